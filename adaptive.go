@@ -0,0 +1,146 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the request timeout derivation from the observed reply latencies.
+
+package iris
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Settings of the adaptive request timeouts. If enabled, requests issued with a
+// zero timeout will derive it from the reply latencies observed recently from
+// the same cluster. Any unset fields (i.e. value of zero) will default to the
+// preset ones.
+type AdaptiveTimeout struct {
+	Percentile float64       // Latency percentile to base the timeout on
+	Factor     float64       // Multiplier applied to the latency percentile
+	Initial    time.Duration // Timeout to use until enough samples are gathered
+	Minimum    time.Duration // Lower bound of the derived timeouts
+	Maximum    time.Duration // Upper bound of the derived timeouts
+	Samples    int           // Number of recent replies to track per cluster
+}
+
+// Default settings of the adaptive request timeouts.
+var defaultAdaptiveTimeout = AdaptiveTimeout{
+	Percentile: 0.99,
+	Factor:     2,
+	Initial:    time.Second,
+	Minimum:    10 * time.Millisecond,
+	Maximum:    time.Minute,
+	Samples:    1000,
+}
+
+// Number of replies needed from a cluster before the derived timeouts are used.
+var adaptiveWarmup = 10
+
+// Per cluster reply latency tracker deriving the request timeouts.
+type latencyTracker struct {
+	config   AdaptiveTimeout           // Settings of the timeout derivation
+	clusters map[string]*latencyWindow // Recent reply latencies of each cluster
+	lock     sync.Mutex                // Protects the latency windows
+}
+
+// Circular buffer of the most recent latencies of a cluster.
+type latencyWindow struct {
+	samples []time.Duration // Recorded latencies
+	next    int             // Position to record the next sample into
+}
+
+// Creates a new latency tracker, or nil if adaptive timeouts are disabled.
+func newLatencyTracker(user *AdaptiveTimeout) *latencyTracker {
+	if user == nil {
+		return nil
+	}
+	// Merge the user settings with the defaults
+	config := *user
+	if config.Percentile <= 0 || config.Percentile > 1 {
+		config.Percentile = defaultAdaptiveTimeout.Percentile
+	}
+	if config.Factor <= 0 {
+		config.Factor = defaultAdaptiveTimeout.Factor
+	}
+	if config.Initial == 0 {
+		config.Initial = defaultAdaptiveTimeout.Initial
+	}
+	if config.Minimum == 0 {
+		config.Minimum = defaultAdaptiveTimeout.Minimum
+	}
+	if config.Maximum == 0 {
+		config.Maximum = defaultAdaptiveTimeout.Maximum
+	}
+	if config.Samples == 0 {
+		config.Samples = defaultAdaptiveTimeout.Samples
+	}
+	return &latencyTracker{
+		config:   config,
+		clusters: make(map[string]*latencyWindow),
+	}
+}
+
+// Records the reply latency of a request to a cluster.
+func (t *latencyTracker) record(cluster string, latency time.Duration) {
+	if t == nil {
+		return
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	win, ok := t.clusters[cluster]
+	if !ok {
+		win = &latencyWindow{samples: make([]time.Duration, 0, t.config.Samples)}
+		t.clusters[cluster] = win
+	}
+	if len(win.samples) < t.config.Samples {
+		win.samples = append(win.samples, latency)
+	} else {
+		win.samples[win.next] = latency
+	}
+	win.next = (win.next + 1) % t.config.Samples
+}
+
+// Records a request to a cluster that timed out, as a latency of at least the
+// applied timeout. Otherwise a latency jump above the derived timeout would make
+// every request time out without ever adding a sample to grow it.
+func (t *latencyTracker) expired(cluster string, elapsed time.Duration, timeout time.Duration) {
+	if elapsed < timeout {
+		elapsed = timeout
+	}
+	t.record(cluster, elapsed)
+}
+
+// Derives a request timeout for a cluster based on its recent reply latencies.
+func (t *latencyTracker) timeout(cluster string) time.Duration {
+	t.lock.Lock()
+	win, ok := t.clusters[cluster]
+	if !ok || len(win.samples) < adaptiveWarmup {
+		t.lock.Unlock()
+		return t.config.Initial
+	}
+	samples := append([]time.Duration{}, win.samples...)
+	t.lock.Unlock()
+
+	// Calculate the requested percentile and bound the derived timeout
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	idx := int(float64(len(samples))*t.config.Percentile+0.5) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= len(samples) {
+		idx = len(samples) - 1
+	}
+	timeout := time.Duration(float64(samples[idx]) * t.config.Factor)
+	if timeout < t.config.Minimum {
+		timeout = t.config.Minimum
+	}
+	if timeout > t.config.Maximum {
+		timeout = t.config.Maximum
+	}
+	return timeout
+}
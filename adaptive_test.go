@@ -0,0 +1,101 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Tests that the adaptive timeouts follow the observed latency distribution.
+func TestAdaptiveTimeout(t *testing.T) {
+	tracker := newLatencyTracker(&AdaptiveTimeout{
+		Percentile: 0.9,
+		Factor:     2,
+		Initial:    500 * time.Millisecond,
+		Samples:    100,
+	})
+	// Until warmed up, the initial timeout should be used
+	if timeout := tracker.timeout("cluster"); timeout != 500*time.Millisecond {
+		t.Fatalf("cold timeout mismatch: have %v, want %v.", timeout, 500*time.Millisecond)
+	}
+	// Feed a uniform distribution and check the derived timeout
+	for i := 1; i <= 100; i++ {
+		tracker.record("cluster", time.Duration(i)*time.Millisecond)
+	}
+	if timeout := tracker.timeout("cluster"); timeout != 180*time.Millisecond {
+		t.Fatalf("derived timeout mismatch: have %v, want %v.", timeout, 180*time.Millisecond)
+	}
+	// Ensure clusters are tracked independently
+	if timeout := tracker.timeout("other"); timeout != 500*time.Millisecond {
+		t.Fatalf("foreign timeout mismatch: have %v, want %v.", timeout, 500*time.Millisecond)
+	}
+	// Overwrite the window with fast replies and check the bounds
+	for i := 0; i < 100; i++ {
+		tracker.record("cluster", time.Microsecond)
+	}
+	if timeout := tracker.timeout("cluster"); timeout != defaultAdaptiveTimeout.Minimum {
+		t.Fatalf("bounded timeout mismatch: have %v, want %v.", timeout, defaultAdaptiveTimeout.Minimum)
+	}
+}
+
+// Service handler replying after an adjustable delay.
+type adaptiveTestHandler struct {
+	delay int64 // Reply delay in nanoseconds (atomic)
+}
+
+func (h *adaptiveTestHandler) Init(conn *Connection) error { return nil }
+func (h *adaptiveTestHandler) HandleBroadcast(msg []byte)  { panic("not implemented") }
+func (h *adaptiveTestHandler) HandleTunnel(tun *Tunnel)    { panic("not implemented") }
+func (h *adaptiveTestHandler) HandleDrop(reason error)     { panic("not implemented") }
+
+func (h *adaptiveTestHandler) HandleRequest(req []byte) ([]byte, error) {
+	time.Sleep(time.Duration(atomic.LoadInt64(&h.delay)))
+	return req, nil
+}
+
+// Tests that the adaptive timeouts grow after a step-up in the latency, even
+// though requests time out with the previously derived ones.
+func TestAdaptiveTimeoutStepUp(t *testing.T) {
+	handler := new(adaptiveTestHandler)
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := ConnectWithOptions(config.relay, &Options{Timeouts: &AdaptiveTimeout{Minimum: 20 * time.Millisecond}})
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Warm up the tracker with fast replies
+	for i := 0; i < adaptiveWarmup; i++ {
+		if _, err := conn.Request(config.cluster, []byte{0x00}, 0); err != nil {
+			t.Fatalf("warmup request %d failed: %v.", i, err)
+		}
+	}
+	if timeout := conn.latency.timeout(config.cluster); timeout != 20*time.Millisecond {
+		t.Fatalf("warm timeout mismatch: have %v, want %v.", timeout, 20*time.Millisecond)
+	}
+	// Step the latency up and ensure requests eventually succeed again
+	atomic.StoreInt64(&handler.delay, int64(100*time.Millisecond))
+	for i := 0; ; i++ {
+		_, err := conn.Request(config.cluster, []byte{0x00}, 0)
+		if err == nil {
+			break
+		}
+		if err != ErrTimeout {
+			t.Fatalf("request %d: failure mismatch: have %v, want %v.", i, err, ErrTimeout)
+		}
+		if i >= 10 {
+			t.Fatalf("timeout stuck at %v.", conn.latency.timeout(config.cluster))
+		}
+	}
+}
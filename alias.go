@@ -0,0 +1,64 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the alias links registering a service under additional names.
+//
+// A relay link is registered into a single cluster, whose members the relay
+// balances the traffic between. To make a service reachable under narrower
+// names too (its zone, its own instance), it opens additional relay links, each
+// registered into a cluster derived from the primary one. The inbound traffic
+// of the aliases is served by the primary connection as if it arrived directly,
+// with the replies and tunnels staying on the link they arrived through.
+
+package iris
+
+import "fmt"
+
+// Opens the alias links requested by the options of a service.
+func (c *Connection) openAliases() error {
+	if c.cluster == "" || c.primary != nil {
+		return nil
+	}
+	var names []string
+	if c.options.Zone != "" {
+		names = append(names, zoneCluster(c.cluster, c.options.Zone))
+	}
+	if c.options.Instance != "" {
+		names = append(names, instanceCluster(c.cluster, c.options.Instance))
+	}
+	// Alias links are plain service links with the same settings, sans extras
+	options := new(Options)
+	*options = *c.options
+	options.Sockets = 0
+
+	for _, name := range names {
+		link, err := newConnection(c.port, name, nil, c.limits, options, c, c.Log.New("alias", name))
+		if err != nil {
+			c.closeAliases()
+			return fmt.Errorf("failed to open alias link %q: %v", name, err)
+		}
+		c.aliases = append(c.aliases, link)
+	}
+	return nil
+}
+
+// Gracefully closes the alias links of a service.
+func (c *Connection) closeAliases() {
+	for _, link := range c.aliases {
+		if err := link.Close(); err != nil {
+			link.Log.Warn("failed to close alias link", "reason", err)
+		}
+	}
+}
+
+// Retrieves the service connection handling the inbound traffic of this relay
+// link: the primary one for aliases, itself otherwise.
+func (c *Connection) service() *Connection {
+	if c.primary != nil {
+		return c.primary
+	}
+	return c
+}
@@ -0,0 +1,60 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the audit trail of the inbound requests. Every request arriving at a
+// service - whether handled, replayed, rejected, expired or dropped - results in
+// a single audit record with the caller's metadata, the payload sizes and the
+// time it took to conclude, handed to the audit callback if one is configured.
+// Health probes are answered by the binding itself and are not audited.
+
+package iris
+
+import "time"
+
+// Results of the audited requests.
+const (
+	AuditSuccess  = "success"  // Handled, replied with a result
+	AuditFailure  = "failure"  // Handled, replied with a failure
+	AuditReplayed = "replayed" // Duplicate, replied with the recorded outcome
+	AuditRejected = "rejected" // Refused without handling (unverified or overloaded)
+	AuditExpired  = "expired"  // Timed out while queued
+	AuditDropped  = "dropped"  // Exceeded the memory allowance of the queue
+)
+
+// Record of a concluded inbound request.
+type AuditRecord struct {
+	Cluster  string        // Cluster the request was served by
+	Meta     *Metadata     // Delivery metadata of the caller
+	Arrived  time.Time     // Time the request arrived
+	Duration time.Duration // Time from arrival until conclusion
+	Request  int           // Size of the request payload
+	Reply    int           // Size of the reply payload (zero if none)
+	Result   string        // Outcome of the request (AuditSuccess, AuditFailure, etc)
+	Fault    string        // Failure replied or the reason of the drop (empty if none)
+}
+
+// Callback receiving the audit records of the inbound requests.
+type AuditHandler func(record *AuditRecord)
+
+// Hands the record of a concluded request to the audit callback, if one was
+// configured. The callback is not invoked inline, to not stall the receiver.
+func (c *Connection) auditRequest(arrived time.Time, request []byte, meta *Metadata, reply []byte, result string, fault string) {
+	handler := c.options.Audit
+	if handler == nil {
+		return
+	}
+	record := &AuditRecord{
+		Cluster:  c.cluster,
+		Meta:     meta,
+		Arrived:  arrived,
+		Duration: since(c.clock, arrived),
+		Request:  len(request),
+		Reply:    len(reply),
+		Result:   result,
+		Fault:    fault,
+	}
+	go handler(record)
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"testing"
+	"time"
+)
+
+// Tests that concluded inbound requests are audited with the caller details.
+func TestAudit(t *testing.T) {
+	records := make(chan *AuditRecord, 4)
+	options := &Options{Audit: func(record *AuditRecord) { records <- record }}
+
+	serv, err := RegisterWithOptions(config.relay, config.cluster, new(requestTestHandler), nil, options)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := ConnectWithOptions(config.relay, &Options{Metadata: true, Name: "auditor"})
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Request(config.cluster, []byte("audited"), time.Second); err != nil {
+		t.Fatalf("request failed: %v.", err)
+	}
+	select {
+	case record := <-records:
+		if record.Result != AuditSuccess || record.Fault != "" {
+			t.Fatalf("result mismatch: have %s/%q, want %s.", record.Result, record.Fault, AuditSuccess)
+		}
+		if record.Cluster != config.cluster || record.Meta.Sender != "auditor" {
+			t.Fatalf("caller mismatch: have %s from %s.", record.Cluster, record.Meta.Sender)
+		}
+		if record.Request != len("audited") || record.Reply != len("audited") {
+			t.Fatalf("size mismatch: have %d/%d, want %d/%d.", record.Request, record.Reply, len("audited"), len("audited"))
+		}
+		if record.Duration < 0 || record.Arrived.IsZero() {
+			t.Fatalf("invalid timing: arrived %v, took %v.", record.Arrived, record.Duration)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("request not audited.")
+	}
+}
+
+// Tests that failed and dropped inbound requests are audited too.
+func TestAuditFailures(t *testing.T) {
+	records := make(chan *AuditRecord, 4)
+	options := &Options{Audit: func(record *AuditRecord) { records <- record }}
+
+	limits := &ServiceLimits{RequestMemory: 8}
+	serv, err := RegisterWithOptions(config.relay, config.cluster, new(requestFailTestHandler), limits, options)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Issue a failing request and an oversized one
+	conn.Request(config.cluster, []byte("failure"), time.Second)
+	conn.Request(config.cluster, []byte("oversized request"), 100*time.Millisecond)
+
+	results := make(map[string]string)
+	for i := 0; i < 2; i++ {
+		select {
+		case record := <-records:
+			results[record.Result] = record.Fault
+		case <-time.After(time.Second):
+			t.Fatalf("request %d not audited.", i)
+		}
+	}
+	for _, want := range []string{AuditFailure, AuditDropped} {
+		if fault, ok := results[want]; !ok || fault == "" {
+			t.Fatalf("%s request not audited with fault: have %v.", want, results)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package barrier
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gopkg.in/project-iris/iris-go.v1"
+)
+
+// Port of the local relay to run the distributed tests against.
+var relay = 55555
+
+// Tests that the local barrier synchronizes phases and collects errors.
+func TestBarrier(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		workers int
+	}{16}
+
+	barrier := New(conf.workers)
+	phase := int32(0)
+	for i := 0; i < conf.workers; i++ {
+		go func(worker int) {
+			atomic.AddInt32(&phase, 1)
+			barrier.Sync()
+
+			if worker%2 == 0 {
+				barrier.Exit(fmt.Errorf("worker #%d failed", worker))
+				return
+			}
+			barrier.Exit(nil)
+		}(i)
+	}
+	if errs := barrier.Wait(); len(errs) != 0 {
+		t.Fatalf("first phase failed: %v.", errs)
+	}
+	if n := atomic.LoadInt32(&phase); n != int32(conf.workers) {
+		t.Fatalf("phase arrivals mismatch: have %d, want %d.", n, conf.workers)
+	}
+	if errs := barrier.Wait(); len(errs) != conf.workers/2 {
+		t.Fatalf("error count mismatch: have %d, want %d.", len(errs), conf.workers/2)
+	}
+}
+
+// Tests that the distributed barrier releases only when all members arrive.
+func TestDistributed(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		members int
+		rounds  int
+	}{5, 3}
+
+	local := New(conf.members)
+	for i := 0; i < conf.members; i++ {
+		go func() {
+			conn, err := iris.Connect(relay)
+			if err != nil {
+				local.Exit(fmt.Errorf("connection failed: %v", err))
+				return
+			}
+			defer conn.Close()
+
+			dist, err := NewDistributed(conn, "test-barrier", conf.members)
+			if err != nil {
+				local.Exit(fmt.Errorf("barrier join failed: %v", err))
+				return
+			}
+			defer dist.Close()
+			local.Sync()
+
+			for j := 0; j < conf.rounds; j++ {
+				if err := dist.Wait(5 * time.Second); err != nil {
+					local.Exit(fmt.Errorf("round %d failed: %v", j, err))
+					return
+				}
+			}
+			local.Exit(nil)
+		}()
+	}
+	if errs := local.Wait(); len(errs) != 0 {
+		t.Fatalf("startup phase failed: %v.", errs)
+	}
+	if errs := local.Wait(); len(errs) != 0 {
+		t.Fatalf("rendezvous phase failed: %v.", errs)
+	}
+}
+
+// Tests that a lone member of a larger barrier times out.
+func TestDistributedTimeout(t *testing.T) {
+	conn, err := iris.Connect(relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	dist, err := NewDistributed(conn, "test-barrier-timeout", 2)
+	if err != nil {
+		t.Fatalf("barrier join failed: %v.", err)
+	}
+	defer dist.Close()
+
+	if err := dist.Wait(250 * time.Millisecond); err != iris.ErrTimeout {
+		t.Fatalf("wait result mismatch: have %v, want %v.", err, iris.ErrTimeout)
+	}
+	// Ensure a late member rendezvous with the timed out one in the same generation
+	late, err := iris.Connect(relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer late.Close()
+
+	other, err := NewDistributed(late, "test-barrier-timeout", 2)
+	if err != nil {
+		t.Fatalf("barrier join failed: %v.", err)
+	}
+	defer other.Close()
+
+	errc := make(chan error, 1)
+	go func() { errc <- other.Wait(5 * time.Second) }()
+
+	if err := dist.Wait(5 * time.Second); err != nil {
+		t.Fatalf("retried wait failed: %v.", err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("late wait failed: %v.", err)
+	}
+}
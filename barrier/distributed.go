@@ -0,0 +1,195 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package barrier
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"gopkg.in/project-iris/iris-go.v1"
+)
+
+// Prefix of the topics used to exchange the barrier arrivals.
+var topicPrefix = "iris-barrier:"
+
+// Interval between re-announcing an arrival, needed to cover the subscription
+// propagation delay and any lost events (publish is best effort).
+var announceInterval = 100 * time.Millisecond
+
+// Barrier synchronizing the members of an Iris cluster: each participant calls
+// Wait, and none returns until size distinct participants reached the same
+// generation of the named barrier.
+type Distributed struct {
+	conn  *iris.Connection // Connection through which to rendezvous
+	topic string           // Topic to exchange the arrivals on
+	size  int              // Number of participants to wait for
+	self  string           // Unique id of the local participant
+
+	gen    uint64                         // Generation the local member is waiting in
+	arrive map[uint64]map[string]struct{} // Participants arrived in each generation
+	done   map[uint64]struct{}            // Generations already released
+	sign   chan struct{}                  // Arrival signaler
+	lock   sync.Mutex                     // Protects the arrival bookkeeping
+}
+
+// Joins the named distributed barrier, which will release its waiters when size
+// participants arrive.
+func NewDistributed(conn *iris.Connection, name string, size int) (*Distributed, error) {
+	// Sanity check on the arguments
+	if len(name) == 0 {
+		return nil, errors.New("empty barrier name")
+	}
+	if size < 1 {
+		return nil, fmt.Errorf("invalid barrier size %d < 1", size)
+	}
+	// Generate a unique id for the local participant
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	d := &Distributed{
+		conn:   conn,
+		topic:  topicPrefix + name,
+		size:   size,
+		self:   hex.EncodeToString(id),
+		arrive: make(map[uint64]map[string]struct{}),
+		done:   make(map[uint64]struct{}),
+		sign:   make(chan struct{}, 1),
+	}
+	if err := conn.Subscribe(d.topic, eventHandler(d.handleEvent), nil); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Blocks until all participants reach the barrier or the timeout expires. On a
+// timeout the local arrival is withdrawn, so the next Wait retries the same
+// generation in step with the other participants.
+//
+// Infinite blocking is supported by setting the timeout to zero (0).
+func (d *Distributed) Wait(timeout time.Duration) error {
+	d.lock.Lock()
+	d.gen++
+	gen := d.gen
+	d.lock.Unlock()
+
+	// Create the timeout signaler
+	var after <-chan time.Time
+	if timeout != 0 {
+		after = time.After(timeout)
+	}
+	arrival := []byte(fmt.Sprintf("arrive %d %s", gen, d.self))
+	ticker := time.NewTicker(announceInterval)
+	defer ticker.Stop()
+
+	for {
+		// Announce the local arrival and check for release
+		if err := d.conn.Publish(d.topic, arrival); err != nil {
+			return err
+		}
+		if released, announce := d.released(gen); released {
+			if announce {
+				if err := d.conn.Publish(d.topic, []byte(fmt.Sprintf("release %d", gen))); err != nil {
+					d.conn.Log.Warn("failed to announce barrier release", "topic", d.topic, "generation", gen, "reason", err)
+				}
+			}
+			return nil
+		}
+		// Wait for further arrivals or an announcement retry
+		select {
+		case <-after:
+			return d.abort(gen)
+		case <-d.sign:
+		case <-ticker.C:
+		}
+	}
+}
+
+// Leaves the distributed barrier.
+func (d *Distributed) Close() error {
+	return d.conn.Unsubscribe(d.topic)
+}
+
+// Checks whether a generation was released, and whether it just happened, in
+// which case the release should be announced.
+func (d *Distributed) released(gen uint64) (bool, bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if _, ok := d.done[gen]; ok {
+		return true, false
+	}
+	if len(d.arrive[gen]) >= d.size {
+		d.done[gen] = struct{}{}
+		return true, true
+	}
+	return false, false
+}
+
+// Withdraws the local arrival from a timed out generation and steps back into
+// the previous one, unless the generation was released meanwhile.
+func (d *Distributed) abort(gen uint64) error {
+	d.lock.Lock()
+	if _, ok := d.done[gen]; ok {
+		d.lock.Unlock()
+		return nil
+	}
+	delete(d.arrive[gen], d.self)
+	d.gen--
+	d.lock.Unlock()
+
+	if err := d.conn.Publish(d.topic, []byte(fmt.Sprintf("leave %d %s", gen, d.self))); err != nil {
+		return err
+	}
+	return iris.ErrTimeout
+}
+
+// Topic handler forwarding the barrier events into a callback.
+type eventHandler func(event []byte)
+
+func (h eventHandler) HandleEvent(event []byte) { h(event) }
+
+// Callback invoked whenever a barrier arrival, withdrawal or release is announced.
+func (d *Distributed) handleEvent(event []byte) {
+	var (
+		kind string
+		gen  uint64
+		id   string
+	)
+	if n, _ := fmt.Sscanf(string(event), "%s %d %s", &kind, &gen, &id); n < 2 {
+		return
+	}
+	d.lock.Lock()
+	switch kind {
+	case "arrive":
+		if _, ok := d.arrive[gen]; !ok {
+			d.arrive[gen] = make(map[string]struct{})
+		}
+		d.arrive[gen][id] = struct{}{}
+	case "leave":
+		delete(d.arrive[gen], id)
+	case "release":
+		d.done[gen] = struct{}{}
+	}
+	// Drop the bookkeeping of long finished generations
+	for old := range d.arrive {
+		if old+1 < d.gen {
+			delete(d.arrive, old)
+			delete(d.done, old)
+		}
+	}
+	d.lock.Unlock()
+
+	select {
+	case d.sign <- struct{}{}:
+	default:
+	}
+}
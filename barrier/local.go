@@ -0,0 +1,82 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Package barrier contains rendezvous utilities to synchronize a batch of
+// goroutines, either within a single process or across all the members of an
+// Iris cluster.
+package barrier
+
+import "sync"
+
+// Simple barrier to support synchronizing a batch of goroutines in phases. The
+// participants call Sync or Exit at the end of each phase, while a coordinator
+// collects the results via Wait and permits the next phase to begin.
+type Barrier struct {
+	pend sync.WaitGroup
+	hold sync.WaitGroup
+	pass sync.WaitGroup
+	cont sync.WaitGroup
+	errc chan error
+}
+
+// Creates a new barrier for size participating goroutines.
+func New(size int) *Barrier {
+	b := &Barrier{
+		errc: make(chan error, size),
+	}
+	b.pend.Add(size)
+	b.hold.Add(1)
+	return b
+}
+
+// Syncs the goroutines up to begin the next phase.
+func (b *Barrier) Sync() {
+	b.pass.Add(1)
+	b.pend.Done()
+	b.hold.Wait()
+	b.pend.Add(1)
+	b.pass.Done()
+	b.cont.Wait()
+}
+
+// Removes one goroutine from the barrier after the phase, reporting any error
+// that occurred during it.
+func (b *Barrier) Exit(err error) {
+	if err != nil {
+		b.errc <- err
+	}
+	b.pass.Add(1)
+	b.pend.Done()
+	b.hold.Wait()
+	b.pass.Done()
+}
+
+// Waits for all goroutines to reach the barrier and permits continuation. The
+// errors reported by the exiting goroutines during the phase are returned.
+func (b *Barrier) Wait() []error {
+	// Wait for all the goroutines to arrive
+	b.pend.Wait()
+	b.cont.Add(1)
+
+	// Collect all the occurred errors
+	errs := []error{}
+	for done := false; !done; {
+		select {
+		case err := <-b.errc:
+			errs = append(errs, err)
+		default:
+			done = true
+		}
+	}
+	// Permit all goroutines to continue
+	b.hold.Done()
+	b.pass.Wait()
+	b.hold.Add(1)
+	b.cont.Done()
+
+	// Report the results
+	return errs
+}
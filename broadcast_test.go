@@ -13,7 +13,7 @@ import (
 	"testing"
 	"time"
 
-	"github.com/project-iris/iris/pool"
+	"gopkg.in/project-iris/iris-go.v1/internal/pool"
 )
 
 // Service handler for the broadcast tests.
@@ -340,3 +340,95 @@ func benchmarkBroadcastThroughput(threads int, b *testing.B) {
 	// Stop the timer (don't measure deferred cleanup)
 	b.StopTimer()
 }
+
+// Service handler declaring the ordering key of the broadcasts as their first
+// byte, recording the arrival order and concurrency of each key.
+type broadcastKeyTestHandler struct {
+	arrived map[byte][]byte
+	running map[byte]bool
+	overlap bool
+	total   int
+	done    chan struct{}
+	lock    sync.Mutex
+}
+
+func (b *broadcastKeyTestHandler) Init(conn *Connection) error              { return nil }
+func (b *broadcastKeyTestHandler) HandleRequest(req []byte) ([]byte, error) { panic("not implemented") }
+func (b *broadcastKeyTestHandler) HandleTunnel(tun *Tunnel)                 { panic("not implemented") }
+func (b *broadcastKeyTestHandler) HandleDrop(reason error)                  { panic("not implemented") }
+
+func (b *broadcastKeyTestHandler) BroadcastKey(msg []byte, meta *Metadata) string {
+	return string(msg[:1])
+}
+
+func (b *broadcastKeyTestHandler) HandleBroadcast(msg []byte) {
+	b.lock.Lock()
+	if b.running[msg[0]] {
+		b.overlap = true
+	}
+	b.running[msg[0]] = true
+	b.lock.Unlock()
+
+	time.Sleep(time.Duration(msg[1]%3) * time.Millisecond)
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.running[msg[0]] = false
+	b.arrived[msg[0]] = append(b.arrived[msg[0]], msg[1])
+	if b.total--; b.total == 0 {
+		close(b.done)
+	}
+}
+
+// Tests that broadcasts with the same key are handled one by one, in order.
+func TestBroadcastKeyed(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		keys     int
+		messages int
+	}{4, 50}
+
+	handler := &broadcastKeyTestHandler{
+		arrived: make(map[byte][]byte),
+		running: make(map[byte]bool),
+		total:   conf.keys * conf.messages,
+		done:    make(chan struct{}),
+	}
+	serv, err := Register(config.relay, config.cluster, handler, &ServiceLimits{BroadcastThreads: 8})
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Interleave the broadcasts of the different keys
+	for i := 0; i < conf.messages; i++ {
+		for key := 0; key < conf.keys; key++ {
+			if err := conn.Broadcast(config.cluster, []byte{byte(key), byte(i)}); err != nil {
+				t.Fatalf("broadcast failed: %v.", err)
+			}
+		}
+	}
+	select {
+	case <-handler.done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("broadcasts not delivered.")
+	}
+	// Verify the per key ordering and exclusivity
+	if handler.overlap {
+		t.Fatalf("broadcasts with the same key ran concurrently.")
+	}
+	for key, messages := range handler.arrived {
+		for i, msg := range messages {
+			if int(msg) != i {
+				t.Fatalf("key %d: broadcast #%d out of order: have %d.", key, i, msg)
+			}
+		}
+	}
+}
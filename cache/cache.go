@@ -0,0 +1,100 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Package cache contains a distributed key-value cache built on top of an Iris
+// service cluster.
+//
+// Since Iris load balances the requests between the members of a cluster, each
+// cache node registers into its own node specific cluster besides the cache
+// wide one. Clients map every key onto one of the nodes via consistent hashing,
+// and direct its retrievals, insertions and removals to that node alone.
+// Invalidations are broadcast to the cache wide cluster instead, purging the
+// key from every node, e.g. to cover stale copies left behind by membership
+// changes.
+//
+// The set of nodes is configured statically on the clients; nodes missing from
+// the fabric simply fail the operations on their keys.
+package cache
+
+import (
+	"errors"
+	"time"
+
+	"gopkg.in/project-iris/iris-go.v1"
+)
+
+// Client of a distributed cache.
+type Client struct {
+	conn    *iris.Connection // Connection to reach the cache nodes through
+	name    string           // Name of the cache (cluster of all nodes)
+	ring    *ring            // Consistent hash ring of the nodes
+	timeout time.Duration    // Timeout of the node requests
+}
+
+// Creates a client of the named cache, spread over the given nodes.
+func NewClient(conn *iris.Connection, name string, nodes []string, timeout time.Duration) (*Client, error) {
+	// Sanity check on the arguments
+	if len(name) == 0 {
+		return nil, errors.New("empty cache name")
+	}
+	if len(nodes) == 0 {
+		return nil, errors.New("no cache nodes")
+	}
+	for _, node := range nodes {
+		if len(node) == 0 {
+			return nil, errors.New("empty node name")
+		}
+	}
+	return &Client{
+		conn:    conn,
+		name:    name,
+		ring:    newRing(nodes),
+		timeout: timeout,
+	}, nil
+}
+
+// Retrieves a cached value from its owner node, along with whether it was found.
+func (c *Client) Get(key string) ([]byte, bool, error) {
+	reply, err := c.request(&command{op: opGet, key: key})
+	if err != nil {
+		return nil, false, err
+	}
+	if len(reply) == 0 {
+		return nil, false, errMalformed
+	}
+	if reply[0] != replyHit {
+		return nil, false, nil
+	}
+	return reply[1:], true, nil
+}
+
+// Caches a value on its owner node, expiring it after ttl if non-zero.
+func (c *Client) Set(key string, value []byte, ttl time.Duration) error {
+	_, err := c.request(&command{op: opSet, key: key, value: value, ttl: ttl})
+	return err
+}
+
+// Removes a value from its owner node.
+func (c *Client) Delete(key string) error {
+	_, err := c.request(&command{op: opDelete, key: key})
+	return err
+}
+
+// Purges a value from all the cache nodes. The broadcast is best effort, so
+// there is no confirmation of its delivery.
+func (c *Client) Invalidate(key string) error {
+	return c.conn.Broadcast(c.name, encode(&command{op: opInvalidate, key: key}))
+}
+
+// Sends a cache operation to the node owning its key.
+func (c *Client) request(cmd *command) ([]byte, error) {
+	return c.conn.Request(nodeCluster(c.name, c.ring.owner(cmd.key)), encode(cmd), c.timeout)
+}
+
+// Assembles the cluster name of a specific cache node.
+func nodeCluster(name string, node string) string {
+	return name + "." + node
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package cache
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"gopkg.in/project-iris/iris-go.v1"
+)
+
+// Port of the local relay to run the distributed tests against.
+var relay = 55555
+
+// Tests that the hash ring spreads the keys between the nodes, and that adding
+// a node only remaps the keys it takes over.
+func TestRing(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		keys int
+	}{10000}
+
+	before := newRing([]string{"a", "b", "c"})
+	after := newRing([]string{"a", "b", "c", "d"})
+
+	owned := make(map[string]int)
+	for i := 0; i < conf.keys; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		owner := before.owner(key)
+		owned[owner]++
+
+		if moved := after.owner(key); moved != owner && moved != "d" {
+			t.Fatalf("key %s remapped between old nodes: %s -> %s.", key, owner, moved)
+		}
+	}
+	for node, count := range owned {
+		if count < conf.keys/6 {
+			t.Fatalf("node %s underloaded: %d of %d keys.", node, count, conf.keys)
+		}
+	}
+}
+
+// Tests the cache operations against a few live nodes.
+func TestCache(t *testing.T) {
+	nodes := []string{"n1", "n2", "n3"}
+	for _, node := range nodes {
+		server, err := NewServer(relay, "test-cache", node)
+		if err != nil {
+			t.Fatalf("node %s start failed: %v.", node, err)
+		}
+		defer server.Close()
+	}
+	conn, err := iris.Connect(relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	cache, err := NewClient(conn, "test-cache", nodes, time.Second)
+	if err != nil {
+		t.Fatalf("client creation failed: %v.", err)
+	}
+	// Insert a batch of values and read them back
+	for i := 0; i < 32; i++ {
+		if err := cache.Set(fmt.Sprintf("key-%d", i), []byte(fmt.Sprintf("value-%d", i)), 0); err != nil {
+			t.Fatalf("set %d failed: %v.", i, err)
+		}
+	}
+	for i := 0; i < 32; i++ {
+		value, ok, err := cache.Get(fmt.Sprintf("key-%d", i))
+		if err != nil || !ok {
+			t.Fatalf("get %d failed: ok %v, err %v.", i, ok, err)
+		}
+		if want := []byte(fmt.Sprintf("value-%d", i)); !bytes.Equal(value, want) {
+			t.Fatalf("value %d mismatch: have %s, want %s.", i, value, want)
+		}
+	}
+	// Remove and invalidate values, and let others expire
+	if err := cache.Delete("key-0"); err != nil {
+		t.Fatalf("delete failed: %v.", err)
+	}
+	if err := cache.Invalidate("key-1"); err != nil {
+		t.Fatalf("invalidate failed: %v.", err)
+	}
+	if err := cache.Set("short", []byte("lived"), 50*time.Millisecond); err != nil {
+		t.Fatalf("set failed: %v.", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	for _, key := range []string{"key-0", "key-1", "short"} {
+		if _, ok, err := cache.Get(key); err != nil || ok {
+			t.Fatalf("key %s still cached: ok %v, err %v.", key, ok, err)
+		}
+	}
+	if _, ok, _ := cache.Get("key-2"); !ok {
+		t.Fatalf("unrelated key dropped.")
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package cache
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// Number of points each node occupies on the hash ring, smoothing the key
+// distribution between the nodes.
+var ringReplicas = 128
+
+// Consistent hash ring mapping keys onto cache nodes, so that adding or removing
+// a node only remaps the keys of its neighbours.
+type ring struct {
+	points []uint32          // Sorted hash points of all the nodes
+	owners map[uint32]string // Node owning each hash point
+}
+
+// Creates a hash ring of the given nodes.
+func newRing(nodes []string) *ring {
+	r := &ring{
+		points: make([]uint32, 0, len(nodes)*ringReplicas),
+		owners: make(map[uint32]string, len(nodes)*ringReplicas),
+	}
+	for _, node := range nodes {
+		for i := 0; i < ringReplicas; i++ {
+			point := hash(node + "#" + strconv.Itoa(i))
+			if _, ok := r.owners[point]; ok {
+				continue // Collision, first node keeps the point
+			}
+			r.points = append(r.points, point)
+			r.owners[point] = node
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return r
+}
+
+// Retrieves the node owning a key: the first one clockwise from its hash.
+func (r *ring) owner(key string) string {
+	point := hash(key)
+	idx := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= point })
+	if idx == len(r.points) {
+		idx = 0
+	}
+	return r.owners[r.points[idx]]
+}
+
+// Hashes a key or node point onto the ring.
+func hash(data string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(data))
+	return h.Sum32()
+}
@@ -0,0 +1,159 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package cache
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"gopkg.in/project-iris/iris-go.v1"
+)
+
+// Cached value along with its expiration.
+type entry struct {
+	value  []byte    // Cached payload
+	expire time.Time // Expiration time of the value (zero = never)
+}
+
+// Cache node serving its share of the keys, and dropping the invalidated ones.
+type Server struct {
+	owner *iris.Service // Service answering the requests of the owned keys
+	group *iris.Service // Service receiving the invalidation broadcasts
+
+	entries map[string]*entry // Values cached by the node
+	lock    sync.RWMutex      // Protects the cached values
+}
+
+// Starts a cache node, registering it into the node specific cluster of the
+// cache (requests of the owned keys) and the cache wide one (invalidations).
+func NewServer(port int, name string, node string) (*Server, error) {
+	// Sanity check on the arguments
+	if len(name) == 0 {
+		return nil, errors.New("empty cache name")
+	}
+	if len(node) == 0 {
+		return nil, errors.New("empty node name")
+	}
+	s := &Server{
+		entries: make(map[string]*entry),
+	}
+	var err error
+	if s.owner, err = iris.Register(port, nodeCluster(name, node), &ownerHandler{s}, nil); err != nil {
+		return nil, err
+	}
+	if s.group, err = iris.Register(port, name, &groupHandler{s}, nil); err != nil {
+		s.owner.Unregister()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Unregisters the cache node, dropping all its cached values.
+func (s *Server) Close() error {
+	err := s.group.Unregister()
+	if oerr := s.owner.Unregister(); err == nil {
+		err = oerr
+	}
+	return err
+}
+
+// Retrieves a live cached value.
+func (s *Server) get(key string) ([]byte, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	entry, ok := s.entries[key]
+	if !ok || (!entry.expire.IsZero() && time.Now().After(entry.expire)) {
+		return nil, false
+	}
+	return entry.value, true
+}
+
+// Inserts a value into the cache, expiring after ttl if non-zero.
+func (s *Server) set(key string, value []byte, ttl time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	entry := &entry{value: value}
+	if ttl > 0 {
+		entry.expire = time.Now().Add(ttl)
+	}
+	s.entries[key] = entry
+	s.sweep()
+}
+
+// Removes a value from the cache.
+func (s *Server) delete(key string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.entries, key)
+}
+
+// Drops the expired values whenever the cache grows to a power of two size, so
+// the cost of the sweeps is amortized over the insertions. The lock is assumed
+// held.
+func (s *Server) sweep() {
+	if len(s.entries) < 1024 || len(s.entries)&(len(s.entries)-1) != 0 {
+		return
+	}
+	now := time.Now()
+	for key, entry := range s.entries {
+		if !entry.expire.IsZero() && now.After(entry.expire) {
+			delete(s.entries, key)
+		}
+	}
+}
+
+// Service handler executing the requests of the owned keys.
+type ownerHandler struct {
+	server *Server
+}
+
+func (h *ownerHandler) Init(conn *iris.Connection) error { return nil }
+func (h *ownerHandler) HandleBroadcast(msg []byte)       {}
+func (h *ownerHandler) HandleTunnel(tun *iris.Tunnel)    { tun.Close() }
+func (h *ownerHandler) HandleDrop(reason error)          {}
+
+func (h *ownerHandler) HandleRequest(req []byte) ([]byte, error) {
+	cmd, err := decode(req)
+	if err != nil {
+		return nil, err
+	}
+	switch cmd.op {
+	case opGet:
+		if value, ok := h.server.get(cmd.key); ok {
+			return append([]byte{replyHit}, value...), nil
+		}
+		return []byte{replyMiss}, nil
+
+	case opSet:
+		h.server.set(cmd.key, cmd.value, cmd.ttl)
+	case opDelete:
+		h.server.delete(cmd.key)
+	default:
+		return nil, errMalformed
+	}
+	return []byte{replyHit}, nil
+}
+
+// Service handler executing the invalidation broadcasts.
+type groupHandler struct {
+	server *Server
+}
+
+func (h *groupHandler) Init(conn *iris.Connection) error         { return nil }
+func (h *groupHandler) HandleRequest(req []byte) ([]byte, error) { return nil, errMalformed }
+func (h *groupHandler) HandleTunnel(tun *iris.Tunnel)            { tun.Close() }
+func (h *groupHandler) HandleDrop(reason error)                  {}
+
+func (h *groupHandler) HandleBroadcast(msg []byte) {
+	if cmd, err := decode(msg); err == nil && cmd.op == opInvalidate {
+		h.server.delete(cmd.key)
+	}
+}
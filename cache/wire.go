@@ -0,0 +1,75 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package cache
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// Cache operations carried by the requests and broadcasts.
+const (
+	opGet        byte = 0x01 // Request: value retrieval
+	opSet        byte = 0x02 // Request: value insertion
+	opDelete     byte = 0x03 // Request: value removal from the owner
+	opInvalidate byte = 0x04 // Broadcast: value removal from all nodes
+)
+
+// Reply flags of the retrievals.
+const (
+	replyMiss byte = 0x00 // Key not cached
+	replyHit  byte = 0x01 // Key cached, value follows
+)
+
+// Returned if a cache message cannot be decoded.
+var errMalformed = errors.New("malformed cache message")
+
+// Cache operation decoded from a request or broadcast.
+type command struct {
+	op    byte          // Operation to execute
+	key   string        // Key to operate on
+	value []byte        // Value to insert (set only)
+	ttl   time.Duration // Lifetime of the value (set only, zero = forever)
+}
+
+// Serializes a cache operation.
+func encode(cmd *command) []byte {
+	buf := make([]byte, 1, 1+3*binary.MaxVarintLen64+len(cmd.key)+len(cmd.value))
+	buf[0] = cmd.op
+	buf = binary.AppendUvarint(buf, uint64(len(cmd.key)))
+	buf = append(buf, cmd.key...)
+	if cmd.op == opSet {
+		buf = binary.AppendUvarint(buf, uint64(cmd.ttl/time.Millisecond))
+		buf = append(buf, cmd.value...)
+	}
+	return buf
+}
+
+// Deserializes a cache operation.
+func decode(data []byte) (*command, error) {
+	if len(data) < 1 {
+		return nil, errMalformed
+	}
+	cmd := &command{op: data[0]}
+	data = data[1:]
+
+	size, n := binary.Uvarint(data)
+	if n <= 0 || uint64(len(data)-n) < size {
+		return nil, errMalformed
+	}
+	cmd.key, data = string(data[n:n+int(size)]), data[n+int(size):]
+
+	if cmd.op == opSet {
+		ttl, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, errMalformed
+		}
+		cmd.ttl, cmd.value = time.Duration(ttl)*time.Millisecond, data[n:]
+	}
+	return cmd, nil
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the propagation of request cancellations to the serving members.
+//
+// The relay protocol has no means to withdraw a request, nor to address the
+// member serving it. Requests issued with a cancellable context thus carry a
+// random cancel token, and should the caller give up on them, the token is
+// broadcast to the target cluster. As the token travels in the envelope headers,
+// it is only attached if the connection already envelopes its messages (i.e.
+// metadata, encryption or signing is enabled), so that services unaware of the
+// envelopes (older bindings, other languages) keep receiving raw payloads. The member serving the request cancels the
+// context of its handler, whereas all others ignore the notification. Timeouts
+// need no notification: the serving member knows the caller's deadline.
+
+package iris
+
+import (
+	"context"
+	"time"
+)
+
+// Notifies the members of a cluster that the request with the given cancel token
+// was abandoned by its caller. Note, the notice is a broadcast, so each abandoned
+// request costs a message to every member of the target cluster.
+func (c *Connection) cancelRemote(cluster string, token string) {
+	notice, err := c.envelope(cluster, []byte{0x00}, map[string]string{headerCancel: token})
+	if err == nil {
+		err = c.sendBroadcast(cluster, notice)
+	}
+	if err != nil {
+		c.Log.Debug("failed to propagate request cancellation", "cluster", cluster, "reason", err)
+	}
+}
+
+// Derives the handler context of an inbound request, cancelled if the caller
+// abandons it (matched by the cancel token, if any), or when its timeout runs
+// out. The returned release function must be called once the request concludes
+// to stop tracking the token, stop the timer and cancel the context, similarly
+// to how net/http treats the contexts of served requests.
+func (c *Connection) requestContext(token string, timeout time.Duration) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(c.ctx)
+	expiry := c.clock.AfterFunc(timeout, func() { cancel(ErrTimeout) })
+
+	if token != "" {
+		c.reqCancelLock.Lock()
+		c.reqCancels[token] = cancel
+		c.reqCancelLock.Unlock()
+	}
+	return ctx, func() {
+		if token != "" {
+			c.reqCancelLock.Lock()
+			delete(c.reqCancels, token)
+			c.reqCancelLock.Unlock()
+		}
+		expiry.Stop()
+		cancel(context.Canceled)
+	}
+}
+
+// Cancels the handler context of an inbound request abandoned by its caller, if
+// it is being served by this member.
+func (c *Connection) cancelRequest(token string) {
+	c.reqCancelLock.Lock()
+	cancel, ok := c.reqCancels[token]
+	c.reqCancelLock.Unlock()
+
+	if ok {
+		c.Log.Debug("cancelling abandoned request", "token", token)
+		cancel(context.Canceled)
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+// Context carrying service handler blocking until its request is abandoned.
+type cancelTestHandler struct {
+	causes chan error
+}
+
+func (h *cancelTestHandler) Init(ctx context.Context, conn *Connection) error { return nil }
+
+func (h *cancelTestHandler) HandleBroadcast(ctx context.Context, message []byte, meta *Metadata) {
+	panic("not implemented")
+}
+
+func (h *cancelTestHandler) HandleRequest(ctx context.Context, request []byte, meta *Metadata) ([]byte, error) {
+	select {
+	case <-ctx.Done():
+		h.causes <- context.Cause(ctx)
+	case <-time.After(5 * time.Second):
+		h.causes <- nil
+	}
+	return request, nil
+}
+
+func (h *cancelTestHandler) HandleTunnel(ctx context.Context, tunnel *Tunnel, meta *Metadata) {
+	panic("not implemented")
+}
+
+func (h *cancelTestHandler) HandleDrop(ctx context.Context, reason error) {
+	panic("not implemented")
+}
+
+// Tests that abandoned requests of enveloping connections cancel the context of
+// their remote handlers.
+func TestRequestCancel(t *testing.T) {
+	handler := &cancelTestHandler{
+		causes: make(chan error, 1),
+	}
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := ConnectWithOptions(config.relay, &Options{Metadata: true})
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Cancel a request mid-flight and ensure the handler is notified
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	if _, err := conn.RequestContext(ctx, config.cluster, []byte{0x00}, 5*time.Second); err != context.Canceled {
+		t.Fatalf("request error mismatch: have %v, want %v.", err, context.Canceled)
+	}
+	select {
+	case cause := <-handler.causes:
+		if cause != context.Canceled {
+			t.Fatalf("cancellation cause mismatch: have %v, want %v.", cause, context.Canceled)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("handler not cancelled.")
+	}
+	// Time out a request and ensure the handler is notified (the reply sent upon
+	// the cancellation races with the caller's own timeout, so ignore the result)
+	conn.Request(config.cluster, []byte{0x00}, 100*time.Millisecond)
+
+	select {
+	case cause := <-handler.causes:
+		if cause != ErrTimeout {
+			t.Fatalf("cancellation cause mismatch: have %v, want %v.", cause, ErrTimeout)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("handler not cancelled.")
+	}
+}
+
+// Tests that cancellable requests of plain connections don't get enveloped just
+// to carry a cancel token, keeping interoperability with envelope unaware peers.
+func TestRequestCancelPlain(t *testing.T) {
+	handler := &metadataTestHandler{
+		metas: make(chan *Metadata, 1),
+	}
+	serv, err := RegisterWithOptions(config.relay, config.cluster, handler, nil, &Options{RawPayloads: true})
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := ConnectWithOptions(config.relay, &Options{RawPayloads: true})
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reply, err := conn.RequestContext(ctx, config.cluster, []byte{0x01}, time.Second)
+	if err != nil {
+		t.Fatalf("request failed: %v.", err)
+	}
+	if !bytes.Equal(reply, []byte{0x01}) {
+		t.Fatalf("request enveloped: have %x, want %x.", reply, []byte{0x01})
+	}
+}
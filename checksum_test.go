@@ -0,0 +1,106 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gopkg.in/project-iris/iris-go.v1/relaywire"
+	"gopkg.in/project-iris/iris-go.v1/sim"
+)
+
+// Relay link flipping a bit of a marker on the inbound stream once armed.
+type corruptingConn struct {
+	net.Conn
+	marker []byte
+	armed  int32
+}
+
+func (c *corruptingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if atomic.LoadInt32(&c.armed) == 1 {
+		if idx := bytes.Index(b[:n], c.marker); idx >= 0 {
+			b[idx] ^= 0x01
+		}
+	}
+	return n, err
+}
+
+// Service handler reporting the drop of its connection.
+type checksumTestHandler struct {
+	drops chan error
+}
+
+func (h *checksumTestHandler) Init(conn *Connection) error              { return nil }
+func (h *checksumTestHandler) HandleBroadcast(msg []byte)               {}
+func (h *checksumTestHandler) HandleRequest(req []byte) ([]byte, error) { return req, nil }
+func (h *checksumTestHandler) HandleTunnel(tun *Tunnel)                 { tun.Close() }
+func (h *checksumTestHandler) HandleDrop(reason error)                  { h.drops <- reason }
+
+// Tests that checksums are negotiated on request, and that corrupted frames are
+// detected and reported as the connection drop reason.
+func TestChecksums(t *testing.T) {
+	relay, err := sim.NewRelay(nil)
+	if err != nil {
+		t.Fatalf("failed to start relay: %v.", err)
+	}
+	defer relay.Close()
+
+	// Register a service through a link corrupting the inbound stream
+	var link *corruptingConn
+	dialer := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := new(net.Dialer).DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		link = &corruptingConn{Conn: conn, marker: []byte("corrupt me")}
+		return link, nil
+	}
+	handler := &checksumTestHandler{drops: make(chan error, 1)}
+	serv, err := RegisterWithOptions(relay.Port(), "checksum", handler, nil, &Options{Dialer: dialer, Checksums: true})
+	if err != nil {
+		t.Fatalf("failed to register service: %v.", err)
+	}
+	defer serv.Unregister()
+
+	if !serv.conn.supports(relaywire.FeatureCRC) {
+		t.Fatalf("feature mismatch: have %v, want checksums agreed.", serv.conn.Features())
+	}
+	// Ensure intact frames pass the verification
+	conn, err := Connect(relay.Port())
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	if reply, err := conn.Request("checksum", []byte("intact"), time.Second); err != nil || string(reply) != "intact" {
+		t.Fatalf("request failed: have %q/%v, want %q/nil.", reply, err, "intact")
+	}
+	// Corrupt a delivery and ensure the service drops with a checksum failure
+	atomic.StoreInt32(&link.armed, 1)
+	if err := conn.Broadcast("checksum", []byte("corrupt me")); err != nil {
+		t.Fatalf("broadcast failed: %v.", err)
+	}
+	select {
+	case reason := <-handler.drops:
+		var cerr *ChecksumError
+		if !errors.As(reason, &cerr) {
+			t.Fatalf("drop reason mismatch: have %v, want checksum error.", reason)
+		}
+		if cerr.Opcode != relaywire.OpBroadcast {
+			t.Fatalf("corrupted opcode mismatch: have %#x, want %#x.", cerr.Opcode, relaywire.OpBroadcast)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("corruption not detected.")
+	}
+}
@@ -0,0 +1,26 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the pluggable end-to-end encryption of the message payloads.
+
+package iris
+
+// Pluggable end-to-end encryption of broadcast, request, reply and publish
+// payloads. The id of the key used is carried in the message envelope, so the
+// receiving side can pick the matching one (e.g. during key rotation).
+//
+// Encrypted requests are replied to with encrypted replies, sealed by the
+// service's own cipher for its own cluster. Messages arriving encrypted to a
+// connection without a cipher, or failing to decrypt, are dropped (requests are
+// failed with the decryption error).
+type Cipher interface {
+	// Encrypts a payload destined to the given cluster or topic, returning the
+	// id of the key used and the ciphertext.
+	Encrypt(target string, plaintext []byte) (key string, ciphertext []byte, err error)
+
+	// Decrypts a payload sealed with the key of the given id.
+	Decrypt(key string, ciphertext []byte) ([]byte, error)
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+)
+
+// AES-GCM cipher with a single key for the encryption tests.
+type cipherTestCipher struct {
+	aead cipher.AEAD
+}
+
+func newCipherTestCipher() *cipherTestCipher {
+	block, _ := aes.NewCipher(make([]byte, 32))
+	aead, _ := cipher.NewGCM(block)
+	return &cipherTestCipher{aead}
+}
+
+func (c *cipherTestCipher) Encrypt(target string, plain []byte) (string, []byte, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", nil, err
+	}
+	return "test-key", c.aead.Seal(nonce, nonce, plain, nil), nil
+}
+
+func (c *cipherTestCipher) Decrypt(key string, sealed []byte) ([]byte, error) {
+	if key != "test-key" {
+		return nil, errors.New("unknown key")
+	}
+	size := c.aead.NonceSize()
+	if len(sealed) < size {
+		return nil, errors.New("ciphertext too short")
+	}
+	return c.aead.Open(nil, sealed[:size], sealed[size:], nil)
+}
+
+// Tests that encrypted messages are transparently decrypted on arrival.
+func TestCipher(t *testing.T) {
+	// Register a new encrypting service to the relay and subscribe to a topic
+	handler := &metadataTestHandler{
+		metas: make(chan *Metadata, 3),
+	}
+	options := &Options{Cipher: newCipherTestCipher()}
+
+	serv, err := RegisterWithOptions(config.relay, config.cluster, handler, nil, options)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	if err := handler.conn.Subscribe(config.topic, handler, nil); err != nil {
+		t.Fatalf("subscription failed: %v.", err)
+	}
+	defer handler.conn.Unsubscribe(config.topic)
+	time.Sleep(100 * time.Millisecond)
+
+	// Connect an encrypting client and send each message type
+	conn, err := ConnectWithOptions(config.relay, options)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	if err := conn.Broadcast(config.cluster, []byte("broadcast")); err != nil {
+		t.Fatalf("broadcast failed: %v.", err)
+	}
+	if reply, err := conn.Request(config.cluster, []byte("request"), time.Second); err != nil {
+		t.Fatalf("request failed: %v.", err)
+	} else if !bytes.Equal(reply, []byte("request")) {
+		t.Fatalf("reply mismatch: have %q, want %q.", reply, "request")
+	}
+	if err := conn.Publish(config.topic, []byte("event")); err != nil {
+		t.Fatalf("publish failed: %v.", err)
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case meta := <-handler.metas:
+			if meta.KeyID != "test-key" {
+				t.Fatalf("metadata #%d: key mismatch: have %q, want %q.", i, meta.KeyID, "test-key")
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("metadata #%d: delivery timed out.", i)
+		}
+	}
+}
+
+// Tests that encrypted requests to a service without a cipher fail.
+func TestCipherMissing(t *testing.T) {
+	// Register a new plain service to the relay
+	handler := &metadataTestHandler{
+		metas: make(chan *Metadata, 1),
+	}
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	// Connect an encrypting client and ensure requests are refused
+	conn, err := ConnectWithOptions(config.relay, &Options{Cipher: newCipherTestCipher()})
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	_, err = conn.Request(config.cluster, []byte("request"), time.Second)
+	if rerr, ok := err.(*RemoteError); !ok || rerr.Error() != ErrNoCipher.Error() {
+		t.Fatalf("request result mismatch: have %v, want %v.", err, ErrNoCipher)
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the time source abstraction behind the internal timers.
+//
+// All timeouts, expirations, backoffs and delays of a connection are measured
+// through its clock, so test suites can inject a fake one and step through the
+// time dependent paths instantly and deterministically. Timestamps embedded
+// into the wire protocol (e.g. credential signatures) keep using the real time.
+
+package iris
+
+import "time"
+
+// Source of the current time and timers of a connection.
+type Clock interface {
+	// Retrieves the current time.
+	Now() time.Time
+
+	// Waits for the duration to elapse and then sends the current time on the
+	// returned channel.
+	After(d time.Duration) <-chan time.Time
+
+	// Waits for the duration to elapse and then calls f in its own go-routine.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Single event timer created by a clock.
+type Timer interface {
+	// Prevents the timer from firing, returning false if it already expired or
+	// was stopped.
+	Stop() bool
+}
+
+// Clock backed by the real system time.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                            { return time.Now() }
+func (systemClock) After(d time.Duration) <-chan time.Time    { return time.After(d) }
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer { return time.AfterFunc(d, f) }
+
+// Retrieves the clock to use, defaulting to the system one if none was given.
+func newClock(user Clock) Clock {
+	if user == nil {
+		return systemClock{}
+	}
+	return user
+}
+
+// Retrieves the time elapsed on the clock since t.
+func since(clock Clock, t time.Time) time.Duration {
+	return clock.Now().Sub(t)
+}
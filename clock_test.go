@@ -0,0 +1,172 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// Manually advanced clock to deterministically fire the connection timers.
+type clockTestFake struct {
+	now    time.Time
+	timers map[*clockTestTimer]struct{}
+	lock   sync.Mutex
+}
+
+type clockTestTimer struct {
+	clock *clockTestFake
+	due   time.Time
+	fire  func(now time.Time)
+}
+
+func newClockTestFake() *clockTestFake {
+	return &clockTestFake{
+		now:    time.Unix(0, 0),
+		timers: make(map[*clockTestTimer]struct{}),
+	}
+}
+
+func (c *clockTestFake) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.now
+}
+
+func (c *clockTestFake) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	c.schedule(d, func(now time.Time) { ch <- now })
+	return ch
+}
+
+func (c *clockTestFake) AfterFunc(d time.Duration, f func()) Timer {
+	return c.schedule(d, func(time.Time) { go f() })
+}
+
+func (c *clockTestFake) schedule(d time.Duration, fire func(now time.Time)) *clockTestTimer {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	timer := &clockTestTimer{clock: c, due: c.now.Add(d), fire: fire}
+	c.timers[timer] = struct{}{}
+	return timer
+}
+
+func (t *clockTestTimer) Stop() bool {
+	t.clock.lock.Lock()
+	defer t.clock.lock.Unlock()
+
+	_, ok := t.clock.timers[t]
+	delete(t.clock.timers, t)
+	return ok
+}
+
+// Retrieves the number of timers pending on the clock.
+func (c *clockTestFake) pending() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return len(c.timers)
+}
+
+// Waits until at least n timers are pending on the clock.
+func (c *clockTestFake) wait(n int) {
+	for c.pending() < n {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Moves the clock forward, firing all the timers that became due.
+func (c *clockTestFake) advance(d time.Duration) {
+	c.lock.Lock()
+	c.now = c.now.Add(d)
+	var due []*clockTestTimer
+	for timer := range c.timers {
+		if !timer.due.After(c.now) {
+			due = append(due, timer)
+			delete(c.timers, timer)
+		}
+	}
+	now := c.now
+	c.lock.Unlock()
+
+	for _, timer := range due {
+		timer.fire(now)
+	}
+}
+
+// Tests that the connection timers run off the injected clock.
+func TestClockInjection(t *testing.T) {
+	serv, err := Register(config.relay, config.cluster, new(tunnelTestHandler), nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	clock := newClockTestFake()
+	conn, err := ConnectWithOptions(config.relay, &Options{Clock: clock})
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Verify that an hour long tunnel receive times out as soon as the clock says so
+	tun, err := conn.Tunnel(config.cluster, time.Second)
+	if err != nil {
+		t.Fatalf("tunnel construction failed: %v.", err)
+	}
+	defer tun.Close()
+
+	timers := clock.pending()
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := tun.Recv(time.Hour)
+		errc <- err
+	}()
+	clock.wait(timers + 1)
+	clock.advance(time.Hour)
+
+	select {
+	case err := <-errc:
+		if err != ErrTimeout {
+			t.Fatalf("receive error mismatch: have %v, want %v.", err, ErrTimeout)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("receive didn't time out on the fake clock.")
+	}
+	// Verify that delayed publishes become due by the clock only
+	handler := &publishLimitTestTopicHandler{
+		delivers: make(chan []byte, 1),
+	}
+	if err := conn.Subscribe(config.topic, handler, nil); err != nil {
+		t.Fatalf("subscription failed: %v.", err)
+	}
+	defer conn.Unsubscribe(config.topic)
+	time.Sleep(100 * time.Millisecond)
+
+	if _, err := conn.PublishAfter(config.topic, []byte("delayed"), time.Hour); err != nil {
+		t.Fatalf("failed to schedule publish: %v.", err)
+	}
+	clock.advance(time.Minute)
+	select {
+	case msg := <-handler.delivers:
+		t.Fatalf("event delivered before due: %q.", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+	clock.advance(time.Hour)
+	select {
+	case msg := <-handler.delivers:
+		if string(msg) != "delayed" {
+			t.Fatalf("event mismatch: have %q, want %q.", msg, "delayed")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("event not delivered once due.")
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Command iris-cli is a diagnostic tool to issue Iris messaging operations from
+// the terminal, without the need to write throwaway programs.
+//
+// Usage:
+//
+//	iris-cli [flags] broadcast <cluster> <message>
+//	iris-cli [flags] request   <cluster> <message>
+//	iris-cli [flags] publish   <topic>   <message>
+//	iris-cli [flags] subscribe <topic>
+//	iris-cli [flags] tunnel    <cluster>
+//	iris-cli [flags] echo      <cluster>
+//	iris-cli [flags] ping      <cluster>
+//
+// A message of "-" is read from the standard input. Subscriptions print the
+// arriving events until interrupted, whereas tunnels send each line of the
+// standard input as a separate message and print anything arriving back until
+// the remote side closes or no reply arrives within the timeout. The echo and
+// ping commands together measure the latency between two nodes: the former
+// serves a built-in echo service until interrupted, the latter probes it.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"time"
+
+	"gopkg.in/inconshreveable/log15.v2"
+	"gopkg.in/project-iris/iris-go.v1"
+)
+
+var (
+	relayFlag   = flag.Int("relay", 55555, "port of the local relay endpoint")
+	timeoutFlag = flag.Duration("timeout", 10*time.Second, "time allowance of the requests and tunnels")
+	statsFlag   = flag.Bool("stats", false, "dump the connection stats before exiting")
+	countFlag   = flag.Int("count", 100, "number of probes sent by the ping command")
+	verboseFlag = flag.Bool("v", false, "print the binding's own log entries")
+)
+
+// Arguments needed by each individual command.
+var commands = map[string]int{
+	"broadcast": 2,
+	"request":   2,
+	"publish":   2,
+	"subscribe": 1,
+	"tunnel":    1,
+	"echo":      1,
+	"ping":      1,
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		usage()
+		os.Exit(2)
+	}
+	if need, ok := commands[args[0]]; !ok || len(args) != need+1 {
+		usage()
+		os.Exit(2)
+	}
+	if !*verboseFlag {
+		iris.Log.SetHandler(log15.DiscardHandler())
+	}
+	conn, err := iris.Connect(*relayFlag)
+	if err != nil {
+		fatalf("failed to connect to the Iris relay: %v", err)
+	}
+	defer conn.Close()
+
+	if err := run(conn, args[0], args[1:]); err != nil {
+		dumpStats(conn)
+		fatalf("%s failed: %v", args[0], err)
+	}
+	dumpStats(conn)
+}
+
+// Prints the usage instructions of the tool.
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [flags] <command> <args>\n\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "Commands:\n")
+	fmt.Fprintf(os.Stderr, "  broadcast <cluster> <message>  broadcast a message to all members of a cluster\n")
+	fmt.Fprintf(os.Stderr, "  request   <cluster> <message>  request from a member of a cluster and print the reply\n")
+	fmt.Fprintf(os.Stderr, "  publish   <topic>   <message>  publish an event to a topic\n")
+	fmt.Fprintf(os.Stderr, "  subscribe <topic>              print the events of a topic until interrupted\n")
+	fmt.Fprintf(os.Stderr, "  tunnel    <cluster>            pipe stdin lines through a tunnel and print the replies\n")
+	fmt.Fprintf(os.Stderr, "  echo      <cluster>            serve a built-in echo service until interrupted\n")
+	fmt.Fprintf(os.Stderr, "  ping      <cluster>            probe the latency of an echo service\n\n")
+	fmt.Fprintf(os.Stderr, "A message of \"-\" is read from the standard input.\n\n")
+	fmt.Fprintf(os.Stderr, "Flags:\n")
+	flag.PrintDefaults()
+}
+
+// Prints an error message and terminates the process.
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
+
+// Executes a single command through the connection.
+func run(conn *iris.Connection, cmd string, args []string) error {
+	switch cmd {
+	case "broadcast":
+		msg, err := message(args[1])
+		if err != nil {
+			return err
+		}
+		return conn.Broadcast(args[0], msg)
+
+	case "request":
+		msg, err := message(args[1])
+		if err != nil {
+			return err
+		}
+		reply, err := conn.Request(args[0], msg, *timeoutFlag)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\n", reply)
+		return nil
+
+	case "publish":
+		msg, err := message(args[1])
+		if err != nil {
+			return err
+		}
+		return conn.Publish(args[0], msg)
+
+	case "subscribe":
+		if err := conn.Subscribe(args[0], printHandler{}, nil); err != nil {
+			return err
+		}
+		interrupted()
+		return conn.Unsubscribe(args[0])
+
+	case "tunnel":
+		return pipe(conn, args[0])
+
+	case "echo":
+		serv, err := iris.RegisterEcho(*relayFlag, args[0])
+		if err != nil {
+			return err
+		}
+		interrupted()
+		return serv.Unregister()
+
+	case "ping":
+		report, err := conn.Echo(args[0], *countFlag, *timeoutFlag)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d/%d echoes, min %v, median %v, p99 %v, max %v\n",
+			report.Received, report.Sent, report.Min, report.Median, report.P99, report.Max)
+		return nil
+	}
+	return fmt.Errorf("unknown command: %s", cmd)
+}
+
+// Retrieves the message to send, either from the argument or from stdin.
+func message(arg string) ([]byte, error) {
+	if arg == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	return []byte(arg), nil
+}
+
+// Blocks until the user interrupts the process.
+func interrupted() {
+	abort := make(chan os.Signal, 1)
+	signal.Notify(abort, os.Interrupt)
+	<-abort
+}
+
+// Topic handler printing all the arriving events.
+type printHandler struct{}
+
+func (printHandler) HandleEvent(event []byte) {
+	fmt.Printf("%s\n", event)
+}
+
+// Opens a tunnel to a cluster, sending each stdin line through it and printing
+// everything arriving back until the tunnel closes or the timeout expires.
+func pipe(conn *iris.Connection, cluster string) error {
+	tun, err := conn.Tunnel(cluster, *timeoutFlag)
+	if err != nil {
+		return err
+	}
+	defer tun.Close()
+
+	// Print the inbound messages until the tunnel closes
+	done := make(chan error, 1)
+	go func() {
+		for {
+			msg, err := tun.Recv(0)
+			if err != nil {
+				if err == iris.ErrClosed {
+					err = nil
+				}
+				done <- err
+				return
+			}
+			fmt.Printf("%s\n", msg)
+		}
+	}()
+	// Send the stdin lines until exhausted
+	lines := bufio.NewScanner(os.Stdin)
+	for lines.Scan() {
+		if len(lines.Bytes()) == 0 {
+			continue
+		}
+		if err := tun.Send(append([]byte{}, lines.Bytes()...), *timeoutFlag); err != nil {
+			return err
+		}
+	}
+	if err := lines.Err(); err != nil {
+		return err
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(*timeoutFlag):
+		return nil
+	}
+}
+
+// Prints the connection statistics if requested.
+func dumpStats(conn *iris.Connection) {
+	if !*statsFlag {
+		return
+	}
+	stats := conn.Stats()
+
+	fmt.Fprintf(os.Stderr, "Connection stats:\n")
+	fmt.Fprintf(os.Stderr, "  broadcasts:    %d sent, %d received\n", stats.BroadcastsSent, stats.BroadcastsRecv)
+	fmt.Fprintf(os.Stderr, "  requests:      %d sent, %d received, %d pending\n", stats.RequestsSent, stats.RequestsRecv, stats.PendingRequests)
+	fmt.Fprintf(os.Stderr, "  events:        %d sent, %d received\n", stats.EventsSent, stats.EventsRecv)
+	fmt.Fprintf(os.Stderr, "  subscriptions: %d active\n", stats.Subscriptions)
+	fmt.Fprintf(os.Stderr, "  tunnels:       %d opened, %d live\n", stats.TunnelsOpened, stats.Tunnels)
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the serialization codecs of the typed messaging APIs. Outbound typed
+// messages declare their content-type in the envelope, and inbound typed APIs
+// pick the decoder registered for the declared type, so that producers and
+// consumers can migrate between serialization formats gradually.
+
+package iris
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+)
+
+// Content-type assumed for typed messages not declaring any.
+const defaultContentType = "application/json"
+
+// Serialization format of typed message payloads.
+type Codec interface {
+	// Content-type identifying the format in the message envelopes.
+	ContentType() string
+
+	// Serializes a value into a message payload.
+	Marshal(value interface{}) ([]byte, error)
+
+	// Deserializes a message payload into the value pointed to.
+	Unmarshal(data []byte, value interface{}) error
+}
+
+// Codec serializing values into JSON.
+type JSONCodec struct{}
+
+// Implements Codec.ContentType.
+func (JSONCodec) ContentType() string { return defaultContentType }
+
+// Implements Codec.Marshal.
+func (JSONCodec) Marshal(value interface{}) ([]byte, error) { return json.Marshal(value) }
+
+// Implements Codec.Unmarshal.
+func (JSONCodec) Unmarshal(data []byte, value interface{}) error { return json.Unmarshal(data, value) }
+
+// Registers a codec on the connection, making it available for decoding inbound
+// typed messages declaring its content-type. The JSON codec is registered by
+// default.
+func (c *Connection) RegisterCodec(codec Codec) {
+	c.codecLock.Lock()
+	defer c.codecLock.Unlock()
+
+	c.codecs[codec.ContentType()] = codec
+}
+
+// Looks up the codec of a content-type, defaulting to JSON if none is declared.
+func (c *Connection) codec(contentType string) (Codec, error) {
+	if contentType == "" {
+		contentType = defaultContentType
+	}
+	c.codecLock.RLock()
+	defer c.codecLock.RUnlock()
+
+	codec, ok := c.codecs[contentType]
+	if !ok {
+		return nil, fmt.Errorf("no codec registered for content-type %q", contentType)
+	}
+	return codec, nil
+}
+
+// Serializes a value with the codec of the given content-type (or the default
+// one if empty), and publishes it to topic, declaring the content-type in the
+// envelope. Otherwise it behaves as Publish.
+func (c *Connection) PublishValue(topic string, contentType string, value interface{}) error {
+	codec, err := c.codec(contentType)
+	if err != nil {
+		return err
+	}
+	event, err := codec.Marshal(value)
+	if err != nil {
+		return err
+	}
+	if err := c.checkPublish(topic, event); err != nil {
+		return err
+	}
+	c.Log.Debug("publishing new typed event", "topic", topic, "type", codec.ContentType(), "data", logLazyBlob(event))
+	if c.faults.dropPublish() {
+		c.Log.Debug("fault injected: event dropped", "topic", topic)
+		return nil
+	}
+	event, err = c.envelope(topic, event, map[string]string{headerContentType: codec.ContentType()})
+	if err != nil {
+		return err
+	}
+	if err := c.sendPublish(topic, event); err != nil {
+		return err
+	}
+	atomic.AddUint64(&c.stats.eventSent, 1)
+	return nil
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// Toy codec serializing points as "x:y" strings.
+type pointCodec struct{}
+
+func (pointCodec) ContentType() string { return "text/x-point" }
+
+func (pointCodec) Marshal(value interface{}) ([]byte, error) {
+	p := value.(codecTestPoint)
+	return []byte(fmt.Sprintf("%d:%d", p.X, p.Y)), nil
+}
+
+func (pointCodec) Unmarshal(data []byte, value interface{}) error {
+	p := value.(*codecTestPoint)
+	_, err := fmt.Sscanf(string(data), "%d:%d", &p.X, &p.Y)
+	return err
+}
+
+// Value streamed by the codec tests.
+type codecTestPoint struct {
+	X, Y int
+}
+
+// Tests that typed streams decode events with the codec of their declared
+// content-type, allowing mixed formats on the same topic.
+func TestCodecNegotiation(t *testing.T) {
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+	conn.RegisterCodec(pointCodec{})
+
+	points, stop, err := Stream[codecTestPoint](conn, config.topic, &StreamOptions[codecTestPoint]{Limits: &TopicLimits{EventThreads: 1}})
+	if err != nil {
+		t.Fatalf("failed to start stream: %v.", err)
+	}
+	defer stop()
+	time.Sleep(100 * time.Millisecond)
+
+	// Publish the same value in both formats, failing on an unregistered one
+	if err := conn.PublishValue(config.topic, "", codecTestPoint{1, 2}); err != nil {
+		t.Fatalf("failed to publish JSON event: %v.", err)
+	}
+	if err := conn.PublishValue(config.topic, "text/x-unknown", codecTestPoint{3, 4}); err == nil {
+		t.Fatalf("publish with unknown content-type succeeded.")
+	}
+	if err := conn.PublishValue(config.topic, "text/x-point", codecTestPoint{1, 2}); err != nil {
+		t.Fatalf("failed to publish custom event: %v.", err)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case p := <-points:
+			if p != (codecTestPoint{1, 2}) {
+				t.Fatalf("event %d mismatch: have %+v, want %+v.", i, p, codecTestPoint{1, 2})
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("event %d timed out.", i)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the transparent compression of the message payloads.
+//
+// Outbound broadcast, request, reply and publish payloads above a threshold are
+// deflated before encryption and marked as such in their envelope, so receivers
+// inflate them before delivery regardless of their own settings. Payloads not
+// shrinking by compression are sent as they were. Individual calls may force or
+// disable compression through their context, overriding the policy. Tunnel
+// traffic is never compressed.
+
+package iris
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// Settings of the transparent payload compression. Any unset fields (i.e. value
+// of zero) will default to the preset ones.
+type CompressionPolicy struct {
+	Threshold int // Payload size from which to compress
+	Level     int // Deflate compression level (see compress/flate)
+}
+
+// Default settings of the transparent payload compression.
+var defaultCompressionPolicy = CompressionPolicy{
+	Threshold: 1024,
+	Level:     flate.DefaultCompression,
+}
+
+// Per-call override of the connection's compression policy.
+type Compression int
+
+const (
+	CompressAuto  Compression = iota // Follow the connection's compression policy
+	CompressForce                    // Compress regardless of the payload size or policy
+	CompressNever                    // Send uncompressed regardless of the policy
+)
+
+// Context key of the per-call compression override.
+type compressionKey struct{}
+
+// Creates a context overriding the compression of the calls made with it (i.e.
+// BroadcastContext, RequestContext and PublishContext).
+func WithCompression(ctx context.Context, mode Compression) context.Context {
+	return context.WithValue(ctx, compressionKey{}, mode)
+}
+
+// Retrieves the compression override carried by a context, if any.
+func compressionOf(ctx context.Context) Compression {
+	mode, _ := ctx.Value(compressionKey{}).(Compression)
+	return mode
+}
+
+// Envelope marker of the payloads compressed with deflate.
+const compressDeflate = "deflate"
+
+// Maximum size a compressed payload may inflate to, guarding against bombs.
+var maxInflatedSize = 64 * 1024 * 1024
+
+// Compresses an outbound payload if the mode and policy call for it, returning
+// whether it was compressed.
+func (c *Connection) compress(payload []byte, mode Compression) ([]byte, bool) {
+	policy := defaultCompressionPolicy
+	if user := c.options.Compression; user != nil {
+		if user.Threshold != 0 {
+			policy.Threshold = user.Threshold
+		}
+		if user.Level != 0 {
+			policy.Level = user.Level
+		}
+	}
+	switch mode {
+	case CompressNever:
+		return payload, false
+	case CompressAuto:
+		if c.options.Compression == nil || len(payload) < policy.Threshold {
+			return payload, false
+		}
+	}
+	var buf bytes.Buffer
+	writer, err := flate.NewWriter(&buf, policy.Level)
+	if err != nil {
+		c.Log.Warn("failed to create compressor", "level", policy.Level, "reason", err)
+		return payload, false
+	}
+	writer.Write(payload)
+	writer.Close()
+
+	if buf.Len() >= len(payload) {
+		atomic.AddUint64(&c.stats.compressSkip, 1)
+		return payload, false
+	}
+	atomic.AddUint64(&c.stats.compressIn, uint64(len(payload)))
+	atomic.AddUint64(&c.stats.compressOut, uint64(buf.Len()))
+	return buf.Bytes(), true
+}
+
+// Inflates an inbound payload compressed with the given algorithm.
+func inflate(algorithm string, payload []byte) ([]byte, error) {
+	if algorithm != compressDeflate {
+		return nil, fmt.Errorf("unsupported compression: %s", algorithm)
+	}
+	reader := flate.NewReader(bytes.NewReader(payload))
+	defer reader.Close()
+
+	plain, err := io.ReadAll(io.LimitReader(reader, int64(maxInflatedSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(plain) > maxInflatedSize {
+		return nil, errors.New("inflated payload too large")
+	}
+	return plain, nil
+}
@@ -0,0 +1,109 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"testing"
+	"time"
+)
+
+// Tests that large payloads are transparently compressed, unless overridden.
+func TestCompression(t *testing.T) {
+	serv, err := Register(config.relay, config.cluster, new(requestTestHandler), nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := ConnectWithOptions(config.relay, &Options{Compression: &CompressionPolicy{Threshold: 64}})
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Issue a large and a small request, only the former should be compressed
+	large := bytes.Repeat([]byte("compressible "), 256)
+	for _, req := range [][]byte{large, []byte("small")} {
+		if rep, err := conn.Request(config.cluster, req, time.Second); err != nil {
+			t.Fatalf("request failed: %v.", err)
+		} else if !bytes.Equal(rep, req) {
+			t.Fatalf("reply mismatch: have %d bytes, want %d.", len(rep), len(req))
+		}
+	}
+	stats := conn.Stats()
+	if stats.CompressedIn != uint64(len(large)) {
+		t.Fatalf("compressed input mismatch: have %d, want %d.", stats.CompressedIn, len(large))
+	}
+	if ratio := stats.CompressionRatio(); ratio <= 0 || ratio >= 0.5 {
+		t.Fatalf("compression ratio mismatch: have %v, want (0, 0.5).", ratio)
+	}
+	// Disable compression for a single call, and force it for an incompressible one
+	if _, err := conn.RequestContext(WithCompression(context.Background(), CompressNever), config.cluster, large, time.Second); err != nil {
+		t.Fatalf("uncompressed request failed: %v.", err)
+	}
+	random := make([]byte, 1024)
+	rand.Read(random)
+	if _, err := conn.RequestContext(WithCompression(context.Background(), CompressForce), config.cluster, random, time.Second); err != nil {
+		t.Fatalf("incompressible request failed: %v.", err)
+	}
+	if have := conn.Stats(); have.CompressedIn != stats.CompressedIn || have.CompressSkipped != 1 {
+		t.Fatalf("override stats mismatch: have %d/%d, want %d/%d.", have.CompressedIn, have.CompressSkipped, stats.CompressedIn, 1)
+	}
+}
+
+// Tests that compression can be forced on connections without a policy.
+func TestCompressionForced(t *testing.T) {
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	events := make(chan []byte, 1)
+	if err := conn.Subscribe(config.topic, &nsTestTopicHandler{events, make(chan string, 1)}, nil); err != nil {
+		t.Fatalf("subscription failed: %v.", err)
+	}
+	defer conn.Unsubscribe(config.topic)
+	time.Sleep(10 * time.Millisecond)
+
+	event := bytes.Repeat([]byte("event "), 256)
+	if err := conn.PublishContext(WithCompression(context.Background(), CompressForce), config.topic, event); err != nil {
+		t.Fatalf("publish failed: %v.", err)
+	}
+	select {
+	case have := <-events:
+		if !bytes.Equal(have, event) {
+			t.Fatalf("event mismatch: have %d bytes, want %d.", len(have), len(event))
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("compressed event not delivered.")
+	}
+	if stats := conn.Stats(); stats.CompressedIn != uint64(len(event)) {
+		t.Fatalf("compressed input mismatch: have %d, want %d.", stats.CompressedIn, len(event))
+	}
+}
+
+// Tests that payloads inflating beyond the limit are rejected.
+func TestInflateLimit(t *testing.T) {
+	conn := &Connection{options: &Options{}, Log: Log}
+	packed, ok := conn.compress(make([]byte, 4096), CompressForce)
+	if !ok {
+		t.Fatalf("zero payload not compressed.")
+	}
+	defer func(limit int) { maxInflatedSize = limit }(maxInflatedSize)
+	maxInflatedSize = 1024
+
+	if _, err := inflate(compressDeflate, packed); err == nil {
+		t.Fatalf("oversized inflation accepted.")
+	}
+	if _, err := inflate("lzma", packed); err == nil {
+		t.Fatalf("unknown compression accepted.")
+	}
+}
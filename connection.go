@@ -8,6 +8,7 @@ package iris
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"net"
@@ -15,48 +16,124 @@ import (
 	"sync/atomic"
 	"time"
 
-	"github.com/project-iris/iris/pool"
 	"gopkg.in/inconshreveable/log15.v2"
+	"gopkg.in/project-iris/iris-go.v1/internal/pool"
+	"gopkg.in/project-iris/iris-go.v1/relaywire"
 )
 
 // Client connection to the Iris network.
 type Connection struct {
 	// Application layer fields
 	handler ServiceHandler // Handler for connection events
+	cluster string         // Cluster the connection is registered into (empty for clients)
+	name    string         // Sender name reported in the delivery metadata
+	caller  string         // Self-reported id of the connection sent along in the delivery metadata
 
-	reqIdx  uint64                 // Index to assign the next request
-	reqReps map[uint64]chan []byte // Reply channels for active requests
-	reqErrs map[uint64]chan error  // Error channels for active requests
-	reqLock sync.RWMutex           // Mutex to protect the result channel maps
+	reqLive *pendingTable // Result channels of the active requests
+
+	lanes   []*Connection // Additional relay sockets striping the outbound traffic
+	laneIdx uint32        // Index of the last lane a request was sent through
+
+	primary  *Connection           // Service serving the inbound traffic of an alias link (nil otherwise)
+	aliases  []*Connection         // Additional relay links registering the service under other names
+	zoneLive map[string]*zoneState // Presence of members in the own zone, per cluster
+	zoneLock sync.Mutex            // Mutex to protect the zone presence map
 
 	subIdx  uint64            // Index to assign the next subscription (logging purposes)
 	subLive map[string]*topic // Active subscriptions
 	subLock sync.RWMutex      // Mutex to protect the subscription map
 
+	ordered   sync.Map        // Topics subscribed with ordered delivery
+	orderPend []*orderedEvent // Events of ordered subscriptions awaiting dispatch
+	orderBusy bool            // Whether the ordered dispatcher is running
+	orderLock sync.Mutex      // Mutex to protect the ordered dispatch queue
+
+	pubIdx  uint64              // Index to assign the next confirmed publish
+	pubAcks map[uint64]chan int // Acknowledgement channels for confirmed publishes
+	pubLock sync.Mutex          // Mutex to protect the acknowledgement map
+
+	sched *scheduler // Delayed broadcasts and publishes awaiting their due time
+
+	rcptIdx   uint64         // Index to assign the next tracked broadcast
+	rcptLive  map[uint64]int // Receipts counted so far for the tracked broadcasts
+	rcptTopic string         // Private topic the receipts arrive on (empty until first use)
+	rcptLock  sync.Mutex     // Mutex to protect the receipt trackers
+
+	codecs    map[string]Codec // Codecs of the typed messages, keyed by content-type
+	codecLock sync.RWMutex     // Mutex to protect the codec map
+
+	schemaLive map[string][]int // Payload schema versions advertised by the clusters
+	schemaLock sync.Mutex       // Mutex to protect the schema version map
+
 	tunIdx  uint64             // Index to assign the next tunnel
 	tunLive map[uint64]*Tunnel // Active tunnels
 	tunLock sync.RWMutex       // Mutex to protect the tunnel map
+	tunAcpt chan *Tunnel       // Accept queue of the inbound tunnels (nil = handler callbacks)
+	tunRTT  int64              // Smoothed tunnel construction round trip in nanoseconds (atomic)
+	tunPara sync.Map           // Inbound parallel transfers accepting stripes, keyed by id
 
 	// Quality of service fields
-	limits *ServiceLimits // Limits on the inbound message processing
+	limits  *ServiceLimits  // Limits on the inbound message processing
+	options *Options        // Optional settings of the connection
+	clock   Clock           // Time source of the timeouts, expirations and backoffs
+	faults  *faultInjector  // Fault injection layer (nil if disabled)
+	latency *latencyTracker // Reply latency tracker (nil if adaptive timeouts are disabled)
+	retry   *retryBudget    // Retry policy and budget (nil if retries are disabled)
+	limiter *clusterLimiter // Outstanding request cap per cluster (nil if unlimited)
+	quota   *quotaLimiter   // Inbound request rate limits per caller (nil if unlimited)
+	poison  *quarantine     // Poison message tracker (nil if quarantining is disabled)
+	tracer  *tracer         // Handler invocation sampler (nil if tracing is disabled)
 
 	bcastIdx  uint64           // Index to assign the next inbound broadcast (logging purposes)
 	bcastPool *pool.ThreadPool // Queue and concurrency limiter for the broadcast handlers
 	bcastUsed int32            // Actual memory usage of the broadcast queue
+	bcastSlow *slowDetector    // Congestion tracker of the broadcast queue (nil if disabled)
+	bcastKeys *serialQueue     // Per key queues of the keyed broadcast handlers
 
 	reqPool *pool.ThreadPool // Queue and concurrency limiter for the request handlers
 	reqUsed int32            // Actual memory usage of the request queue
+	reqPend int32            // Number of requests waiting in the queue
+	reqFair *fairQueue       // Per caller queues if fair scheduling is enabled
+	reqEDF  *deadlineQueue   // Nearest deadline first queue if enabled
+	reqTime int64            // Moving average of the request handling times
+
+	reqQueue     map[uint64]time.Time // Arrival times of the queued inbound requests
+	reqQueueLock sync.Mutex           // Protects the queued request arrival times
+
+	reqCancels    map[string]context.CancelCauseFunc // Context cancellers of the inbound requests, keyed by cancel token
+	reqCancelLock sync.Mutex                         // Protects the request context cancellers
+
+	echoes   map[uint64]*loopbackEcho // Relay echoes expected of the broadcasts delivered in memory
+	echoLock sync.Mutex               // Protects the expected echoes
+
+	prio    *pool.Gate   // Weighted handler slots shared by the message kinds (nil if disabled)
+	tunKeys *serialQueue // Per tunnel queues of the prioritized chunk processing
+
+	limitLock sync.RWMutex // Protects the limits and handler pools from runtime changes
+	unready   int32        // Whether the service stopped accepting new work (atomic)
 
 	// Network layer fields
 	sock     net.Conn          // Network connection to the iris node
 	sockBuf  *bufio.ReadWriter // Buffered access to the network socket
-	sockLock sync.Mutex        // Mutex to atomize message sending
+	sockEnc  *relaywire.Writer // Frame encoder reused by all sends (socket lock protected)
+	sockDec  *relaywire.Reader // Frame decoder lending its frames until the next receive
+	sockLock priorityLock      // Mutex to atomize message sending, control frames first
 	sockWait int32             // Counter for the pending writes (batch before flush)
 
+	version  string          // Protocol version agreed with the relay
+	features map[string]bool // Extension features agreed with the relay
+
 	// Bookkeeping fields
-	init chan struct{}   // Init channel to receive a success signal
-	quit chan chan error // Quit channel to synchronize receiver termination
-	term chan struct{}   // Channel to signal termination to blocked go-routines
+	port    int                // Local relay port to reconnect to
+	closing int32              // Set when a graceful close was initiated
+	termErr error              // Failure that terminated the connection, if any
+	stats   connStats          // Traffic counters of the connection
+	errHook atomic.Value       // Callback of the background failures (errorHook)
+	ctx     context.Context    // Context of the handler callbacks, cancelled on termination
+	cancel  context.CancelFunc // Cancels the handler context
+	init    chan struct{}      // Init channel to receive a success signal
+	quit    chan chan error    // Quit channel to synchronize receiver termination
+	term    chan struct{}      // Channel to signal termination to blocked go-routines
 
 	Log log15.Logger // Logger with connection id injected
 }
@@ -66,10 +143,19 @@ var nextConnId uint64
 
 // Connects to the Iris network as a simple client.
 func Connect(port int) (*Connection, error) {
+	return ConnectWithOptions(port, nil)
+}
+
+// Connects to the Iris network as a simple client, overriding some of the
+// default connection settings.
+func ConnectWithOptions(port int, options *Options) (*Connection, error) {
+	// Make sure the options have valid values
+	options = finalizeOptions(options)
+
 	logger := Log.New("client", atomic.AddUint64(&nextConnId, 1))
 	logger.Info("connecting new client", "relay_port", port)
 
-	conn, err := newConnection(port, "", nil, nil, logger)
+	conn, err := newConnection(port, "", nil, nil, options, nil, logger)
 	if err != nil {
 		logger.Warn("failed to connect new client", "reason", err)
 	} else {
@@ -79,51 +165,100 @@ func Connect(port int) (*Connection, error) {
 }
 
 // Connects to a local relay endpoint on port and registers as cluster.
-func newConnection(port int, cluster string, handler ServiceHandler, limits *ServiceLimits, logger log15.Logger) (*Connection, error) {
+func newConnection(port int, cluster string, handler ServiceHandler, limits *ServiceLimits, options *Options, primary *Connection, logger log15.Logger) (*Connection, error) {
 	// Connect to the iris relay node
-	addr, err := net.ResolveTCPAddr("tcp", fmt.Sprintf("localhost:%d", port))
-	if err != nil {
-		return nil, err
-	}
-	sock, err := net.DialTCP("tcp", nil, addr)
+	sock, err := dialRelay(options, port)
 	if err != nil {
 		return nil, err
 	}
 	// Create the relay object
+	clock := newClock(options.Clock)
+	ctx, cancel := context.WithCancel(context.Background())
 	conn := &Connection{
 		// Application layer
 		handler: handler,
-
-		reqReps: make(map[uint64]chan []byte),
-		reqErrs: make(map[uint64]chan error),
-		subLive: make(map[string]*topic),
-		tunLive: make(map[uint64]*Tunnel),
+		cluster: cluster,
+		name:    cluster,
+		caller:  newCorrelationID(),
+		primary: primary,
+
+		reqLive:    newPendingTable(),
+		subLive:    make(map[string]*topic),
+		pubAcks:    make(map[uint64]chan int),
+		rcptLive:   make(map[uint64]int),
+		sched:      &scheduler{clock: clock, pend: make(map[*Scheduled]struct{})},
+		codecs:     map[string]Codec{defaultContentType: JSONCodec{}},
+		schemaLive: make(map[string][]int),
+		zoneLive:   make(map[string]*zoneState),
+		tunLive:    make(map[uint64]*Tunnel),
+
+		// Quality of service
+		options: options,
+		clock:   clock,
+		faults:  newFaultInjector(options.Faults),
+		latency: newLatencyTracker(options.Timeouts),
+		retry:   newRetryBudget(options.Retry, clock),
+		limiter: newClusterLimiter(options.Outstanding),
+		quota:   newQuotaLimiter(options.Quotas, clock),
+		poison:  newQuarantine(options.Quarantine, clock),
+		tracer:  newTracer(options.Tracing, clock, logger),
+		prio:    newPriorityGate(options.Priorities),
+		tunKeys: newSerialQueue(),
 
 		// Network layer
 		sock:    sock,
 		sockBuf: bufio.NewReadWriter(bufio.NewReader(sock), bufio.NewWriter(sock)),
+		sockEnc: relaywire.NewWriter(nil),
+		sockDec: relaywire.NewScratchReader(nil),
 
 		// Bookkeeping
-		quit: make(chan chan error),
-		term: make(chan struct{}),
+		port:   port,
+		ctx:    ctx,
+		cancel: cancel,
+		quit:   make(chan chan error),
+		term:   make(chan struct{}),
 
 		Log: logger,
 	}
+	if options.Name != "" {
+		conn.name = options.Name
+	}
 	// Initialize service QoS fields
 	if cluster != "" {
 		conn.limits = limits
 		conn.bcastPool = pool.NewThreadPool(limits.BroadcastThreads)
+		conn.bcastSlow = newSlowDetector(options.SlowConsumer, "", clock)
+		conn.bcastKeys = newSerialQueue()
 		conn.reqPool = pool.NewThreadPool(limits.RequestThreads)
+		conn.reqQueue = make(map[uint64]time.Time)
+		conn.reqCancels = make(map[string]context.CancelCauseFunc)
+		conn.echoes = make(map[uint64]*loopbackEcho)
+		if limits.TunnelBacklog > 0 {
+			conn.tunAcpt = make(chan *Tunnel, limits.TunnelBacklog)
+		}
+		if options.Fair || options.Fairness != nil {
+			conn.reqFair = newFairQueue()
+		} else if options.Deadlines {
+			conn.reqEDF = newDeadlineQueue()
+		}
 	}
-	// Initialize the connection and wait for a confirmation
-	if err := conn.sendInit(cluster); err != nil {
+	// Initialize the connection and negotiate the protocol with the relay
+	if err := conn.handshake(port, cluster); err != nil {
+		conn.cancel()
+		conn.sock.Close()
 		return nil, err
 	}
-	if _, err := conn.procInit(); err != nil {
+	// Start the network receiver, open any additional lanes and return
+	go conn.process()
+
+	if err := conn.openLanes(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := conn.openAliases(); err != nil {
+		conn.Close()
 		return nil, err
 	}
-	// Start the network receiver and return
-	go conn.process()
 	return conn, nil
 }
 
@@ -132,23 +267,75 @@ func newConnection(port int, cluster string, handler ServiceHandler, limits *Ser
 //
 // The call blocks until the message is forwarded to the local Iris node.
 func (c *Connection) Broadcast(cluster string, message []byte) error {
-	// Sanity check on the arguments
+	return c.BroadcastContext(context.Background(), cluster, message)
+}
+
+// Broadcasts a message similarly to Broadcast, but fails without sending if the
+// context is cancelled before the message is handed to the relay link. A write
+// already in progress is not interrupted.
+func (c *Connection) BroadcastContext(ctx context.Context, cluster string, message []byte) error {
+	if err := c.checkBroadcast(cluster, message); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	// Broadcast and return
+	c.Log.Debug("sending new broadcast", "cluster", cluster, "data", logLazyBlob(message))
+	if c.faults.dropBroadcast() {
+		c.Log.Debug("fault injected: broadcast dropped", "cluster", cluster)
+		return nil
+	}
+	sealed, err := c.seal(cluster, message, nil, compressionOf(ctx))
+	if err != nil {
+		return err
+	}
+	// Deliver to any local members in memory, relaying for the remote ones
+	members := c.loopbackMembers(cluster)
+	if len(members) > 0 {
+		c.broadcastLocal(members, sealed)
+	}
+	if err := c.sendBroadcast(cluster, sealed); err != nil {
+		c.withdrawEchoes(members, sealed)
+		return err
+	}
+	atomic.AddUint64(&c.stats.bcastSent, 1)
+	return nil
+}
+
+// Validates the arguments of a broadcast against the sanity checks.
+func (c *Connection) checkBroadcast(cluster string, message []byte) error {
 	if len(cluster) == 0 {
 		return errors.New("empty cluster identifier")
 	}
 	if message == nil || len(message) == 0 {
 		return errors.New("nil or empty message")
 	}
-	// Broadcast and return
-	c.Log.Debug("sending new broadcast", "cluster", cluster, "data", logLazyBlob(message))
-	return c.sendBroadcast(cluster, message)
+	if err := c.checkSendSize(len(message)); err != nil {
+		return err
+	}
+	return c.validate(cluster, message, ValidateSend)
 }
 
 // Executes a synchronous request to be serviced by a member of the specified
 // cluster, load-balanced between all participant, returning the received reply.
 //
-// The timeout unit is in milliseconds. Anything lower will fail with an error.
+// The timeout unit is in milliseconds. Anything lower will fail with an error,
+// unless adaptive timeouts are enabled, in which case a zero timeout is derived
+// from the latencies observed recently from the cluster, or a default request
+// timeout is configured, in which case a zero timeout falls back to it.
 func (c *Connection) Request(cluster string, request []byte, timeout time.Duration) ([]byte, error) {
+	return c.RequestContext(context.Background(), cluster, request, timeout)
+}
+
+// Executes a synchronous request similarly to Request, but aborting the wait if
+// the context is cancelled, and propagating the correlation ID carried by the
+// context (or a freshly generated one if metadata is enabled) to the handler.
+// Any idempotency key carried by the context is attached too.
+//
+// Cancelling the context also notifies the serving member, cancelling the
+// context of the handler (see Metadata.Context).
+func (c *Connection) RequestContext(ctx context.Context, cluster string, request []byte, timeout time.Duration) (reply []byte, err error) {
 	// Sanity check on the arguments
 	if len(cluster) == 0 {
 		return nil, errors.New("empty cluster identifier")
@@ -156,35 +343,165 @@ func (c *Connection) Request(cluster string, request []byte, timeout time.Durati
 	if request == nil || len(request) == 0 {
 		return nil, errors.New("nil or empty request")
 	}
+	if err := c.checkSendSize(len(request)); err != nil {
+		return nil, err
+	}
+	if err := c.validate(cluster, request, ValidateSend); err != nil {
+		return nil, err
+	}
+	if timeout == 0 && c.latency != nil {
+		timeout = c.latency.timeout(cluster)
+	}
+	if timeout == 0 {
+		timeout = c.options.Defaults.request()
+	}
 	timeoutms := int(timeout.Nanoseconds() / 1000000)
 	if timeoutms < 1 {
 		return nil, fmt.Errorf("invalid timeout %v < 1ms", timeout)
 	}
-	// Create a reply and error channel for the results
-	repc := make(chan []byte, 1)
-	errc := make(chan error, 1)
+	// Attach the correlation ID and any other metadata
+	logger := c.Log
+	correlation := CorrelationID(ctx)
+	if correlation == "" && c.options.Metadata {
+		correlation = newCorrelationID()
+	}
+	extra := make(map[string]string)
+	if correlation != "" {
+		logger = logger.New("correlation", correlation)
+		extra[headerCorrelation] = correlation
+	}
+	if key := IdempotencyKey(ctx); key != "" {
+		extra[headerIdempotency] = key
+	}
+	if tag := shardTagOf(ctx); tag != "" {
+		extra[headerShard] = tag
+	}
+	if tag := schemaTagOf(ctx); tag != "" {
+		extra[headerSchema] = tag
+	}
+	if ctx.Done() != nil && c.envelopes(cluster) {
+		token := newCorrelationID()
+		extra[headerCancel] = token
+
+		defer func() {
+			if err != nil && ctx.Err() != nil {
+				c.cancelRemote(cluster, token)
+			}
+		}()
+	}
+	request, err = c.seal(cluster, request, extra, compressionOf(ctx))
+	if err != nil {
+		return nil, err
+	}
+	// Route the request to the caller's zone if preferred and possible. Should the
+	// zone members reject it, fall back to the whole cluster within the time left.
+	// Timed out requests might have been processed, so only idempotent ones switch
+	// over, and only for their retries.
+	route := c.zoneRoute(ctx, cluster)
+	issue := func() ([]byte, error) {
+		if route == cluster {
+			return c.request(ctx, logger, cluster, request, timeout, timeoutms)
+		}
+		start := c.clock.Now()
+		reply, err := c.request(ctx, logger, route, request, timeout, timeoutms)
+		if err != ErrTimeout && !retriable(ctx, err) {
+			return reply, err
+		}
+		c.forgetZone(cluster)
+		if !retriable(ctx, err) {
+			return reply, err
+		}
+		logger.Debug("zone members failed, falling back to cluster", "cluster", cluster, "zone", c.options.Zone, "error", err)
+		route = cluster
 
-	c.reqLock.Lock()
-	reqId := c.reqIdx
-	c.reqIdx++
-	c.reqReps[reqId] = repc
-	c.reqErrs[reqId] = errc
-	c.reqLock.Unlock()
+		left := timeout - since(c.clock, start)
+		if left < time.Millisecond {
+			return reply, err
+		}
+		return c.request(ctx, logger, cluster, request, left, int(left.Nanoseconds()/1000000))
+	}
+	// If retries are disabled, issue a single attempt
+	if c.retry == nil {
+		return issue()
+	}
+	// Otherwise retry failed attempts while the policy and budget permit
+	c.retry.request()
+
+	backoff := c.retry.policy.Backoff
+	for attempt := 1; ; attempt++ {
+		reply, err := issue()
+		if err == nil || !retriable(ctx, err) || attempt >= c.retry.policy.Attempts {
+			return reply, err
+		}
+		if !c.retry.retry() {
+			logger.Debug("retry budget exhausted", "cluster", cluster, "attempt", attempt)
+			return reply, err
+		}
+		logger.Debug("retrying failed request", "cluster", cluster, "attempt", attempt, "error", err, "backoff", backoff)
+		select {
+		case <-c.term:
+			return nil, ErrClosed
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-c.clock.After(backoff):
+			backoff *= 2
+		}
+	}
+}
+
+// Executes a single request attempt, with the arguments already validated.
+func (c *Connection) request(ctx context.Context, logger log15.Logger, cluster string, request []byte, timeout time.Duration, timeoutms int) ([]byte, error) {
+	// Wait for a free request slot, deducting any wait from the timeout
+	if c.limiter != nil {
+		queued := c.clock.Now()
+		if err := c.limiter.acquire(ctx, cluster, c.clock.After(timeout), c.term); err != nil {
+			return nil, err
+		}
+		defer c.limiter.release(cluster)
+
+		if wait := since(c.clock, queued); wait > 0 {
+			timeout -= wait
+			if timeoutms = int(timeout.Nanoseconds() / 1000000); timeoutms < 1 {
+				return nil, ErrTimeout
+			}
+		}
+	}
+	// Inject any requested faults before sending
+	if delay := c.faults.requestDelay(); delay > 0 {
+		logger.Debug("fault injected: request delayed", "cluster", cluster, "delay", delay)
+		select {
+		case <-c.term:
+			return nil, ErrClosed
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-c.clock.After(delay):
+		}
+	}
+	if c.faults.dropRequest() {
+		logger.Debug("fault injected: request dropped", "cluster", cluster)
+		select {
+		case <-c.term:
+			return nil, ErrClosed
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-c.clock.After(timeout):
+			return nil, ErrTimeout
+		}
+	}
+	// Register the request on its lane and make sure it's cleaned up
+	link := c.nextLane()
+	reqId, pend := link.reqLive.add()
+	defer link.reqLive.remove(reqId)
 
-	// Make sure the result channels are cleaned up
-	defer func() {
-		c.reqLock.Lock()
-		delete(c.reqReps, reqId)
-		delete(c.reqErrs, reqId)
-		close(repc)
-		close(errc)
-		c.reqLock.Unlock()
-	}()
 	// Send the request
-	c.Log.Debug("sending new request", "local_request", reqId, "cluster", cluster, "data", logLazyBlob(request), "timeout", timeout)
-	if err := c.sendRequest(reqId, cluster, request, timeoutms); err != nil {
+	logger.Debug("sending new request", "local_request", reqId, "cluster", cluster, "data", logLazyBlob(request), "timeout", timeout)
+	start := c.clock.Now()
+	if member := c.loopbackMember(cluster); member != nil {
+		c.requestLocal(member, link, reqId, request, timeout)
+	} else if err := link.sendRequest(reqId, cluster, request, timeoutms); err != nil {
 		return nil, err
 	}
+	atomic.AddUint64(&c.stats.reqSent, 1)
 	// Retrieve the results or fail if terminating
 	var reply []byte
 	var err error
@@ -192,15 +509,34 @@ func (c *Connection) Request(cluster string, request []byte, timeout time.Durati
 	select {
 	case <-c.term:
 		err = ErrClosed
-	case reply = <-repc:
-	case err = <-errc:
+	case <-link.term:
+		err = ErrClosed
+	case <-ctx.Done():
+		err = ctx.Err()
+	case reply = <-pend.reply:
+		c.latency.record(cluster, since(c.clock, start))
+	case err = <-pend.fault:
+		if _, ok := err.(*RemoteError); ok {
+			c.latency.record(cluster, since(c.clock, start))
+		} else if err == ErrTimeout {
+			c.latency.expired(cluster, since(c.clock, start), timeout)
+		}
 	}
-	c.Log.Debug("request completed", "local_request", reqId, "data", logLazyBlob(reply), "error", err)
+	logger.Debug("request completed", "local_request", reqId, "data", logLazyBlob(reply), "error", err)
 	return reply, err
 }
 
 // Subscribes to a topic, using handler as the callback for arriving events.
 //
+// The limits apply to this subscription alone: every topic has its own handler
+// threads and event memory allowance, so a noisy topic exhausting its own quota
+// doesn't delay or drop the events of the other subscriptions.
+//
+// Events are handled in parallel and thus possibly out of order, unless ordered
+// delivery is requested in the limits, in which case the events of a publisher
+// run one by one in publish order. Publishers are told apart by their sender
+// metadata, so they need metadata enabled to run in parallel with each other.
+//
 // The method blocks until the subscription is forwarded to the relay. There
 // might be a small delay between subscription completion and start of event
 // delivery. This is caused by subscription propagation through the network.
@@ -212,6 +548,10 @@ func (c *Connection) Subscribe(topic string, handler TopicHandler, limits *Topic
 	if handler == nil {
 		return errors.New("nil subscription handler")
 	}
+	if policy := c.options.SubscribePolicy; policy != nil && !policy(topic) {
+		c.Log.Warn("subscription denied by policy", "topic", topic)
+		return ErrDenied
+	}
 	// Make sure the subscription limits have valid values
 	limits = finalizeTopicLimits(limits)
 
@@ -227,19 +567,31 @@ func (c *Connection) Subscribe(topic string, handler TopicHandler, limits *Topic
 			return fmt.Sprintf("%dT|%dB", limits.EventThreads, limits.EventMemory)
 		}})
 
-	c.subLive[topic] = newTopic(handler, limits, logger)
-	c.subLock.Unlock()
+	top := newTopic(handler, limits, logger)
+	top.poison = c.poison
+	top.slow = newSlowDetector(c.options.SlowConsumer, topic, c.clock)
+	top.tracer = c.tracer
+	top.name = topic
+	c.subLive[topic] = top
+	if _, keyed := handler.(KeyedEventHandler); keyed || limits.Ordered {
+		c.ordered.Store(topic, struct{}{})
+	}
 
-	// Send the subscription request
+	// Send the subscription request, keeping it pending if the link is down and
+	// will be re-established
 	err := c.sendSubscribe(topic)
-	if err != nil {
-		c.subLock.Lock()
-		if top, ok := c.subLive[topic]; ok {
-			top.terminate()
-			delete(c.subLive, topic)
-		}
-		c.subLock.Unlock()
+	switch {
+	case err == nil:
+		top.active = true
+	case c.options.Reconnect != nil:
+		logger.Warn("subscription pending until reconnect", "reason", err)
+		err = nil
+	default:
+		top.terminate()
+		delete(c.subLive, topic)
+		c.ordered.Delete(topic)
 	}
+	c.subLock.Unlock()
 	return err
 }
 
@@ -248,16 +600,114 @@ func (c *Connection) Subscribe(topic string, handler TopicHandler, limits *Topic
 //
 // The method blocks until the message is forwarded to the local Iris node.
 func (c *Connection) Publish(topic string, event []byte) error {
-	// Sanity check on the arguments
+	return c.PublishContext(context.Background(), topic, event)
+}
+
+// Publishes an event similarly to Publish, but fails without sending if the
+// context is cancelled before the event is handed to the relay link. A write
+// already in progress is not interrupted.
+func (c *Connection) PublishContext(ctx context.Context, topic string, event []byte) error {
+	if err := c.checkPublish(topic, event); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	// Publish and return
+	c.Log.Debug("publishing new event", "topic", topic, "data", logLazyBlob(event))
+	if c.faults.dropPublish() {
+		c.Log.Debug("fault injected: event dropped", "topic", topic)
+		return nil
+	}
+	event, err := c.seal(topic, event, nil, compressionOf(ctx))
+	if err != nil {
+		return err
+	}
+	if err := c.sendPublish(topic, event); err != nil {
+		return err
+	}
+	atomic.AddUint64(&c.stats.eventSent, 1)
+	return nil
+}
+
+// Publishes an event to topic similarly to Publish, but blocks until the relay
+// acknowledges accepting it, returning the number of subscribers at the time of
+// the publish. Delivery to the subscribers themselves is still best effort.
+//
+// The timeout unit is in milliseconds. Anything lower will fail with an error.
+// Relays not supporting confirmations fail the call with ErrUnsupported.
+func (c *Connection) PublishConfirmed(topic string, event []byte, timeout time.Duration) (int, error) {
+	if err := c.checkPublish(topic, event); err != nil {
+		return 0, err
+	}
+	if timeout < time.Millisecond {
+		return 0, fmt.Errorf("invalid timeout %v < 1ms", timeout)
+	}
+	if !c.supports(relaywire.FeatureConfirm) {
+		return 0, ErrUnsupported
+	}
+	event, err := c.envelope(topic, event, nil)
+	if err != nil {
+		return 0, err
+	}
+	return c.publishConfirmed(context.Background(), topic, event, timeout)
+}
+
+// Publishes an already enveloped event and waits for the relay acknowledgement,
+// the context cancellation or the timeout, whichever comes first.
+func (c *Connection) publishConfirmed(ctx context.Context, topic string, event []byte, timeout time.Duration) (int, error) {
+	// Create an acknowledgement channel for the result
+	ackc := make(chan int, 1)
+
+	c.pubLock.Lock()
+	pubId := c.pubIdx
+	c.pubIdx++
+	c.pubAcks[pubId] = ackc
+	c.pubLock.Unlock()
+
+	defer func() {
+		c.pubLock.Lock()
+		delete(c.pubAcks, pubId)
+		c.pubLock.Unlock()
+	}()
+	// Publish, unless a fault is injected, and wait for the acknowledgement
+	c.Log.Debug("publishing new confirmed event", "local_publish", pubId, "topic", topic, "data", logLazyBlob(event), "timeout", timeout)
+	if c.faults.dropPublish() {
+		c.Log.Debug("fault injected: event dropped", "topic", topic)
+	} else if err := c.sendPublishConfirm(pubId, topic, event); err != nil {
+		return 0, err
+	} else {
+		atomic.AddUint64(&c.stats.eventSent, 1)
+	}
+	select {
+	case <-c.term:
+		return 0, ErrClosed
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case <-c.clock.After(timeout):
+		return 0, ErrTimeout
+	case subs := <-ackc:
+		return subs, nil
+	}
+}
+
+// Validates the arguments of a publish against the sanity checks and the local
+// access policy.
+func (c *Connection) checkPublish(topic string, event []byte) error {
 	if len(topic) == 0 {
 		return errors.New("empty topic identifier")
 	}
 	if event == nil || len(event) == 0 {
 		return errors.New("nil or empty event")
 	}
-	// Publish and return
-	c.Log.Debug("publishing new event", "topic", topic, "data", logLazyBlob(event))
-	return c.sendPublish(topic, event)
+	if err := c.checkSendSize(len(event)); err != nil {
+		return err
+	}
+	if policy := c.options.PublishPolicy; policy != nil && !policy(topic) {
+		c.Log.Warn("publish denied by policy", "topic", topic)
+		return ErrDenied
+	}
+	return c.validate(topic, event, ValidateSend)
 }
 
 // Unsubscribes from topic, receiving no more event notifications for it.
@@ -275,8 +725,12 @@ func (c *Connection) Unsubscribe(topic string) error {
 	}
 	c.subLock.RUnlock()
 
-	// Unsubscribe through the relay and remove if successful
+	// Unsubscribe through the relay and remove if successful (a dropped link that
+	// will be re-established forgets the subscription anyway)
 	err := c.sendUnsubscribe(topic)
+	if err != nil && c.options.Reconnect != nil {
+		err = nil
+	}
 	if err == nil {
 		c.subLock.Lock()
 		defer c.subLock.Unlock()
@@ -286,21 +740,70 @@ func (c *Connection) Unsubscribe(topic string) error {
 		} else {
 			top.terminate()
 			delete(c.subLive, topic)
+			c.ordered.Delete(topic)
 		}
 	}
 	return err
 }
 
+// Adjusts the threading and memory limits of a live subscription. The call
+// blocks until the events queued under the old limits finish running, while new
+// arrivals already run under the new ones.
+func (c *Connection) SetTopicLimits(topic string, limits *TopicLimits) error {
+	limits = finalizeTopicLimits(limits)
+
+	c.subLock.RLock()
+	top, ok := c.subLive[topic]
+	c.subLock.RUnlock()
+
+	if !ok {
+		return errors.New("not subscribed")
+	}
+	top.logger.Info("subscription limits adjusted", "limits", fmt.Sprintf("%dT|%dB", limits.EventThreads, limits.EventMemory))
+	if _, keyed := top.handler.(KeyedEventHandler); keyed || limits.Ordered {
+		c.ordered.Store(topic, struct{}{})
+	} else {
+		c.ordered.Delete(topic)
+	}
+	top.setLimits(limits)
+	return nil
+}
+
 // Opens a direct tunnel to a member of a remote cluster, allowing pairwise-
 // exclusive, order-guaranteed and throttled message passing between them.
 //
 // The method blocks until the newly created tunnel is set up, or the time
 // limit is reached.
 //
-// The timeout unit is in milliseconds. Anything lower will fail with an error.
+// The timeout unit is in milliseconds. Anything lower will fail with an error,
+// unless a default tunnel timeout is configured, which a zero timeout uses.
 func (c *Connection) Tunnel(cluster string, timeout time.Duration) (*Tunnel, error) {
+	return c.TunnelContext(context.Background(), cluster, timeout)
+}
+
+// Opens a direct tunnel similarly to Tunnel, but aborting the construction if
+// the context is cancelled. A tunnel completed after the abort is torn down.
+func (c *Connection) TunnelContext(ctx context.Context, cluster string, timeout time.Duration) (*Tunnel, error) {
 	// Simple call indirection to move into the tunnel source file
-	return c.initTunnel(cluster, timeout)
+	return c.initTunnel(ctx, cluster, nil, c.options.Tunnels, timeout)
+}
+
+// Opens a direct tunnel similarly to Tunnel, attaching an application defined
+// header (e.g. purpose, auth token, content-type) that the remote service can
+// inspect via Tunnel.Header before consuming any data. Relays not supporting
+// tunnel headers fail the call with ErrUnsupported.
+func (c *Connection) TunnelWithHeader(cluster string, header map[string]string, timeout time.Duration) (*Tunnel, error) {
+	if len(header) > 0 && !c.supports(relaywire.FeatureHeader) {
+		return nil, ErrUnsupported
+	}
+	return c.initTunnel(context.Background(), cluster, header, c.options.Tunnels, timeout)
+}
+
+// Opens a direct tunnel similarly to Tunnel, but with its own chunking and flow
+// control settings replacing the connection wide ones (e.g. a large window for
+// a bulk transfer over a high latency link).
+func (c *Connection) TunnelWithOptions(cluster string, options *TunnelOptions, timeout time.Duration) (*Tunnel, error) {
+	return c.initTunnel(context.Background(), cluster, nil, options, timeout)
 }
 
 // Gracefully terminates the connection removing all subscriptions and closing
@@ -309,6 +812,7 @@ func (c *Connection) Tunnel(cluster string, timeout time.Duration) (*Tunnel, err
 // The call blocks until the connection tear-down is confirmed by the Iris node.
 func (c *Connection) Close() error {
 	c.Log.Info("detaching from relay")
+	atomic.StoreInt32(&c.closing, 1)
 
 	// Send a graceful close to the relay node
 	if err := c.sendClose(); err != nil {
@@ -326,5 +830,19 @@ func (c *Connection) Close() error {
 	}
 	c.subLock.Unlock()
 
-	return <-errc
+	err := <-errc
+	c.closeLanes()
+	c.closeAliases()
+	return err
+}
+
+// Terminates the handler pools of a service connection, dropping any queued but
+// unprocessed messages.
+func (c *Connection) terminatePools() {
+	c.limitLock.RLock()
+	bcastPool, reqPool := c.bcastPool, c.reqPool
+	c.limitLock.RUnlock()
+
+	reqPool.Terminate(true)
+	bcastPool.Terminate(true)
 }
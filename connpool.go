@@ -0,0 +1,217 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the pool of pre-established relay connections.
+//
+// Bursty batch jobs connecting on demand pay the relay handshake at the worst
+// possible moment, and funnel all their traffic through a single relay socket.
+// A pool dials its connections up front, hands them out round robin, and probes
+// them periodically in the background, replacing the ones found dead. Pooled
+// connections are shared, not owned by the caller: they are safe for concurrent
+// use, so there is nothing to return after use.
+
+package iris
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Settings of a connection pool. Any unset fields (i.e. value of zero) will
+// default to the preset ones.
+type PoolOptions struct {
+	Connection *Options // Options of the pooled connections
+
+	HealthInterval time.Duration // Interval between probing the pooled connections
+	HealthTimeout  time.Duration // Timeout of a single connection probe
+}
+
+// Default settings of the connection pools.
+var defaultPoolOptions = PoolOptions{
+	HealthInterval: 10 * time.Second,
+	HealthTimeout:  5 * time.Second,
+}
+
+// Pool of pre-established connections to the local relay.
+type Pool struct {
+	port    int         // Relay port to (re)dial the connections to
+	options PoolOptions // Settings of the pool
+
+	conns []*Connection // Pooled connections (nil if dead and not yet replaced)
+	next  uint32        // Index of the next connection to hand out (atomic)
+	lock  sync.RWMutex  // Protects the pooled connections
+
+	quit chan struct{} // Quit channel to stop the health checker
+	done chan struct{} // Signals the termination of the health checker
+}
+
+// Dials a pool of connections to the local relay.
+func NewPool(size int, port int) (*Pool, error) {
+	return NewPoolWithOptions(size, port, nil)
+}
+
+// Dials a pool of connections to the local relay similarly to NewPool, but with
+// custom pool and connection settings.
+func NewPoolWithOptions(size int, port int, options *PoolOptions) (*Pool, error) {
+	// Sanity check on the arguments
+	if size < 1 {
+		return nil, fmt.Errorf("invalid pool size %d < 1", size)
+	}
+	// Merge the user options with the defaults
+	opts := defaultPoolOptions
+	if options != nil {
+		if options.HealthInterval != 0 {
+			opts.HealthInterval = options.HealthInterval
+		}
+		if options.HealthTimeout != 0 {
+			opts.HealthTimeout = options.HealthTimeout
+		}
+		opts.Connection = options.Connection
+	}
+	// Warm up all the connections, failing if any of them can't be established
+	p := &Pool{
+		port:    port,
+		options: opts,
+		conns:   make([]*Connection, size),
+		quit:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	for i := range p.conns {
+		conn, err := ConnectWithOptions(port, opts.Connection)
+		if err != nil {
+			for _, conn := range p.conns[:i] {
+				conn.Close()
+			}
+			return nil, err
+		}
+		p.conns[i] = conn
+	}
+	go p.loop()
+	return p, nil
+}
+
+// Retrieves the next live connection of the pool, round robin. The connection
+// remains owned by the pool and must not be closed by the caller.
+func (p *Pool) Get() (*Connection, error) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	if p.conns == nil {
+		return nil, ErrClosed
+	}
+	for i := 0; i < len(p.conns); i++ {
+		conn := p.conns[int(atomic.AddUint32(&p.next, 1)-1)%len(p.conns)]
+		if conn == nil {
+			continue
+		}
+		select {
+		case <-conn.term:
+			continue
+		default:
+			return conn, nil
+		}
+	}
+	return nil, ErrPoolEmpty
+}
+
+// Retrieves the number of live connections in the pool.
+func (p *Pool) Live() int {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	live := 0
+	for _, conn := range p.conns {
+		if conn != nil {
+			select {
+			case <-conn.term:
+			default:
+				live++
+			}
+		}
+	}
+	return live
+}
+
+// Stops the health checker and closes all the pooled connections.
+func (p *Pool) Close() error {
+	p.lock.Lock()
+	if p.conns == nil {
+		p.lock.Unlock()
+		return ErrClosed
+	}
+	close(p.quit)
+	p.lock.Unlock()
+
+	<-p.done
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	var err error
+	for _, conn := range p.conns {
+		if conn != nil {
+			if cerr := conn.Close(); err == nil {
+				err = cerr
+			}
+		}
+	}
+	p.conns = nil
+	return err
+}
+
+// Probes the pooled connections periodically, replacing the dead ones.
+func (p *Pool) loop() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(p.options.HealthInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.quit:
+			return
+		case <-ticker.C:
+		}
+		for i := range p.conns {
+			p.check(i)
+		}
+	}
+}
+
+// Probes a single pooled connection, replacing it if dead or missing.
+func (p *Pool) check(index int) {
+	p.lock.RLock()
+	conn := p.conns[index]
+	p.lock.RUnlock()
+
+	if conn != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), p.options.HealthTimeout)
+		_, err := conn.Health(ctx)
+		cancel()
+
+		if err == nil {
+			return
+		}
+		Log.Warn("replacing unhealthy pooled connection", "relay_port", p.port, "reason", err)
+		go conn.Close()
+
+		p.lock.Lock()
+		p.conns[index] = nil
+		p.lock.Unlock()
+	}
+	// Dial a replacement, retrying on the next round if that fails too
+	conn, err := ConnectWithOptions(p.port, p.options.Connection)
+	if err != nil {
+		Log.Warn("failed to replace pooled connection", "relay_port", p.port, "reason", err)
+		return
+	}
+	p.lock.Lock()
+	p.conns[index] = conn
+	p.lock.Unlock()
+}
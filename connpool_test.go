@@ -0,0 +1,62 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"testing"
+	"time"
+)
+
+// Tests that pooled connections are handed out round robin, and dead ones are
+// skipped and replaced.
+func TestPool(t *testing.T) {
+	pool, err := NewPoolWithOptions(3, config.relay, &PoolOptions{HealthInterval: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("pool creation failed: %v.", err)
+	}
+	// Ensure the connections are handed out evenly
+	seen := make(map[*Connection]int)
+	for i := 0; i < 6; i++ {
+		conn, err := pool.Get()
+		if err != nil {
+			t.Fatalf("checkout %d failed: %v.", i, err)
+		}
+		seen[conn]++
+	}
+	if len(seen) != 3 {
+		t.Fatalf("distinct connection count mismatch: have %d, want %d.", len(seen), 3)
+	}
+	for conn, count := range seen {
+		if count != 2 {
+			t.Fatalf("connection %p checkout count mismatch: have %d, want %d.", conn, count, 2)
+		}
+	}
+	// Kill a connection and ensure it's skipped, then replaced
+	dead, _ := pool.Get()
+	dead.Close()
+
+	if live := pool.Live(); live != 2 {
+		t.Fatalf("live connection count mismatch: have %d, want %d.", live, 2)
+	}
+	for i := 0; i < 6; i++ {
+		if conn, err := pool.Get(); err != nil || conn == dead {
+			t.Fatalf("checkout %d returned dead connection: %v.", i, err)
+		}
+	}
+	for start := time.Now(); pool.Live() != 3; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatalf("dead connection not replaced: live %d.", pool.Live())
+		}
+	}
+	// Close the pool and ensure it refuses to hand out connections
+	if err := pool.Close(); err != nil {
+		t.Fatalf("pool close failed: %v.", err)
+	}
+	if _, err := pool.Get(); err != ErrClosed {
+		t.Fatalf("checkout after close error mismatch: have %v, want %v.", err, ErrClosed)
+	}
+}
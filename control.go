@@ -0,0 +1,77 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the prioritization of the control frames on the relay link.
+//
+// Control frames - tunnel flow control, tunnel setup and teardown, and the
+// subscription changes - are tiny, but the remote side may stall waiting for
+// them. Under bulk load they would queue up behind any number of large data
+// frames waiting for the socket, and then linger in the write buffer until the
+// last pending writer flushes it. Control senders thus jump ahead of the data
+// senders waiting and always flush right away. A frame already being written
+// is never interrupted, as the relay link is a single ordered stream.
+//
+// The connection close is deliberately not a control frame, letting the data
+// already waiting for the socket go out before it.
+
+package iris
+
+import "sync"
+
+// Mutual exclusion lock granting itself to urgent waiters before normal ones.
+type priorityLock struct {
+	held   bool       // Whether the lock is currently taken
+	urgent int        // Number of urgent waiters
+	lock   sync.Mutex // Protects the lock state
+	fast   sync.Cond  // Signals the urgent waiters of a release
+	slow   sync.Cond  // Signals the normal waiters of a release
+}
+
+// Acquires the lock as a normal waiter, yielding to all urgent ones.
+func (p *priorityLock) Lock() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.init()
+	for p.held || p.urgent > 0 {
+		p.slow.Wait()
+	}
+	p.held = true
+}
+
+// Acquires the lock as an urgent waiter, overtaking all normal ones.
+func (p *priorityLock) LockUrgent() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.init()
+	p.urgent++
+	for p.held {
+		p.fast.Wait()
+	}
+	p.urgent--
+	p.held = true
+}
+
+// Releases the lock, handing it to an urgent waiter if there's any.
+func (p *priorityLock) Unlock() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.held = false
+	if p.urgent > 0 {
+		p.fast.Signal()
+	} else {
+		p.slow.Signal()
+	}
+}
+
+// Binds the condition variables to the state lock, so the zero value is usable.
+func (p *priorityLock) init() {
+	if p.fast.L == nil {
+		p.fast.L, p.slow.L = &p.lock, &p.lock
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"testing"
+	"time"
+)
+
+// Tests that urgent waiters of a priority lock overtake the normal ones.
+func TestPriorityLock(t *testing.T) {
+	var lock priorityLock
+	lock.Lock()
+
+	// Queue up a few normal waiters, followed by an urgent one
+	order := make(chan string, 4)
+	for i := 0; i < 3; i++ {
+		go func() {
+			lock.Lock()
+			order <- "normal"
+			lock.Unlock()
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	go func() {
+		lock.LockUrgent()
+		order <- "urgent"
+		lock.Unlock()
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	// Release the lock and verify the urgent waiter went first
+	lock.Unlock()
+	if first := <-order; first != "urgent" {
+		t.Fatalf("first acquirer mismatch: have %s, want %s.", first, "urgent")
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case <-order:
+		case <-time.After(time.Second):
+			t.Fatalf("normal waiter #%d starved.", i)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the generation and context propagation of request correlation IDs.
+
+package iris
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// Context key under which the correlation ID is stored.
+type correlationKey struct{}
+
+// Creates a child context carrying a correlation ID, which requests issued via
+// RequestContext will propagate to the remote handlers.
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationKey{}, id)
+}
+
+// Retrieves the correlation ID carried by a context, or an empty string if none.
+func CorrelationID(ctx context.Context) string {
+	id, _ := ctx.Value(correlationKey{}).(string)
+	return id
+}
+
+// Generates a new random correlation ID.
+func newCorrelationID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// Service handler for the correlation tests, forwarding requests to the next
+// cluster if any, and reporting the correlation IDs seen.
+type correlationTestHandler struct {
+	conn *Connection
+	next string
+	ids  chan string
+}
+
+func (c *correlationTestHandler) Init(conn *Connection) error              { c.conn = conn; return nil }
+func (c *correlationTestHandler) HandleBroadcast(msg []byte)               { panic("not implemented") }
+func (c *correlationTestHandler) HandleRequest(req []byte) ([]byte, error) { panic("not implemented") }
+func (c *correlationTestHandler) HandleTunnel(tun *Tunnel)                 { panic("not implemented") }
+func (c *correlationTestHandler) HandleDrop(reason error)                  { panic("not implemented") }
+
+func (c *correlationTestHandler) HandleRequestWithMetadata(req []byte, meta *Metadata) ([]byte, error) {
+	c.ids <- meta.CorrelationID
+	if c.next == "" {
+		return req, nil
+	}
+	return c.conn.RequestContext(meta.Context(), c.next, req, time.Second)
+}
+
+// Tests that correlation IDs are generated and propagated into nested requests.
+func TestCorrelation(t *testing.T) {
+	// Register a front and a back service, the former forwarding to the latter
+	front := &correlationTestHandler{next: config.cluster + "-back", ids: make(chan string, 2)}
+	back := &correlationTestHandler{ids: make(chan string, 2)}
+
+	frontServ, err := Register(config.relay, config.cluster, front, nil)
+	if err != nil {
+		t.Fatalf("front registration failed: %v.", err)
+	}
+	defer frontServ.Unregister()
+
+	backServ, err := Register(config.relay, config.cluster+"-back", back, nil)
+	if err != nil {
+		t.Fatalf("back registration failed: %v.", err)
+	}
+	defer backServ.Unregister()
+
+	// Issue a request with an explicit correlation ID
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	ctx := WithCorrelationID(context.Background(), "explicit")
+	if _, err := conn.RequestContext(ctx, config.cluster, []byte{0x00}, time.Second); err != nil {
+		t.Fatalf("request failed: %v.", err)
+	}
+	if id := <-front.ids; id != "explicit" {
+		t.Fatalf("front correlation mismatch: have %q, want %q.", id, "explicit")
+	}
+	if id := <-back.ids; id != "explicit" {
+		t.Fatalf("back correlation mismatch: have %q, want %q.", id, "explicit")
+	}
+	// Issue a request through a metadata enabled client and check generation
+	auto, err := ConnectWithOptions(config.relay, &Options{Metadata: true})
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer auto.Close()
+
+	if _, err := auto.Request(config.cluster, []byte{0x00}, time.Second); err != nil {
+		t.Fatalf("request failed: %v.", err)
+	}
+	id := <-front.ids
+	if id == "" {
+		t.Fatalf("no correlation id generated.")
+	}
+	if back := <-back.ids; back != id {
+		t.Fatalf("back correlation mismatch: have %q, want %q.", back, id)
+	}
+}
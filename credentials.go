@@ -0,0 +1,90 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the credentials presented to access controlled relays during the
+// connection handshake.
+
+package iris
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// Credential kinds understood by access controlled relays.
+const (
+	CredentialToken     = "token"   // Opaque application token
+	CredentialSignature = "ed25519" // Public key, timestamp and signature triplet
+)
+
+// Source of the credential presented to the relay during the handshake.
+type Credentials interface {
+	// Generates the credential kind and blob to authenticate the given cluster
+	// (empty for simple clients) with.
+	Credential(cluster string) (kind string, credential []byte, err error)
+}
+
+// Credentials presenting a fixed application token.
+type tokenCredentials string
+
+// Creates credentials presenting a fixed application token to the relay.
+func TokenCredentials(token string) Credentials {
+	return tokenCredentials(token)
+}
+
+func (t tokenCredentials) Credential(cluster string) (string, []byte, error) {
+	return CredentialToken, []byte(t), nil
+}
+
+// Credentials signing the handshake with an ed25519 private key.
+type signatureCredentials ed25519.PrivateKey
+
+// Creates credentials proving the ownership of an ed25519 key to the relay by
+// signing the cluster name and the current time.
+func SignatureCredentials(key ed25519.PrivateKey) Credentials {
+	return signatureCredentials(key)
+}
+
+func (s signatureCredentials) Credential(cluster string) (string, []byte, error) {
+	key := ed25519.PrivateKey(s)
+	if len(key) != ed25519.PrivateKeySize {
+		return "", nil, errors.New("invalid ed25519 private key")
+	}
+	stamp := make([]byte, 8)
+	binary.BigEndian.PutUint64(stamp, uint64(time.Now().Unix()))
+
+	credential := append([]byte{}, key.Public().(ed25519.PublicKey)...)
+	credential = append(credential, stamp...)
+	return CredentialSignature, append(credential, ed25519.Sign(key, signedHandshake(cluster, stamp))...), nil
+}
+
+// Assembles the handshake content signed by the signature credentials.
+func signedHandshake(cluster string, stamp []byte) []byte {
+	return append([]byte(clientMagic+"\x00"+cluster+"\x00"), stamp...)
+}
+
+// Verifies a signature credential presented for a cluster, allowing at most
+// skew difference between the signing time and now. On success the public key
+// of the signer is returned for the relay to authorize.
+func VerifySignatureCredential(cluster string, credential []byte, skew time.Duration) (ed25519.PublicKey, error) {
+	if len(credential) != ed25519.PublicKeySize+8+ed25519.SignatureSize {
+		return nil, errors.New("malformed signature credential")
+	}
+	key := ed25519.PublicKey(credential[:ed25519.PublicKeySize])
+	stamp := credential[ed25519.PublicKeySize : ed25519.PublicKeySize+8]
+	sig := credential[ed25519.PublicKeySize+8:]
+
+	signed := time.Unix(int64(binary.BigEndian.Uint64(stamp)), 0)
+	if diff := time.Since(signed); diff > skew || diff < -skew {
+		return nil, errors.New("signature timestamp out of range")
+	}
+	if !ed25519.Verify(key, signedHandshake(cluster, stamp), sig) {
+		return nil, errors.New("invalid signature")
+	}
+	return key, nil
+}
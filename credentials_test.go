@@ -0,0 +1,75 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+
+	"gopkg.in/project-iris/iris-go.v1/sim"
+)
+
+// Tests that credentials are presented during the handshake and that refused
+// ones surface as authentication errors.
+func TestCredentials(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v.", err)
+	}
+	// Start an access controlled relay
+	relay, err := sim.NewRelay(&sim.Config{
+		Authenticate: func(cluster string, kind string, credential []byte) error {
+			switch kind {
+			case CredentialToken:
+				if string(credential) != "secret" {
+					return errors.New("invalid token")
+				}
+				return nil
+			case CredentialSignature:
+				key, err := VerifySignatureCredential(cluster, credential, time.Minute)
+				if err != nil {
+					return err
+				}
+				if !bytes.Equal(key, public) {
+					return errors.New("unknown key")
+				}
+				return nil
+			}
+			return errors.New("unsupported credential")
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to start relay: %v.", err)
+	}
+	defer relay.Close()
+
+	// Ensure valid credentials are accepted
+	conn, err := ConnectWithOptions(relay.Port(), &Options{Credentials: TokenCredentials("secret")})
+	if err != nil {
+		t.Fatalf("token connection failed: %v.", err)
+	}
+	conn.Close()
+
+	serv, err := RegisterWithOptions(relay.Port(), config.cluster, new(requestTestHandler), nil, &Options{Credentials: SignatureCredentials(private)})
+	if err != nil {
+		t.Fatalf("signature registration failed: %v.", err)
+	}
+	serv.Unregister()
+
+	// Ensure invalid and missing credentials are refused
+	for i, options := range []*Options{{Credentials: TokenCredentials("guess")}, nil} {
+		if _, err := ConnectWithOptions(relay.Port(), options); err == nil {
+			t.Fatalf("test %d: unauthenticated connection succeeded.", i)
+		} else if _, ok := err.(*AuthError); !ok {
+			t.Fatalf("test %d: error type mismatch: have %T, want %T.", i, err, &AuthError{})
+		}
+	}
+}
@@ -0,0 +1,280 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Package cron contains a coordinator of periodic tasks, running each scheduled
+// occurrence of a named task on exactly one member of a cluster, replacing an
+// external cron daemon guarded by distributed locks.
+//
+// The members scheduling the same task elect a leader among themselves: each
+// announces its presence on the task's topic periodically, and the live member
+// that joined the earliest leads (so newcomers never preempt the leader), a
+// member being live until its lease (a few missed announcements) expires. Only the leader runs the task, claiming every slot
+// (a multiple of the task period since the Unix epoch) in an announcement before
+// running it, so a newly elected leader doesn't run it again.
+//
+// Slots that passed without any member claiming them, e.g. while a crashed
+// leader's lease was expiring, are detected by the next leader and reported as
+// missed; they are not run retroactively.
+package cron
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"gopkg.in/project-iris/iris-go.v1"
+)
+
+// Prefix of the topics used to exchange the leadership announcements.
+var topicPrefix = "iris-cron:"
+
+// Periodic task to run on a single member of the cluster.
+type Task struct {
+	Name  string                     // Name of the task, unique across the fabric
+	Every time.Duration              // Period of the task, aligned to the Unix epoch
+	Run   func(slot time.Time) error // Callback executing a scheduled occurrence
+}
+
+// Settings of a task scheduler. Any unset fields (i.e. value of zero) will
+// default to the preset ones.
+type Config struct {
+	Heartbeat time.Duration // Interval between announcing the member's presence
+	Lease     time.Duration // Time after which a silent member is deemed gone
+
+	OnMissed func(from time.Time, count int) // Callback notified of the skipped slots
+}
+
+// Default settings of the task schedulers.
+var defaultConfig = Config{
+	Heartbeat: time.Second,
+	Lease:     3 * time.Second,
+}
+
+// Counters of a task scheduler.
+type Stats struct {
+	Leader   bool      // Whether the local member currently leads
+	Runs     uint64    // Occurrences run by the local member
+	Failures uint64    // Occurrences run locally that returned an error
+	Missed   uint64    // Slots detected as skipped by the local member
+	LastSlot time.Time // Last slot claimed by any member (zero if unknown)
+}
+
+// Remote member of a task's cluster, as known from its announcements.
+type member struct {
+	joined int64     // Time the member joined, in nanoseconds since the epoch
+	expire time.Time // Expiration of the member's lease
+}
+
+// Member of a periodic task's cluster, running the task while leading.
+type Scheduler struct {
+	conn   *iris.Connection // Connection to announce through
+	task   Task             // Task to run periodically
+	config Config           // Settings of the scheduler
+	topic  string           // Topic to exchange the announcements on
+	self   string           // Unique id of the local member
+	start  time.Time        // Time of joining, to wait out a lease before leading
+
+	members map[string]*member // Live members of the task's cluster
+	last    int64              // Last slot claimed by any member (zero if unknown)
+	stats   Stats              // Counters of the scheduler
+	lock    sync.Mutex         // Protects the membership and the counters
+
+	runs sync.WaitGroup // Occurrences currently running
+	quit chan struct{}  // Quit channel to stop the announcer
+	done chan struct{}  // Signals the announcer's termination
+}
+
+// Joins the cluster of a periodic task, running it whenever elected leader.
+func New(conn *iris.Connection, task *Task, config *Config) (*Scheduler, error) {
+	// Sanity check on the arguments
+	if conn == nil {
+		return nil, errors.New("nil connection")
+	}
+	if task == nil || len(task.Name) == 0 {
+		return nil, errors.New("empty task name")
+	}
+	if task.Every <= 0 {
+		return nil, fmt.Errorf("invalid task period %v", task.Every)
+	}
+	if task.Run == nil {
+		return nil, errors.New("nil task callback")
+	}
+	// Merge the user configs with the defaults
+	conf := defaultConfig
+	if config != nil {
+		if config.Heartbeat != 0 {
+			conf.Heartbeat = config.Heartbeat
+		}
+		if config.Lease != 0 {
+			conf.Lease = config.Lease
+		}
+		conf.OnMissed = config.OnMissed
+	}
+	if conf.Lease <= conf.Heartbeat {
+		return nil, fmt.Errorf("lease %v not above heartbeat %v", conf.Lease, conf.Heartbeat)
+	}
+	// Generate a unique id for the local member
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	s := &Scheduler{
+		conn:    conn,
+		task:    *task,
+		config:  conf,
+		topic:   topicPrefix + task.Name,
+		self:    hex.EncodeToString(id),
+		start:   time.Now(),
+		members: make(map[string]*member),
+		quit:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if err := conn.Subscribe(s.topic, eventHandler(s.handleEvent), nil); err != nil {
+		return nil, err
+	}
+	go s.loop()
+	return s, nil
+}
+
+// Leaves the cluster of the task, waiting for any local occurrence to finish.
+func (s *Scheduler) Close() error {
+	close(s.quit)
+	<-s.done
+	s.runs.Wait()
+
+	return s.conn.Unsubscribe(s.topic)
+}
+
+// Retrieves the counters of the scheduler.
+func (s *Scheduler) Stats() Stats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	stats := s.stats
+	if s.last != 0 {
+		stats.LastSlot = time.Unix(0, s.last*int64(s.task.Every))
+	}
+	return stats
+}
+
+// Announces the local member and runs the due slots while leading.
+func (s *Scheduler) loop() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.config.Heartbeat)
+	defer ticker.Stop()
+
+	for {
+		s.announce(0)
+
+		// Run the current slot if leading and nobody claimed it yet
+		now := time.Now()
+		if slot, missed, ok := s.claim(now); ok {
+			s.announce(slot)
+			if missed > 0 && s.config.OnMissed != nil {
+				s.config.OnMissed(time.Unix(0, (slot-int64(missed))*int64(s.task.Every)), missed)
+			}
+			s.runs.Add(1)
+			go s.run(slot)
+		}
+		// Wait for the next heartbeat or slot boundary
+		next := time.Unix(0, (now.UnixNano()/int64(s.task.Every)+1)*int64(s.task.Every))
+		timer := time.NewTimer(time.Until(next))
+
+		select {
+		case <-s.quit:
+			timer.Stop()
+			return
+		case <-ticker.C:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// Publishes the presence of the local member, along with a claimed slot if any.
+func (s *Scheduler) announce(slot int64) {
+	if err := s.conn.Publish(s.topic, []byte(fmt.Sprintf("beat %d %d %s", slot, s.start.UnixNano(), s.self))); err != nil {
+		s.conn.Log.Warn("failed to announce task member", "task", s.task.Name, "reason", err)
+	}
+}
+
+// Claims the current slot if the local member leads and it wasn't run yet,
+// returning it along with the number of slots skipped before it.
+func (s *Scheduler) claim(now time.Time) (int64, int, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// Drop the expired members and check for leadership
+	for id, member := range s.members {
+		if now.After(member.expire) {
+			delete(s.members, id)
+		}
+	}
+	s.stats.Leader = now.Sub(s.start) >= s.config.Lease
+	for id, member := range s.members {
+		if member.joined < s.start.UnixNano() || (member.joined == s.start.UnixNano() && id < s.self) {
+			s.stats.Leader = false
+		}
+	}
+	slot := now.UnixNano() / int64(s.task.Every)
+	if !s.stats.Leader || slot <= s.last {
+		return 0, 0, false
+	}
+	// Claim the slot, counting the skipped ones if the history is known
+	missed := 0
+	if s.last != 0 {
+		missed = int(slot - s.last - 1)
+	}
+	s.last = slot
+	s.stats.Missed += uint64(missed)
+	return slot, missed, true
+}
+
+// Runs a claimed slot of the task, accounting its outcome.
+func (s *Scheduler) run(slot int64) {
+	defer s.runs.Done()
+
+	err := s.task.Run(time.Unix(0, slot*int64(s.task.Every)))
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.stats.Runs++
+	if err != nil {
+		s.stats.Failures++
+		s.conn.Log.Warn("scheduled task failed", "task", s.task.Name, "reason", err)
+	}
+}
+
+// Topic handler forwarding the announcements into a callback.
+type eventHandler func(event []byte)
+
+func (h eventHandler) HandleEvent(event []byte) { h(event) }
+
+// Callback invoked whenever a member announces itself or a claimed slot.
+func (s *Scheduler) handleEvent(event []byte) {
+	var (
+		slot   int64
+		joined int64
+		id     string
+	)
+	if n, _ := fmt.Sscanf(string(event), "beat %d %d %s", &slot, &joined, &id); n < 3 {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if id != s.self {
+		s.members[id] = &member{joined: joined, expire: time.Now().Add(s.config.Lease)}
+	}
+	if slot > s.last {
+		s.last = slot
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package cron
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"gopkg.in/project-iris/iris-go.v1"
+)
+
+// Port of the local relay to run the tests against.
+var relay = 55555
+
+// Tests that every slot of a task is run by exactly one member of the cluster.
+func TestScheduler(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		members int
+		every   time.Duration
+		runtime time.Duration
+	}{3, 50 * time.Millisecond, time.Second}
+
+	var lock sync.Mutex
+	runs := make(map[time.Time]int)
+
+	task := &Task{
+		Name:  "test-cron",
+		Every: conf.every,
+		Run: func(slot time.Time) error {
+			lock.Lock()
+			defer lock.Unlock()
+
+			runs[slot]++
+			return nil
+		},
+	}
+	config := &Config{Heartbeat: 10 * time.Millisecond, Lease: 100 * time.Millisecond}
+
+	scheds := make([]*Scheduler, conf.members)
+	for i := 0; i < conf.members; i++ {
+		conn, err := iris.Connect(relay)
+		if err != nil {
+			t.Fatalf("connection %d failed: %v.", i, err)
+		}
+		defer conn.Close()
+
+		if scheds[i], err = New(conn, task, config); err != nil {
+			t.Fatalf("scheduler %d creation failed: %v.", i, err)
+		}
+		defer scheds[i].Close()
+	}
+	time.Sleep(conf.runtime)
+
+	// Ensure a single leader ran all the slots exactly once
+	leaders := 0
+	for _, sched := range scheds {
+		if sched.Stats().Leader {
+			leaders++
+		}
+	}
+	if leaders != 1 {
+		t.Fatalf("leader count mismatch: have %d, want 1.", leaders)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+
+	if len(runs) < int(conf.runtime/conf.every)/2 {
+		t.Fatalf("too few slots run: have %d, want at least %d.", len(runs), int(conf.runtime/conf.every)/2)
+	}
+	for slot, count := range runs {
+		if count != 1 {
+			t.Fatalf("slot %v run count mismatch: have %d, want 1.", slot, count)
+		}
+	}
+}
+
+// Tests that leadership fails over when the leader leaves, reporting the slots
+// skipped meanwhile.
+func TestSchedulerFailover(t *testing.T) {
+	missed := make(chan int, 4)
+
+	task := &Task{
+		Name:  "test-cron-failover",
+		Every: 20 * time.Millisecond,
+		Run:   func(slot time.Time) error { return nil },
+	}
+	config := &Config{
+		Heartbeat: 10 * time.Millisecond,
+		Lease:     100 * time.Millisecond,
+		OnMissed:  func(from time.Time, count int) { missed <- count },
+	}
+	scheds := make([]*Scheduler, 2)
+	for i := 0; i < len(scheds); i++ {
+		conn, err := iris.Connect(relay)
+		if err != nil {
+			t.Fatalf("connection %d failed: %v.", i, err)
+		}
+		defer conn.Close()
+
+		if scheds[i], err = New(conn, task, config); err != nil {
+			t.Fatalf("scheduler %d creation failed: %v.", i, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(250 * time.Millisecond)
+
+	// The earliest member should lead, the other should take over when it leaves
+	if !scheds[0].Stats().Leader || scheds[1].Stats().Leader {
+		t.Fatalf("leadership mismatch: have %v/%v, want true/false.", scheds[0].Stats().Leader, scheds[1].Stats().Leader)
+	}
+	if err := scheds[0].Close(); err != nil {
+		t.Fatalf("leader close failed: %v.", err)
+	}
+	defer scheds[1].Close()
+
+	select {
+	case count := <-missed:
+		if count < 1 {
+			t.Fatalf("missed slot count mismatch: have %d, want positive.", count)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("missed slots not reported.")
+	}
+	if stats := scheds[1].Stats(); !stats.Leader || stats.Missed == 0 || stats.LastSlot.IsZero() {
+		t.Fatalf("failover stats mismatch: leader %v, missed %d, last slot %v.", stats.Leader, stats.Missed, stats.LastSlot)
+	}
+}
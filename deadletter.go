@@ -0,0 +1,97 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the dead-letter routing of failed inbound messages.
+//
+// Iris never redelivers a message, so an inbound one is considered dead as soon
+// as its processing fails: a request handler returns an error, a request expires
+// while queued, or a message doesn't fit into its queue's memory allowance.
+// Dead messages are handed to the dead-letter callback and/or republished to the
+// dead-letter topic, with the failure details attached as envelope headers.
+
+package iris
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// Kinds of the dead-lettered messages.
+const (
+	DeadBroadcast = "broadcast"
+	DeadRequest   = "request"
+	DeadEvent     = "event"
+)
+
+// Envelope headers carrying the failure details of republished dead letters.
+const (
+	headerDeadKind   = "iris.dead.kind"
+	headerDeadSource = "iris.dead.source"
+	headerDeadReason = "iris.dead.reason"
+)
+
+// Inbound message whose processing failed.
+type DeadLetter struct {
+	Kind    string    // Kind of the message (DeadBroadcast, DeadRequest or DeadEvent)
+	Source  string    // Cluster or topic the message arrived through
+	Message []byte    // Payload of the failed message
+	Meta    *Metadata // Delivery metadata of the failed message
+	Reason  error     // Failure that killed the message
+}
+
+// Callback receiving the inbound messages whose processing failed.
+type DeadLetterHandler func(letter *DeadLetter)
+
+// Routes a failed inbound message to the dead-letter callback and topic, if
+// any were configured. Neither is invoked inline, to not stall the receiver.
+func (c *Connection) deadLetter(kind string, source string, message []byte, meta *Metadata, reason error) {
+	if c.options.DeadLetter == nil && c.options.DeadLetterTopic == "" {
+		return
+	}
+	// Don't loop on failures of the dead-letter topic itself
+	if kind == DeadEvent && source == c.options.DeadLetterTopic {
+		c.Log.Warn("dropping failed dead letter", "reason", reason)
+		return
+	}
+	atomic.AddUint64(&c.stats.deadLetters, 1)
+	letter := &DeadLetter{Kind: kind, Source: source, Message: message, Meta: meta, Reason: reason}
+
+	if handler := c.options.DeadLetter; handler != nil {
+		go handler(letter)
+	}
+	if topic := c.options.DeadLetterTopic; topic != "" {
+		go func() {
+			if err := c.publishDeadLetter(topic, letter); err != nil {
+				c.Log.Error("failed to publish dead letter", "topic", topic, "reason", err)
+				c.reportError("failed to publish dead letter to %s: %v", topic, err)
+			}
+		}()
+	}
+}
+
+// Republishes a dead letter to a topic, attaching the failure details.
+func (c *Connection) publishDeadLetter(topic string, letter *DeadLetter) error {
+	if len(letter.Message) == 0 {
+		return errors.New("nil or empty message")
+	}
+	headers := map[string]string{
+		headerDeadKind:   letter.Kind,
+		headerDeadSource: letter.Source,
+		headerDeadReason: letter.Reason.Error(),
+	}
+	if letter.Meta != nil && letter.Meta.CorrelationID != "" {
+		headers[headerCorrelation] = letter.Meta.CorrelationID
+	}
+	event, err := c.envelope(topic, letter.Message, headers)
+	if err != nil {
+		return err
+	}
+	if err := c.sendPublish(topic, event); err != nil {
+		return err
+	}
+	atomic.AddUint64(&c.stats.eventSent, 1)
+	return nil
+}
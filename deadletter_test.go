@@ -0,0 +1,85 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+// Service handler failing all requests for the dead-letter tests.
+type deadLetterTestHandler struct{}
+
+func (d *deadLetterTestHandler) Init(conn *Connection) error { return nil }
+func (d *deadLetterTestHandler) HandleBroadcast(msg []byte)  { panic("not implemented") }
+func (d *deadLetterTestHandler) HandleRequest(req []byte) ([]byte, error) {
+	return nil, errors.New("boom")
+}
+func (d *deadLetterTestHandler) HandleTunnel(tun *Tunnel) { panic("not implemented") }
+func (d *deadLetterTestHandler) HandleDrop(reason error)  { panic("not implemented") }
+
+// Tests that failed requests are routed to the dead-letter callback and topic.
+func TestDeadLetter(t *testing.T) {
+	// Subscribe to the dead-letter topic
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	monitor := &metadataTestHandler{
+		metas: make(chan *Metadata, 1),
+	}
+	if err := conn.Subscribe(config.topic, monitor, nil); err != nil {
+		t.Fatalf("subscription failed: %v.", err)
+	}
+	defer conn.Unsubscribe(config.topic)
+	time.Sleep(100 * time.Millisecond)
+
+	// Register a failing service with dead-letter handling
+	letters := make(chan *DeadLetter, 1)
+	serv, err := RegisterWithOptions(config.relay, config.cluster, new(deadLetterTestHandler), nil, &Options{
+		DeadLetter:      func(letter *DeadLetter) { letters <- letter },
+		DeadLetterTopic: config.topic,
+	})
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	if _, err := conn.Request(config.cluster, []byte("request"), time.Second); err == nil {
+		t.Fatalf("failing request succeeded.")
+	}
+	// Verify both the callback and the topic received the dead letter
+	select {
+	case letter := <-letters:
+		if letter.Kind != DeadRequest || letter.Source != config.cluster || letter.Reason.Error() != "boom" {
+			t.Fatalf("dead letter mismatch: have %+v.", letter)
+		}
+		if !bytes.Equal(letter.Message, []byte("request")) {
+			t.Fatalf("dead letter payload mismatch: have %q, want %q.", letter.Message, "request")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("dead letter callback timed out.")
+	}
+	select {
+	case meta := <-monitor.metas:
+		if kind := meta.Headers[headerDeadKind]; kind != DeadRequest {
+			t.Fatalf("dead letter kind mismatch: have %q, want %q.", kind, DeadRequest)
+		}
+		if reason := meta.Headers[headerDeadReason]; reason != "boom" {
+			t.Fatalf("dead letter reason mismatch: have %q, want %q.", reason, "boom")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("dead letter publish timed out.")
+	}
+	if dead := serv.conn.Stats().DeadLetters; dead != 1 {
+		t.Fatalf("dead letter count mismatch: have %d, want %d.", dead, 1)
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the nearest deadline first scheduling of inbound requests.
+//
+// Under overload, running the queued requests in arrival order wastes handler
+// time on requests whose callers are about to give up. Serving the nearest
+// deadline first maximizes the number of replies arriving in time, while the
+// requests already expired sort to the front too, and are dropped without
+// involving the handler.
+
+package iris
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// Task queue serving the pending tasks in the order of their deadlines.
+type deadlineQueue struct {
+	tasks deadlineHeap // Pending tasks, nearest deadline first
+	index uint64       // Arrival index of the next task, breaking ties
+	lock  sync.Mutex   // Protects the queue internals
+}
+
+// Task waiting in the deadline queue.
+type deadlineTask struct {
+	deadline time.Time // Time after which the task is useless
+	index    uint64    // Arrival index, keeping equal deadlines in order
+	run      func()    // Task to execute
+}
+
+// Creates a new, empty deadline task queue.
+func newDeadlineQueue() *deadlineQueue {
+	return new(deadlineQueue)
+}
+
+// Enqueues a new task to be completed before the given deadline.
+func (q *deadlineQueue) push(deadline time.Time, task func()) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	heap.Push(&q.tasks, &deadlineTask{deadline: deadline, index: q.index, run: task})
+	q.index++
+}
+
+// Dequeues the task with the nearest deadline. Returns nil if the queue is empty.
+func (q *deadlineQueue) pop() func() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if len(q.tasks) == 0 {
+		return nil
+	}
+	return heap.Pop(&q.tasks).(*deadlineTask).run
+}
+
+// Min-heap of deadline tasks, implementing heap.Interface.
+type deadlineHeap []*deadlineTask
+
+func (h deadlineHeap) Len() int      { return len(h) }
+func (h deadlineHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h deadlineHeap) Less(i, j int) bool {
+	if h[i].deadline.Equal(h[j].deadline) {
+		return h[i].index < h[j].index
+	}
+	return h[i].deadline.Before(h[j].deadline)
+}
+
+func (h *deadlineHeap) Push(x interface{}) {
+	*h = append(*h, x.(*deadlineTask))
+}
+
+func (h *deadlineHeap) Pop() interface{} {
+	old := *h
+	task := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return task
+}
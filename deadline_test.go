@@ -0,0 +1,122 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"testing"
+	"time"
+)
+
+// Tests that the deadline queue serves the nearest deadlines first, keeping the
+// arrival order of equal ones.
+func TestDeadlineQueue(t *testing.T) {
+	queue := newDeadlineQueue()
+
+	base := time.Now()
+	order := []string{}
+	for _, task := range []struct {
+		name   string
+		offset time.Duration
+	}{
+		{"late", 3 * time.Second},
+		{"soon-1", time.Second},
+		{"expired", -time.Second},
+		{"soon-2", time.Second},
+		{"medium", 2 * time.Second},
+	} {
+		name := task.name
+		queue.push(base.Add(task.offset), func() { order = append(order, name) })
+	}
+	for task := queue.pop(); task != nil; task = queue.pop() {
+		task()
+	}
+	want := []string{"expired", "soon-1", "soon-2", "medium", "late"}
+	if len(order) != len(want) {
+		t.Fatalf("serving order length mismatch: have %v, want %v.", order, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("serving order mismatch: have %v, want %v.", order, want)
+		}
+	}
+}
+
+// Tests that queued requests are served nearest deadline first, skipping the
+// ones that expired while waiting.
+func TestRequestDeadlines(t *testing.T) {
+	handler := &deadlineTestHandler{
+		block: make(chan struct{}),
+		order: make(chan string, 8),
+	}
+	limits := &ServiceLimits{RequestThreads: 1}
+	serv, err := RegisterWithOptions(config.relay, config.cluster, handler, limits, &Options{Deadlines: true})
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Occupy the only handler thread, and queue up requests with varying timeouts
+	go conn.Request(config.cluster, []byte("block"), time.Second)
+	time.Sleep(50 * time.Millisecond)
+
+	for _, req := range []struct {
+		name    string
+		timeout time.Duration
+	}{
+		{"late", time.Second},
+		{"expired", 50 * time.Millisecond},
+		{"soon", 500 * time.Millisecond},
+	} {
+		go conn.Request(config.cluster, []byte(req.name), req.timeout)
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+	close(handler.block)
+
+	// Verify the serving order of the queued requests
+	want := []string{"block", "soon", "late"}
+	for i, name := range want {
+		select {
+		case have := <-handler.order:
+			if have != name {
+				t.Fatalf("request %d mismatch: have %s, want %s.", i, have, name)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("request %d not handled.", i)
+		}
+	}
+	select {
+	case have := <-handler.order:
+		t.Fatalf("unexpected request handled: %s.", have)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// Service handler recording the order of the requests, blocking the first one.
+type deadlineTestHandler struct {
+	block chan struct{}
+	order chan string
+}
+
+func (d *deadlineTestHandler) Init(conn *Connection) error { return nil }
+func (d *deadlineTestHandler) HandleBroadcast(msg []byte)  { panic("not implemented") }
+func (d *deadlineTestHandler) HandleTunnel(tun *Tunnel)    { panic("not implemented") }
+func (d *deadlineTestHandler) HandleDrop(reason error)     { panic("not implemented") }
+
+func (d *deadlineTestHandler) HandleRequest(req []byte) ([]byte, error) {
+	if string(req) == "block" {
+		<-d.block
+	}
+	d.order <- string(req)
+	return req, nil
+}
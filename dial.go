@@ -0,0 +1,87 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the establishment of the network link to the relay.
+//
+// By default the binding connects to the local relay over loopback TCP. Other
+// links can be selected through an endpoint of the form scheme://address, with
+// the scheme naming a registered transport. Besides TCP, only the platform
+// specific links are built in (named pipes on Windows, WebSockets in browsers).
+// The relay does not accept QUIC links, so no QUIC transport is shipped; one
+// (or any other stream transport) may be plugged in through RegisterTransport,
+// should a relay front end speaking it be deployed.
+
+package iris
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Network dialer opening the link to the relay, allowing connections to be
+// routed through proxies, bound to source addresses or instrumented.
+type Dialer func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// Transport opening a reliable, ordered stream to a relay endpoint address.
+type Transport func(ctx context.Context, addr string) (net.Conn, error)
+
+// Dialer used if none was specified in the options.
+var defaultDialer Dialer = new(net.Dialer).DialContext
+
+// Transports registered by the application, keyed by endpoint scheme.
+var (
+	transports     = make(map[string]Transport)
+	transportsLock sync.RWMutex
+)
+
+// Registers a transport for the given endpoint scheme, replacing any previous
+// one. A nil transport removes the registration.
+func RegisterTransport(scheme string, transport Transport) {
+	transportsLock.Lock()
+	defer transportsLock.Unlock()
+
+	if transport == nil {
+		delete(transports, scheme)
+	} else {
+		transports[scheme] = transport
+	}
+}
+
+// Opens the link to the relay. If an endpoint was specified in the options, it
+// is dialed with the transport of its scheme, otherwise the local relay port is
+// dialed over TCP with the user supplied dialer if any.
+func dialRelay(options *Options, port int) (net.Conn, error) {
+	if options.Endpoint == "" {
+		return dialTCP(options.Dialer, fmt.Sprintf("localhost:%d", port))
+	}
+	parts := strings.SplitN(options.Endpoint, "://", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid relay endpoint: %s", options.Endpoint)
+	}
+	scheme, addr := parts[0], parts[1]
+	if scheme == "tcp" {
+		return dialTCP(options.Dialer, addr)
+	}
+	transportsLock.RLock()
+	transport, ok := transports[scheme]
+	transportsLock.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unsupported relay transport: %s", scheme)
+	}
+	return transport(context.Background(), addr)
+}
+
+// Dials a relay address over TCP.
+func dialTCP(dialer Dialer, addr string) (net.Conn, error) {
+	if dialer == nil {
+		dialer = defaultDialer
+	}
+	return dialer(context.Background(), "tcp", addr)
+}
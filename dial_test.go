@@ -0,0 +1,75 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+)
+
+// Tests that custom dialers are used to open the relay link.
+func TestDialer(t *testing.T) {
+	// Connect through an instrumented dialer
+	var dials []string
+	dialer := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dials = append(dials, network+"://"+addr)
+		return new(net.Dialer).DialContext(ctx, network, addr)
+	}
+	conn, err := ConnectWithOptions(config.relay, &Options{Dialer: dialer})
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	conn.Close()
+
+	if want := fmt.Sprintf("tcp://localhost:%d", config.relay); len(dials) != 1 || dials[0] != want {
+		t.Fatalf("dial mismatch: have %v, want [%v].", dials, want)
+	}
+	// Ensure dialer failures are reported
+	failure := errors.New("dial refused")
+	dialer = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return nil, failure
+	}
+	if _, err := ConnectWithOptions(config.relay, &Options{Dialer: dialer}); err != failure {
+		t.Fatalf("dial failure mismatch: have %v, want %v.", err, failure)
+	}
+}
+
+// Tests that endpoints are dialed with the transport of their scheme.
+func TestTransport(t *testing.T) {
+	// Register a transport tunneling over plain TCP
+	var dials []string
+	RegisterTransport("test", func(ctx context.Context, addr string) (net.Conn, error) {
+		dials = append(dials, addr)
+		return new(net.Dialer).DialContext(ctx, "tcp", addr)
+	})
+	defer RegisterTransport("test", nil)
+
+	addr := fmt.Sprintf("localhost:%d", config.relay)
+	conn, err := ConnectWithOptions(0, &Options{Endpoint: "test://" + addr})
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	conn.Close()
+
+	if len(dials) != 1 || dials[0] != addr {
+		t.Fatalf("dial mismatch: have %v, want [%v].", dials, addr)
+	}
+	// Ensure plain TCP endpoints and unknown schemes are handled
+	if conn, err := ConnectWithOptions(0, &Options{Endpoint: "tcp://" + addr}); err != nil {
+		t.Fatalf("tcp connection failed: %v.", err)
+	} else {
+		conn.Close()
+	}
+	for _, endpoint := range []string{"unknown://" + addr, addr} {
+		if _, err := ConnectWithOptions(0, &Options{Endpoint: endpoint}); err == nil {
+			t.Fatalf("connection to %s succeeded.", endpoint)
+		}
+	}
+}
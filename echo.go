@@ -0,0 +1,98 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the built-in echo service and the matching latency probe, allowing
+// operators to measure the fabric latency between any two nodes without having
+// to deploy custom test services.
+
+package iris
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+	"time"
+)
+
+// Service handler echoing back every request and tunnel message unmodified.
+// Broadcasts are silently discarded.
+type echoHandler struct{}
+
+func (e echoHandler) Init(conn *Connection) error              { return nil }
+func (e echoHandler) HandleBroadcast(msg []byte)               {}
+func (e echoHandler) HandleRequest(req []byte) ([]byte, error) { return req, nil }
+func (e echoHandler) HandleDrop(reason error)                  {}
+
+func (e echoHandler) HandleTunnel(tun *Tunnel) {
+	defer tun.Close()
+
+	for {
+		msg, err := tun.Recv(0)
+		if err != nil {
+			return
+		}
+		if err := tun.Send(msg, 0); err != nil {
+			return
+		}
+	}
+}
+
+// Registers a built-in echo service into the specified cluster, replying to all
+// requests and tunnel messages with their own content. Probe its latency from
+// any other node with Connection.Echo.
+func RegisterEcho(port int, cluster string) (*Service, error) {
+	return Register(port, cluster, echoHandler{}, nil)
+}
+
+// Latency distribution measured by an echo probe.
+type EchoReport struct {
+	Sent     int           // Number of probe requests issued
+	Received int           // Number of echoes received back intact
+	Min      time.Duration // Fastest round trip
+	Median   time.Duration // Median round trip
+	P99      time.Duration // 99th percentile round trip
+	Max      time.Duration // Slowest round trip
+}
+
+// Measures the round trip latency to an echo service cluster by sequentially
+// issuing count requests, each allowed at most timeout to complete. Failed or
+// corrupted echoes are only accounted as lost, the call fails only if no echo
+// at all was received.
+func (c *Connection) Echo(cluster string, count int, timeout time.Duration) (*EchoReport, error) {
+	if count < 1 {
+		return nil, errors.New("non-positive probe count")
+	}
+	report := &EchoReport{Sent: count}
+	rtts := make([]time.Duration, 0, count)
+
+	var fail error
+	for i := 0; i < count; i++ {
+		probe := make([]byte, 8)
+		binary.BigEndian.PutUint64(probe, uint64(i))
+
+		start := c.clock.Now()
+		reply, err := c.Request(cluster, probe, timeout)
+		if err != nil {
+			fail = err
+			continue
+		}
+		if len(reply) != len(probe) || binary.BigEndian.Uint64(reply) != uint64(i) {
+			fail = errors.New("corrupted echo")
+			continue
+		}
+		rtts = append(rtts, since(c.clock, start))
+	}
+	if len(rtts) == 0 {
+		return nil, fail
+	}
+	sort.Slice(rtts, func(i, j int) bool { return rtts[i] < rtts[j] })
+
+	report.Received = len(rtts)
+	report.Min, report.Max = rtts[0], rtts[len(rtts)-1]
+	report.Median = rtts[len(rtts)/2]
+	report.P99 = rtts[(len(rtts)-1)*99/100]
+	return report, nil
+}
@@ -0,0 +1,56 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"testing"
+	"time"
+)
+
+// Tests that the built-in echo service can be probed for latencies.
+func TestEcho(t *testing.T) {
+	serv, err := RegisterEcho(config.relay, config.cluster)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Probe the echo service and verify the latency distribution
+	report, err := conn.Echo(config.cluster, 50, time.Second)
+	if err != nil {
+		t.Fatalf("echo probe failed: %v.", err)
+	}
+	if report.Sent != 50 || report.Received != 50 {
+		t.Fatalf("probe count mismatch: have %d/%d, want %d/%d.", report.Received, report.Sent, 50, 50)
+	}
+	if report.Min <= 0 || report.Min > report.Median || report.Median > report.P99 || report.P99 > report.Max {
+		t.Fatalf("invalid latency distribution: %+v.", report)
+	}
+	// Verify that echoes also flow through tunnels
+	tun, err := conn.Tunnel(config.cluster, time.Second)
+	if err != nil {
+		t.Fatalf("tunnel construction failed: %v.", err)
+	}
+	defer tun.Close()
+
+	if err := tun.Send([]byte("ping"), time.Second); err != nil {
+		t.Fatalf("failed to send message: %v.", err)
+	}
+	if msg, err := tun.Recv(time.Second); err != nil || string(msg) != "ping" {
+		t.Fatalf("echo mismatch: have %q/%v, want %q.", msg, err, "ping")
+	}
+	// Verify that probing a missing cluster fails
+	if _, err := conn.Echo(config.cluster+"-missing", 2, 50*time.Millisecond); err == nil {
+		t.Fatalf("probe of missing cluster succeeded.")
+	}
+}
@@ -6,7 +6,12 @@
 
 package iris
 
-import "errors"
+import (
+	"errors"
+	"strings"
+
+	"gopkg.in/project-iris/iris-go.v1/relaywire"
+)
 
 // Returned whenever a time-limited operation expires.
 var ErrTimeout = errors.New("operation timed out")
@@ -14,7 +19,120 @@ var ErrTimeout = errors.New("operation timed out")
 // Returned if an operation is requested on a closed entity.
 var ErrClosed = errors.New("entity closed")
 
+// Returned (wrapped as a remote error) if a service rejected a request due to
+// being overloaded.
+var ErrOverloaded = errors.New("service overloaded")
+
+// Returned (wrapped as a remote error) if a service rejected a request due to
+// not being ready, e.g. draining for a restart. Another member may accept it.
+var ErrUnavailable = errors.New("service unavailable")
+
+// Returned if a request expired while waiting in the remote service's queue.
+var ErrExpired = errors.New("request expired while queued")
+
+// Returned if a request was refused locally due to too many already outstanding
+// requests to the same cluster.
+var ErrThrottled = errors.New("too many outstanding requests")
+
+// Returned if an encrypted message arrived, but no cipher was configured.
+var ErrNoCipher = errors.New("no cipher for encrypted payload")
+
+// Returned if an unsigned message arrived through a verified cluster or topic.
+var ErrUnsigned = errors.New("unsigned message")
+
+// Returned if a topic operation was denied by the local access policy.
+var ErrDenied = errors.New("denied by topic policy")
+
+// Returned if a message was rejected due to its payload being quarantined.
+var ErrQuarantined = errors.New("message quarantined")
+
+// Returned if an operation needs a protocol extension the relay doesn't support.
+var ErrUnsupported = errors.New("not supported by the relay")
+
+// Returned if a connection pool has no live connection to hand out.
+var ErrPoolEmpty = errors.New("no live pooled connection")
+
+// Returned if the relay refused the connection due to failed authentication.
+type AuthError struct {
+	Reason string // Failure reason reported by the relay
+}
+
+func (e *AuthError) Error() string {
+	return "authentication failed: " + e.Reason
+}
+
+// Returned (as the connection drop reason) if a frame received from the relay
+// failed its checksum verification. Only raised if checksums were requested in
+// the options and agreed to by the relay.
+type ChecksumError = relaywire.ChecksumError
+
+// Marker prefixing the request failures reported by the binding itself (e.g. an
+// overload), telling them apart from the ones returned by service handlers, as
+// only the former are interpreted by the callers. Handler failures starting with
+// the marker are escaped by doubling it.
+const faultMarker = "\x00"
+
+// Marks a request failure as reported by the binding.
+func markFault(fault string) string {
+	return faultMarker + fault
+}
+
+// Escapes a request failure returned by a service handler.
+func escapeFault(fault string) string {
+	if strings.HasPrefix(fault, faultMarker) {
+		return faultMarker + fault
+	}
+	return fault
+}
+
+// Reconstructs the error of a request failure. Failures reported by the binding
+// are mapped back to their well known errors, whereas handler failures are only
+// ever delivered as opaque errors.
+func parseFault(fault string) error {
+	if !strings.HasPrefix(fault, faultMarker) {
+		return errors.New(fault)
+	}
+	fault = fault[len(faultMarker):]
+	if strings.HasPrefix(fault, faultMarker) {
+		return errors.New(fault)
+	}
+	switch {
+	case fault == ErrOverloaded.Error():
+		return ErrOverloaded
+	case fault == ErrUnavailable.Error():
+		return ErrUnavailable
+	case fault == ErrExpired.Error():
+		return ErrExpired
+	case strings.HasPrefix(fault, schemaFaultPrefix):
+		return schemaFault(fault)
+	}
+	if quota := parseQuotaFault(fault); quota != nil {
+		return quota
+	}
+	return errors.New(fault)
+}
+
 // Wrapper to differentiate between local and remote errors.
 type RemoteError struct {
 	error
 }
+
+// Retrieves the remote failure wrapped by the error.
+func (e *RemoteError) Unwrap() error {
+	return e.error
+}
+
+// Failure of a connection or service owned by a Supervisor.
+type SupervisorError struct {
+	Name string // Name of the failed connection or service
+	Err  error  // Failure causing the restart
+}
+
+func (e *SupervisorError) Error() string {
+	return e.Name + ": " + e.Err.Error()
+}
+
+// Retrieves the failure wrapped by the error.
+func (e *SupervisorError) Unwrap() error {
+	return e.Err
+}
@@ -10,115 +10,510 @@ package iris
 
 import (
 	"errors"
+	"strconv"
 	"sync/atomic"
 	"time"
+
+	"gopkg.in/inconshreveable/log15.v2"
 )
 
-// Schedules an application broadcast message for the service handler to process.
+// Schedules an application broadcast message arrived from the relay for the
+// service handler to process.
 func (c *Connection) handleBroadcast(message []byte) {
+	if c.primary != nil {
+		c.primary.handleBroadcast(message)
+		return
+	}
+	// Drop the relay's echo of broadcasts already delivered in memory
+	if c.consumeEcho(message) {
+		c.Log.Debug("dropping echo of loopback broadcast")
+		return
+	}
+	c.deliverBroadcast(message)
+}
+
+// Schedules an application broadcast message for the service handler to process.
+func (c *Connection) deliverBroadcast(message []byte) {
 	id := int(atomic.AddUint64(&c.bcastIdx, 1))
+	atomic.AddUint64(&c.stats.bcastRecv, 1)
+
+	message, meta, err := c.unwrap(c.cluster, message)
+	if err != nil {
+		c.Log.Error("dropping unverified or undecryptable broadcast", "broadcast", id, "key", meta.KeyID, "reason", err)
+		c.reportError("dropped unverified or undecryptable broadcast: %v", err)
+		return
+	}
+	// Acknowledge membership probes directly, without involving the service handler
+	if _, ok := meta.Headers[headerProbe]; ok && meta.receipt != "" {
+		c.Log.Debug("answering membership probe", "broadcast", id)
+		c.sendReceipt(meta.receipt)
+		return
+	}
+	// Cancel abandoned requests similarly, if served by this member
+	if meta.cancel != "" {
+		c.cancelRequest(meta.cancel)
+		return
+	}
 	c.Log.Debug("scheduling arrived broadcast", "broadcast", id, "data", logLazyBlob(message))
 
 	// Make sure there is enough memory for the message
-	used := int(atomic.LoadInt32(&c.bcastUsed)) // Safe, since only 1 thread increments!
+	c.limitLock.RLock()
+	defer c.limitLock.RUnlock()
+
+	used := int(atomic.LoadInt32(&c.bcastUsed)) // Safe bar loopback callers, overshooting by a message each
+	c.bcastSlow.observe(used+len(message), c.limits.BroadcastMemory)
 	if used+len(message) <= c.limits.BroadcastMemory {
 		// Increment the memory usage of the queue and schedule the broadcast
 		atomic.AddInt32(&c.bcastUsed, int32(len(message)))
-		c.bcastPool.Schedule(func() {
+		span := c.tracer.start("broadcast", c.cluster, len(message))
+		task := func() {
 			// Start the processing by decrementing the memory usage
 			atomic.AddInt32(&c.bcastUsed, -int32(len(message)))
 			c.Log.Debug("handling scheduled broadcast", "broadcast", id)
-			c.handler.HandleBroadcast(message)
-		})
+			span.begin()
+			err := c.poison.guard(message, 0, func() {
+				if handler, ok := c.handler.(BroadcastMetadataHandler); ok {
+					handler.HandleBroadcastWithMetadata(message, meta)
+				} else {
+					c.handler.HandleBroadcast(message)
+				}
+			})
+			span.end(err)
+			if err != nil {
+				c.Log.Error("failed to handle broadcast", "broadcast", id, "reason", err)
+				c.deadLetter(DeadBroadcast, c.cluster, message, meta, err)
+			} else if meta.receipt != "" {
+				c.sendReceipt(meta.receipt)
+			}
+		}
+		task = c.prioritize(prioBroadcast, task)
+		if keyer, ok := broadcastKeyer(c.handler); ok {
+			c.bcastKeys.schedule(c.bcastPool.Schedule, keyer.BroadcastKey(message, meta), task)
+		} else {
+			c.bcastPool.Schedule(task)
+		}
 		return
 	}
 	// Not enough memory in the broadcast queue
 	c.Log.Error("broadcast exceeded memory allowance", "broadcast", id, "limit", c.limits.BroadcastMemory, "used", used, "size", len(message))
+	c.reportError("dropped broadcast of %d bytes exceeding memory allowance (%d/%d used)", len(message), used, c.limits.BroadcastMemory)
+	c.deadLetter(DeadBroadcast, c.cluster, message, meta, errors.New("broadcast exceeded memory allowance"))
 }
 
-// Schedules an application request for the service handler to process.
+// Schedules an application request arrived from the relay for the service
+// handler to process.
 func (c *Connection) handleRequest(id uint64, request []byte, timeout time.Duration) {
+	if c.primary != nil {
+		c.primary.serveRequest(id, request, timeout, c.sendReply)
+		return
+	}
+	c.serveRequest(id, request, timeout, c.sendReply)
+}
+
+// Destination of the reply (or failure) of a served request.
+type replySink func(id uint64, reply []byte, fault string) error
+
+// Schedules an application request for the service handler to process, passing
+// the outcome to the reply sink.
+func (c *Connection) serveRequest(id uint64, request []byte, timeout time.Duration, sink replySink) {
+	atomic.AddUint64(&c.stats.reqRecv, 1)
+	arrived := c.clock.Now()
+
+	request, meta, err := c.unwrap(c.cluster, request)
 	logger := c.Log.New("remote_request", id)
+	if meta.CorrelationID != "" {
+		logger = logger.New("correlation", meta.CorrelationID)
+	}
+	if err != nil {
+		logger.Error("rejecting unverified or undecryptable request", "key", meta.KeyID, "reason", err)
+		c.reportError("rejected unverified or undecryptable request: %v", err)
+		c.auditRequest(arrived, request, meta, nil, AuditRejected, err.Error())
+		if err := sink(id, nil, markFault(err.Error())); err != nil {
+			logger.Error("failed to send rejection", "reason", err)
+		}
+		return
+	}
+	// Reject new work if the service is not ready (probes included, reporting it)
+	if atomic.LoadInt32(&c.unready) != 0 {
+		logger.Debug("rejecting request while unready")
+		c.auditRequest(arrived, request, meta, nil, AuditRejected, ErrUnavailable.Error())
+		if err := sink(id, nil, markFault(ErrUnavailable.Error())); err != nil {
+			logger.Error("failed to send rejection", "reason", err)
+		}
+		return
+	}
+	// Answer health probes directly, without involving the service handler
+	if _, ok := meta.Headers[headerProbe]; ok {
+		if _, ok := meta.Headers[headerSchema]; ok {
+			request = []byte(formatSchemas(c.options.Schemas))
+		} else if _, ok := meta.Headers[headerInstance]; ok {
+			request = []byte(c.options.Instance)
+		}
+		logger.Debug("answering health probe")
+		if err := sink(id, request, ""); err != nil {
+			logger.Error("failed to answer health probe", "reason", err)
+		}
+		return
+	}
+	// Reject requests encoded in a schema version the service doesn't accept
+	if !c.acceptsSchema(meta.Schema) {
+		fault := schemaFaultPrefix + strconv.Itoa(meta.Schema)
+		logger.Warn("rejecting request of unsupported schema version", "schema", meta.Schema)
+		c.auditRequest(arrived, request, meta, nil, AuditRejected, fault)
+		if err := sink(id, nil, markFault(fault)); err != nil {
+			logger.Error("failed to send rejection", "reason", err)
+		}
+		return
+	}
 	logger.Debug("scheduling arrived request", "data", logLazyBlob(request), "timeout", timeout)
 
+	// Reject the request if its caller exceeded their quota
+	if err := c.quota.admit(request, meta); err != nil {
+		logger.Warn("request rejected by caller quota", "sender", meta.Sender, "limit", err.Limit, "retry", err.RetryAfter)
+		c.auditRequest(arrived, request, meta, nil, AuditRejected, err.Error())
+		if err := sink(id, nil, markFault(err.Error())); err != nil {
+			logger.Error("failed to send rejection", "reason", err)
+		}
+		return
+	}
+
+	// Reject the request early if the service is overloaded
+	c.limitLock.RLock()
+	defer c.limitLock.RUnlock()
+
+	if !c.admitRequest() {
+		logger.Warn("request rejected by admission control")
+		c.auditRequest(arrived, request, meta, nil, AuditRejected, ErrOverloaded.Error())
+		if err := sink(id, nil, markFault(ErrOverloaded.Error())); err != nil {
+			logger.Error("failed to send rejection", "reason", err)
+		}
+		return
+	}
 	// Make sure there is enough memory for the request
-	used := int(atomic.LoadInt32(&c.reqUsed)) // Safe, since only 1 thread increments!
+	used := int(atomic.LoadInt32(&c.reqUsed)) // Safe bar loopback callers, overshooting by a message each
 	if used+len(request) <= c.limits.RequestMemory {
-		// Increment the memory usage of the queue
+		// Increment the memory usage and length of the queue
 		atomic.AddInt32(&c.reqUsed, int32(len(request)))
+		atomic.AddInt32(&c.reqPend, 1)
 
 		// Create the expiration timer and schedule the request
-		expiration := time.After(timeout)
-		c.reqPool.Schedule(func() {
-			// Start the processing by decrementing the memory usage
+		deadline, expiration := c.clock.Now().Add(timeout), c.clock.After(timeout)
+		c.queueRequest(id)
+		span := c.tracer.start("request", c.cluster, len(request))
+
+		var release func()
+		meta.ctx, release = c.requestContext(meta.cancel, timeout)
+
+		start := func() bool {
+			// Start the processing by decrementing the memory usage and queue length
 			atomic.AddInt32(&c.reqUsed, -int32(len(request)))
+			atomic.AddInt32(&c.reqPend, -1)
+			c.dequeueRequest(id)
 
 			// Make sure the request didn't expire while enqueued
 			select {
 			case expired := <-expiration:
-				exp := time.Since(expired)
+				exp := since(c.clock, expired)
 				logger.Error("dumping expired scheduled request", "scheduled", exp+timeout, "timeout", timeout, "expired", exp)
-				return
+				c.reportError("dropped request expired while queued for %v", exp+timeout)
+				c.deadLetter(DeadRequest, c.cluster, request, meta, ErrTimeout)
+				span.end(ErrTimeout)
+				c.auditRequest(arrived, request, meta, nil, AuditExpired, ErrTimeout.Error())
+				release()
+				if c.options.ShedExpired {
+					if err := sink(id, nil, markFault(ErrExpired.Error())); err != nil {
+						logger.Error("failed to send expiration", "reason", err)
+					}
+				}
+				return false
 			default:
 				// All ok, continue
+				return true
 			}
-			// Handle the request and return a reply
+		}
+		c.scheduleRequest(request, meta, deadline, start, func() {
+			span.begin()
+
+			// Replay the outcome of duplicate requests, awaiting it if still in flight
+			store, claimed := c.options.Idempotency, false
+			if store != nil && meta.IdempotencyKey != "" {
+				record, err := c.claimRequest(store, c.idempotencyKey(meta), deadline)
+				switch {
+				case err == ErrTimeout:
+					logger.Warn("dropping duplicate request outliving its timeout", "key", meta.IdempotencyKey)
+					span.end(ErrTimeout)
+					c.auditRequest(arrived, request, meta, nil, AuditExpired, ErrTimeout.Error())
+					release()
+					return
+				case err != nil:
+					logger.Error("failed to claim idempotency key", "key", meta.IdempotencyKey, "reason", err)
+				case record != nil:
+					logger.Debug("replaying duplicate request", "key", meta.IdempotencyKey)
+					span.end(nil)
+					c.auditRequest(arrived, request, meta, record.reply, AuditReplayed, record.fault)
+					c.replyRequest(sink, logger, id, record.reply, record.fault, meta)
+					release()
+					return
+				default:
+					claimed = true
+				}
+			}
+			// Handle the request and return a reply, either on return or deferred
 			logger.Debug("handling scheduled request")
-			reply, err := c.handler.HandleRequest(request)
-			fault := ""
-			if err != nil {
-				fault = err.Error()
+			start := c.clock.Now()
+
+			meta.responder = &Responder{finish: func(reply []byte, err error, record bool) {
+				c.trackRequestTime(since(c.clock, start))
+				span.end(err)
+				fault := ""
+				if err != nil {
+					fault = err.Error()
+					c.deadLetter(DeadRequest, c.cluster, request, meta, err)
+				}
+				// Record the outcome of handled requests for duplicate suppression
+				if claimed && record {
+					if err := store.Save(c.idempotencyKey(meta), reply, fault); err != nil {
+						logger.Error("failed to save idempotency record", "key", meta.IdempotencyKey, "reason", err)
+					}
+				} else if claimed {
+					if err := store.Release(c.idempotencyKey(meta)); err != nil {
+						logger.Error("failed to release idempotency key", "key", meta.IdempotencyKey, "reason", err)
+					}
+				}
+				result := AuditSuccess
+				if fault != "" {
+					result = AuditFailure
+				}
+				c.auditRequest(arrived, request, meta, reply, result, fault)
+				c.replyRequest(sink, logger, id, reply, fault, meta)
+				release()
+			}}
+			var reply []byte
+			var err error
+			perr := c.poison.guard(request, timeout, func() {
+				if handler, ok := c.handler.(RequestMetadataHandler); ok {
+					reply, err = handler.HandleRequestWithMetadata(request, meta)
+				} else {
+					reply, err = c.handler.HandleRequest(request)
+				}
+			})
+			if err == ErrPending && perr == nil {
+				logger.Debug("deferring request reply")
+				return
+			}
+			if perr != nil {
+				reply, err = nil, perr
 			}
-			logger.Debug("replying to handled request", "data", logLazyBlob(reply), "error", err)
-			if err := c.sendReply(id, reply, fault); err != nil {
-				logger.Error("failed to send reply", "reason", err)
+			if err := meta.responder.conclude(reply, err, perr == nil); err != nil {
+				logger.Error("discarding handler reply", "reason", err)
 			}
 		})
 		return
 	}
 	// Not enough memory in the request queue
 	logger.Error("request exceeded memory allowance", "limit", c.limits.RequestMemory, "used", used, "size", len(request))
+	c.reportError("dropped request of %d bytes exceeding memory allowance (%d/%d used)", len(request), used, c.limits.RequestMemory)
+	c.auditRequest(arrived, request, meta, nil, AuditDropped, "request exceeded memory allowance")
+	c.deadLetter(DeadRequest, c.cluster, request, meta, errors.New("request exceeded memory allowance"))
+}
+
+// Sends the reply of a handled request, encrypting it if the request was too.
+// The fault is the one returned by the handler, escaped before sending so that
+// it can't pass for a failure reported by the binding.
+func (c *Connection) replyRequest(sink replySink, logger log15.Logger, id uint64, reply []byte, fault string, meta *Metadata) {
+	var err error
+	if fault != "" {
+		err, fault = errors.New(fault), escapeFault(fault)
+	}
+	if reply != nil {
+		if serr := c.checkSendSize(len(reply)); serr != nil {
+			logger.Error("rejecting oversized reply", "reason", serr)
+			reply, fault, err = nil, markFault(serr.Error()), serr
+		}
+	}
+	if reply != nil && meta.KeyID != "" {
+		if reply, err = c.envelope(c.cluster, reply, nil); err != nil {
+			logger.Error("failed to encrypt reply", "reason", err)
+			reply, fault = nil, markFault(err.Error())
+		}
+	}
+	logger.Debug("replying to handled request", "data", logLazyBlob(reply), "error", err)
+	if err := sink(id, reply, fault); err != nil {
+		logger.Error("failed to send reply", "reason", err)
+		c.reportError("failed to send reply: %v", err)
+	}
+}
+
+// Schedules an inbound request handler into the request pool, either directly
+// or through the fair or deadline queue if requested. The start callback runs
+// first, skipping the handler if the request expired while queued.
+func (c *Connection) scheduleRequest(request []byte, meta *Metadata, deadline time.Time, start func() bool, handle func()) {
+	handle = c.prioritize(prioRequest, handle)
+	task := func() {
+		if start() {
+			handle()
+		}
+	}
+	switch {
+	case c.reqFair != nil:
+		c.reqFair.push(c.fairCaller(request, meta), task)
+		c.reqPool.Schedule(func() {
+			c.reqFair.pop()()
+		})
+	case c.reqEDF != nil:
+		c.reqEDF.push(deadline, task)
+		c.reqPool.Schedule(func() {
+			c.reqEDF.pop()()
+		})
+	default:
+		c.reqPool.Schedule(task)
+	}
+}
+
+// Records the arrival of an inbound request into the queue.
+func (c *Connection) queueRequest(id uint64) {
+	c.reqQueueLock.Lock()
+	c.reqQueue[id] = c.clock.Now()
+	c.reqQueueLock.Unlock()
+}
+
+// Removes an inbound request from the queue upon starting its processing.
+func (c *Connection) dequeueRequest(id uint64) {
+	c.reqQueueLock.Lock()
+	delete(c.reqQueue, id)
+	c.reqQueueLock.Unlock()
+}
+
+// Retrieves the time the oldest queued inbound request has been waiting for, or
+// zero if the queue is empty.
+func (c *Connection) oldestRequestAge() time.Duration {
+	c.reqQueueLock.Lock()
+	defer c.reqQueueLock.Unlock()
+
+	var oldest time.Time
+	for _, arrived := range c.reqQueue {
+		if oldest.IsZero() || arrived.Before(oldest) {
+			oldest = arrived
+		}
+	}
+	if oldest.IsZero() {
+		return 0
+	}
+	return since(c.clock, oldest)
+}
+
+// Checks with the user's admission controller (if any) whether a new inbound
+// request can be accepted, given the current queue length and the estimated
+// time needed to process it. The limit lock must be held by the caller.
+func (c *Connection) admitRequest() bool {
+	if c.options.Admission == nil {
+		return true
+	}
+	pending := int(atomic.LoadInt32(&c.reqPend))
+	average := time.Duration(atomic.LoadInt64(&c.reqTime))
+	latency := time.Duration(pending/c.limits.RequestThreads+1) * average
+
+	return c.options.Admission(pending, latency)
+}
+
+// Folds a request handling time into the moving average of the service.
+func (c *Connection) trackRequestTime(elapsed time.Duration) {
+	for {
+		old := atomic.LoadInt64(&c.reqTime)
+		avg := old + (int64(elapsed)-old)/8
+		if old == 0 {
+			avg = int64(elapsed)
+		}
+		if atomic.CompareAndSwapInt64(&c.reqTime, old, avg) {
+			return
+		}
+	}
 }
 
 // Looks up a pending request and delivers the result.
 func (c *Connection) handleReply(id uint64, reply []byte, fault string) {
-	c.reqLock.RLock()
-	defer c.reqLock.RUnlock()
+	if !c.deliverReply(id, reply, fault) {
+		c.Log.Warn("dropping reply of unknown request", "local_request", id)
+	}
+}
 
+// Delivers the result of a pending request, returning whether it was found.
+func (c *Connection) deliverReply(id uint64, reply []byte, fault string) bool {
+	pend, ok := c.reqLive.remove(id)
+	if !ok {
+		return false
+	}
 	if reply == nil && len(fault) == 0 {
-		c.reqErrs[id] <- ErrTimeout
+		pend.fault <- ErrTimeout
 	} else if reply == nil {
-		c.reqErrs[id] <- &RemoteError{errors.New(fault)}
+		pend.fault <- &RemoteError{parseFault(fault)}
+	} else if reply, _, err := c.unwrap("", reply); err != nil {
+		pend.fault <- err
 	} else {
-		c.reqReps[id] <- reply
+		pend.reply <- reply
 	}
+	return true
 }
 
 // Forwards a topic publish event to the topic subscription.
 func (c *Connection) handlePublish(topic string, event []byte) {
+	atomic.AddUint64(&c.stats.eventRecv, 1)
+
+	// Account broadcast receipts, which bypass the subscriptions
+	if c.handleReceipt(topic, event) {
+		return
+	}
 	// Fetch the handler and release the lock fast
 	c.subLock.RLock()
 	top, ok := c.subLive[topic]
 	c.subLock.RUnlock()
 
 	// Make sure the subscription is still live
-	if ok {
-		top.handlePublish(event)
-	} else {
+	if !ok {
 		c.Log.Warn("stale publish arrived", "topic", topic)
+		return
+	}
+	event, meta, err := c.unwrap(topic, event)
+	if err != nil {
+		c.Log.Error("dropping unverified or undecryptable event", "topic", topic, "key", meta.KeyID, "reason", err)
+		c.reportError("dropped unverified or undecryptable event on %s: %v", topic, err)
+		return
+	}
+	if !top.handlePublish(event, meta) {
+		c.reportError("dropped event of %d bytes on %s exceeding memory allowance", len(event), topic)
+		c.deadLetter(DeadEvent, topic, event, meta, errors.New("event exceeded memory allowance"))
+	}
+}
+
+// Looks up a pending confirmed publish and delivers the acknowledgement.
+func (c *Connection) handlePublishAck(id uint64, subscribers int) {
+	c.pubLock.Lock()
+	defer c.pubLock.Unlock()
+
+	if ackc, ok := c.pubAcks[id]; ok {
+		ackc <- subscribers
 	}
 }
 
 // Notifies the application of the relay link going down.
 func (c *Connection) handleClose(reason error) {
+	// Cancel the context of any running handlers and drop the delayed messages
+	c.cancel()
+	c.sched.stop()
+
 	// Notify the client of the drop if premature
 	if reason != nil {
 		c.Log.Crit("connection dropped", "reason", reason)
+		c.reportError("connection dropped: %v", reason)
 
 		// Only server connections have registered handlers
 		if c.handler != nil {
 			c.handler.HandleDrop(reason)
 		}
+		// Tear down the lanes and aliases of the connection, useless without the primary
+		go c.closeLanes()
+		go c.closeAliases()
+		c.leaveLoopback()
 	}
 	// Close all open tunnels
 	c.tunLock.Lock()
@@ -130,24 +525,51 @@ func (c *Connection) handleClose(reason error) {
 }
 
 // Opens a new local tunnel endpoint and binds it to the remote side.
-func (c *Connection) handleTunnelInit(id uint64, chunkLimit int) {
+func (c *Connection) handleTunnelInit(id uint64, chunkLimit int, header map[string]string) {
 	go func() {
-		if tun, err := c.acceptTunnel(id, chunkLimit); err == nil {
-			c.handler.HandleTunnel(tun)
+		tun, err := c.acceptTunnel(id, chunkLimit, header)
+		if err != nil {
+			return // Failure already logged by the acceptor
+		}
+		serv := c.service()
+		switch {
+		case serv.joinParallel(tun):
+			// Stripe of a parallel transfer, routed internally
+		case atomic.LoadInt32(&serv.unready) != 0:
+			tun.Abort(ErrUnavailable.Error())
+		case serv.tunAcpt != nil:
+			serv.queueTunnel(tun)
+		default:
+			serv.handler.HandleTunnel(tun)
 		}
-		// Else: failure already logged by the acceptor
 	}()
 }
 
+// Queues an accepted inbound tunnel for the service to pick up, aborting it if
+// the backlog is already full.
+func (c *Connection) queueTunnel(tun *Tunnel) {
+	select {
+	case c.tunAcpt <- tun:
+	default:
+		c.Log.Warn("tunnel backlog full, rejecting", "tunnel", tun.id)
+		tun.Abort("tunnel backlog full")
+	}
+}
+
 // Forwards the tunnel construction result to the requested tunnel.
 func (c *Connection) handleTunnelResult(id uint64, chunkLimit int) {
 	// Retrieve the tunnel
 	c.tunLock.RLock()
-	tun := c.tunLive[id]
+	tun, ok := c.tunLive[id]
 	c.tunLock.RUnlock()
 
-	// Finalize initialization
-	tun.handleInitResult(chunkLimit)
+	// Finalize initialization, or tear down tunnels whose construction was aborted
+	if ok {
+		tun.handleInitResult(chunkLimit)
+	} else if chunkLimit > 0 {
+		c.Log.Warn("tearing down abandoned tunnel", "tunnel", id)
+		go c.sendTunnelClose(id)
+	}
 }
 
 // Forwards a tunnel data allowance to the requested tunnel.
@@ -163,7 +585,8 @@ func (c *Connection) handleTunnelAllowance(id uint64, space int) {
 	}
 }
 
-// Forwards a message chunk transfer to the requested tunnel.
+// Forwards a message chunk transfer to the requested tunnel. The chunk is only
+// borrowed from the frame decoder, the tunnel copies it while reassembling.
 func (c *Connection) handleTunnelTransfer(id uint64, size int, chunk []byte) {
 	// Retrieve the tunnel
 	c.tunLock.RLock()
@@ -171,9 +594,14 @@ func (c *Connection) handleTunnelTransfer(id uint64, size int, chunk []byte) {
 	c.tunLock.RUnlock()
 
 	// Notify it of the arrived message chunk
-	if ok {
-		tun.handleTransfer(size, chunk)
+	if !ok {
+		return
+	}
+	if c.prio != nil {
+		c.scheduleTransfer(tun, size, chunk)
+		return
 	}
+	tun.handleTransfer(size, chunk)
 }
 
 // Terminates a tunnel, stopping all data transfers.
@@ -0,0 +1,85 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the fair scheduling of inbound requests between distinct callers.
+
+package iris
+
+import (
+	"sync"
+
+	"github.com/project-iris/iris/container/queue"
+)
+
+// Callback extracting the identity of the caller who originated a request from
+// its payload or delivery metadata, used to schedule the queued requests fairly
+// between the distinct callers.
+type CallerKey func(request []byte, meta *Metadata) string
+
+// Identifies the caller of a request for fair scheduling, by the user supplied
+// key if any, or by the originating connection in its metadata otherwise.
+func (c *Connection) fairCaller(request []byte, meta *Metadata) string {
+	if c.options.Fairness != nil {
+		return c.options.Fairness(request, meta)
+	}
+	return meta.origin()
+}
+
+// Task queue serving the pending tasks of distinct callers in a round robin
+// fashion, instead of the order in which they arrived.
+type fairQueue struct {
+	queues map[string]*queue.Queue // Pending tasks of each caller
+	active []string                // Callers with pending tasks, in serving order
+	next   int                     // Index of the caller to serve next
+	lock   sync.Mutex              // Protects the queue internals
+}
+
+// Creates a new, empty fair task queue.
+func newFairQueue() *fairQueue {
+	return &fairQueue{
+		queues: make(map[string]*queue.Queue),
+	}
+}
+
+// Enqueues a new task originating from the given caller.
+func (f *fairQueue) push(caller string, task func()) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	q, ok := f.queues[caller]
+	if !ok {
+		q = queue.New()
+		f.queues[caller] = q
+		f.active = append(f.active, caller)
+	}
+	q.Push(task)
+}
+
+// Dequeues the next task to run, rotating between the callers with pending
+// tasks. Returns nil if the queue is empty.
+func (f *fairQueue) pop() func() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if len(f.active) == 0 {
+		return nil
+	}
+	if f.next >= len(f.active) {
+		f.next = 0
+	}
+	caller := f.active[f.next]
+	q := f.queues[caller]
+	task := q.Pop().(func())
+
+	// Drop the caller if it has nothing else pending, otherwise move on
+	if q.Empty() {
+		delete(f.queues, caller)
+		f.active = append(f.active[:f.next], f.active[f.next+1:]...)
+	} else {
+		f.next++
+	}
+	return task
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"testing"
+	"time"
+)
+
+// Tests that the fair queue rotates between the callers with pending tasks.
+func TestFairQueue(t *testing.T) {
+	queue := newFairQueue()
+
+	order := []string{}
+	for i := 0; i < 4; i++ {
+		queue.push("greedy", func() { order = append(order, "greedy") })
+	}
+	queue.push("polite-1", func() { order = append(order, "polite-1") })
+	queue.push("polite-2", func() { order = append(order, "polite-2") })
+
+	for task := queue.pop(); task != nil; task = queue.pop() {
+		task()
+	}
+	want := []string{"greedy", "polite-1", "polite-2", "greedy", "greedy", "greedy"}
+	if len(order) != len(want) {
+		t.Fatalf("serving order length mismatch: have %v, want %v.", order, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("serving order mismatch: have %v, want %v.", order, want)
+		}
+	}
+}
+
+// Tests that fairly scheduled requests of a quiet caller aren't starved behind
+// the backlog of a noisy one, callers being told apart by their sender name.
+func TestRequestFairness(t *testing.T) {
+	handler := &requestTestExpiryHandler{
+		sleep: 50 * time.Millisecond,
+	}
+	limits := &ServiceLimits{RequestThreads: 1}
+
+	serv, err := RegisterWithOptions(config.relay, config.cluster, handler, limits, &Options{Fair: true})
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	// Connect a noisy and a quiet caller
+	noisy, err := ConnectWithOptions(config.relay, &Options{Metadata: true, Name: "noisy"})
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer noisy.Close()
+
+	quiet, err := ConnectWithOptions(config.relay, &Options{Metadata: true, Name: "quiet"})
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer quiet.Close()
+
+	// Queue up a backlog from the noisy caller, and time a quiet request behind it
+	for i := 0; i < 8; i++ {
+		go noisy.Request(config.cluster, []byte{0x00}, 2*time.Second)
+	}
+	time.Sleep(25 * time.Millisecond)
+
+	start := time.Now()
+	if _, err := quiet.Request(config.cluster, []byte{0x01}, 2*time.Second); err != nil {
+		t.Fatalf("quiet request failed: %v.", err)
+	}
+	if elapsed := time.Since(start); elapsed > 4*handler.sleep {
+		t.Fatalf("quiet request starved: have %v, want < %v.", elapsed, 4*handler.sleep)
+	}
+	// Ensure fair and deadline scheduling can't be combined
+	if serv, err := RegisterWithOptions(config.relay, config.cluster, handler, limits, &Options{Fair: true, Deadlines: true}); err == nil {
+		serv.Unregister()
+		t.Fatalf("conflicting scheduling accepted.")
+	}
+}
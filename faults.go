@@ -0,0 +1,114 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the fault injection layer used to validate application resilience.
+
+package iris
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Fault injection settings, simulating messaging failures on the outbound path
+// of a connection. It is meant for tests only, never enable it in production!
+type Faults struct {
+	Seed int64 // Seed of the fault randomness (zero = time based)
+
+	BroadcastDrop float64 // Probability of silently dropping an outbound broadcast
+	PublishDrop   float64 // Probability of silently dropping an outbound event
+	RequestDrop   float64 // Probability of an outbound request timing out
+
+	RequestDelay DelayDistribution // Delay to inject before sending requests
+
+	TunnelKill float64 // Probability of a tunnel dying on each message send
+}
+
+// Distribution of injected delays, sampled through the fault source.
+type DelayDistribution func(rng *rand.Rand) time.Duration
+
+// Creates a delay distribution uniformly sampling the [min, max) interval.
+func UniformDelay(min, max time.Duration) DelayDistribution {
+	return func(rng *rand.Rand) time.Duration {
+		if max <= min {
+			return min
+		}
+		return min + time.Duration(rng.Int63n(int64(max-min)))
+	}
+}
+
+// Creates a delay distribution sampling an exponential with the given mean.
+func ExponentialDelay(mean time.Duration) DelayDistribution {
+	return func(rng *rand.Rand) time.Duration {
+		return time.Duration(rng.ExpFloat64() * float64(mean))
+	}
+}
+
+// Seeded fault source deciding when to inject a failure. A nil injector never
+// fails anything, hence the binding can call it unconditionally.
+type faultInjector struct {
+	faults *Faults    // Fault injection settings
+	rng    *rand.Rand // Source of the fault randomness
+	lock   sync.Mutex // Protects the non-thread safe random source
+}
+
+// Creates a new fault injector, or nil if no faults were requested.
+func newFaultInjector(faults *Faults) *faultInjector {
+	if faults == nil {
+		return nil
+	}
+	seed := faults.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &faultInjector{
+		faults: faults,
+		rng:    rand.New(rand.NewSource(seed)),
+	}
+}
+
+// Rolls the dice whether a fault of the given probability should occur.
+func (f *faultInjector) roll(prob float64) bool {
+	if f == nil || prob <= 0 {
+		return false
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.rng.Float64() < prob
+}
+
+// Checks whether an outbound broadcast should be dropped.
+func (f *faultInjector) dropBroadcast() bool {
+	return f != nil && f.roll(f.faults.BroadcastDrop)
+}
+
+// Checks whether an outbound event should be dropped.
+func (f *faultInjector) dropPublish() bool {
+	return f != nil && f.roll(f.faults.PublishDrop)
+}
+
+// Checks whether an outbound request should be timed out.
+func (f *faultInjector) dropRequest() bool {
+	return f != nil && f.roll(f.faults.RequestDrop)
+}
+
+// Checks whether a tunnel should be killed.
+func (f *faultInjector) killTunnel() bool {
+	return f != nil && f.roll(f.faults.TunnelKill)
+}
+
+// Samples the delay to inject before an outbound request.
+func (f *faultInjector) requestDelay() time.Duration {
+	if f == nil || f.faults.RequestDelay == nil {
+		return 0
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.faults.RequestDelay(f.rng)
+}
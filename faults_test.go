@@ -0,0 +1,68 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"testing"
+	"time"
+)
+
+// Tests that identically seeded fault injectors make the same decisions.
+func TestFaultDeterminism(t *testing.T) {
+	faults := &Faults{
+		Seed:          42,
+		BroadcastDrop: 0.5,
+		RequestDelay:  UniformDelay(time.Millisecond, 10*time.Millisecond),
+	}
+	a, b := newFaultInjector(faults), newFaultInjector(faults)
+	for i := 0; i < 1000; i++ {
+		if da, db := a.dropBroadcast(), b.dropBroadcast(); da != db {
+			t.Fatalf("drop decision #%d mismatch: %v != %v.", i, da, db)
+		}
+		if da, db := a.requestDelay(), b.requestDelay(); da != db {
+			t.Fatalf("delay sample #%d mismatch: %v != %v.", i, da, db)
+		} else if da < time.Millisecond || da >= 10*time.Millisecond {
+			t.Fatalf("delay sample #%d out of bounds: %v.", i, da)
+		}
+	}
+	// Make sure a disabled injector never fails anything
+	var none *faultInjector
+	if none.dropBroadcast() || none.dropPublish() || none.dropRequest() || none.killTunnel() || none.requestDelay() != 0 {
+		t.Fatalf("disabled injector produced a fault.")
+	}
+}
+
+// Tests that injected broadcast drops are never delivered.
+func TestFaultBroadcastDrop(t *testing.T) {
+	// Create the service handler
+	handler := &broadcastTestHandler{
+		delivers: make(chan []byte, 1),
+	}
+	// Register a new service to the relay
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	// Connect a faulty client and broadcast through it
+	conn, err := ConnectWithOptions(config.relay, &Options{Faults: &Faults{BroadcastDrop: 1}})
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	if err := conn.Broadcast(config.cluster, []byte{0x00}); err != nil {
+		t.Fatalf("broadcast failed: %v.", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	select {
+	case <-handler.delivers:
+		t.Fatalf("dropped broadcast received.")
+	default:
+	}
+}
@@ -0,0 +1,294 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Package files contains a file distribution helper pair, shipping artifacts or
+// configuration bundles across an Iris fabric without extra infrastructure.
+//
+// File servers share a local directory as members of a cluster, and fetchers
+// download files from them through tunnels. A download is split into batches
+// of byte ranges fetched in parallel over multiple tunnels, which Iris spreads
+// over the members of the cluster, so the sources share the load. Every chunk
+// is checksummed, and a batch cut short by a corrupt chunk or a failed tunnel
+// is resumed from its last verified byte through a new tunnel. Downloads may
+// also be resumed across runs by starting them at the size already fetched.
+//
+// All servers of a cluster are expected to share identical copies of the files.
+package files
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"gopkg.in/project-iris/iris-go.v1"
+)
+
+// Settings of a file download. Any unset fields (i.e. value of zero) will
+// default to the preset ones.
+type FetchOptions struct {
+	Sources int           // Tunnels to fetch the batches through in parallel
+	Batch   int64         // Size of the byte ranges requested at once
+	Timeout time.Duration // Timeout of the tunnel operations
+	Retries int           // Resumptions of a failed batch before giving up
+
+	Offset int64 // Position to resume the download from (bytes already fetched)
+}
+
+// Default settings of the file downloads.
+var defaultFetchOptions = FetchOptions{
+	Sources: 4,
+	Batch:   1024 * 1024,
+	Timeout: 10 * time.Second,
+	Retries: 3,
+}
+
+// Failure reported by a file server, which is not retried.
+type RemoteError struct {
+	Reason string // Failure message of the server
+}
+
+func (e *RemoteError) Error() string {
+	return "file server failure: " + e.Reason
+}
+
+// Downloads a file from the servers of a cluster, writing it into w.
+func Fetch(conn *iris.Connection, cluster string, path string, w io.Writer) error {
+	return FetchWithOptions(conn, cluster, path, w, nil)
+}
+
+// Downloads a file from the servers of a cluster similarly to Fetch, but with
+// custom download settings.
+func FetchWithOptions(conn *iris.Connection, cluster string, path string, w io.Writer, options *FetchOptions) error {
+	// Merge the user options with the defaults
+	opts := defaultFetchOptions
+	if options != nil {
+		if options.Sources != 0 {
+			opts.Sources = options.Sources
+		}
+		if options.Batch != 0 {
+			opts.Batch = options.Batch
+		}
+		if options.Timeout != 0 {
+			opts.Timeout = options.Timeout
+		}
+		if options.Retries != 0 {
+			opts.Retries = options.Retries
+		}
+		opts.Offset = options.Offset
+	}
+	// Query the size of the file through a first source
+	first := &source{conn: conn, cluster: cluster, timeout: opts.Timeout}
+	size, err := first.stat(path)
+	if err != nil {
+		first.close()
+		return err
+	}
+	if opts.Offset > size {
+		first.close()
+		return fmt.Errorf("resume offset %d beyond file size %d", opts.Offset, size)
+	}
+	// Feed the batches to the sources, limiting the ones fetched ahead of the writer
+	var (
+		batches = make(chan *batch)
+		results = make(chan *batch)
+		window  = make(chan struct{}, 2*opts.Sources)
+		quit    = make(chan struct{})
+	)
+	defer close(quit)
+
+	go func() {
+		defer close(batches)
+		for offset := opts.Offset; offset < size; offset += opts.Batch {
+			select {
+			case window <- struct{}{}:
+			case <-quit:
+				return
+			}
+			length := opts.Batch
+			if offset+length > size {
+				length = size - offset
+			}
+			select {
+			case batches <- &batch{offset: offset, length: length}:
+			case <-quit:
+				return
+			}
+		}
+	}()
+	for i := 0; i < opts.Sources; i++ {
+		src := first
+		if i > 0 {
+			src = &source{conn: conn, cluster: cluster, timeout: opts.Timeout}
+		}
+		go src.fetch(path, opts.Retries, batches, results, quit)
+	}
+	// Write the batches in order as they arrive
+	pending := make(map[int64]*batch)
+	for next := opts.Offset; next < size; {
+		var done *batch
+		select {
+		case done = <-results:
+		case <-time.After(opts.Timeout * time.Duration(opts.Retries+1)):
+			return iris.ErrTimeout
+		}
+		if done.err != nil {
+			return done.err
+		}
+		pending[done.offset] = done
+		for ready, ok := pending[next]; ok; ready, ok = pending[next] {
+			if _, err := w.Write(ready.data); err != nil {
+				return err
+			}
+			delete(pending, next)
+			next += ready.length
+			<-window
+		}
+	}
+	return nil
+}
+
+// Byte range of a file to download.
+type batch struct {
+	offset int64  // Position of the range within the file
+	length int64  // Length of the range
+	data   []byte // Contents of the range fetched so far
+	err    error  // Failure of the fetch, if any
+}
+
+// Single source of a download, lazily connecting through a tunnel.
+type source struct {
+	conn    *iris.Connection // Connection to open the tunnels through
+	cluster string           // Cluster of the file servers
+	timeout time.Duration    // Timeout of the tunnel operations
+	tun     *iris.Tunnel     // Tunnel to the current server, if connected
+}
+
+// Fetches batches until all are done or the download is aborted, resuming the
+// failed ones through new tunnels.
+func (s *source) fetch(path string, retries int, batches <-chan *batch, results chan<- *batch, quit <-chan struct{}) {
+	defer s.close()
+
+	for b := range batches {
+		for attempt := 0; ; attempt++ {
+			err := s.read(path, b)
+			if err == nil {
+				break
+			}
+			// Drop the failed tunnel and resume unless it's hopeless
+			s.close()
+
+			var remote *RemoteError
+			if errors.As(err, &remote) || attempt >= retries {
+				b.err = err
+				break
+			}
+		}
+		select {
+		case results <- b:
+		case <-quit:
+			return
+		}
+		if b.err != nil {
+			return
+		}
+	}
+}
+
+// Connects the source to a file server, unless already connected.
+func (s *source) connect() error {
+	if s.tun != nil {
+		return nil
+	}
+	tun, err := s.conn.Tunnel(s.cluster, s.timeout)
+	if err != nil {
+		return err
+	}
+	s.tun = tun
+	return nil
+}
+
+// Tears down the tunnel of the source, if connected.
+func (s *source) close() {
+	if s.tun != nil {
+		s.tun.Close()
+		s.tun = nil
+	}
+}
+
+// Queries the size of a file.
+func (s *source) stat(path string) (int64, error) {
+	if err := s.connect(); err != nil {
+		return 0, err
+	}
+	if err := s.tun.Send(encodeStat(path), s.timeout); err != nil {
+		return 0, err
+	}
+	reply, err := s.recv()
+	if err != nil {
+		return 0, err
+	}
+	if reply[0] != msgInfo {
+		return 0, errMalformed
+	}
+	size, n := decodeSize(reply[1:])
+	if n <= 0 {
+		return 0, errMalformed
+	}
+	return size, nil
+}
+
+// Fetches the missing part of a batch, appending the verified chunks to it.
+func (s *source) read(path string, b *batch) error {
+	if err := s.connect(); err != nil {
+		return err
+	}
+	offset := b.offset + int64(len(b.data))
+	if err := s.tun.Send(encodeRead(path, offset, b.length-int64(len(b.data))), s.timeout); err != nil {
+		return err
+	}
+	for {
+		reply, err := s.recv()
+		if err != nil {
+			return err
+		}
+		switch reply[0] {
+		case msgChunk:
+			at, chunk, err := decodeChunk(reply[1:])
+			if err != nil {
+				return err
+			}
+			if at != b.offset+int64(len(b.data)) {
+				return errMalformed
+			}
+			b.data = append(b.data, chunk...)
+
+		case msgEnd:
+			if int64(len(b.data)) != b.length {
+				return &RemoteError{Reason: "file changed during download"}
+			}
+			return nil
+
+		default:
+			return errMalformed
+		}
+	}
+}
+
+// Retrieves the next reply of the server, converting the failures into errors.
+func (s *source) recv() ([]byte, error) {
+	reply, err := s.tun.Recv(s.timeout)
+	if err != nil {
+		return nil, err
+	}
+	if len(reply) == 0 {
+		return nil, errMalformed
+	}
+	if reply[0] == msgError {
+		return nil, &RemoteError{Reason: string(reply[1:])}
+	}
+	return reply, nil
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package files
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gopkg.in/project-iris/iris-go.v1"
+)
+
+// Port of the local relay to run the tests against.
+var relay = 55555
+
+// Tests that files are downloaded intact from multiple sources, and downloads
+// can be resumed.
+func TestFetch(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		servers int
+		size    int
+	}{2, 300 * 1024}
+
+	// Create a random file and share it through multiple servers
+	root := t.TempDir()
+	dir := filepath.Join(root, "shared")
+	if err := os.Mkdir(dir, 0700); err != nil {
+		t.Fatalf("failed to create shared dir: %v.", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "secret"), []byte("secret"), 0600); err != nil {
+		t.Fatalf("failed to create secret file: %v.", err)
+	}
+	blob := make([]byte, conf.size)
+	rand.Read(blob)
+	if err := os.WriteFile(filepath.Join(dir, "blob"), blob, 0600); err != nil {
+		t.Fatalf("failed to create test file: %v.", err)
+	}
+	for i := 0; i < conf.servers; i++ {
+		server, err := ServeFiles(relay, "test-files", dir)
+		if err != nil {
+			t.Fatalf("server %d start failed: %v.", i, err)
+		}
+		defer server.Close()
+	}
+	conn, err := iris.Connect(relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Download the file in full and resumed
+	var full bytes.Buffer
+	if err := FetchWithOptions(conn, "test-files", "blob", &full, &FetchOptions{Sources: 3, Batch: 50 * 1024}); err != nil {
+		t.Fatalf("download failed: %v.", err)
+	}
+	if !bytes.Equal(full.Bytes(), blob) {
+		t.Fatalf("downloaded file mismatch: have %d bytes, want %d.", full.Len(), len(blob))
+	}
+	var tail bytes.Buffer
+	if err := FetchWithOptions(conn, "test-files", "/blob", &tail, &FetchOptions{Offset: 100000}); err != nil {
+		t.Fatalf("resumed download failed: %v.", err)
+	}
+	if !bytes.Equal(tail.Bytes(), blob[100000:]) {
+		t.Fatalf("resumed file mismatch: have %d bytes, want %d.", tail.Len(), len(blob)-100000)
+	}
+	// Ensure missing and escaping paths are rejected
+	for _, path := range []string{"missing", "../secret", "."} {
+		var remote *RemoteError
+		if err := Fetch(conn, "test-files", path, new(bytes.Buffer)); !errors.As(err, &remote) {
+			t.Fatalf("path %s error mismatch: have %v, want remote failure.", path, err)
+		}
+	}
+}
+
+// Tests that corrupted chunks are detected.
+func TestChunkChecksum(t *testing.T) {
+	msg := encodeChunk(1024, []byte("some file contents"))
+	if offset, chunk, err := decodeChunk(msg[1:]); err != nil || offset != 1024 || string(chunk) != "some file contents" {
+		t.Fatalf("chunk mismatch: have %d/%s/%v.", offset, chunk, err)
+	}
+	msg[len(msg)-1] ^= 0x01
+	if _, _, err := decodeChunk(msg[1:]); err != errChecksum {
+		t.Fatalf("corruption error mismatch: have %v, want %v.", err, errChecksum)
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package files
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/project-iris/iris-go.v1"
+)
+
+// Size of the checksummed chunks the files are streamed in.
+var chunkSize = 64 * 1024
+
+// Time a file tunnel may stay idle before the server drops it.
+var idleTimeout = 30 * time.Second
+
+// File server sharing the contents of a local directory with a cluster.
+type Server struct {
+	dir     string        // Root directory of the shared files
+	service *iris.Service // Service accepting the file tunnels
+}
+
+// Starts serving the files within a directory as a member of the given cluster.
+// Fetchers can only reach files within the directory, not outside of it.
+func ServeFiles(port int, cluster string, dir string) (*Server, error) {
+	// Sanity check on the arguments
+	if len(cluster) == 0 {
+		return nil, errors.New("empty cluster name")
+	}
+	if info, err := os.Stat(dir); err != nil {
+		return nil, err
+	} else if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	s := &Server{dir: dir}
+
+	var err error
+	if s.service, err = iris.Register(port, cluster, &serverHandler{s}, nil); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Stops serving the files, leaving the cluster.
+func (s *Server) Close() error {
+	return s.service.Unregister()
+}
+
+// Opens a shared file, confining the path to the served directory.
+func (s *Server) open(name string) (*os.File, int64, error) {
+	file, err := os.Open(filepath.Join(s.dir, filepath.FromSlash(path.Clean("/"+name))))
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	if info.IsDir() {
+		file.Close()
+		return nil, 0, fmt.Errorf("%s is a directory", name)
+	}
+	return file, info.Size(), nil
+}
+
+// Serves the requests arriving through a file tunnel until it's torn down.
+func (s *Server) serve(tun *iris.Tunnel) {
+	for {
+		req, err := tun.Recv(idleTimeout)
+		if err != nil || len(req) == 0 {
+			return
+		}
+		switch req[0] {
+		case msgStat:
+			err = s.stat(tun, string(req[1:]))
+		case msgRead:
+			err = s.read(tun, req[1:])
+		default:
+			err = errMalformed
+		}
+		if err != nil {
+			if tun.Send(append([]byte{msgError}, err.Error()...), idleTimeout) != nil {
+				return
+			}
+		}
+	}
+}
+
+// Reports the size of a shared file.
+func (s *Server) stat(tun *iris.Tunnel, name string) error {
+	file, size, err := s.open(name)
+	if err != nil {
+		return err
+	}
+	file.Close()
+
+	return tun.Send(encodeInfo(size), idleTimeout)
+}
+
+// Streams a byte range of a shared file in checksummed chunks.
+func (s *Server) read(tun *iris.Tunnel, req []byte) error {
+	name, offset, length, err := decodeRead(req)
+	if err != nil {
+		return err
+	}
+	file, _, err := s.open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	buf := make([]byte, chunkSize)
+	for length > 0 {
+		size := int64(len(buf))
+		if size > length {
+			size = length
+		}
+		n, err := file.ReadAt(buf[:size], offset)
+		if n > 0 {
+			if err := tun.Send(encodeChunk(offset, buf[:n]), idleTimeout); err != nil {
+				return err
+			}
+			offset, length = offset+int64(n), length-int64(n)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return tun.Send([]byte{msgEnd}, idleTimeout)
+}
+
+// Service handler accepting the file tunnels.
+type serverHandler struct {
+	server *Server
+}
+
+func (h *serverHandler) Init(conn *iris.Connection) error         { return nil }
+func (h *serverHandler) HandleBroadcast(msg []byte)               {}
+func (h *serverHandler) HandleRequest(req []byte) ([]byte, error) { return nil, errMalformed }
+func (h *serverHandler) HandleDrop(reason error)                  {}
+
+func (h *serverHandler) HandleTunnel(tun *iris.Tunnel) {
+	defer tun.Close()
+	h.server.serve(tun)
+}
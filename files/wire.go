@@ -0,0 +1,90 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package files
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+// Kinds of the messages exchanged through the file tunnels.
+const (
+	msgStat  byte = 0x01 // Fetcher: size query of a file
+	msgRead  byte = 0x02 // Fetcher: byte range request of a file
+	msgInfo  byte = 0x03 // Server: size of the queried file
+	msgChunk byte = 0x04 // Server: checksummed chunk of the requested range
+	msgEnd   byte = 0x05 // Server: end of the requested range
+	msgError byte = 0x06 // Server: failure of the last request
+)
+
+// Returned if a file transfer message cannot be decoded.
+var errMalformed = errors.New("malformed file transfer message")
+
+// Returned if a chunk doesn't match its checksum.
+var errChecksum = errors.New("chunk checksum mismatch")
+
+// Assembles a size query of a file.
+func encodeStat(path string) []byte {
+	return append([]byte{msgStat}, path...)
+}
+
+// Assembles a byte range request of a file.
+func encodeRead(path string, offset int64, length int64) []byte {
+	buf := make([]byte, 1, 1+2*binary.MaxVarintLen64+len(path))
+	buf[0] = msgRead
+	buf = binary.AppendUvarint(buf, uint64(offset))
+	buf = binary.AppendUvarint(buf, uint64(length))
+	return append(buf, path...)
+}
+
+// Disassembles a byte range request (without its kind byte).
+func decodeRead(data []byte) (string, int64, int64, error) {
+	offset, n := binary.Uvarint(data)
+	if n <= 0 {
+		return "", 0, 0, errMalformed
+	}
+	data = data[n:]
+	length, n := binary.Uvarint(data)
+	if n <= 0 {
+		return "", 0, 0, errMalformed
+	}
+	return string(data[n:]), int64(offset), int64(length), nil
+}
+
+// Assembles the size report of a file.
+func encodeInfo(size int64) []byte {
+	return binary.AppendUvarint([]byte{msgInfo}, uint64(size))
+}
+
+// Disassembles the size report of a file (without its kind byte).
+func decodeSize(data []byte) (int64, int) {
+	size, n := binary.Uvarint(data)
+	return int64(size), n
+}
+
+// Assembles a checksummed chunk of a file.
+func encodeChunk(offset int64, data []byte) []byte {
+	buf := make([]byte, 1, 1+binary.MaxVarintLen64+4+len(data))
+	buf[0] = msgChunk
+	buf = binary.AppendUvarint(buf, uint64(offset))
+	buf = binary.BigEndian.AppendUint32(buf, crc32.ChecksumIEEE(data))
+	return append(buf, data...)
+}
+
+// Disassembles a chunk (without its kind byte), verifying its checksum.
+func decodeChunk(data []byte) (int64, []byte, error) {
+	offset, n := binary.Uvarint(data)
+	if n <= 0 || len(data) < n+4 {
+		return 0, nil, errMalformed
+	}
+	sum, chunk := binary.BigEndian.Uint32(data[n:]), data[n+4:]
+	if crc32.ChecksumIEEE(chunk) != sum {
+		return 0, nil, errChecksum
+	}
+	return int64(offset), chunk, nil
+}
@@ -0,0 +1,209 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the TCP port forwarding through tunnels, giving operators access to
+// e.g. ssh or database ports of remote machines across the Iris fabric.
+//
+// The forwarder listens on a local address, and pipes each accepted connection
+// through a new tunnel to the forwarding service cluster, whose member dials
+// the configured target and pipes the tunnel into that connection. Tunnels have
+// no half-close, so either side finishing tears down the whole connection.
+
+package iris
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// Timeout of the tunnel construction and target dialing of a forwarded
+// connection, unless a default tunnel timeout is configured.
+var forwardTimeout = 10 * time.Second
+
+// Reply fault of the requests sent to a port forwarding service.
+var errForwardRequest = errors.New("requests not supported by port forwarder")
+
+// Size of the buffer the TCP streams are read into before tunneling.
+var forwardBuffer = 32 * 1024
+
+// Local listener forwarding its accepted connections to a remote service.
+type Forwarder struct {
+	conn     *Connection  // Connection to open the tunnels through
+	cluster  string       // Forwarding service cluster to tunnel to
+	listener net.Listener // Local listener accepting the connections
+
+	live   map[net.Conn]struct{} // Currently forwarded local connections
+	closed bool                  // Whether the forwarder was closed
+	lock   sync.Mutex            // Protects the live connections
+	pend   sync.WaitGroup        // Pipes of the forwarded connections
+}
+
+// Listens on a local address and forwards each accepted TCP connection through
+// a new tunnel to a member of the forwarding service cluster (RegisterForward).
+func ForwardPort(conn *Connection, cluster string, localAddr string) (*Forwarder, error) {
+	listener, err := net.Listen("tcp", localAddr)
+	if err != nil {
+		return nil, err
+	}
+	f := &Forwarder{
+		conn:     conn,
+		cluster:  cluster,
+		listener: listener,
+		live:     make(map[net.Conn]struct{}),
+	}
+	f.pend.Add(1)
+	go f.accept()
+
+	return f, nil
+}
+
+// Retrieves the local address the forwarder listens on.
+func (f *Forwarder) Addr() net.Addr {
+	return f.listener.Addr()
+}
+
+// Stops accepting new connections and tears down the forwarded ones.
+func (f *Forwarder) Close() error {
+	err := f.listener.Close()
+
+	f.lock.Lock()
+	f.closed = true
+	for sock := range f.live {
+		sock.Close()
+	}
+	f.lock.Unlock()
+
+	f.pend.Wait()
+	return err
+}
+
+// Accepts the local connections until the listener is closed, forwarding each
+// through its own tunnel.
+func (f *Forwarder) accept() {
+	defer f.pend.Done()
+
+	for {
+		sock, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		f.lock.Lock()
+		if f.closed {
+			f.lock.Unlock()
+			sock.Close()
+			return
+		}
+		f.live[sock] = struct{}{}
+		f.pend.Add(1)
+		f.lock.Unlock()
+
+		go func() {
+			defer f.pend.Done()
+			defer func() {
+				f.lock.Lock()
+				delete(f.live, sock)
+				f.lock.Unlock()
+			}()
+			f.forward(sock)
+		}()
+	}
+}
+
+// Opens a tunnel to the forwarding service and pipes a local connection into it.
+func (f *Forwarder) forward(sock net.Conn) {
+	defer sock.Close()
+
+	timeout := f.conn.options.Defaults.tunnel()
+	if timeout == 0 {
+		timeout = forwardTimeout
+	}
+	tun, err := f.conn.Tunnel(f.cluster, timeout)
+	if err != nil {
+		f.conn.Log.Warn("failed to tunnel forwarded connection", "cluster", f.cluster, "remote", sock.RemoteAddr(), "reason", err)
+		return
+	}
+	pipeTunnel(sock, tun)
+}
+
+// Service handler dialing a fixed target for every inbound tunnel.
+type forwardHandler struct {
+	target string      // Address of the TCP endpoint to forward to
+	conn   *Connection // Connection of the service, for logging
+}
+
+func (f *forwardHandler) HandleBroadcast(msg []byte)               {}
+func (f *forwardHandler) HandleRequest(req []byte) ([]byte, error) { return nil, errForwardRequest }
+func (f *forwardHandler) HandleDrop(reason error)                  {}
+
+func (f *forwardHandler) Init(conn *Connection) error {
+	f.conn = conn
+	return nil
+}
+
+func (f *forwardHandler) HandleTunnel(tun *Tunnel) {
+	sock, err := net.DialTimeout("tcp", f.target, forwardTimeout)
+	if err != nil {
+		f.conn.Log.Warn("failed to dial forwarding target", "target", f.target, "reason", err)
+		tun.Close()
+		return
+	}
+	defer sock.Close()
+
+	pipeTunnel(sock, tun)
+}
+
+// Registers a port forwarding service into the specified cluster, piping every
+// inbound tunnel into a new TCP connection to the target address. Forward local
+// ports to it from any other node with ForwardPort.
+func RegisterForward(port int, cluster string, target string) (*Service, error) {
+	return Register(port, cluster, &forwardHandler{target: target}, nil)
+}
+
+// Pipes a TCP connection and a tunnel into each other until either side is
+// done, then tears down both.
+func pipeTunnel(sock net.Conn, tun *Tunnel) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	// Stream the socket into the tunnel
+	go func() {
+		defer close(done)
+		defer tun.Close()
+
+		buf := make([]byte, forwardBuffer)
+		for {
+			n, err := sock.Read(buf)
+			if n > 0 {
+				if err := tun.SendContext(ctx, append([]byte(nil), buf[:n]...)); err != nil {
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	// Stream the tunnel into the socket, tolerating default receive timeouts
+	for {
+		msg, err := tun.Recv(0)
+		if err == ErrTimeout {
+			continue
+		}
+		if err != nil {
+			break
+		}
+		if _, err := sock.Write(msg); err != nil {
+			break
+		}
+	}
+	cancel()
+	sock.Close()
+	tun.Close()
+	<-done
+}
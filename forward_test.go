@@ -0,0 +1,95 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// Tests that local TCP connections are forwarded to the remote target.
+func TestForwardPort(t *testing.T) {
+	// Start a TCP echo server to forward to
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("target listen failed: %v.", err)
+	}
+	defer target.Close()
+
+	go func() {
+		for {
+			sock, err := target.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer sock.Close()
+				io.Copy(sock, sock)
+			}()
+		}
+	}()
+	// Register the forwarding service and forward a local port to it
+	serv, err := RegisterForward(config.relay, config.cluster, target.Addr().String())
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	fwd, err := ForwardPort(conn, config.cluster, "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("forwarding failed: %v.", err)
+	}
+	// Exchange some data through multiple forwarded connections
+	for i := 0; i < 3; i++ {
+		sock, err := net.Dial("tcp", fwd.Addr().String())
+		if err != nil {
+			t.Fatalf("dial %d failed: %v.", i, err)
+		}
+		defer sock.Close()
+
+		data := bytes.Repeat([]byte{byte(i)}, 100*1024)
+		go sock.Write(data)
+
+		sock.SetReadDeadline(time.Now().Add(time.Second))
+		reply := make([]byte, len(data))
+		if _, err := io.ReadFull(sock, reply); err != nil {
+			t.Fatalf("connection %d read failed: %v.", i, err)
+		}
+		if !bytes.Equal(reply, data) {
+			t.Fatalf("connection %d data mismatch.", i)
+		}
+	}
+	// Close the forwarder and ensure the connections are torn down
+	sock, err := net.Dial("tcp", fwd.Addr().String())
+	if err != nil {
+		t.Fatalf("dial failed: %v.", err)
+	}
+	defer sock.Close()
+
+	if _, err := sock.Write([]byte("ping")); err != nil {
+		t.Fatalf("write failed: %v.", err)
+	}
+	sock.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(sock, make([]byte, 4)); err != nil {
+		t.Fatalf("read failed: %v.", err)
+	}
+	if err := fwd.Close(); err != nil {
+		t.Fatalf("forwarder close failed: %v.", err)
+	}
+	if _, err := sock.Read(make([]byte, 1)); err == nil {
+		t.Fatalf("forwarded connection alive after close.")
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the stream framing codecs of tunnels.
+//
+// A framed tunnel treats the tunnel as a byte stream: each value sent is framed
+// and written as a single tunnel message, but received messages are reassembled
+// into a stream and split along the frame boundaries, so frames split across or
+// batched into messages (e.g. by a peer piping a TCP stream into the tunnel) are
+// decoded all the same.
+
+package iris
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Maximum size of a single frame, protecting against corrupt length prefixes.
+const maxFrameSize = 64 * 1024 * 1024
+
+// Framing format of the values exchanged over a framed tunnel.
+type Framing[T any] interface {
+	// Appends the frame of a value to the buffer.
+	AppendFrame(buf []byte, value T) ([]byte, error)
+
+	// Decodes the frame at the start of the buffer, returning the value and the
+	// number of bytes consumed, or zero if the frame is not yet complete.
+	DecodeFrame(buf []byte) (T, int, error)
+}
+
+// Framing of raw binary blobs, each prefixed by its length as a 4 byte big
+// endian integer.
+type LengthFraming struct{}
+
+// Implements Framing.AppendFrame.
+func (LengthFraming) AppendFrame(buf []byte, value []byte) ([]byte, error) {
+	if len(value) > maxFrameSize {
+		return buf, fmt.Errorf("frame too large: %d bytes", len(value))
+	}
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(value)))
+	return append(buf, value...), nil
+}
+
+// Implements Framing.DecodeFrame.
+func (LengthFraming) DecodeFrame(buf []byte) ([]byte, int, error) {
+	if len(buf) < 4 {
+		return nil, 0, nil
+	}
+	size := binary.BigEndian.Uint32(buf)
+	if size > maxFrameSize {
+		return nil, 0, fmt.Errorf("frame too large: %d bytes", size)
+	}
+	if len(buf) < 4+int(size) {
+		return nil, 0, nil
+	}
+	return append([]byte{}, buf[4:4+size]...), 4 + int(size), nil
+}
+
+// Framing of values serialized into JSON, each terminated by a newline.
+type JSONLinesFraming[T any] struct{}
+
+// Implements Framing.AppendFrame.
+func (JSONLinesFraming[T]) AppendFrame(buf []byte, value T) ([]byte, error) {
+	blob, err := json.Marshal(value)
+	if err != nil {
+		return buf, err
+	}
+	buf = append(buf, blob...)
+	return append(buf, '\n'), nil
+}
+
+// Implements Framing.DecodeFrame.
+func (JSONLinesFraming[T]) DecodeFrame(buf []byte) (T, int, error) {
+	var value T
+
+	end := bytes.IndexByte(buf, '\n')
+	if end < 0 {
+		if len(buf) > maxFrameSize {
+			return value, 0, fmt.Errorf("frame too large: over %d bytes", len(buf))
+		}
+		return value, 0, nil
+	}
+	if err := json.Unmarshal(buf[:end], &value); err != nil {
+		return value, 0, err
+	}
+	return value, end + 1, nil
+}
+
+// Framing of values serialized by custom functions, each prefixed by its length
+// as a base 128 varint (e.g. length delimited protobuf messages).
+type VarintFraming[T any] struct {
+	Marshal   func(value T) ([]byte, error) // Serializer of the values
+	Unmarshal func(data []byte) (T, error)  // Deserializer of the values
+}
+
+// Implements Framing.AppendFrame.
+func (f VarintFraming[T]) AppendFrame(buf []byte, value T) ([]byte, error) {
+	blob, err := f.Marshal(value)
+	if err != nil {
+		return buf, err
+	}
+	if len(blob) > maxFrameSize {
+		return buf, fmt.Errorf("frame too large: %d bytes", len(blob))
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(blob)))
+	return append(buf, blob...), nil
+}
+
+// Implements Framing.DecodeFrame.
+func (f VarintFraming[T]) DecodeFrame(buf []byte) (T, int, error) {
+	var value T
+
+	size, n := binary.Uvarint(buf)
+	switch {
+	case n == 0:
+		return value, 0, nil
+	case n < 0 || size > maxFrameSize:
+		return value, 0, errors.New("frame too large")
+	case uint64(len(buf)-n) < size:
+		return value, 0, nil
+	}
+	value, err := f.Unmarshal(buf[n : n+int(size)])
+	if err != nil {
+		return value, 0, err
+	}
+	return value, n + int(size), nil
+}
+
+// Tunnel exchanging framed values instead of raw messages.
+type FramedTunnel[T any] struct {
+	tun     *Tunnel    // Tunnel carrying the frames
+	framing Framing[T] // Framing format of the values
+
+	pending  []byte     // Received stream not yet decoded into frames
+	sendLock sync.Mutex // Serializes the senders
+	recvLock sync.Mutex // Serializes the receivers
+}
+
+// Wraps a tunnel to exchange values in the given framing format. The tunnel
+// must not be used directly any more.
+func NewFramedTunnel[T any](tun *Tunnel, framing Framing[T]) *FramedTunnel[T] {
+	return &FramedTunnel[T]{
+		tun:     tun,
+		framing: framing,
+	}
+}
+
+// Frames a value and sends it over the tunnel. The semantics follow Tunnel.Send.
+func (f *FramedTunnel[T]) Send(value T, timeout time.Duration) error {
+	frame, err := f.framing.AppendFrame(nil, value)
+	if err != nil {
+		return err
+	}
+	f.sendLock.Lock()
+	defer f.sendLock.Unlock()
+
+	return f.tun.Send(frame, timeout)
+}
+
+// Retrieves the next framed value from the tunnel, blocking until one is fully
+// arrived or the operation times out. The semantics follow Tunnel.Recv.
+//
+// A timeout doesn't lose any partially arrived frame, the next receive resumes
+// where this one left off.
+func (f *FramedTunnel[T]) Recv(timeout time.Duration) (T, error) {
+	f.recvLock.Lock()
+	defer f.recvLock.Unlock()
+
+	// Create the timeout signaler, shared by all the message receives
+	if timeout == 0 {
+		timeout = f.tun.conn.options.Defaults.recv()
+	}
+	var after <-chan time.Time
+	if timeout != 0 {
+		after = f.tun.conn.clock.After(timeout)
+	}
+	// Keep decoding and pulling messages until a frame completes
+	for {
+		value, n, err := f.framing.DecodeFrame(f.pending)
+		if err != nil {
+			return value, err
+		}
+		if n > 0 {
+			f.pending = f.pending[n:]
+			if len(f.pending) == 0 {
+				f.pending = nil
+			}
+			return value, nil
+		}
+		msg, err := f.tun.recv(after)
+		if err != nil {
+			return value, err
+		}
+		f.pending = append(f.pending, msg...)
+	}
+}
+
+// Closes the underlying tunnel.
+func (f *FramedTunnel[T]) Close() error {
+	return f.tun.Close()
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// Tests that framed values round trip through a tunnel, regardless of how the
+// frames are split across or batched into tunnel messages.
+func TestFramedTunnel(t *testing.T) {
+	// Register an echo service and open a tunnel to it
+	serv, err := Register(config.relay, config.cluster, new(tunnelTestHandler), nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	tun, err := conn.Tunnel(config.cluster, time.Second)
+	if err != nil {
+		t.Fatalf("tunnel construction failed: %v.", err)
+	}
+	framed := NewFramedTunnel[[]byte](tun, LengthFraming{})
+	defer framed.Close()
+
+	// Send a few frames one by one
+	values := [][]byte{[]byte("alpha"), bytes.Repeat([]byte{'b'}, 3000), []byte("gamma")}
+	for i, value := range values {
+		if err := framed.Send(value, time.Second); err != nil {
+			t.Fatalf("value %d: send failed: %v.", i, err)
+		}
+	}
+	// Send the same frames batched and split arbitrarily over raw messages
+	var stream []byte
+	for _, value := range values {
+		stream, _ = LengthFraming{}.AppendFrame(stream, value)
+	}
+	for _, part := range [][]byte{stream[:3], stream[3:20], stream[20:]} {
+		if err := tun.Send(part, time.Second); err != nil {
+			t.Fatalf("raw send failed: %v.", err)
+		}
+	}
+	// Ensure all the echoed frames are reassembled
+	for i := 0; i < 2*len(values); i++ {
+		value, err := framed.Recv(time.Second)
+		if err != nil {
+			t.Fatalf("frame %d: receive failed: %v.", i, err)
+		}
+		if want := values[i%len(values)]; !bytes.Equal(value, want) {
+			t.Fatalf("frame %d: value mismatch: have %q, want %q.", i, value, want)
+		}
+	}
+	// Ensure a receive with nothing in flight times out without losing state
+	if _, err := framed.Recv(10 * time.Millisecond); err != ErrTimeout {
+		t.Fatalf("idle receive mismatch: have %v, want %v.", err, ErrTimeout)
+	}
+}
+
+// Tests that JSON lines frames round trip and reject malformed streams.
+func TestJSONLinesFraming(t *testing.T) {
+	type event struct {
+		Name  string `json:"name"`
+		Count int    `json:"count"`
+	}
+	framing := JSONLinesFraming[event]{}
+
+	stream, err := framing.AppendFrame(nil, event{"first", 1})
+	if err != nil {
+		t.Fatalf("failed to frame value: %v.", err)
+	}
+	stream = append(stream, []byte("{\"name\":\"second\",\"count\":2}\n{\"na")...)
+
+	for _, want := range []event{{"first", 1}, {"second", 2}} {
+		value, n, err := framing.DecodeFrame(stream)
+		if err != nil || n == 0 {
+			t.Fatalf("failed to decode frame: %d bytes, %v.", n, err)
+		}
+		if value != want {
+			t.Fatalf("value mismatch: have %+v, want %+v.", value, want)
+		}
+		stream = stream[n:]
+	}
+	if _, n, err := framing.DecodeFrame(stream); n != 0 || err != nil {
+		t.Fatalf("partial frame decoded: %d bytes, %v.", n, err)
+	}
+	if _, _, err := framing.DecodeFrame([]byte("garbage\n")); err == nil {
+		t.Fatalf("malformed frame decoded.")
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the gathering of answers to a broadcast question.
+//
+// Asking every member of a cluster something (e.g. a service census, or the
+// confirmation of a cache invalidation) needs a broadcast paired with replies,
+// which Iris doesn't provide. The asker thus subscribes to a temporary answer
+// topic, broadcasts the question with the topic attached in its envelope, and
+// collects whatever is published there within a time window. Members answer
+// through Answer, using the metadata the question arrived with.
+
+package iris
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Prefix of the temporary topics the answers of a question are collected on.
+const gatherTopicPrefix = "iris.gather."
+
+// Answer of a cluster member to a gathering broadcast.
+type Answer struct {
+	Sender string // Name of the answering member (empty if metadata is disabled there)
+	Reply  []byte // Payload of the answer
+}
+
+// Broadcasts a question to all members of a cluster, and gathers the answers
+// published back by them within the window, in their order of arrival.
+//
+// Only members handling broadcasts with metadata can answer, and both questions
+// and answers are best effort, so a member's silence doesn't mean it's absent.
+func (c *Connection) Gather(cluster string, question []byte, window time.Duration) ([]Answer, error) {
+	if err := c.checkBroadcast(cluster, question); err != nil {
+		return nil, err
+	}
+	// Subscribe to a temporary topic to collect the answers on
+	topic := gatherTopicPrefix + newCorrelationID()
+	collector := new(gatherCollector)
+	if err := c.Subscribe(topic, collector, nil); err != nil {
+		return nil, err
+	}
+	defer c.Unsubscribe(topic)
+
+	// Broadcast the question along with the answer destination
+	c.Log.Debug("sending new gathering broadcast", "cluster", cluster, "topic", topic, "data", logLazyBlob(question))
+	question, err := c.envelope(cluster, question, map[string]string{headerQuestion: topic})
+	if err != nil {
+		return nil, err
+	}
+	if err := c.lane(cluster).sendBroadcast(cluster, question); err != nil {
+		return nil, err
+	}
+	atomic.AddUint64(&c.stats.bcastSent, 1)
+
+	// Wait for the window to pass and return whatever arrived
+	select {
+	case <-c.clock.After(window):
+	case <-c.term:
+		return nil, ErrClosed
+	}
+	return collector.gathered(), nil
+}
+
+// Answers a gathering broadcast, publishing the reply to the asker's topic. The
+// metadata must be the one the question was delivered with.
+func (c *Connection) Answer(meta *Metadata, reply []byte) error {
+	if meta == nil || meta.question == "" {
+		return errors.New("not a gathering broadcast")
+	}
+	return c.Publish(meta.question, reply)
+}
+
+// Topic handler collecting the answers of a question.
+type gatherCollector struct {
+	answers []Answer   // Answers arrived so far
+	lock    sync.Mutex // Protects the answer list
+}
+
+// Implements TopicHandler.HandleEvent.
+func (g *gatherCollector) HandleEvent(event []byte) {
+	g.HandleEventWithMetadata(event, new(Metadata))
+}
+
+// Implements EventMetadataHandler.HandleEventWithMetadata, recording the answer
+// along with its sender.
+func (g *gatherCollector) HandleEventWithMetadata(event []byte, meta *Metadata) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	g.answers = append(g.answers, Answer{Sender: meta.Sender, Reply: event})
+}
+
+// Retrieves the answers collected so far.
+func (g *gatherCollector) gathered() []Answer {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	return append([]Answer(nil), g.answers...)
+}
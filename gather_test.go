@@ -0,0 +1,81 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+)
+
+// Service handler answering gathering broadcasts with its own name.
+type gatherTestHandler struct {
+	conn *Connection
+	name string
+}
+
+func (g *gatherTestHandler) Init(conn *Connection) error              { g.conn = conn; return nil }
+func (g *gatherTestHandler) HandleBroadcast(msg []byte)               { panic("not implemented") }
+func (g *gatherTestHandler) HandleRequest(req []byte) ([]byte, error) { panic("not implemented") }
+func (g *gatherTestHandler) HandleTunnel(tun *Tunnel)                 { panic("not implemented") }
+func (g *gatherTestHandler) HandleDrop(reason error)                  { panic("not implemented") }
+
+func (g *gatherTestHandler) HandleBroadcastWithMetadata(msg []byte, meta *Metadata) {
+	g.conn.Answer(meta, []byte(g.name))
+}
+
+// Tests that the answers of all members to a broadcast question are gathered.
+func TestGather(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		members int
+	}{3}
+
+	// Register a few members answering with their names
+	for i := 0; i < conf.members; i++ {
+		name := fmt.Sprintf("member-%d", i)
+		options := &Options{Metadata: true, Name: name}
+
+		serv, err := RegisterWithOptions(config.relay, config.cluster, &gatherTestHandler{name: name}, nil, options)
+		if err != nil {
+			t.Fatalf("registration %d failed: %v.", i, err)
+		}
+		defer serv.Unregister()
+	}
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Ask the cluster and verify all members answered
+	answers, err := conn.Gather(config.cluster, []byte("census"), 250*time.Millisecond)
+	if err != nil {
+		t.Fatalf("gathering failed: %v.", err)
+	}
+	if len(answers) != conf.members {
+		t.Fatalf("answer count mismatch: have %d, want %d.", len(answers), conf.members)
+	}
+	names := make([]string, 0, len(answers))
+	for _, answer := range answers {
+		if answer.Sender != string(answer.Reply) {
+			t.Fatalf("answer sender mismatch: have %s, want %s.", answer.Sender, answer.Reply)
+		}
+		names = append(names, answer.Sender)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		if want := fmt.Sprintf("member-%d", i); name != want {
+			t.Fatalf("answer %d mismatch: have %s, want %s.", i, name, want)
+		}
+	}
+	// Ensure plain broadcasts cannot be answered
+	if err := conn.Answer(new(Metadata), []byte("stray")); err == nil {
+		t.Fatalf("answer to plain broadcast succeeded.")
+	}
+}
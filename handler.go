@@ -0,0 +1,106 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the context carrying version of the service handler.
+
+package iris
+
+import (
+	"context"
+	"errors"
+)
+
+// Context carrying variant of the ServiceHandler. Every callback receives a
+// context cancelled when the connection drops or the service is unregistered,
+// carrying the correlation id of the inbound message if any. Register accepts
+// both handler versions.
+type ServiceHandlerV2 interface {
+	// Called once after the service is registered in the Iris network, but before
+	// and handlers are activated.
+	Init(ctx context.Context, conn *Connection) error
+
+	// Callback invoked whenever a broadcast message arrives designated to the
+	// cluster of which this particular service instance is part of.
+	HandleBroadcast(ctx context.Context, message []byte, meta *Metadata)
+
+	// Callback invoked whenever a request designated to the service's cluster is
+	// load-balanced to this particular service instance. The same reply rules
+	// apply as for ServiceHandler.HandleRequest. The context is also cancelled
+	// once the caller abandons the request or its timeout runs out.
+	HandleRequest(ctx context.Context, request []byte, meta *Metadata) ([]byte, error)
+
+	// Callback invoked whenever a tunnel designated to the service's cluster is
+	// constructed from a remote node to this particular instance. The context is
+	// the tunnel's own, cancelled when either end closes it or the connection
+	// drops, and the metadata headers are the ones attached by the initiator.
+	HandleTunnel(ctx context.Context, tunnel *Tunnel, meta *Metadata)
+
+	// Callback notifying the service that the local relay dropped its connection.
+	// The context is already cancelled by the time it is invoked.
+	HandleDrop(ctx context.Context, reason error)
+}
+
+// Converts a user supplied handler of either version into a ServiceHandler.
+func serviceHandler(handler interface{}) (ServiceHandler, error) {
+	switch handler := handler.(type) {
+	case nil:
+		return nil, errors.New("nil service handler")
+	case ServiceHandler:
+		return handler, nil
+	case ServiceHandlerV2:
+		return &handlerV2Adapter{handler: handler}, nil
+	default:
+		return nil, errors.New("unsupported service handler type")
+	}
+}
+
+// Adapter running a ServiceHandlerV2 through the ServiceHandler callbacks.
+type handlerV2Adapter struct {
+	handler ServiceHandlerV2
+	conn    *Connection
+}
+
+// Derives the callback context of a message from the connection's.
+func (a *handlerV2Adapter) context(meta *Metadata) context.Context {
+	ctx := a.conn.ctx
+	if meta.ctx != nil {
+		ctx = meta.ctx
+	}
+	if meta.CorrelationID == "" {
+		return ctx
+	}
+	return WithCorrelationID(ctx, meta.CorrelationID)
+}
+
+func (a *handlerV2Adapter) Init(conn *Connection) error {
+	a.conn = conn
+	return a.handler.Init(conn.ctx, conn)
+}
+
+func (a *handlerV2Adapter) HandleBroadcast(message []byte) {
+	panic("unreachable code")
+}
+
+func (a *handlerV2Adapter) HandleBroadcastWithMetadata(message []byte, meta *Metadata) {
+	a.handler.HandleBroadcast(a.context(meta), message, meta)
+}
+
+func (a *handlerV2Adapter) HandleRequest(request []byte) ([]byte, error) {
+	panic("unreachable code")
+}
+
+func (a *handlerV2Adapter) HandleRequestWithMetadata(request []byte, meta *Metadata) ([]byte, error) {
+	return a.handler.HandleRequest(a.context(meta), request, meta)
+}
+
+func (a *handlerV2Adapter) HandleTunnel(tunnel *Tunnel) {
+	meta := &Metadata{Received: a.conn.clock.Now(), Headers: tunnel.Header()}
+	a.handler.HandleTunnel(tunnel.Context(), tunnel, meta)
+}
+
+func (a *handlerV2Adapter) HandleDrop(reason error) {
+	a.handler.HandleDrop(a.conn.ctx, reason)
+}
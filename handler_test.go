@@ -0,0 +1,84 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// Context carrying service handler recording the request contexts and their
+// states while handling.
+type handlerV2TestHandler struct {
+	ctxs chan context.Context
+	errs chan error
+}
+
+func (h *handlerV2TestHandler) Init(ctx context.Context, conn *Connection) error { return nil }
+
+func (h *handlerV2TestHandler) HandleBroadcast(ctx context.Context, message []byte, meta *Metadata) {
+	panic("not implemented")
+}
+
+func (h *handlerV2TestHandler) HandleRequest(ctx context.Context, request []byte, meta *Metadata) ([]byte, error) {
+	h.ctxs <- ctx
+	h.errs <- ctx.Err()
+	return request, nil
+}
+
+func (h *handlerV2TestHandler) HandleTunnel(ctx context.Context, tunnel *Tunnel, meta *Metadata) {
+	panic("not implemented")
+}
+
+func (h *handlerV2TestHandler) HandleDrop(ctx context.Context, reason error) {
+	panic("not implemented")
+}
+
+// Tests that v2 handlers receive contexts carrying the correlation ids, which
+// get cancelled once the requests conclude.
+func TestServiceHandlerV2(t *testing.T) {
+	handler := &handlerV2TestHandler{
+		ctxs: make(chan context.Context, 1),
+		errs: make(chan error, 1),
+	}
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	conn, err := ConnectWithOptions(config.relay, &Options{Metadata: true})
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	ctx := WithCorrelationID(context.Background(), "test-correlation")
+	if _, err := conn.RequestContext(ctx, config.cluster, []byte{0x00}, time.Second); err != nil {
+		t.Fatalf("request failed: %v.", err)
+	}
+	ctx = <-handler.ctxs
+	if id := CorrelationID(ctx); id != "test-correlation" {
+		t.Fatalf("correlation mismatch: have %v, want %v.", id, "test-correlation")
+	}
+	if err := <-handler.errs; err != nil {
+		t.Fatalf("context cancelled while handling: %v.", err)
+	}
+	defer serv.Unregister()
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("context not cancelled after reply.")
+	}
+}
+
+// Tests that handlers of unknown types are refused.
+func TestServiceHandlerInvalid(t *testing.T) {
+	if _, err := Register(config.relay, config.cluster, struct{}{}, nil); err == nil {
+		t.Fatalf("registration with invalid handler succeeded.")
+	}
+}
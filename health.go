@@ -0,0 +1,143 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the active health probing of the relay link and remote clusters.
+//
+// The relay is probed with a confirmed publish to a reserved topic, the round
+// trip of which is the relay latency. Clusters are probed with a request marked
+// by an envelope header, answered by the binding of the receiving service right
+// away, without queuing it or involving the service handler. Cluster members
+// are counted similarly, by a marked broadcast each binding acknowledges with
+// a receipt.
+
+package iris
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gopkg.in/project-iris/iris-go.v1/relaywire"
+)
+
+// Reserved topic the relay probes are published to.
+const healthTopic = "iris.health"
+
+// Timeout of the health probes if the context carries no deadline.
+var defaultHealthTimeout = 5 * time.Second
+
+// Time to collect the receipts of a membership probe in.
+var clusterProbeWindow = 250 * time.Millisecond
+
+// Time to wait between membership probes of a cluster still short of members.
+var clusterProbeInterval = time.Second
+
+// Outcome of an active health probe of a connection.
+type HealthReport struct {
+	RelayRTT time.Duration // Round trip time to the relay (zero if the relay can't confirm publishes)
+	Version  string        // Protocol version agreed with the relay
+	Features []string      // Extension features agreed with the relay
+
+	Cluster    string        // Remote cluster probed (empty if none)
+	ClusterRTT time.Duration // Round trip time to a member of the probed cluster
+}
+
+// Actively probes the relay link, measuring its round trip time and reporting
+// the negotiated protocol details. The probe is bounded by the deadline of the
+// context, or a few seconds if it has none.
+//
+// Relays not supporting publish confirmations can't be timed, so only the link
+// liveness is verified with them, leaving the round trip time at zero.
+func (c *Connection) Health(ctx context.Context) (HealthReport, error) {
+	report := HealthReport{
+		Version:  c.version,
+		Features: c.Features(),
+	}
+	select {
+	case <-c.term:
+		return report, ErrClosed
+	default:
+	}
+	if !c.supports(relaywire.FeatureConfirm) {
+		return report, ctx.Err()
+	}
+	start := c.clock.Now()
+	if _, err := c.publishConfirmed(ctx, healthTopic, []byte{0x00}, healthTimeout(ctx)); err != nil {
+		return report, err
+	}
+	report.RelayRTT = since(c.clock, start)
+	return report, nil
+}
+
+// Actively probes the relay link similarly to Health, and afterwards a member of
+// the given cluster too, measuring the round trip of a no-op request answered
+// by the remote binding itself. Services running older binding versions handle
+// the probe as a regular request.
+func (c *Connection) HealthWithCluster(ctx context.Context, cluster string) (HealthReport, error) {
+	report, err := c.Health(ctx)
+	if err != nil {
+		return report, err
+	}
+	report.Cluster = cluster
+
+	probe, err := c.envelope(cluster, []byte{0x00}, map[string]string{headerProbe: "1"})
+	if err != nil {
+		return report, err
+	}
+	timeout := healthTimeout(ctx)
+	if timeout < time.Millisecond {
+		return report, context.DeadlineExceeded
+	}
+	start := c.clock.Now()
+	if _, err := c.request(ctx, c.Log, cluster, probe, timeout, int(timeout.Nanoseconds()/1000000)); err != nil {
+		return report, err
+	}
+	report.ClusterRTT = since(c.clock, start)
+	return report, nil
+}
+
+// Retrieves the time remaining until the deadline of the context, or the default
+// health probe timeout if it has none.
+func healthTimeout(ctx context.Context) time.Duration {
+	if deadline, ok := ctx.Deadline(); ok {
+		return time.Until(deadline)
+	}
+	return defaultHealthTimeout
+}
+
+// Blocks until a cluster has at least the given number of registered members, or
+// the context is cancelled. Services may use it to hold back their readiness
+// until their dependencies come online.
+//
+// Members are counted by periodically probing the cluster with a broadcast that
+// every binding acknowledges, so only members running a binding version able
+// to do so are counted, unready ones included.
+func WaitForCluster(ctx context.Context, conn *Connection, cluster string, minInstances int) error {
+	// Sanity check on the arguments
+	if len(cluster) == 0 {
+		return errors.New("empty cluster identifier")
+	}
+	for {
+		receipts, err := conn.broadcastTracked(cluster, []byte{0x00}, map[string]string{headerProbe: "1"}, clusterProbeWindow)
+		if err != nil {
+			return err
+		}
+		select {
+		case count := <-receipts:
+			if count >= minInstances {
+				return nil
+			}
+			conn.Log.Debug("waiting for cluster members", "cluster", cluster, "have", count, "want", minInstances)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		select {
+		case <-conn.clock.After(clusterProbeInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gopkg.in/project-iris/iris-go.v1/relaywire"
+)
+
+// Tests that the relay and remote clusters can be actively probed.
+func TestHealth(t *testing.T) {
+	// Register a service that must never see the probes
+	serv, err := Register(config.relay, config.cluster, new(tunnelTestHandler), nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Probe the relay and verify the negotiated details
+	report, err := conn.Health(context.Background())
+	if err != nil {
+		t.Fatalf("relay probe failed: %v.", err)
+	}
+	if report.Version != conn.version {
+		t.Fatalf("version mismatch: have %v, want %v.", report.Version, conn.version)
+	}
+	if conn.supports(relaywire.FeatureConfirm) && report.RelayRTT <= 0 {
+		t.Fatalf("relay round trip not measured: %v.", report.RelayRTT)
+	}
+	// Probe the service cluster and a non existent one
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	report, err = conn.HealthWithCluster(ctx, config.cluster)
+	if err != nil {
+		t.Fatalf("cluster probe failed: %v.", err)
+	}
+	if report.Cluster != config.cluster || report.ClusterRTT <= 0 {
+		t.Fatalf("cluster report mismatch: have %v/%v, want %v/>0.", report.Cluster, report.ClusterRTT, config.cluster)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	if _, err := conn.HealthWithCluster(ctx, config.cluster+"-missing"); err == nil {
+		t.Fatalf("probe of missing cluster succeeded.")
+	}
+	// Verify that closed connections are reported unhealthy
+	conn.Close()
+	if _, err := conn.Health(context.Background()); err != ErrClosed {
+		t.Fatalf("closed connection probe mismatch: have %v, want %v.", err, ErrClosed)
+	}
+}
+
+// Tests that waiting for cluster members blocks until enough are registered.
+func TestWaitForCluster(t *testing.T) {
+	// Register a service that must never see the probes
+	serv, err := Register(config.relay, config.cluster, new(tunnelTestHandler), nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Wait for the single member, and for more than available
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := WaitForCluster(ctx, conn, config.cluster, 1); err != nil {
+		t.Fatalf("failed to wait for single member: %v.", err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	if err := WaitForCluster(ctx, conn, config.cluster, 2); err != context.DeadlineExceeded {
+		t.Fatalf("wait for missing member mismatch: have %v, want %v.", err, context.DeadlineExceeded)
+	}
+	// Start waiting for two members, and register the second one meanwhile
+	errc := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		errc <- WaitForCluster(ctx, conn, config.cluster, 2)
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	second, err := Register(config.relay, config.cluster, new(tunnelTestHandler), nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer second.Unregister()
+
+	if err := <-errc; err != nil {
+		t.Fatalf("failed to wait for second member: %v.", err)
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the exactly-once processing of requests: callers tag requests with
+// an idempotency key, and services record the outcome of each handled key in a
+// pluggable store, replaying it to duplicates instead of re-running the handler.
+// Each key is reserved in the store before its handler runs, so duplicates that
+// arrive while the original is still in flight (e.g. retries of a timed out
+// request) wait for its outcome instead of racing it.
+//
+// The bundled store is memory backed, so it only suppresses duplicates within a
+// single process lifetime. Persistent stores (bolt, redis, SQL, etc.) surviving
+// restarts can be plugged in by implementing the IdempotencyStore interface.
+
+package iris
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Persistent record of the requests already processed by a service, keyed by
+// their idempotency keys. Implementations must be safe for concurrent use.
+type IdempotencyStore interface {
+	// Atomically claims a key for processing, or returns false if it was already
+	// claimed or processed. Claims should lapse eventually, lest a process dying
+	// mid-request block the key for good.
+	Reserve(key string) (claimed bool, err error)
+
+	// Retrieves the outcome of a processed request, or false if the key is unknown
+	// or still in flight.
+	Load(key string) (reply []byte, fault string, ok bool, err error)
+
+	// Records the outcome of a processed request, concluding its claim.
+	Save(key string, reply []byte, fault string) error
+
+	// Drops the claim of a request whose outcome wasn't recorded, letting its
+	// duplicates be processed anew.
+	Release(key string) error
+}
+
+// Interval between the checks for the outcome of an in-flight duplicate.
+var idempotencyPoll = 10 * time.Millisecond
+
+// Context key under which the idempotency key is stored.
+type idempotencyKey struct{}
+
+// Creates a child context carrying an idempotency key, which requests issued via
+// RequestContext will attach, letting services with an idempotency store detect
+// and suppress duplicates.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKey{}, key)
+}
+
+// Retrieves the idempotency key carried by a context, or an empty string if none.
+func IdempotencyKey(ctx context.Context) string {
+	key, _ := ctx.Value(idempotencyKey{}).(string)
+	return key
+}
+
+// Outcome of a processed request held by the memory store.
+type idempotencyRecord struct {
+	reply   []byte    // Reply returned by the handler
+	fault   string    // Failure returned by the handler
+	pending bool      // Whether the request is still in flight
+	expiry  time.Time // Time after which the record is forgotten
+}
+
+// Memory backed idempotency store, forgetting the processed requests after a
+// configured retention period.
+type MemoryStore struct {
+	retention time.Duration                 // Time to remember the processed requests for
+	records   map[string]*idempotencyRecord // Outcomes of the processed requests
+	lock      sync.Mutex                    // Protects the processed request records
+}
+
+// Creates a new memory backed idempotency store, remembering each processed
+// request for the given retention period.
+func NewMemoryStore(retention time.Duration) *MemoryStore {
+	return &MemoryStore{
+		retention: retention,
+		records:   make(map[string]*idempotencyRecord),
+	}
+}
+
+// Implements IdempotencyStore.Reserve, claims lapsing after the retention period.
+func (s *MemoryStore) Reserve(key string) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if record, ok := s.records[key]; ok && !time.Now().After(record.expiry) {
+		return false, nil
+	}
+	s.store(key, &idempotencyRecord{pending: true})
+	return true, nil
+}
+
+// Implements IdempotencyStore.Load.
+func (s *MemoryStore) Load(key string) ([]byte, string, bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	record, ok := s.records[key]
+	if !ok || record.pending || time.Now().After(record.expiry) {
+		return nil, "", false, nil
+	}
+	return record.reply, record.fault, true, nil
+}
+
+// Implements IdempotencyStore.Save.
+func (s *MemoryStore) Save(key string, reply []byte, fault string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.store(key, &idempotencyRecord{reply: reply, fault: fault})
+	return nil
+}
+
+// Implements IdempotencyStore.Release.
+func (s *MemoryStore) Release(key string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if record, ok := s.records[key]; ok && record.pending {
+		delete(s.records, key)
+	}
+	return nil
+}
+
+// Inserts a record into the store, sweeping the expired ones along the way. The
+// lock must be held by the caller.
+func (s *MemoryStore) store(key string, record *idempotencyRecord) {
+	now := time.Now()
+	for old, stale := range s.records {
+		if now.After(stale.expiry) {
+			delete(s.records, old)
+		}
+	}
+	record.expiry = now.Add(s.retention)
+	s.records[key] = record
+}
+
+// Assembles the store key of a request, namespaced by the cluster to allow the
+// sharing of a single store between multiple services.
+func (c *Connection) idempotencyKey(meta *Metadata) string {
+	return c.cluster + "/" + meta.IdempotencyKey
+}
+
+// Claims the idempotency key of a request for processing. If an earlier copy of
+// the request holds the key, its outcome is awaited until the deadline, taking
+// the claim over should the earlier copy release it unprocessed. A nil record
+// with no error means the key was claimed, and the request should be handled.
+func (c *Connection) claimRequest(store IdempotencyStore, key string, deadline time.Time) (*idempotencyRecord, error) {
+	for {
+		if claimed, err := store.Reserve(key); err != nil || claimed {
+			return nil, err
+		}
+		if reply, fault, ok, err := store.Load(key); err != nil {
+			return nil, err
+		} else if ok {
+			return &idempotencyRecord{reply: reply, fault: fault}, nil
+		}
+		// Duplicate still in flight, wait a bit unless the caller already gave up
+		if !c.clock.Now().Before(deadline) {
+			return nil, ErrTimeout
+		}
+		<-c.clock.After(idempotencyPoll)
+	}
+}
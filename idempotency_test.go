@@ -0,0 +1,113 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Service handler numbering the handled requests for the idempotency tests.
+type idempotencyTestHandler struct {
+	runs  int32
+	delay time.Duration
+}
+
+func (i *idempotencyTestHandler) Init(conn *Connection) error { return nil }
+func (i *idempotencyTestHandler) HandleBroadcast(msg []byte)  { panic("not implemented") }
+func (i *idempotencyTestHandler) HandleTunnel(tun *Tunnel)    { panic("not implemented") }
+func (i *idempotencyTestHandler) HandleDrop(reason error)     { panic("not implemented") }
+
+func (i *idempotencyTestHandler) HandleRequest(req []byte) ([]byte, error) {
+	time.Sleep(i.delay)
+	return []byte(fmt.Sprintf("run #%d", atomic.AddInt32(&i.runs, 1))), nil
+}
+
+// Tests that duplicate requests get the recorded reply without re-running the
+// handler, while distinct or untagged ones are handled anew.
+func TestIdempotency(t *testing.T) {
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	handler := new(idempotencyTestHandler)
+	serv, err := RegisterWithOptions(config.relay, config.cluster, handler, nil, &Options{
+		Idempotency: NewMemoryStore(time.Minute),
+	})
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	tests := []struct {
+		key   string
+		reply string
+	}{
+		{"alpha", "run #1"},
+		{"alpha", "run #1"},
+		{"beta", "run #2"},
+		{"", "run #3"},
+		{"", "run #4"},
+		{"beta", "run #2"},
+	}
+	for i, tt := range tests {
+		ctx := context.Background()
+		if tt.key != "" {
+			ctx = WithIdempotencyKey(ctx, tt.key)
+		}
+		reply, err := conn.RequestContext(ctx, config.cluster, []byte("request"), time.Second)
+		if err != nil {
+			t.Fatalf("test %d: request failed: %v.", i, err)
+		}
+		if string(reply) != tt.reply {
+			t.Errorf("test %d: reply mismatch: have %q, want %q.", i, reply, tt.reply)
+		}
+	}
+	if runs := atomic.LoadInt32(&handler.runs); runs != 4 {
+		t.Fatalf("handler run count mismatch: have %d, want %d.", runs, 4)
+	}
+}
+
+// Tests that retries arriving while the original request is still in flight wait
+// for its outcome instead of re-running the handler.
+func TestIdempotencyInFlight(t *testing.T) {
+	conn, err := ConnectWithOptions(config.relay, &Options{
+		Retry: &RetryPolicy{Attempts: 3, Backoff: 10 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	handler := &idempotencyTestHandler{delay: 150 * time.Millisecond}
+	serv, err := RegisterWithOptions(config.relay, config.cluster, handler, nil, &Options{
+		Idempotency: NewMemoryStore(time.Minute),
+	})
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	// Time out the first attempt mid-handling, and ensure the retry gets its reply
+	ctx := WithIdempotencyKey(context.Background(), "alpha")
+	reply, err := conn.RequestContext(ctx, config.cluster, []byte("request"), 100*time.Millisecond)
+	if err != nil {
+		t.Fatalf("request failed: %v.", err)
+	}
+	if string(reply) != "run #1" {
+		t.Fatalf("reply mismatch: have %q, want %q.", reply, "run #1")
+	}
+	time.Sleep(2 * handler.delay)
+	if runs := atomic.LoadInt32(&handler.runs); runs != 1 {
+		t.Fatalf("handler run count mismatch: have %d, want %d.", runs, 1)
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the tunnels pinned to a particular service instance.
+//
+// The relay routes tunnels to an arbitrary member of a cluster, with no means
+// of addressing a specific one. Services given an instance identifier thus open
+// an alias link into an instance scoped cluster (the cluster name suffixed by
+// the identifier), having a single member. Pinned tunnels learn the identifier
+// of a member through a probe answered by the remote binding itself, and are
+// opened to - and later redialed into - its instance scoped cluster.
+
+package iris
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Separator between a cluster name and an instance in the instance scoped clusters.
+const instanceSeparator = "#"
+
+// Assembles the name of the instance scoped variant of a cluster.
+func instanceCluster(cluster string, instance string) string {
+	return cluster + instanceSeparator + instance
+}
+
+// Retrieves the stable identifier of the service instance, under which pinned
+// tunnels can reach it, or an empty string if not addressable.
+func (s *Service) Instance() string {
+	return s.conn.options.Instance
+}
+
+// Opens a direct tunnel to a member of the cluster similarly to Tunnel, pinned
+// to that particular instance so that it can be redialed should it break. Only
+// members registered with an instance identifier can be pinned to.
+//
+// The timeout applies separately to discovering the instance and to the tunnel
+// construction.
+func (c *Connection) PinnedTunnel(cluster string, timeout time.Duration) (*Tunnel, error) {
+	// Sanity check on the arguments
+	if len(cluster) == 0 {
+		return nil, errors.New("empty cluster identifier")
+	}
+	if timeout == 0 {
+		timeout = c.options.Defaults.tunnel()
+	}
+	timeoutms := int(timeout.Nanoseconds() / 1000000)
+	if timeoutms < 1 {
+		return nil, fmt.Errorf("invalid timeout %v < 1ms", timeout)
+	}
+	// Probe a member of the cluster for its instance identifier
+	probe, err := c.envelope(cluster, []byte{0x00}, map[string]string{headerProbe: "1", headerInstance: "?"})
+	if err != nil {
+		return nil, err
+	}
+	reply, err := c.request(context.Background(), c.Log, cluster, probe, timeout, timeoutms)
+	if err != nil {
+		return nil, err
+	}
+	// Older bindings echo the probe, so anything but an identifier means not addressable
+	instance := string(reply)
+	if instance == "" || instance == "\x00" {
+		return nil, fmt.Errorf("cluster %q member not addressable", cluster)
+	}
+	return c.TunnelInstance(cluster, instance, timeout)
+}
+
+// Opens a direct tunnel similarly to Tunnel, but to the member of the cluster
+// registered with the given instance identifier. If no such member is live, the
+// construction times out.
+func (c *Connection) TunnelInstance(cluster string, instance string, timeout time.Duration) (*Tunnel, error) {
+	return c.pinTunnel(cluster, instance, c.options.Tunnels, timeout)
+}
+
+// Opens a tunnel into the instance scoped cluster of a service instance.
+func (c *Connection) pinTunnel(cluster string, instance string, options *TunnelOptions, timeout time.Duration) (*Tunnel, error) {
+	// Sanity check on the arguments
+	if len(cluster) == 0 {
+		return nil, errors.New("empty cluster identifier")
+	}
+	if len(instance) == 0 {
+		return nil, errors.New("empty instance identifier")
+	}
+	tun, err := c.initTunnel(context.Background(), instanceCluster(cluster, instance), nil, options, timeout)
+	if err != nil {
+		return nil, err
+	}
+	tun.cluster, tun.instance = cluster, instance
+	return tun, nil
+}
+
+// Retrieves the identifier of the service instance the tunnel is pinned to, or
+// an empty string if the tunnel is not pinned.
+func (t *Tunnel) Instance() string {
+	return t.instance
+}
+
+// Opens a new tunnel to the same service instance the broken (or closed) one
+// was pinned to, with the same settings. Any state tied to the old tunnel (e.g.
+// undelivered messages) is not carried over. Fails if the tunnel is not pinned.
+func (t *Tunnel) Redial(timeout time.Duration) (*Tunnel, error) {
+	if t.instance == "" {
+		return nil, errors.New("tunnel not pinned to an instance")
+	}
+	return t.conn.pinTunnel(t.cluster, t.instance, t.options, timeout)
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"testing"
+	"time"
+)
+
+// Service handler replying to each tunnel message with its instance identifier.
+type instanceTestHandler struct {
+	instance string
+}
+
+func (h *instanceTestHandler) Init(conn *Connection) error              { return nil }
+func (h *instanceTestHandler) HandleBroadcast(msg []byte)               { panic("not implemented") }
+func (h *instanceTestHandler) HandleRequest(req []byte) ([]byte, error) { panic("not implemented") }
+func (h *instanceTestHandler) HandleDrop(reason error)                  { panic("not implemented") }
+
+func (h *instanceTestHandler) HandleTunnel(tun *Tunnel) {
+	defer tun.Close()
+	for {
+		if _, err := tun.Recv(0); err != nil {
+			return
+		}
+		if err := tun.Send([]byte(h.instance), 0); err != nil {
+			return
+		}
+	}
+}
+
+// Tests that pinned tunnels are redialed into the same service instance.
+func TestPinnedTunnel(t *testing.T) {
+	// Register a few addressable members of the cluster
+	for _, instance := range []string{"alpha", "beta", "gamma"} {
+		serv, err := RegisterWithOptions(config.relay, config.cluster, &instanceTestHandler{instance: instance}, nil, &Options{Instance: instance})
+		if err != nil {
+			t.Fatalf("registration failed: %v.", err)
+		}
+		defer serv.Unregister()
+
+		if serv.Instance() != instance {
+			t.Fatalf("instance mismatch: have %q, want %q.", serv.Instance(), instance)
+		}
+	}
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Pin a tunnel to a member and ensure it's served there across redials
+	tun, err := conn.PinnedTunnel(config.cluster, time.Second)
+	if err != nil {
+		t.Fatalf("pinned tunnel failed: %v.", err)
+	}
+	instance := tun.Instance()
+	for i := 0; i < 5; i++ {
+		if err := tun.Send([]byte{0x00}, time.Second); err != nil {
+			t.Fatalf("redial %d: send failed: %v.", i, err)
+		}
+		if reply, err := tun.Recv(time.Second); err != nil || string(reply) != instance {
+			t.Fatalf("redial %d: reply mismatch: have %q/%v, want %q/nil.", i, reply, err, instance)
+		}
+		tun.Close()
+
+		if tun, err = tun.Redial(time.Second); err != nil {
+			t.Fatalf("redial %d: failed: %v.", i, err)
+		}
+		if tun.Instance() != instance {
+			t.Fatalf("redial %d: instance mismatch: have %q, want %q.", i, tun.Instance(), instance)
+		}
+	}
+	tun.Close()
+
+	// Ensure unpinned tunnels can't be redialed
+	plain, err := conn.Tunnel(config.cluster, time.Second)
+	if err != nil {
+		t.Fatalf("tunnel construction failed: %v.", err)
+	}
+	defer plain.Close()
+
+	if _, err := plain.Redial(time.Second); err == nil {
+		t.Fatalf("unpinned tunnel redialed.")
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package pool
+
+import "sync"
+
+// Concurrency limiter shared by multiple task classes. Whenever a slot frees up
+// and several classes are waiting for one, it is granted to them in proportion
+// to their weights (smooth weighted round robin), so a flood of low priority
+// tasks cannot starve the higher priority ones.
+type Gate struct {
+	classes []*gateClass // Waiting tasks and scheduling state of each class
+	slots   int          // Number of tasks allowed to run concurrently
+	busy    int          // Number of slots currently taken
+	lock    sync.Mutex   // Protects the gate internals
+}
+
+// Scheduling state of a single task class.
+type gateClass struct {
+	weight  int             // Relative share of the slots granted to the class
+	credit  int             // Accumulated weighted round robin credit
+	waiters []chan struct{} // Tasks waiting for a slot, oldest first
+}
+
+// Creates a gate running at most slots tasks concurrently, shared by as many
+// task classes as weights are given. Non-positive weights count as one.
+func NewGate(slots int, weights ...int) *Gate {
+	if slots < 1 {
+		slots = 1
+	}
+	classes := make([]*gateClass, len(weights))
+	for i, weight := range weights {
+		if weight < 1 {
+			weight = 1
+		}
+		classes[i] = &gateClass{weight: weight}
+	}
+	return &Gate{
+		classes: classes,
+		slots:   slots,
+	}
+}
+
+// Blocks until a slot is granted to a task of the given class.
+func (g *Gate) Enter(class int) {
+	g.lock.Lock()
+	if g.busy < g.slots {
+		g.busy++
+		g.lock.Unlock()
+		return
+	}
+	wake := make(chan struct{})
+	g.classes[class].waiters = append(g.classes[class].waiters, wake)
+	g.lock.Unlock()
+
+	<-wake
+}
+
+// Releases a slot, handing it over to the next waiting task if any.
+func (g *Gate) Leave() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	// Pick the waiting class with the most credit, charging it for the grant
+	var next *gateClass
+	total := 0
+	for _, class := range g.classes {
+		if len(class.waiters) == 0 {
+			continue
+		}
+		class.credit += class.weight
+		total += class.weight
+		if next == nil || class.credit > next.credit {
+			next = class
+		}
+	}
+	if next == nil {
+		g.busy--
+		return
+	}
+	next.credit -= total
+
+	wake := next.waiters[0]
+	next.waiters[0] = nil
+	next.waiters = next.waiters[1:]
+	close(wake)
+}
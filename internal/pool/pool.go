@@ -0,0 +1,194 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Package pool contains a bounded, work-stealing thread pool running the inbound
+// message handlers of the binding.
+//
+// Instead of a single task queue guarded by one lock, which all producers and
+// workers contend on, every worker slot owns a queue of its own. New tasks are
+// spread across the queues round robin, workers drain their own queue first and
+// steal from the others once it's empty. Workers are spawned on demand, up to
+// the pool's capacity, and exit when no work is left anywhere.
+//
+// A pool with a single worker runs its tasks in scheduling order.
+package pool
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// Returned when scheduling into a pool already terminating.
+var ErrTerminating = errors.New("pool terminating")
+
+// Unit of work executed by the pool.
+type Task func()
+
+// Task queue owned by a single worker slot.
+type taskQueue struct {
+	tasks []Task     // Tasks waiting for execution, oldest first
+	lock  sync.Mutex // Protects the task list
+}
+
+// Appends a task to the end of the queue.
+func (q *taskQueue) push(task Task) {
+	q.lock.Lock()
+	q.tasks = append(q.tasks, task)
+	q.lock.Unlock()
+}
+
+// Removes the oldest task from the queue, or nil if it's empty.
+func (q *taskQueue) pop() Task {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if len(q.tasks) == 0 {
+		return nil
+	}
+	task := q.tasks[0]
+	q.tasks[0] = nil
+	q.tasks = q.tasks[1:]
+	return task
+}
+
+// Drops all the tasks from the queue, returning their number.
+func (q *taskQueue) clear() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	dropped := len(q.tasks)
+	q.tasks = nil
+	return dropped
+}
+
+// Bounded thread pool with per worker task queues and work stealing.
+type ThreadPool struct {
+	queues []*taskQueue // Task queues of the individual worker slots
+	next   uint32       // Index of the queue to push the next task into
+	spawn  uint32       // Index of the home queue of the next spawned worker
+
+	pending int32 // Number of tasks waiting in all the queues
+	running int32 // Number of live workers
+
+	started bool           // Whether the pool was started
+	quit    bool           // Whether the pool is terminating
+	state   sync.RWMutex   // Protects the lifecycle flags against worker spawns
+	done    sync.WaitGroup // Tracks the live workers for termination
+}
+
+// Creates a thread pool running at most threads tasks concurrently. The tasks
+// are only executed after the pool is started.
+func NewThreadPool(threads int) *ThreadPool {
+	if threads < 1 {
+		threads = 1
+	}
+	queues := make([]*taskQueue, threads)
+	for i := range queues {
+		queues[i] = new(taskQueue)
+	}
+	return &ThreadPool{queues: queues}
+}
+
+// Starts executing the scheduled tasks.
+func (t *ThreadPool) Start() {
+	t.state.Lock()
+	defer t.state.Unlock()
+
+	if t.started || t.quit {
+		return
+	}
+	t.started = true
+	for atomic.LoadInt32(&t.pending) > 0 && t.reserve() {
+		t.launch()
+	}
+}
+
+// Schedules a new task into the pool, spawning a worker for it if the pool is
+// running below its capacity.
+func (t *ThreadPool) Schedule(task Task) error {
+	t.state.RLock()
+	defer t.state.RUnlock()
+
+	if t.quit {
+		return ErrTerminating
+	}
+	queue := t.queues[(atomic.AddUint32(&t.next, 1)-1)%uint32(len(t.queues))]
+	queue.push(task)
+	atomic.AddInt32(&t.pending, 1)
+
+	if t.started && t.reserve() {
+		t.launch()
+	}
+	return nil
+}
+
+// Terminates the pool, waiting for the running tasks to finish. If clear is set,
+// the tasks still queued are dropped, otherwise they are executed first.
+func (t *ThreadPool) Terminate(clear bool) {
+	t.state.Lock()
+	t.quit = true
+	if clear {
+		for _, queue := range t.queues {
+			atomic.AddInt32(&t.pending, -int32(queue.clear()))
+		}
+	}
+	t.state.Unlock()
+
+	t.done.Wait()
+}
+
+// Reserves a worker slot if the pool is running below its capacity.
+func (t *ThreadPool) reserve() bool {
+	for {
+		running := atomic.LoadInt32(&t.running)
+		if int(running) >= len(t.queues) {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&t.running, running, running+1) {
+			return true
+		}
+	}
+}
+
+// Spawns a worker into a reserved slot. The state lock must be held.
+func (t *ThreadPool) launch() {
+	home := int((atomic.AddUint32(&t.spawn, 1) - 1) % uint32(len(t.queues)))
+
+	t.done.Add(1)
+	go t.worker(home)
+}
+
+// Executes tasks from the home queue, or stolen from the others, until no work
+// is left in the pool.
+func (t *ThreadPool) worker(home int) {
+	defer t.done.Done()
+
+	for {
+		if task := t.fetch(home); task != nil {
+			task()
+			continue
+		}
+		// No work found, release the slot, but make sure no task was scheduled
+		// in the mean time that found the pool at capacity
+		atomic.AddInt32(&t.running, -1)
+		if atomic.LoadInt32(&t.pending) <= 0 || !t.reserve() {
+			return
+		}
+	}
+}
+
+// Retrieves the next task to run, preferring the home queue and stealing from
+// the other ones if it's empty.
+func (t *ThreadPool) fetch(home int) Task {
+	for i := 0; i < len(t.queues); i++ {
+		if task := t.queues[(home+i)%len(t.queues)].pop(); task != nil {
+			atomic.AddInt32(&t.pending, -1)
+			return task
+		}
+	}
+	return nil
+}
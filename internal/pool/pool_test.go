@@ -0,0 +1,188 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package pool
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Tests that all scheduled tasks run, never exceeding the pool's capacity.
+func TestThreadPool(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		threads int
+		tasks   int
+	}{8, 10000}
+
+	workers := NewThreadPool(conf.threads)
+
+	var running, peak, done int32
+	var pend sync.WaitGroup
+	pend.Add(conf.tasks)
+	for i := 0; i < conf.tasks; i++ {
+		if err := workers.Schedule(func() {
+			defer pend.Done()
+
+			now := atomic.AddInt32(&running, 1)
+			for {
+				old := atomic.LoadInt32(&peak)
+				if now <= old || atomic.CompareAndSwapInt32(&peak, old, now) {
+					break
+				}
+			}
+			atomic.AddInt32(&done, 1)
+			atomic.AddInt32(&running, -1)
+		}); err != nil {
+			t.Fatalf("failed to schedule task #%d: %v.", i, err)
+		}
+		if i == conf.tasks/2 {
+			workers.Start()
+		}
+	}
+	pend.Wait()
+	workers.Terminate(false)
+
+	if int(done) != conf.tasks {
+		t.Fatalf("executed task mismatch: have %d, want %d.", done, conf.tasks)
+	}
+	if int(peak) > conf.threads {
+		t.Fatalf("capacity exceeded: have %d, limit %d.", peak, conf.threads)
+	}
+}
+
+// Tests that a single threaded pool runs its tasks in scheduling order.
+func TestThreadPoolOrder(t *testing.T) {
+	workers := NewThreadPool(1)
+	workers.Start()
+
+	order := make(chan int, 1000)
+	for i := 0; i < 1000; i++ {
+		i := i
+		workers.Schedule(func() { order <- i })
+	}
+	workers.Terminate(false)
+	close(order)
+
+	next := 0
+	for i := range order {
+		if i != next {
+			t.Fatalf("task order mismatch: have %d, want %d.", i, next)
+		}
+		next++
+	}
+	if next != 1000 {
+		t.Fatalf("executed task mismatch: have %d, want %d.", next, 1000)
+	}
+}
+
+// Tests that termination optionally drops the queued tasks and rejects new ones.
+func TestThreadPoolTerminate(t *testing.T) {
+	workers := NewThreadPool(1)
+	workers.Start()
+
+	// Block the only worker and queue up a few more tasks
+	block := make(chan struct{})
+	var done int32
+	workers.Schedule(func() { <-block })
+	for i := 0; i < 10; i++ {
+		workers.Schedule(func() { atomic.AddInt32(&done, 1) })
+	}
+	// Terminate with clearing and verify that only the running task completes
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(block)
+	}()
+	workers.Terminate(true)
+
+	if done != 0 {
+		t.Fatalf("cleared tasks executed: %d.", done)
+	}
+	if err := workers.Schedule(func() {}); err != ErrTerminating {
+		t.Fatalf("schedule after termination mismatch: have %v, want %v.", err, ErrTerminating)
+	}
+}
+
+func BenchmarkThreadPool1Thread(b *testing.B) {
+	benchmarkThreadPool(1, b)
+}
+
+func BenchmarkThreadPool8Threads(b *testing.B) {
+	benchmarkThreadPool(8, b)
+}
+
+func BenchmarkThreadPool64Threads(b *testing.B) {
+	benchmarkThreadPool(64, b)
+}
+
+func BenchmarkThreadPool128Threads(b *testing.B) {
+	benchmarkThreadPool(128, b)
+}
+
+// Measures the scheduling throughput of tiny tasks from concurrent producers.
+func benchmarkThreadPool(threads int, b *testing.B) {
+	workers := NewThreadPool(threads)
+	workers.Start()
+
+	var pend sync.WaitGroup
+	pend.Add(b.N)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			workers.Schedule(pend.Done)
+		}
+	})
+	pend.Wait()
+	b.StopTimer()
+
+	workers.Terminate(false)
+}
+
+// Tests that a gate grants its freed slots to the waiting classes in proportion
+// to their weights.
+func TestGateWeights(t *testing.T) {
+	gate := NewGate(1, 3, 1)
+	gate.Enter(0)
+
+	// Queue up a batch of waiters in both classes
+	order := make(chan int, 16)
+	for class := 0; class < 2; class++ {
+		for i := 0; i < 8; i++ {
+			class := class
+			go func() {
+				gate.Enter(class)
+				order <- class
+				gate.Leave()
+			}()
+		}
+	}
+	for {
+		gate.lock.Lock()
+		waiting := len(gate.classes[0].waiters) + len(gate.classes[1].waiters)
+		gate.lock.Unlock()
+		if waiting == 16 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	gate.Leave()
+
+	// Verify that the first grants were shared three to one
+	counts := make([]int, 2)
+	for i := 0; i < 8; i++ {
+		counts[<-order]++
+	}
+	if counts[0] != 6 || counts[1] != 2 {
+		t.Fatalf("grant share mismatch: have %v, want [6 2].", counts)
+	}
+	for i := 8; i < 16; i++ {
+		<-order
+	}
+}
@@ -0,0 +1,345 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Package irisbench contains a benchmarking harness measuring the latency
+// distribution and throughput of the Iris messaging primitives against a live
+// relay node, meant to aid capacity planning.
+package irisbench
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+
+	"gopkg.in/project-iris/iris-go.v1"
+)
+
+// Configuration of a benchmark run. Any unset fields (i.e. value of zero) will
+// default to the preset ones.
+type Config struct {
+	Relay       int           // Port of the local relay endpoint
+	Cluster     string        // Cluster to register the benchmark service into
+	Topic       string        // Topic to run the publish benchmark on
+	Messages    int           // Number of messages to send in total
+	Size        int           // Size of the message payloads (min 8 bytes)
+	Concurrency int           // Number of concurrent senders
+	Timeout     time.Duration // Time allowance of each individual operation
+}
+
+// Default benchmark configuration values.
+var defaultConfig = Config{
+	Relay:       55555,
+	Cluster:     "iris-bench-cluster",
+	Topic:       "iris-bench-topic",
+	Messages:    10000,
+	Size:        64,
+	Concurrency: 1,
+	Timeout:     10 * time.Second,
+}
+
+// Results of a benchmark run.
+type Report struct {
+	Operation  string        // Name of the benchmarked messaging primitive
+	Messages   int           // Number of messages delivered
+	Elapsed    time.Duration // Total time taken by the run
+	Throughput float64       // Delivered messages per second
+
+	P50  time.Duration // Median latency
+	P99  time.Duration // 99th percentile latency
+	P999 time.Duration // 99.9th percentile latency
+	Max  time.Duration // Worst case latency
+}
+
+// Flattens the report into a single human readable line.
+func (r *Report) String() string {
+	return fmt.Sprintf("%-9s %8d msgs in %-12v %10.1f msg/s | p50 %-10v p99 %-10v p999 %-10v max %v",
+		r.Operation, r.Messages, r.Elapsed, r.Throughput, r.P50, r.P99, r.P999, r.Max)
+}
+
+// Merges the user requested configuration with the defaults.
+func finalizeConfig(user *Config) *Config {
+	config := defaultConfig
+	if user == nil {
+		return &config
+	}
+	if user.Relay != 0 {
+		config.Relay = user.Relay
+	}
+	if user.Cluster != "" {
+		config.Cluster = user.Cluster
+	}
+	if user.Topic != "" {
+		config.Topic = user.Topic
+	}
+	if user.Messages != 0 {
+		config.Messages = user.Messages
+	}
+	if user.Size != 0 {
+		config.Size = user.Size
+	}
+	if user.Concurrency != 0 {
+		config.Concurrency = user.Concurrency
+	}
+	if user.Timeout != 0 {
+		config.Timeout = user.Timeout
+	}
+	if config.Size < 8 {
+		config.Size = 8
+	}
+	return &config
+}
+
+// Assembles the final report out of the collected latencies.
+func newReport(op string, hist *Histogram, elapsed time.Duration) *Report {
+	return &Report{
+		Operation:  op,
+		Messages:   int(hist.Count()),
+		Elapsed:    elapsed,
+		Throughput: float64(hist.Count()) / elapsed.Seconds(),
+		P50:        hist.Percentile(0.5),
+		P99:        hist.Percentile(0.99),
+		P999:       hist.Percentile(0.999),
+		Max:        hist.Max(),
+	}
+}
+
+// Creates a payload of the requested size, stamped with the current time.
+func stamp(size int) []byte {
+	payload := make([]byte, size)
+	binary.BigEndian.PutUint64(payload, uint64(time.Now().UnixNano()))
+	return payload
+}
+
+// Calculates the time elapsed since a payload was stamped.
+func since(payload []byte) time.Duration {
+	return time.Duration(time.Now().UnixNano() - int64(binary.BigEndian.Uint64(payload)))
+}
+
+// Runs a batch of concurrent senders, splitting the messages between them and
+// returning the first failure, if any.
+func parallel(config *Config, send func() error) error {
+	var (
+		wg   sync.WaitGroup
+		lock sync.Mutex
+		fail error
+	)
+	for i := 0; i < config.Concurrency; i++ {
+		count := config.Messages / config.Concurrency
+		if i < config.Messages%config.Concurrency {
+			count++
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < count; j++ {
+				if err := send(); err != nil {
+					lock.Lock()
+					if fail == nil {
+						fail = err
+					}
+					lock.Unlock()
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return fail
+}
+
+// Service handler reflecting all inbound messages back to the benchmark.
+type benchHandler struct {
+	delivers chan []byte
+}
+
+func (b *benchHandler) Init(conn *iris.Connection) error         { return nil }
+func (b *benchHandler) HandleBroadcast(msg []byte)               { b.delivers <- msg }
+func (b *benchHandler) HandleRequest(req []byte) ([]byte, error) { return req, nil }
+func (b *benchHandler) HandleEvent(event []byte)                 { b.delivers <- event }
+func (b *benchHandler) HandleDrop(reason error)                  {}
+
+func (b *benchHandler) HandleTunnel(tun *iris.Tunnel) {
+	defer tun.Close()
+	for {
+		msg, err := tun.Recv(0)
+		if err != nil {
+			return
+		}
+		if err := tun.Send(msg, 0); err != nil {
+			return
+		}
+	}
+}
+
+// Measures the round trip latency and throughput of requests.
+func Request(config *Config) (*Report, error) {
+	config = finalizeConfig(config)
+
+	serv, err := iris.Register(config.Relay, config.Cluster, new(benchHandler), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer serv.Unregister()
+
+	conn, err := iris.Connect(config.Relay)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	hist := NewHistogram()
+	start := time.Now()
+	err = parallel(config, func() error {
+		reply, err := conn.Request(config.Cluster, stamp(config.Size), config.Timeout)
+		if err != nil {
+			return err
+		}
+		hist.Record(since(reply))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newReport("request", hist, time.Since(start)), nil
+}
+
+// Collects the delivered one-way messages until all arrive or a timeout hits.
+func collect(config *Config, delivers chan []byte, hist *Histogram) error {
+	for i := 0; i < config.Messages; i++ {
+		select {
+		case msg := <-delivers:
+			hist.Record(since(msg))
+		case <-time.After(config.Timeout):
+			return fmt.Errorf("%d/%d messages lost", config.Messages-i, config.Messages)
+		}
+	}
+	return nil
+}
+
+// Measures the one way delivery latency and throughput of broadcasts.
+func Broadcast(config *Config) (*Report, error) {
+	config = finalizeConfig(config)
+
+	handler := &benchHandler{delivers: make(chan []byte, config.Messages)}
+	serv, err := iris.Register(config.Relay, config.Cluster, handler, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer serv.Unregister()
+
+	conn, err := iris.Connect(config.Relay)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	hist := NewHistogram()
+	start := time.Now()
+	if err := parallel(config, func() error {
+		return conn.Broadcast(config.Cluster, stamp(config.Size))
+	}); err != nil {
+		return nil, err
+	}
+	if err := collect(config, handler.delivers, hist); err != nil {
+		return nil, err
+	}
+	return newReport("broadcast", hist, time.Since(start)), nil
+}
+
+// Measures the one way delivery latency and throughput of topic events.
+func Publish(config *Config) (*Report, error) {
+	config = finalizeConfig(config)
+
+	conn, err := iris.Connect(config.Relay)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	handler := &benchHandler{delivers: make(chan []byte, config.Messages)}
+	if err := conn.Subscribe(config.Topic, handler, nil); err != nil {
+		return nil, err
+	}
+	defer conn.Unsubscribe(config.Topic)
+
+	// Wait for the subscription to propagate
+	time.Sleep(100 * time.Millisecond)
+
+	hist := NewHistogram()
+	start := time.Now()
+	if err := parallel(config, func() error {
+		return conn.Publish(config.Topic, stamp(config.Size))
+	}); err != nil {
+		return nil, err
+	}
+	if err := collect(config, handler.delivers, hist); err != nil {
+		return nil, err
+	}
+	return newReport("publish", hist, time.Since(start)), nil
+}
+
+// Measures the round trip latency and throughput of tunnel transfers, each of
+// the concurrent senders using its own tunnel.
+func Tunnel(config *Config) (*Report, error) {
+	config = finalizeConfig(config)
+
+	serv, err := iris.Register(config.Relay, config.Cluster, new(benchHandler), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer serv.Unregister()
+
+	conn, err := iris.Connect(config.Relay)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// Open a tunnel for each concurrent sender
+	tunnels := make(chan *iris.Tunnel, config.Concurrency)
+	for i := 0; i < config.Concurrency; i++ {
+		tun, err := conn.Tunnel(config.Cluster, config.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		defer tun.Close()
+		tunnels <- tun
+	}
+	hist := NewHistogram()
+	start := time.Now()
+	err = parallel(config, func() error {
+		tun := <-tunnels
+		defer func() { tunnels <- tun }()
+
+		if err := tun.Send(stamp(config.Size), config.Timeout); err != nil {
+			return err
+		}
+		reply, err := tun.Recv(config.Timeout)
+		if err != nil {
+			return err
+		}
+		hist.Record(since(reply))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newReport("tunnel", hist, time.Since(start)), nil
+}
+
+// Runs all the benchmarks in succession with the same configuration.
+func All(config *Config) ([]*Report, error) {
+	reports := []*Report{}
+	for _, bench := range []func(*Config) (*Report, error){Request, Broadcast, Publish, Tunnel} {
+		report, err := bench(config)
+		if err != nil {
+			return reports, err
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
@@ -0,0 +1,52 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package irisbench
+
+import (
+	"testing"
+	"time"
+)
+
+// Tests that the histogram percentiles stay within the bucket precision.
+func TestHistogramPercentiles(t *testing.T) {
+	hist := NewHistogram()
+	for i := 1; i <= 100000; i++ {
+		hist.Record(time.Duration(i) * time.Microsecond)
+	}
+	tests := []struct {
+		frac float64
+		want time.Duration
+	}{
+		{0.5, 50 * time.Millisecond},
+		{0.99, 99 * time.Millisecond},
+		{0.999, 99900 * time.Microsecond},
+		{1, 100 * time.Millisecond},
+	}
+	for i, tt := range tests {
+		have := hist.Percentile(tt.frac)
+		if diff := float64(have-tt.want) / float64(tt.want); diff < -0.04 || diff > 0.04 {
+			t.Errorf("test %d: percentile %v mismatch: have %v, want %v.", i, tt.frac, have, tt.want)
+		}
+	}
+	if max := hist.Max(); max != 100*time.Millisecond {
+		t.Errorf("maximum mismatch: have %v, want %v.", max, 100*time.Millisecond)
+	}
+}
+
+// Tests that the benchmarks run end to end against the local relay.
+func TestBenchmarks(t *testing.T) {
+	reports, err := All(&Config{Messages: 100, Concurrency: 4})
+	if err != nil {
+		t.Fatalf("benchmarks failed: %v.", err)
+	}
+	for _, report := range reports {
+		if report.Messages != 100 {
+			t.Errorf("%s: message count mismatch: have %d, want %d.", report.Operation, report.Messages, 100)
+		}
+		t.Log(report)
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package irisbench
+
+import (
+	"math/bits"
+	"sync"
+	"time"
+)
+
+// Number of mantissa bits retained by the histogram buckets, resulting in a
+// relative error of at most 1/2^subBits (~3%).
+const subBits = 5
+
+// Latency histogram with logarithmic buckets, recording an unbounded number of
+// samples in constant memory.
+type Histogram struct {
+	buckets [64 << subBits]uint64 // Sample counts of the individual buckets
+	count   uint64                // Total number of recorded samples
+	max     time.Duration         // Largest sample recorded
+	lock    sync.Mutex            // Protects the histogram during concurrent use
+}
+
+// Creates a new, empty latency histogram.
+func NewHistogram() *Histogram {
+	return new(Histogram)
+}
+
+// Calculates the bucket index of a latency sample.
+func bucketOf(d time.Duration) int {
+	if d < 1<<subBits {
+		if d < 0 {
+			return 0
+		}
+		return int(d)
+	}
+	exp := bits.Len64(uint64(d)) - 1 - subBits
+	return (exp+1)<<subBits + int(uint64(d)>>uint(exp)) - 1<<subBits
+}
+
+// Calculates the upper latency bound of a bucket.
+func boundOf(bucket int) time.Duration {
+	if bucket < 1<<subBits {
+		return time.Duration(bucket)
+	}
+	exp := bucket>>subBits - 1
+	mant := bucket&(1<<subBits-1) + 1<<subBits
+	return time.Duration((uint64(mant)+1)<<uint(exp) - 1)
+}
+
+// Records a single latency sample.
+func (h *Histogram) Record(d time.Duration) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.buckets[bucketOf(d)]++
+	h.count++
+	if d > h.max {
+		h.max = d
+	}
+}
+
+// Retrieves the number of recorded samples.
+func (h *Histogram) Count() uint64 {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	return h.count
+}
+
+// Retrieves the largest recorded sample.
+func (h *Histogram) Max() time.Duration {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	return h.max
+}
+
+// Retrieves the latency below which the given fraction (0..1] of the samples
+// fall, or zero if no samples were recorded.
+func (h *Histogram) Percentile(frac float64) time.Duration {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if h.count == 0 {
+		return 0
+	}
+	rank := uint64(frac*float64(h.count) + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+	seen := uint64(0)
+	for i, n := range h.buckets {
+		if seen += n; seen >= rank {
+			if bound := boundOf(i); bound < h.max {
+				return bound
+			}
+			return h.max
+		}
+	}
+	return h.max
+}
@@ -0,0 +1,159 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Package irisproto contains helpers for exchanging protobuf messages over Iris.
+//
+// Every message is packed into a google.protobuf.Any before being sent, so the
+// payloads are self describing: the type URL travels along with the encoded
+// message, and receivers can dispatch on it or unpack into the expected type.
+// Published events additionally declare the protobuf content-type in their
+// envelope, so typed streams (iris.Stream[anypb.Any]) decode them directly.
+package irisproto
+
+import (
+	"fmt"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"gopkg.in/project-iris/iris-go.v1"
+)
+
+// Content-type declared in the envelopes of protobuf events.
+const ContentType = "application/x-protobuf"
+
+// Codec packing protobuf messages into google.protobuf.Any payloads.
+type Codec struct{}
+
+// Implements iris.Codec.ContentType.
+func (Codec) ContentType() string { return ContentType }
+
+// Implements iris.Codec.Marshal, packing a proto.Message into an Any.
+func (Codec) Marshal(value interface{}) ([]byte, error) {
+	msg, ok := value.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("value %T is not a protobuf message", value)
+	}
+	return encode(msg)
+}
+
+// Implements iris.Codec.Unmarshal. An Any destination receives the packed
+// message as is, any other proto.Message is unpacked into.
+func (Codec) Unmarshal(data []byte, value interface{}) error {
+	msg, ok := value.(proto.Message)
+	if !ok {
+		return fmt.Errorf("value %T is not a protobuf message", value)
+	}
+	if packed, ok := msg.(*anypb.Any); ok {
+		return proto.Unmarshal(data, packed)
+	}
+	return decode(data, msg)
+}
+
+// Packs a protobuf message into an Any and serializes it.
+func encode(msg proto.Message) ([]byte, error) {
+	packed, err := anypb.New(msg)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(packed)
+}
+
+// Deserializes an Any and unpacks it into the protobuf message.
+func decode(data []byte, msg proto.Message) error {
+	packed := new(anypb.Any)
+	if err := proto.Unmarshal(data, packed); err != nil {
+		return err
+	}
+	return packed.UnmarshalTo(msg)
+}
+
+// Executes a synchronous request with a protobuf message, unpacking the reply
+// into the given message. The semantics follow iris.Connection.Request.
+func Request(conn *iris.Connection, cluster string, request, reply proto.Message, timeout time.Duration) error {
+	data, err := encode(request)
+	if err != nil {
+		return err
+	}
+	data, err = conn.Request(cluster, data, timeout)
+	if err != nil {
+		return err
+	}
+	return decode(data, reply)
+}
+
+// Callback serving a protobuf request, packed into an Any.
+type RequestHandler func(request *anypb.Any) (proto.Message, error)
+
+// Decodes a raw inbound request, serves it through the handler and encodes the
+// reply. Meant to be called from a service's HandleRequest method.
+func HandleRequest(request []byte, handler RequestHandler) ([]byte, error) {
+	packed := new(anypb.Any)
+	if err := proto.Unmarshal(request, packed); err != nil {
+		return nil, err
+	}
+	reply, err := handler(packed)
+	if err != nil {
+		return nil, err
+	}
+	return encode(reply)
+}
+
+// Publishes a protobuf message to a topic, declaring the protobuf content-type.
+// The semantics follow iris.Connection.Publish.
+func Publish(conn *iris.Connection, topic string, msg proto.Message) error {
+	conn.RegisterCodec(Codec{})
+	return conn.PublishValue(topic, ContentType, msg)
+}
+
+// Callback receiving the protobuf events of a topic, packed into an Any, along
+// with their delivery metadata.
+type EventHandler func(event *anypb.Any, meta *iris.Metadata)
+
+// Topic handler decoding the events into Any messages.
+type eventHandler struct {
+	conn    *iris.Connection
+	handler EventHandler
+}
+
+// Implements iris.TopicHandler.HandleEvent.
+func (h *eventHandler) HandleEvent(event []byte) {
+	h.HandleEventWithMetadata(event, new(iris.Metadata))
+}
+
+// Implements iris.EventMetadataHandler.HandleEventWithMetadata.
+func (h *eventHandler) HandleEventWithMetadata(event []byte, meta *iris.Metadata) {
+	packed := new(anypb.Any)
+	if err := proto.Unmarshal(event, packed); err != nil {
+		h.conn.Log.Error("dropping undecodable protobuf event", "reason", err)
+		return
+	}
+	h.handler(packed, meta)
+}
+
+// Subscribes to a topic of protobuf events. The semantics follow
+// iris.Connection.Subscribe.
+func Subscribe(conn *iris.Connection, topic string, handler EventHandler, limits *iris.TopicLimits) error {
+	return conn.Subscribe(topic, &eventHandler{conn: conn, handler: handler}, limits)
+}
+
+// Assembles the framing of protobuf messages over framed tunnels, packing each
+// into an Any and prefixing it by its length as a varint, matching protobuf's
+// own delimited streams.
+func Delimited() iris.VarintFraming[*anypb.Any] {
+	return iris.VarintFraming[*anypb.Any]{
+		Marshal: func(msg *anypb.Any) ([]byte, error) {
+			return proto.Marshal(msg)
+		},
+		Unmarshal: func(data []byte) (*anypb.Any, error) {
+			msg := new(anypb.Any)
+			if err := proto.Unmarshal(data, msg); err != nil {
+				return nil, err
+			}
+			return msg, nil
+		},
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package irisproto
+
+import (
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"gopkg.in/project-iris/iris-go.v1"
+)
+
+// Port of the local relay to run the protobuf tests against.
+var relay = 55555
+
+// Service handler echoing protobuf string requests in upper case.
+type echoHandler struct{}
+
+func (e *echoHandler) Init(conn *iris.Connection) error { return nil }
+func (e *echoHandler) HandleBroadcast(msg []byte)       { panic("not implemented") }
+func (e *echoHandler) HandleTunnel(tun *iris.Tunnel)    { panic("not implemented") }
+func (e *echoHandler) HandleDrop(reason error)          { panic("not implemented") }
+
+func (e *echoHandler) HandleRequest(req []byte) ([]byte, error) {
+	return HandleRequest(req, func(request *anypb.Any) (proto.Message, error) {
+		msg := new(wrapperspb.StringValue)
+		if err := request.UnmarshalTo(msg); err != nil {
+			return nil, err
+		}
+		return wrapperspb.String(msg.GetValue() + "!"), nil
+	})
+}
+
+// Tests protobuf request/reply round trips.
+func TestRequest(t *testing.T) {
+	serv, err := iris.Register(relay, "irisproto-test", new(echoHandler), nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := iris.Connect(relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	reply := new(wrapperspb.StringValue)
+	if err := Request(conn, "irisproto-test", wrapperspb.String("hello"), reply, time.Second); err != nil {
+		t.Fatalf("request failed: %v.", err)
+	}
+	if reply.GetValue() != "hello!" {
+		t.Fatalf("reply mismatch: have %q, want %q.", reply.GetValue(), "hello!")
+	}
+}
+
+// Tests protobuf publish/subscribe round trips.
+func TestPublish(t *testing.T) {
+	conn, err := iris.Connect(relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	events := make(chan *anypb.Any, 1)
+	metas := make(chan *iris.Metadata, 1)
+	if err := Subscribe(conn, "irisproto-test", func(event *anypb.Any, meta *iris.Metadata) {
+		events <- event
+		metas <- meta
+	}, nil); err != nil {
+		t.Fatalf("subscription failed: %v.", err)
+	}
+	defer conn.Unsubscribe("irisproto-test")
+	time.Sleep(100 * time.Millisecond)
+
+	if err := Publish(conn, "irisproto-test", wrapperspb.String("event")); err != nil {
+		t.Fatalf("publish failed: %v.", err)
+	}
+	select {
+	case event := <-events:
+		msg := new(wrapperspb.StringValue)
+		if err := event.UnmarshalTo(msg); err != nil {
+			t.Fatalf("failed to unpack event: %v.", err)
+		}
+		if msg.GetValue() != "event" {
+			t.Fatalf("event mismatch: have %q, want %q.", msg.GetValue(), "event")
+		}
+		if meta := <-metas; meta.ContentType != ContentType {
+			t.Fatalf("content-type mismatch: have %q, want %q.", meta.ContentType, ContentType)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("event timed out.")
+	}
+}
+
+// Tests that delimited frames round trip, even when split or batched.
+func TestDelimited(t *testing.T) {
+	framing := Delimited()
+
+	var stream []byte
+	for _, value := range []string{"first", "second"} {
+		packed, err := anypb.New(wrapperspb.String(value))
+		if err != nil {
+			t.Fatalf("failed to pack %q: %v.", value, err)
+		}
+		if stream, err = framing.AppendFrame(stream, packed); err != nil {
+			t.Fatalf("failed to frame %q: %v.", value, err)
+		}
+	}
+	// Ensure a partial frame is not decoded
+	if _, n, err := framing.DecodeFrame(stream[:3]); n != 0 || err != nil {
+		t.Fatalf("partial frame decoded: %d bytes, %v.", n, err)
+	}
+	// Decode the batched frames one by one
+	for _, want := range []string{"first", "second"} {
+		packed, n, err := framing.DecodeFrame(stream)
+		if err != nil || n == 0 {
+			t.Fatalf("failed to decode frame: %d bytes, %v.", n, err)
+		}
+		stream = stream[n:]
+
+		msg := new(wrapperspb.StringValue)
+		if err := packed.UnmarshalTo(msg); err != nil {
+			t.Fatalf("failed to unpack frame: %v.", err)
+		}
+		if msg.GetValue() != want {
+			t.Fatalf("frame mismatch: have %q, want %q.", msg.GetValue(), want)
+		}
+	}
+	if len(stream) != 0 {
+		t.Fatalf("unconsumed bytes: %d.", len(stream))
+	}
+}
@@ -0,0 +1,212 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Package iristest contains a fixture launching a real Iris relay node for end
+// to end application tests, either from a Docker image or from a local (or
+// downloaded) Iris binary.
+//
+// The node is started in developer mode on a free port, and the fixture waits
+// until the relay endpoint accepts connections before returning, so the tests
+// can connect to it right away:
+//
+//	port, cleanup, err := iristest.StartRelay(nil)
+//	if err != nil {
+//	  t.Skipf("no relay available: %v", err)
+//	}
+//	defer cleanup()
+//
+// Docker containers are attached to the host network, as the relay endpoint of
+// Iris only listens on the loopback interface.
+package iristest
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/project-iris/iris-go.v1"
+)
+
+// Returned if neither an Iris binary nor Docker is available to run a relay.
+var ErrNoRelay = errors.New("iristest: no iris binary or docker available")
+
+// Configuration of the relay fixture.
+type Config struct {
+	Binary  string        // Path or http(s) URL of an Iris binary (empty = docker, then iris from PATH)
+	Image   string        // Docker image of the Iris node (defaults to karalabe/iris)
+	Timeout time.Duration // Time to wait for the relay to become ready (defaults to 30 seconds)
+}
+
+// Default settings of the relay fixture.
+const (
+	defaultImage   = "karalabe/iris"
+	defaultTimeout = 30 * time.Second
+)
+
+// Interval between two relay readiness probes.
+var probeInterval = 100 * time.Millisecond
+
+// Launches an Iris relay node and waits for it to become ready, returning its
+// relay port and a function to tear it down with.
+func StartRelay(cfg *Config) (int, func(), error) {
+	if cfg == nil {
+		cfg = new(Config)
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	port, err := freePort()
+	if err != nil {
+		return 0, nil, err
+	}
+	// Launch the relay node from the first available source
+	var cleanup func()
+	switch {
+	case cfg.Binary != "":
+		cleanup, err = startBinary(cfg.Binary, port)
+	case hasDocker():
+		image := cfg.Image
+		if image == "" {
+			image = defaultImage
+		}
+		cleanup, err = startDocker(image, port)
+	default:
+		binary, lookErr := exec.LookPath("iris")
+		if lookErr != nil {
+			return 0, nil, ErrNoRelay
+		}
+		cleanup, err = startBinary(binary, port)
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	// Wait until the relay accepts connections
+	if err := waitRelay(port, timeout); err != nil {
+		cleanup()
+		return 0, nil, err
+	}
+	return port, cleanup, nil
+}
+
+// Finds a currently unused port on the loopback interface.
+func freePort() (int, error) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
+
+// Arguments of an Iris node running in developer mode on the given relay port.
+func nodeArgs(port int) []string {
+	return []string{"-dev", "-relay", strconv.Itoa(port)}
+}
+
+// Starts an Iris binary as a child process, downloading it first if needed.
+func startBinary(binary string, port int) (func(), error) {
+	var temp string
+	if strings.HasPrefix(binary, "http://") || strings.HasPrefix(binary, "https://") {
+		path, err := download(binary)
+		if err != nil {
+			return nil, err
+		}
+		binary, temp = path, path
+	}
+	cmd := exec.Command(binary, nodeArgs(port)...)
+	if err := cmd.Start(); err != nil {
+		if temp != "" {
+			os.Remove(temp)
+		}
+		return nil, fmt.Errorf("iristest: failed to start iris binary: %v", err)
+	}
+	return func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+		if temp != "" {
+			os.Remove(temp)
+		}
+	}, nil
+}
+
+// Downloads an Iris binary into an executable temporary file.
+func download(url string) (string, error) {
+	res, err := http.Get(url)
+	if err != nil {
+		return "", fmt.Errorf("iristest: failed to download iris binary: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("iristest: failed to download iris binary: %s", res.Status)
+	}
+	file, err := ioutil.TempFile("", "iris-relay-")
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(file, res.Body); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return "", fmt.Errorf("iristest: failed to download iris binary: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	if err := os.Chmod(file.Name(), 0755); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+// Checks whether a usable Docker daemon is available.
+func hasDocker() bool {
+	if _, err := exec.LookPath("docker"); err != nil {
+		return false
+	}
+	return exec.Command("docker", "info").Run() == nil
+}
+
+// Starts an Iris node in a detached Docker container on the host network.
+func startDocker(image string, port int) (func(), error) {
+	args := append([]string{"run", "-d", "--rm", "--network", "host", image}, nodeArgs(port)...)
+	out, err := exec.Command("docker", args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("iristest: failed to start iris container: %v", err)
+	}
+	id := strings.TrimSpace(string(out))
+	return func() {
+		exec.Command("docker", "rm", "-f", id).Run()
+	}, nil
+}
+
+// Probes the relay endpoint until a client connection succeeds or the timeout
+// expires.
+func waitRelay(port int, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		conn, err := iris.Connect(port)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("iristest: relay not ready within %v: %v", timeout, err)
+		}
+		time.Sleep(probeInterval)
+	}
+}
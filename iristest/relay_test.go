@@ -0,0 +1,69 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iristest
+
+import (
+	"flag"
+	"os"
+	"testing"
+	"time"
+
+	"gopkg.in/project-iris/iris-go.v1"
+	"gopkg.in/project-iris/iris-go.v1/sim"
+)
+
+// Environment variable turning the test binary into a stand-in Iris node.
+const helperEnv = "IRISTEST_HELPER_NODE"
+
+// Runs the tests, or if requested, poses as an Iris binary serving a simulated
+// relay on the requested port until killed.
+func TestMain(m *testing.M) {
+	if os.Getenv(helperEnv) == "" {
+		os.Exit(m.Run())
+	}
+	flags := flag.NewFlagSet("iris", flag.ExitOnError)
+	flags.Bool("dev", false, "")
+	port := flags.Int("relay", 0, "")
+	flags.Parse(os.Args[1:])
+
+	// Delay the startup a bit to exercise the readiness probing
+	time.Sleep(250 * time.Millisecond)
+	if _, err := sim.NewRelay(&sim.Config{Port: *port}); err != nil {
+		os.Exit(1)
+	}
+	select {}
+}
+
+// Tests that a binary relay is started, awaited and torn down.
+func TestStartRelayBinary(t *testing.T) {
+	os.Setenv(helperEnv, "1")
+	defer os.Unsetenv(helperEnv)
+
+	port, cleanup, err := StartRelay(&Config{Binary: os.Args[0], Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("failed to start relay: %v.", err)
+	}
+	conn, err := iris.Connect(port)
+	if err != nil {
+		cleanup()
+		t.Fatalf("connection failed: %v.", err)
+	}
+	conn.Close()
+
+	cleanup()
+	if conn, err := iris.Connect(port); err == nil {
+		conn.Close()
+		t.Fatalf("relay alive after cleanup.")
+	}
+}
+
+// Tests that a relay never becoming ready is reported and torn down.
+func TestStartRelayTimeout(t *testing.T) {
+	if _, _, err := StartRelay(&Config{Binary: "sleep", Timeout: 300 * time.Millisecond}); err == nil {
+		t.Fatalf("unready relay reported as started.")
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the pull based consumption of inbound events and broadcasts. Instead
+// of pushing messages into a handler, arrivals are handed over one by one as the
+// consumer asks for them, blocking the handler threads in the mean time. Excess
+// messages pile up in the regular memory capped queues, so slow consumers get
+// natural backpressure.
+
+package iris
+
+import (
+	"context"
+	"sync"
+)
+
+// Inbound message retrieved through an iterator.
+type Event struct {
+	Data []byte    // Payload of the event or broadcast
+	Meta *Metadata // Delivery metadata of the message
+}
+
+// Pull based consumer of inbound messages. It implements TopicHandler and the
+// broadcast callbacks of ServiceHandler, so it can be subscribed directly, or be
+// embedded into a service handler to consume broadcasts via Next.
+type Iterator struct {
+	queue chan Event    // Hand-over channel between the handlers and the consumer
+	done  chan struct{} // Channel closed when the iterator is closed
+	once  sync.Once     // Guards the closure of the iterator
+}
+
+// Creates a new pull based message consumer.
+func NewIterator() *Iterator {
+	return &Iterator{
+		queue: make(chan Event),
+		done:  make(chan struct{}),
+	}
+}
+
+// Blocks until the next message arrives, the context is cancelled or the
+// iterator is closed.
+func (it *Iterator) Next(ctx context.Context) (Event, error) {
+	select {
+	case event := <-it.queue:
+		return event, nil
+	case <-ctx.Done():
+		return Event{}, ctx.Err()
+	case <-it.done:
+		return Event{}, ErrClosed
+	}
+}
+
+// Closes the iterator, failing any pending and future Next calls and discarding
+// all further arrivals.
+func (it *Iterator) Close() {
+	it.once.Do(func() { close(it.done) })
+}
+
+// Implements TopicHandler.HandleEvent.
+func (it *Iterator) HandleEvent(event []byte) {
+	it.HandleEventWithMetadata(event, &Metadata{})
+}
+
+// Implements EventMetadataHandler.HandleEventWithMetadata.
+func (it *Iterator) HandleEventWithMetadata(event []byte, meta *Metadata) {
+	it.deliver(Event{Data: event, Meta: meta})
+}
+
+// Implements ServiceHandler.HandleBroadcast.
+func (it *Iterator) HandleBroadcast(message []byte) {
+	it.HandleBroadcastWithMetadata(message, &Metadata{})
+}
+
+// Implements BroadcastMetadataHandler.HandleBroadcastWithMetadata.
+func (it *Iterator) HandleBroadcastWithMetadata(message []byte, meta *Metadata) {
+	it.deliver(Event{Data: message, Meta: meta})
+}
+
+// Hands a message over to the consumer, waiting until it's requested.
+func (it *Iterator) deliver(event Event) {
+	select {
+	case it.queue <- event:
+	case <-it.done:
+	}
+}
+
+// Topic subscription consumed through pull based iteration.
+type Subscription struct {
+	conn  *Connection // Connection holding the subscription
+	topic string      // Topic subscribed to
+	iter  *Iterator   // Consumer of the topic events
+}
+
+// Subscribes to a topic, returning a subscription from which the events can be
+// pulled one by one via Next.
+func (c *Connection) SubscribeIterator(topic string, limits *TopicLimits) (*Subscription, error) {
+	iter := NewIterator()
+	if err := c.Subscribe(topic, iter, limits); err != nil {
+		return nil, err
+	}
+	return &Subscription{
+		conn:  c,
+		topic: topic,
+		iter:  iter,
+	}, nil
+}
+
+// Blocks until the next event arrives, the context is cancelled or the
+// subscription is closed.
+func (s *Subscription) Next(ctx context.Context) (Event, error) {
+	return s.iter.Next(ctx)
+}
+
+// Adjusts the threading and memory limits of the subscription.
+func (s *Subscription) SetLimits(limits *TopicLimits) error {
+	return s.conn.SetTopicLimits(s.topic, limits)
+}
+
+// Unsubscribes from the topic, failing any pending and future Next calls.
+func (s *Subscription) Close() error {
+	s.iter.Close()
+	return s.conn.Unsubscribe(s.topic)
+}
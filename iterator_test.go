@@ -0,0 +1,92 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// Service handler consuming broadcasts through an embedded iterator.
+type iteratorTestHandler struct {
+	*Iterator
+}
+
+func (i *iteratorTestHandler) Init(conn *Connection) error              { return nil }
+func (i *iteratorTestHandler) HandleRequest(req []byte) ([]byte, error) { panic("not implemented") }
+func (i *iteratorTestHandler) HandleTunnel(tun *Tunnel)                 { panic("not implemented") }
+func (i *iteratorTestHandler) HandleDrop(reason error)                  { panic("not implemented") }
+
+// Tests that topic events can be pulled from a subscription.
+func TestSubscriptionIterator(t *testing.T) {
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	sub, err := conn.SubscribeIterator(config.topic, nil)
+	if err != nil {
+		t.Fatalf("subscription failed: %v.", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	if err := conn.Publish(config.topic, []byte("event")); err != nil {
+		t.Fatalf("failed to publish event: %v.", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	event, err := sub.Next(ctx)
+	if err != nil {
+		t.Fatalf("failed to pull event: %v.", err)
+	}
+	if string(event.Data) != "event" {
+		t.Fatalf("event mismatch: have %q, want %q.", event.Data, "event")
+	}
+	// Close the subscription and verify further pulls fail
+	if err := sub.Close(); err != nil {
+		t.Fatalf("failed to close subscription: %v.", err)
+	}
+	if _, err := sub.Next(ctx); err != ErrClosed {
+		t.Fatalf("pull after close error mismatch: have %v, want %v.", err, ErrClosed)
+	}
+}
+
+// Tests that broadcasts can be pulled through an iterator embedded into the
+// service handler.
+func TestBroadcastIterator(t *testing.T) {
+	handler := &iteratorTestHandler{NewIterator()}
+	defer handler.Close()
+
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	if err := conn.Broadcast(config.cluster, []byte("broadcast")); err != nil {
+		t.Fatalf("failed to broadcast: %v.", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	event, err := handler.Next(ctx)
+	if err != nil {
+		t.Fatalf("failed to pull broadcast: %v.", err)
+	}
+	if string(event.Data) != "broadcast" {
+		t.Fatalf("broadcast mismatch: have %q, want %q.", event.Data, "broadcast")
+	}
+}
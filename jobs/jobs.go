@@ -0,0 +1,135 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Package jobs contains a background job queue built on top of an Iris service
+// cluster, needing no infrastructure beyond the Iris fabric itself.
+//
+// The workers of a queue register into a common cluster and form a consumer
+// group: Iris load balances every submitted job to exactly one of them, which
+// accepts it into its local buffer and acknowledges it right away, executing
+// it in the background. Jobs arriving at a worker with a full buffer are
+// rejected, and the submission is retried until some member of the group has
+// room for it.
+//
+// Failed jobs are resubmitted to the group after an exponentially growing
+// backoff, so that another worker may pick them up. Jobs failing all their
+// attempts are published to the dead-letter topic of the queue instead.
+//
+// Iris has no persistence, so the jobs buffered or awaiting a retry within a
+// worker are lost should its process die.
+package jobs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"gopkg.in/project-iris/iris-go.v1"
+)
+
+// Prefix of the clusters the workers of a queue register into.
+const clusterPrefix = "jobs."
+
+// Suffix of the dead-letter topic of a queue.
+const deadSuffix = ".dead"
+
+// Fault replied by the workers whose buffer is full.
+var errBusy = errors.New("job buffer full")
+
+// Background job of a queue.
+type Job struct {
+	ID      string // Unique identifier of the job
+	Queue   string // Queue the job was submitted to
+	Payload []byte // Application specific content of the job
+	Attempt int    // Number of the current attempt, starting at one
+}
+
+// Job that failed all its attempts, as delivered on the dead-letter topic.
+type DeadJob struct {
+	Job           // Job that failed
+	Reason string // Failure of the last attempt
+}
+
+// Retrieves the name of the cluster the workers of a queue register into.
+func Cluster(queue string) string {
+	return clusterPrefix + queue
+}
+
+// Retrieves the name of the topic the failed jobs of a queue are published to.
+func DeadLetterTopic(queue string) string {
+	return clusterPrefix + queue + deadSuffix
+}
+
+// Decodes a failed job from an event of a dead-letter topic.
+func ParseDeadLetter(event []byte) (*DeadJob, error) {
+	reason, rest, err := readString(event)
+	if err != nil {
+		return nil, err
+	}
+	job, err := decode(rest)
+	if err != nil {
+		return nil, err
+	}
+	return &DeadJob{Job: *job, Reason: reason}, nil
+}
+
+// Submits a job to a queue, returning its id once a worker accepted it. Busy
+// workers are retried until the timeout elapses.
+func Enqueue(conn *iris.Connection, queue string, payload []byte, timeout time.Duration) (string, error) {
+	if len(queue) == 0 {
+		return "", errors.New("empty queue name")
+	}
+	job := &Job{
+		ID:      newID(),
+		Queue:   queue,
+		Payload: payload,
+		Attempt: 1,
+	}
+	if err := submit(conn, job, timeout); err != nil {
+		return "", err
+	}
+	return job.ID, nil
+}
+
+// Hands a job to a worker of its queue, retrying busy rejections with a short
+// backoff until the timeout elapses.
+func submit(conn *iris.Connection, job *Job, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	backoff := 10 * time.Millisecond
+
+	request := encode(job)
+	for {
+		remaining := time.Until(deadline)
+		if remaining < time.Millisecond {
+			return errBusy
+		}
+		_, err := conn.Request(Cluster(job.Queue), request, remaining)
+		if err == nil || !busy(err) {
+			return err
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			return err
+		}
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > time.Second {
+			backoff = time.Second
+		}
+	}
+}
+
+// Checks whether a submission was rejected by a busy worker.
+func busy(err error) bool {
+	var remote *iris.RemoteError
+	return errors.As(err, &remote) && remote.Unwrap().Error() == errBusy.Error()
+}
+
+// Generates a random job identifier.
+func newID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
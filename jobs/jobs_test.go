@@ -0,0 +1,185 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package jobs
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"gopkg.in/project-iris/iris-go.v1"
+)
+
+// Port of the local relay to run the tests against.
+var relay = 55555
+
+// Topic handler forwarding the dead-lettered jobs into a channel.
+type deadHandler chan *DeadJob
+
+func (d deadHandler) HandleEvent(event []byte) {
+	if job, err := ParseDeadLetter(event); err == nil {
+		d <- job
+	}
+}
+
+// Tests that every enqueued job is executed by exactly one worker of the group.
+func TestJobs(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		workers int
+		jobs    int
+	}{3, 30}
+
+	var lock sync.Mutex
+	done := make(map[string]int)
+	exec := make(chan struct{}, conf.jobs)
+
+	for i := 0; i < conf.workers; i++ {
+		worker, err := NewWorker(relay, "test-jobs", func(job *Job) error {
+			lock.Lock()
+			done[string(job.Payload)]++
+			lock.Unlock()
+
+			exec <- struct{}{}
+			return nil
+		}, nil)
+		if err != nil {
+			t.Fatalf("worker %d start failed: %v.", i, err)
+		}
+		defer worker.Close()
+	}
+	conn, err := iris.Connect(relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	for i := 0; i < conf.jobs; i++ {
+		if _, err := Enqueue(conn, "test-jobs", []byte(fmt.Sprintf("job-%d", i)), time.Second); err != nil {
+			t.Fatalf("enqueue %d failed: %v.", i, err)
+		}
+	}
+	for i := 0; i < conf.jobs; i++ {
+		select {
+		case <-exec:
+		case <-time.After(time.Second):
+			t.Fatalf("job executions mismatch: have %d, want %d.", i, conf.jobs)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	lock.Lock()
+	defer lock.Unlock()
+	for i := 0; i < conf.jobs; i++ {
+		if count := done[fmt.Sprintf("job-%d", i)]; count != 1 {
+			t.Fatalf("job %d execution count mismatch: have %d, want 1.", i, count)
+		}
+	}
+}
+
+// Tests that failed jobs are retried, and dead-lettered after their attempts.
+func TestJobsRetry(t *testing.T) {
+	attempts := make(chan int, 16)
+	worker, err := NewWorker(relay, "test-jobs", func(job *Job) error {
+		attempts <- job.Attempt
+		if string(job.Payload) == "flaky" && job.Attempt == 2 {
+			return nil
+		}
+		return errors.New("job failed")
+	}, &Config{Attempts: 3, Backoff: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("worker start failed: %v.", err)
+	}
+	defer worker.Close()
+
+	conn, err := iris.Connect(relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	dead := make(deadHandler, 1)
+	if err := conn.Subscribe(DeadLetterTopic("test-jobs"), dead, nil); err != nil {
+		t.Fatalf("subscription failed: %v.", err)
+	}
+	defer conn.Unsubscribe(DeadLetterTopic("test-jobs"))
+	time.Sleep(10 * time.Millisecond)
+
+	// Execute a job succeeding on its retry
+	if _, err := Enqueue(conn, "test-jobs", []byte("flaky"), time.Second); err != nil {
+		t.Fatalf("enqueue failed: %v.", err)
+	}
+	for want := 1; want <= 2; want++ {
+		select {
+		case have := <-attempts:
+			if have != want {
+				t.Fatalf("attempt mismatch: have %d, want %d.", have, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("attempt %d not executed.", want)
+		}
+	}
+	// Execute a job failing all its attempts
+	id, err := Enqueue(conn, "test-jobs", []byte("broken"), time.Second)
+	if err != nil {
+		t.Fatalf("enqueue failed: %v.", err)
+	}
+	select {
+	case job := <-dead:
+		if job.ID != id || string(job.Payload) != "broken" || job.Attempt != 3 {
+			t.Fatalf("dead job mismatch: have %s/%s/%d, want %s/%s/%d.", job.ID, job.Payload, job.Attempt, id, "broken", 3)
+		}
+		if job.Reason != "job failed" {
+			t.Fatalf("dead reason mismatch: have %q, want %q.", job.Reason, "job failed")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("failed job not dead-lettered.")
+	}
+	if len(attempts) != 3 {
+		t.Fatalf("attempt count mismatch: have %d, want %d.", len(attempts), 3)
+	}
+}
+
+// Tests that jobs submitted to a full worker are rejected until it has room.
+func TestJobsBusy(t *testing.T) {
+	release := make(chan struct{})
+	worker, err := NewWorker(relay, "test-jobs", func(job *Job) error {
+		<-release
+		return nil
+	}, &Config{Buffer: 1})
+	if err != nil {
+		t.Fatalf("worker start failed: %v.", err)
+	}
+	defer worker.Close()
+
+	conn, err := iris.Connect(relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Fill up the executor and the buffer, and overflow it
+	for i := 0; i < 2; i++ {
+		if _, err := Enqueue(conn, "test-jobs", nil, time.Second); err != nil {
+			t.Fatalf("enqueue %d failed: %v.", i, err)
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	if _, err := Enqueue(conn, "test-jobs", nil, 50*time.Millisecond); !busy(err) {
+		t.Fatalf("overflowing enqueue error mismatch: have %v, want %v.", err, errBusy)
+	}
+	// Free up some space and ensure the submission goes through
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+	}()
+	if _, err := Enqueue(conn, "test-jobs", nil, time.Second); err != nil {
+		t.Fatalf("delayed enqueue failed: %v.", err)
+	}
+}
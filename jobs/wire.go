@@ -0,0 +1,63 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package jobs
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Returned if a job message cannot be decoded.
+var errMalformed = errors.New("malformed job message")
+
+// Serializes a job for the submission requests.
+func encode(job *Job) []byte {
+	buf := make([]byte, 0, 3*binary.MaxVarintLen64+len(job.ID)+len(job.Queue)+len(job.Payload))
+	buf = appendString(buf, job.ID)
+	buf = appendString(buf, job.Queue)
+	buf = binary.AppendUvarint(buf, uint64(job.Attempt))
+	return append(buf, job.Payload...)
+}
+
+// Deserializes a job from a submission request.
+func decode(data []byte) (*Job, error) {
+	job := new(Job)
+
+	var err error
+	if job.ID, data, err = readString(data); err != nil {
+		return nil, err
+	}
+	if job.Queue, data, err = readString(data); err != nil {
+		return nil, err
+	}
+	attempt, n := binary.Uvarint(data)
+	if n <= 0 {
+		return nil, errMalformed
+	}
+	job.Attempt, job.Payload = int(attempt), data[n:]
+	return job, nil
+}
+
+// Serializes a failed job for the dead-letter topic.
+func encodeDead(job *Job, reason string) []byte {
+	return append(appendString(nil, reason), encode(job)...)
+}
+
+// Appends a length prefixed string to a buffer.
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// Reads a length prefixed string from the head of a buffer.
+func readString(data []byte) (string, []byte, error) {
+	size, n := binary.Uvarint(data)
+	if n <= 0 || uint64(len(data)-n) < size {
+		return "", nil, errMalformed
+	}
+	return string(data[n : n+int(size)]), data[n+int(size):], nil
+}
@@ -0,0 +1,257 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package jobs
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"gopkg.in/project-iris/iris-go.v1"
+)
+
+// Callback executing a job, failing it if an error is returned.
+type Handler func(job *Job) error
+
+// Settings of a job worker. Any unset fields (i.e. value of zero) will default
+// to the preset ones.
+type Config struct {
+	Concurrency int // Jobs executed in parallel by the worker
+	Buffer      int // Accepted jobs the worker may hold awaiting execution
+
+	Attempts   int           // Maximum executions of a job, including the first one
+	Backoff    time.Duration // Delay before the first retry, doubled after each
+	MaxBackoff time.Duration // Upper limit of the retry delays
+	Timeout    time.Duration // Timeout of resubmitting a failed job to the group
+}
+
+// Default settings of the job workers.
+var defaultConfig = Config{
+	Concurrency: 1,
+	Buffer:      64,
+	Attempts:    5,
+	Backoff:     100 * time.Millisecond,
+	MaxBackoff:  30 * time.Second,
+	Timeout:     5 * time.Second,
+}
+
+// Member of the consumer group of a queue, executing the jobs handed to it.
+type Worker struct {
+	queue   string           // Queue the worker consumes
+	handler Handler          // Callback executing the jobs
+	config  Config           // Settings of the worker
+	service *iris.Service    // Service accepting the submitted jobs
+	conn    *iris.Connection // Connection of the service, for resubmissions
+
+	jobs    chan *Job            // Accepted jobs awaiting execution
+	retries map[*time.Timer]*Job // Failed jobs awaiting their resubmission
+	closing bool                 // Whether the worker is shutting down
+	lock    sync.Mutex           // Protects the retries and the closing flag
+
+	execs   sync.WaitGroup // Executor threads of the accepted jobs
+	pending sync.WaitGroup // Resubmissions of the failed jobs
+}
+
+// Starts a worker of the given queue, joining its consumer group.
+func NewWorker(port int, queue string, handler Handler, config *Config) (*Worker, error) {
+	// Sanity check on the arguments
+	if len(queue) == 0 {
+		return nil, errors.New("empty queue name")
+	}
+	if handler == nil {
+		return nil, errors.New("nil job handler")
+	}
+	// Merge the user configs with the defaults
+	conf := defaultConfig
+	if config != nil {
+		if config.Concurrency != 0 {
+			conf.Concurrency = config.Concurrency
+		}
+		if config.Buffer != 0 {
+			conf.Buffer = config.Buffer
+		}
+		if config.Attempts != 0 {
+			conf.Attempts = config.Attempts
+		}
+		if config.Backoff != 0 {
+			conf.Backoff = config.Backoff
+		}
+		if config.MaxBackoff != 0 {
+			conf.MaxBackoff = config.MaxBackoff
+		}
+		if config.Timeout != 0 {
+			conf.Timeout = config.Timeout
+		}
+	}
+	w := &Worker{
+		queue:   queue,
+		handler: handler,
+		config:  conf,
+		jobs:    make(chan *Job, conf.Buffer),
+		retries: make(map[*time.Timer]*Job),
+	}
+	for i := 0; i < conf.Concurrency; i++ {
+		w.execs.Add(1)
+		go w.loop()
+	}
+	var err error
+	if w.service, err = iris.Register(port, Cluster(queue), &workerHandler{w}, nil); err != nil {
+		w.lock.Lock()
+		w.closing = true
+		close(w.jobs)
+		w.lock.Unlock()
+
+		w.execs.Wait()
+		return nil, err
+	}
+	return w, nil
+}
+
+// Leaves the consumer group, finishing the already accepted jobs and handing
+// the ones awaiting a retry back to the rest of the group right away.
+func (w *Worker) Close() error {
+	w.lock.Lock()
+	w.closing = true
+	close(w.jobs)
+	w.lock.Unlock()
+
+	// Wait for the accepted jobs, then flush the scheduled retries
+	w.execs.Wait()
+
+	w.lock.Lock()
+	for timer, job := range w.retries {
+		if timer.Stop() {
+			go w.resubmit(job)
+		}
+		delete(w.retries, timer)
+	}
+	w.lock.Unlock()
+
+	w.pending.Wait()
+	return w.service.Unregister()
+}
+
+// Accepts a submitted job into the buffer, or rejects it if full.
+func (w *Worker) accept(job *Job) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.closing {
+		return errBusy
+	}
+	select {
+	case w.jobs <- job:
+		return nil
+	default:
+		return errBusy
+	}
+}
+
+// Executes the accepted jobs until the worker is closed.
+func (w *Worker) loop() {
+	defer w.execs.Done()
+
+	for job := range w.jobs {
+		if err := w.execute(job); err != nil {
+			w.fail(job, err)
+		}
+	}
+}
+
+// Executes a single job, converting a handler panic into a failure.
+func (w *Worker) execute(job *Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job handler panicked: %v", r)
+		}
+	}()
+	return w.handler(job)
+}
+
+// Schedules a failed job for resubmission after its backoff, or dead-letters it
+// if it ran out of attempts.
+func (w *Worker) fail(job *Job, err error) {
+	if job.Attempt >= w.config.Attempts {
+		w.dead(job, err.Error())
+		return
+	}
+	retry := *job
+	retry.Attempt++
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.pending.Add(1)
+	if w.closing {
+		go w.resubmit(&retry)
+		return
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(w.backoff(job.Attempt), func() {
+		w.lock.Lock()
+		_, ok := w.retries[timer]
+		delete(w.retries, timer)
+		w.lock.Unlock()
+
+		if ok {
+			w.resubmit(&retry)
+		}
+	})
+	w.retries[timer] = &retry
+}
+
+// Calculates the delay before retrying a job after its given failed attempt.
+func (w *Worker) backoff(attempt int) time.Duration {
+	delay := w.config.Backoff
+	for i := 1; i < attempt && delay < w.config.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > w.config.MaxBackoff {
+		delay = w.config.MaxBackoff
+	}
+	return delay
+}
+
+// Hands a failed job back to the consumer group, dead-lettering it if no member
+// accepts it.
+func (w *Worker) resubmit(job *Job) {
+	defer w.pending.Done()
+
+	if err := submit(w.conn, job, w.config.Timeout); err != nil {
+		w.dead(job, "resubmission failed: "+err.Error())
+	}
+}
+
+// Publishes a failed job to the dead-letter topic of the queue.
+func (w *Worker) dead(job *Job, reason string) {
+	if err := w.conn.Publish(DeadLetterTopic(w.queue), encodeDead(job, reason)); err != nil {
+		w.conn.Log.Error("failed to dead-letter job", "queue", w.queue, "id", job.ID, "reason", err)
+	}
+}
+
+// Service handler accepting the jobs submitted to the worker.
+type workerHandler struct {
+	worker *Worker
+}
+
+func (h *workerHandler) HandleBroadcast(msg []byte)    {}
+func (h *workerHandler) HandleTunnel(tun *iris.Tunnel) { tun.Close() }
+func (h *workerHandler) HandleDrop(reason error)       {}
+
+func (h *workerHandler) Init(conn *iris.Connection) error {
+	h.worker.conn = conn
+	return nil
+}
+
+func (h *workerHandler) HandleRequest(req []byte) ([]byte, error) {
+	job, err := decode(req)
+	if err != nil {
+		return nil, err
+	}
+	return nil, h.worker.accept(job)
+}
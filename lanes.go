@@ -0,0 +1,83 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the striping of the outbound traffic across multiple relay sockets.
+//
+// At high throughput on many-core machines, the single TCP stream to the relay
+// (and its lock) becomes the bottleneck. A connection can thus open additional
+// client sockets - lanes - to the relay, spreading the outbound broadcasts,
+// requests and publishes across them, while everything bound to the identity
+// of the primary socket (service registration, subscriptions, tunnels, replies
+// and confirmed publishes) stays on it.
+//
+// Broadcasts and publishes are assigned to lanes by hashing their destination,
+// retaining their relative order per cluster and topic, whereas requests are
+// spread round robin, each tracked by the lane its reply will arrive on.
+
+package iris
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sync/atomic"
+)
+
+// Opens the additional relay sockets requested by the options.
+func (c *Connection) openLanes() error {
+	if c.options.Sockets <= 1 {
+		return nil
+	}
+	// Lanes are plain clients with the same settings, sans the striping
+	options := new(Options)
+	*options = *c.options
+	options.Sockets = 0
+
+	for i := 1; i < c.options.Sockets; i++ {
+		lane, err := newConnection(c.port, "", nil, nil, options, nil, c.Log.New("lane", i))
+		if err != nil {
+			c.closeLanes()
+			return fmt.Errorf("failed to open relay lane %d: %v", i, err)
+		}
+		c.lanes = append(c.lanes, lane)
+	}
+	return nil
+}
+
+// Gracefully closes the additional relay sockets.
+func (c *Connection) closeLanes() {
+	for _, lane := range c.lanes {
+		if err := lane.Close(); err != nil {
+			lane.Log.Warn("failed to close relay lane", "reason", err)
+		}
+	}
+}
+
+// Retrieves the relay socket to send the traffic of a destination through.
+func (c *Connection) lane(dest string) *Connection {
+	if len(c.lanes) == 0 {
+		return c
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(dest))
+
+	return c.pick(hash.Sum32())
+}
+
+// Retrieves the next relay socket to send a request through, round robin.
+func (c *Connection) nextLane() *Connection {
+	if len(c.lanes) == 0 {
+		return c
+	}
+	return c.pick(atomic.AddUint32(&c.laneIdx, 1))
+}
+
+// Maps an index onto the primary socket or one of the lanes.
+func (c *Connection) pick(index uint32) *Connection {
+	if index %= uint32(len(c.lanes) + 1); index == 0 {
+		return c
+	}
+	return c.lanes[index-1]
+}
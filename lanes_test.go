@@ -0,0 +1,99 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Tests that traffic striped across multiple relay sockets is delivered, with
+// the order of the publishes retained per topic.
+func TestLanes(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		sockets  int
+		requests int
+		events   int
+	}{4, 100, 100}
+
+	// Register a service and a subscriber, both striping their traffic
+	options := &Options{Sockets: conf.sockets}
+
+	handler := new(requestTestHandler)
+	serv, err := RegisterWithOptions(config.relay, config.cluster, handler, nil, options)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := ConnectWithOptions(config.relay, options)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	if len(conn.lanes) != conf.sockets-1 {
+		t.Fatalf("lane count mismatch: have %d, want %d.", len(conn.lanes), conf.sockets-1)
+	}
+	// Issue a batch of concurrent requests, spread across the lanes
+	var pend sync.WaitGroup
+	errc := make(chan error, conf.requests)
+	for i := 0; i < conf.requests; i++ {
+		pend.Add(1)
+		go func(i int) {
+			defer pend.Done()
+
+			req := []byte(fmt.Sprintf("request #%d", i))
+			if rep, err := conn.Request(config.cluster, req, time.Second); err != nil {
+				errc <- fmt.Errorf("request %d failed: %v", i, err)
+			} else if !bytes.Equal(rep, req) {
+				errc <- fmt.Errorf("request %d reply mismatch: have %s, want %s", i, rep, req)
+			}
+		}(i)
+	}
+	pend.Wait()
+	close(errc)
+	for err := range errc {
+		t.Fatalf("%v.", err)
+	}
+	if stats := conn.Stats(); stats.PendingRequests != 0 {
+		t.Fatalf("pending requests leaked: %d.", stats.PendingRequests)
+	}
+	// Publish a stream of events and verify they arrive in order
+	events := make(chan []byte, conf.events)
+	if err := conn.Subscribe(config.topic, &laneTestTopicHandler{events}, &TopicLimits{EventThreads: 1, Ordered: true}); err != nil {
+		t.Fatalf("subscription failed: %v.", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	for i := 0; i < conf.events; i++ {
+		if err := serv.conn.Publish(config.topic, []byte{byte(i)}); err != nil {
+			t.Fatalf("publish %d failed: %v.", i, err)
+		}
+	}
+	for i := 0; i < conf.events; i++ {
+		select {
+		case event := <-events:
+			if event[0] != byte(i) {
+				t.Fatalf("event order mismatch: have %d, want %d.", event[0], i)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("event %d not delivered.", i)
+		}
+	}
+}
+
+// Topic handler forwarding the arrived events into a channel.
+type laneTestTopicHandler struct {
+	events chan []byte
+}
+
+func (l *laneTestTopicHandler) HandleEvent(event []byte) { l.events <- event }
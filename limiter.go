@@ -0,0 +1,91 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the client side cap on the outstanding requests to each cluster.
+
+package iris
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limit on the simultaneously outstanding requests to any single cluster, so a
+// slow dependency cannot consume all the resources of the caller. Requests over
+// the cap either wait for a free slot (counting towards their timeout) or fail
+// fast with ErrThrottled.
+type ClusterLimit struct {
+	Requests int  // Maximum number of outstanding requests per cluster
+	FailFast bool // Whether to fail instead of queuing requests over the cap
+}
+
+// Per cluster semaphores limiting the outstanding requests.
+type clusterLimiter struct {
+	limit ClusterLimit             // Cap on the requests and the overflow behavior
+	slots map[string]chan struct{} // Semaphore of each cluster
+	lock  sync.Mutex               // Protects the semaphore map
+}
+
+// Creates a new cluster limiter, or nil if the requests are not capped.
+func newClusterLimiter(limit *ClusterLimit) *clusterLimiter {
+	if limit == nil || limit.Requests <= 0 {
+		return nil
+	}
+	return &clusterLimiter{
+		limit: *limit,
+		slots: make(map[string]chan struct{}),
+	}
+}
+
+// Acquires a request slot for the cluster, waiting until the deadline fires or
+// the request is cancelled for one to free up, or failing immediately if so
+// configured.
+func (l *clusterLimiter) acquire(ctx context.Context, cluster string, deadline <-chan time.Time, term chan struct{}) error {
+	if l == nil {
+		return nil
+	}
+	l.lock.Lock()
+	slots, ok := l.slots[cluster]
+	if !ok {
+		slots = make(chan struct{}, l.limit.Requests)
+		l.slots[cluster] = slots
+	}
+	l.lock.Unlock()
+
+	// Try to grab a free slot without blocking
+	select {
+	case slots <- struct{}{}:
+		return nil
+	default:
+		if l.limit.FailFast {
+			return ErrThrottled
+		}
+	}
+	// No free slots, wait until one frees up
+	select {
+	case slots <- struct{}{}:
+		return nil
+	case <-deadline:
+		return ErrTimeout
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-term:
+		return ErrClosed
+	}
+}
+
+// Releases a previously acquired request slot of the cluster.
+func (l *clusterLimiter) release(cluster string) {
+	if l == nil {
+		return
+	}
+	l.lock.Lock()
+	slots := l.slots[cluster]
+	l.lock.Unlock()
+
+	<-slots
+}
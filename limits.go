@@ -16,12 +16,14 @@ type ServiceLimits struct {
 	BroadcastMemory  int // Memory allowance for pending broadcasts
 	RequestThreads   int // Request handlers to execute concurrently
 	RequestMemory    int // Memory allowance for pending requests
+	TunnelBacklog    int // Inbound tunnels queued for AcceptTunnel (0 = HandleTunnel, fixed at registration)
 }
 
 // User limits of the threading and memory usage of a subscription.
 type TopicLimits struct {
-	EventThreads int // Event handlers to execute concurrently
-	EventMemory  int // Memory allowance for pending events
+	EventThreads int  // Event handlers to execute concurrently
+	EventMemory  int  // Memory allowance for pending events
+	Ordered      bool // Run the events of each publisher one by one, in publish order
 }
 
 // Default limits of the threading and memory usage of a registered service.
@@ -38,5 +40,5 @@ var defaultTopicLimits = TopicLimits{
 	EventMemory:  64 * 1024 * 1024,
 }
 
-// Size of a tunnel's input buffer.
+// Size of a tunnel's input buffer (lower bound of the adaptive windows).
 var defaultTunnelBuffer = 64 * 1024 * 1024
@@ -0,0 +1,197 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Package logship contains a log shipping adapter, centralizing the application
+// logs of a fleet over the Iris fabric.
+//
+// A shipper is an io.Writer treating every write as a single log record, so it
+// can back the standard log package, slog (via Handler) or any other logger
+// emitting one record per write. Records are batched and published to a topic
+// in the background as newline delimited events, which a collector subscribed
+// to the topic can split with Records.
+//
+// Logging must never stall the application, so records are queued without
+// blocking: if publishing falls behind (e.g. the relay applies backpressure)
+// and the queue fills up, new records are dropped and counted instead. The
+// next batch published reports the number of records dropped since the last.
+package logship
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/project-iris/iris-go.v1"
+)
+
+// Settings of a log shipper. Any unset fields (i.e. value of zero) will default
+// to the preset ones.
+type Config struct {
+	Buffer   int           // Records queued for publishing before dropping new ones
+	Batch    int           // Maximum number of records published in one event
+	Bytes    int           // Maximum size of an event before publishing it early
+	Interval time.Duration // Maximum time a record waits to be published
+}
+
+// Default settings of the log shippers.
+var defaultConfig = Config{
+	Buffer:   4096,
+	Batch:    256,
+	Bytes:    64 * 1024,
+	Interval: time.Second,
+}
+
+// Log writer publishing the records in batches to a topic.
+type Shipper struct {
+	conn   *iris.Connection // Connection to publish the batches through
+	topic  string           // Topic to publish the batches to
+	config Config           // Settings of the shipper
+
+	records chan []byte   // Records queued for publishing
+	dropped uint64        // Records dropped since the last batch (atomic)
+	total   uint64        // Records dropped since creation (atomic)
+	lock    sync.RWMutex  // Protects the record queue from closure while writing
+	closed  bool          // Whether the shipper was closed
+	done    chan struct{} // Signals the termination of the publisher
+}
+
+// Creates a log shipper publishing the written records to the given topic.
+func New(conn *iris.Connection, topic string, config *Config) (*Shipper, error) {
+	// Sanity check on the arguments
+	if conn == nil {
+		return nil, errors.New("nil connection")
+	}
+	if len(topic) == 0 {
+		return nil, errors.New("empty topic name")
+	}
+	// Merge the user configs with the defaults
+	conf := defaultConfig
+	if config != nil {
+		if config.Buffer != 0 {
+			conf.Buffer = config.Buffer
+		}
+		if config.Batch != 0 {
+			conf.Batch = config.Batch
+		}
+		if config.Bytes != 0 {
+			conf.Bytes = config.Bytes
+		}
+		if config.Interval != 0 {
+			conf.Interval = config.Interval
+		}
+	}
+	s := &Shipper{
+		conn:    conn,
+		topic:   topic,
+		config:  conf,
+		records: make(chan []byte, conf.Buffer),
+		done:    make(chan struct{}),
+	}
+	go s.loop()
+	return s, nil
+}
+
+// Queues a single log record for publishing, dropping it if the queue is full.
+// The write never blocks and never fails, as logging shouldn't either.
+func (s *Shipper) Write(record []byte) (int, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if s.closed {
+		return 0, iris.ErrClosed
+	}
+	select {
+	case s.records <- append([]byte(nil), record...):
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+		atomic.AddUint64(&s.total, 1)
+	}
+	return len(record), nil
+}
+
+// Creates a structured log handler emitting JSON records into the shipper.
+func (s *Shipper) Handler(opts *slog.HandlerOptions) slog.Handler {
+	return slog.NewJSONHandler(s, opts)
+}
+
+// Retrieves the number of records dropped since the shipper was created.
+func (s *Shipper) Dropped() uint64 {
+	return atomic.LoadUint64(&s.total)
+}
+
+// Publishes the queued records and stops the shipper. Records written afterwards
+// are rejected.
+func (s *Shipper) Close() error {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return iris.ErrClosed
+	}
+	s.closed = true
+	close(s.records)
+	s.lock.Unlock()
+
+	<-s.done
+	return nil
+}
+
+// Collects the queued records into batches and publishes them whenever one is
+// full or the oldest record waited long enough.
+func (s *Shipper) loop() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.config.Interval)
+	defer ticker.Stop()
+
+	var (
+		batch bytes.Buffer
+		count int
+	)
+	for {
+		select {
+		case record, ok := <-s.records:
+			if !ok {
+				s.publish(&batch, count)
+				return
+			}
+			batch.Write(record)
+			if len(record) == 0 || record[len(record)-1] != '\n' {
+				batch.WriteByte('\n')
+			}
+			if count++; count < s.config.Batch && batch.Len() < s.config.Bytes {
+				continue
+			}
+		case <-ticker.C:
+		}
+		s.publish(&batch, count)
+		batch.Reset()
+		count = 0
+	}
+}
+
+// Publishes a batch of records, prefixed with the drop report if any records
+// were lost since the last batch.
+func (s *Shipper) publish(batch *bytes.Buffer, count int) {
+	event := append([]byte(nil), batch.Bytes()...)
+	if dropped := atomic.SwapUint64(&s.dropped, 0); dropped > 0 {
+		event = append([]byte(fmt.Sprintf("logship: %d records dropped\n", dropped)), event...)
+	} else if count == 0 {
+		return
+	}
+	if err := s.conn.Publish(s.topic, event); err != nil {
+		s.conn.Log.Warn("failed to ship log records", "topic", s.topic, "records", count, "reason", err)
+	}
+}
+
+// Splits a shipped event into its log records. Records spanning multiple lines
+// are split into the individual lines.
+func Records(event []byte) [][]byte {
+	return bytes.Split(bytes.TrimSuffix(event, []byte("\n")), []byte("\n"))
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package logship
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/project-iris/iris-go.v1"
+)
+
+// Port of the local relay to run the tests against.
+var relay = 55555
+
+// Topic handler forwarding the shipped events into a channel.
+type collector chan []byte
+
+func (c collector) HandleEvent(event []byte) { c <- event }
+
+// Tests that log records are batched and shipped to the topic.
+func TestShipper(t *testing.T) {
+	conn, err := iris.Connect(relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	events := make(collector, 16)
+	if err := conn.Subscribe("test-logs", events, nil); err != nil {
+		t.Fatalf("subscription failed: %v.", err)
+	}
+	defer conn.Unsubscribe("test-logs")
+	time.Sleep(10 * time.Millisecond)
+
+	shipper, err := New(conn, "test-logs", &Config{Batch: 4, Interval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("shipper creation failed: %v.", err)
+	}
+	// Log through both the standard and the structured loggers
+	logger := log.New(shipper, "", 0)
+	for i := 0; i < 5; i++ {
+		logger.Printf("record %d", i)
+	}
+	slog.New(shipper.Handler(nil)).Info("structured", "key", "value")
+
+	if err := shipper.Close(); err != nil {
+		t.Fatalf("shipper close failed: %v.", err)
+	}
+	if _, err := shipper.Write([]byte("late")); err != iris.ErrClosed {
+		t.Fatalf("write after close error mismatch: have %v, want %v.", err, iris.ErrClosed)
+	}
+	// Collect all the shipped records
+	var records []string
+	for len(records) < 6 {
+		select {
+		case event := <-events:
+			for _, record := range Records(event) {
+				records = append(records, string(record))
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("records not shipped: have %v.", records)
+		}
+	}
+	// Events may be delivered out of order, check only for their presence
+	shipped := make(map[string]bool)
+	for _, record := range records {
+		shipped[record] = true
+		if strings.HasPrefix(record, "{") {
+			if !strings.Contains(record, `"msg":"structured"`) || !strings.Contains(record, `"key":"value"`) {
+				t.Fatalf("structured record mismatch: have %q.", record)
+			}
+		}
+	}
+	for i := 0; i < 5; i++ {
+		if want := fmt.Sprintf("record %d", i); !shipped[want] {
+			t.Fatalf("record %q not shipped: have %v.", want, records)
+		}
+	}
+}
+
+// Tests that records overflowing the queue are dropped and reported.
+func TestShipperDrops(t *testing.T) {
+	conn, err := iris.Connect(relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	events := make(collector, 16)
+	if err := conn.Subscribe("test-logs", events, nil); err != nil {
+		t.Fatalf("subscription failed: %v.", err)
+	}
+	defer conn.Unsubscribe("test-logs")
+	time.Sleep(10 * time.Millisecond)
+
+	// Fill up the queue of a shipper whose publisher isn't running yet
+	shipper := &Shipper{
+		conn:    conn,
+		topic:   "test-logs",
+		config:  Config{Buffer: 2, Batch: 16, Bytes: 1024, Interval: 10 * time.Millisecond},
+		records: make(chan []byte, 2),
+		done:    make(chan struct{}),
+	}
+	for i := 0; i < 5; i++ {
+		if n, err := shipper.Write([]byte("overflow")); n != 8 || err != nil {
+			t.Fatalf("write %d mismatch: have %d/%v, want %d/nil.", i, n, err, 8)
+		}
+	}
+	if dropped := shipper.Dropped(); dropped != 3 {
+		t.Fatalf("dropped count mismatch: have %d, want %d.", dropped, 3)
+	}
+	go shipper.loop()
+	shipper.Close()
+
+	select {
+	case event := <-events:
+		records := Records(event)
+		if len(records) != 3 || !bytes.Equal(records[0], []byte("logship: 3 records dropped")) {
+			t.Fatalf("drop report mismatch: have %q.", records)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("records not shipped.")
+	}
+}
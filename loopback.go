@@ -0,0 +1,185 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the in-process loopback delivery of requests and broadcasts.
+//
+// Modular monoliths often run the services and their callers in the same
+// process, paying two relay hops for every call. Services registered with the
+// loopback option join a process wide registry, and connections with the same
+// option deliver the requests and broadcasts meant for those clusters in memory
+// instead, through the same scheduling, limits and handler wrappers as relayed
+// messages, with the replies taking the same path to the caller as well.
+//
+// Requests are served by a local member if the cluster has any, never reaching
+// the remote ones. Broadcasts are delivered to the local members in memory and
+// relayed verbatim to the remote ones as well, so that peers unaware of loopback
+// (older bindings, other languages) receive them unchanged. The local members
+// remember the broadcasts handed to them, and drop the relay's echo matching one
+// byte for byte. Without metadata, identical plain broadcasts of another sender
+// arriving meanwhile are indistinguishable from the echo and may be dropped in
+// its stead, the echo itself being delivered later on. Tracked and gathering
+// broadcasts, tunnels and topics always go through the relay.
+
+package iris
+
+import (
+	"hash/maphash"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Time to wait for the relay's echo of a broadcast delivered in memory before
+// forgetting about it.
+var loopbackEchoTimeout = time.Minute
+
+// Seed of the hashes identifying the broadcasts delivered in memory.
+var loopbackSeed = maphash.MakeSeed()
+
+// Relay echoes expected of identical broadcasts delivered in memory.
+type loopbackEcho struct {
+	pending int       // Number of echoes still expected
+	expiry  time.Time // Time after which the echoes are not waited for any more
+}
+
+// Process wide registry of the loopback enabled service members.
+var loopback = struct {
+	members map[string][]*Connection // Local members of each cluster
+	next    uint32                   // Index to balance the next request with
+	lock    sync.RWMutex             // Protects the member registry
+}{
+	members: make(map[string][]*Connection),
+}
+
+// Registers a service connection as a local member of its cluster, if loopback
+// is enabled on it.
+func (c *Connection) joinLoopback() {
+	if !c.options.Loopback || c.cluster == "" {
+		return
+	}
+	loopback.lock.Lock()
+	defer loopback.lock.Unlock()
+
+	loopback.members[c.cluster] = append(loopback.members[c.cluster], c)
+}
+
+// Removes a service connection from the local members of its cluster.
+func (c *Connection) leaveLoopback() {
+	if !c.options.Loopback || c.cluster == "" {
+		return
+	}
+	loopback.lock.Lock()
+	defer loopback.lock.Unlock()
+
+	members := loopback.members[c.cluster]
+	for i, member := range members {
+		if member == c {
+			members = append(members[:i:i], members[i+1:]...)
+			break
+		}
+	}
+	if len(members) == 0 {
+		delete(loopback.members, c.cluster)
+	} else {
+		loopback.members[c.cluster] = members
+	}
+}
+
+// Retrieves the local members of a cluster to deliver to directly, or nil if
+// loopback is disabled or there are none.
+func (c *Connection) loopbackMembers(cluster string) []*Connection {
+	if !c.options.Loopback {
+		return nil
+	}
+	loopback.lock.RLock()
+	defer loopback.lock.RUnlock()
+
+	return loopback.members[cluster]
+}
+
+// Retrieves a local member of a cluster to serve a request, balanced round
+// robin, or nil if loopback is disabled or there are none.
+func (c *Connection) loopbackMember(cluster string) *Connection {
+	members := c.loopbackMembers(cluster)
+	if len(members) == 0 {
+		return nil
+	}
+	return members[atomic.AddUint32(&loopback.next, 1)%uint32(len(members))]
+}
+
+// Delivers a broadcast to all the local members of a cluster, noting down that
+// they are to drop the relay's echo of it.
+func (c *Connection) broadcastLocal(members []*Connection, message []byte) {
+	for _, member := range members {
+		member.expectEcho(message)
+		member.deliverBroadcast(own(message))
+	}
+}
+
+// Forgets the echoes expected by the local members of a broadcast that could not
+// be relayed after all.
+func (c *Connection) withdrawEchoes(members []*Connection, message []byte) {
+	for _, member := range members {
+		member.consumeEcho(message)
+	}
+}
+
+// Notes down that the relay's echo of a broadcast delivered in memory is to be
+// dropped, forgetting any echoes not arriving in time.
+func (c *Connection) expectEcho(message []byte) {
+	hash, now := maphash.Bytes(loopbackSeed, message), c.clock.Now()
+
+	c.echoLock.Lock()
+	defer c.echoLock.Unlock()
+
+	for key, echo := range c.echoes {
+		if now.After(echo.expiry) {
+			delete(c.echoes, key)
+		}
+	}
+	echo, ok := c.echoes[hash]
+	if !ok {
+		echo = new(loopbackEcho)
+		c.echoes[hash] = echo
+	}
+	echo.pending++
+	echo.expiry = now.Add(loopbackEchoTimeout)
+}
+
+// Checks whether a relayed broadcast is the echo of one already delivered to this
+// member in memory, consuming the expectation if so.
+func (c *Connection) consumeEcho(message []byte) bool {
+	if !c.options.Loopback || c.cluster == "" {
+		return false
+	}
+	hash := maphash.Bytes(loopbackSeed, message)
+
+	c.echoLock.Lock()
+	defer c.echoLock.Unlock()
+
+	echo, ok := c.echoes[hash]
+	if !ok {
+		return false
+	}
+	if echo.pending--; echo.pending == 0 {
+		delete(c.echoes, hash)
+	}
+	return true
+}
+
+// Hands a request registered on link over to a local member for serving, with
+// the reply delivered back to link as if arriving from the relay. The expiry
+// is enforced locally in lieu of the relay.
+func (c *Connection) requestLocal(member *Connection, link *Connection, id uint64, request []byte, timeout time.Duration) {
+	expiry := c.clock.AfterFunc(timeout, func() {
+		link.deliverReply(id, nil, "")
+	})
+	member.serveRequest(id, own(request), timeout, func(id uint64, reply []byte, fault string) error {
+		expiry.Stop()
+		link.deliverReply(id, reply, fault)
+		return nil
+	})
+}
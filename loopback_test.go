@@ -0,0 +1,114 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// Service handler forwarding the arrived requests and broadcasts into a channel.
+type loopTestHandler struct {
+	delivers chan []byte
+}
+
+func (l *loopTestHandler) Init(conn *Connection) error { return nil }
+func (l *loopTestHandler) HandleBroadcast(msg []byte)  { l.delivers <- msg }
+func (l *loopTestHandler) HandleRequest(req []byte) ([]byte, error) {
+	l.delivers <- req
+	return req, nil
+}
+func (l *loopTestHandler) HandleTunnel(tun *Tunnel) { panic("not implemented") }
+func (l *loopTestHandler) HandleDrop(reason error)  { panic("not implemented") }
+
+// Tests that requests to clusters with in-process members are served in memory,
+// bypassing the remote members of the cluster, whereas broadcasts are delivered
+// in memory to the local members and relayed verbatim to the remote ones.
+func TestLoopback(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		requests   int
+		broadcasts int
+	}{100, 100}
+
+	// Register a relay-only member and a loopback member of the same cluster
+	remote := &loopTestHandler{make(chan []byte, conf.requests+conf.broadcasts)}
+	rserv, err := RegisterWithOptions(config.relay, config.cluster, remote, nil, &Options{RawPayloads: true})
+	if err != nil {
+		t.Fatalf("remote registration failed: %v.", err)
+	}
+	defer rserv.Unregister()
+
+	local := &loopTestHandler{make(chan []byte, conf.requests+conf.broadcasts)}
+	lserv, err := RegisterWithOptions(config.relay, config.cluster, local, nil, &Options{Loopback: true})
+	if err != nil {
+		t.Fatalf("local registration failed: %v.", err)
+	}
+	defer lserv.Unregister()
+
+	conn, err := ConnectWithOptions(config.relay, &Options{Loopback: true})
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Issue the requests and broadcasts, the former of which should stay in-process
+	for i := 0; i < conf.requests; i++ {
+		req := []byte(fmt.Sprintf("request #%d", i))
+		if rep, err := conn.Request(config.cluster, req, time.Second); err != nil {
+			t.Fatalf("request %d failed: %v.", i, err)
+		} else if !bytes.Equal(rep, req) {
+			t.Fatalf("request %d reply mismatch: have %s, want %s.", i, rep, req)
+		}
+	}
+	for i := 0; i < conf.broadcasts; i++ {
+		if err := conn.Broadcast(config.cluster, []byte(fmt.Sprintf("broadcast #%d", i))); err != nil {
+			t.Fatalf("broadcast %d failed: %v.", i, err)
+		}
+	}
+	for i := 0; i < conf.requests+conf.broadcasts; i++ {
+		select {
+		case <-local.delivers:
+		case <-time.After(time.Second):
+			t.Fatalf("local delivery %d missing.", i)
+		}
+	}
+	for i := 0; i < conf.broadcasts; i++ {
+		select {
+		case msg := <-remote.delivers:
+			if !bytes.HasPrefix(msg, []byte("broadcast")) {
+				t.Fatalf("remote member reached: %s.", msg)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("remote broadcast %d missing.", i)
+		}
+	}
+	// Ensure the relay's echo of the broadcasts didn't reach the local member
+	select {
+	case msg := <-local.delivers:
+		t.Fatalf("loopback broadcast echoed: %s.", msg)
+	case msg := <-remote.delivers:
+		t.Fatalf("remote member reached: %s.", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if stats := conn.Stats(); stats.PendingRequests != 0 {
+		t.Fatalf("pending requests leaked: %d.", stats.PendingRequests)
+	}
+	// Unregister the local member and ensure the traffic reverts to the relay
+	lserv.Unregister()
+
+	if _, err := conn.Request(config.cluster, []byte("relayed"), time.Second); err != nil {
+		t.Fatalf("relayed request failed: %v.", err)
+	}
+	select {
+	case <-remote.delivers:
+	case <-time.After(time.Second):
+		t.Fatalf("relayed request not delivered remotely.")
+	}
+}
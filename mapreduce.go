@@ -0,0 +1,114 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the map-reduce helper over service clusters.
+//
+// Simple distributed aggregations (e.g. counting the records held by the
+// instances of a sharded service) consist of one request per shard, whose
+// replies are folded into a single result. The map requests are tagged with
+// their shard in the envelope, retrievable by the handler via Metadata.Shard.
+// Note, the requests are still load balanced by the relay, so the handlers are
+// expected to serve any shard, e.g. from shared storage.
+
+package iris
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Context key of the shard tag of a map request.
+type shardKey struct{}
+
+// Fans out a request per shard to the members of a cluster, and folds the
+// replies in shard order into a single result, starting from the zero value.
+// The requests use the connection's default (or adaptive) timeout.
+func MapReduce[T any](conn *Connection, cluster string, shards int, mapReq func(shard int) []byte, reduceFn func(acc T, shard int, reply []byte) (T, error)) (T, error) {
+	return MapReduceContext(context.Background(), conn, cluster, shards, 0, mapReq, reduceFn)
+}
+
+// Fans out a request per shard similarly to MapReduce, but with an explicit
+// request timeout, aborting if the context is cancelled. The first failing map
+// request aborts the others.
+func MapReduceContext[T any](ctx context.Context, conn *Connection, cluster string, shards int, timeout time.Duration, mapReq func(shard int) []byte, reduceFn func(acc T, shard int, reply []byte) (T, error)) (T, error) {
+	var acc T
+	if shards < 1 {
+		return acc, fmt.Errorf("invalid shard count %d < 1", shards)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Issue all the map requests concurrently, aborting on the first failure
+	var (
+		replies = make([][]byte, shards)
+		failure error
+		lock    sync.Mutex
+		pend    sync.WaitGroup
+	)
+	for i := 0; i < shards; i++ {
+		pend.Add(1)
+		go func(shard int) {
+			defer pend.Done()
+
+			reply, err := conn.RequestContext(context.WithValue(ctx, shardKey{}, shardTag(shard, shards)), cluster, mapReq(shard), timeout)
+			if err != nil {
+				lock.Lock()
+				if failure == nil {
+					failure = fmt.Errorf("shard %d: %w", shard, err)
+					cancel()
+				}
+				lock.Unlock()
+				return
+			}
+			replies[shard] = reply
+		}(i)
+	}
+	pend.Wait()
+	if failure != nil {
+		return acc, failure
+	}
+	// Fold the replies in shard order
+	for shard, reply := range replies {
+		var err error
+		if acc, err = reduceFn(acc, shard, reply); err != nil {
+			return acc, fmt.Errorf("shard %d: %w", shard, err)
+		}
+	}
+	return acc, nil
+}
+
+// Formats the envelope tag of a map request.
+func shardTag(shard int, shards int) string {
+	return strconv.Itoa(shard) + "/" + strconv.Itoa(shards)
+}
+
+// Retrieves the shard tag carried by a request context, or an empty string if
+// none.
+func shardTagOf(ctx context.Context) string {
+	tag, _ := ctx.Value(shardKey{}).(string)
+	return tag
+}
+
+// Retrieves the shard a map request is meant for and the total shard count, or
+// false if the request didn't originate from MapReduce.
+func (m *Metadata) Shard() (shard int, shards int, ok bool) {
+	split := strings.Index(m.shard, "/")
+	if split < 0 {
+		return 0, 0, false
+	}
+	shard, err := strconv.Atoi(m.shard[:split])
+	if err != nil {
+		return 0, 0, false
+	}
+	if shards, err = strconv.Atoi(m.shard[split+1:]); err != nil || shard < 0 || shard >= shards {
+		return 0, 0, false
+	}
+	return shard, shards, true
+}
@@ -0,0 +1,65 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Service handler replying with the square of the shard it's asked for.
+type mapTestHandler struct{}
+
+func (m *mapTestHandler) Init(conn *Connection) error              { return nil }
+func (m *mapTestHandler) HandleBroadcast(msg []byte)               { panic("not implemented") }
+func (m *mapTestHandler) HandleRequest(req []byte) ([]byte, error) { panic("not implemented") }
+func (m *mapTestHandler) HandleTunnel(tun *Tunnel)                 { panic("not implemented") }
+func (m *mapTestHandler) HandleDrop(reason error)                  { panic("not implemented") }
+
+func (m *mapTestHandler) HandleRequestWithMetadata(req []byte, meta *Metadata) ([]byte, error) {
+	shard, shards, ok := meta.Shard()
+	if !ok || shards != 8 || strconv.Itoa(shard) != string(req) {
+		return nil, errors.New("shard tag mismatch")
+	}
+	return []byte(strconv.Itoa(shard * shard)), nil
+}
+
+// Tests that shard tagged requests are fanned out and their replies folded.
+func TestMapReduce(t *testing.T) {
+	for i := 0; i < 2; i++ {
+		serv, err := Register(config.relay, config.cluster, new(mapTestHandler), nil)
+		if err != nil {
+			t.Fatalf("registration %d failed: %v.", i, err)
+		}
+		defer serv.Unregister()
+	}
+	conn, err := ConnectWithOptions(config.relay, &Options{Defaults: &DefaultTimeouts{Request: time.Second}})
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	mapReq := func(shard int) []byte { return []byte(strconv.Itoa(shard)) }
+	reduceFn := func(acc int, shard int, reply []byte) (int, error) {
+		square, err := strconv.Atoi(string(reply))
+		return acc + square, err
+	}
+	sum, err := MapReduce(conn, config.cluster, 8, mapReq, reduceFn)
+	if err != nil {
+		t.Fatalf("map-reduce failed: %v.", err)
+	}
+	if sum != 140 {
+		t.Fatalf("result mismatch: have %d, want %d.", sum, 140)
+	}
+	// Ensure a failing shard fails the whole job
+	failing := func(shard int) []byte { return []byte("bogus") }
+	if _, err := MapReduce(conn, config.cluster, 8, failing, reduceFn); err == nil {
+		t.Fatalf("failing map-reduce succeeded.")
+	}
+}
@@ -0,0 +1,353 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the delivery metadata attached to the messages and the envelope that
+// carries it alongside the application payloads.
+//
+// Since the relay protocol has no notion of message headers, the metadata is
+// embedded into the payload itself: a magic prefix, the CRC32 checksum of the
+// header block, the header block itself (a varint count of the headers, each a
+// length prefixed key and value), and finally the raw application payload.
+// Enveloped messages are unwrapped transparently on the receiving side, whereas
+// plain ones are delivered as is. The checksum keeps plain payloads of bindings
+// unaware of envelopes from being mistaken for one just because they happen to
+// start with the magic, and connections receiving only plain payloads may opt
+// out of unwrapping altogether.
+
+package iris
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Delivery metadata of an inbound broadcast, request or event.
+type Metadata struct {
+	Sender   string            // Cluster or application name of the originator (empty if unknown)
+	Caller   string            // Self-reported, unauthenticated id of the originating connection (empty if unknown)
+	Sent     time.Time         // Time the message was sent by the originator (zero if unknown)
+	Received time.Time         // Time the message arrived from the local relay
+	Headers  map[string]string // Any additional headers of the envelope
+
+	CorrelationID  string // Correlation ID of the request (empty for other messages)
+	IdempotencyKey string // Idempotency key of the request (empty if untagged)
+	ContentType    string // Serialization format of a typed payload (empty if undeclared)
+	KeyID          string // Id of the key the payload was encrypted with (empty if plain)
+	Schema         int    // Payload schema version of a versioned request (zero if untagged)
+
+	receipt  string // Destination of the receipt of a tracked broadcast (empty if untracked)
+	question string // Topic to publish the answers of a gathering broadcast to (empty if none)
+	shard    string // Shard tag of a map request (empty if untagged)
+	cancel   string // Token of the request to cancel, or to be cancelled by (empty if none)
+
+	ctx       context.Context // Context of a request, cancelled once the caller gives up (nil for other messages)
+	responder *Responder      // Handle to complete the reply of a request with (nil for other messages)
+}
+
+// Creates a context carrying the correlation ID of the message, which can be
+// passed to RequestContext to propagate it into nested requests.
+//
+// For requests, the context is also cancelled once nobody awaits the reply
+// anymore: the caller cancelled the request or timed out, or the service was
+// unregistered. Long running handlers may watch it to stop early.
+func (m *Metadata) Context() context.Context {
+	ctx := m.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if m.CorrelationID == "" {
+		return ctx
+	}
+	return WithCorrelationID(ctx, m.CorrelationID)
+}
+
+// Retrieves the opaque identifier reported as the caller in the metadata of the
+// messages sent through the connection, stable for its whole lifetime.
+//
+// The relay protocol doesn't reveal the originator of a message, so the id is a
+// random one generated by the binding rather than assigned by the relay, and a
+// new one is drawn by every connection, including reconnects of the same client.
+// It is only sent along if metadata is enabled, and nothing stops a client from
+// reporting any id it likes (a signature only proves the signing key was used).
+// As such, it is an unauthenticated, self-reported client id, fit for tracing and
+// cooperative bookkeeping, but not as a basis for quotas, billing or auditing.
+func (c *Connection) CallerID() string {
+	return c.caller
+}
+
+// Identifies the originator of a message for per caller bookkeeping: the caller
+// id of its connection if known, or its sender name otherwise. Messages with
+// neither (e.g. from connections with metadata disabled) share the empty id.
+func (m *Metadata) origin() string {
+	if m.Caller != "" {
+		return m.Caller
+	}
+	return m.Sender
+}
+
+// Optional extension of the ServiceHandler, receiving inbound broadcasts along
+// with their delivery metadata instead of through HandleBroadcast.
+type BroadcastMetadataHandler interface {
+	HandleBroadcastWithMetadata(message []byte, meta *Metadata)
+}
+
+// Optional extension of the ServiceHandler, receiving inbound requests along
+// with their delivery metadata instead of through HandleRequest.
+type RequestMetadataHandler interface {
+	HandleRequestWithMetadata(request []byte, meta *Metadata) ([]byte, error)
+}
+
+// Optional extension of the TopicHandler, receiving inbound events along with
+// their delivery metadata instead of through HandleEvent.
+type EventMetadataHandler interface {
+	HandleEventWithMetadata(event []byte, meta *Metadata)
+}
+
+// Magic prefix marking a payload wrapped into a metadata envelope.
+var envelopeMagic = []byte{0xff, 'i', 'r', 's', 0x01}
+
+// Checksum table of the envelope header blocks.
+var envelopeTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Reserved envelope headers carrying the well known metadata fields.
+const (
+	headerSender      = "iris.sender"
+	headerCaller      = "iris.caller"
+	headerSent        = "iris.sent"
+	headerCorrelation = "iris.correlation"
+	headerIdempotency = "iris.idempotency"
+	headerContentType = "iris.content-type"
+	headerKey         = "iris.key"
+	headerSignature   = "iris.sig"
+	headerProbe       = "iris.probe"
+	headerReceipt     = "iris.receipt"
+	headerQuestion    = "iris.question"
+	headerShard       = "iris.shard"
+	headerCompress    = "iris.zip"
+	headerSchema      = "iris.schema"
+	headerInstance    = "iris.instance"
+	headerCancel      = "iris.cancel"
+)
+
+// Wraps a payload into an envelope carrying the given headers.
+func wrapEnvelope(headers map[string]string, payload []byte) []byte {
+	// Sort the header keys to keep the encoding deterministic
+	keys := make([]string, 0, len(headers))
+	for key := range headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	// Serialize the magic, the checksum placeholder, the headers and the payload
+	buf := make([]byte, 0, len(envelopeMagic)+4+binary.MaxVarintLen64+len(payload)+64)
+	buf = append(buf, envelopeMagic...)
+	buf = append(buf, 0, 0, 0, 0)
+	buf = appendUvarint(buf, uint64(len(keys)))
+	for _, key := range keys {
+		buf = appendUvarint(buf, uint64(len(key)))
+		buf = append(buf, key...)
+		buf = appendUvarint(buf, uint64(len(headers[key])))
+		buf = append(buf, headers[key]...)
+	}
+	// Checksum the header block and append the payload
+	block := buf[len(envelopeMagic)+4:]
+	binary.BigEndian.PutUint32(buf[len(envelopeMagic):], crc32.Checksum(block, envelopeTable))
+
+	return append(buf, payload...)
+}
+
+// Unwraps an enveloped payload, returning the headers and the raw payload. If
+// the data is not enveloped (or is malformed), it is returned as is with nil
+// headers.
+func unwrapEnvelope(data []byte) (map[string]string, []byte) {
+	if !bytes.HasPrefix(data, envelopeMagic) || len(data) < len(envelopeMagic)+4 {
+		return nil, data
+	}
+	checksum := binary.BigEndian.Uint32(data[len(envelopeMagic):])
+	block := data[len(envelopeMagic)+4:]
+	rest := block
+
+	count, n := binary.Uvarint(rest)
+	if n <= 0 || count > uint64(len(rest)) {
+		return nil, data
+	}
+	rest = rest[n:]
+
+	headers := make(map[string]string, int(count))
+	for i := uint64(0); i < count; i++ {
+		var key, value []byte
+		if key, rest = splitPrefixed(rest); key == nil {
+			return nil, data
+		}
+		if value, rest = splitPrefixed(rest); value == nil {
+			return nil, data
+		}
+		headers[string(key)] = string(value)
+	}
+	// Plain payloads starting with the magic by chance fail the checksum
+	if crc32.Checksum(block[:len(block)-len(rest)], envelopeTable) != checksum {
+		return nil, data
+	}
+	return headers, rest
+}
+
+// Appends a varint encoded integer to a buffer.
+func appendUvarint(buf []byte, value uint64) []byte {
+	var scratch [binary.MaxVarintLen64]byte
+	return append(buf, scratch[:binary.PutUvarint(scratch[:], value)]...)
+}
+
+// Splits a length prefixed blob off the front of the data. A nil result means
+// malformed input.
+func splitPrefixed(data []byte) ([]byte, []byte) {
+	size, n := binary.Uvarint(data)
+	if n <= 0 || size > uint64(len(data)-n) {
+		return nil, data
+	}
+	return data[n : n+int(size)], data[n+int(size):]
+}
+
+// Reports whether all messages sent to a cluster or topic get wrapped into an
+// envelope, so that optional headers can ride along without forcing one.
+func (c *Connection) envelopes(target string) bool {
+	return c.options.Metadata || c.options.Cipher != nil || c.signer(target) != nil
+}
+
+// Wraps an outbound payload destined to a cluster or topic into an envelope if
+// metadata, encryption or signing is enabled, or if any extra headers need to be
+// carried along.
+func (c *Connection) envelope(target string, payload []byte, extra map[string]string) ([]byte, error) {
+	return c.seal(target, payload, extra, CompressAuto)
+}
+
+// Wraps an outbound payload similarly to envelope, but overriding the payload
+// compression policy with the given mode.
+func (c *Connection) seal(target string, payload []byte, extra map[string]string, mode Compression) ([]byte, error) {
+	payload, compressed := c.compress(payload, mode)
+
+	signer := c.signer(target)
+	if !compressed && !c.envelopes(target) && len(extra) == 0 {
+		return payload, nil
+	}
+	headers := make(map[string]string, len(extra)+4)
+	for key, value := range extra {
+		headers[key] = value
+	}
+	if compressed {
+		headers[headerCompress] = compressDeflate
+	}
+	if c.options.Cipher != nil {
+		key, sealed, err := c.options.Cipher.Encrypt(target, payload)
+		if err != nil {
+			return nil, err
+		}
+		headers[headerKey], payload = key, sealed
+	}
+	if c.options.Metadata {
+		headers[headerSent] = strconv.FormatInt(c.clock.Now().UnixNano(), 10)
+		if c.name != "" {
+			headers[headerSender] = c.name
+		}
+		headers[headerCaller] = c.caller
+	}
+	if signer != nil {
+		signature, err := signer(target, signedEnvelope(target, headers, payload))
+		if err != nil {
+			return nil, err
+		}
+		headers[headerSignature] = string(signature)
+	}
+	return wrapEnvelope(headers, payload), nil
+}
+
+// Unwraps an inbound payload arrived through a cluster or topic, verifying,
+// decrypting and validating it if needed, and assembles its delivery metadata.
+func (c *Connection) unwrap(target string, data []byte) ([]byte, *Metadata, error) {
+	headers, payload := map[string]string(nil), data
+	if !c.options.RawPayloads {
+		headers, payload = unwrapEnvelope(data)
+	}
+	meta := parseMetadata(headers, c.clock.Now())
+
+	if verifier := c.verifier(target); verifier != nil {
+		signature, ok := headers[headerSignature]
+		if !ok {
+			return nil, meta, ErrUnsigned
+		}
+		delete(headers, headerSignature)
+		if err := verifier(target, signedEnvelope(target, headers, payload), []byte(signature)); err != nil {
+			return nil, meta, err
+		}
+	}
+	if meta.KeyID != "" {
+		if c.options.Cipher == nil {
+			return nil, meta, ErrNoCipher
+		}
+		plain, err := c.options.Cipher.Decrypt(meta.KeyID, payload)
+		if err != nil {
+			return nil, meta, err
+		}
+		payload = plain
+	}
+	if algorithm, ok := headers[headerCompress]; ok {
+		plain, err := inflate(algorithm, payload)
+		if err != nil {
+			return nil, meta, err
+		}
+		payload = plain
+	}
+	if err := c.checkRecvSize(len(payload)); err != nil {
+		return nil, meta, err
+	}
+	if err := c.validate(target, payload, ValidateReceive); err != nil {
+		return nil, meta, err
+	}
+	return payload, meta, nil
+}
+
+// Assembles the delivery metadata from the headers of an envelope.
+func parseMetadata(headers map[string]string, received time.Time) *Metadata {
+	meta := &Metadata{Received: received}
+	if headers == nil {
+		return meta
+	}
+	meta.Sender = headers[headerSender]
+	meta.Caller = headers[headerCaller]
+	if sent, err := strconv.ParseInt(headers[headerSent], 10, 64); err == nil {
+		meta.Sent = time.Unix(0, sent)
+	}
+	meta.CorrelationID = headers[headerCorrelation]
+	meta.IdempotencyKey = headers[headerIdempotency]
+	meta.ContentType = headers[headerContentType]
+	meta.KeyID = headers[headerKey]
+	meta.receipt = headers[headerReceipt]
+	meta.question = headers[headerQuestion]
+	meta.shard = headers[headerShard]
+	meta.cancel = headers[headerCancel]
+	meta.Schema, _ = strconv.Atoi(headers[headerSchema])
+
+	for key, value := range headers {
+		switch key {
+		case headerSender, headerCaller, headerSent, headerCorrelation, headerIdempotency, headerContentType, headerKey, headerSignature, headerReceipt, headerQuestion, headerShard, headerCancel, headerCompress:
+			continue
+		case headerSchema, headerInstance:
+			// Kept on probes, marking them as version or instance queries
+			if _, probe := headers[headerProbe]; !probe {
+				continue
+			}
+		}
+		if meta.Headers == nil {
+			meta.Headers = make(map[string]string)
+		}
+		meta.Headers[key] = value
+	}
+	return meta
+}
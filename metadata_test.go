@@ -0,0 +1,199 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// Service and topic handler for the metadata tests.
+type metadataTestHandler struct {
+	conn  *Connection
+	metas chan *Metadata
+}
+
+func (m *metadataTestHandler) Init(conn *Connection) error              { m.conn = conn; return nil }
+func (m *metadataTestHandler) HandleBroadcast(msg []byte)               { panic("not implemented") }
+func (m *metadataTestHandler) HandleRequest(req []byte) ([]byte, error) { panic("not implemented") }
+func (m *metadataTestHandler) HandleTunnel(tun *Tunnel)                 { panic("not implemented") }
+func (m *metadataTestHandler) HandleDrop(reason error)                  { panic("not implemented") }
+func (m *metadataTestHandler) HandleEvent(event []byte)                 { panic("not implemented") }
+
+func (m *metadataTestHandler) HandleBroadcastWithMetadata(msg []byte, meta *Metadata) {
+	m.metas <- meta
+}
+
+func (m *metadataTestHandler) HandleRequestWithMetadata(req []byte, meta *Metadata) ([]byte, error) {
+	m.metas <- meta
+	return req, nil
+}
+
+func (m *metadataTestHandler) HandleEventWithMetadata(event []byte, meta *Metadata) {
+	m.metas <- meta
+}
+
+// Tests that envelopes survive a round trip and plain payloads pass through.
+func TestEnvelope(t *testing.T) {
+	headers := map[string]string{"a": "1", "empty": ""}
+	payload := []byte("payload")
+
+	heads, data := unwrapEnvelope(wrapEnvelope(headers, payload))
+	if !bytes.Equal(data, payload) {
+		t.Fatalf("payload mismatch: have %q, want %q.", data, payload)
+	}
+	if len(heads) != len(headers) {
+		t.Fatalf("header count mismatch: have %v, want %v.", len(heads), len(headers))
+	}
+	for key, value := range headers {
+		if heads[key] != value {
+			t.Fatalf("header %s mismatch: have %q, want %q.", key, heads[key], value)
+		}
+	}
+	// Ensure plain and truncated envelopes are delivered as is
+	for _, plain := range [][]byte{payload, envelopeMagic, append(append([]byte{}, envelopeMagic...), 0x05)} {
+		if heads, data := unwrapEnvelope(plain); heads != nil || !bytes.Equal(data, plain) {
+			t.Fatalf("plain payload %x modified: headers %v, data %x.", plain, heads, data)
+		}
+	}
+	// Ensure plain payloads parsing as envelopes but failing the checksum pass through
+	forged := wrapEnvelope(headers, payload)
+	forged[len(envelopeMagic)] ^= 0xff
+	if heads, data := unwrapEnvelope(forged); heads != nil || !bytes.Equal(data, forged) {
+		t.Fatalf("forged envelope %x modified: headers %v, data %x.", forged, heads, data)
+	}
+}
+
+// Tests that the delivery metadata reaches the handlers of all message types.
+func TestMetadata(t *testing.T) {
+	// Create the service handler
+	handler := &metadataTestHandler{
+		metas: make(chan *Metadata, 3),
+	}
+	// Register a new service to the relay and subscribe to a topic
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	if err := handler.conn.Subscribe(config.topic, handler, nil); err != nil {
+		t.Fatalf("subscription failed: %v.", err)
+	}
+	defer handler.conn.Unsubscribe(config.topic)
+	time.Sleep(100 * time.Millisecond)
+
+	// Connect a metadata enabled client and send each message type
+	conn, err := ConnectWithOptions(config.relay, &Options{Metadata: true, Name: "tester"})
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	if err := conn.Broadcast(config.cluster, []byte{0x00}); err != nil {
+		t.Fatalf("broadcast failed: %v.", err)
+	}
+	if reply, err := conn.Request(config.cluster, []byte{0x01}, time.Second); err != nil {
+		t.Fatalf("request failed: %v.", err)
+	} else if !bytes.Equal(reply, []byte{0x01}) {
+		t.Fatalf("reply mismatch: have %x, want %x.", reply, []byte{0x01})
+	}
+	if err := conn.Publish(config.topic, []byte{0x02}); err != nil {
+		t.Fatalf("publish failed: %v.", err)
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case meta := <-handler.metas:
+			if meta.Sender != "tester" {
+				t.Fatalf("metadata #%d: sender mismatch: have %q, want %q.", i, meta.Sender, "tester")
+			}
+			if meta.Caller != conn.CallerID() {
+				t.Fatalf("metadata #%d: caller mismatch: have %q, want %q.", i, meta.Caller, conn.CallerID())
+			}
+			if meta.Sent.Before(start) || meta.Received.Before(meta.Sent) {
+				t.Fatalf("metadata #%d: invalid timestamps: started %v, sent %v, received %v.", i, start, meta.Sent, meta.Received)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("metadata #%d: delivery timed out.", i)
+		}
+	}
+}
+
+// Tests that connections opted out of unwrapping deliver envelopes as is.
+func TestRawPayloads(t *testing.T) {
+	handler := &metadataTestHandler{
+		metas: make(chan *Metadata, 1),
+	}
+	serv, err := RegisterWithOptions(config.relay, config.cluster, handler, nil, &Options{RawPayloads: true})
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := ConnectWithOptions(config.relay, &Options{Metadata: true, Name: "tester", RawPayloads: true})
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Send an enveloped request and ensure it's echoed back verbatim
+	reply, err := conn.Request(config.cluster, []byte{0x01}, time.Second)
+	if err != nil {
+		t.Fatalf("request failed: %v.", err)
+	}
+	if !bytes.HasPrefix(reply, envelopeMagic) || !bytes.HasSuffix(reply, []byte{0x01}) {
+		t.Fatalf("envelope unwrapped: have %x.", reply)
+	}
+	if meta := <-handler.metas; meta.Sender != "" || meta.Caller != "" {
+		t.Fatalf("metadata parsed: sender %q, caller %q.", meta.Sender, meta.Caller)
+	}
+}
+
+// Tests that the caller ids tell apart the connections, even if sharing a name,
+// and identify the originators of messages, falling back to the sender.
+func TestCallerIdentity(t *testing.T) {
+	handler := &metadataTestHandler{
+		metas: make(chan *Metadata, 6),
+	}
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	// Send a few requests from two same named connections and an anonymous one
+	conns := make([]*Connection, 3)
+	for i, opts := range []*Options{{Metadata: true, Name: "tester"}, {Metadata: true, Name: "tester"}, nil} {
+		if conns[i], err = ConnectWithOptions(config.relay, opts); err != nil {
+			t.Fatalf("connection %d failed: %v.", i, err)
+		}
+		defer conns[i].Close()
+	}
+	if conns[0].CallerID() == "" || conns[0].CallerID() == conns[1].CallerID() {
+		t.Fatalf("caller ids not distinct: %q and %q.", conns[0].CallerID(), conns[1].CallerID())
+	}
+	for i, conn := range conns {
+		for j := 0; j < 2; j++ {
+			if _, err := conn.Request(config.cluster, []byte{0x00}, time.Second); err != nil {
+				t.Fatalf("connection %d: request %d failed: %v.", i, j, err)
+			}
+			want := conn.CallerID()
+			if !conn.options.Metadata {
+				want = ""
+			}
+			if meta := <-handler.metas; meta.Caller != want || meta.origin() != want {
+				t.Fatalf("connection %d: request %d: caller mismatch: have %q/%q, want %q.", i, j, meta.Caller, meta.origin(), want)
+			}
+		}
+	}
+	// Ensure originators without a caller id fall back to the sender
+	if origin := (&Metadata{Sender: "legacy"}).origin(); origin != "legacy" {
+		t.Fatalf("origin fallback mismatch: have %q, want %q.", origin, "legacy")
+	}
+}
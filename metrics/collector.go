@@ -0,0 +1,175 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"gopkg.in/project-iris/iris-go.v1"
+)
+
+// Metrics last reported by a single source.
+type source struct {
+	counters map[string]uint64  // Latest counter totals of the source
+	base     map[string]uint64  // Totals accumulated before counter resets (restarts)
+	gauges   map[string]float64 // Latest gauge values of the source
+	epoch    int64              // Creation time of the source's current emitter
+	seq      uint64             // Sequence number of the latest snapshot
+	seen     time.Time          // Time of the latest snapshot
+}
+
+// Aggregator of the metrics published by the emitters of a fleet.
+type Collector struct {
+	conn   *iris.Connection // Connection the metrics topic is subscribed through
+	topic  string           // Metrics topic to collect the snapshots from
+	expiry time.Duration    // Silence after which a source is forgotten
+
+	sources map[string]*source // Latest metrics of the live sources
+	retired map[string]uint64  // Counter totals of the forgotten sources
+	lock    sync.Mutex         // Protects the collected metrics
+}
+
+// Creates a metrics collector aggregating the snapshots published to the topic,
+// forgetting the sources silent for longer than the expiry (zero = never). The
+// counter totals of the forgotten sources are retained.
+func NewCollector(conn *iris.Connection, topic string, expiry time.Duration) (*Collector, error) {
+	c := &Collector{
+		conn:    conn,
+		topic:   topic,
+		expiry:  expiry,
+		sources: make(map[string]*source),
+		retired: make(map[string]uint64),
+	}
+	if err := conn.Subscribe(topic, c, nil); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Stops collecting the metrics.
+func (c *Collector) Close() error {
+	return c.conn.Unsubscribe(c.topic)
+}
+
+// Implements iris.TopicHandler.HandleEvent, merging a metrics snapshot.
+func (c *Collector) HandleEvent(event []byte) {
+	snap := new(snapshot)
+	if err := json.Unmarshal(event, snap); err != nil || len(snap.Source) == 0 {
+		c.conn.Log.Warn("dropping malformed metrics snapshot", "topic", c.topic, "reason", err)
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	src, ok := c.sources[snap.Source]
+	if !ok {
+		src = &source{base: make(map[string]uint64)}
+		c.sources[snap.Source] = src
+	}
+	// Discard stale snapshots, and keep the totals of a restarted source's past
+	switch {
+	case snap.Epoch < src.epoch, snap.Epoch == src.epoch && snap.Seq <= src.seq:
+		return
+	case snap.Epoch > src.epoch:
+		for name, value := range src.counters {
+			src.base[name] += value
+		}
+	}
+	src.counters, src.gauges = snap.Counters, snap.Gauges
+	src.epoch, src.seq, src.seen = snap.Epoch, snap.Seq, time.Now()
+}
+
+// Forgets the sources silent for too long, retaining their counter totals. The
+// lock is assumed held.
+func (c *Collector) expire() {
+	if c.expiry <= 0 {
+		return
+	}
+	for name, src := range c.sources {
+		if time.Since(src.seen) > c.expiry {
+			for counter, value := range src.counters {
+				c.retired[counter] += src.base[counter] + value
+			}
+			delete(c.sources, name)
+		}
+	}
+}
+
+// Retrieves the fleet wide totals of the counters.
+func (c *Collector) Counters() map[string]uint64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.expire()
+
+	totals := make(map[string]uint64, len(c.retired))
+	for name, value := range c.retired {
+		totals[name] = value
+	}
+	for _, src := range c.sources {
+		for name, value := range src.counters {
+			totals[name] += src.base[name] + value
+		}
+	}
+	return totals
+}
+
+// Retrieves the latest values of the gauges, keyed by gauge and source name.
+func (c *Collector) Gauges() map[string]map[string]float64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.expire()
+
+	gauges := make(map[string]map[string]float64)
+	for name, src := range c.sources {
+		for gauge, value := range src.gauges {
+			if _, ok := gauges[gauge]; !ok {
+				gauges[gauge] = make(map[string]float64)
+			}
+			gauges[gauge][name] = value
+		}
+	}
+	return gauges
+}
+
+// Implements http.Handler, exposing the aggregated metrics in a plain text
+// format of one "name value" or "name{source="..."} value" line each.
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	counters := c.Counters()
+	names := make([]string, 0, len(counters))
+	for name := range counters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "%s %d\n", name, counters[name])
+	}
+	gauges := c.Gauges()
+	names = names[:0]
+	for name := range gauges {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		sources := make([]string, 0, len(gauges[name]))
+		for source := range gauges[name] {
+			sources = append(sources, source)
+		}
+		sort.Strings(sources)
+		for _, source := range sources {
+			fmt.Fprintf(w, "%s{source=%q} %g\n", name, source, gauges[name][source])
+		}
+	}
+}
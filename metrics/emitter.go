@@ -0,0 +1,196 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Package metrics contains a lightweight metrics emitter and collector pair, for
+// setups monitoring a fleet without a dedicated monitoring stack.
+//
+// Emitters keep a set of counters and gauges, and publish a snapshot of them to
+// a metrics topic at a fixed interval. Counters are published as cumulative
+// totals, so lost snapshots lose no counts, merely delay them. Snapshots carry
+// the emitter's creation time and a sequence number, so collectors can discard
+// the ones arriving out of order and detect restarted sources. Collectors track
+// the latest snapshot of every source, aggregating the counters fleet wide and
+// the gauges per source, forgetting the sources that went silent.
+package metrics
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/project-iris/iris-go.v1"
+)
+
+// Monotonically increasing metric, e.g. the number of requests served.
+type Counter struct {
+	value uint64 // Current total of the counter (atomic)
+}
+
+// Increments the counter by one.
+func (c *Counter) Inc() {
+	atomic.AddUint64(&c.value, 1)
+}
+
+// Increments the counter by the given amount.
+func (c *Counter) Add(delta uint64) {
+	atomic.AddUint64(&c.value, delta)
+}
+
+// Retrieves the current total of the counter.
+func (c *Counter) Value() uint64 {
+	return atomic.LoadUint64(&c.value)
+}
+
+// Arbitrarily changing metric, e.g. the length of a queue.
+type Gauge struct {
+	bits uint64 // Current value of the gauge as float64 bits (atomic)
+}
+
+// Sets the gauge to the given value.
+func (g *Gauge) Set(value float64) {
+	atomic.StoreUint64(&g.bits, math.Float64bits(value))
+}
+
+// Retrieves the current value of the gauge.
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&g.bits))
+}
+
+// Snapshot of the metrics of a single source, as published to the topic.
+type snapshot struct {
+	Source   string             `json:"source"`
+	Epoch    int64              `json:"epoch"`
+	Seq      uint64             `json:"seq"`
+	Counters map[string]uint64  `json:"counters,omitempty"`
+	Gauges   map[string]float64 `json:"gauges,omitempty"`
+}
+
+// Periodic publisher of the metrics of a single source.
+type Emitter struct {
+	conn   *iris.Connection // Connection to publish the snapshots through
+	topic  string           // Metrics topic to publish the snapshots to
+	source string           // Name of the source, unique across the fleet
+	epoch  int64            // Creation time of the emitter, to detect restarts
+	seq    uint64           // Sequence number of the last snapshot (atomic)
+
+	counters map[string]*Counter // Counters of the source
+	gauges   map[string]*Gauge   // Gauges of the source
+	lock     sync.Mutex          // Protects the metric registry
+
+	quit chan struct{} // Quit channel to stop the publisher
+	done chan struct{} // Signals the termination of the publisher
+}
+
+// Creates a metrics emitter publishing the snapshots of the source's metrics to
+// the given topic at every interval.
+func NewEmitter(conn *iris.Connection, topic string, source string, interval time.Duration) (*Emitter, error) {
+	// Sanity check on the arguments
+	if conn == nil {
+		return nil, errors.New("nil connection")
+	}
+	if len(topic) == 0 {
+		return nil, errors.New("empty topic name")
+	}
+	if len(source) == 0 {
+		return nil, errors.New("empty source name")
+	}
+	if interval <= 0 {
+		return nil, errors.New("non-positive publish interval")
+	}
+	e := &Emitter{
+		conn:     conn,
+		topic:    topic,
+		source:   source,
+		epoch:    time.Now().UnixNano(),
+		counters: make(map[string]*Counter),
+		gauges:   make(map[string]*Gauge),
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go e.loop(interval)
+	return e, nil
+}
+
+// Retrieves the named counter, creating it if needed.
+func (e *Emitter) Counter(name string) *Counter {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	counter, ok := e.counters[name]
+	if !ok {
+		counter = new(Counter)
+		e.counters[name] = counter
+	}
+	return counter
+}
+
+// Retrieves the named gauge, creating it if needed.
+func (e *Emitter) Gauge(name string) *Gauge {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	gauge, ok := e.gauges[name]
+	if !ok {
+		gauge = new(Gauge)
+		e.gauges[name] = gauge
+	}
+	return gauge
+}
+
+// Publishes a final snapshot and stops the emitter.
+func (e *Emitter) Close() error {
+	close(e.quit)
+	<-e.done
+
+	return e.publish()
+}
+
+// Publishes the snapshots at every interval until the emitter is closed.
+func (e *Emitter) loop(interval time.Duration) {
+	defer close(e.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-e.quit:
+			return
+		case <-ticker.C:
+			if err := e.publish(); err != nil {
+				e.conn.Log.Warn("failed to publish metrics", "topic", e.topic, "reason", err)
+			}
+		}
+	}
+}
+
+// Publishes the current snapshot of the metrics.
+func (e *Emitter) publish() error {
+	snap := &snapshot{
+		Source:   e.source,
+		Epoch:    e.epoch,
+		Seq:      atomic.AddUint64(&e.seq, 1),
+		Counters: make(map[string]uint64),
+		Gauges:   make(map[string]float64),
+	}
+	e.lock.Lock()
+	for name, counter := range e.counters {
+		snap.Counters[name] = counter.Value()
+	}
+	for name, gauge := range e.gauges {
+		snap.Gauges[name] = gauge.Value()
+	}
+	e.lock.Unlock()
+
+	blob, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	return e.conn.Publish(e.topic, blob)
+}
@@ -0,0 +1,109 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/project-iris/iris-go.v1"
+)
+
+// Port of the local relay to run the tests against.
+var relay = 55555
+
+// Waits until a condition holds, failing the test after a second.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	for start := time.Now(); !cond(); time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatalf("%s: condition not met.", what)
+		}
+	}
+}
+
+// Tests that the metrics of multiple sources are aggregated, surviving source
+// restarts and expirations.
+func TestMetrics(t *testing.T) {
+	conn, err := iris.Connect(relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	collector, err := NewCollector(conn, "test-metrics", 100*time.Millisecond)
+	if err != nil {
+		t.Fatalf("collector creation failed: %v.", err)
+	}
+	defer collector.Close()
+	time.Sleep(10 * time.Millisecond)
+
+	// Emit some metrics from two sources
+	emitters := make(map[string]*Emitter)
+	for _, name := range []string{"alpha", "beta"} {
+		if emitters[name], err = NewEmitter(conn, "test-metrics", name, 10*time.Millisecond); err != nil {
+			t.Fatalf("emitter %s creation failed: %v.", name, err)
+		}
+	}
+	emitters["alpha"].Counter("requests").Add(3)
+	emitters["beta"].Counter("requests").Add(4)
+	emitters["alpha"].Gauge("queue").Set(1.5)
+	emitters["beta"].Gauge("queue").Set(2)
+
+	waitFor(t, "aggregation", func() bool {
+		gauges := collector.Gauges()
+		return collector.Counters()["requests"] == 7 && gauges["queue"]["alpha"] == 1.5 && gauges["queue"]["beta"] == 2
+	})
+	// Restart one of the sources and ensure its past counts are retained
+	if err := emitters["alpha"].Close(); err != nil {
+		t.Fatalf("emitter close failed: %v.", err)
+	}
+	if emitters["alpha"], err = NewEmitter(conn, "test-metrics", "alpha", 10*time.Millisecond); err != nil {
+		t.Fatalf("emitter restart failed: %v.", err)
+	}
+	emitters["alpha"].Counter("requests").Add(2)
+
+	waitFor(t, "restart", func() bool { return collector.Counters()["requests"] == 9 })
+
+	// Expose the metrics through HTTP
+	recorder := httptest.NewRecorder()
+	collector.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := recorder.Body.String()
+	for _, line := range []string{"requests 9\n", "queue{source=\"beta\"} 2\n"} {
+		if !strings.Contains(body, line) {
+			t.Fatalf("exposed metrics missing %q: have %q.", line, body)
+		}
+	}
+	// Stop the sources and ensure they expire, retaining the counts
+	for name, emitter := range emitters {
+		if err := emitter.Close(); err != nil {
+			t.Fatalf("emitter %s close failed: %v.", name, err)
+		}
+	}
+	waitFor(t, "expiration", func() bool { return len(collector.Gauges()) == 0 })
+
+	if total := collector.Counters()["requests"]; total != 9 {
+		t.Fatalf("retained total mismatch: have %d, want %d.", total, 9)
+	}
+}
+
+// Tests that stale snapshots arriving out of order are discarded.
+func TestCollectorStale(t *testing.T) {
+	collector := &Collector{sources: make(map[string]*source), retired: make(map[string]uint64)}
+
+	collector.HandleEvent([]byte(`{"source":"a","epoch":1,"seq":2,"counters":{"hits":5}}`))
+	collector.HandleEvent([]byte(`{"source":"a","epoch":1,"seq":1,"counters":{"hits":3}}`))
+	if hits := collector.Counters()["hits"]; hits != 5 {
+		t.Fatalf("stale snapshot merged: have %d, want %d.", hits, 5)
+	}
+	collector.HandleEvent([]byte(`{"source":"a","epoch":2,"seq":1,"counters":{"hits":1}}`))
+	if hits := collector.Counters()["hits"]; hits != 6 {
+		t.Fatalf("restart total mismatch: have %d, want %d.", hits, 6)
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Package mobile contains a simplified facade of the Iris binding, restricted
+// to the types supported by gomobile bind, so that Android and iOS applications
+// can consume Iris services natively.
+//
+// Compared to the full binding, timeouts are given in milliseconds, callbacks
+// are single method interfaces without multiple return values, and replies to
+// requests are returned as a Reply value carrying either the result or the
+// failure reason.
+package mobile
+
+import (
+	"errors"
+	"time"
+
+	"gopkg.in/project-iris/iris-go.v1"
+)
+
+// Callback interface for receiving topic events.
+type EventHandler interface {
+	HandleEvent(event []byte)
+}
+
+// Callback interface of a service instance. Requests must be answered with a
+// Reply created by either NewReply or NewFault.
+type ServiceHandler interface {
+	HandleBroadcast(message []byte)
+	HandleRequest(request []byte) *Reply
+	HandleTunnel(tunnel *Tunnel)
+	HandleDrop(reason string)
+}
+
+// Outcome of a serviced request, either a successful result or a failure.
+type Reply struct {
+	Data  []byte // Reply payload if successful
+	Fault string // Failure reason if unsuccessful
+}
+
+// Creates a successful request reply.
+func NewReply(data []byte) *Reply {
+	return &Reply{Data: data}
+}
+
+// Creates a failed request reply.
+func NewFault(reason string) *Reply {
+	return &Reply{Fault: reason}
+}
+
+// Client connection to the Iris network.
+type Client struct {
+	conn *iris.Connection
+}
+
+// Connects to the Iris network as a simple client.
+func Connect(port int) (*Client, error) {
+	conn, err := iris.Connect(port)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn}, nil
+}
+
+// Broadcasts a message to all members of a cluster.
+func (c *Client) Broadcast(cluster string, message []byte) error {
+	return c.conn.Broadcast(cluster, message)
+}
+
+// Executes a synchronous request to be serviced by a member of the specified
+// cluster, waiting at most timeout milliseconds for the reply.
+func (c *Client) Request(cluster string, request []byte, timeout int64) ([]byte, error) {
+	return c.conn.Request(cluster, request, time.Duration(timeout)*time.Millisecond)
+}
+
+// Subscribes to a topic, delivering the events to the given handler.
+func (c *Client) Subscribe(topic string, handler EventHandler) error {
+	if handler == nil {
+		return errors.New("nil event handler")
+	}
+	return c.conn.Subscribe(topic, &eventAdapter{handler}, nil)
+}
+
+// Publishes an event to all the subscribers of a topic.
+func (c *Client) Publish(topic string, event []byte) error {
+	return c.conn.Publish(topic, event)
+}
+
+// Unsubscribes from a topic, stopping the event deliveries.
+func (c *Client) Unsubscribe(topic string) error {
+	return c.conn.Unsubscribe(topic)
+}
+
+// Opens a direct tunnel to a member of a cluster, waiting at most timeout
+// milliseconds for the construction.
+func (c *Client) Tunnel(cluster string, timeout int64) (*Tunnel, error) {
+	tun, err := c.conn.Tunnel(cluster, time.Duration(timeout)*time.Millisecond)
+	if err != nil {
+		return nil, err
+	}
+	return &Tunnel{tun}, nil
+}
+
+// Gracefully terminates the connection.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Service instance registered into an Iris cluster.
+type Service struct {
+	serv *iris.Service
+}
+
+// Connects to the Iris network and registers a new service instance as a member
+// of the specified cluster.
+func Register(port int, cluster string, handler ServiceHandler) (*Service, error) {
+	if handler == nil {
+		return nil, errors.New("nil service handler")
+	}
+	serv, err := iris.Register(port, cluster, &serviceAdapter{handler: handler}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Service{serv}, nil
+}
+
+// Unregisters the service instance from the Iris network.
+func (s *Service) Unregister() error {
+	return s.serv.Unregister()
+}
+
+// Ordered, bidirectional message stream between two endpoints.
+type Tunnel struct {
+	tun *iris.Tunnel
+}
+
+// Sends a message over the tunnel, waiting at most timeout milliseconds for
+// transfer allowance (zero = block indefinitely).
+func (t *Tunnel) Send(message []byte, timeout int64) error {
+	return t.tun.Send(message, time.Duration(timeout)*time.Millisecond)
+}
+
+// Retrieves a message from the tunnel, waiting at most timeout milliseconds for
+// one to arrive (zero = block indefinitely).
+func (t *Tunnel) Recv(timeout int64) ([]byte, error) {
+	return t.tun.Recv(time.Duration(timeout) * time.Millisecond)
+}
+
+// Closes the tunnel between the endpoints.
+func (t *Tunnel) Close() error {
+	return t.tun.Close()
+}
+
+// Adapter delivering the topic events to a mobile handler.
+type eventAdapter struct {
+	handler EventHandler
+}
+
+func (a *eventAdapter) HandleEvent(event []byte) {
+	a.handler.HandleEvent(event)
+}
+
+// Adapter translating the service callbacks to a mobile handler.
+type serviceAdapter struct {
+	handler ServiceHandler
+}
+
+func (a *serviceAdapter) Init(conn *iris.Connection) error {
+	return nil
+}
+
+func (a *serviceAdapter) HandleBroadcast(message []byte) {
+	a.handler.HandleBroadcast(message)
+}
+
+func (a *serviceAdapter) HandleRequest(request []byte) ([]byte, error) {
+	reply := a.handler.HandleRequest(request)
+	switch {
+	case reply == nil:
+		return nil, errors.New("no reply from request handler")
+	case reply.Fault != "":
+		return nil, errors.New(reply.Fault)
+	case reply.Data == nil:
+		return []byte{}, nil
+	default:
+		return reply.Data, nil
+	}
+}
+
+func (a *serviceAdapter) HandleTunnel(tunnel *iris.Tunnel) {
+	a.handler.HandleTunnel(&Tunnel{tunnel})
+}
+
+func (a *serviceAdapter) HandleDrop(reason error) {
+	a.handler.HandleDrop(reason.Error())
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package mobile
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// Port of the local relay to run the tests against.
+var relay = 55555
+
+// Service handler echoing requests back, failing the ones asking for it.
+type echoHandler struct{}
+
+func (h *echoHandler) HandleBroadcast(message []byte) { panic("not implemented") }
+func (h *echoHandler) HandleTunnel(tunnel *Tunnel)    { panic("not implemented") }
+func (h *echoHandler) HandleDrop(reason string)       { panic("not implemented") }
+
+func (h *echoHandler) HandleRequest(request []byte) *Reply {
+	if string(request) == "fail" {
+		return NewFault("requested failure")
+	}
+	return NewReply(request)
+}
+
+// Event handler forwarding the events into a channel.
+type eventHandler struct {
+	events chan []byte
+}
+
+func (h *eventHandler) HandleEvent(event []byte) {
+	h.events <- event
+}
+
+// Tests that requests and events pass through the facade.
+func TestFacade(t *testing.T) {
+	serv, err := Register(relay, "go-mobile-test-cluster", new(echoHandler))
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	client, err := Connect(relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer client.Close()
+
+	// Ensure replies and faults are delivered
+	if reply, err := client.Request("go-mobile-test-cluster", []byte("ping"), 1000); err != nil {
+		t.Fatalf("request failed: %v.", err)
+	} else if !bytes.Equal(reply, []byte("ping")) {
+		t.Fatalf("reply mismatch: have %q, want %q.", reply, "ping")
+	}
+	if _, err := client.Request("go-mobile-test-cluster", []byte("fail"), 1000); err == nil || err.Error() != "requested failure" {
+		t.Fatalf("fault mismatch: have %v, want %v.", err, "requested failure")
+	}
+	// Ensure events are delivered
+	handler := &eventHandler{events: make(chan []byte, 1)}
+	if err := client.Subscribe("go-mobile-test-topic", handler); err != nil {
+		t.Fatalf("subscription failed: %v.", err)
+	}
+	defer client.Unsubscribe("go-mobile-test-topic")
+	time.Sleep(100 * time.Millisecond)
+
+	if err := client.Publish("go-mobile-test-topic", []byte("event")); err != nil {
+		t.Fatalf("publish failed: %v.", err)
+	}
+	select {
+	case event := <-handler.events:
+		if !bytes.Equal(event, []byte("event")) {
+			t.Fatalf("event mismatch: have %q, want %q.", event, "event")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("event delivery timed out.")
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the enforcement of the message size caps.
+//
+// Outbound messages (broadcasts, requests, replies, events and tunnel messages)
+// larger than the send cap are rejected locally before reaching the relay link,
+// whereas inbound ones larger than the receive cap are dropped before reaching
+// the handlers: requests are answered with the failure, replies fail the call,
+// and tunnels receiving an oversized message are torn down. The caps apply to
+// the plain payloads, envelopes and compression excluded.
+
+package iris
+
+import "fmt"
+
+// Returned if a message exceeded the configured size cap.
+type ErrMessageTooLarge struct {
+	Size  int // Size of the rejected message
+	Limit int // Size cap the message exceeded
+}
+
+func (e *ErrMessageTooLarge) Error() string {
+	return fmt.Sprintf("message too large: %d bytes > %d limit", e.Size, e.Limit)
+}
+
+// Checks an outbound message size against the send cap.
+func (c *Connection) checkSendSize(size int) error {
+	if limit := c.options.MaxSendSize; limit > 0 && size > limit {
+		return &ErrMessageTooLarge{Size: size, Limit: limit}
+	}
+	return nil
+}
+
+// Checks an inbound message size against the receive cap.
+func (c *Connection) checkRecvSize(size int) error {
+	if limit := c.options.MaxRecvSize; limit > 0 && size > limit {
+		return &ErrMessageTooLarge{Size: size, Limit: limit}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Tests that oversized messages are rejected locally on send and dropped on
+// receive.
+func TestMessageSizeLimits(t *testing.T) {
+	// Register a service capping its inbound requests
+	serv, err := RegisterWithOptions(config.relay, config.cluster, new(requestTestHandler), nil, &Options{MaxRecvSize: 16})
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	// Connect a client capping both directions
+	conn, err := ConnectWithOptions(config.relay, &Options{MaxSendSize: 32, MaxRecvSize: 8})
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Verify that oversized sends fail locally with the size and limit
+	large := bytes.Repeat([]byte{'x'}, 64)
+	check := func(op string, err error) {
+		if terr, ok := err.(*ErrMessageTooLarge); !ok || terr.Size != 64 || terr.Limit != 32 {
+			t.Fatalf("%s size error mismatch: have %v, want 64 > 32.", op, err)
+		}
+	}
+	check("broadcast", conn.Broadcast(config.cluster, large))
+	check("publish", conn.Publish(config.topic, large))
+	_, err = conn.Request(config.cluster, large, time.Second)
+	check("request", err)
+
+	// Verify that oversized inbound requests are rejected remotely
+	_, err = conn.Request(config.cluster, bytes.Repeat([]byte{'x'}, 24), time.Second)
+	if _, ok := err.(*RemoteError); !ok || !strings.Contains(err.Error(), "message too large") {
+		t.Fatalf("remote size error mismatch: have %v.", err)
+	}
+	// Verify that oversized replies fail the request locally
+	_, err = conn.Request(config.cluster, bytes.Repeat([]byte{'x'}, 12), time.Second)
+	if terr, ok := err.(*ErrMessageTooLarge); !ok || terr.Size != 12 || terr.Limit != 8 {
+		t.Fatalf("reply size error mismatch: have %v, want 12 > 8.", err)
+	}
+	// Verify that messages within the caps pass
+	if reply, err := conn.Request(config.cluster, []byte("small"), time.Second); err != nil || string(reply) != "small" {
+		t.Fatalf("small request failed: have %q/%v.", reply, err)
+	}
+}
+
+// Tests that tunnels receiving an oversized message are torn down.
+func TestMessageSizeLimitTunnel(t *testing.T) {
+	// Register a tunnel echo service capping its inbound messages
+	serv, err := RegisterWithOptions(config.relay, config.cluster, new(tunnelTestHandler), nil, &Options{MaxRecvSize: 16})
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	tun, err := conn.Tunnel(config.cluster, time.Second)
+	if err != nil {
+		t.Fatalf("tunnel construction failed: %v.", err)
+	}
+	defer tun.Close()
+
+	// Verify that small messages are echoed, but large ones kill the tunnel
+	if err := tun.Send([]byte("small"), time.Second); err != nil {
+		t.Fatalf("small send failed: %v.", err)
+	}
+	if msg, err := tun.Recv(time.Second); err != nil || string(msg) != "small" {
+		t.Fatalf("small echo mismatch: have %q/%v.", msg, err)
+	}
+	if err := tun.Send(bytes.Repeat([]byte{'x'}, 64), time.Second); err != nil {
+		t.Fatalf("large send failed: %v.", err)
+	}
+	if msg, err := tun.Recv(time.Second); err != ErrClosed {
+		t.Fatalf("oversized message not rejected: have %q/%v, want closed.", msg, err)
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the namespacing of cluster and topic names.
+//
+// Multiple applications sharing one Iris fabric may easily pick colliding names
+// for their services and topics. A namespace view of a connection prefixes all
+// the cluster and topic names passed to it with its own name, and strips the
+// prefix from the sender names of the delivered events, so each application may
+// keep using short names without stepping on the others. Services are placed
+// into a namespace by registering them under the name returned by Name.
+
+package iris
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Separator between a namespace and the names within.
+const namespaceSeparator = "."
+
+// View of a connection confined to a namespace of clusters and topics.
+type Namespace struct {
+	conn   *Connection // Connection to communicate through
+	prefix string      // Prefix of the names within the namespace
+}
+
+// Creates a view of the connection confined to the given namespace.
+func (c *Connection) Namespace(name string) *Namespace {
+	return &Namespace{
+		conn:   c,
+		prefix: name + namespaceSeparator,
+	}
+}
+
+// Creates a view confined to a namespace nested within the current one.
+func (n *Namespace) Namespace(name string) *Namespace {
+	return &Namespace{
+		conn:   n.conn,
+		prefix: n.prefix + name + namespaceSeparator,
+	}
+}
+
+// Retrieves the fully qualified name of a cluster or topic in the namespace,
+// e.g. to register a service into it.
+func (n *Namespace) Name(name string) string {
+	return n.prefix + name
+}
+
+// Strips the namespace from a fully qualified name, if it's within.
+func (n *Namespace) strip(name string) string {
+	return strings.TrimPrefix(name, n.prefix)
+}
+
+// Broadcasts a message to all members of a cluster in the namespace.
+func (n *Namespace) Broadcast(cluster string, message []byte) error {
+	return n.conn.Broadcast(n.Name(cluster), message)
+}
+
+// Broadcasts a message to all members of a cluster in the namespace, aborting
+// if the context is cancelled before the message is handed to the relay.
+func (n *Namespace) BroadcastContext(ctx context.Context, cluster string, message []byte) error {
+	return n.conn.BroadcastContext(ctx, n.Name(cluster), message)
+}
+
+// Executes a synchronous request to be serviced by a member of a cluster in the
+// namespace.
+func (n *Namespace) Request(cluster string, request []byte, timeout time.Duration) ([]byte, error) {
+	return n.conn.Request(n.Name(cluster), request, timeout)
+}
+
+// Executes a synchronous request to be serviced by a member of a cluster in the
+// namespace, aborting if the context is cancelled.
+func (n *Namespace) RequestContext(ctx context.Context, cluster string, request []byte, timeout time.Duration) ([]byte, error) {
+	return n.conn.RequestContext(ctx, n.Name(cluster), request, timeout)
+}
+
+// Subscribes to a topic in the namespace, delivering the events to the handler
+// with the namespace stripped from the sender names.
+func (n *Namespace) Subscribe(topic string, handler TopicHandler, limits *TopicLimits) error {
+	return n.conn.Subscribe(n.Name(topic), n.wrap(handler), limits)
+}
+
+// Unsubscribes from a topic in the namespace.
+func (n *Namespace) Unsubscribe(topic string) error {
+	return n.conn.Unsubscribe(n.Name(topic))
+}
+
+// Adjusts the threading and memory limits of a subscription in the namespace.
+func (n *Namespace) SetTopicLimits(topic string, limits *TopicLimits) error {
+	return n.conn.SetTopicLimits(n.Name(topic), limits)
+}
+
+// Publishes an event to all subscribers of a topic in the namespace.
+func (n *Namespace) Publish(topic string, event []byte) error {
+	return n.conn.Publish(n.Name(topic), event)
+}
+
+// Publishes an event to all subscribers of a topic in the namespace, aborting
+// if the context is cancelled before the event is handed to the relay.
+func (n *Namespace) PublishContext(ctx context.Context, topic string, event []byte) error {
+	return n.conn.PublishContext(ctx, n.Name(topic), event)
+}
+
+// Publishes an event to a topic in the namespace, waiting for the relay to
+// acknowledge it and returning the number of subscribers it was routed to.
+func (n *Namespace) PublishConfirmed(topic string, event []byte, timeout time.Duration) (int, error) {
+	return n.conn.PublishConfirmed(n.Name(topic), event, timeout)
+}
+
+// Opens a direct tunnel to a member of a cluster in the namespace.
+func (n *Namespace) Tunnel(cluster string, timeout time.Duration) (*Tunnel, error) {
+	return n.conn.Tunnel(n.Name(cluster), timeout)
+}
+
+// Opens a direct tunnel to a member of a cluster in the namespace, aborting if
+// the context is cancelled.
+func (n *Namespace) TunnelContext(ctx context.Context, cluster string, timeout time.Duration) (*Tunnel, error) {
+	return n.conn.TunnelContext(ctx, n.Name(cluster), timeout)
+}
+
+// Wraps a topic handler to strip the namespace from the delivered metadata,
+// retaining its keyed ordering if any.
+func (n *Namespace) wrap(handler TopicHandler) TopicHandler {
+	wrapped := &namespaceTopicHandler{ns: n, handler: handler}
+	if keyer, ok := handler.(KeyedEventHandler); ok {
+		return &namespaceKeyedTopicHandler{wrapped, keyer}
+	}
+	return wrapped
+}
+
+// Topic handler stripping the namespace from the sender of the events.
+type namespaceTopicHandler struct {
+	ns      *Namespace   // Namespace to strip from the sender
+	handler TopicHandler // Handler to deliver the events to
+}
+
+// Implements TopicHandler.HandleEvent.
+func (h *namespaceTopicHandler) HandleEvent(event []byte) {
+	h.handler.HandleEvent(event)
+}
+
+// Implements EventMetadataHandler.HandleEventWithMetadata, stripping the sender
+// name before delivery if the wrapped handler accepts metadata.
+func (h *namespaceTopicHandler) HandleEventWithMetadata(event []byte, meta *Metadata) {
+	handler, ok := h.handler.(EventMetadataHandler)
+	if !ok {
+		h.handler.HandleEvent(event)
+		return
+	}
+	stripped := *meta
+	stripped.Sender = h.ns.strip(meta.Sender)
+	handler.HandleEventWithMetadata(event, &stripped)
+}
+
+// Namespaced topic handler retaining the event keys of the wrapped one.
+type namespaceKeyedTopicHandler struct {
+	*namespaceTopicHandler
+	KeyedEventHandler
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// Topic handler forwarding the arrived events and their senders into channels.
+type nsTestTopicHandler struct {
+	events  chan []byte
+	senders chan string
+}
+
+func (n *nsTestTopicHandler) HandleEvent(event []byte) { panic("not implemented") }
+func (n *nsTestTopicHandler) HandleEventWithMetadata(event []byte, meta *Metadata) {
+	n.events <- event
+	n.senders <- meta.Sender
+}
+
+// Tests that namespaces isolate the same names from each other and strip the
+// namespace from the delivered sender names.
+func TestNamespace(t *testing.T) {
+	conn, err := ConnectWithOptions(config.relay, &Options{Metadata: true, Name: "billing.app"})
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	billing, shipping := conn.Namespace("billing"), conn.Namespace("shipping")
+
+	// Register a service into one of the namespaces and request through both
+	serv, err := Register(config.relay, billing.Name(config.cluster), new(requestTestHandler), nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	if rep, err := billing.Request(config.cluster, []byte("billed"), time.Second); err != nil {
+		t.Fatalf("namespaced request failed: %v.", err)
+	} else if !bytes.Equal(rep, []byte("billed")) {
+		t.Fatalf("reply mismatch: have %s, want %s.", rep, "billed")
+	}
+	if _, err := shipping.Request(config.cluster, []byte("shipped"), 100*time.Millisecond); err == nil {
+		t.Fatalf("request crossed namespaces.")
+	}
+	// Subscribe to the same topic in both namespaces and publish into one
+	handlers := make(map[*Namespace]*nsTestTopicHandler)
+	for _, ns := range []*Namespace{billing, shipping} {
+		handlers[ns] = &nsTestTopicHandler{make(chan []byte, 1), make(chan string, 1)}
+		if err := ns.Subscribe(config.topic, handlers[ns], nil); err != nil {
+			t.Fatalf("namespaced subscription failed: %v.", err)
+		}
+		defer ns.Unsubscribe(config.topic)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	if err := billing.Publish(config.topic, []byte("invoice")); err != nil {
+		t.Fatalf("namespaced publish failed: %v.", err)
+	}
+	select {
+	case event := <-handlers[billing].events:
+		if !bytes.Equal(event, []byte("invoice")) {
+			t.Fatalf("event mismatch: have %s, want %s.", event, "invoice")
+		}
+		if sender := <-handlers[billing].senders; sender != "app" {
+			t.Fatalf("sender mismatch: have %s, want %s.", sender, "app")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("namespaced event not delivered.")
+	}
+	select {
+	case event := <-handlers[shipping].events:
+		t.Fatalf("event crossed namespaces: %s.", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
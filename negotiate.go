@@ -0,0 +1,138 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the protocol version and feature negotiation with the relay.
+//
+// The binding advertises the extension features it wishes to use by appending
+// them to the protocol version of the connection initiation, each prefixed by a
+// plus sign (e.g. v1.0-draft2+auth). Relays supporting the negotiation reply
+// with the version tag listing the features they agreed to, whereas older ones
+// either refuse the unknown version, or accept with the plain one. Refusals
+// are retried on a fresh connection with the mandatory features only, so the
+// binding works with both old and new relay releases.
+
+package iris
+
+import (
+	"bufio"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/project-iris/iris-go.v1/relaywire"
+)
+
+// Optional extension features the binding requests from every relay, enabled
+// only if the relay agrees.
+var optionalFeatures = []string{relaywire.FeatureConfirm, relaywire.FeatureHeader, relaywire.FeatureAbort}
+
+// Returned if the relay refused the requested protocol version.
+type versionDeniedError string
+
+func (e versionDeniedError) Error() string {
+	return "connection denied: " + string(e)
+}
+
+// Assembles the version tag advertising the given extension features.
+func versionTag(features []string) string {
+	tag := protoVersion
+	for _, feature := range features {
+		tag += "+" + feature
+	}
+	return tag
+}
+
+// Splits a version tag into the protocol version and the extension features.
+func parseVersionTag(tag string) (string, []string) {
+	parts := strings.Split(tag, "+")
+	return parts[0], parts[1:]
+}
+
+// Checks whether a feature is contained in a feature list.
+func hasFeature(features []string, feature string) bool {
+	for _, f := range features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// Initializes the relay connection, negotiating the protocol version and the
+// extension features. If the relay refuses the optional features, the handshake
+// is retried on a fresh connection with only the mandatory ones.
+func (c *Connection) handshake(port int, cluster string) error {
+	// Assemble the mandatory and optional features
+	var required []string
+	var kind string
+	var credential []byte
+	if c.options.Credentials != nil {
+		var err error
+		if kind, credential, err = c.options.Credentials.Credential(cluster); err != nil {
+			return err
+		}
+		required = append(required, relaywire.FeatureAuth)
+	}
+	requested := append(append([]string{}, required...), optionalFeatures...)
+	if c.options.Checksums {
+		requested = append(requested, relaywire.FeatureCRC)
+	}
+
+	for {
+		if err := c.sendInit(cluster, requested, kind, credential); err != nil {
+			return err
+		}
+		tag, err := c.procInit()
+		if err == nil {
+			// Accepted, agree on the features both sides support
+			version, granted := parseVersionTag(tag)
+			c.version, c.features = version, make(map[string]bool)
+			for _, feature := range granted {
+				if hasFeature(requested, feature) {
+					c.features[feature] = true
+				}
+			}
+			for _, feature := range required {
+				if !c.features[feature] {
+					return fmt.Errorf("relay refused mandatory feature: %s", feature)
+				}
+			}
+			c.sockEnc.SetChecksums(c.features[relaywire.FeatureCRC])
+			c.sockDec.SetChecksums(c.features[relaywire.FeatureCRC])
+
+			c.Log.Debug("protocol negotiated", "version", c.version, "features", c.Features())
+			return nil
+		}
+		// Refused, retry without the optional features if any were requested
+		if _, ok := err.(versionDeniedError); !ok || len(requested) == len(required) {
+			return err
+		}
+		c.Log.Debug("relay refused optional features, retrying", "features", requested)
+		requested = required
+
+		c.sock.Close()
+		sock, err := dialRelay(c.options, port)
+		if err != nil {
+			return err
+		}
+		c.sock, c.sockBuf = sock, bufio.NewReadWriter(bufio.NewReader(sock), bufio.NewWriter(sock))
+	}
+}
+
+// Retrieves the extension features agreed upon with the relay, sorted.
+func (c *Connection) Features() []string {
+	features := make([]string, 0, len(c.features))
+	for feature := range c.features {
+		features = append(features, feature)
+	}
+	sort.Strings(features)
+	return features
+}
+
+// Checks whether an extension feature was agreed upon with the relay.
+func (c *Connection) supports(feature string) bool {
+	return c.features[feature]
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/project-iris/iris-go.v1/sim"
+)
+
+// Tests that optional features are negotiated with new relays and dropped with
+// old ones.
+func TestNegotiation(t *testing.T) {
+	defer func(old []string) { optionalFeatures = old }(optionalFeatures)
+	optionalFeatures = []string{"alpha", "beta"}
+
+	tests := []struct {
+		config   *sim.Config
+		features []string
+	}{
+		{&sim.Config{Features: []string{"beta", "gamma"}}, []string{"beta"}},
+		{&sim.Config{}, []string{}},
+		{&sim.Config{Legacy: true}, []string{}},
+	}
+	for i, tt := range tests {
+		relay, err := sim.NewRelay(tt.config)
+		if err != nil {
+			t.Fatalf("test %d: failed to start relay: %v.", i, err)
+		}
+		conn, err := Connect(relay.Port())
+		if err != nil {
+			relay.Close()
+			t.Fatalf("test %d: connection failed: %v.", i, err)
+		}
+		if features := conn.Features(); !reflect.DeepEqual(features, tt.features) {
+			t.Errorf("test %d: feature mismatch: have %v, want %v.", i, features, tt.features)
+		}
+		if conn.version != protoVersion {
+			t.Errorf("test %d: version mismatch: have %v, want %v.", i, conn.version, protoVersion)
+		}
+		conn.Close()
+		relay.Close()
+	}
+}
+
+// Tests that mandatory features are not dropped when refused.
+func TestNegotiationMandatory(t *testing.T) {
+	relay, err := sim.NewRelay(&sim.Config{Legacy: true})
+	if err != nil {
+		t.Fatalf("failed to start relay: %v.", err)
+	}
+	defer relay.Close()
+
+	if _, err := ConnectWithOptions(relay.Port(), &Options{Credentials: TokenCredentials("secret")}); err == nil {
+		t.Fatalf("authenticated connection to legacy relay succeeded.")
+	}
+}
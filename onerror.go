@@ -0,0 +1,37 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the reporting of background failures. Messages dropped or failing
+// outside of any API call (e.g. undecryptable or oversized deliveries, failed
+// replies and receipts, delayed sends, the relay link dropping) are otherwise
+// only logged, so the application may hook in to count or forward them too.
+
+package iris
+
+import "fmt"
+
+// Sets the callback invoked with the failures the binding runs into in the
+// background, replacing any previous one; nil removes it. The callback runs on
+// the binding's internal goroutines, so it should return quickly.
+func (c *Connection) OnError(handler func(err error)) {
+	c.errHook.Store(errorHook{handler})
+	for _, lane := range c.lanes {
+		lane.OnError(handler)
+	}
+}
+
+// Wrapper around the error callback, as atomic values cannot hold nil.
+type errorHook struct {
+	handler func(err error)
+}
+
+// Reports a background failure to the application's error callback, if any.
+func (c *Connection) reportError(format string, args ...interface{}) {
+	hook, _ := c.errHook.Load().(errorHook)
+	if hook.handler != nil {
+		hook.handler(fmt.Errorf(format, args...))
+	}
+}
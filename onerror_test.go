@@ -0,0 +1,54 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Tests that messages dropped in the background are reported to the error hook.
+func TestOnError(t *testing.T) {
+	serv, err := Register(config.relay, config.cluster, new(requestTestHandler), &ServiceLimits{RequestMemory: 1})
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	errs := make(chan error, 1)
+	serv.conn.OnError(func(err error) { errs <- err })
+
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Send an oversized request and ensure the drop is reported
+	if _, err := conn.Request(config.cluster, []byte("oversized request"), 100*time.Millisecond); err == nil {
+		t.Fatalf("oversized request succeeded.")
+	}
+	select {
+	case err := <-errs:
+		if !strings.Contains(err.Error(), "memory allowance") {
+			t.Fatalf("reported error mismatch: have %v.", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("dropped request not reported.")
+	}
+	// Remove the hook and ensure further drops are not reported
+	serv.conn.OnError(nil)
+	if _, err := conn.Request(config.cluster, []byte("oversized request"), 100*time.Millisecond); err == nil {
+		t.Fatalf("oversized request succeeded.")
+	}
+	select {
+	case err := <-errs:
+		t.Fatalf("error reported after hook removal: %v.", err)
+	default:
+	}
+}
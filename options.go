@@ -0,0 +1,150 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the optional settings of client and service connections.
+
+package iris
+
+import "time"
+
+// Optional settings of a client or service connection. Any unset fields (i.e.
+// value of zero) will keep the binding's default behavior.
+type Options struct {
+	Endpoint  string           // Relay endpoint as scheme://address, overriding the local port
+	Dialer    Dialer           // Custom dialer of the TCP relay link (e.g. proxies, source binding)
+	Reconnect *ReconnectPolicy // Automatic re-establishment of dropped relay links
+	Faults    *Faults          // Fault injection layer for resilience testing
+	Sockets   int              // Relay sockets to stripe the outbound traffic across (defaults to 1)
+	Loopback  bool             // Deliver to the clusters registered in this process in memory
+	Checksums bool             // Checksum the relay frames to detect corruption (if the relay agrees)
+
+	Admission AdmissionController // Gate deciding whether to accept inbound requests
+	Fair      bool                // Schedule queued requests fairly between callers, round robin
+	Fairness  CallerKey           // Caller identification of the fair scheduling (defaults to the caller, implies fair)
+	Deadlines bool                // Run queued requests nearest deadline first (exclusive with fair)
+	Quotas    *QuotaPolicy        // Request and byte rate limits of each caller
+
+	Priorities *Priorities // Weighted sharing of the handler threads between message kinds
+
+	Clock    Clock            // Time source of the internal timers (defaults to the system one)
+	Timeouts *AdaptiveTimeout // Request timeout derivation from observed latencies
+	Defaults *DefaultTimeouts // Timeouts of the operations invoked with a zero one
+	Retry    *RetryPolicy     // Automatic retries of failed requests
+
+	Outstanding *ClusterLimit // Cap on the concurrent requests to a single cluster
+
+	Metadata    bool   // Attach delivery metadata envelopes to outbound messages
+	Name        string // Sender name reported in the metadata (defaults to the cluster)
+	RawPayloads bool   // Deliver inbound payloads as is, never unwrapping envelopes (peers that never envelope)
+
+	Credentials Credentials // Credentials to authenticate with to the relay
+	Cipher      Cipher      // End-to-end encryption of the message payloads
+
+	Compression *CompressionPolicy // Transparent compression of the large payloads
+
+	MaxSendSize int // Cap on the outbound message sizes, rejected locally above it (0 = unlimited)
+	MaxRecvSize int // Cap on the inbound message sizes, dropped above it (0 = unlimited)
+
+	Signers   map[string]Signer   // Message signers per target cluster or topic ("" = any)
+	Verifiers map[string]Verifier // Message verifiers per source cluster or topic ("" = any)
+
+	Validators map[string]Validator // Payload validators per cluster or topic, both directions ("" = any)
+
+	PublishPolicy   TopicPolicy // Local access control of the outgoing publishes
+	SubscribePolicy TopicPolicy // Local access control of the topic subscriptions
+
+	DeadLetter      DeadLetterHandler // Callback receiving the failed inbound messages
+	DeadLetterTopic string            // Topic to republish the failed inbound messages to
+
+	Quarantine  *QuarantinePolicy // Rejection of payloads repeatedly crashing their handlers
+	Idempotency IdempotencyStore  // Record of processed requests for duplicate suppression
+	ShedExpired bool              // Reply with ErrExpired to requests expired while queued
+	Audit       AuditHandler      // Callback receiving a record of every concluded inbound request
+
+	SlowConsumer *SlowConsumerPolicy // Reporting of handlers persistently falling behind
+	Tracing      *TracePolicy        // Sampled logging of the handler invocations
+
+	StageThreshold int    // Tunnel message size from which to stage off-heap (0 = never)
+	StageDir       string // Directory of the staging files (defaults to the system temp dir)
+
+	Tunnels *TunnelOptions // Chunking and flow control of the tunnels (defaults to adaptive)
+	Schemas []int          // Payload schema versions accepted in requests, advertised to callers (nil = unversioned)
+	Zone    string         // Zone (data center, region) of the connection for zone preferring requests
+
+	Instance string // Stable identifier of the service instance, making it reachable by pinned tunnels (empty = not addressable)
+}
+
+// Admission control callback invoked before queuing each inbound request, with
+// the number of requests already pending and the estimated time the new one
+// would need to complete. Returning false rejects the request early with an
+// ErrOverloaded failure instead of letting the caller time out.
+type AdmissionController func(pending int, latency time.Duration) bool
+
+// Local access control callback approving or denying an operation on a topic.
+// Denied operations fail with ErrDenied without reaching the relay.
+type TopicPolicy func(topic string) bool
+
+// Connection wide timeouts used by the operations invoked with a zero timeout,
+// sparing the call sites from each picking their own. A non-zero timeout passed
+// to an individual call always overrides these. Any unset fields (i.e. value of
+// zero) keep the binding's default behavior for that operation.
+//
+// Note, configuring the tunnel send or receive defaults means a zero timeout no
+// longer blocks infinitely; use SendContext for unbounded waits instead.
+type DefaultTimeouts struct {
+	Request time.Duration // Timeout of requests (adaptive timeouts take precedence)
+	Tunnel  time.Duration // Timeout of tunnel construction
+	Send    time.Duration // Timeout of tunnel sends
+	Recv    time.Duration // Timeout of tunnel receives
+}
+
+// Retrieves the default request timeout, or zero if none was configured.
+func (d *DefaultTimeouts) request() time.Duration {
+	if d == nil {
+		return 0
+	}
+	return d.Request
+}
+
+// Retrieves the default tunnel construction timeout, or zero if none was configured.
+func (d *DefaultTimeouts) tunnel() time.Duration {
+	if d == nil {
+		return 0
+	}
+	return d.Tunnel
+}
+
+// Retrieves the default tunnel send timeout, or zero if none was configured.
+func (d *DefaultTimeouts) send() time.Duration {
+	if d == nil {
+		return 0
+	}
+	return d.Send
+}
+
+// Retrieves the default tunnel receive timeout, or zero if none was configured.
+func (d *DefaultTimeouts) recv() time.Duration {
+	if d == nil {
+		return 0
+	}
+	return d.Recv
+}
+
+// Default settings of a client or service connection.
+var defaultOptions = Options{}
+
+// Merges the user requested options with the defaults.
+func finalizeOptions(user *Options) *Options {
+	// If the user didn't specify anything, load the full default set
+	if user == nil {
+		return &defaultOptions
+	}
+	// Check each field and merge only non-specified ones
+	options := new(Options)
+	*options = *user
+
+	return options
+}
@@ -0,0 +1,129 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the in-order delivery of ordered subscriptions and keyed handlers.
+//
+// Events normally race each other twice: every arrival is processed on its own
+// go-routine, and the subscription's handler pool runs them in parallel. The
+// events of ordered subscriptions are instead dispatched from a single FIFO in
+// arrival order, and the events sharing an ordering key are run one after the
+// other, while distinct keys still proceed in parallel.
+//
+// The key of an event is the one declared by a keyed handler, or otherwise the
+// publishing connection's caller id in its metadata (the sender name for older
+// bindings), with events lacking both all sharing the empty key.
+// Broadcasts arrive in order by default, so only keyed handlers serialize them.
+
+package iris
+
+import (
+	"sync"
+
+	"gopkg.in/project-iris/iris-go.v1/internal/pool"
+)
+
+// Optional extension of the ServiceHandler (or ServiceHandlerV2), declaring the
+// ordering key of the inbound broadcasts. Broadcasts with the same key are run
+// one by one in arrival order, whereas different keys run in parallel.
+type KeyedBroadcastHandler interface {
+	BroadcastKey(message []byte, meta *Metadata) string
+}
+
+// Optional extension of the TopicHandler, declaring the ordering key of the
+// inbound events. Events with the same key are run one by one in arrival order,
+// whereas different keys run in parallel.
+type KeyedEventHandler interface {
+	EventKey(event []byte, meta *Metadata) string
+}
+
+// Retrieves the broadcast key declarer of a service handler, if any.
+func broadcastKeyer(handler ServiceHandler) (KeyedBroadcastHandler, bool) {
+	if adapter, ok := handler.(*handlerV2Adapter); ok {
+		keyer, ok := adapter.handler.(KeyedBroadcastHandler)
+		return keyer, ok
+	}
+	keyer, ok := handler.(KeyedBroadcastHandler)
+	return keyer, ok
+}
+
+// Queues an event of an ordered subscription for dispatching, starting the
+// dispatcher if it's idle. Invoked only from the network receiver.
+func (c *Connection) dispatchOrdered(topic string, event []byte) {
+	c.orderLock.Lock()
+	defer c.orderLock.Unlock()
+
+	c.orderPend = append(c.orderPend, &orderedEvent{topic, event})
+	if !c.orderBusy {
+		c.orderBusy = true
+		go c.drainOrdered()
+	}
+}
+
+// Event of an ordered subscription waiting to be dispatched.
+type orderedEvent struct {
+	topic string
+	event []byte
+}
+
+// Dispatches the queued events of the ordered subscriptions one by one, until
+// the queue drains.
+func (c *Connection) drainOrdered() {
+	for {
+		c.orderLock.Lock()
+		if len(c.orderPend) == 0 {
+			c.orderPend, c.orderBusy = nil, false
+			c.orderLock.Unlock()
+			return
+		}
+		next := c.orderPend[0]
+		c.orderPend = c.orderPend[1:]
+		c.orderLock.Unlock()
+
+		c.handlePublish(next.topic, next.event)
+	}
+}
+
+// Per key queues of the handlers waiting behind a running one with the same key.
+type serialQueue struct {
+	pend map[string][]func() // Waiting handlers of each key with one running
+	lock sync.Mutex          // Protects the waiting handlers
+}
+
+// Creates an empty set of per key handler queues.
+func newSerialQueue() *serialQueue {
+	return &serialQueue{
+		pend: make(map[string][]func()),
+	}
+}
+
+// Runs a handler through the scheduler after all the previously scheduled ones
+// with the same key finished.
+func (q *serialQueue) schedule(run func(task pool.Task) error, key string, task func()) {
+	q.lock.Lock()
+	if pend, busy := q.pend[key]; busy {
+		q.pend[key] = append(pend, task)
+		q.lock.Unlock()
+		return
+	}
+	q.pend[key] = nil
+	q.lock.Unlock()
+
+	run(func() {
+		for {
+			task()
+
+			q.lock.Lock()
+			pend := q.pend[key]
+			if len(pend) == 0 {
+				delete(q.pend, key)
+				q.lock.Unlock()
+				return
+			}
+			task, q.pend[key] = pend[0], pend[1:]
+			q.lock.Unlock()
+		}
+	})
+}
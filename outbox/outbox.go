@@ -0,0 +1,162 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Package outbox contains a transactional outbox for reliable event emission:
+// applications record their intended publishes into a local store as part of
+// their own database transactions, and a background publisher drains the store
+// into Iris, removing each entry only after it was handed to the relay.
+//
+// Delivery is at-least-once: a crash between publishing an entry and removing
+// it from the store will result in the event being published again.
+package outbox
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"gopkg.in/project-iris/iris-go.v1"
+)
+
+// Pending publish recorded in an outbox store.
+type Entry struct {
+	ID    int64  // Store assigned identifier of the entry, increasing with insertion
+	Topic string // Topic to publish the event to
+	Event []byte // Event to publish
+}
+
+// Storage of the pending publishes. Implementations must be safe for concurrent
+// use, and the insertion of new entries is left to the store specific API, so
+// that it can join the application's own transactions.
+type Store interface {
+	// Retrieves at most limit pending entries, oldest first.
+	Fetch(limit int) ([]*Entry, error)
+
+	// Removes the published entries from the store.
+	Remove(ids ...int64) error
+}
+
+// Settings of the outbox publisher. Any unset fields (i.e. value of zero) will
+// default to the preset ones.
+type Config struct {
+	Interval time.Duration // Time between two polls of the store
+	Batch    int           // Maximum number of entries to drain in one go
+
+	Errors func(err error) // Callback notified of store or publish failures
+}
+
+// Default settings of the outbox publisher.
+var defaultConfig = Config{
+	Interval: time.Second,
+	Batch:    128,
+}
+
+// Background publisher draining an outbox store into Iris.
+type Publisher struct {
+	conn   *iris.Connection // Connection through which to publish
+	store  Store            // Store holding the pending publishes
+	config Config           // Settings of the publisher
+
+	kick chan struct{}   // Signaler for an immediate drain
+	quit chan chan error // Quit channel to stop the drainer
+	lock sync.Mutex      // Serializes the drains
+}
+
+// Starts a background publisher draining the store into the given connection.
+func New(conn *iris.Connection, store Store, config *Config) (*Publisher, error) {
+	// Sanity check on the arguments
+	if conn == nil {
+		return nil, errors.New("nil connection")
+	}
+	if store == nil {
+		return nil, errors.New("nil outbox store")
+	}
+	// Merge the user configs with the defaults
+	conf := defaultConfig
+	if config != nil {
+		if config.Interval != 0 {
+			conf.Interval = config.Interval
+		}
+		if config.Batch != 0 {
+			conf.Batch = config.Batch
+		}
+		conf.Errors = config.Errors
+	}
+	p := &Publisher{
+		conn:   conn,
+		store:  store,
+		config: conf,
+		kick:   make(chan struct{}, 1),
+		quit:   make(chan chan error),
+	}
+	go p.loop()
+	return p, nil
+}
+
+// Requests an immediate drain of the store, e.g. right after committing a
+// transaction that recorded new entries.
+func (p *Publisher) Notify() {
+	select {
+	case p.kick <- struct{}{}:
+	default:
+	}
+}
+
+// Drains the store synchronously, returning the first failure encountered.
+func (p *Publisher) Flush() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for {
+		entries, err := p.store.Fetch(p.config.Batch)
+		if err != nil || len(entries) == 0 {
+			return err
+		}
+		// Publish the batch in order, stopping at the first failure
+		done := make([]int64, 0, len(entries))
+		for _, entry := range entries {
+			if err = p.conn.Publish(entry.Topic, entry.Event); err != nil {
+				break
+			}
+			done = append(done, entry.ID)
+		}
+		if len(done) > 0 {
+			if rerr := p.store.Remove(done...); rerr != nil {
+				return rerr
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Stops the background publisher. Any entries still pending are left in the
+// store for the next publisher to drain.
+func (p *Publisher) Close() error {
+	errc := make(chan error)
+	p.quit <- errc
+	return <-errc
+}
+
+// Periodically drains the store until the publisher is closed.
+func (p *Publisher) loop() {
+	ticker := time.NewTicker(p.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case errc := <-p.quit:
+			errc <- nil
+			return
+		case <-p.kick:
+		case <-ticker.C:
+		}
+		if err := p.Flush(); err != nil && p.config.Errors != nil {
+			p.config.Errors(err)
+		}
+	}
+}
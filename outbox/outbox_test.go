@@ -0,0 +1,114 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package outbox
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"gopkg.in/project-iris/iris-go.v1"
+)
+
+// Port of the local relay to run the publisher tests against.
+var relay = 55555
+
+// Memory backed outbox store for the publisher tests.
+type memoryStore struct {
+	entries []*Entry
+	nextId  int64
+	lock    sync.Mutex
+}
+
+func (s *memoryStore) add(topic string, event []byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.nextId++
+	s.entries = append(s.entries, &Entry{ID: s.nextId, Topic: topic, Event: event})
+}
+
+func (s *memoryStore) Fetch(limit int) ([]*Entry, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(s.entries) < limit {
+		limit = len(s.entries)
+	}
+	return append([]*Entry(nil), s.entries[:limit]...), nil
+}
+
+func (s *memoryStore) Remove(ids ...int64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	gone := make(map[int64]bool)
+	for _, id := range ids {
+		gone[id] = true
+	}
+	kept := s.entries[:0]
+	for _, entry := range s.entries {
+		if !gone[entry.ID] {
+			kept = append(kept, entry)
+		}
+	}
+	s.entries = kept
+	return nil
+}
+
+// Topic handler collecting the arrived events.
+type collector chan string
+
+func (c collector) HandleEvent(event []byte) { c <- string(event) }
+
+// Tests that the recorded entries are published in order and removed.
+func TestPublisher(t *testing.T) {
+	conn, err := iris.Connect(relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	events := make(collector, 16)
+	if err := conn.Subscribe("outbox-test", events, nil); err != nil {
+		t.Fatalf("subscription failed: %v.", err)
+	}
+	defer conn.Unsubscribe("outbox-test")
+	time.Sleep(100 * time.Millisecond)
+
+	// Record a few entries and drain them with small batches
+	store := new(memoryStore)
+	for i := 0; i < 5; i++ {
+		store.add("outbox-test", []byte(fmt.Sprintf("event #%d", i)))
+	}
+	pub, err := New(conn, store, &Config{Interval: time.Hour, Batch: 2})
+	if err != nil {
+		t.Fatalf("failed to start publisher: %v.", err)
+	}
+	defer pub.Close()
+
+	// Events may be handled out of order, so only check the set of arrivals
+	pub.Notify()
+	arrived := make(map[string]bool)
+	for i := 0; i < 5; i++ {
+		select {
+		case event := <-events:
+			arrived[event] = true
+		case <-time.After(time.Second):
+			t.Fatalf("event %d timed out.", i)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		if event := fmt.Sprintf("event #%d", i); !arrived[event] {
+			t.Fatalf("event %q not published.", event)
+		}
+	}
+	if entries, _ := store.Fetch(16); len(entries) != 0 {
+		t.Fatalf("drained store not empty: %d entries left.", len(entries))
+	}
+}
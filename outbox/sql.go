@@ -0,0 +1,77 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package outbox
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Outbox store backed by an SQL table, expected to have the following layout
+// (adjusted to the dialect of the database in use):
+//
+//	CREATE TABLE outbox (
+//	  id    INTEGER PRIMARY KEY AUTOINCREMENT,
+//	  topic TEXT NOT NULL,
+//	  event BLOB NOT NULL
+//	)
+//
+// The queries use ? as the parameter placeholder, as understood by the MySQL
+// and SQLite drivers among others.
+type SQLStore struct {
+	db    *sql.DB // Database holding the outbox table
+	table string  // Name of the outbox table
+}
+
+// Creates an outbox store over the given table of the database.
+func NewSQLStore(db *sql.DB, table string) *SQLStore {
+	return &SQLStore{
+		db:    db,
+		table: table,
+	}
+}
+
+// Records an intended publish as part of the application's transaction, so that
+// it is emitted if and only if the transaction commits.
+func (s *SQLStore) Add(tx *sql.Tx, topic string, event []byte) error {
+	_, err := tx.Exec(fmt.Sprintf("INSERT INTO %s (topic, event) VALUES (?, ?)", s.table), topic, event)
+	return err
+}
+
+// Implements Store.Fetch.
+func (s *SQLStore) Fetch(limit int) ([]*Entry, error) {
+	rows, err := s.db.Query(fmt.Sprintf("SELECT id, topic, event FROM %s ORDER BY id LIMIT ?", s.table), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []*Entry
+	for rows.Next() {
+		entry := new(Entry)
+		if err := rows.Scan(&entry.ID, &entry.Topic, &entry.Event); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// Implements Store.Remove.
+func (s *SQLStore) Remove(ids ...int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	marks := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+	_, err := s.db.Exec(fmt.Sprintf("DELETE FROM %s WHERE id IN (%s)", s.table, marks), args...)
+	return err
+}
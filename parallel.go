@@ -0,0 +1,455 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the parallel transfers striping a stream across multiple tunnels.
+//
+// A single tunnel is throttled by its window and carried by a single relay
+// stream, so large transfers may be sped up by spreading them over a handful of
+// tunnels to the same peer. The sender opens a leader tunnel announcing the
+// transfer in its header, which the receiving service hands to ReceiveParallel.
+// The rest of the tunnels (stripes) join the transfer by its id and are routed
+// to it internally, never reaching the service's tunnel handler. As tunnels to
+// a cluster may land on any of its members, stripes arriving at a different
+// member are rejected there, and the sender retries a few times before going
+// ahead with the stripes it managed to attach.
+//
+// The stream is cut into numbered segments, handed to whichever stripe is free
+// next, and reordered on the receiving side. Segments of failed stripes are
+// resent through the remaining ones. The leader concludes the transfer with the
+// segment count, which the receiver acknowledges once it has all of them.
+
+package iris
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"gopkg.in/project-iris/iris-go.v1/relaywire"
+)
+
+// Tunnel header keys announcing a parallel transfer and joining its stripes.
+var (
+	parallelLeaderHeader = "iris-parallel"
+	parallelStripeHeader = "iris-parallel-stripe"
+)
+
+// Kinds of the parallel transfer messages.
+const (
+	parallelAck  byte = iota // Receiver attached a tunnel to the transfer
+	parallelData             // Numbered segment of the stream
+	parallelEnd              // Total number of segments sent
+	parallelDone             // Receiver has all the segments
+)
+
+// Settings of a parallel transfer. Any unset fields (i.e. value of zero) will
+// default to the preset ones.
+type ParallelOptions struct {
+	Stripes int           // Tunnels to spread the transfer across, leader included
+	Segment int           // Length of the pieces the stream is cut into
+	Timeout time.Duration // Timeout of the tunnel constructions and attachments
+}
+
+// Default settings of the parallel transfers.
+var defaultParallelOptions = ParallelOptions{
+	Stripes: 4,
+	Segment: 1024 * 1024,
+	Timeout: 10 * time.Second,
+}
+
+// Segments a receiver may buffer ahead of the reader before throttling stripes.
+var parallelReorderLimit = 64
+
+// Numbered piece of a parallel transfer.
+type parallelSegment struct {
+	seq  uint64 // Position of the segment in the stream
+	data []byte // Contents of the segment
+}
+
+// Outcome of sending a segment through a stripe.
+type parallelResult struct {
+	seg parallelSegment // Segment sent
+	err error           // Failure of the stripe, if any
+}
+
+// Assembles a parallel transfer message of the given kind.
+func encodeParallel(kind byte, seq uint64, data []byte) []byte {
+	msg := binary.AppendUvarint([]byte{kind}, seq)
+	return append(msg, data...)
+}
+
+// Splits a parallel transfer message into its kind, number and contents.
+func decodeParallel(msg []byte) (byte, uint64, []byte, error) {
+	if len(msg) == 0 {
+		return 0, 0, nil, errors.New("empty parallel transfer message")
+	}
+	seq, n := binary.Uvarint(msg[1:])
+	if n <= 0 {
+		return 0, 0, nil, errors.New("corrupt parallel transfer message")
+	}
+	return msg[0], seq, msg[1+n:], nil
+}
+
+// Waits for the receiver to acknowledge attaching a tunnel to the transfer.
+func expectParallel(tun *Tunnel, kind byte, deadline <-chan time.Time) error {
+	msg, err := tun.recv(deadline)
+	if err != nil {
+		return err
+	}
+	if got, _, _, err := decodeParallel(msg); err != nil || got != kind {
+		return fmt.Errorf("protocol violation: unexpected parallel transfer message")
+	}
+	return nil
+}
+
+// Streams the contents of r to a member of a remote cluster, striped across
+// multiple tunnels. The call blocks until the receiver has the entire stream,
+// or the transfer fails or the context is cancelled. Relays not supporting
+// tunnel headers fail the call with ErrUnsupported.
+func SendParallel(ctx context.Context, conn *Connection, cluster string, r io.Reader, options *ParallelOptions) error {
+	// Merge the user options with the defaults
+	opts := defaultParallelOptions
+	if options != nil {
+		if options.Stripes != 0 {
+			opts.Stripes = options.Stripes
+		}
+		if options.Segment != 0 {
+			opts.Segment = options.Segment
+		}
+		if options.Timeout != 0 {
+			opts.Timeout = options.Timeout
+		}
+	}
+	// Sanity check on the arguments
+	if opts.Stripes < 1 || opts.Segment < 1 {
+		return fmt.Errorf("invalid parallel options: %d stripes of %d byte segments", opts.Stripes, opts.Segment)
+	}
+	if !conn.supports(relaywire.FeatureHeader) {
+		return ErrUnsupported
+	}
+	// Announce the transfer through the leader tunnel
+	id := newCorrelationID()
+	leader, err := conn.TunnelWithHeader(cluster, map[string]string{parallelLeaderHeader: id}, opts.Timeout)
+	if err != nil {
+		return err
+	}
+	defer leader.Close()
+
+	if err := expectParallel(leader, parallelAck, conn.clock.After(opts.Timeout)); err != nil {
+		return err
+	}
+	// Attach the stripes, retrying the ones landing on other members
+	stripes := []*Tunnel{leader}
+	for attempt := 0; len(stripes) < opts.Stripes && attempt < 2*opts.Stripes; attempt++ {
+		tun, err := conn.TunnelWithHeader(cluster, map[string]string{parallelStripeHeader: id}, opts.Timeout)
+		if err != nil {
+			continue
+		}
+		if err := expectParallel(tun, parallelAck, conn.clock.After(opts.Timeout)); err != nil {
+			tun.Close()
+			continue
+		}
+		defer tun.Close()
+		stripes = append(stripes, tun)
+	}
+	conn.Log.Debug("parallel transfer started", "cluster", cluster, "stripes", len(stripes))
+
+	// Start the stripes, each sending whichever segment is next
+	sendCtx, cancel := context.WithCancel(ctx)
+	var (
+		work    = make(chan parallelSegment)
+		results = make(chan parallelResult)
+		pend    sync.WaitGroup
+	)
+	for _, tun := range stripes {
+		pend.Add(1)
+		go func(tun *Tunnel) {
+			defer pend.Done()
+			for seg := range work {
+				err := tun.SendContext(sendCtx, encodeParallel(parallelData, seg.seq, seg.data))
+				results <- parallelResult{seg: seg, err: err}
+				if err != nil {
+					return
+				}
+			}
+		}(tun)
+	}
+	defer func() {
+		// Stop the stripes, draining any results still reported
+		cancel()
+		close(work)
+		go func() {
+			pend.Wait()
+			close(results)
+		}()
+		for range results {
+		}
+	}()
+	// Feed the segments to the stripes until all are sent
+	var (
+		next     parallelSegment
+		hasNext  bool
+		retries  []parallelSegment
+		count    uint64
+		eof      bool
+		inflight int
+		live     = len(stripes)
+	)
+	for hasNext || len(retries) > 0 || !eof || inflight > 0 {
+		// Pick the next segment to send, failed ones first
+		if !hasNext {
+			if len(retries) > 0 {
+				next, hasNext, retries = retries[0], true, retries[1:]
+			} else if !eof {
+				buf := make([]byte, opts.Segment)
+				n, err := io.ReadFull(r, buf)
+				switch {
+				case err == io.EOF || err == io.ErrUnexpectedEOF:
+					eof = true
+				case err != nil:
+					return err
+				}
+				if n > 0 {
+					next, hasNext = parallelSegment{seq: count, data: buf[:n]}, true
+					count++
+				}
+			}
+		}
+		if !hasNext && inflight == 0 {
+			continue // Stream exhausted, nothing left to wait for
+		}
+		var out chan parallelSegment
+		if hasNext {
+			out = work
+		}
+		select {
+		case out <- next:
+			hasNext = false
+			inflight++
+		case res := <-results:
+			inflight--
+			if res.err != nil {
+				retries = append(retries, res.seg)
+				if live--; live == 0 {
+					return errors.New("parallel transfer failed: all stripes closed")
+				}
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	// Conclude the transfer and wait for the receiver to confirm it
+	if err := leader.SendContext(ctx, encodeParallel(parallelEnd, count, nil)); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() { done <- expectParallel(leader, parallelDone, nil) }()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Receiving end of a parallel transfer, reassembling the stream from the stripe
+// tunnels in order.
+type ParallelReader struct {
+	conn    *Connection // Connection the transfer arrives through
+	id      string      // Identifier of the transfer the stripes join by
+	leader  *Tunnel     // Tunnel announcing and concluding the transfer
+	stripes []*Tunnel   // Tunnels attached to the transfer, leader included
+	live    int         // Number of stripes still receiving
+
+	segs   map[uint64][]byte // Segments arrived ahead of the reader
+	next   uint64            // Number of the next segment to read
+	have   uint64            // Number of distinct segments arrived
+	total  uint64            // Number of segments sent, once the leader concluded
+	ended  bool              // Whether the leader concluded the transfer
+	done   bool              // Whether the transfer was confirmed to the sender
+	bound  bool              // Whether stripes are throttled to the reorder limit
+	cur    []byte            // Unread remainder of the current segment
+	err    error             // Failure terminating the transfer, if any
+	closed bool              // Whether the reader was closed
+
+	lock sync.Mutex
+	cond *sync.Cond
+}
+
+// Starts receiving a parallel transfer announced through an inbound tunnel, to
+// be called from the tunnel handler for tunnels whose header marks a transfer
+// leader. Reading the transfer to its end confirms it to the sender.
+func ReceiveParallel(tun *Tunnel) (*ParallelReader, error) {
+	// Sanity check on the arguments
+	id, ok := tun.Header()[parallelLeaderHeader]
+	if !ok {
+		return nil, errors.New("tunnel not a parallel transfer leader")
+	}
+	// Register the transfer to route its stripes to
+	p := &ParallelReader{
+		conn:   tun.conn.service(),
+		id:     id,
+		leader: tun,
+		segs:   make(map[uint64][]byte),
+		bound:  true,
+	}
+	p.cond = sync.NewCond(&p.lock)
+	p.conn.tunPara.Store(p.id, p)
+
+	if err := p.attach(tun); err != nil {
+		p.conn.tunPara.Delete(p.id)
+		return nil, err
+	}
+	return p, nil
+}
+
+// Checks whether an inbound tunnel is a stripe of a parallel transfer, routing
+// it to the transfer if registered locally, or rejecting it otherwise.
+func (c *Connection) joinParallel(tun *Tunnel) bool {
+	id, ok := tun.Header()[parallelStripeHeader]
+	if !ok {
+		return false
+	}
+	if p, ok := c.tunPara.Load(id); ok {
+		if err := p.(*ParallelReader).attach(tun); err == nil {
+			return true
+		}
+	}
+	tun.Log.Debug("rejecting unknown parallel stripe", "transfer", id)
+	tun.Abort("unknown parallel transfer")
+	return true
+}
+
+// Attaches a tunnel to the transfer, acknowledging it to the sender and starting
+// to receive the segments.
+func (p *ParallelReader) attach(tun *Tunnel) error {
+	p.lock.Lock()
+	if p.closed || p.err != nil || (p.ended && p.have == p.total) {
+		p.lock.Unlock()
+		return ErrClosed
+	}
+	p.stripes = append(p.stripes, tun)
+	p.live++
+	p.lock.Unlock()
+
+	if err := tun.Send(encodeParallel(parallelAck, 0, nil), 0); err != nil {
+		p.detach(tun, err)
+		return err
+	}
+	go p.pump(tun)
+	return nil
+}
+
+// Marks a stripe as no longer receiving, failing the transfer if it can't be
+// completed any more.
+func (p *ParallelReader) detach(tun *Tunnel, err error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.live--
+	if p.err == nil && !(p.ended && p.have == p.total) {
+		switch {
+		case tun == p.leader:
+			p.err = fmt.Errorf("parallel transfer failed: leader: %v", err)
+		case p.live == 0:
+			p.err = errors.New("parallel transfer failed: all stripes closed")
+		default:
+			// Resent segments may arrive out of order, drop the throttling
+			p.bound = false
+		}
+	}
+	p.cond.Broadcast()
+}
+
+// Receives the messages of a stripe until it is closed.
+func (p *ParallelReader) pump(tun *Tunnel) {
+	for {
+		msg, err := tun.recv(nil)
+		if err != nil {
+			p.detach(tun, err)
+			return
+		}
+		kind, seq, data, err := decodeParallel(msg)
+		if err != nil || (kind != parallelData && !(kind == parallelEnd && tun == p.leader)) {
+			tun.Log.Warn("invalid parallel transfer message", "reason", err)
+			tun.Abort("parallel transfer protocol violation")
+			continue
+		}
+		p.lock.Lock()
+		switch kind {
+		case parallelData:
+			// Throttle the stripe while too far ahead of the reader
+			for p.bound && seq >= p.next+uint64(parallelReorderLimit) && !p.closed && p.err == nil {
+				p.cond.Wait()
+			}
+			if _, dup := p.segs[seq]; !dup && seq >= p.next {
+				p.segs[seq] = data
+				p.have++
+			}
+		case parallelEnd:
+			p.total, p.ended = seq, true
+		}
+		complete := p.ended && p.have == p.total && !p.done
+		if complete {
+			p.done = true
+		}
+		p.cond.Broadcast()
+		p.lock.Unlock()
+
+		if complete {
+			p.conn.tunPara.Delete(p.id)
+			p.leader.Send(encodeParallel(parallelDone, 0, nil), 0)
+		}
+	}
+}
+
+// Reads the next bytes of the reassembled stream, blocking until they arrive.
+func (p *ParallelReader) Read(b []byte) (int, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for {
+		switch {
+		case len(p.cur) > 0:
+			n := copy(b, p.cur)
+			p.cur = p.cur[n:]
+			return n, nil
+		case p.segs[p.next] != nil:
+			p.cur = p.segs[p.next]
+			delete(p.segs, p.next)
+			p.next++
+			p.cond.Broadcast()
+		case p.ended && p.next == p.total:
+			return 0, io.EOF
+		case p.closed:
+			return 0, ErrClosed
+		case p.err != nil:
+			return 0, p.err
+		default:
+			p.cond.Wait()
+		}
+	}
+}
+
+// Closes all the tunnels of the transfer, aborting it if not yet complete.
+func (p *ParallelReader) Close() error {
+	p.lock.Lock()
+	p.closed = true
+	stripes := p.stripes
+	p.cond.Broadcast()
+	p.lock.Unlock()
+
+	p.conn.tunPara.Delete(p.id)
+	for _, tun := range stripes {
+		tun.Close()
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"testing"
+	"time"
+)
+
+// Service handler reassembling the parallel transfers it receives.
+type parallelTestHandler struct {
+	streams chan []byte
+}
+
+func (h *parallelTestHandler) Init(conn *Connection) error              { return nil }
+func (h *parallelTestHandler) HandleBroadcast(msg []byte)               { panic("not implemented") }
+func (h *parallelTestHandler) HandleRequest(req []byte) ([]byte, error) { panic("not implemented") }
+func (h *parallelTestHandler) HandleDrop(reason error)                  { panic("not implemented") }
+
+func (h *parallelTestHandler) HandleTunnel(tun *Tunnel) {
+	reader, err := ReceiveParallel(tun)
+	if err != nil {
+		panic(err)
+	}
+	defer reader.Close()
+
+	stream, err := io.ReadAll(reader)
+	if err != nil {
+		panic(err)
+	}
+	h.streams <- stream
+}
+
+// Tests that parallel transfers are reassembled in order, even if some stripes
+// land on other members of the cluster.
+func TestParallelTransfer(t *testing.T) {
+	// Register two members of the same cluster
+	handler := &parallelTestHandler{streams: make(chan []byte, 2)}
+	for i := 0; i < 2; i++ {
+		serv, err := Register(config.relay, config.cluster, handler, nil)
+		if err != nil {
+			t.Fatalf("registration failed: %v.", err)
+		}
+		defer serv.Unregister()
+	}
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Transfer a few streams and ensure they arrive intact
+	for _, size := range []int{0, 1, 4096, 100*1024 + 7} {
+		data := make([]byte, size)
+		rand.Read(data)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := SendParallel(ctx, conn, config.cluster, bytes.NewReader(data), &ParallelOptions{Stripes: 4, Segment: 1000, Timeout: time.Second})
+		cancel()
+		if err != nil {
+			t.Fatalf("size %d: transfer failed: %v.", size, err)
+		}
+		select {
+		case stream := <-handler.streams:
+			if !bytes.Equal(stream, data) {
+				t.Fatalf("size %d: stream mismatch: have %d bytes, want %d.", size, len(stream), size)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("size %d: stream not reassembled.", size)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the table of the outstanding outbound requests.
+//
+// With tens of thousands of requests in flight on a single connection, a lone
+// map and mutex tracking them becomes a contention point between the callers
+// registering requests and the network receiver delivering the replies. The
+// table is instead split into independently locked stripes, picked by the low
+// bits of the sequentially assigned request ids, so concurrent requests almost
+// always land on different locks.
+
+package iris
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Number of independently locked stripes of the pending request table.
+const pendingStripes = 64
+
+// Result channels of an outstanding request.
+type pendingRequest struct {
+	reply chan []byte // Channel receiving the reply of the request
+	fault chan error  // Channel receiving the failure of the request
+}
+
+// Single stripe of the pending request table.
+type pendingStripe struct {
+	reqs map[uint64]*pendingRequest // Outstanding requests within the stripe
+	lock sync.Mutex                 // Protects the stripe's requests
+	_    [48]byte                   // Padding to keep the locks on separate cache lines
+}
+
+// Striped table of the outstanding requests, keyed by request id.
+type pendingTable struct {
+	next    uint64                        // Id to assign to the next request
+	stripes [pendingStripes]pendingStripe // Independently locked request subsets
+}
+
+// Creates a new, empty pending request table.
+func newPendingTable() *pendingTable {
+	table := new(pendingTable)
+	for i := range table.stripes {
+		table.stripes[i].reqs = make(map[uint64]*pendingRequest)
+	}
+	return table
+}
+
+// Registers a new outstanding request, returning its id and result channels.
+func (t *pendingTable) add() (uint64, *pendingRequest) {
+	id := atomic.AddUint64(&t.next, 1) - 1
+	req := &pendingRequest{
+		reply: make(chan []byte, 1),
+		fault: make(chan error, 1),
+	}
+	stripe := &t.stripes[id%pendingStripes]
+
+	stripe.lock.Lock()
+	stripe.reqs[id] = req
+	stripe.lock.Unlock()
+
+	return id, req
+}
+
+// Removes an outstanding request from the table, returning it if it was still
+// pending. Only the first removal succeeds, so at most one result is delivered
+// to the buffered result channels, which never block.
+func (t *pendingTable) remove(id uint64) (*pendingRequest, bool) {
+	stripe := &t.stripes[id%pendingStripes]
+
+	stripe.lock.Lock()
+	defer stripe.lock.Unlock()
+
+	req, ok := stripe.reqs[id]
+	if ok {
+		delete(stripe.reqs, id)
+	}
+	return req, ok
+}
+
+// Counts the outstanding requests in the table.
+func (t *pendingTable) len() int {
+	count := 0
+	for i := range t.stripes {
+		stripe := &t.stripes[i]
+
+		stripe.lock.Lock()
+		count += len(stripe.reqs)
+		stripe.lock.Unlock()
+	}
+	return count
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"sync"
+	"testing"
+)
+
+// Tests that requests can be concurrently registered and removed from the
+// pending table, each exactly once.
+func TestPendingTable(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		threads  int
+		requests int
+	}{16, 1000}
+
+	table := newPendingTable()
+
+	ids := make(chan uint64, conf.threads*conf.requests)
+	var pend sync.WaitGroup
+	for i := 0; i < conf.threads; i++ {
+		pend.Add(1)
+		go func() {
+			defer pend.Done()
+			for j := 0; j < conf.requests; j++ {
+				id, _ := table.add()
+				ids <- id
+			}
+		}()
+	}
+	pend.Wait()
+	close(ids)
+
+	if n := table.len(); n != conf.threads*conf.requests {
+		t.Fatalf("pending count mismatch: have %d, want %d.", n, conf.threads*conf.requests)
+	}
+	for id := range ids {
+		if _, ok := table.remove(id); !ok {
+			t.Fatalf("request %d not pending.", id)
+		}
+		if _, ok := table.remove(id); ok {
+			t.Fatalf("request %d removed twice.", id)
+		}
+	}
+	if n := table.len(); n != 0 {
+		t.Fatalf("pending count mismatch: have %d, want %d.", n, 0)
+	}
+}
+
+// Benchmarks the registration and removal of concurrent requests.
+func BenchmarkPendingTable(b *testing.B) {
+	table := newPendingTable()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			id, _ := table.add()
+			table.remove(id)
+		}
+	})
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+//go:build windows
+// +build windows
+
+// Contains the Windows named pipe transport, for deployments where loopback TCP
+// is restricted by policy. Pipes are selected with a pipe://name endpoint, the
+// name being either a full pipe path or relative to \\.\pipe\.
+
+package iris
+
+import (
+	"context"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+func init() {
+	RegisterTransport("pipe", dialPipe)
+}
+
+// Error reported while all instances of a named pipe are busy.
+const errPipeBusy = syscall.Errno(231)
+
+// Interval between attempts to connect to a busy named pipe.
+var pipeBusyRetry = 10 * time.Millisecond
+
+// Address of a named pipe endpoint.
+type pipeAddr string
+
+func (a pipeAddr) Network() string { return "pipe" }
+func (a pipeAddr) String() string  { return string(a) }
+
+// Named pipe client end, wrapped into a network connection. The handle is opened
+// for overlapped I/O, so the file is driven through the runtime poller, allowing
+// concurrent reads and writes, as well as deadlines.
+type pipeConn struct {
+	*os.File
+	addr pipeAddr
+}
+
+func (c *pipeConn) LocalAddr() net.Addr  { return c.addr }
+func (c *pipeConn) RemoteAddr() net.Addr { return c.addr }
+
+// Opens the client end of a relay named pipe, waiting for a free instance until
+// the context is done.
+func dialPipe(ctx context.Context, name string) (net.Conn, error) {
+	path := name
+	if !strings.HasPrefix(path, `\\`) {
+		path = `\\.\pipe\` + path
+	}
+	path16, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		handle, err := syscall.CreateFile(path16, syscall.GENERIC_READ|syscall.GENERIC_WRITE, 0, nil, syscall.OPEN_EXISTING, syscall.FILE_FLAG_OVERLAPPED, 0)
+		if err == nil {
+			return &pipeConn{File: os.NewFile(uintptr(handle), path), addr: pipeAddr(path)}, nil
+		}
+		if err != errPipeBusy {
+			return nil, &os.PathError{Op: "open", Path: path, Err: err}
+		}
+		// All pipe instances are busy, retry a bit later
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(pipeBusyRetry):
+		}
+	}
+}
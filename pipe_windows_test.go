@@ -0,0 +1,122 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+//go:build windows
+// +build windows
+
+package iris
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+var (
+	kernel32             = syscall.NewLazyDLL("kernel32.dll")
+	procCreateNamedPipe  = kernel32.NewProc("CreateNamedPipeW")
+	procConnectNamedPipe = kernel32.NewProc("ConnectNamedPipe")
+)
+
+// Creates the server end of a single instance, byte mode named pipe.
+func listenTestPipe(path string) (syscall.Handle, error) {
+	path16, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return syscall.InvalidHandle, err
+	}
+	// PIPE_ACCESS_DUPLEX, PIPE_TYPE_BYTE | PIPE_READMODE_BYTE | PIPE_WAIT, one instance
+	handle, _, err := procCreateNamedPipe.Call(uintptr(unsafe.Pointer(path16)), 0x3, 0x0, 1, 4096, 4096, 0, 0)
+	if syscall.Handle(handle) == syscall.InvalidHandle {
+		return syscall.InvalidHandle, err
+	}
+	return syscall.Handle(handle), nil
+}
+
+// Waits for a client to connect to the server end of a named pipe.
+func acceptTestPipe(handle syscall.Handle) error {
+	if ok, _, err := procConnectNamedPipe.Call(uintptr(handle), 0); ok == 0 && err != syscall.Errno(535) { // ERROR_PIPE_CONNECTED
+		return err
+	}
+	return nil
+}
+
+// Tests that named pipe links can read and write concurrently, honor deadlines
+// and wait for busy pipes only until the context is done.
+func TestPipeTransport(t *testing.T) {
+	name := fmt.Sprintf("iris-test-%d", os.Getpid())
+
+	// Start an echo server on a single instance pipe
+	handle, err := listenTestPipe(`\\.\pipe\` + name)
+	if err != nil {
+		t.Fatalf("pipe creation failed: %v.", err)
+	}
+	server := os.NewFile(uintptr(handle), name)
+	defer server.Close()
+
+	go func() {
+		if err := acceptTestPipe(handle); err != nil {
+			return
+		}
+		io.Copy(server, server)
+	}()
+	conn, err := dialPipe(context.Background(), name)
+	if err != nil {
+		t.Fatalf("pipe dial failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Block a reader on the idle link and ensure writes go through meanwhile
+	replies := make(chan []byte, 1)
+	go func() {
+		reply := make([]byte, 4)
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			reply = nil
+		}
+		replies <- reply
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	written := make(chan error, 1)
+	go func() {
+		_, err := conn.Write([]byte("ping"))
+		written <- err
+	}()
+	select {
+	case err := <-written:
+		if err != nil {
+			t.Fatalf("pipe write failed: %v.", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("pipe write blocked behind pending read.")
+	}
+	select {
+	case reply := <-replies:
+		if !bytes.Equal(reply, []byte("ping")) {
+			t.Fatalf("reply mismatch: have %q, want %q.", reply, "ping")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("pipe read timed out.")
+	}
+	// Ensure read deadlines are honored
+	conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := conn.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("deadline error mismatch: have %v, want %v.", err, os.ErrDeadlineExceeded)
+	}
+	// Ensure dialing the busy pipe gives up with the context
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	if _, err := dialPipe(ctx, name); err != context.DeadlineExceeded {
+		t.Fatalf("busy dial error mismatch: have %v, want %v.", err, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Package pipeline contains chained service calls, passing a payload through an
+// ordered list of clusters, each transforming it via a request, with the reply
+// of one stage becoming the request of the next.
+//
+// Every stage has its own timeout and failure policy: a failing stage may abort
+// the whole pipeline, be skipped (passing its input on unchanged), or be retried
+// a number of times before aborting.
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gopkg.in/project-iris/iris-go.v1"
+)
+
+// Reaction of a pipeline to a failing stage.
+type Policy int
+
+const (
+	Abort Policy = iota // Fail the pipeline with the stage's error
+	Skip                // Pass the stage's input on to the next stage unchanged
+	Retry               // Reissue the stage's request, aborting once out of retries
+)
+
+// Single step of a pipeline.
+type Stage struct {
+	Cluster string        // Cluster transforming the payload
+	Timeout time.Duration // Timeout of a single request (zero needs connection wide defaults)
+	OnError Policy        // Reaction to a failing request
+	Retries int           // Number of reissues with the Retry policy
+}
+
+// Failure of a pipeline stage, aborting the pipeline.
+type StageError struct {
+	Stage   int    // Index of the failed stage
+	Cluster string // Cluster of the failed stage
+	Err     error  // Failure of the last request of the stage
+}
+
+func (e *StageError) Error() string {
+	return fmt.Sprintf("stage %d (%s): %v", e.Stage, e.Cluster, e.Err)
+}
+
+// Retrieves the failure wrapped by the error.
+func (e *StageError) Unwrap() error {
+	return e.Err
+}
+
+// Ordered list of clusters to pass payloads through.
+type Pipeline struct {
+	conn   *iris.Connection // Connection to issue the stage requests through
+	stages []Stage          // Stages to pass the payloads through
+}
+
+// Creates a pipeline passing payloads through the stages, in order.
+func New(conn *iris.Connection, stages ...Stage) (*Pipeline, error) {
+	if len(stages) == 0 {
+		return nil, errors.New("empty pipeline")
+	}
+	for i, stage := range stages {
+		if len(stage.Cluster) == 0 {
+			return nil, fmt.Errorf("stage %d: empty cluster identifier", i)
+		}
+		if stage.Retries < 0 {
+			return nil, fmt.Errorf("stage %d: negative retry count %d", i, stage.Retries)
+		}
+	}
+	return &Pipeline{
+		conn:   conn,
+		stages: append([]Stage(nil), stages...),
+	}, nil
+}
+
+// Passes a payload through all the stages, returning the reply of the last one.
+// The context aborts the pipeline between or during the stage requests.
+func (p *Pipeline) Run(ctx context.Context, payload []byte) ([]byte, error) {
+	for i, stage := range p.stages {
+		reply, err := p.run(ctx, stage, payload)
+		if err != nil {
+			if stage.OnError == Skip && ctx.Err() == nil {
+				continue
+			}
+			return nil, &StageError{Stage: i, Cluster: stage.Cluster, Err: err}
+		}
+		payload = reply
+	}
+	return payload, nil
+}
+
+// Executes a single stage, retrying it if requested.
+func (p *Pipeline) run(ctx context.Context, stage Stage, payload []byte) ([]byte, error) {
+	attempts := 1
+	if stage.OnError == Retry {
+		attempts += stage.Retries
+	}
+	var err error
+	for i := 0; i < attempts; i++ {
+		var reply []byte
+		if reply, err = p.conn.RequestContext(ctx, stage.Cluster, payload, stage.Timeout); err == nil {
+			return reply, nil
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gopkg.in/project-iris/iris-go.v1"
+)
+
+// Port of the local relay to run the tests against.
+var relay = 55555
+
+// Service handler transforming requests, failing the first few of them.
+type stageHandler struct {
+	transform func([]byte) []byte
+	failures  int32
+}
+
+func (s *stageHandler) Init(conn *iris.Connection) error { return nil }
+func (s *stageHandler) HandleBroadcast(msg []byte)       { panic("not implemented") }
+func (s *stageHandler) HandleTunnel(tun *iris.Tunnel)    { panic("not implemented") }
+func (s *stageHandler) HandleDrop(reason error)          { panic("not implemented") }
+
+func (s *stageHandler) HandleRequest(req []byte) ([]byte, error) {
+	if atomic.AddInt32(&s.failures, -1) >= 0 {
+		return nil, errors.New("transient failure")
+	}
+	return s.transform(req), nil
+}
+
+// Registers a stage service into a cluster, failing the first requests.
+func register(t *testing.T, cluster string, failures int32, transform func([]byte) []byte) *iris.Service {
+	serv, err := iris.Register(relay, cluster, &stageHandler{transform, failures}, nil)
+	if err != nil {
+		t.Fatalf("registration of %s failed: %v.", cluster, err)
+	}
+	return serv
+}
+
+// Tests that payloads are passed through the stages in order, with the failure
+// policies applied.
+func TestPipeline(t *testing.T) {
+	upper := register(t, "pipeline-upper", 0, bytes.ToUpper)
+	defer upper.Unregister()
+
+	flaky := register(t, "pipeline-flaky", 2, func(data []byte) []byte { return append(data, '!') })
+	defer flaky.Unregister()
+
+	broken := register(t, "pipeline-broken", 1<<30, nil)
+	defer broken.Unregister()
+
+	conn, err := iris.Connect(relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Run a pipeline skipping the broken stage and retrying the flaky one
+	pipe, err := New(conn,
+		Stage{Cluster: "pipeline-upper", Timeout: time.Second},
+		Stage{Cluster: "pipeline-broken", Timeout: time.Second, OnError: Skip},
+		Stage{Cluster: "pipeline-flaky", Timeout: time.Second, OnError: Retry, Retries: 2},
+	)
+	if err != nil {
+		t.Fatalf("pipeline creation failed: %v.", err)
+	}
+	if out, err := pipe.Run(context.Background(), []byte("payload")); err != nil {
+		t.Fatalf("pipeline failed: %v.", err)
+	} else if !bytes.Equal(out, []byte("PAYLOAD!")) {
+		t.Fatalf("output mismatch: have %s, want %s.", out, "PAYLOAD!")
+	}
+	// Run a pipeline aborting on the broken stage
+	pipe, err = New(conn, Stage{Cluster: "pipeline-upper", Timeout: time.Second}, Stage{Cluster: "pipeline-broken", Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("pipeline creation failed: %v.", err)
+	}
+	_, err = pipe.Run(context.Background(), []byte("payload"))
+
+	var stage *StageError
+	if !errors.As(err, &stage) || stage.Stage != 1 || stage.Cluster != "pipeline-broken" {
+		t.Fatalf("stage failure mismatch: have %v.", err)
+	}
+}
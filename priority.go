@@ -0,0 +1,88 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the prioritization of the inbound message handling.
+//
+// The broadcast and request pools limit their own concurrency, but otherwise
+// compete freely for the CPU, so a broadcast storm can still push up the RPC
+// latencies. With priorities enabled, every handler additionally needs one of
+// a shared set of slots to run, which are granted to the message kinds waiting
+// for them in proportion to their weights. Tunnel chunks, otherwise assembled
+// inline by the network receiver, are then processed through the same slots,
+// still one by one within each tunnel.
+
+package iris
+
+import (
+	"runtime"
+	"strconv"
+
+	"gopkg.in/project-iris/iris-go.v1/internal/pool"
+)
+
+// Relative weights of the inbound message kinds when competing for the handler
+// threads. A kind with twice the weight of another is granted twice as many of
+// the freed up threads while both are backlogged. Non-positive weights count
+// as one.
+type Priorities struct {
+	Threads    int // Handlers of any kind to execute concurrently (defaults to 4 * CPUs)
+	Requests   int // Weight of the request handlers
+	Tunnels    int // Weight of the tunnel chunk processing
+	Broadcasts int // Weight of the broadcast handlers
+}
+
+// Message kinds competing for the prioritized handler slots.
+const (
+	prioRequest = iota
+	prioTunnel
+	prioBroadcast
+)
+
+// Creates the shared handler slots of the configured priorities, or nil if
+// prioritization is disabled.
+func newPriorityGate(prio *Priorities) *pool.Gate {
+	if prio == nil {
+		return nil
+	}
+	threads := prio.Threads
+	if threads <= 0 {
+		threads = 4 * runtime.NumCPU()
+	}
+	return pool.NewGate(threads, prio.Requests, prio.Tunnels, prio.Broadcasts)
+}
+
+// Wraps a handler task to only run after acquiring a slot of its message kind.
+// If prioritization is disabled, the task is returned unmodified.
+func (c *Connection) prioritize(kind int, task func()) func() {
+	if c.prio == nil {
+		return task
+	}
+	return func() {
+		c.prio.Enter(kind)
+		defer c.prio.Leave()
+
+		task()
+	}
+}
+
+// Schedules the processing of an inbound tunnel chunk through the prioritized
+// slots, keeping the chunks of the same tunnel in arrival order.
+func (c *Connection) scheduleTransfer(tun *Tunnel, size int, chunk []byte) {
+	chunk = own(chunk)
+	run := func(task pool.Task) error {
+		go task()
+		return nil
+	}
+	c.tunKeys.schedule(run, strconv.FormatUint(tun.id, 10), c.prioritize(prioTunnel, func() {
+		// Drop the chunks of tunnels torn down while queued
+		select {
+		case <-tun.term:
+			return
+		default:
+		}
+		tun.handleTransfer(size, chunk)
+	}))
+}
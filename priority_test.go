@@ -0,0 +1,64 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"testing"
+	"time"
+)
+
+// Service handler with slow broadcasts and echoing requests and tunnels.
+type priorityTestHandler struct {
+	echoHandler
+}
+
+func (p priorityTestHandler) HandleBroadcast(msg []byte) { time.Sleep(20 * time.Millisecond) }
+
+// Tests that prioritized requests and tunnels overtake a broadcast backlog.
+func TestPriorities(t *testing.T) {
+	options := &Options{
+		Priorities: &Priorities{Threads: 1, Requests: 16, Tunnels: 4, Broadcasts: 1},
+	}
+	serv, err := RegisterWithOptions(config.relay, config.cluster, priorityTestHandler{}, nil, options)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Flood the service with a second worth of broadcasts
+	for i := 0; i < 50; i++ {
+		if err := conn.Broadcast(config.cluster, []byte{byte(i)}); err != nil {
+			t.Fatalf("broadcast %d failed: %v.", i, err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	// Verify that requests and tunnel messages don't wait out the backlog
+	if reply, err := conn.Request(config.cluster, []byte("ping"), 250*time.Millisecond); err != nil || string(reply) != "ping" {
+		t.Fatalf("request mismatch: have %q/%v, want %q.", reply, err, "ping")
+	}
+	tun, err := conn.Tunnel(config.cluster, 250*time.Millisecond)
+	if err != nil {
+		t.Fatalf("tunnel construction failed: %v.", err)
+	}
+	defer tun.Close()
+
+	for i := 0; i < 3; i++ {
+		if err := tun.Send([]byte("ping"), 250*time.Millisecond); err != nil {
+			t.Fatalf("failed to send message: %v.", err)
+		}
+		if msg, err := tun.Recv(250 * time.Millisecond); err != nil || string(msg) != "ping" {
+			t.Fatalf("echo mismatch: have %q/%v, want %q.", msg, err, "ping")
+		}
+	}
+}
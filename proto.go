@@ -8,88 +8,35 @@
 
 // The specification version implemented is v1.0-draft2, available at:
 // http://iris.karalabe.com/specs/relay-protocol-v1.0-draft2.pdf
+//
+// The frame encoding itself lives in the relaywire package, shared with other
+// bindings and relay doubles.
 
 package iris
 
 import (
 	"fmt"
-	"io"
+	"strings"
 	"sync/atomic"
 	"time"
-)
 
-// Packet opcodes
-const (
-	opInit  byte = 0x00 // Out: connection initiation           | In: connection acceptance
-	opDeny       = 0x01 // Out: <never sent>                    | In: connection refusal
-	opClose      = 0x02 // Out: connection tear-down initiation | In: connection tear-down notification
-
-	opBroadcast = 0x03 // Out: application broadcast initiation | In: application broadcast delivery
-	opRequest   = 0x04 // Out: application request initiation   | In: application request delivery
-	opReply     = 0x05 // Out: application reply initiation     | In: application reply delivery
-
-	opSubscribe   = 0x06 // Out: topic subscription             | In: <never received>
-	opUnsubscribe = 0x07 // Out: topic subscription removal     | In: <never received>
-	opPublish     = 0x08 // Out: topic event publish            | In: topic event delivery
-
-	opTunInit     = 0x09 // Out: tunnel construction request    | In: tunnel initiation
-	opTunConfirm  = 0x0a // Out: tunnel confirmation            | In: tunnel construction result
-	opTunAllow    = 0x0b // Out: tunnel transfer allowance      | In: <same as out>
-	opTunTransfer = 0x0c // Out: tunnel data exchange           | In: <same as out>
-	opTunClose    = 0x0d // Out: tunnel termination request     | In: tunnel termination notification
+	"gopkg.in/project-iris/iris-go.v1/relaywire"
 )
 
 // Protocol constants
 var (
-	protoVersion = "v1.0-draft2"
-	clientMagic  = "iris-client-magic"
-	relayMagic   = "iris-relay-magic"
+	protoVersion = relaywire.ProtoVersion
+	clientMagic  = relaywire.ClientMagic
 )
 
-// Serializes a single byte into the relay connection.
-func (c *Connection) sendByte(data byte) error {
-	return c.sockBuf.WriteByte(data)
-}
-
-// Serializes a boolean into the relay connection.
-func (c *Connection) sendBool(data bool) error {
-	if data {
-		return c.sendByte(1)
-	}
-	return c.sendByte(0)
-}
-
-// Serializes a variable int using base 128 encoding into the relay connection.
-func (c *Connection) sendVarint(data uint64) error {
-	for data > 127 {
-		// Internal byte, set the continuation flag and send
-		if err := c.sendByte(byte(128 + data%128)); err != nil {
-			return err
-		}
-		data /= 128
-	}
-	// Final byte, send and return
-	return c.sendByte(byte(data))
-}
-
-// Serializes a length-tagged binary array into the relay connection.
-func (c *Connection) sendBinary(data []byte) error {
-	if err := c.sendVarint(uint64(len(data))); err != nil {
-		return err
-	}
-	if _, err := c.sockBuf.Write([]byte(data)); err != nil {
-		return err
-	}
-	return nil
-}
-
-// Serializes a length-tagged string into the relay connection.
-func (c *Connection) sendString(data string) error {
-	return c.sendBinary([]byte(data))
-}
+// Well known prefixes of the connection denial reasons.
+var (
+	authDenyPrefix    = "authentication failed: "
+	versionDenyPrefix = "unsupported protocol version"
+)
 
-// Serializes a packet through a closure into the relay connection.
-func (c *Connection) sendPacket(closure func() error) error {
+// Serializes a data frame into the relay connection.
+func (c *Connection) sendPacket(frame relaywire.Frame) error {
 	// Increment the pending write count
 	atomic.AddInt32(&c.sockWait, 1)
 
@@ -97,8 +44,9 @@ func (c *Connection) sendPacket(closure func() error) error {
 	c.sockLock.Lock()
 	defer c.sockLock.Unlock()
 
-	// Send the packet itself
-	if err := closure(); err != nil {
+	// Send the packet itself, through the current socket
+	c.sockEnc.Reset(c.sockBuf)
+	if err := relaywire.WriteFrame(c.sockEnc, frame); err != nil {
 		// Decrement the pending count and error out
 		atomic.AddInt32(&c.sockWait, -1)
 		return err
@@ -110,497 +58,230 @@ func (c *Connection) sendPacket(closure func() error) error {
 	return nil
 }
 
-// Sends a connection initiation.
-func (c *Connection) sendInit(cluster string) error {
-	return c.sendPacket(func() error {
-		if err := c.sendByte(opInit); err != nil {
-			return err
-		}
-		if err := c.sendString(clientMagic); err != nil {
-			return err
-		}
-		if err := c.sendString(protoVersion); err != nil {
-			return err
-		}
-		return c.sendString(cluster)
+// Serializes a control frame into the relay connection, ahead of any waiting
+// data frames, flushing it out immediately.
+func (c *Connection) sendControl(frame relaywire.Frame) error {
+	c.sockLock.LockUrgent()
+	defer c.sockLock.Unlock()
+
+	c.sockEnc.Reset(c.sockBuf)
+	if err := relaywire.WriteFrame(c.sockEnc, frame); err != nil {
+		return err
+	}
+	return c.sockBuf.Flush()
+}
+
+// Sends a connection initiation, requesting the given extension features. If
+// authentication is requested, the credentials follow the cluster name.
+func (c *Connection) sendInit(cluster string, features []string, kind string, credential []byte) error {
+	return c.sendPacket(&relaywire.Init{
+		Version:    versionTag(features),
+		Cluster:    cluster,
+		Kind:       kind,
+		Credential: credential,
 	})
 }
 
 // Sends a connection tear-down initiation.
 func (c *Connection) sendClose() error {
-	return c.sendPacket(func() error {
-		return c.sendByte(opClose)
-	})
+	return c.sendPacket(&relaywire.Close{})
 }
 
 // Sends an application broadcast initiation.
 func (c *Connection) sendBroadcast(cluster string, message []byte) error {
-	return c.sendPacket(func() error {
-		if err := c.sendByte(opBroadcast); err != nil {
-			return err
-		}
-		if err := c.sendString(cluster); err != nil {
-			return err
-		}
-		return c.sendBinary(message)
-	})
+	return c.lane(cluster).sendPacket(&relaywire.Broadcast{Cluster: cluster, Message: message})
 }
 
 // Sends an application request initiation.
 func (c *Connection) sendRequest(id uint64, cluster string, request []byte, timeout int) error {
-	return c.sendPacket(func() error {
-		if err := c.sendByte(opRequest); err != nil {
-			return err
-		}
-		if err := c.sendVarint(id); err != nil {
-			return err
-		}
-		if err := c.sendString(cluster); err != nil {
-			return err
-		}
-		if err := c.sendBinary(request); err != nil {
-			return err
-		}
-		return c.sendVarint(uint64(timeout))
-	})
+	return c.sendPacket(&relaywire.Request{ID: id, Cluster: cluster, Request: request, Timeout: uint64(timeout)})
 }
 
 // Sends an application reply initiation.
 func (c *Connection) sendReply(id uint64, reply []byte, fault string) error {
-	return c.sendPacket(func() error {
-		if err := c.sendByte(opReply); err != nil {
-			return err
-		}
-		if err := c.sendVarint(id); err != nil {
-			return err
-		}
-		success := (len(fault) == 0)
-		if err := c.sendBool(success); err != nil {
-			return err
-		}
-		if success {
-			return c.sendBinary(reply)
-		} else {
-			return c.sendString(fault)
-		}
-	})
+	return c.sendPacket(&relaywire.Reply{ID: id, Reply: reply, Fault: fault})
 }
 
 // Sends a topic subscription.
 func (c *Connection) sendSubscribe(topic string) error {
-	return c.sendPacket(func() error {
-		if err := c.sendByte(opSubscribe); err != nil {
-			return err
-		}
-		return c.sendString(topic)
-	})
+	return c.sendControl(&relaywire.Subscribe{Topic: topic})
 }
 
 // Sends a topic subscription removal.
 func (c *Connection) sendUnsubscribe(topic string) error {
-	return c.sendPacket(func() error {
-		if err := c.sendByte(opUnsubscribe); err != nil {
-			return err
-		}
-		return c.sendString(topic)
-	})
+	return c.sendControl(&relaywire.Unsubscribe{Topic: topic})
 }
 
 // Sends a topic event publish.
 func (c *Connection) sendPublish(topic string, event []byte) error {
-	return c.sendPacket(func() error {
-		if err := c.sendByte(opPublish); err != nil {
-			return err
-		}
-		if err := c.sendString(topic); err != nil {
-			return err
-		}
-		return c.sendBinary(event)
-	})
+	return c.lane(topic).sendPacket(&relaywire.Publish{Topic: topic, Event: event})
+}
+
+// Sends a topic event publish to be acknowledged by the relay.
+func (c *Connection) sendPublishConfirm(id uint64, topic string, event []byte) error {
+	return c.sendPacket(&relaywire.PublishConfirm{ID: id, Topic: topic, Event: event})
 }
 
 // Sends a tunnel construction request.
 func (c *Connection) sendTunnelInit(id uint64, cluster string, timeout int) error {
-	return c.sendPacket(func() error {
-		if err := c.sendByte(opTunInit); err != nil {
-			return err
-		}
-		if err := c.sendVarint(id); err != nil {
-			return err
-		}
-		if err := c.sendString(cluster); err != nil {
-			return err
-		}
-		return c.sendVarint(uint64(timeout))
-	})
+	return c.sendPacket(&relaywire.TunnelInit{ID: id, Cluster: cluster, Timeout: uint64(timeout)})
+}
+
+// Sends a tunnel construction request carrying an application header.
+func (c *Connection) sendTunnelInitHeader(id uint64, cluster string, timeout int, header []byte) error {
+	return c.sendPacket(&relaywire.TunnelInitHeader{ID: id, Cluster: cluster, Timeout: uint64(timeout), Header: header})
 }
 
 // Sends a tunnel confirmation.
 func (c *Connection) sendTunnelConfirm(buildId, tunId uint64) error {
-	return c.sendPacket(func() error {
-		if err := c.sendByte(opTunConfirm); err != nil {
-			return err
-		}
-		if err := c.sendVarint(buildId); err != nil {
-			return err
-		}
-		return c.sendVarint(tunId)
-	})
+	return c.sendControl(&relaywire.TunnelConfirm{BuildID: buildId, ID: tunId})
 }
 
 // Sends a tunnel transfer allowance.
 func (c *Connection) sendTunnelAllowance(id uint64, space int) error {
-	return c.sendPacket(func() error {
-		if err := c.sendByte(opTunAllow); err != nil {
-			return err
-		}
-		if err := c.sendVarint(id); err != nil {
-			return err
-		}
-		return c.sendVarint(uint64(space))
-	})
+	return c.sendControl(&relaywire.TunnelAllow{ID: id, Space: uint64(space)})
 }
 
 // Sends a tunnel data exchange.
 func (c *Connection) sendTunnelTransfer(id uint64, sizeOrCont int, payload []byte) error {
-	return c.sendPacket(func() error {
-		if err := c.sendByte(opTunTransfer); err != nil {
-			return err
-		}
-		if err := c.sendVarint(id); err != nil {
-			return err
-		}
-		if err := c.sendVarint(uint64(sizeOrCont)); err != nil {
-			return err
-		}
-		return c.sendBinary(payload)
-	})
+	return c.sendPacket(&relaywire.TunnelTransfer{ID: id, Size: uint64(sizeOrCont), Payload: payload})
 }
 
 // Sends a tunnel termination request.
 func (c *Connection) sendTunnelClose(id uint64) error {
-	return c.sendPacket(func() error {
-		if err := c.sendByte(opTunClose); err != nil {
-			return err
-		}
-		return c.sendVarint(id)
-	})
+	return c.sendControl(&relaywire.TunnelClose{ID: id})
 }
 
-// Retrieves a single byte from the relay connection.
-func (c *Connection) recvByte() (byte, error) {
-	return c.sockBuf.ReadByte()
+// Sends a tunnel termination request with the reason to notify the remote end of.
+func (c *Connection) sendTunnelAbort(id uint64, reason string) error {
+	return c.sendControl(&relaywire.TunnelAbort{ID: id, Reason: reason})
 }
 
-// Retrieves a boolean from the relay connection.
-func (c *Connection) recvBool() (bool, error) {
-	b, err := c.recvByte()
-	if err != nil {
-		return false, err
-	}
-	switch b {
-	case 0:
-		return false, nil
-	case 1:
-		return true, nil
-	default:
-		return false, fmt.Errorf("protocol violation: invalid boolean value: %v", b)
-	}
+// Retrieves the next frame from the relay connection. The frame and its binary
+// fields are only valid until the next receive, use own to retain them.
+func (c *Connection) recvPacket() (relaywire.Frame, error) {
+	c.sockDec.Reset(c.sockBuf)
+	return relaywire.ReadRelayFrame(c.sockDec)
 }
 
-// Retrieves a variable int in base 128 encoding from the relay connection.
-func (c *Connection) recvVarint() (uint64, error) {
-	var num uint64
-	for i := uint(0); ; i++ {
-		chunk, err := c.recvByte()
-		if err != nil {
-			return 0, err
-		}
-		num += uint64(chunk&127) << (7 * i)
-		if chunk <= 127 {
-			break
-		}
-	}
-	return num, nil
-}
-
-// Retrieves a length-tagged binary array from the relay connection.
-func (c *Connection) recvBinary() ([]byte, error) {
-	// Fetch the length of the binary blob
-	size, err := c.recvVarint()
-	if err != nil {
-		return nil, err
-	}
-	// Fetch the blob itself
-	data := make([]byte, size)
-	if _, err := io.ReadFull(c.sockBuf, data); err != nil {
-		return nil, err
-	}
-	return data, nil
-}
-
-// Retrieves a length-tagged string from the relay connection.
-func (c *Connection) recvString() (string, error) {
-	if data, err := c.recvBinary(); err != nil {
-		return "", err
-	} else {
-		return string(data), nil
-	}
+// Copies a binary field borrowed from the frame decoder, for handlers retaining
+// it beyond the next receive. Empty fields are kept non-nil.
+func own(blob []byte) []byte {
+	owned := make([]byte, len(blob))
+	copy(owned, blob)
+	return owned
 }
 
 // Retrieves a connection initiation response (either accept or deny).
 func (c *Connection) procInit() (string, error) {
-	// Retrieve the response opcode
-	op, err := c.recvByte()
+	frame, err := c.recvPacket()
 	if err != nil {
 		return "", err
 	}
-	// Verify the opcode validity and relay magic string
-	switch {
-	case op == opInit || op == opDeny:
-		if magic, err := c.recvString(); err != nil {
-			return "", err
-		} else if magic != relayMagic {
-			return "", fmt.Errorf("protocol violation: invalid relay magic: %s", magic)
-		}
-	default:
-		return "", fmt.Errorf("protocol violation: invalid init response opcode: %v", op)
-	}
 	// Depending on success or failure, proceed and return
-	switch op {
-	case opInit:
-		// Read the highest supported protocol version
-		if version, err := c.recvString(); err != nil {
-			return "", err
-		} else {
-			return version, nil
-		}
-	case opDeny:
-		// Read the reason for connection denial
-		if reason, err := c.recvString(); err != nil {
-			return "", err
-		} else {
-			return "", fmt.Errorf("connection denied: %s", reason)
-		}
+	switch frame := frame.(type) {
+	case *relaywire.InitAccept:
+		return frame.Version, nil
+	case *relaywire.InitDeny:
+		if strings.HasPrefix(frame.Reason, authDenyPrefix) {
+			return "", &AuthError{strings.TrimPrefix(frame.Reason, authDenyPrefix)}
+		} else if strings.HasPrefix(frame.Reason, versionDenyPrefix) {
+			return "", versionDeniedError(frame.Reason)
+		}
+		return "", fmt.Errorf("connection denied: %s", frame.Reason)
 	default:
-		panic("unreachable code")
-	}
-}
-
-// Retrieves a connection tear-down notification.
-func (c *Connection) procClose() (string, error) {
-	return c.recvString()
-}
-
-// Retrieves an application broadcast delivery.
-func (c *Connection) procBroadcast() error {
-	message, err := c.recvBinary()
-	if err != nil {
-		return err
+		return "", fmt.Errorf("protocol violation: invalid init response opcode: %v", frame.Opcode())
 	}
-	c.handleBroadcast(message)
-	return nil
 }
 
-// Retrieves an application request delivery.
-func (c *Connection) procRequest() error {
-	id, err := c.recvVarint()
-	if err != nil {
-		return err
-	}
-	request, err := c.recvBinary()
-	if err != nil {
-		return err
-	}
-	timeout, err := c.recvVarint()
-	if err != nil {
-		return err
-	}
-	c.handleRequest(id, request, time.Duration(timeout)*time.Millisecond)
-	return nil
-}
-
-// Retrieves an application reply delivery.
-func (c *Connection) procReply() error {
-	id, err := c.recvVarint()
-	if err != nil {
-		return err
-	}
-	timeout, err := c.recvBool()
-	if err != nil {
-		return err
-	}
-	if timeout {
-		c.handleReply(id, nil, "")
-		return nil
-	}
-	// The request didn't time out, get the result
-	success, err := c.recvBool()
-	if err != nil {
-		return err
-	}
-	if success {
-		reply, err := c.recvBinary()
-		if err != nil {
-			return err
-		}
-		c.handleReply(id, reply, "")
-	} else {
-		fault, err := c.recvString()
-		if err != nil {
-			return err
-		}
-		c.handleReply(id, nil, fault)
-	}
-	return nil
-}
-
-// Retrieves a topic event delivery.
-func (c *Connection) procPublish() error {
-	topic, err := c.recvString()
-	if err != nil {
-		return err
-	}
-	event, err := c.recvBinary()
-	if err != nil {
-		return err
-	}
-	go c.handlePublish(topic, event)
-	return nil
-}
-
-// Retrieves a tunnel initiation message.
-func (c *Connection) procTunnelInit() error {
-	id, err := c.recvVarint()
-	if err != nil {
-		return err
-	}
-	chunkLimit, err := c.recvVarint()
-	if err != nil {
-		return err
+// Forwards an application reply delivery.
+func (c *Connection) procReply(frame *relaywire.ReplyDelivery) {
+	switch {
+	case frame.Timeout:
+		c.handleReply(frame.ID, nil, "")
+	case len(frame.Fault) == 0:
+		c.handleReply(frame.ID, own(frame.Reply), "")
+	default:
+		c.handleReply(frame.ID, nil, frame.Fault)
 	}
-	c.handleTunnelInit(id, int(chunkLimit))
-	return nil
 }
 
-// Retrieves a tunnel construction result.
-func (c *Connection) procTunnelResult() error {
-	id, err := c.recvVarint()
-	if err != nil {
-		return err
-	}
-	timeout, err := c.recvBool()
-	if err != nil {
-		return err
-	}
-	if timeout {
-		c.handleTunnelResult(id, 0)
-		return nil
-	}
-	// The tunnel didn't time out, proceed
-	chunkLimit, err := c.recvVarint()
-	if err != nil {
-		return err
+// Forwards a tunnel construction result.
+func (c *Connection) procTunnelResult(frame *relaywire.TunnelResult) {
+	if frame.Timeout {
+		c.handleTunnelResult(frame.ID, 0)
+		return
 	}
-	c.handleTunnelResult(id, int(chunkLimit))
-	return nil
+	c.handleTunnelResult(frame.ID, int(frame.ChunkLimit))
 }
 
-// Retrieves a tunnel transfer allowance message.
-func (c *Connection) procTunnelAllowance() error {
-	id, err := c.recvVarint()
-	if err != nil {
-		return err
-	}
-	space, err := c.recvVarint()
-	if err != nil {
-		return err
+// Retrieves messages from the client connection and keeps processing them until
+// either the relay closes (graceful close) or the connection drops for good.
+func (c *Connection) process() {
+	err := c.serve()
+	for err != nil && c.reconnect(err) {
+		err = c.serve()
 	}
-	c.handleTunnelAllowance(id, int(space))
-	return nil
-}
+	// Close the socket and signal termination to all blocked threads
+	c.sock.Close()
+	c.termErr = err
+	close(c.term)
 
-// Retrieves a tunnel data exchange message.
-func (c *Connection) procTunnelTransfer() error {
-	id, err := c.recvVarint()
-	if err != nil {
-		return err
-	}
-	size, err := c.recvVarint()
-	if err != nil {
-		return err
-	}
-	payload, err := c.recvBinary()
-	if err != nil {
-		return err
-	}
-	c.handleTunnelTransfer(id, int(size), payload)
-	return nil
-}
+	// Notify the application of the connection closure
+	c.handleClose(err)
 
-// Retrieves a tunnel closure notification.
-func (c *Connection) procTunnelClose() error {
-	id, err := c.recvVarint()
-	if err != nil {
-		return err
-	}
-	reason, err := c.recvString()
-	if err != nil {
-		return err
-	}
-	go c.handleTunnelClose(id, reason)
-	return nil
+	// Wait for termination sync
+	errc := <-c.quit
+	errc <- err
 }
 
-// Retrieves messages from the client connection and keeps processing them until
-// either the relay closes (graceful close) or the connection drops.
-func (c *Connection) process() {
-	var op byte
+// Processes the messages arriving on the current relay link, returning nil on a
+// graceful close, or the failure that dropped the link.
+func (c *Connection) serve() error {
+	var frame relaywire.Frame
 	var err error
 	for closed := false; !closed && err == nil; {
-		// Retrieve the next opcode and call the specific handler for the rest
-		if op, err = c.recvByte(); err == nil {
-			switch op {
-			case opBroadcast:
-				err = c.procBroadcast()
-			case opRequest:
-				err = c.procRequest()
-			case opReply:
-				err = c.procReply()
-			case opPublish:
-				err = c.procPublish()
-			case opTunInit:
-				err = c.procTunnelInit()
-			case opTunConfirm:
-				err = c.procTunnelResult()
-			case opTunAllow:
-				err = c.procTunnelAllowance()
-			case opTunTransfer:
-				err = c.procTunnelTransfer()
-			case opTunClose:
-				err = c.procTunnelClose()
-			case opClose:
+		// Retrieve the next frame and call the specific handler for it
+		if frame, err = c.recvPacket(); err == nil {
+			switch frame := frame.(type) {
+			case *relaywire.BroadcastDelivery:
+				c.handleBroadcast(own(frame.Message))
+			case *relaywire.RequestDelivery:
+				c.handleRequest(frame.ID, own(frame.Request), time.Duration(frame.Timeout)*time.Millisecond)
+			case *relaywire.ReplyDelivery:
+				c.procReply(frame)
+			case *relaywire.PublishDelivery:
+				if _, ok := c.ordered.Load(frame.Topic); ok {
+					c.dispatchOrdered(frame.Topic, own(frame.Event))
+				} else {
+					go c.handlePublish(frame.Topic, own(frame.Event))
+				}
+			case *relaywire.PublishAck:
+				c.handlePublishAck(frame.ID, int(frame.Subscribers))
+			case *relaywire.TunnelInitDelivery:
+				c.handleTunnelInit(frame.ID, int(frame.ChunkLimit), nil)
+			case *relaywire.TunnelInitHeaderDelivery:
+				headers, _ := unwrapEnvelope(frame.Header)
+				c.handleTunnelInit(frame.ID, int(frame.ChunkLimit), headers)
+			case *relaywire.TunnelResult:
+				c.procTunnelResult(frame)
+			case *relaywire.TunnelAllow:
+				c.handleTunnelAllowance(frame.ID, int(frame.Space))
+			case *relaywire.TunnelTransfer:
+				c.handleTunnelTransfer(frame.ID, int(frame.Size), frame.Payload)
+			case *relaywire.TunnelCloseNotify:
+				go c.handleTunnelClose(frame.ID, frame.Reason)
+			case *relaywire.CloseNotify:
 				// Retrieve any reason for remote closure
-				if reason, cerr := c.procClose(); cerr != nil {
-					err = cerr
-				} else if len(reason) > 0 {
-					err = fmt.Errorf("connection dropped: %s", reason)
+				if len(frame.Reason) > 0 {
+					err = fmt.Errorf("connection dropped: %s", frame.Reason)
 				} else {
 					closed = true
 				}
 			default:
-				err = fmt.Errorf("protocol violation: unknown opcode: %v", op)
+				err = fmt.Errorf("protocol violation: unexpected opcode: %v", frame.Opcode())
 			}
 		}
 	}
-	// Close the socket and signal termination to all blocked threads
-	c.sock.Close()
-	close(c.term)
-
-	// Notify the application of the connection closure
-	c.handleClose(err)
-
-	// Wait for termination sync
-	errc := <-c.quit
-	errc <- err
+	return err
 }
@@ -13,7 +13,8 @@ import (
 	"testing"
 	"time"
 
-	"github.com/project-iris/iris/pool"
+	"gopkg.in/project-iris/iris-go.v1/internal/pool"
+	"gopkg.in/project-iris/iris-go.v1/sim"
 )
 
 // Service handler for the publish/subscribe tests.
@@ -335,6 +336,36 @@ func TestPublishMemoryLimit(t *testing.T) {
 	}
 }
 
+// Tests that the local topic policies deny publishes and subscriptions.
+func TestTopicPolicy(t *testing.T) {
+	// Connect to the local relay with a policy allowing only the test topic
+	policy := func(topic string) bool { return topic == config.topic }
+	conn, err := ConnectWithOptions(config.relay, &Options{PublishPolicy: policy, SubscribePolicy: policy})
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	handler := &publishTestTopicHandler{
+		delivers: make(chan []byte, 1),
+	}
+	// Ensure the allowed topic can be used, but others are denied
+	if err := conn.Subscribe(config.topic, handler, nil); err != nil {
+		t.Fatalf("allowed subscription failed: %v.", err)
+	}
+	defer conn.Unsubscribe(config.topic)
+
+	if err := conn.Publish(config.topic, []byte{0x00}); err != nil {
+		t.Fatalf("allowed publish failed: %v.", err)
+	}
+	if err := conn.Subscribe(config.topic+"-denied", handler, nil); err != ErrDenied {
+		t.Fatalf("denied subscription result mismatch: have %v, want %v.", err, ErrDenied)
+	}
+	if err := conn.Publish(config.topic+"-denied", []byte{0x00}); err != ErrDenied {
+		t.Fatalf("denied publish result mismatch: have %v, want %v.", err, ErrDenied)
+	}
+}
+
 // Benchmarks the latency of a single publish operation.
 func BenchmarkPublishLatency(b *testing.B) {
 	// Connect to the local relay
@@ -437,3 +468,286 @@ func benchmarkPublishThroughput(threads int, b *testing.B) {
 	// Stop the timer (don't measure deferred cleanup)
 	b.StopTimer()
 }
+
+// Tests that confirmed publishes report the subscriber count, and fail on relays
+// not supporting them.
+func TestPublishConfirmed(t *testing.T) {
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	handler := &publishTestTopicHandler{
+		delivers: make(chan []byte, 1),
+	}
+	if err := conn.Subscribe(config.topic, handler, nil); err != nil {
+		t.Fatalf("subscription failed: %v.", err)
+	}
+	defer conn.Unsubscribe(config.topic)
+	time.Sleep(100 * time.Millisecond)
+
+	if subs, err := conn.PublishConfirmed(config.topic, []byte{0x00}, time.Second); err != nil {
+		t.Fatalf("confirmed publish failed: %v.", err)
+	} else if subs != 1 {
+		t.Fatalf("subscriber count mismatch: have %d, want %d.", subs, 1)
+	}
+	<-handler.delivers
+
+	// Ensure legacy relays refuse confirmations
+	relay, err := sim.NewRelay(&sim.Config{Legacy: true})
+	if err != nil {
+		t.Fatalf("failed to start relay: %v.", err)
+	}
+	defer relay.Close()
+
+	legacy, err := Connect(relay.Port())
+	if err != nil {
+		t.Fatalf("legacy connection failed: %v.", err)
+	}
+	defer legacy.Close()
+
+	if _, err := legacy.PublishConfirmed(config.topic, []byte{0x00}, time.Second); err != ErrUnsupported {
+		t.Fatalf("legacy publish result mismatch: have %v, want %v.", err, ErrUnsupported)
+	}
+}
+
+// Tests that delayed publishes are delivered when due, unless cancelled.
+func TestPublishDelayed(t *testing.T) {
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	handler := &publishTestTopicHandler{
+		delivers: make(chan []byte, 2),
+	}
+	if err := conn.Subscribe(config.topic, handler, nil); err != nil {
+		t.Fatalf("subscription failed: %v.", err)
+	}
+	defer conn.Unsubscribe(config.topic)
+	time.Sleep(100 * time.Millisecond)
+
+	// Schedule two events and cancel one of them
+	start := time.Now()
+	if _, err := conn.PublishAfter(config.topic, []byte{0x01}, 200*time.Millisecond); err != nil {
+		t.Fatalf("delayed publish failed: %v.", err)
+	}
+	cancelled, err := conn.PublishAfter(config.topic, []byte{0x02}, 100*time.Millisecond)
+	if err != nil {
+		t.Fatalf("delayed publish failed: %v.", err)
+	}
+	if pend := conn.Stats().ScheduledMessages; pend != 2 {
+		t.Fatalf("scheduled message mismatch: have %d, want %d.", pend, 2)
+	}
+	if !cancelled.Cancel() {
+		t.Fatalf("failed to cancel pending publish.")
+	}
+	// Ensure only the remaining one arrives, and only when due
+	select {
+	case event := <-handler.delivers:
+		if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+			t.Fatalf("event delivered too early: %v.", elapsed)
+		}
+		if event[0] != 0x01 {
+			t.Fatalf("event mismatch: have %v, want %v.", event, []byte{0x01})
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("delayed event delivery timed out.")
+	}
+	select {
+	case event := <-handler.delivers:
+		t.Fatalf("cancelled event delivered: %v.", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// Tests that a saturated subscription doesn't starve the other topics of the
+// same connection.
+func TestPublishIsolation(t *testing.T) {
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Subscribe a slow, tightly limited handler and a fast, critical one
+	noisy := &publishLimitTestTopicHandler{
+		delivers: make(chan []byte, 16),
+		sleep:    250 * time.Millisecond,
+	}
+	if err := conn.Subscribe(config.topic+"-noisy", noisy, &TopicLimits{EventThreads: 1, EventMemory: 2}); err != nil {
+		t.Fatalf("noisy subscription failed: %v.", err)
+	}
+	defer conn.Unsubscribe(config.topic + "-noisy")
+
+	critical := &publishTestTopicHandler{
+		delivers: make(chan []byte, 1),
+	}
+	if err := conn.Subscribe(config.topic+"-critical", critical, nil); err != nil {
+		t.Fatalf("critical subscription failed: %v.", err)
+	}
+	defer conn.Unsubscribe(config.topic + "-critical")
+	time.Sleep(100 * time.Millisecond)
+
+	// Flood the noisy topic and verify the critical one is still served promptly
+	for i := 0; i < 16; i++ {
+		if err := conn.Publish(config.topic+"-noisy", []byte{byte(i)}); err != nil {
+			t.Fatalf("noisy publish failed: %v.", err)
+		}
+	}
+	if err := conn.Publish(config.topic+"-critical", []byte{0x00}); err != nil {
+		t.Fatalf("critical publish failed: %v.", err)
+	}
+	select {
+	case <-critical.delivers:
+	case <-time.After(100 * time.Millisecond):
+		t.Fatalf("critical event starved by noisy topic.")
+	}
+}
+
+// Topic handler recording the arrival order of the events of each publisher.
+type publishOrderTestTopicHandler struct {
+	arrived map[string][]byte
+	total   int
+	done    chan struct{}
+	lock    sync.Mutex
+}
+
+func (p *publishOrderTestTopicHandler) HandleEvent(event []byte) { panic("not implemented") }
+
+func (p *publishOrderTestTopicHandler) HandleEventWithMetadata(event []byte, meta *Metadata) {
+	time.Sleep(time.Duration(event[0]%3) * time.Millisecond)
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.arrived[meta.Sender] = append(p.arrived[meta.Sender], event[0])
+	if p.total--; p.total == 0 {
+		close(p.done)
+	}
+}
+
+// Tests that ordered subscriptions deliver each publisher's events in order.
+func TestPublishOrdered(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		publishers int
+		events     int
+	}{3, 50}
+
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	handler := &publishOrderTestTopicHandler{
+		arrived: make(map[string][]byte),
+		total:   conf.publishers * conf.events,
+		done:    make(chan struct{}),
+	}
+	if err := conn.Subscribe(config.topic, handler, &TopicLimits{EventThreads: 8, Ordered: true}); err != nil {
+		t.Fatalf("subscription failed: %v.", err)
+	}
+	defer conn.Unsubscribe(config.topic)
+	time.Sleep(100 * time.Millisecond)
+
+	// Publish concurrently from multiple named publishers
+	var pend sync.WaitGroup
+	for i := 0; i < conf.publishers; i++ {
+		pub, err := ConnectWithOptions(config.relay, &Options{Metadata: true, Name: fmt.Sprintf("publisher-%d", i)})
+		if err != nil {
+			t.Fatalf("publisher #%d: connection failed: %v.", i, err)
+		}
+		defer pub.Close()
+
+		pend.Add(1)
+		go func() {
+			defer pend.Done()
+			for j := 0; j < conf.events; j++ {
+				if err := pub.Publish(config.topic, []byte{byte(j)}); err != nil {
+					t.Errorf("publish failed: %v.", err)
+					return
+				}
+			}
+		}()
+	}
+	pend.Wait()
+
+	select {
+	case <-handler.done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("events not delivered.")
+	}
+	// Verify the per publisher ordering
+	for name, events := range handler.arrived {
+		for i, event := range events {
+			if int(event) != i {
+				t.Fatalf("publisher %s: event #%d out of order: have %d.", name, i, event)
+			}
+		}
+	}
+}
+
+// Topic handler declaring the ordering key of the events as their first byte.
+type publishKeyedTestTopicHandler struct {
+	publishOrderTestTopicHandler
+}
+
+func (p *publishKeyedTestTopicHandler) EventKey(event []byte, meta *Metadata) string {
+	return string(event[:1])
+}
+
+func (p *publishKeyedTestTopicHandler) HandleEventWithMetadata(event []byte, meta *Metadata) {
+	p.publishOrderTestTopicHandler.HandleEventWithMetadata(event[1:], &Metadata{Sender: string(event[:1])})
+}
+
+// Tests that events with the same key are delivered in publish order.
+func TestPublishKeyed(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		keys   int
+		events int
+	}{4, 50}
+
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	handler := &publishKeyedTestTopicHandler{publishOrderTestTopicHandler{
+		arrived: make(map[string][]byte),
+		total:   conf.keys * conf.events,
+		done:    make(chan struct{}),
+	}}
+	if err := conn.Subscribe(config.topic, handler, &TopicLimits{EventThreads: 8}); err != nil {
+		t.Fatalf("subscription failed: %v.", err)
+	}
+	defer conn.Unsubscribe(config.topic)
+	time.Sleep(100 * time.Millisecond)
+
+	// Interleave the events of the different keys
+	for i := 0; i < conf.events; i++ {
+		for key := 0; key < conf.keys; key++ {
+			if err := conn.Publish(config.topic, []byte{byte(key), byte(i)}); err != nil {
+				t.Fatalf("publish failed: %v.", err)
+			}
+		}
+	}
+	select {
+	case <-handler.done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("events not delivered.")
+	}
+	// Verify the per key ordering
+	for key, events := range handler.arrived {
+		for i, event := range events {
+			if int(event) != i {
+				t.Fatalf("key %q: event #%d out of order: have %d.", key, i, event)
+			}
+		}
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the quarantine of poison messages: payloads that repeatedly crash
+// their handlers or keep them busy beyond the request timeout. Payloads are
+// identified by content hash, and once quarantined, are rejected without being
+// handed to the application until the quarantine expires.
+
+package iris
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Settings of the poison message quarantine. Any unset fields (i.e. value of
+// zero) will default to the preset ones.
+type QuarantinePolicy struct {
+	Attempts int           // Failed handler runs after which a payload is quarantined
+	Expiry   time.Duration // Time after which failures and quarantines are forgotten
+
+	Notify func(hash string, payload []byte, reason error) // Hook invoked upon quarantining a payload
+}
+
+// Default settings of the poison message quarantine.
+var defaultQuarantinePolicy = QuarantinePolicy{
+	Attempts: 3,
+	Expiry:   10 * time.Minute,
+}
+
+// Failure history of a single payload.
+type poisonRecord struct {
+	fails  int       // Number of failed handler runs
+	last   time.Time // Time of the last failure
+	banned bool      // Whether the payload is quarantined
+}
+
+// Tracker of the failing payloads of a connection.
+type quarantine struct {
+	policy  QuarantinePolicy         // Settings of the quarantine
+	clock   Clock                    // Time source of the failure histories
+	records map[string]*poisonRecord // Failure histories keyed by payload hash
+	lock    sync.Mutex               // Protects the failure histories
+}
+
+// Creates a new poison message tracker, or nil if quarantining is disabled.
+func newQuarantine(user *QuarantinePolicy, clock Clock) *quarantine {
+	if user == nil {
+		return nil
+	}
+	policy := *user
+	if policy.Attempts == 0 {
+		policy.Attempts = defaultQuarantinePolicy.Attempts
+	}
+	if policy.Expiry == 0 {
+		policy.Expiry = defaultQuarantinePolicy.Expiry
+	}
+	return &quarantine{
+		policy:  policy,
+		clock:   clock,
+		records: make(map[string]*poisonRecord),
+	}
+}
+
+// Runs a message handler unless its payload is quarantined, converting panics
+// and runs exceeding the timeout (if non-zero) into failures counting towards
+// the quarantine. A nil tracker runs the handler as is.
+func (q *quarantine) guard(payload []byte, timeout time.Duration, handler func()) (err error) {
+	if q == nil {
+		handler()
+		return nil
+	}
+	sum := sha256.Sum256(payload)
+	hash := hex.EncodeToString(sum[:])
+
+	if q.banned(hash) {
+		return ErrQuarantined
+	}
+	start := q.clock.Now()
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("handler panicked: %v", r)
+		} else if timeout > 0 && since(q.clock, start) > timeout {
+			err = ErrTimeout
+		}
+		if err != nil {
+			q.failed(hash, payload, err)
+		}
+	}()
+	handler()
+	return nil
+}
+
+// Checks whether a payload is currently quarantined.
+func (q *quarantine) banned(hash string) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	record, ok := q.records[hash]
+	return ok && record.banned && since(q.clock, record.last) < q.policy.Expiry
+}
+
+// Records a failed handler run, quarantining the payload if it failed too many
+// times. Expired histories are swept along the way.
+func (q *quarantine) failed(hash string, payload []byte, reason error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	now := q.clock.Now()
+	for key, record := range q.records {
+		if now.Sub(record.last) >= q.policy.Expiry {
+			delete(q.records, key)
+		}
+	}
+	record, ok := q.records[hash]
+	if !ok {
+		record = new(poisonRecord)
+		q.records[hash] = record
+	}
+	record.fails++
+	record.last = now
+
+	if !record.banned && record.fails >= q.policy.Attempts {
+		record.banned = true
+		if q.policy.Notify != nil {
+			go q.policy.Notify(hash, payload, reason)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"bytes"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Service handler crashing on every request for the quarantine tests.
+type quarantineTestHandler struct {
+	runs int32
+}
+
+func (q *quarantineTestHandler) Init(conn *Connection) error { return nil }
+func (q *quarantineTestHandler) HandleBroadcast(msg []byte)  { panic("not implemented") }
+func (q *quarantineTestHandler) HandleTunnel(tun *Tunnel)    { panic("not implemented") }
+func (q *quarantineTestHandler) HandleDrop(reason error)     { panic("not implemented") }
+
+func (q *quarantineTestHandler) HandleRequest(req []byte) ([]byte, error) {
+	atomic.AddInt32(&q.runs, 1)
+	panic("poison")
+}
+
+// Tests that payloads repeatedly crashing their handler get quarantined.
+func TestQuarantine(t *testing.T) {
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Register a crashing service with quarantining enabled
+	poisons := make(chan []byte, 1)
+	handler := new(quarantineTestHandler)
+	serv, err := RegisterWithOptions(config.relay, config.cluster, handler, nil, &Options{
+		Quarantine: &QuarantinePolicy{
+			Attempts: 2,
+			Notify:   func(hash string, payload []byte, reason error) { poisons <- payload },
+		},
+	})
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	// Crash the handler until the payload gets quarantined
+	for i := 0; i < 2; i++ {
+		if _, err := conn.Request(config.cluster, []byte("poison"), time.Second); err == nil {
+			t.Fatalf("attempt %d: crashing request succeeded.", i)
+		}
+	}
+	select {
+	case payload := <-poisons:
+		if !bytes.Equal(payload, []byte("poison")) {
+			t.Fatalf("quarantined payload mismatch: have %q, want %q.", payload, "poison")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("quarantine notification timed out.")
+	}
+	// Verify that the quarantined payload doesn't reach the handler any more
+	_, err = conn.Request(config.cluster, []byte("poison"), time.Second)
+	if rerr, ok := err.(*RemoteError); !ok || rerr.Error() != ErrQuarantined.Error() {
+		t.Fatalf("quarantined request error mismatch: have %v, want %v.", err, ErrQuarantined)
+	}
+	if runs := atomic.LoadInt32(&handler.runs); runs != 2 {
+		t.Fatalf("handler run count mismatch: have %d, want %d.", runs, 2)
+	}
+}
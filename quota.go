@@ -0,0 +1,220 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the per caller quotas of the inbound requests.
+//
+// Multi-tenant services may cap the request and byte rates each caller gets,
+// so that a single abusive peer cannot starve the rest. Every caller has its own
+// token buckets, refilled continuously at the configured rates and holding at
+// most a second's worth (or the configured burst) of tokens. Requests finding
+// their caller's bucket empty are rejected before being queued, replying with a
+// QuotaError that tells the caller when to retry.
+//
+// Callers are told apart by default by the caller id in the request metadata,
+// i.e. each client connection has its own quota. Callers with metadata disabled
+// can't be told apart, and share a single anonymous quota.
+//
+// WARNING: the default caller key is self-reported by the clients and is not
+// trustworthy. An abusive client may escape its quota by reconnecting (drawing
+// a new caller id), by forging the caller id of its requests, or by disabling
+// metadata and landing in the anonymous quota, throttling every well behaved
+// anonymous caller along with itself. The default is thus only fit to contain
+// cooperative clients; services facing untrusted ones must supply a verified
+// caller identity through QuotaPolicy.Caller (e.g. derived from the key id of
+// signed or encrypted requests, or from credentials carried in the payload).
+
+package iris
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Settings of the per caller request quotas. Any unset fields (i.e. value of
+// zero) will disable the corresponding limit.
+type QuotaPolicy struct {
+	Requests float64 // Requests per second a single caller may issue
+	Bytes    float64 // Request bytes per second a single caller may send
+
+	RequestBurst int // Requests a caller may issue at once (defaults to a second's worth)
+	ByteBurst    int // Request bytes a caller may send at once (defaults to a second's worth)
+
+	Caller CallerKey // Verified identity of the caller of a request (defaults to the self-reported, untrusted metadata caller)
+}
+
+// Limits of the quotas, reported in the rejections.
+const (
+	QuotaRequests = "requests"
+	QuotaBytes    = "bytes"
+)
+
+// Failure replied to a request whose caller exceeded its quota.
+type QuotaError struct {
+	Limit      string        // Limit that was exceeded (QuotaRequests or QuotaBytes)
+	RetryAfter time.Duration // Time after which the request would have fit
+}
+
+// Prefix of the reply faults carrying a quota rejection.
+const quotaFaultPrefix = "quota exceeded: "
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("%s%s rate, retry after %v", quotaFaultPrefix, e.Limit, e.RetryAfter)
+}
+
+// Reconstructs a quota rejection from a reply fault, or nil if the fault is of
+// some other kind.
+func parseQuotaFault(fault string) *QuotaError {
+	if !strings.HasPrefix(fault, quotaFaultPrefix) {
+		return nil
+	}
+	var limit, retry string
+	if _, err := fmt.Sscanf(fault[len(quotaFaultPrefix):], "%s rate, retry after %s", &limit, &retry); err != nil {
+		return nil
+	}
+	after, err := time.ParseDuration(retry)
+	if err != nil {
+		return nil
+	}
+	return &QuotaError{Limit: limit, RetryAfter: after}
+}
+
+// Token bucket refilled continuously up to its capacity.
+type quotaBucket struct {
+	tokens float64   // Tokens available at the last update
+	update time.Time // Time of the last refill
+}
+
+// Refills the bucket up to the current time.
+func (b *quotaBucket) refill(now time.Time, rate float64, capacity float64) {
+	b.tokens += rate * now.Sub(b.update).Seconds()
+	if b.tokens > capacity {
+		b.tokens = capacity
+	}
+	b.update = now
+}
+
+// Per caller request and byte rate limiter.
+type quotaLimiter struct {
+	policy QuotaPolicy // Settings of the quotas
+
+	reqCap  float64 // Capacity of the request buckets
+	byteCap float64 // Capacity of the byte buckets
+
+	reqs  map[string]*quotaBucket // Request buckets of the callers
+	bytes map[string]*quotaBucket // Byte buckets of the callers
+	prune time.Time               // Time of the last sweep of the idle buckets
+	clock Clock                   // Time source of the refills
+	lock  sync.Mutex              // Protects the buckets
+}
+
+// Creates a per caller rate limiter, or nil if quotas are disabled.
+func newQuotaLimiter(user *QuotaPolicy, clock Clock) *quotaLimiter {
+	if user == nil || (user.Requests <= 0 && user.Bytes <= 0) {
+		return nil
+	}
+	limiter := &quotaLimiter{
+		policy:  *user,
+		reqCap:  user.Requests,
+		byteCap: user.Bytes,
+		reqs:    make(map[string]*quotaBucket),
+		bytes:   make(map[string]*quotaBucket),
+		prune:   clock.Now(),
+		clock:   clock,
+	}
+	if user.RequestBurst > 0 {
+		limiter.reqCap = float64(user.RequestBurst)
+	}
+	if user.ByteBurst > 0 {
+		limiter.byteCap = float64(user.ByteBurst)
+	}
+	return limiter
+}
+
+// Caller identity shared by all the requests arriving without metadata.
+const quotaAnonymous = ""
+
+// Identifies the caller of a request to account it to: the user supplied key if
+// any, or the self-reported originating client connection otherwise, falling
+// back to the shared anonymous caller if unknown.
+func (q *quotaLimiter) caller(request []byte, meta *Metadata) string {
+	if q.policy.Caller != nil {
+		return q.policy.Caller(request, meta)
+	}
+	if origin := meta.origin(); origin != "" {
+		return origin
+	}
+	return quotaAnonymous
+}
+
+// Accounts a request to its caller's quotas, returning the rejection if any of
+// them was exceeded. Rejected requests consume no quota. A nil limiter admits
+// everything.
+func (q *quotaLimiter) admit(request []byte, meta *Metadata) *QuotaError {
+	if q == nil {
+		return nil
+	}
+	caller, now := q.caller(request, meta), q.clock.Now()
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.sweep(now)
+
+	var reqs, bytes *quotaBucket
+	if q.policy.Requests > 0 {
+		if reqs = q.bucket(q.reqs, caller, now, q.policy.Requests, q.reqCap); reqs.tokens < 1 {
+			return &QuotaError{Limit: QuotaRequests, RetryAfter: quotaWait(1-reqs.tokens, q.policy.Requests)}
+		}
+	}
+	if q.policy.Bytes > 0 {
+		size := float64(len(request))
+		if bytes = q.bucket(q.bytes, caller, now, q.policy.Bytes, q.byteCap); bytes.tokens < size {
+			return &QuotaError{Limit: QuotaBytes, RetryAfter: quotaWait(size-bytes.tokens, q.policy.Bytes)}
+		}
+		bytes.tokens -= size
+	}
+	if reqs != nil {
+		reqs.tokens--
+	}
+	return nil
+}
+
+// Retrieves the refilled bucket of a caller, creating a full one if needed.
+func (q *quotaLimiter) bucket(buckets map[string]*quotaBucket, caller string, now time.Time, rate float64, capacity float64) *quotaBucket {
+	bucket, ok := buckets[caller]
+	if !ok {
+		bucket = &quotaBucket{tokens: capacity, update: now}
+		buckets[caller] = bucket
+	}
+	bucket.refill(now, rate, capacity)
+	return bucket
+}
+
+// Drops the buckets refilled to capacity at most once a second, as those are
+// indistinguishable from fresh ones.
+func (q *quotaLimiter) sweep(now time.Time) {
+	if now.Sub(q.prune) < time.Second {
+		return
+	}
+	q.prune = now
+	for caller, bucket := range q.reqs {
+		if bucket.refill(now, q.policy.Requests, q.reqCap); bucket.tokens >= q.reqCap {
+			delete(q.reqs, caller)
+		}
+	}
+	for caller, bucket := range q.bytes {
+		if bucket.refill(now, q.policy.Bytes, q.byteCap); bucket.tokens >= q.byteCap {
+			delete(q.bytes, caller)
+		}
+	}
+}
+
+// Calculates the time needed to refill the missing tokens at the given rate.
+func quotaWait(missing float64, rate float64) time.Duration {
+	return time.Duration(missing / rate * float64(time.Second))
+}
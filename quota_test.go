@@ -0,0 +1,116 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// Tests that callers exceeding their request quota are rejected with a quota
+// error, without affecting other callers.
+func TestQuotas(t *testing.T) {
+	options := &Options{Quotas: &QuotaPolicy{Requests: 1, RequestBurst: 3}}
+	serv, err := RegisterWithOptions(config.relay, config.cluster, new(requestTestHandler), nil, options)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	// Connect two distinct callers and exhaust the quota of the first
+	abuser, err := ConnectWithOptions(config.relay, &Options{Metadata: true, Name: "abuser"})
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer abuser.Close()
+
+	victim, err := ConnectWithOptions(config.relay, &Options{Metadata: true, Name: "victim"})
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer victim.Close()
+
+	for i := 0; i < 3; i++ {
+		if _, err := abuser.Request(config.cluster, []byte{byte(i)}, time.Second); err != nil {
+			t.Fatalf("request %d within burst failed: %v.", i, err)
+		}
+	}
+	_, err = abuser.Request(config.cluster, []byte{3}, time.Second)
+
+	var quota *QuotaError
+	if !errors.As(err, &quota) {
+		t.Fatalf("quota rejection mismatch: have %v.", err)
+	}
+	if quota.Limit != QuotaRequests || quota.RetryAfter <= 0 || quota.RetryAfter > time.Second {
+		t.Fatalf("quota details mismatch: have %s after %v.", quota.Limit, quota.RetryAfter)
+	}
+	// Ensure other callers are unaffected
+	if _, err := victim.Request(config.cluster, []byte{0}, time.Second); err != nil {
+		t.Fatalf("unrelated caller rejected: %v.", err)
+	}
+}
+
+// Tests that each client connection has its own quota, even if sharing a name,
+// whereas callers without metadata share an anonymous one.
+func TestQuotaCallers(t *testing.T) {
+	options := &Options{Quotas: &QuotaPolicy{Requests: 1, RequestBurst: 2}}
+	serv, err := RegisterWithOptions(config.relay, config.cluster, new(requestTestHandler), nil, options)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	// Connect a few clients, two of the same tenant and two anonymous ones
+	conns := make([]*Connection, 4)
+	for i, opts := range []*Options{{Metadata: true, Name: "tenant"}, {Metadata: true, Name: "tenant"}, nil, nil} {
+		if conns[i], err = ConnectWithOptions(config.relay, opts); err != nil {
+			t.Fatalf("connection %d failed: %v.", i, err)
+		}
+		defer conns[i].Close()
+	}
+	// Exhaust the quota of the first client of each kind, checking the second
+	for _, pair := range [][2]int{{0, 1}, {2, 3}} {
+		for i := 0; i < 2; i++ {
+			if _, err := conns[pair[0]].Request(config.cluster, []byte{byte(i)}, time.Second); err != nil {
+				t.Fatalf("client %d: request %d within burst failed: %v.", pair[0], i, err)
+			}
+		}
+		_, err := conns[pair[1]].Request(config.cluster, []byte{0}, time.Second)
+
+		var quota *QuotaError
+		if shared := pair[0] == 2; shared != errors.As(err, &quota) {
+			t.Fatalf("client %d: rejection mismatch: have %v, shared quota %v.", pair[1], err, shared)
+		}
+	}
+}
+
+// Tests that the byte quotas are refilled over time.
+func TestQuotaRefill(t *testing.T) {
+	clock := newClockTestFake()
+	limiter := newQuotaLimiter(&QuotaPolicy{Bytes: 100}, clock)
+	meta := &Metadata{Caller: "caller"}
+
+	if err := limiter.admit(make([]byte, 80), meta); err != nil {
+		t.Fatalf("request within quota rejected: %v.", err)
+	}
+	err := limiter.admit(make([]byte, 40), meta)
+	if err == nil || err.Limit != QuotaBytes {
+		t.Fatalf("request over quota not rejected: %v.", err)
+	}
+	if err.RetryAfter != 200*time.Millisecond {
+		t.Fatalf("retry delay mismatch: have %v, want %v.", err.RetryAfter, 200*time.Millisecond)
+	}
+	clock.advance(err.RetryAfter)
+	if err := limiter.admit(make([]byte, 40), meta); err != nil {
+		t.Fatalf("request after refill rejected: %v.", err)
+	}
+	// Ensure the fault survives the wire round trip
+	if parsed := parseQuotaFault(err.Error()); parsed == nil || *parsed != *err {
+		t.Fatalf("fault round trip mismatch: have %v, want %v.", parsed, err)
+	}
+}
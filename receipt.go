@@ -0,0 +1,143 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the fan-out receipts of tracked broadcasts.
+//
+// The relay doesn't report how many members a broadcast reached, so receivers
+// acknowledge tracked broadcasts themselves: the envelope carries the sender's
+// private receipt topic and the broadcast id, and each member handling it
+// successfully publishes the id back to that topic. The sender counts the
+// receipts arriving within a time window and reports the total.
+
+package iris
+
+import (
+	"encoding/binary"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Prefix of the private topics the broadcast receipts are collected on.
+const receiptTopicPrefix = "iris.receipt."
+
+// Broadcasts a message to all members of a cluster similarly to Broadcast, but
+// requests every member to acknowledge handling it. The number of members that
+// did so within the window is delivered asynchronously on the returned channel,
+// which is closed afterwards.
+//
+// Only members running a binding version supporting receipts acknowledge, and
+// those are best effort too, so the count is a lower bound meant for auditing.
+func (c *Connection) BroadcastWithReceipts(cluster string, message []byte, window time.Duration) (<-chan int, error) {
+	if err := c.checkBroadcast(cluster, message); err != nil {
+		return nil, err
+	}
+	return c.broadcastTracked(cluster, message, nil, window)
+}
+
+// Broadcasts a message along with any extra envelope headers, requesting every
+// member to acknowledge it, and counting the receipts arriving in the window.
+func (c *Connection) broadcastTracked(cluster string, message []byte, headers map[string]string, window time.Duration) (<-chan int, error) {
+	topic, err := c.receiptTopic()
+	if err != nil {
+		return nil, err
+	}
+	// Register the tracker of the receipts
+	c.rcptLock.Lock()
+	id := c.rcptIdx
+	c.rcptIdx++
+	c.rcptLive[id] = 0
+	c.rcptLock.Unlock()
+
+	result := make(chan int, 1)
+	c.clock.AfterFunc(window, func() {
+		c.rcptLock.Lock()
+		count := c.rcptLive[id]
+		delete(c.rcptLive, id)
+		c.rcptLock.Unlock()
+
+		result <- count
+		close(result)
+	})
+	// Broadcast the message along with the receipt destination
+	c.Log.Debug("sending new tracked broadcast", "cluster", cluster, "receipt", id, "data", logLazyBlob(message))
+	if c.faults.dropBroadcast() {
+		c.Log.Debug("fault injected: broadcast dropped", "cluster", cluster)
+		return result, nil
+	}
+	envelope := map[string]string{headerReceipt: topic + "/" + strconv.FormatUint(id, 10)}
+	for key, val := range headers {
+		envelope[key] = val
+	}
+	message, err = c.envelope(cluster, message, envelope)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.sendBroadcast(cluster, message); err != nil {
+		return nil, err
+	}
+	atomic.AddUint64(&c.stats.bcastSent, 1)
+	return result, nil
+}
+
+// Retrieves the private receipt topic of the connection, subscribing to it upon
+// the first use.
+func (c *Connection) receiptTopic() (string, error) {
+	c.rcptLock.Lock()
+	defer c.rcptLock.Unlock()
+
+	if c.rcptTopic != "" {
+		return c.rcptTopic, nil
+	}
+	topic := receiptTopicPrefix + newCorrelationID()
+	if err := c.sendSubscribe(topic); err != nil {
+		return "", err
+	}
+	c.rcptTopic = topic
+	return topic, nil
+}
+
+// Checks whether an inbound event arrived on the private receipt topic, and if
+// so, accounts it to the broadcast it acknowledges.
+func (c *Connection) handleReceipt(topic string, event []byte) bool {
+	c.rcptLock.Lock()
+	defer c.rcptLock.Unlock()
+
+	if topic != c.rcptTopic || c.rcptTopic == "" {
+		return false
+	}
+	if len(event) != 8 {
+		c.Log.Warn("dropping malformed broadcast receipt", "size", len(event))
+		return true
+	}
+	if count, ok := c.rcptLive[binary.BigEndian.Uint64(event)]; ok {
+		c.rcptLive[binary.BigEndian.Uint64(event)] = count + 1
+	}
+	return true
+}
+
+// Acknowledges the successful handling of a tracked broadcast to its sender.
+func (c *Connection) sendReceipt(dest string) {
+	split := strings.LastIndex(dest, "/")
+	if split < 0 || !strings.HasPrefix(dest, receiptTopicPrefix) {
+		c.Log.Warn("dropping malformed receipt destination", "destination", dest)
+		c.reportError("dropped malformed receipt destination: %q", dest)
+		return
+	}
+	id, err := strconv.ParseUint(dest[split+1:], 10, 64)
+	if err != nil {
+		c.Log.Warn("dropping malformed receipt destination", "destination", dest)
+		return
+	}
+	receipt := make([]byte, 8)
+	binary.BigEndian.PutUint64(receipt, id)
+
+	if err := c.sendPublish(dest[:split], receipt); err != nil {
+		c.Log.Error("failed to send broadcast receipt", "reason", err)
+		c.reportError("failed to send broadcast receipt: %v", err)
+	}
+}
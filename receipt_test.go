@@ -0,0 +1,69 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"testing"
+	"time"
+)
+
+// Tests that tracked broadcasts report the number of members handling them.
+func TestBroadcastReceipts(t *testing.T) {
+	// Register a few members into the cluster
+	members := 3
+	handlers := make([]*broadcastTestHandler, members)
+	for i := 0; i < members; i++ {
+		handlers[i] = &broadcastTestHandler{
+			delivers: make(chan []byte, 1),
+		}
+		serv, err := Register(config.relay, config.cluster, handlers[i], nil)
+		if err != nil {
+			t.Fatalf("registration failed: %v.", err)
+		}
+		defer serv.Unregister()
+	}
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Send a tracked broadcast and verify the receipts
+	receipts, err := conn.BroadcastWithReceipts(config.cluster, []byte("tracked"), 250*time.Millisecond)
+	if err != nil {
+		t.Fatalf("tracked broadcast failed: %v.", err)
+	}
+	for i, handler := range handlers {
+		select {
+		case msg := <-handler.delivers:
+			if string(msg) != "tracked" {
+				t.Fatalf("member #%d: message mismatch: have %q, want %q.", i, msg, "tracked")
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("member #%d: broadcast not delivered.", i)
+		}
+	}
+	select {
+	case count := <-receipts:
+		if count != members {
+			t.Fatalf("receipt count mismatch: have %d, want %d.", count, members)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("receipts not reported.")
+	}
+	if _, ok := <-receipts; ok {
+		t.Fatalf("receipt channel not closed.")
+	}
+	// Verify that broadcasts to empty clusters report no receipts
+	receipts, err = conn.BroadcastWithReceipts(config.cluster+"-missing", []byte("tracked"), 50*time.Millisecond)
+	if err != nil {
+		t.Fatalf("tracked broadcast failed: %v.", err)
+	}
+	if count := <-receipts; count != 0 {
+		t.Fatalf("receipt count mismatch: have %d, want %d.", count, 0)
+	}
+}
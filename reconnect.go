@@ -0,0 +1,162 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the automatic re-establishment of dropped relay links and the
+// reconciliation of the topic subscriptions.
+//
+// The connection tracks the desired set of subscriptions (those requested by
+// the application and not yet unsubscribed) separately from the actual set the
+// current relay link knows about. Whenever a link drops, all subscriptions are
+// marked pending and get re-sent after a successful reconnect. Requests in
+// flight during the drop are left to time out, tunnels are torn down.
+
+package iris
+
+import (
+	"bufio"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/project-iris/iris-go.v1/relaywire"
+)
+
+// Settings of the automatic reconnection to the relay. Any unset fields (i.e.
+// value of zero) will default to the preset ones.
+type ReconnectPolicy struct {
+	Attempts   int           // Maximum number of reconnect attempts after a drop
+	Backoff    time.Duration // Delay before the first attempt, doubled after each
+	MaxBackoff time.Duration // Upper limit of the delay between attempts
+}
+
+// Default settings of the automatic reconnection.
+var defaultReconnectPolicy = ReconnectPolicy{
+	Attempts:   10,
+	Backoff:    100 * time.Millisecond,
+	MaxBackoff: 5 * time.Second,
+}
+
+// Merges the user requested reconnection settings with the defaults, or nil if
+// reconnection is disabled.
+func finalizeReconnectPolicy(user *ReconnectPolicy) *ReconnectPolicy {
+	if user == nil {
+		return nil
+	}
+	policy := *user
+	if policy.Attempts == 0 {
+		policy.Attempts = defaultReconnectPolicy.Attempts
+	}
+	if policy.Backoff == 0 {
+		policy.Backoff = defaultReconnectPolicy.Backoff
+	}
+	if policy.MaxBackoff == 0 {
+		policy.MaxBackoff = defaultReconnectPolicy.MaxBackoff
+	}
+	return &policy
+}
+
+// Tries to re-establish a dropped relay link, returning whether it succeeded.
+// Only invoked from the network receiver, which is suspended until then.
+func (c *Connection) reconnect(reason error) bool {
+	policy := finalizeReconnectPolicy(c.options.Reconnect)
+	if policy == nil || atomic.LoadInt32(&c.closing) != 0 {
+		return false
+	}
+	c.Log.Warn("relay link dropped, reconnecting", "reason", reason)
+	c.sock.Close()
+	c.dropLink()
+
+	backoff := policy.Backoff
+	for attempt := 1; attempt <= policy.Attempts; attempt++ {
+		<-c.clock.After(backoff)
+		if atomic.LoadInt32(&c.closing) != 0 {
+			return false
+		}
+		if err := c.relink(); err != nil {
+			c.Log.Warn("reconnect attempt failed", "attempt", attempt, "reason", err)
+			if backoff *= 2; backoff > policy.MaxBackoff {
+				backoff = policy.MaxBackoff
+			}
+			continue
+		}
+		c.Log.Info("relay link re-established", "attempt", attempt)
+		c.reconcile()
+		return true
+	}
+	return false
+}
+
+// Dials the relay and runs the handshake on a standalone link, swapping it in
+// only if successful, so concurrent senders never interleave with it.
+func (c *Connection) relink() error {
+	sock, err := dialRelay(c.options, c.port)
+	if err != nil {
+		return err
+	}
+	link := &Connection{
+		cluster: c.cluster,
+		options: c.options,
+		sock:    sock,
+		sockBuf: bufio.NewReadWriter(bufio.NewReader(sock), bufio.NewWriter(sock)),
+		sockEnc: relaywire.NewWriter(nil),
+		sockDec: relaywire.NewScratchReader(nil),
+		Log:     c.Log,
+	}
+	if err := link.handshake(c.port, c.cluster); err != nil {
+		link.sock.Close()
+		return err
+	}
+	c.sockLock.Lock()
+	c.sock, c.sockBuf = link.sock, link.sockBuf
+	c.version, c.features = link.version, link.features
+	c.sockEnc.SetChecksums(c.features[relaywire.FeatureCRC])
+	c.sockDec.SetChecksums(c.features[relaywire.FeatureCRC])
+	c.sockLock.Unlock()
+
+	return nil
+}
+
+// Discards the state bound to a dropped relay link: tunnels are closed and all
+// subscriptions are marked pending.
+func (c *Connection) dropLink() {
+	c.tunLock.Lock()
+	for id, tun := range c.tunLive {
+		tun.handleClose("connection dropped")
+		delete(c.tunLive, id)
+	}
+	c.tunLock.Unlock()
+
+	c.subLock.Lock()
+	for _, top := range c.subLive {
+		top.active = false
+	}
+	c.subLock.Unlock()
+}
+
+// Sends a subscription for every desired topic not yet known by the relay.
+func (c *Connection) reconcile() {
+	c.rcptLock.Lock()
+	if c.rcptTopic != "" {
+		if err := c.sendSubscribe(c.rcptTopic); err != nil {
+			c.Log.Warn("failed to re-establish receipt subscription", "reason", err)
+		}
+	}
+	c.rcptLock.Unlock()
+
+	c.subLock.Lock()
+	defer c.subLock.Unlock()
+
+	for name, top := range c.subLive {
+		if top.active {
+			continue
+		}
+		if err := c.sendSubscribe(name); err != nil {
+			top.logger.Warn("failed to re-establish subscription", "reason", err)
+			return
+		}
+		top.active = true
+		top.logger.Info("subscription re-established")
+	}
+}
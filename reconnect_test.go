@@ -0,0 +1,65 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/project-iris/iris-go.v1/sim"
+)
+
+// Tests that dropped relay links are re-established and the subscriptions get
+// reconciled with the new link.
+func TestReconnect(t *testing.T) {
+	relay, err := sim.NewRelay(nil)
+	if err != nil {
+		t.Fatalf("failed to start relay: %v.", err)
+	}
+	port := relay.Port()
+
+	// Connect a reconnecting client and subscribe to a topic
+	conn, err := ConnectWithOptions(port, &Options{
+		Reconnect: &ReconnectPolicy{Backoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond},
+	})
+	if err != nil {
+		relay.Close()
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	handler := &publishTestTopicHandler{
+		delivers: make(chan []byte, 1),
+	}
+	if err := conn.Subscribe(config.topic, handler, nil); err != nil {
+		relay.Close()
+		t.Fatalf("subscription failed: %v.", err)
+	}
+	// Restart the relay to drop the link
+	relay.Close()
+	if relay, err = sim.NewRelay(&sim.Config{Port: port}); err != nil {
+		t.Fatalf("failed to restart relay: %v.", err)
+	}
+	defer relay.Close()
+
+	// Ensure events are delivered through the new link
+	for start := time.Now(); ; {
+		conn.Publish(config.topic, []byte{0x00})
+		select {
+		case <-handler.delivers:
+		case <-time.After(50 * time.Millisecond):
+			if time.Since(start) > 2*time.Second {
+				t.Fatalf("subscription not reconciled: %+v.", conn.Stats())
+			}
+			continue
+		}
+		break
+	}
+	if stats := conn.Stats(); stats.Subscriptions != 1 || stats.PendingSubscriptions != 0 {
+		t.Fatalf("subscription state mismatch: have %d/%d pending, want 1/0.", stats.Subscriptions, stats.PendingSubscriptions)
+	}
+}
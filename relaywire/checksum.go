@@ -0,0 +1,93 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the frame checksums of the checksum extension.
+//
+// If both sides agreed to the checksum feature, every frame following the
+// handshake is trailed by the big endian CRC32 (Castagnoli) checksum of its
+// bytes, opcode included. The checksums are enabled explicitly on the writers
+// and readers after the negotiation, since the handshake frames themselves are
+// never checksummed.
+
+package relaywire
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// Size of the checksum trailing each frame.
+const checksumSize = 4
+
+// Polynomial table of the frame checksums.
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Returned if a received frame doesn't match its trailing checksum, i.e. it was
+// corrupted somewhere along the way (proxies, interception tools, etc).
+type ChecksumError struct {
+	Opcode   byte   // Opcode of the corrupted frame (may be corrupted itself)
+	Frame    uint64 // Index of the corrupted frame on the stream (handshake included)
+	Size     int    // Length of the corrupted frame, trailer excluded
+	Expected uint32 // Checksum carried by the frame trailer
+	Actual   uint32 // Checksum computed over the received frame
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("frame checksum mismatch: opcode %#02x, frame #%d, %d bytes: have %08x, want %08x",
+		e.Opcode, e.Frame, e.Size, e.Actual, e.Expected)
+}
+
+// Enables or disables the checksum trailers of the frames written.
+func (w *Writer) SetChecksums(enabled bool) {
+	w.checksum = enabled
+}
+
+// Enables or disables the verification of the checksum trailers of the frames
+// read.
+func (r *Reader) SetChecksums(enabled bool) {
+	r.checksum = enabled
+}
+
+// Writes out the checksum trailer of the frame assembled so far, along with its
+// unflushed fields.
+func (w *Writer) seal() error {
+	sum := crc32.Update(w.crc, castagnoli, w.buf)
+	w.buf = binary.BigEndian.AppendUint32(w.buf, sum)
+	w.crc = 0
+	return w.flush()
+}
+
+// Accumulates a piece of the current frame into the running checksum.
+func (r *Reader) sum(data []byte) {
+	if r.checksum {
+		r.crc = crc32.Update(r.crc, castagnoli, data)
+		r.size += len(data)
+	}
+}
+
+// Concludes reading a frame, verifying its checksum trailer if enabled. Decode
+// failures are passed through, as the stream is unusable anyway.
+func (r *Reader) finish(op byte, frame Frame, err error) (Frame, error) {
+	index := r.count
+	r.count++
+
+	crc, size := r.crc, r.size
+	r.crc, r.size = 0, 0
+
+	if err != nil || !r.checksum {
+		return frame, err
+	}
+	var trailer [checksumSize]byte
+	if _, err := io.ReadFull(r.in, trailer[:]); err != nil {
+		return frame, err
+	}
+	if want := binary.BigEndian.Uint32(trailer[:]); want != crc {
+		return nil, &ChecksumError{Opcode: op, Frame: index, Size: size, Expected: want, Actual: crc}
+	}
+	return frame, nil
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the conformance vectors of the wire protocol.
+
+package relaywire
+
+// Conformance vector pairing a frame with its exact wire encoding. Alternative
+// bindings and relay doubles can check their codecs against these.
+type Vector struct {
+	Name  string // Short description of the vector
+	Relay bool   // Whether the frame is sent by the relay (false = by a binding)
+	Frame Frame  // Decoded form of the frame
+	Wire  []byte // Encoded form of the frame, opcode included
+}
+
+// Conformance vectors covering every frame type of both directions.
+var Vectors = []Vector{
+	// Frames sent by the bindings
+	{"init/client", false, &Init{Version: ProtoVersion}, wire(OpInit, 17, ClientMagic, 11, ProtoVersion, 0)},
+	{"init/service", false, &Init{Version: ProtoVersion, Cluster: "svc"}, wire(OpInit, 17, ClientMagic, 11, ProtoVersion, 3, "svc")},
+	{"init/auth", false, &Init{Version: ProtoVersion + "+auth", Cluster: "svc", Kind: "token", Credential: []byte("pw")},
+		wire(OpInit, 17, ClientMagic, 16, ProtoVersion+"+auth", 3, "svc", 5, "token", 2, "pw")},
+	{"close", false, &Close{}, wire(OpClose)},
+	{"broadcast", false, &Broadcast{Cluster: "svc", Message: []byte{0xff}}, wire(OpBroadcast, 3, "svc", 1, 0xff)},
+	{"request", false, &Request{ID: 300, Cluster: "svc", Request: []byte("hi"), Timeout: 1000},
+		wire(OpRequest, 0xac, 0x02, 3, "svc", 2, "hi", 0xe8, 0x07)},
+	{"reply/success", false, &Reply{ID: 1, Reply: []byte("ok")}, wire(OpReply, 1, 1, 2, "ok")},
+	{"reply/failure", false, &Reply{ID: 1, Fault: "bad"}, wire(OpReply, 1, 0, 3, "bad")},
+	{"subscribe", false, &Subscribe{Topic: "news"}, wire(OpSubscribe, 4, "news")},
+	{"unsubscribe", false, &Unsubscribe{Topic: "news"}, wire(OpUnsubscribe, 4, "news")},
+	{"publish", false, &Publish{Topic: "news", Event: []byte("x")}, wire(OpPublish, 4, "news", 1, "x")},
+	{"tunnel/init", false, &TunnelInit{ID: 2, Cluster: "svc", Timeout: 100}, wire(OpTunInit, 2, 3, "svc", 100)},
+	{"tunnel/confirm", false, &TunnelConfirm{BuildID: 7, ID: 3}, wire(OpTunConfirm, 7, 3)},
+	{"tunnel/allow", false, &TunnelAllow{ID: 3, Space: 128}, wire(OpTunAllow, 3, 0x80, 0x01)},
+	{"tunnel/transfer", false, &TunnelTransfer{ID: 3, Size: 2, Payload: []byte("ab")}, wire(OpTunTransfer, 3, 2, 2, "ab")},
+	{"tunnel/close", false, &TunnelClose{ID: 3}, wire(OpTunClose, 3)},
+	{"publish/confirm", false, &PublishConfirm{ID: 4, Topic: "news", Event: []byte("x")}, wire(OpPubConfirm, 4, 4, "news", 1, "x")},
+	{"tunnel/abort", false, &TunnelAbort{ID: 3, Reason: "bad"}, wire(OpTunAbort, 3, 3, "bad")},
+	{"tunnel/init/header", false, &TunnelInitHeader{ID: 2, Cluster: "svc", Timeout: 100, Header: []byte("h")}, wire(OpTunHeader, 2, 3, "svc", 100, 1, "h")},
+
+	// Frames sent by the relay
+	{"init/accept", true, &InitAccept{Version: ProtoVersion}, wire(OpInit, 16, RelayMagic, 11, ProtoVersion)},
+	{"init/deny", true, &InitDeny{Reason: "no"}, wire(OpDeny, 16, RelayMagic, 2, "no")},
+	{"close/graceful", true, &CloseNotify{}, wire(OpClose, 0)},
+	{"close/dropped", true, &CloseNotify{Reason: "bye"}, wire(OpClose, 3, "bye")},
+	{"broadcast/delivery", true, &BroadcastDelivery{Message: []byte("m")}, wire(OpBroadcast, 1, "m")},
+	{"request/delivery", true, &RequestDelivery{ID: 5, Request: []byte("r"), Timeout: 10}, wire(OpRequest, 5, 1, "r", 10)},
+	{"reply/timeout", true, &ReplyDelivery{ID: 5, Timeout: true}, wire(OpReply, 5, 1)},
+	{"reply/delivery", true, &ReplyDelivery{ID: 5, Reply: []byte("ok")}, wire(OpReply, 5, 0, 1, 2, "ok")},
+	{"reply/fault", true, &ReplyDelivery{ID: 5, Fault: "bad"}, wire(OpReply, 5, 0, 0, 3, "bad")},
+	{"publish/delivery", true, &PublishDelivery{Topic: "news", Event: []byte("x")}, wire(OpPublish, 4, "news", 1, "x")},
+	{"tunnel/init/delivery", true, &TunnelInitDelivery{ID: 7, ChunkLimit: 1024}, wire(OpTunInit, 7, 0x80, 0x08)},
+	{"tunnel/result", true, &TunnelResult{ID: 2, ChunkLimit: 1024}, wire(OpTunConfirm, 2, 0, 0x80, 0x08)},
+	{"tunnel/result/timeout", true, &TunnelResult{ID: 2, Timeout: true}, wire(OpTunConfirm, 2, 1)},
+	{"tunnel/allow/relay", true, &TunnelAllow{ID: 2, Space: 1}, wire(OpTunAllow, 2, 1)},
+	{"tunnel/transfer/relay", true, &TunnelTransfer{ID: 2, Payload: []byte("c")}, wire(OpTunTransfer, 2, 0, 1, "c")},
+	{"tunnel/close/notify", true, &TunnelCloseNotify{ID: 2, Reason: "gone"}, wire(OpTunClose, 2, 4, "gone")},
+	{"publish/ack", true, &PublishAck{ID: 4, Subscribers: 3}, wire(OpPubConfirm, 4, 3)},
+	{"tunnel/init/header/delivery", true, &TunnelInitHeaderDelivery{ID: 7, ChunkLimit: 1024, Header: []byte("h")}, wire(OpTunHeader, 7, 0x80, 0x08, 1, "h")},
+}
+
+// Assembles a raw wire encoding from literal bytes and strings.
+func wire(parts ...interface{}) []byte {
+	var data []byte
+	for _, part := range parts {
+		switch part := part.(type) {
+		case byte:
+			data = append(data, part)
+		case int:
+			data = append(data, byte(part))
+		case string:
+			data = append(data, part...)
+		default:
+			panic("unsupported wire part")
+		}
+	}
+	return data
+}
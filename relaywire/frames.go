@@ -0,0 +1,945 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the frame types of both protocol directions.
+
+package relaywire
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Protocol extension features, enabled by appending "+feature" to the protocol
+// version of the connection initiation.
+const (
+	FeatureAuth    = "auth"    // Credentials carried in the connection initiation
+	FeatureConfirm = "confirm" // Publishes acknowledged by the relay
+	FeatureCRC     = "crc32"   // Frames trailed by their CRC32 checksum
+	FeatureHeader  = "tunhdr"  // Tunnel constructions carrying an application header
+	FeatureAbort   = "abort"   // Tunnel terminations carrying a reason to the remote end
+)
+
+// Protocol frame, sent either by a binding or by the relay.
+type Frame interface {
+	// Opcode of the frame on the wire.
+	Opcode() byte
+
+	encode(w *Writer) error
+	decode(r *Reader) error
+}
+
+// Serializes a frame, opcode included, flushing it into the writer's stream.
+func WriteFrame(w *Writer, frame Frame) error {
+	w.WriteByte(frame.Opcode())
+	if err := frame.encode(w); err != nil {
+		w.buf, w.crc = w.buf[:0], 0
+		return err
+	}
+	if w.checksum {
+		return w.seal()
+	}
+	return w.Flush()
+}
+
+// Retrieves the next frame sent by a binding. If r is a scratch reader, the
+// binary fields of the frame are only valid until the next read.
+func ReadClientFrame(r *Reader) (Frame, error) {
+	op, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if r.scratch {
+		r.recycle()
+	}
+	frame, err := decodeClientFrame(r, op)
+	return r.finish(op, frame, err)
+}
+
+// Decodes the fields of a binding frame with the given opcode.
+func decodeClientFrame(r *Reader, op byte) (Frame, error) {
+	var frame Frame
+	switch op {
+	case OpInit:
+		frame = new(Init)
+	case OpClose:
+		frame = new(Close)
+	case OpBroadcast:
+		frame = new(Broadcast)
+	case OpRequest:
+		frame = new(Request)
+	case OpReply:
+		frame = new(Reply)
+	case OpSubscribe:
+		frame = new(Subscribe)
+	case OpUnsubscribe:
+		frame = new(Unsubscribe)
+	case OpPublish:
+		frame = new(Publish)
+	case OpTunInit:
+		frame = new(TunnelInit)
+	case OpTunConfirm:
+		frame = new(TunnelConfirm)
+	case OpTunAllow:
+		frame = new(TunnelAllow)
+	case OpTunTransfer:
+		frame = new(TunnelTransfer)
+	case OpTunClose:
+		frame = new(TunnelClose)
+	case OpPubConfirm:
+		frame = new(PublishConfirm)
+	case OpTunHeader:
+		frame = new(TunnelInitHeader)
+	case OpTunAbort:
+		frame = new(TunnelAbort)
+	default:
+		return nil, fmt.Errorf("protocol violation: unknown opcode: %v", op)
+	}
+	return frame, frame.decode(r)
+}
+
+// Recycled frames of the data carrying relay opcodes, owned by a scratch reader.
+type frameCache struct {
+	broadcast BroadcastDelivery
+	request   RequestDelivery
+	reply     ReplyDelivery
+	publish   PublishDelivery
+	allow     TunnelAllow
+	transfer  TunnelTransfer
+}
+
+// Retrieves the next frame sent by the relay. If r is a scratch reader, the
+// frame is only valid until the next read.
+func ReadRelayFrame(r *Reader) (Frame, error) {
+	op, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if r.scratch {
+		r.recycle()
+		if frame := r.frames.reuse(op); frame != nil {
+			return r.finish(op, frame, frame.decode(r))
+		}
+	}
+	frame, err := decodeRelayFrame(r, op)
+	return r.finish(op, frame, err)
+}
+
+// Decodes the fields of a relay frame with the given opcode.
+func decodeRelayFrame(r *Reader, op byte) (Frame, error) {
+	var frame Frame
+	switch op {
+	case OpInit:
+		frame = new(InitAccept)
+	case OpDeny:
+		frame = new(InitDeny)
+	case OpClose:
+		frame = new(CloseNotify)
+	case OpBroadcast:
+		frame = new(BroadcastDelivery)
+	case OpRequest:
+		frame = new(RequestDelivery)
+	case OpReply:
+		frame = new(ReplyDelivery)
+	case OpPublish:
+		frame = new(PublishDelivery)
+	case OpTunInit:
+		frame = new(TunnelInitDelivery)
+	case OpTunConfirm:
+		frame = new(TunnelResult)
+	case OpTunAllow:
+		frame = new(TunnelAllow)
+	case OpTunTransfer:
+		frame = new(TunnelTransfer)
+	case OpTunClose:
+		frame = new(TunnelCloseNotify)
+	case OpPubConfirm:
+		frame = new(PublishAck)
+	case OpTunHeader:
+		frame = new(TunnelInitHeaderDelivery)
+	default:
+		return nil, fmt.Errorf("protocol violation: unknown opcode: %v", op)
+	}
+	return frame, frame.decode(r)
+}
+
+// Retrieves the cleared recycled frame of an opcode, or nil if it isn't cached.
+func (c *frameCache) reuse(op byte) Frame {
+	switch op {
+	case OpBroadcast:
+		c.broadcast = BroadcastDelivery{}
+		return &c.broadcast
+	case OpRequest:
+		c.request = RequestDelivery{}
+		return &c.request
+	case OpReply:
+		c.reply = ReplyDelivery{}
+		return &c.reply
+	case OpPublish:
+		c.publish = PublishDelivery{}
+		return &c.publish
+	case OpTunAllow:
+		c.allow = TunnelAllow{}
+		return &c.allow
+	case OpTunTransfer:
+		c.transfer = TunnelTransfer{}
+		return &c.transfer
+	default:
+		return nil
+	}
+}
+
+// Checks whether a version tag requests the given extension feature.
+func hasFeature(version string, feature string) bool {
+	for _, f := range strings.Split(version, "+")[1:] {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// Connection initiation sent by a binding. The credential fields are present
+// only if the version requests the authentication feature.
+type Init struct {
+	Version    string // Protocol version tag, with any requested extension features
+	Cluster    string // Cluster to register into (empty for simple clients)
+	Kind       string // Kind of the presented credential (auth extension)
+	Credential []byte // Credential blob to authenticate with (auth extension)
+}
+
+func (f *Init) Opcode() byte { return OpInit }
+
+func (f *Init) encode(w *Writer) error {
+	if err := w.WriteString(ClientMagic); err != nil {
+		return err
+	}
+	if err := w.WriteString(f.Version); err != nil {
+		return err
+	}
+	if err := w.WriteString(f.Cluster); err != nil {
+		return err
+	}
+	if !hasFeature(f.Version, FeatureAuth) {
+		return nil
+	}
+	if err := w.WriteString(f.Kind); err != nil {
+		return err
+	}
+	return w.WriteBinary(f.Credential)
+}
+
+func (f *Init) decode(r *Reader) (err error) {
+	magic, err := r.ReadString()
+	if err != nil {
+		return err
+	}
+	if magic != ClientMagic {
+		return fmt.Errorf("protocol violation: invalid client magic: %s", magic)
+	}
+	if f.Version, err = r.ReadString(); err != nil {
+		return err
+	}
+	if f.Cluster, err = r.ReadString(); err != nil {
+		return err
+	}
+	if !hasFeature(f.Version, FeatureAuth) {
+		return nil
+	}
+	if f.Kind, err = r.ReadString(); err != nil {
+		return err
+	}
+	f.Credential, err = r.ReadBinary()
+	return err
+}
+
+// Connection acceptance sent by the relay.
+type InitAccept struct {
+	Version string // Protocol version tag, with any agreed extension features
+}
+
+func (f *InitAccept) Opcode() byte { return OpInit }
+
+func (f *InitAccept) encode(w *Writer) error {
+	if err := w.WriteString(RelayMagic); err != nil {
+		return err
+	}
+	return w.WriteString(f.Version)
+}
+
+func (f *InitAccept) decode(r *Reader) (err error) {
+	if err = readRelayMagic(r); err != nil {
+		return err
+	}
+	f.Version, err = r.ReadString()
+	return err
+}
+
+// Connection refusal sent by the relay.
+type InitDeny struct {
+	Reason string // Reason for refusing the connection
+}
+
+func (f *InitDeny) Opcode() byte { return OpDeny }
+
+func (f *InitDeny) encode(w *Writer) error {
+	if err := w.WriteString(RelayMagic); err != nil {
+		return err
+	}
+	return w.WriteString(f.Reason)
+}
+
+func (f *InitDeny) decode(r *Reader) (err error) {
+	if err = readRelayMagic(r); err != nil {
+		return err
+	}
+	f.Reason, err = r.ReadString()
+	return err
+}
+
+// Retrieves and verifies the magic string opening the relay handshake.
+func readRelayMagic(r *Reader) error {
+	magic, err := r.ReadString()
+	if err != nil {
+		return err
+	}
+	if magic != RelayMagic {
+		return fmt.Errorf("protocol violation: invalid relay magic: %s", magic)
+	}
+	return nil
+}
+
+// Connection tear-down initiation sent by a binding.
+type Close struct{}
+
+func (f *Close) Opcode() byte           { return OpClose }
+func (f *Close) encode(w *Writer) error { return nil }
+func (f *Close) decode(r *Reader) error { return nil }
+
+// Connection tear-down notification sent by the relay.
+type CloseNotify struct {
+	Reason string // Reason of the tear-down (empty if graceful)
+}
+
+func (f *CloseNotify) Opcode() byte           { return OpClose }
+func (f *CloseNotify) encode(w *Writer) error { return w.WriteString(f.Reason) }
+
+func (f *CloseNotify) decode(r *Reader) (err error) {
+	f.Reason, err = r.ReadString()
+	return err
+}
+
+// Application broadcast initiation sent by a binding.
+type Broadcast struct {
+	Cluster string // Cluster to broadcast to
+	Message []byte // Message to broadcast
+}
+
+func (f *Broadcast) Opcode() byte { return OpBroadcast }
+
+func (f *Broadcast) encode(w *Writer) error {
+	if err := w.WriteString(f.Cluster); err != nil {
+		return err
+	}
+	return w.WriteBinary(f.Message)
+}
+
+func (f *Broadcast) decode(r *Reader) (err error) {
+	if f.Cluster, err = r.ReadString(); err != nil {
+		return err
+	}
+	f.Message, err = r.ReadBinary()
+	return err
+}
+
+// Application broadcast delivery sent by the relay.
+type BroadcastDelivery struct {
+	Message []byte // Broadcast message
+}
+
+func (f *BroadcastDelivery) Opcode() byte           { return OpBroadcast }
+func (f *BroadcastDelivery) encode(w *Writer) error { return w.WriteBinary(f.Message) }
+
+func (f *BroadcastDelivery) decode(r *Reader) (err error) {
+	f.Message, err = r.ReadBinary()
+	return err
+}
+
+// Application request initiation sent by a binding.
+type Request struct {
+	ID      uint64 // Binding side id of the request
+	Cluster string // Cluster to send the request to
+	Request []byte // Request payload
+	Timeout uint64 // Timeout of the request in milliseconds
+}
+
+func (f *Request) Opcode() byte { return OpRequest }
+
+func (f *Request) encode(w *Writer) error {
+	if err := w.WriteVarint(f.ID); err != nil {
+		return err
+	}
+	if err := w.WriteString(f.Cluster); err != nil {
+		return err
+	}
+	if err := w.WriteBinary(f.Request); err != nil {
+		return err
+	}
+	return w.WriteVarint(f.Timeout)
+}
+
+func (f *Request) decode(r *Reader) (err error) {
+	if f.ID, err = r.ReadVarint(); err != nil {
+		return err
+	}
+	if f.Cluster, err = r.ReadString(); err != nil {
+		return err
+	}
+	if f.Request, err = r.ReadBinary(); err != nil {
+		return err
+	}
+	f.Timeout, err = r.ReadVarint()
+	return err
+}
+
+// Application request delivery sent by the relay.
+type RequestDelivery struct {
+	ID      uint64 // Relay side id of the request, to reply with
+	Request []byte // Request payload
+	Timeout uint64 // Time allowance of the request in milliseconds
+}
+
+func (f *RequestDelivery) Opcode() byte { return OpRequest }
+
+func (f *RequestDelivery) encode(w *Writer) error {
+	if err := w.WriteVarint(f.ID); err != nil {
+		return err
+	}
+	if err := w.WriteBinary(f.Request); err != nil {
+		return err
+	}
+	return w.WriteVarint(f.Timeout)
+}
+
+func (f *RequestDelivery) decode(r *Reader) (err error) {
+	if f.ID, err = r.ReadVarint(); err != nil {
+		return err
+	}
+	if f.Request, err = r.ReadBinary(); err != nil {
+		return err
+	}
+	f.Timeout, err = r.ReadVarint()
+	return err
+}
+
+// Application reply initiation sent by a binding. An empty fault signals a
+// successful reply.
+type Reply struct {
+	ID    uint64 // Relay side id of the request being replied to
+	Reply []byte // Reply payload if successful
+	Fault string // Failure reason if unsuccessful
+}
+
+func (f *Reply) Opcode() byte { return OpReply }
+
+func (f *Reply) encode(w *Writer) error {
+	if err := w.WriteVarint(f.ID); err != nil {
+		return err
+	}
+	success := (len(f.Fault) == 0)
+	if err := w.WriteBool(success); err != nil {
+		return err
+	}
+	if success {
+		return w.WriteBinary(f.Reply)
+	}
+	return w.WriteString(f.Fault)
+}
+
+func (f *Reply) decode(r *Reader) (err error) {
+	if f.ID, err = r.ReadVarint(); err != nil {
+		return err
+	}
+	success, err := r.ReadBool()
+	if err != nil {
+		return err
+	}
+	if success {
+		f.Reply, err = r.ReadBinary()
+	} else {
+		f.Fault, err = r.ReadString()
+	}
+	return err
+}
+
+// Application reply delivery sent by the relay. If the request timed out, all
+// other fields are empty, otherwise an empty fault signals success.
+type ReplyDelivery struct {
+	ID      uint64 // Binding side id of the request
+	Timeout bool   // Whether the request timed out
+	Reply   []byte // Reply payload if successful
+	Fault   string // Failure reason if unsuccessful
+}
+
+func (f *ReplyDelivery) Opcode() byte { return OpReply }
+
+func (f *ReplyDelivery) encode(w *Writer) error {
+	if err := w.WriteVarint(f.ID); err != nil {
+		return err
+	}
+	if err := w.WriteBool(f.Timeout); err != nil {
+		return err
+	}
+	if f.Timeout {
+		return nil
+	}
+	success := (len(f.Fault) == 0)
+	if err := w.WriteBool(success); err != nil {
+		return err
+	}
+	if success {
+		return w.WriteBinary(f.Reply)
+	}
+	return w.WriteString(f.Fault)
+}
+
+func (f *ReplyDelivery) decode(r *Reader) (err error) {
+	if f.ID, err = r.ReadVarint(); err != nil {
+		return err
+	}
+	if f.Timeout, err = r.ReadBool(); err != nil || f.Timeout {
+		return err
+	}
+	success, err := r.ReadBool()
+	if err != nil {
+		return err
+	}
+	if success {
+		f.Reply, err = r.ReadBinary()
+	} else {
+		f.Fault, err = r.ReadString()
+	}
+	return err
+}
+
+// Topic subscription sent by a binding.
+type Subscribe struct {
+	Topic string // Topic to subscribe to
+}
+
+func (f *Subscribe) Opcode() byte           { return OpSubscribe }
+func (f *Subscribe) encode(w *Writer) error { return w.WriteString(f.Topic) }
+
+func (f *Subscribe) decode(r *Reader) (err error) {
+	f.Topic, err = r.ReadString()
+	return err
+}
+
+// Topic subscription removal sent by a binding.
+type Unsubscribe struct {
+	Topic string // Topic to unsubscribe from
+}
+
+func (f *Unsubscribe) Opcode() byte           { return OpUnsubscribe }
+func (f *Unsubscribe) encode(w *Writer) error { return w.WriteString(f.Topic) }
+
+func (f *Unsubscribe) decode(r *Reader) (err error) {
+	f.Topic, err = r.ReadString()
+	return err
+}
+
+// Topic event publish sent by a binding.
+type Publish struct {
+	Topic string // Topic to publish to
+	Event []byte // Event to publish
+}
+
+func (f *Publish) Opcode() byte { return OpPublish }
+
+func (f *Publish) encode(w *Writer) error {
+	if err := w.WriteString(f.Topic); err != nil {
+		return err
+	}
+	return w.WriteBinary(f.Event)
+}
+
+func (f *Publish) decode(r *Reader) (err error) {
+	if f.Topic, err = r.ReadString(); err != nil {
+		return err
+	}
+	f.Event, err = r.ReadBinary()
+	return err
+}
+
+// Topic event delivery sent by the relay.
+type PublishDelivery struct {
+	Topic string // Topic the event was published to
+	Event []byte // Published event
+}
+
+func (f *PublishDelivery) Opcode() byte { return OpPublish }
+
+func (f *PublishDelivery) encode(w *Writer) error {
+	if err := w.WriteString(f.Topic); err != nil {
+		return err
+	}
+	return w.WriteBinary(f.Event)
+}
+
+func (f *PublishDelivery) decode(r *Reader) (err error) {
+	if f.Topic, err = r.ReadString(); err != nil {
+		return err
+	}
+	f.Event, err = r.ReadBinary()
+	return err
+}
+
+// Topic event publish sent by a binding, to be acknowledged by the relay with
+// a PublishAck (confirm extension).
+type PublishConfirm struct {
+	ID    uint64 // Binding side id of the publish
+	Topic string // Topic to publish to
+	Event []byte // Event to publish
+}
+
+func (f *PublishConfirm) Opcode() byte { return OpPubConfirm }
+
+func (f *PublishConfirm) encode(w *Writer) error {
+	if err := w.WriteVarint(f.ID); err != nil {
+		return err
+	}
+	if err := w.WriteString(f.Topic); err != nil {
+		return err
+	}
+	return w.WriteBinary(f.Event)
+}
+
+func (f *PublishConfirm) decode(r *Reader) (err error) {
+	if f.ID, err = r.ReadVarint(); err != nil {
+		return err
+	}
+	if f.Topic, err = r.ReadString(); err != nil {
+		return err
+	}
+	f.Event, err = r.ReadBinary()
+	return err
+}
+
+// Acknowledgement of a confirmed publish sent by the relay (confirm extension).
+type PublishAck struct {
+	ID          uint64 // Binding side id of the publish
+	Subscribers uint64 // Number of subscribers at the time of the publish
+}
+
+func (f *PublishAck) Opcode() byte { return OpPubConfirm }
+
+func (f *PublishAck) encode(w *Writer) error {
+	if err := w.WriteVarint(f.ID); err != nil {
+		return err
+	}
+	return w.WriteVarint(f.Subscribers)
+}
+
+func (f *PublishAck) decode(r *Reader) (err error) {
+	if f.ID, err = r.ReadVarint(); err != nil {
+		return err
+	}
+	f.Subscribers, err = r.ReadVarint()
+	return err
+}
+
+// Tunnel construction request sent by a binding.
+type TunnelInit struct {
+	ID      uint64 // Binding side id of the tunnel
+	Cluster string // Cluster to open the tunnel into
+	Timeout uint64 // Timeout of the construction in milliseconds
+}
+
+func (f *TunnelInit) Opcode() byte { return OpTunInit }
+
+func (f *TunnelInit) encode(w *Writer) error {
+	if err := w.WriteVarint(f.ID); err != nil {
+		return err
+	}
+	if err := w.WriteString(f.Cluster); err != nil {
+		return err
+	}
+	return w.WriteVarint(f.Timeout)
+}
+
+func (f *TunnelInit) decode(r *Reader) (err error) {
+	if f.ID, err = r.ReadVarint(); err != nil {
+		return err
+	}
+	if f.Cluster, err = r.ReadString(); err != nil {
+		return err
+	}
+	f.Timeout, err = r.ReadVarint()
+	return err
+}
+
+// Tunnel initiation sent by the relay to the chosen remote endpoint.
+type TunnelInitDelivery struct {
+	ID         uint64 // Relay side id of the tunnel build, to confirm with
+	ChunkLimit uint64 // Maximum size of a data chunk
+}
+
+func (f *TunnelInitDelivery) Opcode() byte { return OpTunInit }
+
+func (f *TunnelInitDelivery) encode(w *Writer) error {
+	if err := w.WriteVarint(f.ID); err != nil {
+		return err
+	}
+	return w.WriteVarint(f.ChunkLimit)
+}
+
+func (f *TunnelInitDelivery) decode(r *Reader) (err error) {
+	if f.ID, err = r.ReadVarint(); err != nil {
+		return err
+	}
+	f.ChunkLimit, err = r.ReadVarint()
+	return err
+}
+
+// Tunnel construction request sent by a binding, carrying an opaque header to
+// be delivered along with the initiation (header extension).
+type TunnelInitHeader struct {
+	ID      uint64 // Binding side id of the tunnel
+	Cluster string // Cluster to open the tunnel into
+	Timeout uint64 // Timeout of the construction in milliseconds
+	Header  []byte // Application header of the tunnel
+}
+
+func (f *TunnelInitHeader) Opcode() byte { return OpTunHeader }
+
+func (f *TunnelInitHeader) encode(w *Writer) error {
+	if err := w.WriteVarint(f.ID); err != nil {
+		return err
+	}
+	if err := w.WriteString(f.Cluster); err != nil {
+		return err
+	}
+	if err := w.WriteVarint(f.Timeout); err != nil {
+		return err
+	}
+	return w.WriteBinary(f.Header)
+}
+
+func (f *TunnelInitHeader) decode(r *Reader) (err error) {
+	if f.ID, err = r.ReadVarint(); err != nil {
+		return err
+	}
+	if f.Cluster, err = r.ReadString(); err != nil {
+		return err
+	}
+	if f.Timeout, err = r.ReadVarint(); err != nil {
+		return err
+	}
+	f.Header, err = r.ReadBinary()
+	return err
+}
+
+// Tunnel initiation sent by the relay to the chosen remote endpoint, carrying
+// the header of the initiator (header extension).
+type TunnelInitHeaderDelivery struct {
+	ID         uint64 // Relay side id of the tunnel build, to confirm with
+	ChunkLimit uint64 // Maximum size of a data chunk
+	Header     []byte // Application header of the tunnel
+}
+
+func (f *TunnelInitHeaderDelivery) Opcode() byte { return OpTunHeader }
+
+func (f *TunnelInitHeaderDelivery) encode(w *Writer) error {
+	if err := w.WriteVarint(f.ID); err != nil {
+		return err
+	}
+	if err := w.WriteVarint(f.ChunkLimit); err != nil {
+		return err
+	}
+	return w.WriteBinary(f.Header)
+}
+
+func (f *TunnelInitHeaderDelivery) decode(r *Reader) (err error) {
+	if f.ID, err = r.ReadVarint(); err != nil {
+		return err
+	}
+	if f.ChunkLimit, err = r.ReadVarint(); err != nil {
+		return err
+	}
+	f.Header, err = r.ReadBinary()
+	return err
+}
+
+// Tunnel confirmation sent by the binding accepting a tunnel initiation.
+type TunnelConfirm struct {
+	BuildID uint64 // Relay side id of the tunnel build
+	ID      uint64 // Binding side id assigned to the tunnel
+}
+
+func (f *TunnelConfirm) Opcode() byte { return OpTunConfirm }
+
+func (f *TunnelConfirm) encode(w *Writer) error {
+	if err := w.WriteVarint(f.BuildID); err != nil {
+		return err
+	}
+	return w.WriteVarint(f.ID)
+}
+
+func (f *TunnelConfirm) decode(r *Reader) (err error) {
+	if f.BuildID, err = r.ReadVarint(); err != nil {
+		return err
+	}
+	f.ID, err = r.ReadVarint()
+	return err
+}
+
+// Tunnel construction result sent by the relay to the initiating binding. If
+// the construction timed out, the chunk limit is not present.
+type TunnelResult struct {
+	ID         uint64 // Binding side id of the tunnel
+	Timeout    bool   // Whether the construction timed out
+	ChunkLimit uint64 // Maximum size of a data chunk
+}
+
+func (f *TunnelResult) Opcode() byte { return OpTunConfirm }
+
+func (f *TunnelResult) encode(w *Writer) error {
+	if err := w.WriteVarint(f.ID); err != nil {
+		return err
+	}
+	if err := w.WriteBool(f.Timeout); err != nil {
+		return err
+	}
+	if f.Timeout {
+		return nil
+	}
+	return w.WriteVarint(f.ChunkLimit)
+}
+
+func (f *TunnelResult) decode(r *Reader) (err error) {
+	if f.ID, err = r.ReadVarint(); err != nil {
+		return err
+	}
+	if f.Timeout, err = r.ReadBool(); err != nil || f.Timeout {
+		return err
+	}
+	f.ChunkLimit, err = r.ReadVarint()
+	return err
+}
+
+// Tunnel transfer allowance, sent in both directions.
+type TunnelAllow struct {
+	ID    uint64 // Id of the tunnel on the receiving side
+	Space uint64 // Additional data space granted
+}
+
+func (f *TunnelAllow) Opcode() byte { return OpTunAllow }
+
+func (f *TunnelAllow) encode(w *Writer) error {
+	if err := w.WriteVarint(f.ID); err != nil {
+		return err
+	}
+	return w.WriteVarint(f.Space)
+}
+
+func (f *TunnelAllow) decode(r *Reader) (err error) {
+	if f.ID, err = r.ReadVarint(); err != nil {
+		return err
+	}
+	f.Space, err = r.ReadVarint()
+	return err
+}
+
+// Tunnel data exchange, sent in both directions. The size is the total message
+// size for the first chunk of a message and zero for continuations.
+type TunnelTransfer struct {
+	ID      uint64 // Id of the tunnel on the receiving side
+	Size    uint64 // Total message size, or zero for a continuation chunk
+	Payload []byte // Chunk of the message
+}
+
+func (f *TunnelTransfer) Opcode() byte { return OpTunTransfer }
+
+func (f *TunnelTransfer) encode(w *Writer) error {
+	if err := w.WriteVarint(f.ID); err != nil {
+		return err
+	}
+	if err := w.WriteVarint(f.Size); err != nil {
+		return err
+	}
+	return w.WriteBinary(f.Payload)
+}
+
+func (f *TunnelTransfer) decode(r *Reader) (err error) {
+	if f.ID, err = r.ReadVarint(); err != nil {
+		return err
+	}
+	if f.Size, err = r.ReadVarint(); err != nil {
+		return err
+	}
+	f.Payload, err = r.ReadBinary()
+	return err
+}
+
+// Tunnel termination request sent by a binding.
+type TunnelClose struct {
+	ID uint64 // Binding side id of the tunnel
+}
+
+func (f *TunnelClose) Opcode() byte           { return OpTunClose }
+func (f *TunnelClose) encode(w *Writer) error { return w.WriteVarint(f.ID) }
+
+func (f *TunnelClose) decode(r *Reader) (err error) {
+	f.ID, err = r.ReadVarint()
+	return err
+}
+
+// Tunnel termination request sent by a binding, with the reason to notify the
+// remote end of (abort extension).
+type TunnelAbort struct {
+	ID     uint64 // Binding side id of the tunnel
+	Reason string // Reason of the termination
+}
+
+func (f *TunnelAbort) Opcode() byte { return OpTunAbort }
+
+func (f *TunnelAbort) encode(w *Writer) error {
+	if err := w.WriteVarint(f.ID); err != nil {
+		return err
+	}
+	return w.WriteString(f.Reason)
+}
+
+func (f *TunnelAbort) decode(r *Reader) (err error) {
+	if f.ID, err = r.ReadVarint(); err != nil {
+		return err
+	}
+	f.Reason, err = r.ReadString()
+	return err
+}
+
+// Tunnel termination notification sent by the relay.
+type TunnelCloseNotify struct {
+	ID     uint64 // Binding side id of the tunnel
+	Reason string // Reason of the termination (empty if graceful)
+}
+
+func (f *TunnelCloseNotify) Opcode() byte { return OpTunClose }
+
+func (f *TunnelCloseNotify) encode(w *Writer) error {
+	if err := w.WriteVarint(f.ID); err != nil {
+		return err
+	}
+	return w.WriteString(f.Reason)
+}
+
+func (f *TunnelCloseNotify) decode(r *Reader) (err error) {
+	if f.ID, err = r.ReadVarint(); err != nil {
+		return err
+	}
+	f.Reason, err = r.ReadString()
+	return err
+}
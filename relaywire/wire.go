@@ -0,0 +1,309 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Package relaywire implements the wire protocol spoken between the Iris relay
+// endpoint and the language bindings, so that alternative bindings or relay test
+// doubles can be built on top of it.
+//
+// The specification version implemented is v1.0-draft2, available at:
+// http://iris.karalabe.com/specs/relay-protocol-v1.0-draft2.pdf
+//
+// Each frame consists of a single opcode byte followed by the frame's fields.
+// Integers are encoded as base 128 varints (least significant group first),
+// booleans as a single 0 or 1 byte, whereas binary blobs and strings are
+// prefixed by their length as a varint. The frames sent by the bindings and by
+// the relay are distinct even where they share an opcode, hence both sides are
+// represented by their own frame types.
+package relaywire
+
+import (
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// Packet opcodes
+const (
+	OpInit  byte = 0x00 // Client: connection initiation           | Relay: connection acceptance
+	OpDeny  byte = 0x01 // Client: <never sent>                    | Relay: connection refusal
+	OpClose byte = 0x02 // Client: connection tear-down initiation | Relay: connection tear-down notification
+
+	OpBroadcast byte = 0x03 // Client: application broadcast initiation | Relay: application broadcast delivery
+	OpRequest   byte = 0x04 // Client: application request initiation   | Relay: application request delivery
+	OpReply     byte = 0x05 // Client: application reply initiation     | Relay: application reply delivery
+
+	OpSubscribe   byte = 0x06 // Client: topic subscription         | Relay: <never sent>
+	OpUnsubscribe byte = 0x07 // Client: topic subscription removal | Relay: <never sent>
+	OpPublish     byte = 0x08 // Client: topic event publish        | Relay: topic event delivery
+
+	OpTunInit     byte = 0x09 // Client: tunnel construction request | Relay: tunnel initiation
+	OpTunConfirm  byte = 0x0a // Client: tunnel confirmation         | Relay: tunnel construction result
+	OpTunAllow    byte = 0x0b // Client: tunnel transfer allowance   | Relay: <same as client>
+	OpTunTransfer byte = 0x0c // Client: tunnel data exchange        | Relay: <same as client>
+	OpTunClose    byte = 0x0d // Client: tunnel termination request  | Relay: tunnel termination notification
+
+	OpPubConfirm byte = 0x0e // Client: confirmed topic event publish | Relay: publish acknowledgement (confirm extension)
+	OpTunHeader  byte = 0x0f // Client: tunnel request with header    | Relay: tunnel initiation with header (header extension)
+	OpTunAbort   byte = 0x10 // Client: tunnel termination with reason | Relay: <never sent> (abort extension)
+)
+
+// Protocol constants
+const (
+	ProtoVersion = "v1.0-draft2"       // Protocol version implemented
+	ClientMagic  = "iris-client-magic" // Magic string opening the binding handshake
+	RelayMagic   = "iris-relay-magic"  // Magic string opening the relay handshake
+)
+
+// Sink of the serialized protocol fields, satisfied by bufio.Writer.
+type ByteWriter interface {
+	io.Writer
+	io.ByteWriter
+}
+
+// Source of the serialized protocol fields, satisfied by bufio.Reader.
+type ByteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// Size from which binary fields bypass the scratch buffer, being written into
+// the output stream directly instead of being copied twice.
+const directWriteThreshold = 4096
+
+// Capacity above which the scratch buffer is released after a flush instead of
+// being retained for the next frame.
+const maxRetainedScratch = 64 * 1024
+
+// Serializer of the protocol fields into a stream.
+//
+// The fields are assembled in a scratch buffer reused across frames, which is
+// written out in one go upon flushing, so encoding a frame doesn't allocate.
+// Large binary fields are passed to the stream directly, after flushing the
+// fields preceding them. A writer may be reused for many frames, even across
+// output streams, but it is not safe for concurrent use.
+type Writer struct {
+	out ByteWriter // Stream to write the assembled fields into
+	buf []byte     // Scratch buffer of the fields not yet flushed
+
+	checksum bool   // Whether frames are trailed by their checksum
+	crc      uint32 // Running checksum of the flushed fields of the current frame
+}
+
+// Creates a new field serializer writing into out.
+func NewWriter(out ByteWriter) *Writer {
+	return &Writer{out: out}
+}
+
+// Switches the writer to a new output stream, dropping any unflushed fields.
+func (w *Writer) Reset(out ByteWriter) {
+	w.out, w.buf = out, w.buf[:0]
+}
+
+// Writes out the fields assembled in the scratch buffer.
+func (w *Writer) Flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	if w.checksum {
+		w.crc = crc32.Update(w.crc, castagnoli, w.buf)
+	}
+	return w.flush()
+}
+
+// Writes out the scratch buffer without accumulating it into the checksum.
+func (w *Writer) flush() error {
+	_, err := w.out.Write(w.buf)
+	if cap(w.buf) > maxRetainedScratch {
+		w.buf = nil
+	} else {
+		w.buf = w.buf[:0]
+	}
+	return err
+}
+
+// Serializes a single byte.
+func (w *Writer) WriteByte(data byte) error {
+	w.buf = append(w.buf, data)
+	return nil
+}
+
+// Serializes a boolean.
+func (w *Writer) WriteBool(data bool) error {
+	if data {
+		return w.WriteByte(1)
+	}
+	return w.WriteByte(0)
+}
+
+// Serializes a variable int using base 128 encoding.
+func (w *Writer) WriteVarint(data uint64) error {
+	for data > 127 {
+		// Internal byte, set the continuation flag
+		w.buf = append(w.buf, byte(128+data%128))
+		data /= 128
+	}
+	// Final byte
+	w.buf = append(w.buf, byte(data))
+	return nil
+}
+
+// Serializes a length-tagged binary array.
+func (w *Writer) WriteBinary(data []byte) error {
+	w.WriteVarint(uint64(len(data)))
+	if len(data) < directWriteThreshold {
+		w.buf = append(w.buf, data...)
+		return nil
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if w.checksum {
+		w.crc = crc32.Update(w.crc, castagnoli, data)
+	}
+	_, err := w.out.Write(data)
+	return err
+}
+
+// Serializes a length-tagged string.
+func (w *Writer) WriteString(data string) error {
+	w.WriteVarint(uint64(len(data)))
+	w.buf = append(w.buf, data...)
+	return nil
+}
+
+// Deserializer of the protocol fields from a stream.
+//
+// By default every decoded frame and binary field is freshly allocated and
+// owned by the caller. A scratch reader instead decodes the binary fields into
+// an arena reused across frames, and recycles the frames of the data carrying
+// relay opcodes, so both are only valid until the next frame is read, and must
+// be copied if retained longer.
+type Reader struct {
+	in  ByteReader // Stream to read the fields from
+	tmp []byte     // Scratch buffer of the string fields before conversion
+
+	scratch bool        // Whether binary fields and frames are borrowed
+	arena   []byte      // Backing memory of the borrowed binary fields of the current frame
+	frames  *frameCache // Recycled frames of the data carrying relay opcodes
+
+	checksum bool    // Whether frames are trailed by their checksum
+	crc      uint32  // Running checksum of the current frame
+	size     int     // Bytes read of the current frame
+	count    uint64  // Number of frames read
+	one      [1]byte // Scratch space to checksum single bytes
+}
+
+// Creates a new field deserializer reading from in.
+func NewReader(in ByteReader) *Reader {
+	return &Reader{in: in}
+}
+
+// Creates a new field deserializer reading from in, lending out its binary
+// fields and frames only until the next frame is read.
+func NewScratchReader(in ByteReader) *Reader {
+	return &Reader{in: in, scratch: true, frames: new(frameCache)}
+}
+
+// Switches the reader to a new input stream.
+func (r *Reader) Reset(in ByteReader) {
+	r.in = in
+}
+
+// Releases the borrowed fields of the previous frame, before reading a new one.
+func (r *Reader) recycle() {
+	if cap(r.arena) > maxRetainedScratch {
+		r.arena = nil
+	} else {
+		r.arena = r.arena[:0]
+	}
+}
+
+// Retrieves a single byte.
+func (r *Reader) ReadByte() (byte, error) {
+	b, err := r.in.ReadByte()
+	if err == nil && r.checksum {
+		r.one[0] = b
+		r.sum(r.one[:])
+	}
+	return b, err
+}
+
+// Retrieves a boolean.
+func (r *Reader) ReadBool() (bool, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return false, err
+	}
+	switch b {
+	case 0:
+		return false, nil
+	case 1:
+		return true, nil
+	default:
+		return false, fmt.Errorf("protocol violation: invalid boolean value: %v", b)
+	}
+}
+
+// Retrieves a variable int in base 128 encoding.
+func (r *Reader) ReadVarint() (uint64, error) {
+	var num uint64
+	for i := uint(0); ; i++ {
+		chunk, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		num += uint64(chunk&127) << (7 * i)
+		if chunk <= 127 {
+			break
+		}
+	}
+	return num, nil
+}
+
+// Retrieves a length-tagged binary array, borrowed from the arena if this is a
+// scratch reader.
+func (r *Reader) ReadBinary() ([]byte, error) {
+	// Fetch the length of the binary blob
+	size, err := r.ReadVarint()
+	if err != nil {
+		return nil, err
+	}
+	// Fetch the blob itself, into the arena or a fresh slice
+	var data []byte
+	if r.scratch {
+		start := len(r.arena)
+		r.arena = append(r.arena, make([]byte, size)...)
+		data = r.arena[start:len(r.arena):len(r.arena)]
+	} else {
+		data = make([]byte, size)
+	}
+	if _, err := io.ReadFull(r.in, data); err != nil {
+		return nil, err
+	}
+	r.sum(data)
+	return data, nil
+}
+
+// Retrieves a length-tagged string.
+func (r *Reader) ReadString() (string, error) {
+	size, err := r.ReadVarint()
+	if err != nil {
+		return "", err
+	}
+	if uint64(cap(r.tmp)) < size {
+		r.tmp = make([]byte, size)
+	}
+	data := r.tmp[:size]
+	if _, err := io.ReadFull(r.in, data); err != nil {
+		return "", err
+	}
+	r.sum(data)
+	str := string(data)
+	if cap(r.tmp) > maxRetainedScratch {
+		r.tmp = nil
+	}
+	return str, nil
+}
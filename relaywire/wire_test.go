@@ -0,0 +1,256 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package relaywire
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// Tests that all frames encode to and decode from their conformance vectors.
+func TestConformance(t *testing.T) {
+	for _, vector := range Vectors {
+		// Encode the frame and compare with the expected wire format
+		buf := new(bytes.Buffer)
+		out := bufio.NewWriter(buf)
+		if err := WriteFrame(NewWriter(out), vector.Frame); err != nil {
+			t.Errorf("%s: failed to encode frame: %v.", vector.Name, err)
+			continue
+		}
+		out.Flush()
+		if !bytes.Equal(buf.Bytes(), vector.Wire) {
+			t.Errorf("%s: encoding mismatch: have %x, want %x.", vector.Name, buf.Bytes(), vector.Wire)
+		}
+		// Decode the wire format and compare with the original frame
+		read := ReadClientFrame
+		if vector.Relay {
+			read = ReadRelayFrame
+		}
+		in := bytes.NewReader(vector.Wire)
+		frame, err := read(NewReader(in))
+		if err != nil {
+			t.Errorf("%s: failed to decode frame: %v.", vector.Name, err)
+			continue
+		}
+		if in.Len() != 0 {
+			t.Errorf("%s: unconsumed bytes: %d.", vector.Name, in.Len())
+		}
+		if !equalFrames(frame, vector.Frame) {
+			t.Errorf("%s: decoding mismatch: have %+v, want %+v.", vector.Name, frame, vector.Frame)
+		}
+	}
+}
+
+// Tests that malformed streams are rejected.
+func TestMalformed(t *testing.T) {
+	tests := []struct {
+		relay bool
+		data  []byte
+	}{
+		{false, []byte{0xff}},                      // Unknown opcode
+		{true, []byte{OpSubscribe, 0}},             // Client-only opcode from relay
+		{false, wire(OpInit, 3, "bad", 0, 0)},      // Invalid client magic
+		{true, wire(OpDeny, 3, "bad", 0)},          // Invalid relay magic
+		{false, wire(OpReply, 1, 2, 0)},            // Invalid boolean
+		{false, wire(OpBroadcast, 3, "svc", 5, 1)}, // Truncated binary
+	}
+	for i, tt := range tests {
+		read := ReadClientFrame
+		if tt.relay {
+			read = ReadRelayFrame
+		}
+		if frame, err := read(NewReader(bytes.NewReader(tt.data))); err == nil {
+			t.Errorf("test %d: malformed frame accepted: %+v.", i, frame)
+		}
+	}
+}
+
+// Compares two frames, treating nil and empty binary fields as equal.
+func equalFrames(a, b Frame) bool {
+	return reflect.DeepEqual(normalize(a), normalize(b))
+}
+
+// Replaces the empty binary fields of a frame copy with nils.
+func normalize(frame Frame) interface{} {
+	v := reflect.New(reflect.TypeOf(frame).Elem()).Elem()
+	v.Set(reflect.ValueOf(frame).Elem())
+	for i := 0; i < v.NumField(); i++ {
+		if f := v.Field(i); f.Kind() == reflect.Slice && f.Len() == 0 {
+			f.Set(reflect.Zero(f.Type()))
+		}
+	}
+	return v.Interface()
+}
+
+// Tests that a writer can be reused across frames and streams, including large
+// binary fields bypassing the scratch buffer.
+func TestWriterReuse(t *testing.T) {
+	large := bytes.Repeat([]byte{0x42}, 3*directWriteThreshold)
+	frames := []Frame{
+		&Request{ID: 1, Cluster: "svc", Request: []byte("small"), Timeout: 1000},
+		&TunnelTransfer{ID: 2, Size: uint64(len(large)), Payload: large},
+		&Publish{Topic: "topic", Event: []byte("event")},
+	}
+	writer := NewWriter(nil)
+	for i := 0; i < 2; i++ {
+		buf := new(bytes.Buffer)
+		out := bufio.NewWriter(buf)
+		writer.Reset(out)
+		for j, frame := range frames {
+			if err := WriteFrame(writer, frame); err != nil {
+				t.Fatalf("pass %d, frame %d: failed to encode frame: %v.", i, j, err)
+			}
+		}
+		out.Flush()
+
+		in := NewReader(bytes.NewReader(buf.Bytes()))
+		for j, want := range frames {
+			have, err := ReadClientFrame(in)
+			if err != nil {
+				t.Fatalf("pass %d, frame %d: failed to decode frame: %v.", i, j, err)
+			}
+			if !equalFrames(have, want) {
+				t.Fatalf("pass %d, frame %d: decoding mismatch: have %+v, want %+v.", i, j, have, want)
+			}
+		}
+	}
+}
+
+// Benchmarks the encoding of a small request frame through a reused writer.
+func BenchmarkWriteFrame(b *testing.B) {
+	frame := &Request{ID: 1, Cluster: "svc", Request: make([]byte, 128), Timeout: 1000}
+
+	out := bufio.NewWriter(io.Discard)
+	writer := NewWriter(out)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := WriteFrame(writer, frame); err != nil {
+			b.Fatalf("failed to encode frame: %v.", err)
+		}
+	}
+}
+
+// Tests that a scratch reader decodes the same frames as an owning one, and
+// recycles the data carrying frames between reads.
+func TestScratchReader(t *testing.T) {
+	buf := new(bytes.Buffer)
+	out := bufio.NewWriter(buf)
+	writer := NewWriter(out)
+
+	frames := []Frame{
+		&PublishDelivery{Topic: "first", Event: []byte("event-1")},
+		&PublishDelivery{Topic: "second", Event: []byte("event-2")},
+		&ReplyDelivery{ID: 1, Timeout: true},
+		&ReplyDelivery{ID: 2, Reply: []byte{}},
+		&TunnelTransfer{ID: 3, Size: 5, Payload: []byte("chunk")},
+		&CloseNotify{Reason: "bye"},
+	}
+	for i, frame := range frames {
+		if err := WriteFrame(writer, frame); err != nil {
+			t.Fatalf("frame %d: failed to encode frame: %v.", i, err)
+		}
+	}
+	out.Flush()
+
+	reader := NewScratchReader(bytes.NewReader(buf.Bytes()))
+	var prev Frame
+	for i, want := range frames {
+		have, err := ReadRelayFrame(reader)
+		if err != nil {
+			t.Fatalf("frame %d: failed to decode frame: %v.", i, err)
+		}
+		if !equalFrames(have, want) {
+			t.Fatalf("frame %d: decoding mismatch: have %+v, want %+v.", i, have, want)
+		}
+		if i == 1 && have != prev {
+			t.Fatalf("frame %d: publish frame not recycled.", i)
+		}
+		prev = have
+	}
+}
+
+// Benchmarks the decoding of a small publish delivery through a scratch reader.
+func BenchmarkReadFrame(b *testing.B) {
+	buf := new(bytes.Buffer)
+	out := bufio.NewWriter(buf)
+	if err := WriteFrame(NewWriter(out), &PublishDelivery{Topic: "topic", Event: make([]byte, 128)}); err != nil {
+		b.Fatalf("failed to encode frame: %v.", err)
+	}
+	out.Flush()
+
+	in := bytes.NewReader(buf.Bytes())
+	reader := NewScratchReader(in)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		in.Reset(buf.Bytes())
+		if _, err := ReadRelayFrame(reader); err != nil {
+			b.Fatalf("failed to decode frame: %v.", err)
+		}
+	}
+}
+
+// Tests that checksummed frames round trip, and that corruption anywhere in a
+// frame is reported along with the frame's context.
+func TestChecksums(t *testing.T) {
+	frames := []Frame{
+		&BroadcastDelivery{Message: []byte("small payload")},
+		&TunnelTransfer{ID: 3, Size: 8192, Payload: bytes.Repeat([]byte{0x5a}, 2*directWriteThreshold)},
+		&PublishDelivery{Topic: "topic", Event: []byte("event")},
+	}
+	// Encode all the frames with checksums enabled
+	buf := new(bytes.Buffer)
+	out := bufio.NewWriter(buf)
+	w := NewWriter(out)
+	w.SetChecksums(true)
+	for i, frame := range frames {
+		if err := WriteFrame(w, frame); err != nil {
+			t.Fatalf("frame %d: failed to encode: %v.", i, err)
+		}
+	}
+	out.Flush()
+	wire := buf.Bytes()
+
+	// Decode them back and ensure the stream is fully consumed
+	r := NewReader(bytes.NewReader(wire))
+	r.SetChecksums(true)
+	for i, frame := range frames {
+		have, err := ReadRelayFrame(r)
+		if err != nil {
+			t.Fatalf("frame %d: failed to decode: %v.", i, err)
+		}
+		if !equalFrames(have, frame) {
+			t.Fatalf("frame %d: decoding mismatch: have %+v, want %+v.", i, have, frame)
+		}
+	}
+	if _, err := ReadRelayFrame(r); err != io.EOF {
+		t.Fatalf("unconsumed bytes after the frames: %v.", err)
+	}
+	// Corrupt the payload of the second frame and ensure it's detected
+	corrupt := append([]byte(nil), wire...)
+	corrupt[len(corrupt)/2] ^= 0x01
+
+	r = NewReader(bytes.NewReader(corrupt))
+	r.SetChecksums(true)
+	if _, err := ReadRelayFrame(r); err != nil {
+		t.Fatalf("intact frame rejected: %v.", err)
+	}
+	_, err := ReadRelayFrame(r)
+	cerr, ok := err.(*ChecksumError)
+	if !ok {
+		t.Fatalf("corruption not detected: %v.", err)
+	}
+	if cerr.Opcode != OpTunTransfer || cerr.Frame != 1 || cerr.Expected == cerr.Actual {
+		t.Fatalf("invalid corruption context: %+v.", cerr)
+	}
+}
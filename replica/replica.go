@@ -0,0 +1,303 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Package replica contains a key-value map replicated among the members of an
+// Iris cluster, meant for sharing small amounts of soft state (e.g. feature
+// flags) without a central store.
+//
+// The map is a last-writer-wins CRDT: every entry carries the time of its last
+// update and the id of the member issuing it, and concurrent updates of a key
+// are resolved by keeping the later one (the member id breaking ties). Removals
+// leave tombstones behind, so they aren't undone by stale copies. Merging is
+// thus commutative and idempotent, and the members converge regardless of the
+// order the updates arrive in.
+//
+// Updates are broadcast to the cluster as deltas of a single entry. Since the
+// broadcasts are best effort and don't reach members joining later, a member
+// joining the cluster pulls the full state of an existing one through a tunnel
+// (anti-entropy sync) before Join returns. The tunnel may be routed back to the
+// joiner itself, in which case it's retried a few times, the joiner assuming to
+// be alone if it keeps landing on itself.
+//
+// Conflict resolution relies on the members' clocks being roughly in sync.
+package replica
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"gopkg.in/project-iris/iris-go.v1"
+)
+
+// Settings of a replicated map. Any unset fields (i.e. value of zero) will
+// default to the preset ones.
+type Config struct {
+	SyncTimeout  time.Duration // Timeout of the anti-entropy sync operations on join
+	SyncAttempts int           // Tunnels to open in search of a peer to sync from
+
+	OnChange func(key string, value []byte, deleted bool) // Callback notified of remote updates
+}
+
+// Default settings of the replicated maps.
+var defaultConfig = Config{
+	SyncTimeout:  5 * time.Second,
+	SyncAttempts: 8,
+}
+
+// Value of a key along with its version.
+type entry struct {
+	value   []byte // Current value of the key (nil if deleted)
+	stamp   int64  // Time of the last update, in nanoseconds since the epoch
+	origin  string // Id of the member issuing the last update
+	deleted bool   // Whether the key was removed (tombstone)
+}
+
+// Checks whether an entry version supersedes another one.
+func (e *entry) newer(other *entry) bool {
+	if e.stamp != other.stamp {
+		return e.stamp > other.stamp
+	}
+	return e.origin > other.origin
+}
+
+// Key-value map replicated among the members of a cluster.
+type Map struct {
+	cluster string           // Cluster of the members replicating the map
+	config  Config           // Settings of the replica
+	self    string           // Unique id of the local member
+	service *iris.Service    // Service receiving the updates and sync requests
+	conn    *iris.Connection // Connection of the service, for updates and syncs
+
+	entries map[string]*entry // Local replica of the map, including tombstones
+	lock    sync.RWMutex      // Protects the local replica
+}
+
+// Joins the cluster replicating a map, syncing the local replica from one of
+// the existing members.
+func Join(port int, cluster string, config *Config) (*Map, error) {
+	// Sanity check on the arguments
+	if len(cluster) == 0 {
+		return nil, errors.New("empty cluster name")
+	}
+	// Merge the user configs with the defaults
+	conf := defaultConfig
+	if config != nil {
+		if config.SyncTimeout != 0 {
+			conf.SyncTimeout = config.SyncTimeout
+		}
+		if config.SyncAttempts != 0 {
+			conf.SyncAttempts = config.SyncAttempts
+		}
+		conf.OnChange = config.OnChange
+	}
+	// Generate a unique id for the local member
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	m := &Map{
+		cluster: cluster,
+		config:  conf,
+		self:    hex.EncodeToString(id),
+		entries: make(map[string]*entry),
+	}
+	var err error
+	if m.service, err = iris.Register(port, cluster, &replicaHandler{m}, nil); err != nil {
+		return nil, err
+	}
+	m.sync()
+	return m, nil
+}
+
+// Leaves the cluster, dropping the local replica.
+func (m *Map) Close() error {
+	return m.service.Unregister()
+}
+
+// Retrieves the value of a key from the local replica.
+func (m *Map) Get(key string) ([]byte, bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	e, ok := m.entries[key]
+	if !ok || e.deleted {
+		return nil, false
+	}
+	return e.value, true
+}
+
+// Retrieves a copy of all the live entries of the local replica.
+func (m *Map) Snapshot() map[string][]byte {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	snapshot := make(map[string][]byte, len(m.entries))
+	for key, e := range m.entries {
+		if !e.deleted {
+			snapshot[key] = e.value
+		}
+	}
+	return snapshot
+}
+
+// Updates the value of a key, replicating it to the rest of the cluster.
+func (m *Map) Set(key string, value []byte) error {
+	return m.update(key, &entry{value: append([]byte(nil), value...)})
+}
+
+// Removes a key, replicating the removal to the rest of the cluster.
+func (m *Map) Delete(key string) error {
+	return m.update(key, &entry{deleted: true})
+}
+
+// Versions a local update, merges it into the replica and broadcasts it.
+func (m *Map) update(key string, e *entry) error {
+	m.lock.Lock()
+	e.stamp, e.origin = time.Now().UnixNano(), m.self
+	if old, ok := m.entries[key]; ok && old.stamp >= e.stamp {
+		e.stamp = old.stamp + 1 // Ensure local updates always win over known ones
+	}
+	m.entries[key] = e
+	m.lock.Unlock()
+
+	return m.conn.Broadcast(m.cluster, encode(key, e))
+}
+
+// Merges a remote entry version into the local replica, notifying the change
+// callback if it superseded the known one.
+func (m *Map) merge(key string, e *entry) {
+	m.lock.Lock()
+	if old, ok := m.entries[key]; ok && !e.newer(old) {
+		m.lock.Unlock()
+		return
+	}
+	m.entries[key] = e
+	m.lock.Unlock()
+
+	if m.config.OnChange != nil {
+		m.config.OnChange(key, e.value, e.deleted)
+	}
+}
+
+// Pulls the full state of an existing member through a tunnel, merging it into
+// the local replica. Tunnels landing on the local member are retried, and if no
+// peer is found the local replica is left as is.
+func (m *Map) sync() {
+	for i := 0; i < m.config.SyncAttempts; i++ {
+		tun, err := m.conn.Tunnel(m.cluster, m.config.SyncTimeout)
+		if err != nil {
+			m.conn.Log.Debug("no replica to sync from", "cluster", m.cluster, "reason", err)
+			return
+		}
+		done, err := m.pull(tun)
+		tun.Close()
+		if done {
+			return
+		}
+		if err != nil {
+			m.conn.Log.Warn("replica sync failed", "cluster", m.cluster, "reason", err)
+		}
+	}
+}
+
+// Pulls the state of the peer at the remote end of a tunnel, returning whether
+// it completed. A rejected tunnel (i.e. looped back to the local member) is an
+// incomplete sync without failure.
+func (m *Map) pull(tun *iris.Tunnel) (bool, error) {
+	if err := tun.Send(append([]byte{syncHello}, m.self...), m.config.SyncTimeout); err != nil {
+		return false, err
+	}
+	for {
+		msg, err := tun.Recv(m.config.SyncTimeout)
+		if err == iris.ErrClosed {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if len(msg) == 0 {
+			return false, errMalformed
+		}
+		switch msg[0] {
+		case syncEntry:
+			key, e, err := decode(msg[1:])
+			if err != nil {
+				return false, err
+			}
+			m.merge(key, e)
+		case syncDone:
+			return true, nil
+		default:
+			return false, errMalformed
+		}
+	}
+}
+
+// Streams the full state of the local replica through a tunnel opened by a
+// joining member.
+func (m *Map) push(tun *iris.Tunnel) error {
+	hello, err := tun.Recv(m.config.SyncTimeout)
+	if err != nil {
+		return err
+	}
+	if len(hello) == 0 || hello[0] != syncHello {
+		return errMalformed
+	}
+	if string(hello[1:]) == m.self {
+		return nil // Looped back to ourselves, let the joiner retry
+	}
+	// Snapshot the entries and stream them to the joiner
+	m.lock.RLock()
+	msgs := make([][]byte, 0, len(m.entries))
+	for key, e := range m.entries {
+		msgs = append(msgs, append([]byte{syncEntry}, encode(key, e)...))
+	}
+	m.lock.RUnlock()
+
+	for _, msg := range msgs {
+		if err := tun.Send(msg, m.config.SyncTimeout); err != nil {
+			return err
+		}
+	}
+	if err := tun.Send([]byte{syncDone}, m.config.SyncTimeout); err != nil {
+		return err
+	}
+	// Wait for the joiner to tear down the tunnel, so the state isn't cut short
+	tun.Recv(m.config.SyncTimeout)
+	return nil
+}
+
+// Service handler receiving the update broadcasts and the sync tunnels.
+type replicaHandler struct {
+	replica *Map
+}
+
+func (h *replicaHandler) HandleRequest(req []byte) ([]byte, error) { return nil, errMalformed }
+func (h *replicaHandler) HandleDrop(reason error)                  {}
+
+func (h *replicaHandler) Init(conn *iris.Connection) error {
+	h.replica.conn = conn
+	return nil
+}
+
+func (h *replicaHandler) HandleBroadcast(msg []byte) {
+	key, e, err := decode(msg)
+	if err != nil || e.origin == h.replica.self {
+		return
+	}
+	h.replica.merge(key, e)
+}
+
+func (h *replicaHandler) HandleTunnel(tun *iris.Tunnel) {
+	defer tun.Close()
+
+	if err := h.replica.push(tun); err != nil {
+		h.replica.conn.Log.Warn("replica sync push failed", "cluster", h.replica.cluster, "reason", err)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package replica
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// Port of the local relay to run the tests against.
+var relay = 55555
+
+// Tests that updates are replicated to the live members, and that joining ones
+// sync the existing state.
+func TestMap(t *testing.T) {
+	config := &Config{SyncTimeout: time.Second, SyncAttempts: 32}
+	first, err := Join(relay, "test-replica", config)
+	if err != nil {
+		t.Fatalf("first join failed: %v.", err)
+	}
+	defer first.Close()
+
+	second, err := Join(relay, "test-replica", config)
+	if err != nil {
+		t.Fatalf("second join failed: %v.", err)
+	}
+	defer second.Close()
+
+	// Update the map on one member and wait for the other to catch up
+	if err := first.Set("flag", []byte("on")); err != nil {
+		t.Fatalf("set failed: %v.", err)
+	}
+	if err := first.Set("gone", []byte("soon")); err != nil {
+		t.Fatalf("set failed: %v.", err)
+	}
+	if err := first.Delete("gone"); err != nil {
+		t.Fatalf("delete failed: %v.", err)
+	}
+	converged := func() bool {
+		value, ok := second.Get("flag")
+		_, gone := second.Get("gone")
+		return ok && bytes.Equal(value, []byte("on")) && !gone
+	}
+	for start := time.Now(); !converged(); time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatalf("updates not replicated: have %v.", second.Snapshot())
+		}
+	}
+	// Join a third member and ensure it syncs the state before returning
+	third, err := Join(relay, "test-replica", config)
+	if err != nil {
+		t.Fatalf("third join failed: %v.", err)
+	}
+	defer third.Close()
+
+	if snapshot := third.Snapshot(); len(snapshot) != 1 || !bytes.Equal(snapshot["flag"], []byte("on")) {
+		t.Fatalf("synced state mismatch: have %v, want map[flag:on].", snapshot)
+	}
+}
+
+// Tests that remote updates are reported to the change callback.
+func TestMapChanges(t *testing.T) {
+	changes := make(chan string, 1)
+	config := &Config{
+		SyncTimeout: time.Second,
+		OnChange:    func(key string, value []byte, deleted bool) { changes <- key },
+	}
+	first, err := Join(relay, "test-replica", config)
+	if err != nil {
+		t.Fatalf("first join failed: %v.", err)
+	}
+	defer first.Close()
+
+	second, err := Join(relay, "test-replica", config)
+	if err != nil {
+		t.Fatalf("second join failed: %v.", err)
+	}
+	defer second.Close()
+
+	if err := first.Set("flag", []byte("on")); err != nil {
+		t.Fatalf("set failed: %v.", err)
+	}
+	select {
+	case key := <-changes:
+		if key != "flag" {
+			t.Fatalf("changed key mismatch: have %s, want %s.", key, "flag")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("change not reported.")
+	}
+	select {
+	case key := <-changes:
+		t.Fatalf("local change reported: %s.", key)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// Tests that concurrent updates are resolved by the last writer winning.
+func TestMapConflict(t *testing.T) {
+	local := &entry{value: []byte("local"), stamp: 10, origin: "a"}
+	remote := &entry{value: []byte("remote"), stamp: 10, origin: "b"}
+	stale := &entry{value: []byte("stale"), stamp: 5, origin: "z"}
+
+	if !remote.newer(local) || local.newer(remote) {
+		t.Fatalf("origin tie break mismatch.")
+	}
+	if stale.newer(local) || !local.newer(stale) {
+		t.Fatalf("timestamp ordering mismatch.")
+	}
+	m := &Map{entries: map[string]*entry{"key": local}}
+	m.merge("key", stale)
+	m.merge("key", remote)
+	m.merge("key", stale)
+
+	if value, _ := m.Get("key"); !bytes.Equal(value, []byte("remote")) {
+		t.Fatalf("merged value mismatch: have %s, want %s.", value, "remote")
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package replica
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Kinds of the messages exchanged during an anti-entropy sync.
+const (
+	syncHello byte = 0x00 // Joiner: id of the joining member
+	syncEntry byte = 0x01 // Peer: single entry of its state
+	syncDone  byte = 0x02 // Peer: end of its state
+)
+
+// Returned if a replication message cannot be decoded.
+var errMalformed = errors.New("malformed replica message")
+
+// Serializes a map entry for a broadcast or a sync.
+func encode(key string, e *entry) []byte {
+	buf := make([]byte, 0, 1+4*binary.MaxVarintLen64+len(key)+len(e.origin)+len(e.value))
+	buf = appendString(buf, key)
+	buf = appendString(buf, e.origin)
+	buf = binary.AppendUvarint(buf, uint64(e.stamp))
+	if e.deleted {
+		return append(buf, 1)
+	}
+	buf = append(buf, 0)
+	return append(buf, e.value...)
+}
+
+// Deserializes a map entry from a broadcast or a sync.
+func decode(data []byte) (string, *entry, error) {
+	key, data, err := readString(data)
+	if err != nil {
+		return "", nil, err
+	}
+	e := new(entry)
+	if e.origin, data, err = readString(data); err != nil {
+		return "", nil, err
+	}
+	stamp, n := binary.Uvarint(data)
+	if n <= 0 || len(data) == n {
+		return "", nil, errMalformed
+	}
+	e.stamp, e.deleted, e.value = int64(stamp), data[n] == 1, data[n+1:]
+	if e.deleted {
+		e.value = nil
+	}
+	return key, e, nil
+}
+
+// Appends a length prefixed string to a buffer.
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// Reads a length prefixed string from the head of a buffer.
+func readString(data []byte) (string, []byte, error) {
+	size, n := binary.Uvarint(data)
+	if n <= 0 || uint64(len(data)-n) < size {
+		return "", nil, errMalformed
+	}
+	return string(data[n : n+int(size)]), data[n+int(size):], nil
+}
@@ -7,14 +7,16 @@
 package iris
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
-	"github.com/project-iris/iris/pool"
+	"gopkg.in/project-iris/iris-go.v1/internal/pool"
 )
 
 // Service handler for the request/reply tests.
@@ -157,6 +159,30 @@ func TestRequestFail(t *testing.T) {
 	}
 }
 
+// Tests that handler failures mimicking the binding's own can't pass for them.
+func TestRequestFailSpoof(t *testing.T) {
+	handler := new(requestFailTestHandler)
+
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	for i, fault := range []string{ErrOverloaded.Error(), markFault(ErrUnavailable.Error()), markFault(markFault(ErrExpired.Error()))} {
+		_, err := handler.conn.Request(config.cluster, []byte(fault), time.Second)
+		if _, ok := err.(*RemoteError); !ok {
+			t.Fatalf("test %d: request didn't fail remotely: %v.", i, err)
+		}
+		if err.Error() != fault {
+			t.Fatalf("test %d: error message mismatch: have %q, want %q.", i, err, fault)
+		}
+		if errors.Is(err, ErrOverloaded) || errors.Is(err, ErrUnavailable) || errors.Is(err, ErrExpired) || retriable(context.Background(), err) {
+			t.Fatalf("test %d: handler failure misclassified: %v.", i, err)
+		}
+	}
+}
+
 // Service handler for the request/reply limit tests.
 type requestTestTimedHandler struct {
 	conn  *Connection
@@ -339,6 +365,112 @@ func TestRequestExpiration(t *testing.T) {
 	}
 }
 
+// Tests that requests rejected by admission control fail fast as overloaded.
+func TestRequestAdmission(t *testing.T) {
+	// Create the service handler and an admission controller allowing 1 request
+	handler := &requestTestExpiryHandler{
+		sleep: 100 * time.Millisecond,
+	}
+	limits := &ServiceLimits{RequestThreads: 1}
+	options := &Options{
+		Admission: func(pending int, latency time.Duration) bool { return pending == 0 && latency < 50*time.Millisecond },
+	}
+	// Register a new service to the relay
+	serv, err := RegisterWithOptions(config.relay, config.cluster, handler, limits, options)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	// The first request should pass, the second one should be rejected
+	if _, err := handler.conn.Request(config.cluster, []byte{0x00}, time.Second); err != nil {
+		t.Fatalf("first request failed: %v.", err)
+	}
+	start := time.Now()
+	_, err = handler.conn.Request(config.cluster, []byte{0x00}, time.Second)
+	if rerr, ok := err.(*RemoteError); !ok || rerr.Unwrap() != ErrOverloaded {
+		t.Fatalf("overloaded request result mismatch: have %v, want %v.", err, ErrOverloaded)
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Fatalf("rejection took too long: %v.", elapsed)
+	}
+}
+
+// Tests that the outstanding requests to a cluster are capped on the client.
+func TestRequestClusterLimit(t *testing.T) {
+	// Create the service handler
+	handler := &requestTestExpiryHandler{
+		sleep: 100 * time.Millisecond,
+	}
+	// Register a new service to the relay
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	// Connect a failing fast client and ensure the overflow is rejected
+	conn, err := ConnectWithOptions(config.relay, &Options{Outstanding: &ClusterLimit{Requests: 1, FailFast: true}})
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := conn.Request(config.cluster, []byte{0x00}, time.Second)
+		errc <- err
+	}()
+	time.Sleep(25 * time.Millisecond)
+	if _, err := conn.Request(config.cluster, []byte{0x00}, time.Second); err != ErrThrottled {
+		t.Fatalf("overflow request result mismatch: have %v, want %v.", err, ErrThrottled)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("capped request failed: %v.", err)
+	}
+	// Connect a queuing client and ensure the overflow waits for a free slot
+	queued, err := ConnectWithOptions(config.relay, &Options{Outstanding: &ClusterLimit{Requests: 1}})
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer queued.Close()
+
+	go func() {
+		_, err := queued.Request(config.cluster, []byte{0x00}, time.Second)
+		errc <- err
+	}()
+	time.Sleep(25 * time.Millisecond)
+	if _, err := queued.Request(config.cluster, []byte{0x00}, 50*time.Millisecond); err != ErrTimeout {
+		t.Fatalf("queued request result mismatch: have %v, want %v.", err, ErrTimeout)
+	}
+	if _, err := queued.Request(config.cluster, []byte{0x00}, time.Second); err != nil {
+		t.Fatalf("queued request failed: %v.", err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("capped request failed: %v.", err)
+	}
+	// Ensure a queued request gives up as soon as its context is cancelled
+	go func() {
+		_, err := queued.Request(config.cluster, []byte{0x00}, time.Second)
+		errc <- err
+	}()
+	time.Sleep(25 * time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(25*time.Millisecond, cancel)
+
+	start := time.Now()
+	if _, err := queued.RequestContext(ctx, config.cluster, []byte{0x00}, time.Second); err != context.Canceled {
+		t.Fatalf("cancelled request result mismatch: have %v, want %v.", err, context.Canceled)
+	}
+	if elapsed := time.Since(start); elapsed > 60*time.Millisecond {
+		t.Fatalf("cancellation took too long: %v.", elapsed)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("capped request failed: %v.", err)
+	}
+}
+
 // Benchmarks the latency of a single request/reply operation.
 func BenchmarkRequestLatency(b *testing.B) {
 	// Create the service handler
@@ -423,3 +555,126 @@ func benchmarkRequestThroughput(threads int, b *testing.B) {
 	// Stop the timer (don't measure deferred cleanup)
 	b.StopTimer()
 }
+
+// Tests that the age of the oldest queued request is reported, and that expired
+// requests are shed without being executed.
+func TestRequestQueueAge(t *testing.T) {
+	handler := &requestTestExpiryHandler{
+		sleep: 250 * time.Millisecond,
+	}
+	limits := &ServiceLimits{RequestThreads: 1}
+
+	serv, err := RegisterWithOptions(config.relay, config.cluster, handler, limits, &Options{ShedExpired: true})
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	// Occupy the only handler thread and queue a short lived request behind it
+	var pend sync.WaitGroup
+	for _, timeout := range []time.Duration{time.Second, 50 * time.Millisecond} {
+		pend.Add(1)
+		go func(timeout time.Duration) {
+			defer pend.Done()
+			handler.conn.Request(config.cluster, []byte{0x00}, timeout)
+		}(timeout)
+		time.Sleep(25 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if age := handler.conn.Stats().OldestRequestAge; age < 50*time.Millisecond {
+		t.Fatalf("oldest request age too low: have %v, want >= %v.", age, 50*time.Millisecond)
+	}
+	// Wait for the queue to drain and verify the expired request was shed
+	pend.Wait()
+	time.Sleep(300 * time.Millisecond)
+	if age := handler.conn.Stats().OldestRequestAge; age != 0 {
+		t.Fatalf("drained queue age mismatch: have %v, want %v.", age, 0)
+	}
+	if done := atomic.LoadInt32(&handler.done); done != 1 {
+		t.Fatalf("executed request count mismatch: have %v, want %v.", done, 1)
+	}
+}
+
+// Tests that the request thread limit can be raised on a live service.
+func TestRequestSetLimits(t *testing.T) {
+	handler := &requestTestExpiryHandler{
+		sleep: 100 * time.Millisecond,
+	}
+	serv, err := Register(config.relay, config.cluster, handler, &ServiceLimits{RequestThreads: 1})
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	// Measure the time needed to serve a batch of concurrent requests
+	measure := func() time.Duration {
+		start := time.Now()
+
+		var pend sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			pend.Add(1)
+			go func() {
+				defer pend.Done()
+				if _, err := handler.conn.Request(config.cluster, []byte{0x00}, time.Second); err != nil {
+					t.Errorf("request failed: %v.", err)
+				}
+			}()
+		}
+		pend.Wait()
+		return time.Since(start)
+	}
+	if elapsed := measure(); elapsed < 400*time.Millisecond {
+		t.Fatalf("serial batch too fast: have %v, want >= %v.", elapsed, 400*time.Millisecond)
+	}
+	if err := serv.SetLimits(&ServiceLimits{RequestThreads: 4}); err != nil {
+		t.Fatalf("failed to adjust limits: %v.", err)
+	}
+	if elapsed := measure(); elapsed > 300*time.Millisecond {
+		t.Fatalf("parallel batch too slow: have %v, want <= %v.", elapsed, 300*time.Millisecond)
+	}
+}
+
+// Tests that unready services reject new work but finish the in-flight requests.
+func TestRequestReadiness(t *testing.T) {
+	handler := &requestTestExpiryHandler{
+		sleep: 100 * time.Millisecond,
+	}
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	// Start a request, and mark the service unready while it's being processed
+	errc := make(chan error, 1)
+	go func() {
+		_, err := handler.conn.Request(config.cluster, []byte{0x00}, time.Second)
+		errc <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	serv.SetReady(false)
+	if serv.Ready() {
+		t.Fatalf("readiness mismatch: have %v, want %v.", true, false)
+	}
+	// Ensure new requests and tunnels are rejected, but the in-flight one finishes
+	_, err = handler.conn.Request(config.cluster, []byte{0x00}, time.Second)
+	if rerr, ok := err.(*RemoteError); !ok || rerr.Unwrap() != ErrUnavailable {
+		t.Fatalf("unready request result mismatch: have %v, want %v.", err, ErrUnavailable)
+	}
+	if tun, err := handler.conn.Tunnel(config.cluster, time.Second); err == nil {
+		if _, err := tun.Recv(time.Second); err != ErrClosed {
+			t.Fatalf("unready tunnel receive mismatch: have %v, want %v.", err, ErrClosed)
+		}
+		if err := tun.Close(); err == nil || !strings.Contains(err.Error(), ErrUnavailable.Error()) {
+			t.Fatalf("unready tunnel close mismatch: have %v, want %v.", err, ErrUnavailable)
+		}
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("in-flight request failed: %v.", err)
+	}
+	// Ensure requests are accepted again once ready
+	serv.SetReady(true)
+	if _, err := handler.conn.Request(config.cluster, []byte{0x00}, time.Second); err != nil {
+		t.Fatalf("ready request failed: %v.", err)
+	}
+}
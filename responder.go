@@ -0,0 +1,55 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the deferred replies of inbound requests.
+//
+// Request handlers waiting on external events (e.g. a downstream job finishing)
+// would occupy a pool worker for the whole wait. Instead, they may take the
+// Responder of the request from its metadata, return ErrPending to release the
+// worker, and complete the reply later from any goroutine.
+
+package iris
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// Returned by a request handler to mark the reply as deferred: it will be sent
+// through the request's Responder instead of on return.
+var ErrPending = errors.New("reply pending")
+
+// Handle to complete the reply of an inbound request, possibly after its handler
+// returned. Only the first reply (returned or deferred) is delivered.
+type Responder struct {
+	done   int32                                      // Whether the request was already replied to (atomic)
+	finish func(reply []byte, err error, record bool) // Concludes the request, recording the outcome for deduplication
+}
+
+// Retrieves the handle to complete the reply of the request with, or nil if the
+// metadata does not belong to an inbound request.
+//
+// A handler deferring its reply must return ErrPending, and call Reply within
+// the request's timeout, after which the caller has given up.
+func (m *Metadata) Responder() *Responder {
+	return m.responder
+}
+
+// Completes the request with a reply or the error encountered, following the
+// same rules as a reply returned from the handler. Fails if the request has
+// already been replied to.
+func (r *Responder) Reply(reply []byte, err error) error {
+	return r.conclude(reply, err, true)
+}
+
+// Concludes the request with the given outcome, unless already concluded.
+func (r *Responder) conclude(reply []byte, err error, record bool) error {
+	if !atomic.CompareAndSwapInt32(&r.done, 0, 1) {
+		return errors.New("request already replied")
+	}
+	r.finish(reply, err, record)
+	return nil
+}
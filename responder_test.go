@@ -0,0 +1,82 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// Service handler deferring its replies, completing them after a delay.
+type responderTestHandler struct {
+	delay   time.Duration
+	replied chan error
+}
+
+func (h *responderTestHandler) Init(conn *Connection) error              { return nil }
+func (h *responderTestHandler) HandleBroadcast(msg []byte)               { panic("not implemented") }
+func (h *responderTestHandler) HandleRequest(req []byte) ([]byte, error) { panic("not implemented") }
+func (h *responderTestHandler) HandleTunnel(tun *Tunnel)                 { panic("not implemented") }
+func (h *responderTestHandler) HandleDrop(reason error)                  { panic("not implemented") }
+
+func (h *responderTestHandler) HandleRequestWithMetadata(req []byte, meta *Metadata) ([]byte, error) {
+	responder := meta.Responder()
+	go func() {
+		time.Sleep(h.delay)
+		responder.Reply(req, nil)
+		h.replied <- responder.Reply(nil, nil)
+	}()
+	return nil, ErrPending
+}
+
+// Tests that deferred replies are delivered without occupying the request pool.
+func TestRequestDeferred(t *testing.T) {
+	handler := &responderTestHandler{
+		delay:   250 * time.Millisecond,
+		replied: make(chan error, 4),
+	}
+	// Register a single threaded service and connect a client
+	serv, err := Register(config.relay, config.cluster, handler, &ServiceLimits{RequestThreads: 1})
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Issue concurrent requests, faster than the handler thread could serve them
+	start := time.Now()
+	errc := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		go func(i byte) {
+			reply, err := conn.Request(config.cluster, []byte{i}, time.Second)
+			if err == nil && !bytes.Equal(reply, []byte{i}) {
+				t.Errorf("request %d: reply mismatch: have %x, want %x.", i, reply, []byte{i})
+			}
+			errc <- err
+		}(byte(i))
+	}
+	for i := 0; i < 4; i++ {
+		if err := <-errc; err != nil {
+			t.Fatalf("request failed: %v.", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 2*handler.delay {
+		t.Fatalf("deferred replies serialized: have %v, want < %v.", elapsed, 2*handler.delay)
+	}
+	// Ensure repeated replies are refused
+	for i := 0; i < 4; i++ {
+		if err := <-handler.replied; err == nil {
+			t.Fatalf("repeated reply accepted.")
+		}
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the request retry policy and the retry budget guarding against retry
+// storms amplifying the outage of a downstream cluster.
+
+package iris
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Settings of the automatic request retries. Only requests rejected by overloaded,
+// unready or quota limited services are retried, along with timed out requests
+// tagged with an idempotency key: a timeout doesn't tell whether the request was
+// processed, so untagged ones would risk running twice. Each attempt gets the
+// original timeout. Any unset fields (i.e. value of zero) will default to the
+// preset ones.
+type RetryPolicy struct {
+	Attempts int           // Maximum number of attempts, including the first one
+	Backoff  time.Duration // Delay before the first retry, doubled after each
+
+	Budget     float64       // Maximum ratio of retries to requests within a window
+	MinRetries int           // Retries permitted within a window regardless of traffic
+	Window     time.Duration // Length of the retry budget accounting window
+}
+
+// Default settings of the automatic request retries.
+var defaultRetryPolicy = RetryPolicy{
+	Attempts:   3,
+	Backoff:    50 * time.Millisecond,
+	Budget:     0.1,
+	MinRetries: 10,
+	Window:     10 * time.Second,
+}
+
+// Global retry budget of a connection, limiting the share of the traffic that
+// may consist of retries within a fixed accounting window.
+type retryBudget struct {
+	policy RetryPolicy // Settings of the retries and the budget
+	clock  Clock       // Time source of the accounting windows
+
+	start    time.Time  // Beginning of the current accounting window
+	requests int        // Requests issued within the current window
+	retries  int        // Retries issued within the current window
+	lock     sync.Mutex // Protects the window counters
+}
+
+// Creates a new retry budget, or nil if retries are disabled.
+func newRetryBudget(user *RetryPolicy, clock Clock) *retryBudget {
+	if user == nil {
+		return nil
+	}
+	// Merge the user settings with the defaults
+	policy := *user
+	if policy.Attempts == 0 {
+		policy.Attempts = defaultRetryPolicy.Attempts
+	}
+	if policy.Backoff == 0 {
+		policy.Backoff = defaultRetryPolicy.Backoff
+	}
+	if policy.Budget <= 0 {
+		policy.Budget = defaultRetryPolicy.Budget
+	}
+	if policy.MinRetries == 0 {
+		policy.MinRetries = defaultRetryPolicy.MinRetries
+	}
+	if policy.Window == 0 {
+		policy.Window = defaultRetryPolicy.Window
+	}
+	return &retryBudget{
+		policy: policy,
+		clock:  clock,
+		start:  clock.Now(),
+	}
+}
+
+// Rotates the accounting window if it expired. The lock must be held.
+func (b *retryBudget) rotate() {
+	if now := b.clock.Now(); now.Sub(b.start) >= b.policy.Window {
+		b.start, b.requests, b.retries = now, 0, 0
+	}
+}
+
+// Accounts a new original (non retry) request.
+func (b *retryBudget) request() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.rotate()
+	b.requests++
+}
+
+// Checks whether the budget permits another retry, and if so, accounts it.
+func (b *retryBudget) retry() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.rotate()
+	if b.retries >= b.policy.MinRetries && float64(b.retries+1) > b.policy.Budget*float64(b.requests) {
+		return false
+	}
+	b.retries++
+	return true
+}
+
+// Checks whether a failed request is worth retrying, timeouts only if the request
+// is idempotent.
+func retriable(ctx context.Context, err error) bool {
+	if err == ErrTimeout {
+		return IdempotencyKey(ctx) != ""
+	}
+	if remote, ok := err.(*RemoteError); ok {
+		if _, quota := remote.error.(*QuotaError); quota || remote.error == ErrOverloaded || remote.error == ErrUnavailable {
+			return true
+		}
+	}
+	return false
+}
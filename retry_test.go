@@ -0,0 +1,71 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// Tests that the retry budget caps the share of retries within a window.
+func TestRetryBudget(t *testing.T) {
+	budget := newRetryBudget(&RetryPolicy{
+		Budget:     0.2,
+		MinRetries: 5,
+		Window:     time.Hour,
+	}, systemClock{})
+	// The minimum retries should be permitted even without traffic
+	for i := 0; i < 5; i++ {
+		if !budget.retry() {
+			t.Fatalf("minimum retry #%d denied.", i)
+		}
+	}
+	if budget.retry() {
+		t.Fatalf("retry beyond the minimum permitted without traffic.")
+	}
+	// Issue some traffic and check that the ratio is enforced
+	for i := 0; i < 100; i++ {
+		budget.request()
+	}
+	for i := 5; i < 20; i++ {
+		if !budget.retry() {
+			t.Fatalf("budgeted retry #%d denied.", i)
+		}
+	}
+	if budget.retry() {
+		t.Fatalf("retry beyond the budget permitted.")
+	}
+	// Expire the window and ensure the budget is replenished
+	budget.start = budget.start.Add(-time.Hour)
+	if !budget.retry() {
+		t.Fatalf("retry denied in fresh window.")
+	}
+}
+
+// Tests that only transient failures are considered retriable, timeouts only of
+// idempotent requests.
+func TestRetriable(t *testing.T) {
+	idempotent := WithIdempotencyKey(context.Background(), "key")
+	tests := []struct {
+		ctx   context.Context
+		err   error
+		retry bool
+	}{
+		{idempotent, ErrTimeout, true},
+		{context.Background(), ErrTimeout, false},
+		{context.Background(), &RemoteError{ErrOverloaded}, true},
+		{context.Background(), ErrClosed, false},
+		{context.Background(), &RemoteError{errors.New("failure")}, false},
+	}
+	for i, tt := range tests {
+		if retry := retriable(tt.ctx, tt.err); retry != tt.retry {
+			t.Errorf("test %d: retriable mismatch for %v: have %v, want %v.", i, tt.err, retry, tt.retry)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Package saga contains a coordinator of multi-service business transactions:
+// a sequence of Iris requests, each paired with a compensating request undoing
+// its effects, which are issued in reverse order should a later step fail.
+//
+// The progress of every saga is persisted into a pluggable store after each
+// step, so a coordinator restarted after a crash can resume an interrupted saga
+// by running it again under the same id, either finishing it or finishing its
+// compensation.
+//
+// A step whose request timed out may still have been executed by the remote
+// service, but it is not compensated, as it's not known to have succeeded.
+// Services should thus make their actions idempotent and deduplicate them (e.g.
+// via the idempotency keys), so a resumed saga may safely reissue them.
+package saga
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"gopkg.in/project-iris/iris-go.v1"
+)
+
+// Single request of a saga step.
+type Call struct {
+	Cluster string // Cluster to send the request to
+	Request []byte // Payload of the request
+}
+
+// Single step of a saga.
+type Step struct {
+	Name       string // Name of the step, for error reporting
+	Action     Call   // Request executing the step
+	Compensate *Call  // Request undoing the step (nil if nothing to undo)
+}
+
+// States of a saga.
+type Status int
+
+const (
+	Running      Status = iota // Steps are being executed
+	Compensating               // A step failed, completed ones are being undone
+	Completed                  // All steps were executed successfully
+	Compensated                // A step failed, all completed ones were undone
+	Stuck                      // A compensation failed, needing manual intervention
+)
+
+// Persisted progress of a saga.
+type Progress struct {
+	ID      string   // Unique identifier of the saga
+	Status  Status   // Current state of the saga
+	Done    int      // Number of steps executed and not yet compensated
+	Replies [][]byte // Replies of the executed steps
+	Failure string   // Failure that triggered the compensation (empty if none)
+	Failed  int      // Index of the step that failed (only meaningful if compensating)
+}
+
+// Storage of the saga progresses. Implementations must be safe for concurrent
+// use.
+type Store interface {
+	// Retrieves the progress of a saga, or nil if it's unknown.
+	Load(id string) (*Progress, error)
+
+	// Stores the progress of a saga, overwriting any previous one.
+	Save(progress *Progress) error
+}
+
+// Failure of a saga step, after all completed steps were compensated.
+type AbortError struct {
+	Step   int    // Index of the failed step
+	Name   string // Name of the failed step
+	Reason string // Failure of the step's request
+}
+
+func (e *AbortError) Error() string {
+	return fmt.Sprintf("saga aborted at step %d (%s): %s", e.Step, e.Name, e.Reason)
+}
+
+// Failure of a compensating request, leaving the saga stuck.
+type StuckError struct {
+	Step int    // Index of the step whose compensation failed
+	Name string // Name of the step whose compensation failed
+	Err  error  // Failure of the compensating request
+}
+
+func (e *StuckError) Error() string {
+	return fmt.Sprintf("saga stuck compensating step %d (%s): %v", e.Step, e.Name, e.Err)
+}
+
+// Retrieves the failure wrapped by the error.
+func (e *StuckError) Unwrap() error {
+	return e.Err
+}
+
+// Settings of the saga coordinator. Any unset fields (i.e. value of zero) will
+// default to the preset ones.
+type Config struct {
+	Timeout time.Duration // Timeout of each step and compensation request
+	Retries int           // Reissues of a failed compensation before getting stuck
+}
+
+// Default settings of the saga coordinator.
+var defaultConfig = Config{
+	Timeout: 10 * time.Second,
+	Retries: 3,
+}
+
+// Executor of sagas through an Iris connection.
+type Coordinator struct {
+	conn   *iris.Connection // Connection to issue the requests through
+	store  Store            // Store persisting the saga progresses
+	config Config           // Settings of the coordinator
+}
+
+// Creates a saga coordinator issuing requests through conn and persisting the
+// progresses into store.
+func New(conn *iris.Connection, store Store, config *Config) (*Coordinator, error) {
+	// Sanity check on the arguments
+	if conn == nil {
+		return nil, errors.New("nil connection")
+	}
+	if store == nil {
+		return nil, errors.New("nil saga store")
+	}
+	// Merge the user configs with the defaults
+	conf := defaultConfig
+	if config != nil {
+		if config.Timeout != 0 {
+			conf.Timeout = config.Timeout
+		}
+		if config.Retries != 0 {
+			conf.Retries = config.Retries
+		}
+	}
+	return &Coordinator{
+		conn:   conn,
+		store:  store,
+		config: conf,
+	}, nil
+}
+
+// Runs the saga with the given id, resuming it from its persisted progress if
+// it was already started, and returns the replies of the steps. The steps must
+// be the same every time the same saga is run.
+//
+// If a step fails, the completed ones are compensated in reverse order and an
+// AbortError is returned. If a compensation fails too, a StuckError is returned,
+// and running the saga again retries the compensation.
+func (c *Coordinator) Run(ctx context.Context, id string, steps []Step) ([][]byte, error) {
+	progress, err := c.store.Load(id)
+	if err != nil {
+		return nil, err
+	}
+	if progress == nil {
+		progress = &Progress{ID: id, Status: Running}
+	}
+	if progress.Done > len(steps) {
+		return nil, fmt.Errorf("saga %s progress beyond its %d steps", id, len(steps))
+	}
+	switch progress.Status {
+	case Running:
+		if err := c.execute(ctx, steps, progress); err != nil {
+			return nil, err
+		}
+		if progress.Status == Completed {
+			return progress.Replies, nil
+		}
+		fallthrough
+
+	case Compensating, Stuck:
+		return nil, c.compensate(ctx, steps, progress)
+
+	case Completed:
+		return progress.Replies, nil
+
+	default:
+		return nil, abort(steps, progress)
+	}
+}
+
+// Executes the pending steps of a saga, persisting the progress after each and
+// switching to compensation if any fails.
+func (c *Coordinator) execute(ctx context.Context, steps []Step, progress *Progress) error {
+	for progress.Done < len(steps) {
+		step := steps[progress.Done]
+
+		reply, err := c.conn.RequestContext(ctx, step.Action.Cluster, step.Action.Request, c.config.Timeout)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			progress.Status, progress.Failed, progress.Failure = Compensating, progress.Done, err.Error()
+			return c.store.Save(progress)
+		}
+		progress.Done++
+		progress.Replies = append(progress.Replies, reply)
+		if progress.Done == len(steps) {
+			progress.Status = Completed
+		}
+		if err := c.store.Save(progress); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Compensates the executed steps of a saga in reverse order, persisting the
+// progress after each.
+func (c *Coordinator) compensate(ctx context.Context, steps []Step, progress *Progress) error {
+	progress.Status = Compensating
+	for progress.Done > 0 {
+		index := progress.Done - 1
+		if call := steps[index].Compensate; call != nil {
+			var err error
+			for i := 0; i <= c.config.Retries; i++ {
+				if _, err = c.conn.RequestContext(ctx, call.Cluster, call.Request, c.config.Timeout); err == nil || ctx.Err() != nil {
+					break
+				}
+			}
+			if err != nil {
+				progress.Status = Stuck
+				if serr := c.store.Save(progress); serr != nil {
+					return serr
+				}
+				return &StuckError{Step: index, Name: steps[index].Name, Err: err}
+			}
+		}
+		progress.Done--
+		progress.Replies = progress.Replies[:progress.Done]
+		if err := c.store.Save(progress); err != nil {
+			return err
+		}
+	}
+	progress.Status = Compensated
+	if err := c.store.Save(progress); err != nil {
+		return err
+	}
+	return abort(steps, progress)
+}
+
+// Assembles the failure of a compensated saga.
+func abort(steps []Step, progress *Progress) error {
+	name := ""
+	if progress.Failed < len(steps) {
+		name = steps[progress.Failed].Name
+	}
+	return &AbortError{Step: progress.Failed, Name: name, Reason: progress.Failure}
+}
+
+// In-memory saga store, useful for testing or for sagas that needn't survive a
+// process restart.
+type MemoryStore struct {
+	sagas map[string]*Progress // Progresses of the sagas
+	lock  sync.Mutex           // Protects the progresses
+}
+
+// Creates a new, empty in-memory saga store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{sagas: make(map[string]*Progress)}
+}
+
+// Implements Store.Load, retrieving a copy of a saga's progress.
+func (s *MemoryStore) Load(id string) (*Progress, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	progress, ok := s.sagas[id]
+	if !ok {
+		return nil, nil
+	}
+	return progress.copy(), nil
+}
+
+// Implements Store.Save, storing a copy of a saga's progress.
+func (s *MemoryStore) Save(progress *Progress) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.sagas[progress.ID] = progress.copy()
+	return nil
+}
+
+// Creates a copy of the progress, detached from the original's reply list.
+func (p *Progress) copy() *Progress {
+	cpy := *p
+	cpy.Replies = append([][]byte(nil), p.Replies...)
+	return &cpy
+}
@@ -0,0 +1,154 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package saga
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"gopkg.in/project-iris/iris-go.v1"
+)
+
+// Port of the local relay to run the tests against.
+var relay = 55555
+
+// Service handler recording the requests, failing those it was told to.
+type ledgerHandler struct {
+	log  []string
+	fail map[string]bool
+	lock sync.Mutex
+}
+
+func (l *ledgerHandler) Init(conn *iris.Connection) error { return nil }
+func (l *ledgerHandler) HandleBroadcast(msg []byte)       { panic("not implemented") }
+func (l *ledgerHandler) HandleTunnel(tun *iris.Tunnel)    { panic("not implemented") }
+func (l *ledgerHandler) HandleDrop(reason error)          { panic("not implemented") }
+
+func (l *ledgerHandler) HandleRequest(req []byte) ([]byte, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.fail[string(req)] {
+		return nil, errors.New("refused " + string(req))
+	}
+	l.log = append(l.log, string(req))
+	return req, nil
+}
+
+// Retrieves the recorded requests, clearing the record.
+func (l *ledgerHandler) drain() []string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	log := l.log
+	l.log = nil
+	return log
+}
+
+// Creates a saga step with requests to the ledger cluster.
+func step(name string) Step {
+	return Step{
+		Name:       name,
+		Action:     Call{Cluster: "saga-ledger", Request: []byte("do " + name)},
+		Compensate: &Call{Cluster: "saga-ledger", Request: []byte("undo " + name)},
+	}
+}
+
+// Tests that successful sagas complete, and failing ones are compensated in
+// reverse order.
+func TestSaga(t *testing.T) {
+	ledger := &ledgerHandler{fail: map[string]bool{"do charge": true}}
+	serv, err := iris.Register(relay, "saga-ledger", ledger, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := iris.Connect(relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	store := NewMemoryStore()
+	saga, err := New(conn, store, &Config{Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("coordinator creation failed: %v.", err)
+	}
+	// Run a successful saga and ensure reruns don't reissue the steps
+	for i := 0; i < 2; i++ {
+		replies, err := saga.Run(context.Background(), "ok", []Step{step("reserve"), step("ship")})
+		if err != nil {
+			t.Fatalf("saga run %d failed: %v.", i, err)
+		}
+		if len(replies) != 2 || string(replies[1]) != "do ship" {
+			t.Fatalf("reply mismatch: have %q.", replies)
+		}
+	}
+	// Run a failing saga and ensure the completed steps are undone
+	ledger.drain()
+	_, err = saga.Run(context.Background(), "fail", []Step{step("reserve"), step("ship"), step("charge")})
+
+	var abort *AbortError
+	if !errors.As(err, &abort) || abort.Step != 2 || abort.Name != "charge" {
+		t.Fatalf("abort mismatch: have %v.", err)
+	}
+	log, want := ledger.drain(), []string{"do reserve", "do ship", "undo ship", "undo reserve"}
+	if len(log) != len(want) {
+		t.Fatalf("ledger mismatch: have %q, want %q.", log, want)
+	}
+	for i := range want {
+		if log[i] != want[i] {
+			t.Fatalf("ledger entry %d mismatch: have %q, want %q.", i, log[i], want[i])
+		}
+	}
+	if progress, _ := store.Load("fail"); progress.Status != Compensated || progress.Done != 0 {
+		t.Fatalf("progress mismatch: have %+v.", progress)
+	}
+}
+
+// Tests that a saga stuck on a failing compensation resumes it when rerun.
+func TestSagaStuck(t *testing.T) {
+	ledger := &ledgerHandler{fail: map[string]bool{"do charge": true, "undo reserve": true}}
+	serv, err := iris.Register(relay, "saga-ledger", ledger, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := iris.Connect(relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	saga, err := New(conn, NewMemoryStore(), &Config{Timeout: time.Second, Retries: 1})
+	if err != nil {
+		t.Fatalf("coordinator creation failed: %v.", err)
+	}
+	steps := []Step{step("reserve"), step("charge")}
+
+	var stuck *StuckError
+	if _, err := saga.Run(context.Background(), "stuck", steps); !errors.As(err, &stuck) || stuck.Name != "reserve" {
+		t.Fatalf("stuck mismatch: have %v.", err)
+	}
+	// Fix the compensation and resume the saga
+	ledger.lock.Lock()
+	delete(ledger.fail, "undo reserve")
+	ledger.lock.Unlock()
+
+	var abort *AbortError
+	if _, err := saga.Run(context.Background(), "stuck", steps); !errors.As(err, &abort) || abort.Name != "charge" {
+		t.Fatalf("abort mismatch: have %v.", err)
+	}
+	if log := ledger.drain(); log[len(log)-1] != "undo reserve" {
+		t.Fatalf("compensation not resumed: ledger %q.", log)
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the delayed broadcasts and publishes. The relay protocol has no
+// notion of deferred delivery, so the messages are held locally until due and
+// are lost if the connection terminates before.
+
+package iris
+
+import (
+	"sync"
+	"time"
+)
+
+// Handle of a delayed broadcast or publish, allowing it to be cancelled.
+type Scheduled struct {
+	sched *scheduler
+	timer Timer
+}
+
+// Cancels the delayed message, returning whether it was still pending.
+func (s *Scheduled) Cancel() bool {
+	s.sched.lock.Lock()
+	defer s.sched.lock.Unlock()
+
+	if _, ok := s.sched.pend[s]; !ok {
+		return false
+	}
+	delete(s.sched.pend, s)
+	return s.timer.Stop()
+}
+
+// Set of the delayed messages of a connection awaiting their due time.
+type scheduler struct {
+	clock Clock                   // Time source to wait for the due times with
+	pend  map[*Scheduled]struct{} // Messages not yet due
+	done  bool                    // Whether the connection terminated
+	lock  sync.Mutex              // Protects the pending set
+}
+
+// Schedules a task to be executed at the given time.
+func (s *scheduler) schedule(at time.Time, task func()) *Scheduled {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	item := &Scheduled{sched: s}
+	if s.done {
+		return item
+	}
+	s.pend[item] = struct{}{}
+	item.timer = s.clock.AfterFunc(at.Sub(s.clock.Now()), func() {
+		s.lock.Lock()
+		_, ok := s.pend[item]
+		delete(s.pend, item)
+		s.lock.Unlock()
+
+		if ok {
+			task()
+		}
+	})
+	return item
+}
+
+// Drops all the pending messages, preventing any further scheduling.
+func (s *scheduler) stop() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for item := range s.pend {
+		item.timer.Stop()
+	}
+	s.pend, s.done = make(map[*Scheduled]struct{}), true
+}
+
+// Retrieves the number of messages waiting to become due.
+func (s *scheduler) pending() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return len(s.pend)
+}
+
+// Broadcasts a message to all members of a cluster after the given delay. The
+// arguments are validated immediately, send failures when due are only logged.
+func (c *Connection) BroadcastAfter(cluster string, message []byte, delay time.Duration) (*Scheduled, error) {
+	return c.BroadcastAt(cluster, message, c.clock.Now().Add(delay))
+}
+
+// Broadcasts a message to all members of a cluster at the given time. The
+// arguments are validated immediately, send failures when due are only logged.
+func (c *Connection) BroadcastAt(cluster string, message []byte, at time.Time) (*Scheduled, error) {
+	if err := c.checkBroadcast(cluster, message); err != nil {
+		return nil, err
+	}
+	c.Log.Debug("scheduling delayed broadcast", "cluster", cluster, "due", at)
+	return c.sched.schedule(at, func() {
+		if err := c.Broadcast(cluster, message); err != nil {
+			c.Log.Error("failed to send delayed broadcast", "cluster", cluster, "reason", err)
+			c.reportError("failed to send delayed broadcast to %s: %v", cluster, err)
+		}
+	}), nil
+}
+
+// Publishes an event to a topic after the given delay. The arguments are
+// validated immediately, send failures when due are only logged.
+func (c *Connection) PublishAfter(topic string, event []byte, delay time.Duration) (*Scheduled, error) {
+	return c.PublishAt(topic, event, c.clock.Now().Add(delay))
+}
+
+// Publishes an event to a topic at the given time. The arguments are validated
+// immediately, send failures when due are only logged.
+func (c *Connection) PublishAt(topic string, event []byte, at time.Time) (*Scheduled, error) {
+	if err := c.checkPublish(topic, event); err != nil {
+		return nil, err
+	}
+	c.Log.Debug("scheduling delayed publish", "topic", topic, "due", at)
+	return c.sched.schedule(at, func() {
+		if err := c.Publish(topic, event); err != nil {
+			c.Log.Error("failed to send delayed publish", "topic", topic, "reason", err)
+			c.reportError("failed to send delayed publish to %s: %v", topic, err)
+		}
+	}), nil
+}
@@ -0,0 +1,212 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the payload schema version negotiation of the typed requests.
+//
+// Services list the payload schema versions they accept, which callers discover
+// through a probe request answered by the remote binding itself, caching them
+// per cluster. Versioned requests are encoded in the newest version both sides
+// understand, tagged with it in the envelope. Members not accepting the tagged
+// version (e.g. ones not yet upgraded during a rolling upgrade) reject it, upon
+// which the caller refreshes the versions of the cluster and retries once.
+
+package iris
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Context key of the schema version tag of a request.
+type schemaKey struct{}
+
+// Prefix of the failure reported by services rejecting a request's version.
+var schemaFaultPrefix = "unsupported schema version: "
+
+// Failure reported by a service rejecting a request's schema version.
+type schemaFault string
+
+func (f schemaFault) Error() string {
+	return string(f)
+}
+
+// Returned if a caller and a service have no payload schema version in common.
+type SchemaError struct {
+	Cluster   string // Cluster the request was meant for
+	Offered   []int  // Schema versions the caller can encode
+	Supported []int  // Schema versions the service advertised
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("no compatible schema version with cluster %q: offered %v, supported %v", e.Cluster, e.Offered, e.Supported)
+}
+
+// Encoding of a typed request and its reply in a single schema version.
+type SchemaCodec[Req, Rep any] struct {
+	Encode func(Req) ([]byte, error) // Serializes a request in the version
+	Decode func([]byte) (Rep, error) // Deserializes a reply in the version
+}
+
+// Executes a synchronous typed request, encoded in the newest schema version
+// both the caller (the keys of codecs) and the remote cluster support. Services
+// not advertising any versions are sent the oldest one. If no version matches,
+// a SchemaError is returned without issuing the request. The semantics follow
+// RequestContext otherwise.
+//
+// Serving handlers find the chosen version in Metadata.Schema, and are expected
+// to reply in the same version.
+func RequestVersioned[Req, Rep any](ctx context.Context, conn *Connection, cluster string, request Req, codecs map[int]SchemaCodec[Req, Rep], timeout time.Duration) (Rep, error) {
+	var none Rep
+
+	// Sanity check on the arguments
+	offered := make([]int, 0, len(codecs))
+	for version := range codecs {
+		if version < 1 {
+			return none, fmt.Errorf("invalid schema version %d < 1", version)
+		}
+		offered = append(offered, version)
+	}
+	if len(offered) == 0 {
+		return none, errors.New("no schema codecs")
+	}
+	sort.Ints(offered)
+
+	// Pick a version the cluster supports and issue the request, refreshing the
+	// advertised versions once if the serving member rejects it
+	for refresh := false; ; refresh = true {
+		supported, err := conn.clusterSchemas(ctx, cluster, timeout, refresh)
+		if err != nil {
+			return none, err
+		}
+		version := pickSchema(offered, supported)
+		if version == 0 {
+			return none, &SchemaError{Cluster: cluster, Offered: offered, Supported: supported}
+		}
+		codec := codecs[version]
+		data, err := codec.Encode(request)
+		if err != nil {
+			return none, err
+		}
+		reply, err := conn.RequestContext(context.WithValue(ctx, schemaKey{}, strconv.Itoa(version)), cluster, data, timeout)
+		if err != nil {
+			var fault schemaFault
+			if errors.As(err, &fault) {
+				if !refresh {
+					continue
+				}
+				return none, &SchemaError{Cluster: cluster, Offered: offered, Supported: supported}
+			}
+			return none, err
+		}
+		return codec.Decode(reply)
+	}
+}
+
+// Picks the newest of the offered versions that is also supported, the oldest
+// offered if the service is unversioned (nil), or zero if none matches.
+func pickSchema(offered []int, supported []int) int {
+	if supported == nil {
+		return offered[0]
+	}
+	for i := len(offered) - 1; i >= 0; i-- {
+		for _, version := range supported {
+			if offered[i] == version {
+				return version
+			}
+		}
+	}
+	return 0
+}
+
+// Retrieves the schema versions advertised by a cluster, probing it if not yet
+// known or if a refresh is requested. Unversioned clusters result in nil.
+func (c *Connection) clusterSchemas(ctx context.Context, cluster string, timeout time.Duration, refresh bool) ([]int, error) {
+	c.schemaLock.Lock()
+	supported, known := c.schemaLive[cluster]
+	c.schemaLock.Unlock()
+
+	if known && !refresh {
+		return supported, nil
+	}
+	// Probe the cluster for its versions
+	probe, err := c.envelope(cluster, []byte{0x00}, map[string]string{headerProbe: "1", headerSchema: "?"})
+	if err != nil {
+		return nil, err
+	}
+	if timeout == 0 {
+		timeout = c.options.Defaults.request()
+	}
+	timeoutms := int(timeout.Nanoseconds() / 1000000)
+	if timeoutms < 1 {
+		return nil, fmt.Errorf("invalid timeout %v < 1ms", timeout)
+	}
+	reply, err := c.request(ctx, c.Log, cluster, probe, timeout, timeoutms)
+	if err != nil {
+		return nil, err
+	}
+	// Older bindings echo the probe, so anything unparsable means unversioned
+	supported, _ = parseSchemas(string(reply))
+
+	c.schemaLock.Lock()
+	c.schemaLive[cluster] = supported
+	c.schemaLock.Unlock()
+
+	return supported, nil
+}
+
+// Formats a list of schema versions for advertising, "*" if unversioned.
+func formatSchemas(versions []int) string {
+	if len(versions) == 0 {
+		return "*"
+	}
+	parts := make([]string, len(versions))
+	for i, version := range versions {
+		parts[i] = strconv.Itoa(version)
+	}
+	return strings.Join(parts, ",")
+}
+
+// Parses an advertised list of schema versions, nil if unversioned.
+func parseSchemas(list string) ([]int, error) {
+	if list == "*" {
+		return nil, nil
+	}
+	var versions []int
+	for _, part := range strings.Split(list, ",") {
+		version, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+	return versions, nil
+}
+
+// Checks whether a service accepts requests tagged with the given schema version.
+// Untagged requests and unversioned services accept anything.
+func (c *Connection) acceptsSchema(version int) bool {
+	if version == 0 || len(c.options.Schemas) == 0 {
+		return true
+	}
+	for _, supported := range c.options.Schemas {
+		if version == supported {
+			return true
+		}
+	}
+	return false
+}
+
+// Retrieves the schema version tag carried by a request context, or an empty
+// string if none.
+func schemaTagOf(ctx context.Context) string {
+	tag, _ := ctx.Value(schemaKey{}).(string)
+	return tag
+}
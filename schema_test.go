@@ -0,0 +1,87 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Service handler replying with the schema version of the requests.
+type schemaTestHandler struct{}
+
+func (h *schemaTestHandler) Init(conn *Connection) error              { return nil }
+func (h *schemaTestHandler) HandleBroadcast(msg []byte)               { panic("not implemented") }
+func (h *schemaTestHandler) HandleRequest(req []byte) ([]byte, error) { panic("not implemented") }
+func (h *schemaTestHandler) HandleTunnel(tun *Tunnel)                 { panic("not implemented") }
+func (h *schemaTestHandler) HandleDrop(reason error)                  { panic("not implemented") }
+
+func (h *schemaTestHandler) HandleRequestWithMetadata(req []byte, meta *Metadata) ([]byte, error) {
+	return []byte(fmt.Sprintf("v%d:%s", meta.Schema, req)), nil
+}
+
+// Assembles schema codecs of the given versions, tagging the requests with it.
+func schemaTestCodecs(versions ...int) map[int]SchemaCodec[string, string] {
+	codecs := make(map[int]SchemaCodec[string, string])
+	for _, version := range versions {
+		codecs[version] = SchemaCodec[string, string]{
+			Encode: func(req string) ([]byte, error) { return []byte(req), nil },
+			Decode: func(rep []byte) (string, error) { return string(rep), nil },
+		}
+	}
+	return codecs
+}
+
+// Tests that versioned requests negotiate a schema version with the services.
+func TestRequestVersioned(t *testing.T) {
+	serv, err := RegisterWithOptions(config.relay, config.cluster, new(schemaTestHandler), nil, &Options{Schemas: []int{1, 2}})
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Ensure the newest common version is picked, or a failure if none exists
+	ctx := context.Background()
+	if reply, err := RequestVersioned(ctx, conn, config.cluster, "hello", schemaTestCodecs(1, 2, 3), time.Second); err != nil || reply != "v2:hello" {
+		t.Fatalf("versioned request mismatch: have %q/%v, want %q/nil.", reply, err, "v2:hello")
+	}
+	_, err = RequestVersioned(ctx, conn, config.cluster, "hello", schemaTestCodecs(3), time.Second)
+	var serr *SchemaError
+	if !errors.As(err, &serr) || !reflect.DeepEqual(serr.Supported, []int{1, 2}) {
+		t.Fatalf("incompatible request mismatch: have %v, want schema error with [1 2] supported.", err)
+	}
+	// Downgrade the service and ensure the stale versions are refreshed
+	serv.Unregister()
+
+	serv, err = RegisterWithOptions(config.relay, config.cluster, new(schemaTestHandler), nil, &Options{Schemas: []int{1}})
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	if reply, err := RequestVersioned(ctx, conn, config.cluster, "hello", schemaTestCodecs(1, 2), time.Second); err != nil || reply != "v1:hello" {
+		t.Fatalf("refreshed request mismatch: have %q/%v, want %q/nil.", reply, err, "v1:hello")
+	}
+	serv.Unregister()
+
+	// Ensure unversioned services are sent the oldest version
+	serv, err = Register(config.relay, config.cluster+"-unversioned", new(schemaTestHandler), nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	if reply, err := RequestVersioned(ctx, conn, config.cluster+"-unversioned", "hello", schemaTestCodecs(1, 2), time.Second); err != nil || reply != "v1:hello" {
+		t.Fatalf("unversioned request mismatch: have %q/%v, want %q/nil.", reply, err, "v1:hello")
+	}
+}
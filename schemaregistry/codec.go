@@ -0,0 +1,104 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package schemaregistry
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Content-type declared in the envelopes of schema registry framed payloads.
+const ContentType = "application/vnd.confluent.avro"
+
+// Magic byte opening every Confluent framed payload.
+const frameMagic = 0x00
+
+// Serializer of the records in a schema described format (e.g. Avro binary).
+type Serde interface {
+	// Serializes a value according to the writer schema.
+	Marshal(schema string, value interface{}) ([]byte, error)
+
+	// Deserializes a record written with the writer schema into a value.
+	Unmarshal(schema string, data []byte, value interface{}) error
+}
+
+// Iris codec framing the payloads with the id of their schema in a registry.
+// Outbound values are written with the codec's own schema (registered under its
+// subject on first use), while inbound ones are read with the writer schema the
+// frame references.
+type Codec struct {
+	client  *Client // Registry client to resolve the schemas through
+	subject string  // Subject to register the writer schema under
+	schema  string  // Writer schema of the outbound values
+	serde   Serde   // Record serializer
+
+	id   int        // Registered id of the writer schema (0 if not yet registered)
+	lock sync.Mutex // Protects the lazy schema registration
+}
+
+// Creates a codec writing values with the given schema, registered under the
+// subject in the registry.
+func NewCodec(client *Client, subject string, schema string, serde Serde) *Codec {
+	return &Codec{
+		client:  client,
+		subject: subject,
+		schema:  schema,
+		serde:   serde,
+	}
+}
+
+// Implements iris.Codec.ContentType.
+func (c *Codec) ContentType() string { return ContentType }
+
+// Implements iris.Codec.Marshal, framing the record with its schema id.
+func (c *Codec) Marshal(value interface{}) ([]byte, error) {
+	id, err := c.register()
+	if err != nil {
+		return nil, err
+	}
+	record, err := c.serde.Marshal(c.schema, value)
+	if err != nil {
+		return nil, err
+	}
+	frame := make([]byte, 5, 5+len(record))
+	frame[0] = frameMagic
+	binary.BigEndian.PutUint32(frame[1:], uint32(id))
+
+	return append(frame, record...), nil
+}
+
+// Implements iris.Codec.Unmarshal, reading the record with the writer schema
+// referenced by the frame.
+func (c *Codec) Unmarshal(data []byte, value interface{}) error {
+	if len(data) < 5 || data[0] != frameMagic {
+		return errors.New("schemaregistry: malformed frame")
+	}
+	id := int(binary.BigEndian.Uint32(data[1:5]))
+
+	schema, err := c.client.Schema(id)
+	if err != nil {
+		return fmt.Errorf("schemaregistry: failed to resolve schema %d: %v", id, err)
+	}
+	return c.serde.Unmarshal(schema, data[5:], value)
+}
+
+// Registers the writer schema if not yet done, returning its id.
+func (c *Codec) register() (int, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.id == 0 {
+		id, err := c.client.Register(c.subject, c.schema)
+		if err != nil {
+			return 0, err
+		}
+		c.id = id
+	}
+	return c.id, nil
+}
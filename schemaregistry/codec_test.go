@@ -0,0 +1,103 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package schemaregistry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// In-memory schema registry serving the subset of the REST API used.
+type testRegistry struct {
+	schemas []string
+	lock    sync.Mutex
+}
+
+func (r *testRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	switch {
+	case req.Method == "POST" && strings.HasPrefix(req.URL.Path, "/subjects/"):
+		var body struct{ Schema string }
+		json.NewDecoder(req.Body).Decode(&body)
+		for i, schema := range r.schemas {
+			if schema == body.Schema {
+				fmt.Fprintf(w, `{"id": %d}`, i+1)
+				return
+			}
+		}
+		r.schemas = append(r.schemas, body.Schema)
+		fmt.Fprintf(w, `{"id": %d}`, len(r.schemas))
+	case req.Method == "GET" && strings.HasPrefix(req.URL.Path, "/schemas/ids/"):
+		var id int
+		fmt.Sscanf(req.URL.Path, "/schemas/ids/%d", &id)
+		if id < 1 || id > len(r.schemas) {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error_code": 40403, "message": "Schema not found"}`)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"schema": r.schemas[id-1]})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// Record serializer tagging JSON encodings with the schema, to verify that the
+// reader is handed the writer schema.
+type testSerde struct{}
+
+func (testSerde) Marshal(schema string, value interface{}) ([]byte, error) {
+	data, err := json.Marshal(value)
+	return append([]byte(schema+"|"), data...), err
+}
+
+func (testSerde) Unmarshal(schema string, data []byte, value interface{}) error {
+	if !bytes.HasPrefix(data, []byte(schema+"|")) {
+		return fmt.Errorf("record not written with schema %q", schema)
+	}
+	return json.Unmarshal(data[len(schema)+1:], value)
+}
+
+// Tests that values are framed with their schema id and read back with the
+// writer schema, even by codecs configured with a different one.
+func TestCodec(t *testing.T) {
+	server := httptest.NewServer(new(testRegistry))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	writerV1 := NewCodec(client, "points-value", "v1", testSerde{})
+	writerV2 := NewCodec(client, "points-value", "v2", testSerde{})
+
+	data, err := writerV1.Marshal(map[string]int{"x": 1})
+	if err != nil {
+		t.Fatalf("failed to marshal value: %v.", err)
+	}
+	if want := []byte{0, 0, 0, 0, 1}; !bytes.HasPrefix(data, want) {
+		t.Fatalf("frame header mismatch: have %x, want %x.", data[:5], want)
+	}
+	var value map[string]int
+	if err := writerV2.Unmarshal(data, &value); err != nil {
+		t.Fatalf("failed to unmarshal value: %v.", err)
+	}
+	if value["x"] != 1 {
+		t.Fatalf("value mismatch: have %v, want %v.", value, map[string]int{"x": 1})
+	}
+	// Verify that unknown schema ids and malformed frames are rejected
+	if err := writerV1.Unmarshal([]byte{0, 0, 0, 0, 9, '{', '}'}, &value); err == nil {
+		t.Fatalf("unknown schema id accepted.")
+	}
+	if err := writerV1.Unmarshal([]byte{1, 2}, &value); err == nil {
+		t.Fatalf("malformed frame accepted.")
+	}
+}
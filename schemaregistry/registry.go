@@ -0,0 +1,127 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Package schemaregistry contains an Iris codec interoperating with Confluent
+// style schema registries, as used by Kafka based data pipelines.
+//
+// Payloads are framed the Confluent way: a zero magic byte, the 4 byte big
+// endian id of the writer schema, and the serialized record. The record format
+// itself is pluggable through the Serde interface, so any Avro implementation
+// (or JSON/protobuf schema support) can be adapted without this package taking
+// a dependency on it.
+package schemaregistry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Content type of the registry REST API.
+const apiContentType = "application/vnd.schemaregistry.v1+json"
+
+// Client of a schema registry's REST API, caching the resolved schemas.
+type Client struct {
+	url  string       // Base URL of the registry
+	http *http.Client // HTTP client to issue the API calls with
+
+	schemas map[int]string // Cache of the schemas by id
+	ids     map[string]int // Cache of the registered schema ids by subject and schema
+	lock    sync.RWMutex   // Protects the caches
+}
+
+// Creates a client for the schema registry at the given base URL.
+func NewClient(url string) *Client {
+	return &Client{
+		url:     strings.TrimSuffix(url, "/"),
+		http:    http.DefaultClient,
+		schemas: make(map[int]string),
+		ids:     make(map[string]int),
+	}
+}
+
+// Retrieves the schema registered under an id.
+func (c *Client) Schema(id int) (string, error) {
+	c.lock.RLock()
+	schema, ok := c.schemas[id]
+	c.lock.RUnlock()
+	if ok {
+		return schema, nil
+	}
+	var result struct {
+		Schema string `json:"schema"`
+	}
+	if err := c.call("GET", fmt.Sprintf("/schemas/ids/%d", id), nil, &result); err != nil {
+		return "", err
+	}
+	c.lock.Lock()
+	c.schemas[id] = result.Schema
+	c.lock.Unlock()
+
+	return result.Schema, nil
+}
+
+// Registers a schema under a subject (or looks it up if already registered),
+// returning its id.
+func (c *Client) Register(subject string, schema string) (int, error) {
+	key := subject + "\x00" + schema
+
+	c.lock.RLock()
+	id, ok := c.ids[key]
+	c.lock.RUnlock()
+	if ok {
+		return id, nil
+	}
+	var result struct {
+		ID int `json:"id"`
+	}
+	request := map[string]string{"schema": schema}
+	if err := c.call("POST", "/subjects/"+url.PathEscape(subject)+"/versions", request, &result); err != nil {
+		return 0, err
+	}
+	c.lock.Lock()
+	c.ids[key] = result.ID
+	c.schemas[result.ID] = schema
+	c.lock.Unlock()
+
+	return result.ID, nil
+}
+
+// Issues a REST call to the registry, decoding the JSON result.
+func (c *Client) call(method string, path string, request interface{}, result interface{}) error {
+	var body bytes.Buffer
+	if request != nil {
+		if err := json.NewEncoder(&body).Encode(request); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, c.url+path, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", apiContentType)
+	req.Header.Set("Accept", apiContentType)
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		var failure struct {
+			Code    int    `json:"error_code"`
+			Message string `json:"message"`
+		}
+		json.NewDecoder(res.Body).Decode(&failure)
+		return fmt.Errorf("schema registry: %s %s: %d %s", method, path, res.StatusCode, failure.Message)
+	}
+	return json.NewDecoder(res.Body).Decode(result)
+}
@@ -0,0 +1,196 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Package sequence contains per producer sequence numbering of published events,
+// letting consumers detect the events lost by the at-most-once topics and run
+// downstream consistency checks.
+//
+// Every producer numbers its events on each topic from one upwards, embedding
+// its identity, a random epoch and the sequence number into the event. The
+// epoch changes with every producer instance, so restarts reset the numbering
+// without being mistaken for losses.
+//
+// Since parallel topic handlers may reorder events, which would be reported as
+// gaps, subscriptions should be limited to a single event thread.
+package sequence
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"sync"
+
+	"gopkg.in/project-iris/iris-go.v1"
+)
+
+// Version byte prefixing the sequenced events.
+const frameVersion = 0x01
+
+// Returned when an event doesn't carry a valid sequence frame.
+var ErrMalformed = errors.New("malformed sequenced event")
+
+// Event arrived from a sequenced producer.
+type Event struct {
+	Producer string // Identity of the producer that published the event
+	Seq      uint64 // Sequence number of the event within the producer's topic
+	Data     []byte // Application payload of the event
+}
+
+// Range of events missing from a producer's sequence.
+type Gap struct {
+	Producer string // Identity of the producer the events were lost from
+	From     uint64 // First missing sequence number
+	To       uint64 // Last missing sequence number
+}
+
+// Publisher numbering its events on each topic.
+type Producer struct {
+	conn  *iris.Connection  // Connection through which to publish
+	id    string            // Identity of the producer
+	epoch uint64            // Random id of this producer instance
+	next  map[string]uint64 // Last sequence number assigned per topic
+	lock  sync.Mutex        // Serializes the numbering and sending of events
+}
+
+// Creates a sequenced producer publishing through conn under the given identity.
+// The identity should be unique among the producers of the same topics.
+func NewProducer(conn *iris.Connection, id string) (*Producer, error) {
+	if conn == nil {
+		return nil, errors.New("nil connection")
+	}
+	if id == "" {
+		return nil, errors.New("empty producer identity")
+	}
+	epoch := make([]byte, 8)
+	if _, err := rand.Read(epoch); err != nil {
+		return nil, err
+	}
+	return &Producer{
+		conn:  conn,
+		id:    id,
+		epoch: binary.BigEndian.Uint64(epoch),
+		next:  make(map[string]uint64),
+	}, nil
+}
+
+// Publishes an event to topic with the next sequence number of the topic, which
+// is returned. Failed publishes don't consume a number.
+func (p *Producer) Publish(topic string, event []byte) (uint64, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	seq := p.next[topic] + 1
+	if err := p.conn.Publish(topic, frame(p.id, p.epoch, seq, event)); err != nil {
+		return 0, err
+	}
+	p.next[topic] = seq
+	return seq, nil
+}
+
+// Embeds the sequence details into an event.
+func frame(producer string, epoch uint64, seq uint64, event []byte) []byte {
+	blob := make([]byte, 0, 1+binary.MaxVarintLen64+len(producer)+16+len(event))
+	blob = append(blob, frameVersion)
+	blob = binary.AppendUvarint(blob, uint64(len(producer)))
+	blob = append(blob, producer...)
+	blob = binary.BigEndian.AppendUint64(blob, epoch)
+	blob = binary.BigEndian.AppendUint64(blob, seq)
+	return append(blob, event...)
+}
+
+// Extracts the sequence details from an event.
+func unframe(blob []byte) (producer string, epoch uint64, seq uint64, event []byte, err error) {
+	if len(blob) == 0 || blob[0] != frameVersion {
+		return "", 0, 0, nil, ErrMalformed
+	}
+	blob = blob[1:]
+
+	size, n := binary.Uvarint(blob)
+	if n <= 0 || size > uint64(len(blob)-n) || len(blob)-n-int(size) < 16 {
+		return "", 0, 0, nil, ErrMalformed
+	}
+	blob = blob[n:]
+	producer, blob = string(blob[:size]), blob[size:]
+
+	epoch, seq = binary.BigEndian.Uint64(blob), binary.BigEndian.Uint64(blob[8:])
+	return producer, epoch, seq, blob[16:], nil
+}
+
+// Settings of a sequenced consumer.
+type Config struct {
+	Gap       func(gap *Gap)     // Callback notified of the missing events
+	Malformed func(event []byte) // Callback notified of events without sequence details
+}
+
+// Progress of a single producer as seen by a consumer.
+type stream struct {
+	epoch uint64 // Producer instance the sequence belongs to
+	last  uint64 // Highest sequence number seen
+}
+
+// Topic handler unwrapping sequenced events, checking them for gaps and passing
+// them on to the application handler.
+type Consumer struct {
+	handler func(event *Event) // Application handler of the events
+	config  Config             // Callbacks of the consumer
+	streams map[string]*stream // Progress of each producer seen
+	lock    sync.Mutex         // Protects the producer progresses
+}
+
+// Creates a sequenced consumer forwarding events to handler. Subscribe it to
+// the topics of sequenced producers as any other topic handler.
+func NewConsumer(handler func(event *Event), config *Config) *Consumer {
+	c := &Consumer{
+		handler: handler,
+		streams: make(map[string]*stream),
+	}
+	if config != nil {
+		c.config = *config
+	}
+	return c
+}
+
+// Implements iris.TopicHandler, checking and forwarding a sequenced event.
+func (c *Consumer) HandleEvent(event []byte) {
+	producer, epoch, seq, data, err := unframe(event)
+	if err != nil {
+		if c.config.Malformed != nil {
+			c.config.Malformed(event)
+		}
+		return
+	}
+	if gap := c.track(producer, epoch, seq); gap != nil && c.config.Gap != nil {
+		c.config.Gap(gap)
+	}
+	c.handler(&Event{Producer: producer, Seq: seq, Data: data})
+}
+
+// Records the arrival of a sequence number, returning the gap it reveals, if
+// any. The first event of a producer establishes its sequence, as the earlier
+// ones were published before subscribing, whereas a new producer instance is
+// expected to restart from one.
+func (c *Consumer) track(producer string, epoch uint64, seq uint64) *Gap {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	s, ok := c.streams[producer]
+	if !ok {
+		c.streams[producer] = &stream{epoch: epoch, last: seq}
+		return nil
+	}
+	if s.epoch != epoch {
+		s.epoch, s.last = epoch, 0
+	}
+	if seq <= s.last {
+		return nil // Reordered, the gap was already reported
+	}
+	var gap *Gap
+	if seq > s.last+1 {
+		gap = &Gap{Producer: producer, From: s.last + 1, To: seq - 1}
+	}
+	s.last = seq
+	return gap
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package sequence
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/project-iris/iris-go.v1"
+)
+
+// Port of the local relay to run the sequencing tests against.
+var relay = 55555
+
+// Tests that sequenced events are numbered and gaps in them detected.
+func TestSequence(t *testing.T) {
+	conn, err := iris.Connect(relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Subscribe a sequenced consumer to the topic
+	events := make(chan *Event, 16)
+	gaps := make(chan *Gap, 16)
+	malformed := make(chan []byte, 16)
+
+	consumer := NewConsumer(func(event *Event) { events <- event }, &Config{
+		Gap:       func(gap *Gap) { gaps <- gap },
+		Malformed: func(event []byte) { malformed <- event },
+	})
+	if err := conn.Subscribe("sequence-topic", consumer, &iris.TopicLimits{EventThreads: 1}); err != nil {
+		t.Fatalf("subscription failed: %v.", err)
+	}
+	defer conn.Unsubscribe("sequence-topic")
+	time.Sleep(100 * time.Millisecond)
+
+	producer, err := NewProducer(conn, "producer")
+	if err != nil {
+		t.Fatalf("failed to create producer: %v.", err)
+	}
+	publish := func(data string, want uint64) {
+		if seq, err := producer.Publish("sequence-topic", []byte(data)); err != nil || seq != want {
+			t.Fatalf("publish mismatch: have %d/%v, want %d.", seq, err, want)
+		}
+	}
+	expect := func(data string, want uint64) {
+		select {
+		case event := <-events:
+			if event.Producer != "producer" || event.Seq != want || string(event.Data) != data {
+				t.Fatalf("event mismatch: have %s/%d/%q, want %s/%d/%q.", event.Producer, event.Seq, event.Data, "producer", want, data)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("event #%d not delivered.", want)
+		}
+	}
+	// Verify that a contiguous sequence reports no gaps
+	for i := uint64(1); i <= 3; i++ {
+		publish("event", i)
+		expect("event", i)
+	}
+	// Simulate lost events and verify that the gap is reported
+	producer.lock.Lock()
+	producer.next["sequence-topic"] += 2
+	producer.lock.Unlock()
+
+	publish("after-loss", 6)
+	select {
+	case gap := <-gaps:
+		if gap.Producer != "producer" || gap.From != 4 || gap.To != 5 {
+			t.Fatalf("gap mismatch: have %+v, want %s/%d-%d.", gap, "producer", 4, 5)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("gap not reported.")
+	}
+	expect("after-loss", 6)
+
+	// Verify that a restarted producer isn't mistaken for a loss
+	if producer, err = NewProducer(conn, "producer"); err != nil {
+		t.Fatalf("failed to recreate producer: %v.", err)
+	}
+	publish("restarted", 1)
+	expect("restarted", 1)
+
+	// Verify that unsequenced events are rejected
+	if err := conn.Publish("sequence-topic", []byte("plain")); err != nil {
+		t.Fatalf("failed to publish plain event: %v.", err)
+	}
+	select {
+	case event := <-malformed:
+		if string(event) != "plain" {
+			t.Fatalf("malformed event mismatch: have %q, want %q.", event, "plain")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("malformed event not reported.")
+	}
+	select {
+	case gap := <-gaps:
+		t.Fatalf("unexpected gap reported: %+v.", gap)
+	default:
+	}
+}
@@ -7,11 +7,13 @@
 package iris
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"sync/atomic"
 
 	"gopkg.in/inconshreveable/log15.v2"
+	"gopkg.in/project-iris/iris-go.v1/internal/pool"
 )
 
 // Callback interface for processing inbound messages designated to a particular
@@ -35,10 +37,14 @@ type ServiceHandler interface {
 	// Returning nil for both or none of the results will result in a panic. Also,
 	// since the requests cross language boundaries, only the error string gets
 	// delivered remotely (any associated type information is effectively lost).
+	//
+	// Handlers receiving the delivery metadata may also defer the reply, sending
+	// it later through Metadata.Responder after returning ErrPending.
 	HandleRequest(request []byte) ([]byte, error)
 
 	// Callback invoked whenever a tunnel designated to the service's cluster is
-	// constructed from a remote node to this particular instance.
+	// constructed from a remote node to this particular instance. Not invoked if
+	// the service queues its tunnels for AcceptTunnel instead.
 	HandleTunnel(tunnel *Tunnel)
 
 	// Callback notifying the service that the local relay dropped its connection.
@@ -56,16 +62,30 @@ var nextServId uint64
 
 // Connects to the Iris network and registers a new service instance as a member
 // of the specified service cluster.
-func Register(port int, cluster string, handler ServiceHandler, limits *ServiceLimits) (*Service, error) {
+func Register(port int, cluster string, handler interface{}, limits *ServiceLimits) (*Service, error) {
+	return RegisterWithOptions(port, cluster, handler, limits, nil)
+}
+
+// Connects to the Iris network and registers a new service instance as a member
+// of the specified service cluster, overriding some of the default connection
+// settings.
+//
+// The handler needs to implement either ServiceHandler or ServiceHandlerV2.
+func RegisterWithOptions(port int, cluster string, handler interface{}, limits *ServiceLimits, options *Options) (*Service, error) {
 	// Sanity check on the arguments
 	if len(cluster) == 0 {
 		return nil, errors.New("empty cluster identifier")
 	}
-	if handler == nil {
-		return nil, errors.New("nil service handler")
+	handlerV1, err := serviceHandler(handler)
+	if err != nil {
+		return nil, err
 	}
-	// Make sure the service limits have valid values
+	if options != nil && (options.Fair || options.Fairness != nil) && options.Deadlines {
+		return nil, errors.New("fair and deadline scheduling are mutually exclusive")
+	}
+	// Make sure the service limits and options have valid values
 	limits = finalizeServiceLimits(limits)
+	options = finalizeOptions(options)
 
 	logger := Log.New("service", atomic.AddUint64(&nextServId, 1))
 	logger.Info("registering new service", "relay_port", port, "cluster", cluster,
@@ -77,7 +97,7 @@ func Register(port int, cluster string, handler ServiceHandler, limits *ServiceL
 		}})
 
 	// Connect to the Iris relay as a service
-	conn, err := newConnection(port, cluster, handler, limits, logger)
+	conn, err := newConnection(port, cluster, handlerV1, limits, options, nil, logger)
 	if err != nil {
 		logger.Warn("failed to register new service", "reason", err)
 		return nil, err
@@ -87,16 +107,17 @@ func Register(port int, cluster string, handler ServiceHandler, limits *ServiceL
 		conn: conn,
 		Log:  logger,
 	}
-	if err := handler.Init(conn); err != nil {
+	if err := handlerV1.Init(conn); err != nil {
 		logger.Warn("user failed to initialize service", "reason", err)
 		conn.Close()
 		return nil, err
 	}
 	logger.Info("service registration completed")
 
-	// Start the handler pools
+	// Start the handler pools and accept any in-process traffic
 	conn.bcastPool.Start()
 	conn.reqPool.Start()
+	conn.joinLoopback()
 
 	return serv, nil
 }
@@ -131,13 +152,115 @@ func finalizeServiceLimits(user *ServiceLimits) *ServiceLimits {
 //
 // The call blocks until the tear-down is confirmed by the Iris node.
 func (s *Service) Unregister() error {
-	// Tear-down the connection
+	// Stop accepting in-process traffic and tear-down the connection
+	s.conn.leaveLoopback()
 	err := s.conn.Close()
 
 	// Stop all the thread pools (drop unprocessed messages)
-	s.conn.reqPool.Terminate(true)
-	s.conn.bcastPool.Terminate(true)
+	s.conn.terminatePools()
 
 	// Return the result of the connection close
 	return err
 }
+
+// Blocks until either the context is cancelled, in which case the service is
+// unregistered, or the service terminates. Suitable for running the service in
+// an errgroup alongside other long running components.
+//
+// The result is the failure of the unregistration on cancellation, nil if the
+// service was unregistered elsewhere, or the reason of the drop otherwise.
+func (s *Service) Run(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return s.Unregister()
+	case <-s.conn.term:
+		if atomic.LoadInt32(&s.conn.closing) != 0 {
+			return nil
+		}
+		// Release the handler pools, the link is gone anyway
+		s.conn.terminatePools()
+
+		if err := s.conn.termErr; err != nil {
+			return err
+		}
+		return errors.New("relay closed the connection")
+	}
+}
+
+// Retrieves the next inbound tunnel from the accept queue, blocking until one
+// arrives, the context is cancelled or the service terminates. Only available
+// if the service was registered with a tunnel backlog, in which case inbound
+// tunnels are queued instead of being passed to HandleTunnel, and any arriving
+// while the backlog is full are rejected.
+func (s *Service) AcceptTunnel(ctx context.Context) (*Tunnel, error) {
+	// Sanity check on the arguments
+	if s.conn.tunAcpt == nil {
+		return nil, errors.New("tunnel accept queue not enabled")
+	}
+	select {
+	case tun := <-s.conn.tunAcpt:
+		return tun, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-s.conn.term:
+		return nil, ErrClosed
+	}
+}
+
+// Marks the service ready or unready to accept new work. While unready, arriving
+// requests (health probes included) are rejected with ErrUnavailable, and new
+// inbound tunnels are aborted, whereas already accepted requests, tunnels and
+// any broadcasts or events are still processed. This allows draining a member
+// before a restart without its callers timing out: they may retry elsewhere.
+//
+// The relay protocol has no notion of readiness, so rejections happen in the
+// binding after the relay routed the work to this member.
+func (s *Service) SetReady(ready bool) {
+	var flag int32
+	if !ready {
+		flag = 1
+	}
+	if atomic.SwapInt32(&s.conn.unready, flag) != flag {
+		s.Log.Info("service readiness changed", "ready", ready)
+	}
+}
+
+// Retrieves whether the service is accepting new work.
+func (s *Service) Ready() bool {
+	return atomic.LoadInt32(&s.conn.unready) == 0
+}
+
+// Adjusts the threading and memory limits of the live service. Memory limits
+// apply to the next arriving messages, whereas thread limits are applied by
+// swapping in new handler pools: the call blocks until the handlers running or
+// queued under the old limits finish, while new arrivals already run under the
+// new ones.
+func (s *Service) SetLimits(limits *ServiceLimits) error {
+	limits = finalizeServiceLimits(limits)
+
+	// Swap in the new limits and pools, unless the service is already down
+	s.conn.limitLock.Lock()
+	select {
+	case <-s.conn.term:
+		s.conn.limitLock.Unlock()
+		return ErrClosed
+	default:
+	}
+	oldBcast, oldReq := s.conn.bcastPool, s.conn.reqPool
+
+	s.conn.limits = limits
+	s.conn.bcastPool = pool.NewThreadPool(limits.BroadcastThreads)
+	s.conn.reqPool = pool.NewThreadPool(limits.RequestThreads)
+	s.conn.bcastPool.Start()
+	s.conn.reqPool.Start()
+	s.conn.limitLock.Unlock()
+
+	s.Log.Info("service limits adjusted",
+		"broadcast_limits", fmt.Sprintf("%dT|%dB", limits.BroadcastThreads, limits.BroadcastMemory),
+		"request_limits", fmt.Sprintf("%dT|%dB", limits.RequestThreads, limits.RequestMemory))
+
+	// Drain the excess workers of the old pools
+	oldBcast.Terminate(false)
+	oldReq.Terminate(false)
+	return nil
+}
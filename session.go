@@ -0,0 +1,190 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the correlated request-reply sessions over tunnels.
+//
+// A tunnel pins its two ends to specific instances, so issuing requests through
+// it reaches the same remote member every time, without the cluster routing of
+// plain requests. Each session message is tagged by its kind and the id of the
+// request it belongs to, so multiple requests may be in flight at once, their
+// replies matched up regardless of arrival order. The serving end handles the
+// requests one by one, in the order they were issued.
+//
+// Once a tunnel is used for requests, its messages are consumed by the session,
+// so it must not be read from directly any more.
+
+package iris
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Kinds of the session messages.
+const (
+	sessionRequest byte = iota // Request to be served by the remote end
+	sessionReply               // Successful reply to a request
+	sessionFault               // Failure reply to a request
+)
+
+// Outcome of a session request.
+type sessionResult struct {
+	reply []byte // Reply of the remote handler, if successful
+	fault string // Failure of the remote handler, if any
+}
+
+// Request issuing side of a tunnel session.
+type session struct {
+	tun  *Tunnel                       // Tunnel the session runs over
+	idx  uint64                        // Id to assign to the next request
+	pend map[uint64]chan sessionResult // Requests waiting for their replies
+	lock sync.Mutex                    // Protects the request bookkeeping
+}
+
+// Assembles a session message of the given kind.
+func encodeSession(kind byte, id uint64, payload []byte) []byte {
+	msg := binary.AppendUvarint([]byte{kind}, id)
+	return append(msg, payload...)
+}
+
+// Splits a session message into its kind, request id and payload.
+func decodeSession(msg []byte) (byte, uint64, []byte, error) {
+	if len(msg) == 0 {
+		return 0, 0, nil, errors.New("empty session message")
+	}
+	id, n := binary.Uvarint(msg[1:])
+	if n <= 0 {
+		return 0, 0, nil, errors.New("malformed session request id")
+	}
+	return msg[0], id, msg[1+n:], nil
+}
+
+// Issues a request to the remote end of the tunnel, which must be serving them
+// via Serve, and waits for its reply. Requests may be issued concurrently, and
+// are served in the order they were sent.
+//
+// The timeout unit is in milliseconds. Anything lower will fail with an error,
+// unless a default request timeout is configured, in which case a zero timeout
+// falls back to it.
+func (t *Tunnel) Request(request []byte, timeout time.Duration) ([]byte, error) {
+	// Sanity check on the arguments
+	if request == nil || len(request) == 0 {
+		return nil, errors.New("nil or empty request")
+	}
+	if timeout == 0 {
+		timeout = t.conn.options.Defaults.request()
+	}
+	if timeout < time.Millisecond {
+		return nil, fmt.Errorf("invalid timeout %v < 1ms", timeout)
+	}
+	// Register the request with the session and send it
+	sess := t.startSession()
+
+	replyc := make(chan sessionResult, 1)
+	sess.lock.Lock()
+	id := sess.idx
+	sess.idx++
+	sess.pend[id] = replyc
+	sess.lock.Unlock()
+
+	defer func() {
+		sess.lock.Lock()
+		delete(sess.pend, id)
+		sess.lock.Unlock()
+	}()
+	deadline := t.conn.clock.After(timeout)
+	if err := t.send(context.Background(), encodeSession(sessionRequest, id, request), deadline); err != nil {
+		return nil, err
+	}
+	// Wait for the reply, the timeout or the tunnel closure
+	select {
+	case res := <-replyc:
+		if res.fault != "" {
+			return nil, &RemoteError{errors.New(res.fault)}
+		}
+		return res.reply, nil
+	case <-deadline:
+		return nil, ErrTimeout
+	case <-t.term:
+		return nil, ErrClosed
+	}
+}
+
+// Starts the session of the tunnel if not yet running, consuming the inbound
+// messages as replies to the issued requests.
+func (t *Tunnel) startSession() *session {
+	t.sessOnce.Do(func() {
+		t.sess = &session{
+			tun:  t,
+			pend: make(map[uint64]chan sessionResult),
+		}
+		go t.sess.loop()
+	})
+	return t.sess
+}
+
+// Delivers the arriving replies to the pending requests until the tunnel closes.
+func (s *session) loop() {
+	for {
+		msg, err := s.tun.recv(nil)
+		if err != nil {
+			return
+		}
+		kind, id, payload, err := decodeSession(msg)
+		if err != nil || kind == sessionRequest {
+			s.tun.Log.Warn("dropping invalid session reply", "reason", err)
+			continue
+		}
+		s.lock.Lock()
+		replyc, ok := s.pend[id]
+		s.lock.Unlock()
+
+		if !ok {
+			s.tun.Log.Warn("dropping reply of unknown session request", "session_request", id)
+			continue
+		}
+		if kind == sessionFault {
+			replyc <- sessionResult{fault: string(payload)}
+		} else {
+			replyc <- sessionResult{reply: payload}
+		}
+	}
+}
+
+// Serves the requests issued from the remote end of the tunnel via Request, in
+// arrival order, until the tunnel is closed. Failures returned by the handler
+// are relayed to the requester as remote errors.
+func (t *Tunnel) Serve(handler func(request []byte) ([]byte, error)) error {
+	for {
+		msg, err := t.recv(nil)
+		if err == ErrClosed {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		kind, id, request, err := decodeSession(msg)
+		if err != nil || kind != sessionRequest {
+			t.Log.Warn("dropping invalid session request", "reason", err)
+			continue
+		}
+		if reply, err := handler(request); err != nil {
+			msg = encodeSession(sessionFault, id, []byte(err.Error()))
+		} else {
+			msg = encodeSession(sessionReply, id, reply)
+		}
+		if err := t.send(context.Background(), msg, nil); err != nil {
+			if err == ErrClosed {
+				return nil
+			}
+			return err
+		}
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Service handler serving the requests of inbound tunnel sessions.
+type sessionTestHandler struct {
+	served []string
+	lock   sync.Mutex
+}
+
+func (s *sessionTestHandler) Init(conn *Connection) error              { return nil }
+func (s *sessionTestHandler) HandleBroadcast(msg []byte)               { panic("not implemented") }
+func (s *sessionTestHandler) HandleRequest(req []byte) ([]byte, error) { panic("not implemented") }
+func (s *sessionTestHandler) HandleDrop(reason error)                  { panic("not implemented") }
+
+func (s *sessionTestHandler) HandleTunnel(tun *Tunnel) {
+	defer tun.Close()
+
+	tun.Serve(func(req []byte) ([]byte, error) {
+		s.lock.Lock()
+		s.served = append(s.served, string(req))
+		s.lock.Unlock()
+
+		if string(req) == "fail" {
+			return nil, errors.New("requested failure")
+		}
+		return append([]byte("re: "), req...), nil
+	})
+}
+
+// Tests that requests issued over a tunnel are served in order by the pinned
+// remote end, concurrent ones being correlated with their replies.
+func TestTunnelSession(t *testing.T) {
+	handler := new(sessionTestHandler)
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	tun, err := conn.Tunnel(config.cluster, time.Second)
+	if err != nil {
+		t.Fatalf("tunnel construction failed: %v.", err)
+	}
+	defer tun.Close()
+
+	// Issue a batch of sequential requests and check the serving order
+	for i := 0; i < 10; i++ {
+		req := fmt.Sprintf("seq %d", i)
+		if reply, err := tun.Request([]byte(req), time.Second); err != nil || string(reply) != "re: "+req {
+			t.Fatalf("request %d: reply mismatch: have %q/%v, want %q.", i, reply, err, "re: "+req)
+		}
+	}
+	handler.lock.Lock()
+	for i, req := range handler.served {
+		if want := fmt.Sprintf("seq %d", i); req != want {
+			t.Errorf("served request %d mismatch: have %q, want %q.", i, req, want)
+		}
+	}
+	handler.lock.Unlock()
+
+	// Issue concurrent requests and check that the replies are correlated
+	var pend sync.WaitGroup
+	for i := 0; i < 25; i++ {
+		pend.Add(1)
+		go func(i int) {
+			defer pend.Done()
+
+			req := fmt.Sprintf("par %d", i)
+			if reply, err := tun.Request([]byte(req), time.Second); err != nil || string(reply) != "re: "+req {
+				t.Errorf("request %d: reply mismatch: have %q/%v, want %q.", i, reply, err, "re: "+req)
+			}
+		}(i)
+	}
+	pend.Wait()
+
+	// Check that handler failures are relayed as remote errors
+	_, err = tun.Request([]byte("fail"), time.Second)
+	if _, ok := err.(*RemoteError); !ok || !strings.Contains(err.Error(), "requested failure") {
+		t.Fatalf("failure mismatch: have %v, want remote requested failure.", err)
+	}
+	// Check that requests fail once the tunnel is closed
+	tun.Close()
+	if _, err := tun.Request([]byte("closed"), time.Second); err != ErrClosed {
+		t.Fatalf("closed tunnel request mismatch: have %v, want %v.", err, ErrClosed)
+	}
+}
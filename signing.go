@@ -0,0 +1,44 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the message signing and verification hooks.
+
+package iris
+
+// Callback signing an outbound message destined to a cluster or topic. The data
+// to sign covers the target, the envelope headers and the (possibly encrypted)
+// payload, so neither can be tampered with en route.
+type Signer func(target string, data []byte) ([]byte, error)
+
+// Callback verifying the signature of an inbound message arrived through a
+// cluster or topic. Returning an error rejects the message.
+type Verifier func(target string, data []byte, signature []byte) error
+
+// Retrieves the signer configured for a target, falling back to the wildcard.
+func (c *Connection) signer(target string) Signer {
+	if signer, ok := c.options.Signers[target]; ok {
+		return signer
+	}
+	return c.options.Signers[""]
+}
+
+// Retrieves the verifier configured for a target, falling back to the wildcard.
+// Replies are never verified, so an empty target always yields none.
+func (c *Connection) verifier(target string) Verifier {
+	if target == "" {
+		return nil
+	}
+	if verifier, ok := c.options.Verifiers[target]; ok {
+		return verifier
+	}
+	return c.options.Verifiers[""]
+}
+
+// Assembles the canonical signed content of an envelope: the target followed by
+// the deterministically encoded headers (without the signature) and payload.
+func signedEnvelope(target string, headers map[string]string, payload []byte) []byte {
+	return append([]byte(target+"\x00"), wrapEnvelope(headers, payload)...)
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+)
+
+// Tests that services reject unsigned and badly signed messages.
+func TestSigning(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v.", err)
+	}
+	_, forged, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v.", err)
+	}
+	verifier := func(target string, data []byte, signature []byte) error {
+		if !ed25519.Verify(public, data, signature) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+	// Register a new verifying service to the relay
+	handler := &metadataTestHandler{
+		metas: make(chan *Metadata, 1),
+	}
+	serv, err := RegisterWithOptions(config.relay, config.cluster, handler, nil, &Options{
+		Verifiers: map[string]Verifier{config.cluster: verifier},
+	})
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	// Issue requests with valid, forged and missing signatures
+	tests := []struct {
+		key  ed25519.PrivateKey
+		fail string
+	}{
+		{private, ""},
+		{forged, "invalid signature"},
+		{nil, ErrUnsigned.Error()},
+	}
+	for i, tt := range tests {
+		options := new(Options)
+		if tt.key != nil {
+			key := tt.key
+			options.Signers = map[string]Signer{"": func(target string, data []byte) ([]byte, error) {
+				return ed25519.Sign(key, data), nil
+			}}
+		}
+		conn, err := ConnectWithOptions(config.relay, options)
+		if err != nil {
+			t.Fatalf("test %d: connection failed: %v.", i, err)
+		}
+		_, err = conn.Request(config.cluster, []byte{0x00}, time.Second)
+		conn.Close()
+
+		switch {
+		case tt.fail == "" && err != nil:
+			t.Fatalf("test %d: request failed: %v.", i, err)
+		case tt.fail != "" && (err == nil || err.Error() != tt.fail):
+			t.Fatalf("test %d: request result mismatch: have %v, want %v.", i, err, tt.fail)
+		}
+		if tt.fail == "" {
+			<-handler.metas
+		}
+	}
+}
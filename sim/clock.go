@@ -0,0 +1,117 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package sim
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Time source of the simulated relay. In real mode it follows the wall clock,
+// whereas in virtual mode time only passes when explicitly advanced, firing any
+// expired timers in deadline order.
+type Clock struct {
+	virtual bool      // Whether time is virtual or real
+	now     time.Time // Current virtual time
+
+	timers []*Timer   // Pending virtual timers
+	seq    uint64     // Sequence number to order timers with equal deadlines
+	lock   sync.Mutex // Protects the virtual time and timers
+}
+
+// Scheduled callback, cancellable until it fires.
+type Timer struct {
+	clock *Clock      // Clock the timer is bound to
+	real  *time.Timer // Wall clock timer in real mode
+
+	when time.Time // Virtual deadline of the timer
+	seq  uint64    // Creation sequence for tie breaking
+	call func()    // Callback to invoke upon expiration
+}
+
+// Creates a new clock, either following the wall clock or a virtual one.
+func newClock(virtual bool) *Clock {
+	return &Clock{
+		virtual: virtual,
+		now:     time.Unix(0, 0),
+	}
+}
+
+// Retrieves the current time of the clock.
+func (c *Clock) Now() time.Time {
+	if !c.virtual {
+		return time.Now()
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.now
+}
+
+// Schedules a callback to be invoked after the specified duration elapses.
+func (c *Clock) AfterFunc(d time.Duration, f func()) *Timer {
+	if !c.virtual {
+		return &Timer{clock: c, real: time.AfterFunc(d, f)}
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.seq++
+	t := &Timer{clock: c, when: c.now.Add(d), seq: c.seq, call: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Advances the virtual time, synchronously firing all the timers that expire in
+// the mean time. It is a no-op on a real clock.
+func (c *Clock) Advance(d time.Duration) {
+	if !c.virtual {
+		return
+	}
+	c.lock.Lock()
+	deadline := c.now.Add(d)
+	for {
+		// Find the next timer to expire, if any
+		sort.Slice(c.timers, func(i, j int) bool {
+			if c.timers[i].when.Equal(c.timers[j].when) {
+				return c.timers[i].seq < c.timers[j].seq
+			}
+			return c.timers[i].when.Before(c.timers[j].when)
+		})
+		if len(c.timers) == 0 || c.timers[0].when.After(deadline) {
+			break
+		}
+		next := c.timers[0]
+		c.timers = c.timers[1:]
+
+		// Move time forward and fire the timer outside the lock
+		c.now = next.when
+		c.lock.Unlock()
+		next.call()
+		c.lock.Lock()
+	}
+	c.now = deadline
+	c.lock.Unlock()
+}
+
+// Cancels the timer, returning whether it was stopped before firing.
+func (t *Timer) Stop() bool {
+	if t.real != nil {
+		return t.real.Stop()
+	}
+	t.clock.lock.Lock()
+	defer t.clock.lock.Unlock()
+
+	for i, timer := range t.clock.timers {
+		if timer == t {
+			t.clock.timers = append(t.clock.timers[:i], t.clock.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,578 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Package sim contains an in-process emulation of an Iris relay node, allowing
+// complex multi-service interactions to be tested within a single process.
+//
+// The simulated relay speaks the same wire protocol as a real one over loopback
+// TCP, so the Go binding connects to it through the usual Connect and Register
+// calls. The routing decisions (i.e. load balancing of requests and tunnels) are
+// drawn from a seeded random source, and the relay side timeouts can be bound to
+// a virtual clock that only moves forward when advanced by the test.
+//
+// The relay does not control goroutine scheduling or the order in which the
+// messages arrive over the sockets. A seed thus fixes the sequence of routing
+// choices, but a rerun only reproduces the same routing if the messages happen
+// to arrive in the same order. Runs are neither fully deterministic nor can a
+// failing one be shrunk to a minimal reproduction.
+package sim
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/project-iris/iris-go.v1/relaywire"
+)
+
+// Configuration of a simulated relay node.
+type Config struct {
+	Port       int   // Loopback port to listen on (zero = random)
+	Seed       int64 // Seed of the routing randomness (zero = time based)
+	Virtual    bool  // Whether to bind the relay timeouts to a virtual clock
+	ChunkLimit int   // Maximum size of a tunnel data chunk (zero = default)
+
+	Authenticate Authenticator // Access control of the handshakes (nil = allow all)
+	Features     []string      // Extension features to agree to if requested (besides the built in ones)
+	Legacy       bool          // Refuse all extensions, as relays predating negotiation
+}
+
+// Access control callback, invoked with the cluster (empty for clients) and the
+// credential presented during the handshake. A non-nil error denies access.
+type Authenticator func(cluster string, kind string, credential []byte) error
+
+// Default maximum size of a tunnel data chunk.
+var defaultChunkLimit = 1024 * 1024
+
+// Simulated relay node routing messages between the attached connections.
+type Relay struct {
+	seed       int64           // Seed used by the routing randomness
+	chunkLimit int             // Maximum size of a tunnel data chunk
+	auth       Authenticator   // Access control of the handshakes
+	features   map[string]bool // Extension features agreed to if requested
+	legacy     bool            // Whether to refuse all extension features
+	listener   net.Listener    // Loopback listener accepting the bindings
+	clock      *Clock          // Time source of the relay side timeouts
+	rng        *rand.Rand      // Random source for the routing decisions
+
+	clients  map[*client]struct{}            // Currently attached connections
+	clusters map[string][]*client            // Service members of each cluster
+	topics   map[string]map[*client]struct{} // Subscribers of each topic
+
+	reqIdx uint64              // Index to assign the next routed request
+	reqs   map[uint64]*request // Requests waiting for a reply
+	tunIdx uint64              // Index to assign the next tunnel construction
+	builds map[uint64]*build   // Tunnels waiting for a confirmation
+
+	lock sync.Mutex     // Protects the routing state
+	pend sync.WaitGroup // Running client handlers to wait for on close
+}
+
+// Attached binding connection.
+type client struct {
+	relay   *Relay             // Relay the client is attached to
+	sock    net.Conn           // Network connection to the binding
+	in      *relaywire.Reader  // Frame decoder of the inbound stream
+	out     *relaywire.Writer  // Frame encoder of the outbound stream
+	outBuf  *bufio.Writer      // Buffered writer of the outbound stream
+	outLock sync.Mutex         // Mutex to atomize packet sending
+	cluster string             // Cluster the client is member of, if any
+	headers bool               // Whether the client agreed to tunnel headers
+	tunnels map[uint64]*tunnel // Live tunnels, keyed by the client side id
+}
+
+// Request routed to a service, waiting for the reply.
+type request struct {
+	origin *client // Client that initiated the request
+	id     uint64  // Request id on the originating client
+	timer  *Timer  // Timer expiring the request
+}
+
+// Tunnel being constructed, waiting for the remote confirmation.
+type build struct {
+	origin *client // Client that initiated the tunnel
+	id     uint64  // Tunnel id on the originating client
+	timer  *Timer  // Timer expiring the construction
+}
+
+// One endpoint of a live tunnel, pointing to the remote pair.
+type tunnel struct {
+	peer   *client // Client at the other end of the tunnel
+	peerId uint64  // Tunnel id on the remote client
+}
+
+// Starts a new simulated relay node, listening on the loopback interface.
+func NewRelay(config *Config) (*Relay, error) {
+	if config == nil {
+		config = new(Config)
+	}
+	listener, err := net.Listen("tcp", fmt.Sprintf("localhost:%d", config.Port))
+	if err != nil {
+		return nil, err
+	}
+	seed := config.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	chunkLimit := config.ChunkLimit
+	if chunkLimit == 0 {
+		chunkLimit = defaultChunkLimit
+	}
+	r := &Relay{
+		seed:       seed,
+		chunkLimit: chunkLimit,
+		auth:       config.Authenticate,
+		features:   make(map[string]bool),
+		legacy:     config.Legacy,
+		listener:   listener,
+		clock:      newClock(config.Virtual),
+		rng:        rand.New(rand.NewSource(seed)),
+		clients:    make(map[*client]struct{}),
+		clusters:   make(map[string][]*client),
+		topics:     make(map[string]map[*client]struct{}),
+		reqs:       make(map[uint64]*request),
+		builds:     make(map[uint64]*build),
+	}
+	for _, feature := range config.Features {
+		r.features[feature] = true
+	}
+	go r.accept()
+	return r, nil
+}
+
+// Retrieves the port on which the relay accepts binding connections.
+func (r *Relay) Port() int {
+	return r.listener.Addr().(*net.TCPAddr).Port
+}
+
+// Retrieves the seed driving the routing decisions, needed to replay a run.
+func (r *Relay) Seed() int64 {
+	return r.seed
+}
+
+// Retrieves the time source of the relay side timeouts.
+func (r *Relay) Clock() *Clock {
+	return r.clock
+}
+
+// Terminates the relay, dropping all the attached connections.
+func (r *Relay) Close() error {
+	err := r.listener.Close()
+
+	r.lock.Lock()
+	for c := range r.clients {
+		c.sock.Close()
+	}
+	r.lock.Unlock()
+
+	r.pend.Wait()
+	return err
+}
+
+// Accepts inbound binding connections until the listener is closed.
+func (r *Relay) accept() {
+	for {
+		sock, err := r.listener.Accept()
+		if err != nil {
+			return
+		}
+		c := &client{
+			relay:   r,
+			sock:    sock,
+			in:      relaywire.NewReader(bufio.NewReader(sock)),
+			outBuf:  bufio.NewWriter(sock),
+			tunnels: make(map[uint64]*tunnel),
+		}
+		c.out = relaywire.NewWriter(c.outBuf)
+
+		r.pend.Add(1)
+		go func() {
+			defer r.pend.Done()
+			c.serve()
+		}()
+	}
+}
+
+// Sends a protocol frame to the client.
+func (c *client) send(frame relaywire.Frame) error {
+	c.outLock.Lock()
+	defer c.outLock.Unlock()
+
+	if err := relaywire.WriteFrame(c.out, frame); err != nil {
+		return err
+	}
+	return c.outBuf.Flush()
+}
+
+// Runs the handshake with the binding and keeps processing its packets until
+// either a graceful close or a failure.
+func (c *client) serve() {
+	defer c.sock.Close()
+
+	if err := c.handshake(); err != nil {
+		return
+	}
+	defer c.relay.detach(c)
+
+	for {
+		frame, err := relaywire.ReadClientFrame(c.in)
+		if err != nil {
+			return
+		}
+		switch frame := frame.(type) {
+		case *relaywire.Broadcast:
+			c.procBroadcast(frame)
+		case *relaywire.Request:
+			c.procRequest(frame)
+		case *relaywire.Reply:
+			c.procReply(frame)
+		case *relaywire.Subscribe:
+			c.procSubscribe(frame.Topic, true)
+		case *relaywire.Unsubscribe:
+			c.procSubscribe(frame.Topic, false)
+		case *relaywire.Publish:
+			c.procPublish(frame.Topic, frame.Event)
+		case *relaywire.PublishConfirm:
+			subs := c.procPublish(frame.Topic, frame.Event)
+			err = c.send(&relaywire.PublishAck{ID: frame.ID, Subscribers: uint64(subs)})
+		case *relaywire.TunnelInit:
+			c.procTunnelInit(frame, nil)
+		case *relaywire.TunnelInitHeader:
+			c.procTunnelInit(&relaywire.TunnelInit{ID: frame.ID, Cluster: frame.Cluster, Timeout: frame.Timeout}, frame.Header)
+		case *relaywire.TunnelConfirm:
+			err = c.procTunnelConfirm(frame)
+		case *relaywire.TunnelAllow:
+			c.procTunnelAllowance(frame)
+		case *relaywire.TunnelTransfer:
+			c.procTunnelTransfer(frame)
+		case *relaywire.TunnelClose:
+			err = c.procTunnelClose(frame.ID, "")
+		case *relaywire.TunnelAbort:
+			err = c.procTunnelClose(frame.ID, frame.Reason)
+		case *relaywire.Close:
+			// Confirm the tear-down and wait for the binding to hang up
+			c.relay.detach(c)
+			c.send(&relaywire.CloseNotify{})
+			for {
+				if _, err := c.in.ReadByte(); err != nil {
+					return
+				}
+			}
+		default:
+			err = fmt.Errorf("protocol violation: unexpected opcode: %v", frame.Opcode())
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Verifies the connection initiation and attaches the client to the relay.
+func (c *client) handshake() error {
+	frame, err := relaywire.ReadClientFrame(c.in)
+	if err != nil {
+		return err
+	}
+	init, ok := frame.(*relaywire.Init)
+	if !ok {
+		return fmt.Errorf("protocol violation: invalid init opcode: %v", frame.Opcode())
+	}
+	// Negotiate the protocol version and extension features
+	parts := strings.Split(init.Version, "+")
+	if parts[0] != relaywire.ProtoVersion || (c.relay.legacy && len(parts) > 1) {
+		c.send(&relaywire.InitDeny{Reason: "unsupported protocol version: " + init.Version})
+		return errors.New("unsupported protocol version")
+	}
+	granted, checksum := relaywire.ProtoVersion, false
+	for _, feature := range parts[1:] {
+		switch {
+		case feature == relaywire.FeatureAuth || feature == relaywire.FeatureConfirm || feature == relaywire.FeatureAbort || c.relay.features[feature]:
+			granted += "+" + feature
+		case feature == relaywire.FeatureHeader:
+			granted, c.headers = granted+"+"+feature, true
+		case feature == relaywire.FeatureCRC:
+			granted, checksum = granted+"+"+feature, true
+		}
+	}
+	// Authenticate the presented credentials if required
+	if c.relay.auth != nil {
+		if init.Kind == "" {
+			err = errors.New("missing credentials")
+		} else {
+			err = c.relay.auth(init.Cluster, init.Kind, init.Credential)
+		}
+		if err != nil {
+			c.send(&relaywire.InitDeny{Reason: authDenyPrefix + err.Error()})
+			return err
+		}
+	}
+	c.cluster = init.Cluster
+	c.relay.attach(c)
+
+	// Accept the connection, checksumming all subsequent frames if agreed to
+	c.in.SetChecksums(checksum)
+
+	c.outLock.Lock()
+	defer c.outLock.Unlock()
+
+	if err := relaywire.WriteFrame(c.out, &relaywire.InitAccept{Version: granted}); err != nil {
+		return err
+	}
+	c.out.SetChecksums(checksum)
+	return c.outBuf.Flush()
+}
+
+// Registers a client, and its cluster membership if a service.
+func (r *Relay) attach(c *client) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.clients[c] = struct{}{}
+	if c.cluster != "" {
+		r.clusters[c.cluster] = append(r.clusters[c.cluster], c)
+	}
+}
+
+// Removes a client from the relay, tearing down all its live tunnels.
+func (r *Relay) detach(c *client) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, ok := r.clients[c]; !ok {
+		return
+	}
+	delete(r.clients, c)
+	if c.cluster != "" {
+		members := r.clusters[c.cluster]
+		for i, member := range members {
+			if member == c {
+				r.clusters[c.cluster] = append(members[:i:i], members[i+1:]...)
+				break
+			}
+		}
+	}
+	for _, subs := range r.topics {
+		delete(subs, c)
+	}
+	for id, req := range r.reqs {
+		if req.origin == c {
+			req.timer.Stop()
+			delete(r.reqs, id)
+		}
+	}
+	for id, b := range r.builds {
+		if b.origin == c {
+			b.timer.Stop()
+			delete(r.builds, id)
+		}
+	}
+	for _, tun := range c.tunnels {
+		delete(tun.peer.tunnels, tun.peerId)
+		go tun.peer.send(&relaywire.TunnelCloseNotify{ID: tun.peerId, Reason: "remote endpoint dropped"})
+	}
+	c.tunnels = nil
+}
+
+// Picks a random member of a cluster, or nil if none is available.
+func (r *Relay) pick(cluster string) *client {
+	members := r.clusters[cluster]
+	if len(members) == 0 {
+		return nil
+	}
+	return members[r.rng.Intn(len(members))]
+}
+
+// Delivers a broadcast to all the members of a cluster.
+func (c *client) procBroadcast(frame *relaywire.Broadcast) {
+	c.relay.lock.Lock()
+	members := append([]*client{}, c.relay.clusters[frame.Cluster]...)
+	c.relay.lock.Unlock()
+
+	delivery := &relaywire.BroadcastDelivery{Message: frame.Message}
+	for _, member := range members {
+		member.send(delivery)
+	}
+}
+
+// Routes a request to a random member of a cluster.
+func (c *client) procRequest(frame *relaywire.Request) {
+	r := c.relay
+
+	r.lock.Lock()
+	r.reqIdx++
+	reqId := r.reqIdx
+	server := r.pick(frame.Cluster)
+	r.reqs[reqId] = &request{
+		origin: c,
+		id:     frame.ID,
+		timer: r.clock.AfterFunc(time.Duration(frame.Timeout)*time.Millisecond, func() {
+			r.lock.Lock()
+			_, ok := r.reqs[reqId]
+			delete(r.reqs, reqId)
+			r.lock.Unlock()
+
+			if ok {
+				c.send(&relaywire.ReplyDelivery{ID: frame.ID, Timeout: true})
+			}
+		}),
+	}
+	r.lock.Unlock()
+
+	if server != nil {
+		server.send(&relaywire.RequestDelivery{ID: reqId, Request: frame.Request, Timeout: frame.Timeout})
+	}
+}
+
+// Forwards a service reply to the originator of the request.
+func (c *client) procReply(frame *relaywire.Reply) {
+	r := c.relay
+
+	r.lock.Lock()
+	req, ok := r.reqs[frame.ID]
+	if ok {
+		req.timer.Stop()
+		delete(r.reqs, frame.ID)
+	}
+	r.lock.Unlock()
+
+	if ok {
+		req.origin.send(&relaywire.ReplyDelivery{ID: req.id, Reply: frame.Reply, Fault: frame.Fault})
+	}
+}
+
+// Adds or removes a topic subscription of the client.
+func (c *client) procSubscribe(topic string, subscribe bool) {
+	r := c.relay
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if subscribe {
+		if _, ok := r.topics[topic]; !ok {
+			r.topics[topic] = make(map[*client]struct{})
+		}
+		r.topics[topic][c] = struct{}{}
+	} else {
+		delete(r.topics[topic], c)
+	}
+}
+
+// Delivers an event to all the subscribers of a topic, returning their number.
+func (c *client) procPublish(topic string, event []byte) int {
+	r := c.relay
+
+	r.lock.Lock()
+	subs := make([]*client, 0, len(r.topics[topic]))
+	for sub := range r.topics[topic] {
+		subs = append(subs, sub)
+	}
+	r.lock.Unlock()
+
+	delivery := &relaywire.PublishDelivery{Topic: topic, Event: event}
+	for _, sub := range subs {
+		sub.send(delivery)
+	}
+	return len(subs)
+}
+
+// Initiates the construction of a tunnel to a random member of a cluster,
+// forwarding the header of the initiator if one was attached.
+func (c *client) procTunnelInit(frame *relaywire.TunnelInit, header []byte) {
+	r := c.relay
+
+	r.lock.Lock()
+	r.tunIdx++
+	buildId := r.tunIdx
+	server := r.pick(frame.Cluster)
+	r.builds[buildId] = &build{
+		origin: c,
+		id:     frame.ID,
+		timer: r.clock.AfterFunc(time.Duration(frame.Timeout)*time.Millisecond, func() {
+			r.lock.Lock()
+			_, ok := r.builds[buildId]
+			delete(r.builds, buildId)
+			r.lock.Unlock()
+
+			if ok {
+				c.send(&relaywire.TunnelResult{ID: frame.ID, Timeout: true})
+			}
+		}),
+	}
+	r.lock.Unlock()
+
+	switch {
+	case server == nil:
+	case header != nil && server.headers:
+		server.send(&relaywire.TunnelInitHeaderDelivery{ID: buildId, ChunkLimit: uint64(r.chunkLimit), Header: header})
+	default:
+		server.send(&relaywire.TunnelInitDelivery{ID: buildId, ChunkLimit: uint64(r.chunkLimit)})
+	}
+}
+
+// Links the two endpoints of a tunnel upon the remote confirmation.
+func (c *client) procTunnelConfirm(frame *relaywire.TunnelConfirm) error {
+	r := c.relay
+
+	r.lock.Lock()
+	b, ok := r.builds[frame.BuildID]
+	if ok {
+		b.timer.Stop()
+		delete(r.builds, frame.BuildID)
+
+		c.tunnels[frame.ID] = &tunnel{peer: b.origin, peerId: b.id}
+		b.origin.tunnels[b.id] = &tunnel{peer: c, peerId: frame.ID}
+	}
+	r.lock.Unlock()
+
+	if !ok {
+		return c.send(&relaywire.TunnelCloseNotify{ID: frame.ID, Reason: "tunnel construction timed out"})
+	}
+	return b.origin.send(&relaywire.TunnelResult{ID: b.id, ChunkLimit: uint64(r.chunkLimit)})
+}
+
+// Looks up the remote endpoint of a live tunnel.
+func (c *client) peer(id uint64) *tunnel {
+	c.relay.lock.Lock()
+	defer c.relay.lock.Unlock()
+
+	return c.tunnels[id]
+}
+
+// Forwards a tunnel data allowance to the remote endpoint.
+func (c *client) procTunnelAllowance(frame *relaywire.TunnelAllow) {
+	if tun := c.peer(frame.ID); tun != nil {
+		tun.peer.send(&relaywire.TunnelAllow{ID: tun.peerId, Space: frame.Space})
+	}
+}
+
+// Forwards a tunnel data chunk to the remote endpoint.
+func (c *client) procTunnelTransfer(frame *relaywire.TunnelTransfer) {
+	if tun := c.peer(frame.ID); tun != nil {
+		tun.peer.send(&relaywire.TunnelTransfer{ID: tun.peerId, Size: frame.Size, Payload: frame.Payload})
+	}
+}
+
+// Tears down a tunnel, notifying both endpoints, the remote one along with the
+// reason if aborted.
+func (c *client) procTunnelClose(id uint64, reason string) error {
+	c.relay.lock.Lock()
+	tun, ok := c.tunnels[id]
+	if ok {
+		delete(c.tunnels, id)
+		delete(tun.peer.tunnels, tun.peerId)
+	}
+	c.relay.lock.Unlock()
+
+	if ok {
+		tun.peer.send(&relaywire.TunnelCloseNotify{ID: tun.peerId, Reason: reason})
+	}
+	return c.send(&relaywire.TunnelCloseNotify{ID: id})
+}
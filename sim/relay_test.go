@@ -0,0 +1,115 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package sim
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"gopkg.in/project-iris/iris-go.v1"
+)
+
+// Service handler replying with the identity of the serving instance.
+type identityHandler struct {
+	id   int
+	conn *iris.Connection
+}
+
+func (h *identityHandler) Init(conn *iris.Connection) error { h.conn = conn; return nil }
+func (h *identityHandler) HandleBroadcast(msg []byte)       {}
+func (h *identityHandler) HandleTunnel(tun *iris.Tunnel)    { tun.Close() }
+func (h *identityHandler) HandleDrop(reason error)          {}
+
+func (h *identityHandler) HandleRequest(req []byte) ([]byte, error) {
+	return []byte(fmt.Sprintf("%d", h.id)), nil
+}
+
+// Runs a batch of requests against a fresh relay and returns the serving order.
+func simulateRouting(seed int64, servers, requests int) ([]byte, error) {
+	relay, err := NewRelay(&Config{Seed: seed})
+	if err != nil {
+		return nil, err
+	}
+	defer relay.Close()
+
+	for i := 0; i < servers; i++ {
+		serv, err := iris.Register(relay.Port(), "sim-test", &identityHandler{id: i}, nil)
+		if err != nil {
+			return nil, err
+		}
+		defer serv.Unregister()
+	}
+	conn, err := iris.Connect(relay.Port())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	order := []byte{}
+	for i := 0; i < requests; i++ {
+		reply, err := conn.Request("sim-test", []byte{0x00}, time.Second)
+		if err != nil {
+			return nil, err
+		}
+		order = append(order, reply...)
+	}
+	return order, nil
+}
+
+// Tests that identically seeded relays make the same routing decisions.
+func TestDeterministicRouting(t *testing.T) {
+	first, err := simulateRouting(42, 5, 100)
+	if err != nil {
+		t.Fatalf("first simulation failed: %v.", err)
+	}
+	second, err := simulateRouting(42, 5, 100)
+	if err != nil {
+		t.Fatalf("second simulation failed: %v.", err)
+	}
+	if !bytes.Equal(first, second) {
+		t.Fatalf("routing mismatch: %s != %s.", first, second)
+	}
+}
+
+// Tests that relay side timeouts only fire when virtual time is advanced.
+func TestVirtualTimeout(t *testing.T) {
+	relay, err := NewRelay(&Config{Virtual: true})
+	if err != nil {
+		t.Fatalf("relay creation failed: %v.", err)
+	}
+	defer relay.Close()
+
+	conn, err := iris.Connect(relay.Port())
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Request from a non existent cluster and check it's pending
+	errc := make(chan error, 1)
+	go func() {
+		_, err := conn.Request("sim-missing", []byte{0x00}, time.Hour)
+		errc <- err
+	}()
+	select {
+	case err := <-errc:
+		t.Fatalf("request completed prematurely: %v.", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	// Advance the virtual time and make sure it times out
+	relay.Clock().Advance(time.Hour)
+	select {
+	case err := <-errc:
+		if err != iris.ErrTimeout {
+			t.Fatalf("request result mismatch: have %v, want %v.", err, iris.ErrTimeout)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("request didn't time out.")
+	}
+}
@@ -0,0 +1,13 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the relay side of the wire protocol. The frame encoding itself lives
+// in the relaywire package.
+
+package sim
+
+// Prefix of the connection denial reasons caused by failed authentication.
+var authDenyPrefix = "authentication failed: "
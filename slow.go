@@ -0,0 +1,99 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the detection of slow consumers: broadcast or topic handlers whose
+// queue stays above an occupancy threshold for a sustained period. Congestion is
+// sampled upon message arrival, and reported once per congestion episode.
+
+package iris
+
+import (
+	"sync"
+	"time"
+)
+
+// Settings of the slow consumer detection. Any unset fields (i.e. value of zero)
+// will default to the preset ones.
+type SlowConsumerPolicy struct {
+	Threshold float64       // Queue memory occupancy ratio considered congested
+	Duration  time.Duration // Sustained congestion after which the consumer is reported
+
+	Notify func(report *SlowConsumer) // Callback receiving the slow consumer reports
+}
+
+// Default settings of the slow consumer detection.
+var defaultSlowConsumerPolicy = SlowConsumerPolicy{
+	Threshold: 0.8,
+	Duration:  5 * time.Second,
+}
+
+// Report of a handler queue not keeping up with the arrivals.
+type SlowConsumer struct {
+	Topic     string        // Topic of the congested subscription (empty for broadcasts)
+	Used      int           // Memory used by the queued messages
+	Limit     int           // Memory allowance of the queue
+	Congested time.Duration // Time the queue has been congested for
+}
+
+// Congestion tracker of a single handler queue.
+type slowDetector struct {
+	policy SlowConsumerPolicy // Settings of the detection
+	topic  string             // Topic of the tracked subscription (empty for broadcasts)
+	clock  Clock              // Time source of the congestion episodes
+	since  time.Time          // Start of the current congestion episode (zero if none)
+	fired  bool               // Whether the current episode was already reported
+	lock   sync.Mutex         // Protects the congestion state
+}
+
+// Creates a congestion tracker for a handler queue, or nil if slow consumer
+// detection is disabled.
+func newSlowDetector(user *SlowConsumerPolicy, topic string, clock Clock) *slowDetector {
+	if user == nil || user.Notify == nil {
+		return nil
+	}
+	policy := *user
+	if policy.Threshold == 0 {
+		policy.Threshold = defaultSlowConsumerPolicy.Threshold
+	}
+	if policy.Duration == 0 {
+		policy.Duration = defaultSlowConsumerPolicy.Duration
+	}
+	return &slowDetector{
+		policy: policy,
+		topic:  topic,
+		clock:  clock,
+	}
+}
+
+// Samples the occupancy of the tracked queue, reporting the consumer if it has
+// been congested for longer than permitted. A nil tracker is a no-op.
+func (d *slowDetector) observe(used, limit int) {
+	if d == nil {
+		return
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	// Reset the episode if the queue caught up
+	if float64(used) < d.policy.Threshold*float64(limit) {
+		d.since, d.fired = time.Time{}, false
+		return
+	}
+	// Otherwise start or continue the congestion episode
+	now := d.clock.Now()
+	if d.since.IsZero() {
+		d.since = now
+	}
+	if !d.fired && now.Sub(d.since) >= d.policy.Duration {
+		d.fired = true
+		go d.policy.Notify(&SlowConsumer{
+			Topic:     d.topic,
+			Used:      used,
+			Limit:     limit,
+			Congested: now.Sub(d.since),
+		})
+	}
+}
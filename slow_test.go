@@ -0,0 +1,58 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"testing"
+	"time"
+)
+
+// Tests that a topic handler persistently falling behind is reported.
+func TestSlowConsumer(t *testing.T) {
+	reports := make(chan *SlowConsumer, 1)
+	conn, err := ConnectWithOptions(config.relay, &Options{
+		SlowConsumer: &SlowConsumerPolicy{
+			Threshold: 0.5,
+			Duration:  100 * time.Millisecond,
+			Notify:    func(report *SlowConsumer) { reports <- report },
+		},
+	})
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Subscribe a slow handler with a small queue
+	handler := &publishLimitTestTopicHandler{
+		delivers: make(chan []byte, 64),
+		sleep:    50 * time.Millisecond,
+	}
+	if err := conn.Subscribe(config.topic, handler, &TopicLimits{EventThreads: 1, EventMemory: 8}); err != nil {
+		t.Fatalf("subscription failed: %v.", err)
+	}
+	defer conn.Unsubscribe(config.topic)
+	time.Sleep(100 * time.Millisecond)
+
+	// Keep publishing faster than the handler can cope with
+	for i := 0; i < 40; i++ {
+		if err := conn.Publish(config.topic, []byte{byte(i)}); err != nil {
+			t.Fatalf("publish failed: %v.", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case report := <-reports:
+		if report.Topic != config.topic || report.Limit != 8 {
+			t.Fatalf("report mismatch: have %+v.", report)
+		}
+		if report.Congested < 100*time.Millisecond {
+			t.Fatalf("congestion duration too low: have %v, want >= %v.", report.Congested, 100*time.Millisecond)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("slow consumer not reported.")
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the off-heap staging of large inbound tunnel messages. Messages at or
+// above the configured threshold are assembled chunk by chunk into temporary
+// files (memory mapped where the platform permits) instead of a heap buffer, and
+// are handed to the application as an io.ReaderAt.
+//
+// Only tunnel messages are staged, being the only ones chunked on the wire; the
+// broadcasts, requests and events arrive in a single relay frame.
+
+package iris
+
+import (
+	"io"
+	"os"
+)
+
+// Inbound tunnel message, either held on the heap or staged off-heap. It must
+// be closed after use to release any staging resources.
+type Payload struct {
+	size    int64        // Total length of the message
+	data    []byte       // Heap buffer or memory mapping holding the message (nil if file backed)
+	file    *os.File     // Temporary file holding the message (nil if in memory)
+	release func() error // Releases the staging resources (nil if heap backed)
+}
+
+// Wraps a heap message into a payload.
+func newHeapPayload(message []byte) *Payload {
+	return &Payload{
+		size: int64(len(message)),
+		data: message,
+	}
+}
+
+// Creates an off-heap payload of the given size in the staging directory.
+func newStagedPayload(dir string, size int) (*Payload, error) {
+	file, err := os.CreateTemp(dir, "iris-stage-")
+	if err != nil {
+		return nil, err
+	}
+	if err := file.Truncate(int64(size)); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	return stageFile(file, size)
+}
+
+// Retrieves the length of the message.
+func (p *Payload) Size() int64 {
+	return p.size
+}
+
+// Implements io.ReaderAt.
+func (p *Payload) ReadAt(buf []byte, off int64) (int, error) {
+	if p.data == nil {
+		return p.file.ReadAt(buf, off)
+	}
+	if off >= p.size {
+		return 0, io.EOF
+	}
+	n := copy(buf, p.data[off:])
+	if n < len(buf) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Releases any staging resources held by the payload.
+func (p *Payload) Close() error {
+	if p.release == nil {
+		return nil
+	}
+	release := p.release
+	p.data, p.file, p.release = nil, nil, nil
+	return release()
+}
+
+// Stores a chunk of the message being assembled at the given offset.
+func (p *Payload) writeAt(chunk []byte, off int) error {
+	if p.data == nil {
+		_, err := p.file.WriteAt(chunk, int64(off))
+		return err
+	}
+	copy(p.data[off:], chunk)
+	return nil
+}
+
+// Copies the message onto the heap, releasing the payload.
+func (p *Payload) bytes() ([]byte, error) {
+	if p.release == nil {
+		return p.data, nil
+	}
+	defer p.Close()
+
+	message := make([]byte, p.size)
+	if _, err := p.ReadAt(message, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return message, nil
+}
@@ -0,0 +1,27 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly
+
+// Contains the plain file staging of large tunnel messages, for platforms where
+// memory mapping is unavailable.
+
+package iris
+
+import "os"
+
+// Uses a sized temporary file directly as the backing of a payload.
+func stageFile(file *os.File, size int) (*Payload, error) {
+	return &Payload{
+		size: int64(size),
+		file: file,
+		release: func() error {
+			file.Close()
+			return os.Remove(file.Name())
+		},
+	}, nil
+}
@@ -0,0 +1,35 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+// +build linux darwin freebsd netbsd openbsd dragonfly
+
+// Contains the memory mapped staging of large tunnel messages.
+
+package iris
+
+import (
+	"os"
+	"syscall"
+)
+
+// Maps a sized temporary file into memory as the backing of a payload. The file
+// is unlinked right away, so the storage is reclaimed as soon as it's unmapped,
+// even if the process dies.
+func stageFile(file *os.File, size int) (*Payload, error) {
+	defer file.Close()
+	defer os.Remove(file.Name())
+
+	data, err := syscall.Mmap(int(file.Fd()), 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+	return &Payload{
+		size:    int64(size),
+		data:    data,
+		release: func() error { return syscall.Munmap(data) },
+	}, nil
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the traffic counters and state introspection of a connection.
+
+package iris
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Snapshot of the traffic counters and internal queue states of a connection.
+type Stats struct {
+	BroadcastsSent uint64 // Broadcasts forwarded to the relay
+	BroadcastsRecv uint64 // Broadcasts arrived from the relay
+	RequestsSent   uint64 // Requests forwarded to the relay
+	RequestsRecv   uint64 // Requests arrived from the relay
+	EventsSent     uint64 // Topic events forwarded to the relay
+	EventsRecv     uint64 // Topic events arrived from the relay
+	TunnelsOpened  uint64 // Tunnels constructed (both inbound and outbound)
+	DeadLetters    uint64 // Failed inbound messages routed to dead-letter handling
+
+	CompressedIn    uint64 // Outbound payload bytes compressed (before compression)
+	CompressedOut   uint64 // Outbound payload bytes compressed (after compression)
+	CompressSkipped uint64 // Outbound payloads sent uncompressed as they didn't shrink
+
+	PendingRequests      int // Outbound requests waiting for a reply
+	Subscriptions        int // Desired topic subscriptions
+	PendingSubscriptions int // Desired subscriptions not yet known by the relay link
+	Tunnels              int // Currently live tunnels
+	ScheduledMessages    int // Delayed broadcasts and publishes not yet due
+	BroadcastMemory      int // Memory used by the queued inbound broadcasts
+	RequestMemory        int // Memory used by the queued inbound requests
+
+	OldestRequestAge time.Duration // Time the oldest queued inbound request has been waiting for
+}
+
+// Traffic counters of a connection, updated atomically.
+type connStats struct {
+	bcastSent uint64
+	bcastRecv uint64
+	reqSent   uint64
+	reqRecv   uint64
+	eventSent uint64
+	eventRecv uint64
+	tunOpened uint64
+
+	deadLetters uint64
+
+	compressIn   uint64
+	compressOut  uint64
+	compressSkip uint64
+}
+
+// Retrieves a snapshot of the connection's traffic counters and queue states.
+func (c *Connection) Stats() *Stats {
+	stats := &Stats{
+		BroadcastsSent: atomic.LoadUint64(&c.stats.bcastSent),
+		BroadcastsRecv: atomic.LoadUint64(&c.stats.bcastRecv),
+		RequestsSent:   atomic.LoadUint64(&c.stats.reqSent),
+		RequestsRecv:   atomic.LoadUint64(&c.stats.reqRecv),
+		EventsSent:     atomic.LoadUint64(&c.stats.eventSent),
+		EventsRecv:     atomic.LoadUint64(&c.stats.eventRecv),
+		TunnelsOpened:  atomic.LoadUint64(&c.stats.tunOpened),
+		DeadLetters:    atomic.LoadUint64(&c.stats.deadLetters),
+
+		CompressedIn:    atomic.LoadUint64(&c.stats.compressIn),
+		CompressedOut:   atomic.LoadUint64(&c.stats.compressOut),
+		CompressSkipped: atomic.LoadUint64(&c.stats.compressSkip),
+
+		BroadcastMemory: int(atomic.LoadInt32(&c.bcastUsed)),
+		RequestMemory:   int(atomic.LoadInt32(&c.reqUsed)),
+	}
+	stats.PendingRequests = c.reqLive.len()
+	for _, lane := range c.lanes {
+		stats.PendingRequests += lane.reqLive.len()
+	}
+
+	c.subLock.RLock()
+	stats.Subscriptions = len(c.subLive)
+	for _, top := range c.subLive {
+		if !top.active {
+			stats.PendingSubscriptions++
+		}
+	}
+	c.subLock.RUnlock()
+
+	c.tunLock.RLock()
+	stats.Tunnels = len(c.tunLive)
+	c.tunLock.RUnlock()
+
+	stats.ScheduledMessages = c.sched.pending()
+
+	if c.reqQueue != nil {
+		stats.OldestRequestAge = c.oldestRequestAge()
+	}
+
+	return stats
+}
+
+// Retrieves the ratio of the compressed outbound payload sizes to their original
+// sizes (lower is better), or zero if nothing was compressed yet.
+func (s *Stats) CompressionRatio() float64 {
+	if s.CompressedIn == 0 {
+		return 0
+	}
+	return float64(s.CompressedOut) / float64(s.CompressedIn)
+}
@@ -0,0 +1,45 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"testing"
+	"time"
+)
+
+// Tests that the traffic counters track the messages passing through.
+func TestStats(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		requests int
+	}{10}
+
+	// Register a new service to the relay
+	handler := new(requestTestHandler)
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	// Execute a few requests and verify the counters on both sides
+	for i := 0; i < conf.requests; i++ {
+		if _, err := handler.conn.Request(config.cluster, []byte{byte(i)}, time.Second); err != nil {
+			t.Fatalf("request %d failed: %v.", i, err)
+		}
+	}
+	stats := handler.conn.Stats()
+	if stats.RequestsSent != uint64(conf.requests) {
+		t.Errorf("sent request count mismatch: have %d, want %d.", stats.RequestsSent, conf.requests)
+	}
+	if stats.RequestsRecv != uint64(conf.requests) {
+		t.Errorf("received request count mismatch: have %d, want %d.", stats.RequestsRecv, conf.requests)
+	}
+	if stats.PendingRequests != 0 {
+		t.Errorf("pending request count mismatch: have %d, want %d.", stats.PendingRequests, 0)
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the channel based consumption of topic events, decoding each event
+// into a typed value before delivering it.
+
+package iris
+
+import (
+	"sync"
+)
+
+// Policy of a stream for handling the events arriving to a full channel.
+type DropPolicy int
+
+const (
+	DropNewest DropPolicy = iota // Discards the arriving event
+	DropOldest                   // Discards the oldest buffered event to make room
+	DropNone                     // Blocks the event handler until there's room
+)
+
+// Settings of a typed topic stream. Any unset fields (i.e. value of zero) will
+// default to the preset ones.
+type StreamOptions[T any] struct {
+	Buffer int                     // Capacity of the delivery channel
+	Drop   DropPolicy              // Handling of events arriving to a full channel
+	Limits *TopicLimits            // Limits on the inbound event processing
+	Decode func([]byte) (T, error) // Event decoder (defaults to raw for []byte and string, by content-type otherwise)
+}
+
+// Default capacity of a stream's delivery channel.
+var defaultStreamBuffer = 64
+
+// Topic handler decoding the events and forwarding them into a channel.
+type streamHandler[T any] struct {
+	conn    *Connection                        // Connection to log through
+	topic   string                             // Topic being streamed
+	sink    chan T                             // Delivery channel of the decoded events
+	drop    DropPolicy                         // Handling of events arriving to a full channel
+	decode  func([]byte, *Metadata) (T, error) // Event decoder
+	done    chan struct{}                      // Channel closed when the stream is stopped
+	stopped bool                               // Whether the delivery channel was closed
+	lock    sync.RWMutex                       // Protects the delivery channel from closure while sending
+}
+
+// Subscribes to a topic and streams the decoded events into the returned channel
+// until the returned stop function is called, which also closes the channel.
+func Stream[T any](conn *Connection, topic string, options *StreamOptions[T]) (<-chan T, func() error, error) {
+	// Merge the user options with the defaults
+	opts := StreamOptions[T]{Buffer: defaultStreamBuffer}
+	if options != nil {
+		if options.Buffer != 0 {
+			opts.Buffer = options.Buffer
+		}
+		opts.Drop, opts.Limits, opts.Decode = options.Drop, options.Limits, options.Decode
+	}
+	// Subscribe with a handler feeding the channel
+	handler := &streamHandler[T]{
+		conn:   conn,
+		topic:  topic,
+		sink:   make(chan T, opts.Buffer),
+		drop:   opts.Drop,
+		decode: defaultDecoder[T](conn),
+		done:   make(chan struct{}),
+	}
+	if opts.Decode != nil {
+		handler.decode = func(event []byte, meta *Metadata) (T, error) { return opts.Decode(event) }
+	}
+	if err := conn.Subscribe(topic, handler, opts.Limits); err != nil {
+		return nil, nil, err
+	}
+	var once sync.Once
+	stop := func() error {
+		var err error
+		once.Do(func() {
+			close(handler.done)
+			err = conn.Unsubscribe(topic)
+
+			handler.lock.Lock()
+			handler.stopped = true
+			close(handler.sink)
+			handler.lock.Unlock()
+		})
+		return err
+	}
+	return handler.sink, stop, nil
+}
+
+// Creates the default event decoder of a stream: raw payloads for byte slices
+// and strings, and the codec of the declared content-type for everything else.
+func defaultDecoder[T any](conn *Connection) func([]byte, *Metadata) (T, error) {
+	var zero T
+	switch any(zero).(type) {
+	case []byte:
+		return func(event []byte, meta *Metadata) (T, error) {
+			return any(event).(T), nil
+		}
+	case string:
+		return func(event []byte, meta *Metadata) (T, error) {
+			return any(string(event)).(T), nil
+		}
+	default:
+		return func(event []byte, meta *Metadata) (T, error) {
+			var value T
+			codec, err := conn.codec(meta.ContentType)
+			if err != nil {
+				return value, err
+			}
+			err = codec.Unmarshal(event, &value)
+			return value, err
+		}
+	}
+}
+
+// Implements TopicHandler.HandleEvent.
+func (s *streamHandler[T]) HandleEvent(event []byte) {
+	s.HandleEventWithMetadata(event, &Metadata{})
+}
+
+// Implements EventMetadataHandler.HandleEventWithMetadata, decoding the event
+// and delivering it according to the drop policy.
+func (s *streamHandler[T]) HandleEventWithMetadata(event []byte, meta *Metadata) {
+	value, err := s.decode(event, meta)
+	if err != nil {
+		s.conn.Log.Error("dropping undecodable stream event", "topic", s.topic, "reason", err)
+		s.conn.reportError("dropped undecodable stream event on %s: %v", s.topic, err)
+		return
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if s.stopped {
+		return
+	}
+	switch s.drop {
+	case DropNone:
+		select {
+		case s.sink <- value:
+		case <-s.done:
+		}
+	case DropOldest:
+		for {
+			select {
+			case s.sink <- value:
+				return
+			default:
+			}
+			select {
+			case <-s.sink:
+				s.conn.Log.Warn("stream full, dropping oldest event", "topic", s.topic)
+			default:
+			}
+		}
+	default:
+		select {
+		case s.sink <- value:
+		default:
+			s.conn.Log.Warn("stream full, dropping newest event", "topic", s.topic)
+		}
+	}
+}
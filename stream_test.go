@@ -0,0 +1,58 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"testing"
+	"time"
+)
+
+// Tests that topic events are decoded and delivered through typed channels.
+func TestStream(t *testing.T) {
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	type point struct {
+		X, Y int
+	}
+	points, stop, err := Stream[point](conn, config.topic, nil)
+	if err != nil {
+		t.Fatalf("failed to start stream: %v.", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// Publish a malformed and a valid event, and check that only the latter arrives
+	if err := conn.Publish(config.topic, []byte("garbage")); err != nil {
+		t.Fatalf("failed to publish malformed event: %v.", err)
+	}
+	if err := conn.Publish(config.topic, []byte(`{"X": 1, "Y": 2}`)); err != nil {
+		t.Fatalf("failed to publish valid event: %v.", err)
+	}
+	select {
+	case p := <-points:
+		if p != (point{1, 2}) {
+			t.Fatalf("streamed event mismatch: have %+v, want %+v.", p, point{1, 2})
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("streamed event timed out.")
+	}
+	// Stop the stream and verify the channel gets closed
+	if err := stop(); err != nil {
+		t.Fatalf("failed to stop stream: %v.", err)
+	}
+	select {
+	case _, ok := <-points:
+		if ok {
+			t.Fatalf("unexpected event after stop.")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("stream channel not closed.")
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the supervisor managing the lifecycle of multiple connections.
+
+package iris
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Settings of the supervised restarts. Any unset fields (i.e. value of zero)
+// will default to the preset ones.
+type RestartPolicy struct {
+	Backoff    time.Duration // Delay before the first restart, doubled after each failed one
+	MaxBackoff time.Duration // Upper limit of the delay between restarts
+}
+
+// Default settings of the supervised restarts.
+var defaultRestartPolicy = RestartPolicy{
+	Backoff:    100 * time.Millisecond,
+	MaxBackoff: 10 * time.Second,
+}
+
+// Capacity of the supervisor error channel, beyond which errors are dropped.
+var supervisorErrorBuffer = 64
+
+// Supervisor owning a set of service registrations and client connections. Any
+// of them that fails or gets dropped is restarted with exponential backoff, the
+// failures being reported through a single error channel.
+type Supervisor struct {
+	policy RestartPolicy // Settings of the restarts
+
+	errc chan error    // Aggregated failures of the supervised entities
+	quit chan struct{} // Channel to signal the supervisor termination
+	pend sync.WaitGroup
+
+	fails []error    // Failures of the shutdowns during Stop
+	lock  sync.Mutex // Mutex protecting the shutdown failures
+	stop  int32      // Whether the supervisor was already stopped
+}
+
+// Creates a new supervisor, restarting failed entities according to policy.
+func NewSupervisor(policy *RestartPolicy) *Supervisor {
+	s := &Supervisor{
+		policy: defaultRestartPolicy,
+		errc:   make(chan error, supervisorErrorBuffer),
+		quit:   make(chan struct{}),
+	}
+	if policy != nil {
+		if policy.Backoff != 0 {
+			s.policy.Backoff = policy.Backoff
+		}
+		if policy.MaxBackoff != 0 {
+			s.policy.MaxBackoff = policy.MaxBackoff
+		}
+	}
+	return s
+}
+
+// Registers a supervised service instance into cluster. The handler's Init is
+// invoked upon every (re-)registration.
+func (s *Supervisor) Register(port int, cluster string, handler interface{}, limits *ServiceLimits, options *Options) {
+	s.supervise("service "+cluster, func() (*Connection, func() error, error) {
+		serv, err := RegisterWithOptions(port, cluster, handler, limits, options)
+		if err != nil {
+			return nil, nil, err
+		}
+		return serv.conn, serv.Unregister, nil
+	})
+}
+
+// Connects a supervised client. The init callback (if any) is invoked upon every
+// (re-)connection, and is the place to set up the subscriptions and to swap in
+// the new connection in the application. An init failure counts as a failed
+// start.
+func (s *Supervisor) Connect(name string, port int, options *Options, init func(conn *Connection) error) {
+	s.supervise("client "+name, func() (*Connection, func() error, error) {
+		conn, err := ConnectWithOptions(port, options)
+		if err != nil {
+			return nil, nil, err
+		}
+		if init != nil {
+			if err := init(conn); err != nil {
+				conn.Close()
+				return nil, nil, err
+			}
+		}
+		return conn, conn.Close, nil
+	})
+}
+
+// Retrieves the channel through which the failures of the supervised entities
+// are reported, each wrapped into a SupervisorError. The channel is closed when
+// the supervisor is stopped.
+func (s *Supervisor) Errors() <-chan error {
+	return s.errc
+}
+
+// Shuts down all the supervised entities and waits for their termination.
+func (s *Supervisor) Stop() error {
+	if !atomic.CompareAndSwapInt32(&s.stop, 0, 1) {
+		return errors.New("supervisor already stopped")
+	}
+	close(s.quit)
+	s.pend.Wait()
+	close(s.errc)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(s.fails) > 0 {
+		return fmt.Errorf("failed to stop %d entities, first: %v", len(s.fails), s.fails[0])
+	}
+	return nil
+}
+
+// Starts a goroutine keeping an entity alive until the supervisor is stopped.
+func (s *Supervisor) supervise(name string, start func() (*Connection, func() error, error)) {
+	s.pend.Add(1)
+	go func() {
+		defer s.pend.Done()
+
+		backoff := s.policy.Backoff
+		for {
+			conn, shutdown, err := start()
+			if err == nil {
+				backoff = s.policy.Backoff
+
+				// Running, wait until it terminates or a stop is requested
+				select {
+				case <-s.quit:
+					if err := shutdown(); err != nil {
+						s.lock.Lock()
+						s.fails = append(s.fails, &SupervisorError{name, err})
+						s.lock.Unlock()
+					}
+					return
+				case <-conn.term:
+					if atomic.LoadInt32(&conn.closing) != 0 {
+						return // Closed by the application, don't restart
+					}
+					if err = conn.termErr; err == nil {
+						err = errors.New("relay closed the connection")
+					}
+					shutdown() // Release any local resources, the link is gone anyway
+				}
+			}
+			s.report(&SupervisorError{name, err})
+
+			// Wait a while before restarting
+			select {
+			case <-s.quit:
+				return
+			case <-time.After(backoff):
+			}
+			if backoff *= 2; backoff > s.policy.MaxBackoff {
+				backoff = s.policy.MaxBackoff
+			}
+		}
+	}()
+}
+
+// Reports a failure, dropping it if nobody consumes the errors.
+func (s *Supervisor) report(err error) {
+	select {
+	case s.errc <- err:
+	default:
+	}
+}
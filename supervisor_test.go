@@ -0,0 +1,107 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gopkg.in/project-iris/iris-go.v1/sim"
+)
+
+// Service handler for the supervisor tests, surviving relay drops.
+type supervisorTestHandler struct{}
+
+func (s *supervisorTestHandler) Init(conn *Connection) error              { return nil }
+func (s *supervisorTestHandler) HandleBroadcast(msg []byte)               { panic("not implemented") }
+func (s *supervisorTestHandler) HandleRequest(req []byte) ([]byte, error) { return req, nil }
+func (s *supervisorTestHandler) HandleTunnel(tun *Tunnel)                 { panic("not implemented") }
+func (s *supervisorTestHandler) HandleDrop(reason error)                  {}
+
+// Tests that supervised entities are restarted after a relay failure and that
+// the failures are reported.
+func TestSupervisor(t *testing.T) {
+	relay, err := sim.NewRelay(nil)
+	if err != nil {
+		t.Fatalf("failed to start relay: %v.", err)
+	}
+	port := relay.Port()
+
+	// Start a supervised service and client, counting the client starts
+	super := NewSupervisor(&RestartPolicy{Backoff: 10 * time.Millisecond})
+	super.Register(port, config.cluster, new(supervisorTestHandler), nil, nil)
+
+	conns := make(chan *Connection, 2)
+	super.Connect("test", port, nil, func(conn *Connection) error {
+		conns <- conn
+		return nil
+	})
+	if _, err := (<-conns).Request(config.cluster, []byte{0x00}, time.Second); err != nil {
+		t.Fatalf("request failed: %v.", err)
+	}
+	// Restart the relay and ensure both entities come back
+	relay.Close()
+	if relay, err = sim.NewRelay(&sim.Config{Port: port}); err != nil {
+		t.Fatalf("failed to restart relay: %v.", err)
+	}
+	defer relay.Close()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-super.Errors():
+			if _, ok := err.(*SupervisorError); !ok {
+				t.Fatalf("failure #%d: type mismatch: have %T, want *SupervisorError.", i, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("failure #%d: report timed out.", i)
+		}
+	}
+	var conn *Connection
+	select {
+	case conn = <-conns:
+	case <-time.After(time.Second):
+		t.Fatalf("client restart timed out.")
+	}
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		if _, err := conn.Request(config.cluster, []byte{0x00}, 100*time.Millisecond); err == nil {
+			break
+		} else if time.Since(start) > 2*time.Second {
+			t.Fatalf("request after restart failed: %v.", err)
+		}
+	}
+	if err := super.Stop(); err != nil {
+		t.Fatalf("failed to stop supervisor: %v.", err)
+	}
+}
+
+// Tests that running services unregister on cancellation and report drops.
+func TestServiceRun(t *testing.T) {
+	// Ensure cancellation unregisters the service
+	serv, err := Register(config.relay, config.cluster, new(supervisorTestHandler), nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if err := serv.Run(ctx); err != nil {
+		t.Fatalf("cancelled run failed: %v.", err)
+	}
+	// Ensure relay drops are reported
+	relay, err := sim.NewRelay(nil)
+	if err != nil {
+		t.Fatalf("failed to start relay: %v.", err)
+	}
+	if serv, err = Register(relay.Port(), config.cluster, new(supervisorTestHandler), nil); err != nil {
+		relay.Close()
+		t.Fatalf("registration failed: %v.", err)
+	}
+	time.AfterFunc(50*time.Millisecond, func() { relay.Close() })
+	if err := serv.Run(context.Background()); err == nil {
+		t.Fatalf("dropped run succeeded.")
+	}
+}
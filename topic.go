@@ -7,10 +7,11 @@
 package iris
 
 import (
+	"sync"
 	"sync/atomic"
 
-	"github.com/project-iris/iris/pool"
 	"gopkg.in/inconshreveable/log15.v2"
+	"gopkg.in/project-iris/iris-go.v1/internal/pool"
 )
 
 // Callback interface for processing events from a single subscribed topic.
@@ -24,6 +25,7 @@ type TopicHandler interface {
 type topic struct {
 	// Application layer fields
 	handler TopicHandler // Handler for topic events
+	active  bool         // Whether the current relay link knows of the subscription
 
 	// Quality of service fields
 	limits *TopicLimits // Limits on the inbound message processing
@@ -31,8 +33,14 @@ type topic struct {
 	eventIdx  uint64           // Index to assign to inbound events for logging purposes
 	eventPool *pool.ThreadPool // Queue and concurrency limiter for the event handlers
 	eventUsed int32            // Actual memory usage of the event queue
+	poison    *quarantine      // Poison message tracker (nil if quarantining is disabled)
+	slow      *slowDetector    // Congestion tracker of the event queue (nil if disabled)
+	tracer    *tracer          // Handler invocation sampler (nil if tracing is disabled)
+	limitLock sync.RWMutex     // Protects the limits and handler pool from runtime changes
+	serial    *serialQueue     // Per key queues of the ordered handlers
 
 	// Bookkeeping fields
+	name   string // Name of the subscribed topic
 	logger log15.Logger
 }
 
@@ -45,6 +53,7 @@ func newTopic(handler TopicHandler, limits *TopicLimits, logger log15.Logger) *t
 		// Quality of service
 		limits:    limits,
 		eventPool: pool.NewThreadPool(limits.EventThreads),
+		serial:    newSerialQueue(),
 
 		// Bookkeeping
 		logger: logger,
@@ -73,30 +82,73 @@ func finalizeTopicLimits(user *TopicLimits) *TopicLimits {
 	return limits
 }
 
-// Schedules a topic event for the subscription handler to process.
-func (t *topic) handlePublish(event []byte) {
+// Schedules a topic event for the subscription handler to process, returning
+// whether it was accepted into the queue.
+func (t *topic) handlePublish(event []byte, meta *Metadata) bool {
 	id := int(atomic.AddUint64(&t.eventIdx, 1))
 	t.logger.Debug("scheduling arrived event", "event", id, "data", logLazyBlob(event))
 
 	// Make sure there is enough memory for the event
+	t.limitLock.RLock()
+	defer t.limitLock.RUnlock()
+
 	used := int(atomic.LoadInt32(&t.eventUsed)) // Safe, since only 1 thread increments!
+	t.slow.observe(used+len(event), t.limits.EventMemory)
 	if used+len(event) <= t.limits.EventMemory {
 		// Increment the memory usage of the queue and schedule the event
 		atomic.AddInt32(&t.eventUsed, int32(len(event)))
-		t.eventPool.Schedule(func() {
+		span := t.tracer.start("event", t.name, len(event))
+		task := func() {
 			// Start the processing by decrementing the memory usage
 			atomic.AddInt32(&t.eventUsed, -int32(len(event)))
 			t.logger.Debug("handling scheduled event", "event", id)
-			t.handler.HandleEvent(event)
-		})
-		return
+			span.begin()
+			err := t.poison.guard(event, 0, func() {
+				if handler, ok := t.handler.(EventMetadataHandler); ok {
+					handler.HandleEventWithMetadata(event, meta)
+				} else {
+					t.handler.HandleEvent(event)
+				}
+			})
+			span.end(err)
+			if err != nil {
+				t.logger.Error("failed to handle event", "event", id, "reason", err)
+			}
+		}
+		if keyer, ok := t.handler.(KeyedEventHandler); ok {
+			t.serial.schedule(t.eventPool.Schedule, keyer.EventKey(event, meta), task)
+		} else if t.limits.Ordered {
+			t.serial.schedule(t.eventPool.Schedule, meta.origin(), task)
+		} else {
+			t.eventPool.Schedule(task)
+		}
+		return true
 	}
 	// Not enough memory in the event queue
 	t.logger.Error("event exceeded memory allowance", "event", id, "limit", t.limits.EventMemory, "used", used, "size", len(event))
+	return false
+}
+
+// Adjusts the limits of the subscription, swapping in a new handler pool and
+// waiting for the events queued in the old one to finish running.
+func (t *topic) setLimits(limits *TopicLimits) {
+	t.limitLock.Lock()
+	old := t.eventPool
+
+	t.limits = limits
+	t.eventPool = pool.NewThreadPool(limits.EventThreads)
+	t.eventPool.Start()
+	t.limitLock.Unlock()
+
+	old.Terminate(false)
 }
 
 // Terminates a topic subscription's internal processing pool.
 func (t *topic) terminate() {
+	t.limitLock.RLock()
+	eventPool := t.eventPool
+	t.limitLock.RUnlock()
+
 	// Wait for queued events to finish running
-	t.eventPool.Terminate(false)
+	eventPool.Terminate(false)
 }
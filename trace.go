@@ -0,0 +1,109 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the sampled tracing of the handler invocations. A sampled inbound
+// broadcast, request or topic event is timed from its arrival through its queue
+// wait and execution, and logged upon completion together with its outcome, so
+// slow or failing handlers can be pinpointed in production.
+
+package iris
+
+import (
+	"math/rand"
+	"time"
+
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// Settings of the handler invocation tracing. Any unset fields (i.e. value of
+// zero) will default to the preset ones.
+type TracePolicy struct {
+	Rate   float64      // Fraction of the handler invocations to trace, between 0 and 1
+	Logger log15.Logger // Destination of the trace records (defaults to the connection logger)
+}
+
+// Default settings of the handler invocation tracing.
+var defaultTracePolicy = TracePolicy{
+	Rate: 0.01,
+}
+
+// Sampler and recorder of the handler invocation traces.
+type tracer struct {
+	rate   float64      // Fraction of the handler invocations to trace
+	logger log15.Logger // Destination of the trace records
+	clock  Clock        // Time source of the invocation timings
+}
+
+// Trace of a single sampled handler invocation.
+type traceSpan struct {
+	tracer  *tracer   // Tracer to record the span through
+	op      string    // Kind of the traced message
+	target  string    // Cluster or topic the message arrived on
+	size    int       // Payload size of the message
+	arrived time.Time // Time the message was queued
+	started time.Time // Time the handler was invoked (zero if never)
+}
+
+// Creates a handler invocation tracer, or nil if tracing is disabled.
+func newTracer(user *TracePolicy, clock Clock, logger log15.Logger) *tracer {
+	if user == nil {
+		return nil
+	}
+	policy := *user
+	if policy.Rate == 0 {
+		policy.Rate = defaultTracePolicy.Rate
+	}
+	if policy.Logger == nil {
+		policy.Logger = logger
+	}
+	return &tracer{
+		rate:   policy.Rate,
+		logger: policy.Logger,
+		clock:  clock,
+	}
+}
+
+// Samples an arrived message for tracing, returning its span if selected or nil
+// otherwise. A nil tracer never samples.
+func (t *tracer) start(op string, target string, size int) *traceSpan {
+	if t == nil || rand.Float64() >= t.rate {
+		return nil
+	}
+	return &traceSpan{
+		tracer:  t,
+		op:      op,
+		target:  target,
+		size:    size,
+		arrived: t.clock.Now(),
+	}
+}
+
+// Marks the invocation of the handler, ending the queue wait. A nil span is a
+// no-op.
+func (s *traceSpan) begin() {
+	if s == nil {
+		return
+	}
+	s.started = s.tracer.clock.Now()
+}
+
+// Records the outcome of the traced message. A nil span is a no-op.
+func (s *traceSpan) end(err error) {
+	if s == nil {
+		return
+	}
+	now := s.tracer.clock.Now()
+
+	wait, exec := now.Sub(s.arrived), time.Duration(0)
+	if !s.started.IsZero() {
+		wait, exec = s.started.Sub(s.arrived), now.Sub(s.started)
+	}
+	outcome := "ok"
+	if err != nil {
+		outcome = "failed"
+	}
+	s.tracer.logger.Info("handler invocation traced", "op", s.op, "target", s.target, "size", s.size, "wait", wait, "exec", exec, "outcome", outcome, "error", err)
+}
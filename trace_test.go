@@ -0,0 +1,93 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// Logger capturing the context of the info level records.
+type traceTestLogger struct {
+	log15.Logger
+	records chan map[string]interface{}
+}
+
+func (l *traceTestLogger) Info(msg string, ctx ...interface{}) {
+	record := make(map[string]interface{})
+	for i := 0; i+1 < len(ctx); i += 2 {
+		record[ctx[i].(string)] = ctx[i+1]
+	}
+	l.records <- record
+}
+
+// Tests that sampled handler invocations are traced with their details.
+func TestTracing(t *testing.T) {
+	logger := &traceTestLogger{log15.New(), make(chan map[string]interface{}, 16)}
+	options := &Options{Tracing: &TracePolicy{Rate: 1, Logger: logger}}
+
+	serv, err := RegisterWithOptions(config.relay, config.cluster, new(requestTestHandler), nil, options)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Request(config.cluster, []byte("traced request"), time.Second); err != nil {
+		t.Fatalf("request failed: %v.", err)
+	}
+	select {
+	case record := <-logger.records:
+		if record["op"] != "request" || record["target"] != config.cluster || record["size"] != len("traced request") {
+			t.Fatalf("trace mismatch: have %v.", record)
+		}
+		if record["outcome"] != "ok" {
+			t.Fatalf("outcome mismatch: have %v, want %v.", record["outcome"], "ok")
+		}
+		if wait, ok := record["wait"].(time.Duration); !ok || wait < 0 {
+			t.Fatalf("invalid queue wait: %v.", record["wait"])
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("request not traced.")
+	}
+}
+
+// Tests that handler invocations not sampled are not traced.
+func TestTracingSampling(t *testing.T) {
+	logger := &traceTestLogger{log15.New(), make(chan map[string]interface{}, 16)}
+	options := &Options{Tracing: &TracePolicy{Rate: 1e-9, Logger: logger}}
+
+	serv, err := RegisterWithOptions(config.relay, config.cluster, new(requestTestHandler), nil, options)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	for i := 0; i < 100; i++ {
+		if _, err := conn.Request(config.cluster, []byte{byte(i)}, time.Second); err != nil {
+			t.Fatalf("request %d failed: %v.", i, err)
+		}
+	}
+	select {
+	case record := <-logger.records:
+		t.Fatalf("unsampled invocation traced: %v.", record)
+	default:
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the chunking and flow control settings of the tunnels.
+//
+// The window of a tunnel is the number of inbound bytes the remote side may have
+// in flight before being throttled. Unless configured explicitly, it is sized to
+// the bandwidth-delay product of the measured round trip, never going below the
+// preset buffer, so that high latency links can still be kept saturated.
+
+package iris
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Settings of a tunnel's chunking and flow control. Any unset fields (i.e. value
+// of zero) will default to the adaptive ones.
+type TunnelOptions struct {
+	ChunkSize int   // Maximum length of the chunks sent (capped by the relay's limit)
+	Window    int   // Inbound bytes the remote side may have in flight (0 = adaptive)
+	Bandwidth int64 // Throughput in bytes/sec to size the adaptive window for
+}
+
+// Throughput the adaptive windows are sized for if none was configured.
+var defaultTunnelBandwidth int64 = 1024 * 1024 * 1024
+
+// Upper bound of the adaptive tunnel windows.
+var maxTunnelWindow = 1024 * 1024 * 1024
+
+// Retrieves the maximum chunk length to send, or zero to use the relay's limit.
+func (o *TunnelOptions) chunkSize() int {
+	if o == nil {
+		return 0
+	}
+	return o.ChunkSize
+}
+
+// Retrieves the inbound window of a tunnel, sizing it to the bandwidth-delay
+// product of the round trip if not configured explicitly.
+func (o *TunnelOptions) window(rtt time.Duration) int {
+	bandwidth := defaultTunnelBandwidth
+	if o != nil {
+		if o.Window > 0 {
+			return o.Window
+		}
+		if o.Bandwidth > 0 {
+			bandwidth = o.Bandwidth
+		}
+	}
+	window := int(float64(bandwidth) * rtt.Seconds())
+	if window < defaultTunnelBuffer {
+		window = defaultTunnelBuffer
+	}
+	if window > maxTunnelWindow {
+		window = maxTunnelWindow
+	}
+	return window
+}
+
+// Folds a measured tunnel construction round trip into the connection's smoothed
+// estimate, used to size the windows of the inbound tunnels.
+func (c *Connection) recordTunnelRTT(rtt time.Duration) {
+	for {
+		old := atomic.LoadInt64(&c.tunRTT)
+		smooth := int64(rtt)
+		if old != 0 {
+			smooth = (7*old + int64(rtt)) / 8
+		}
+		if atomic.CompareAndSwapInt64(&c.tunRTT, old, smooth) {
+			return
+		}
+	}
+}
+
+// Retrieves the smoothed tunnel round trip estimate, zero if none measured yet.
+func (c *Connection) tunnelRTT() time.Duration {
+	return time.Duration(atomic.LoadInt64(&c.tunRTT))
+}
+
+// Retrieves the maximum length of the chunks sent over the tunnel.
+func (t *Tunnel) chunkMax() int {
+	if t.chunkSize > 0 && t.chunkSize < t.chunkLimit {
+		return t.chunkSize
+	}
+	return t.chunkLimit
+}
+
+// Retrieves the number of inbound bytes the remote side of the tunnel may have
+// in flight before being throttled.
+func (t *Tunnel) Window() int {
+	return t.window
+}
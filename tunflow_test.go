@@ -0,0 +1,91 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// Tests that the tunnel windows are sized to the bandwidth-delay product within
+// bounds, unless configured explicitly.
+func TestTunnelWindowSizing(t *testing.T) {
+	tests := []struct {
+		options *TunnelOptions
+		rtt     time.Duration
+		window  int
+	}{
+		{nil, 0, defaultTunnelBuffer},
+		{nil, time.Millisecond, defaultTunnelBuffer},
+		{nil, 125 * time.Millisecond, 128 * 1024 * 1024},
+		{nil, time.Minute, maxTunnelWindow},
+		{&TunnelOptions{Bandwidth: 2 * 1024 * 1024 * 1024}, 125 * time.Millisecond, 256 * 1024 * 1024},
+		{&TunnelOptions{Window: 4096}, time.Minute, 4096},
+	}
+	for i, tt := range tests {
+		if window := tt.options.window(tt.rtt); window != tt.window {
+			t.Errorf("test %d: window mismatch: have %d, want %d.", i, window, tt.window)
+		}
+	}
+}
+
+// Tests that the configured chunk sizes and windows are obeyed by the tunnels.
+func TestTunnelOptions(t *testing.T) {
+	// Register a service granting tiny windows to its inbound tunnels
+	options := &Options{Tunnels: &TunnelOptions{Window: 16}}
+	serv, err := RegisterWithOptions(config.relay, config.cluster, new(tunnelTestHandler), &ServiceLimits{TunnelBacklog: 1}, options)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	tun, err := conn.TunnelWithOptions(config.cluster, &TunnelOptions{ChunkSize: 4, Window: 1024}, time.Second)
+	if err != nil {
+		t.Fatalf("tunnel construction failed: %v.", err)
+	}
+	defer tun.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	remote, err := serv.AcceptTunnel(ctx)
+	if err != nil {
+		t.Fatalf("tunnel acceptance failed: %v.", err)
+	}
+	if tun.Window() != 1024 || remote.Window() != 16 {
+		t.Fatalf("window mismatch: have %d/%d, want %d/%d.", tun.Window(), remote.Window(), 1024, 16)
+	}
+	// Fill the remote window with chunked messages and ensure sending blocks
+	if err := tun.Send([]byte("0123456789"), time.Second); err != nil {
+		t.Fatalf("tunnel send failed: %v.", err)
+	}
+	if err := tun.Send([]byte("abcdef"), time.Second); err != nil {
+		t.Fatalf("tunnel send failed: %v.", err)
+	}
+	if err := tun.Send([]byte("overflow"), 50*time.Millisecond); err != ErrTimeout {
+		t.Fatalf("overflowing send mismatch: have %v, want %v.", err, ErrTimeout)
+	}
+	// Drain the window and ensure the messages were reassembled intact
+	for _, want := range []string{"0123456789", "abcdef"} {
+		if msg, err := remote.Recv(time.Second); err != nil || string(msg) != want {
+			t.Fatalf("tunnel receive mismatch: have %q/%v, want %q/nil.", msg, err, want)
+		}
+	}
+	if err := tun.Send([]byte("overflow"), time.Second); err != nil {
+		t.Fatalf("tunnel send failed: %v.", err)
+	}
+	if msg, err := remote.Recv(time.Second); err != nil || string(msg) != "overflow" {
+		t.Fatalf("tunnel receive mismatch: have %q/%v, want %q/nil.", msg, err, "overflow")
+	}
+}
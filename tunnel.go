@@ -7,13 +7,16 @@
 package iris
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/project-iris/iris/container/queue"
 	"gopkg.in/inconshreveable/log15.v2"
+	"gopkg.in/project-iris/iris-go.v1/relaywire"
 )
 
 // Communication stream between the local application and a remote endpoint. The
@@ -24,8 +27,16 @@ type Tunnel struct {
 	conn *Connection // Connection to the local relay
 
 	// Chunking fields
-	chunkLimit int    // Maximum length of a data payload
-	chunkBuf   []byte // Current message being assembled
+	chunkLimit int      // Maximum length of a data payload
+	chunkSize  int      // Maximum length of the chunks sent (0 = chunk limit)
+	chunkBuf   []byte   // Current message being assembled
+	chunkStage *Payload // Current message being assembled off-heap
+	chunkPos   int      // Length of the off-heap message assembled so far
+	chunkDrop  bool     // Whether the current message is discarded (oversized)
+
+	// Session fields
+	sess     *session  // Request issuing session, started on the first request
+	sessOnce sync.Once // Guards the session startup
 
 	// Quality of service fields
 	itoaBuf  *queue.Queue  // Iris to application message buffer
@@ -36,7 +47,16 @@ type Tunnel struct {
 	atoiSign  chan struct{} // Allowance grant signaler
 	atoiLock  sync.Mutex    // Protects the allowance and signaler
 
+	window int // Inbound bytes the remote side may have in flight
+
 	// Bookkeeping fields
+	cluster  string                  // Cluster the tunnel was pinned to (outbound only)
+	instance string                  // Service instance the tunnel was pinned to (empty if not pinned)
+	options  *TunnelOptions          // Chunking and flow control settings the tunnel was opened with
+	header   map[string]string       // Application header attached by the initiator (inbound only)
+	ctx      context.Context         // Context cancelled upon the tunnel's termination
+	cancel   context.CancelCauseFunc // Cancels the tunnel's context with the termination reason
+
 	init chan bool     // Initialization channel for outbound tunnels
 	term chan struct{} // Channel to signal termination to blocked go-routines
 	stat error         // Failure reason, if any received
@@ -65,22 +85,26 @@ func (c *Connection) newTunnel() (*Tunnel, error) {
 		itoaSign: make(chan struct{}, 1),
 		atoiSign: make(chan struct{}, 1),
 
-		init: make(chan bool),
+		init: make(chan bool, 1),
 		term: make(chan struct{}),
 
 		Log: c.Log.New("tunnel", tunId),
 	}
+	tun.ctx, tun.cancel = context.WithCancelCause(c.ctx)
 	c.tunLive[tunId] = tun
 
 	return tun, nil
 }
 
 // Initiates a new tunnel to a remote cluster.
-func (c *Connection) initTunnel(cluster string, timeout time.Duration) (*Tunnel, error) {
+func (c *Connection) initTunnel(ctx context.Context, cluster string, header map[string]string, options *TunnelOptions, timeout time.Duration) (*Tunnel, error) {
 	// Sanity check on the arguments
 	if len(cluster) == 0 {
 		return nil, errors.New("empty cluster identifier")
 	}
+	if timeout == 0 {
+		timeout = c.options.Defaults.tunnel()
+	}
 	timeoutms := int(timeout.Nanoseconds() / 1000000)
 	if timeoutms < 1 {
 		return nil, fmt.Errorf("invalid timeout %v < 1ms", timeout)
@@ -90,18 +114,30 @@ func (c *Connection) initTunnel(cluster string, timeout time.Duration) (*Tunnel,
 	if err != nil {
 		return nil, err
 	}
+	tun.chunkSize = options.chunkSize()
+	tun.options = options
 	tun.Log.Info("constructing outbound tunnel", "cluster", cluster, "timeout", timeout)
 
 	// Try and construct the tunnel
-	err = c.sendTunnelInit(tun.id, cluster, timeoutms)
+	start := c.clock.Now()
+	if len(header) > 0 {
+		err = c.sendTunnelInitHeader(tun.id, cluster, timeoutms, wrapEnvelope(header, nil))
+	} else {
+		err = c.sendTunnelInit(tun.id, cluster, timeoutms)
+	}
 	if err == nil {
 		// Wait for tunneling completion or a timeout
 		select {
 		case init := <-tun.init:
 			if init {
-				// Send the data allowance
-				if err = c.sendTunnelAllowance(tun.id, defaultTunnelBuffer); err == nil {
-					tun.Log.Info("tunnel construction completed", "chunk_limit", tun.chunkLimit)
+				// Size the window to the construction round trip and send the data allowance
+				rtt := since(c.clock, start)
+				c.recordTunnelRTT(rtt)
+
+				tun.window = options.window(rtt)
+				if err = c.sendTunnelAllowance(tun.id, tun.window); err == nil {
+					tun.Log.Info("tunnel construction completed", "chunk_limit", tun.chunkLimit, "window", tun.window, "rtt", rtt)
+					atomic.AddUint64(&c.stats.tunOpened, 1)
 					return tun, nil
 				}
 			} else {
@@ -109,6 +145,8 @@ func (c *Connection) initTunnel(cluster string, timeout time.Duration) (*Tunnel,
 			}
 		case <-c.term:
 			err = ErrClosed
+		case <-ctx.Done():
+			err = ctx.Err()
 		}
 	}
 	// Clean up and return the failure
@@ -116,27 +154,39 @@ func (c *Connection) initTunnel(cluster string, timeout time.Duration) (*Tunnel,
 	delete(c.tunLive, tun.id)
 	c.tunLock.Unlock()
 
+	// Tear down the tunnel if it was completed in the mean time anyway
+	select {
+	case init := <-tun.init:
+		if init {
+			go c.sendTunnelClose(tun.id)
+		}
+	default:
+	}
+	tun.cancel(err)
 	tun.Log.Warn("tunnel construction failed", "reason", err)
 	return nil, err
 }
 
 // Accepts an incoming tunneling request and confirms its local id.
-func (c *Connection) acceptTunnel(initId uint64, chunkLimit int) (*Tunnel, error) {
+func (c *Connection) acceptTunnel(initId uint64, chunkLimit int, header map[string]string) (*Tunnel, error) {
 	// Create the local tunnel endpoint
 	tun, err := c.newTunnel()
 	if err != nil {
 		return nil, err
 	}
-	tun.chunkLimit = chunkLimit
-	tun.Log.Info("accepting inbound tunnel", "chunk_limit", chunkLimit)
+	tun.chunkLimit, tun.header = chunkLimit, header
+	tun.chunkSize = c.options.Tunnels.chunkSize()
+	tun.window = c.options.Tunnels.window(c.tunnelRTT())
+	tun.Log.Info("accepting inbound tunnel", "chunk_limit", chunkLimit, "window", tun.window)
 
 	// Confirm the tunnel creation to the relay node
 	err = c.sendTunnelConfirm(initId, tun.id)
 	if err == nil {
 		// Send the data allowance
-		err = c.sendTunnelAllowance(tun.id, defaultTunnelBuffer)
+		err = c.sendTunnelAllowance(tun.id, tun.window)
 		if err == nil {
 			tun.Log.Info("tunnel acceptance completed")
+			atomic.AddUint64(&c.stats.tunOpened, 1)
 			return tun, nil
 		}
 	}
@@ -144,29 +194,70 @@ func (c *Connection) acceptTunnel(initId uint64, chunkLimit int) (*Tunnel, error
 	delete(c.tunLive, tun.id)
 	c.tunLock.Unlock()
 
+	tun.cancel(err)
 	tun.Log.Warn("tunnel acceptance failed", "reason", err)
 	return nil, err
 }
 
+// Retrieves a context cancelled when the tunnel terminates, be it closed by
+// either end or dropped along with the connection. The cause of the context is
+// the remote failure if the tunnel was aborted or dropped, ErrClosed otherwise.
+func (t *Tunnel) Context() context.Context {
+	return t.ctx
+}
+
+// Retrieves the application header attached by the initiator of an inbound
+// tunnel, or nil if none was attached. The header must not be modified.
+func (t *Tunnel) Header() map[string]string {
+	return t.header
+}
+
 // Sends a message over the tunnel to the remote pair, blocking until the local
 // Iris node receives the message or the operation times out.
 //
-// Infinite blocking is supported with by setting the timeout to zero (0).
+// Infinite blocking is supported with by setting the timeout to zero (0), unless
+// a default send timeout is configured, in which case that is used instead.
 func (t *Tunnel) Send(message []byte, timeout time.Duration) error {
+	if timeout == 0 {
+		timeout = t.conn.options.Defaults.send()
+	}
 	t.Log.Debug("sending message", "data", logLazyBlob(message), "timeout", logLazyTimeout(timeout))
 
+	// Create timeout signaler
+	var deadline <-chan time.Time
+	if timeout != 0 {
+		deadline = t.conn.clock.After(timeout)
+	}
+	return t.send(context.Background(), message, deadline)
+}
+
+// Sends a message over the tunnel similarly to Send, but blocking until the
+// context is cancelled instead of a timeout expires.
+func (t *Tunnel) SendContext(ctx context.Context, message []byte) error {
+	t.Log.Debug("sending message", "data", logLazyBlob(message))
+	return t.send(ctx, message, nil)
+}
+
+// Splits a message into chunks and sends them over the tunnel, waiting for the
+// data allowance until the deadline expires or the context is cancelled.
+func (t *Tunnel) send(ctx context.Context, message []byte, deadline <-chan time.Time) error {
 	// Sanity check on the arguments
 	if message == nil || len(message) == 0 {
 		return errors.New("nil or empty message")
 	}
-	// Create timeout signaler
-	var deadline <-chan time.Time
-	if timeout != 0 {
-		deadline = time.After(timeout)
+	if err := t.conn.checkSendSize(len(message)); err != nil {
+		return err
+	}
+	// Kill the tunnel if a fault was requested
+	if t.conn.faults.killTunnel() {
+		t.Log.Debug("fault injected: tunnel killed")
+		t.Close()
+		return ErrClosed
 	}
 	// Split the original message into bounded chunks
-	for pos := 0; pos < len(message); pos += t.chunkLimit {
-		end := pos + t.chunkLimit
+	limit := t.chunkMax()
+	for pos := 0; pos < len(message); pos += limit {
+		end := pos + limit
 		if end > len(message) {
 			end = len(message)
 		}
@@ -174,7 +265,7 @@ func (t *Tunnel) Send(message []byte, timeout time.Duration) error {
 		if pos != 0 {
 			sizeOrCont = 0
 		}
-		if err := t.sendChunk(message[pos:end], sizeOrCont, deadline); err != nil {
+		if err := t.sendChunk(ctx, message[pos:end], sizeOrCont, deadline); err != nil {
 			return err
 		}
 	}
@@ -182,7 +273,7 @@ func (t *Tunnel) Send(message []byte, timeout time.Duration) error {
 }
 
 // Sends a single message chunk to the remote endpoint.
-func (t *Tunnel) sendChunk(chunk []byte, sizeOrCont int, deadline <-chan time.Time) error {
+func (t *Tunnel) sendChunk(ctx context.Context, chunk []byte, sizeOrCont int, deadline <-chan time.Time) error {
 	for {
 		// Short circuit if there's enough space allowance already
 		if t.drainAllowance(len(chunk)) {
@@ -194,6 +285,8 @@ func (t *Tunnel) sendChunk(chunk []byte, sizeOrCont int, deadline <-chan time.Ti
 			return ErrClosed
 		case <-deadline:
 			return ErrTimeout
+		case <-ctx.Done():
+			return ctx.Err()
 		case <-t.atoiSign:
 			// Potentially enough space allowance, retry
 			continue
@@ -222,16 +315,50 @@ func (t *Tunnel) drainAllowance(need int) bool {
 // Retrieves a message from the tunnel, blocking until one is available or the
 // operation times out.
 //
-// Infinite blocking is supported with by setting the timeout to zero (0).
+// Infinite blocking is supported with by setting the timeout to zero (0), unless
+// a default receive timeout is configured, in which case that is used instead.
 func (t *Tunnel) Recv(timeout time.Duration) ([]byte, error) {
+	payload, err := t.RecvPayload(timeout)
+	if err != nil {
+		return nil, err
+	}
+	return payload.bytes()
+}
+
+// Retrieves a message from the tunnel similarly to Recv, but returns it as a
+// payload readable in place. Messages staged off-heap are not copied onto the
+// heap this way, but the payload must be closed after use.
+func (t *Tunnel) RecvPayload(timeout time.Duration) (*Payload, error) {
 	// Short circuit if there's a message already buffered
 	if msg := t.fetchMessage(); msg != nil {
 		return msg, nil
 	}
 	// Create the timeout signaler
+	if timeout == 0 {
+		timeout = t.conn.options.Defaults.recv()
+	}
 	var after <-chan time.Time
 	if timeout != 0 {
-		after = time.After(timeout)
+		after = t.conn.clock.After(timeout)
+	}
+	return t.recvPayload(after)
+}
+
+// Retrieves a message from the tunnel onto the heap, waiting for one until the
+// deadline expires (or indefinitely if nil).
+func (t *Tunnel) recv(deadline <-chan time.Time) ([]byte, error) {
+	payload, err := t.recvPayload(deadline)
+	if err != nil {
+		return nil, err
+	}
+	return payload.bytes()
+}
+
+// Retrieves a message from the tunnel, waiting for one until the deadline
+// expires (or indefinitely if nil).
+func (t *Tunnel) recvPayload(after <-chan time.Time) (*Payload, error) {
+	if msg := t.fetchMessage(); msg != nil {
+		return msg, nil
 	}
 	// Wait for a message to arrive
 	select {
@@ -249,15 +376,19 @@ func (t *Tunnel) Recv(timeout time.Duration) ([]byte, error) {
 
 // Fetches the next buffered message, or nil if none is available. If a message
 // was available, grants the remote side the space allowance just consumed.
-func (t *Tunnel) fetchMessage() []byte {
+func (t *Tunnel) fetchMessage() *Payload {
 	t.itoaLock.Lock()
 	defer t.itoaLock.Unlock()
 
 	if !t.itoaBuf.Empty() {
-		message := t.itoaBuf.Pop().([]byte)
-		go t.conn.sendTunnelAllowance(t.id, len(message))
+		message := t.itoaBuf.Pop().(*Payload)
+		go t.conn.sendTunnelAllowance(t.id, int(message.size))
 
-		t.Log.Debug("fetching queued message", "data", logLazyBlob(message))
+		if message.release == nil {
+			t.Log.Debug("fetching queued message", "data", logLazyBlob(message.data))
+		} else {
+			t.Log.Debug("fetching staged message", "size", message.size)
+		}
 		return message
 	}
 	// No message, reset arrival flag
@@ -288,6 +419,31 @@ func (t *Tunnel) Close() error {
 	return t.stat
 }
 
+// Closes the tunnel similarly to Close, but notifies the remote end of the given
+// failure reason, surfacing as the error of its Close and the cause of its
+// context. Relays not supporting aborts close the tunnel without the reason.
+func (t *Tunnel) Abort(reason string) error {
+	// Short circuit if remote end already closed
+	select {
+	case <-t.term:
+		return t.stat
+	default:
+	}
+	// Signal the relay and wait for closure
+	t.Log.Info("aborting tunnel", "reason", reason)
+	var err error
+	if t.conn.supports(relaywire.FeatureAbort) {
+		err = t.conn.sendTunnelAbort(t.id, reason)
+	} else {
+		err = t.conn.sendTunnelClose(t.id)
+	}
+	if err != nil {
+		return err
+	}
+	<-t.term
+	return t.stat
+}
+
 // Finalizes the tunnel construction.
 func (t *Tunnel) handleInitResult(chunkLimit int) {
 	if chunkLimit > 0 {
@@ -318,18 +474,64 @@ func (t *Tunnel) handleTransfer(size int, chunk []byte) {
 
 			// A large transfer timed out, new started, grant the partials allowance
 			go t.conn.sendTunnelAllowance(t.id, len(t.chunkBuf))
+			t.chunkBuf = nil
+		}
+		if t.chunkStage != nil {
+			t.Log.Warn("incomplete staged message discarded", "size", t.chunkStage.size, "arrived", t.chunkPos)
+
+			go t.conn.sendTunnelAllowance(t.id, t.chunkPos)
+			t.chunkStage.Close()
+			t.chunkStage = nil
+		}
+		// Tear down the tunnel if the message exceeds the receive cap
+		if err := t.conn.checkRecvSize(size); err != nil {
+			t.Log.Error("closing tunnel on oversized message", "reason", err)
+			t.conn.reportError("closed tunnel on oversized message: %v", err)
+			t.chunkDrop = true
+			go t.Close()
+			return
+		}
+		t.chunkDrop = false
+
+		// Stage large messages off-heap if requested, falling back to the heap
+		if threshold := t.conn.options.StageThreshold; threshold > 0 && size >= threshold {
+			stage, err := newStagedPayload(t.conn.options.StageDir, size)
+			if err == nil {
+				t.chunkStage, t.chunkPos = stage, 0
+			} else {
+				t.Log.Error("failed to stage large message", "size", size, "reason", err)
+				t.conn.reportError("failed to stage large tunnel message: %v", err)
+			}
+		}
+		if t.chunkStage == nil {
+			t.chunkBuf = make([]byte, 0, size)
 		}
-		t.chunkBuf = make([]byte, 0, size)
 	}
 	// Append the new chunk and check completion
-	t.chunkBuf = append(t.chunkBuf, chunk...)
-	if len(t.chunkBuf) == cap(t.chunkBuf) {
+	if t.chunkDrop {
+		return
+	}
+	var message *Payload
+	if t.chunkStage != nil {
+		if err := t.chunkStage.writeAt(chunk, t.chunkPos); err != nil {
+			t.Log.Error("failed to stage message chunk", "reason", err)
+			t.conn.reportError("failed to stage tunnel message chunk: %v", err)
+		}
+		if t.chunkPos += len(chunk); int64(t.chunkPos) == t.chunkStage.size {
+			message, t.chunkStage = t.chunkStage, nil
+		}
+	} else {
+		t.chunkBuf = append(t.chunkBuf, chunk...)
+		if len(t.chunkBuf) == cap(t.chunkBuf) {
+			message, t.chunkBuf = newHeapPayload(t.chunkBuf), nil
+		}
+	}
+	if message != nil {
 		t.itoaLock.Lock()
 		defer t.itoaLock.Unlock()
 
-		t.Log.Debug("queuing arrived message", "data", logLazyBlob(t.chunkBuf))
-		t.itoaBuf.Push(t.chunkBuf)
-		t.chunkBuf = nil
+		t.Log.Debug("queuing arrived message", "size", message.size)
+		t.itoaBuf.Push(message)
 
 		select {
 		case t.itoaSign <- struct{}{}:
@@ -343,8 +545,14 @@ func (t *Tunnel) handleClose(reason string) {
 	if reason != "" {
 		t.Log.Warn("tunnel dropped", "reason", reason)
 		t.stat = fmt.Errorf("remote error: %s", reason)
+		t.cancel(t.stat)
 	} else {
 		t.Log.Info("tunnel closed gracefully")
+		t.cancel(ErrClosed)
+	}
+	if t.chunkStage != nil {
+		t.chunkStage.Close()
+		t.chunkStage = nil
 	}
 	close(t.term)
 }
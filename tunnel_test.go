@@ -8,7 +8,9 @@ package iris
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -327,3 +329,330 @@ func BenchmarkTunnelThroughput(b *testing.B) {
 	// Stop the timer (don't measure deferred cleanup)
 	b.StopTimer()
 }
+
+// Tests that large tunnel messages are staged off-heap and readable in place.
+func TestTunnelStaging(t *testing.T) {
+	serv, err := Register(config.relay, config.cluster, new(tunnelTestHandler), nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := ConnectWithOptions(config.relay, &Options{StageThreshold: 1024})
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	tun, err := conn.Tunnel(config.cluster, time.Second)
+	if err != nil {
+		t.Fatalf("tunnel construction failed: %v.", err)
+	}
+	defer tun.Close()
+
+	for _, size := range []int{16, 256 * 1024} {
+		message := bytes.Repeat([]byte{byte(size)}, size)
+		if err := tun.Send(message, time.Second); err != nil {
+			t.Fatalf("size %d: failed to send message: %v.", size, err)
+		}
+		payload, err := tun.RecvPayload(time.Second)
+		if err != nil {
+			t.Fatalf("size %d: failed to receive message: %v.", size, err)
+		}
+		if staged := payload.release != nil; staged != (size >= 1024) {
+			t.Errorf("size %d: staging mismatch: have %v, want %v.", size, staged, size >= 1024)
+		}
+		if payload.Size() != int64(size) {
+			t.Fatalf("size %d: payload size mismatch: have %d.", size, payload.Size())
+		}
+		buf := make([]byte, size)
+		if _, err := payload.ReadAt(buf, 0); err != nil {
+			t.Fatalf("size %d: failed to read payload: %v.", size, err)
+		}
+		if !bytes.Equal(buf, message) {
+			t.Fatalf("size %d: payload content mismatch.", size)
+		}
+		if err := payload.Close(); err != nil {
+			t.Fatalf("size %d: failed to release payload: %v.", size, err)
+		}
+	}
+}
+
+// Tests that tunnel construction and sends can be cancelled via contexts.
+func TestTunnelContext(t *testing.T) {
+	serv, err := Register(config.relay, config.cluster, new(tunnelTestHandler), nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Verify that a cancelled context aborts the construction
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := conn.TunnelContext(cancelled, config.cluster, time.Second); err != context.Canceled {
+		t.Fatalf("cancelled construction error mismatch: have %v, want %v.", err, context.Canceled)
+	}
+	// Verify that a live context permits exchanges and a cancelled one aborts them
+	tun, err := conn.TunnelContext(context.Background(), config.cluster, time.Second)
+	if err != nil {
+		t.Fatalf("tunnel construction failed: %v.", err)
+	}
+	defer tun.Close()
+
+	if err := tun.SendContext(context.Background(), []byte("ping")); err != nil {
+		t.Fatalf("failed to send message: %v.", err)
+	}
+	if msg, err := tun.Recv(time.Second); err != nil || string(msg) != "ping" {
+		t.Fatalf("echo mismatch: have %q/%v, want %q.", msg, err, "ping")
+	}
+	// Wait for the echo's allowance grant to arrive before exhausting the space
+	time.Sleep(100 * time.Millisecond)
+
+	tun.atoiLock.Lock()
+	tun.atoiSpace = 0
+	tun.atoiLock.Unlock()
+
+	timeout, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := tun.SendContext(timeout, []byte("ping")); err != context.DeadlineExceeded {
+		t.Fatalf("throttled send error mismatch: have %v, want %v.", err, context.DeadlineExceeded)
+	}
+	// Verify that cancelled broadcasts and publishes are not sent
+	if err := conn.BroadcastContext(cancelled, config.cluster, []byte("x")); err != context.Canceled {
+		t.Fatalf("cancelled broadcast error mismatch: have %v, want %v.", err, context.Canceled)
+	}
+	if err := conn.PublishContext(cancelled, config.topic, []byte("x")); err != context.Canceled {
+		t.Fatalf("cancelled publish error mismatch: have %v, want %v.", err, context.Canceled)
+	}
+}
+
+// Tests that the connection wide default timeouts apply to zero timeout calls.
+func TestTunnelDefaultTimeouts(t *testing.T) {
+	defaults := &DefaultTimeouts{
+		Request: 100 * time.Millisecond,
+		Tunnel:  100 * time.Millisecond,
+		Recv:    50 * time.Millisecond,
+	}
+	conn, err := ConnectWithOptions(config.relay, &Options{Defaults: defaults})
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Verify that construction and requests to a non existent server time out
+	if tun, err := conn.Tunnel(config.cluster, 0); err != ErrTimeout {
+		t.Fatalf("mismatching tunneling result: have %v/%v, want %v/%v.", tun, err, nil, ErrTimeout)
+	}
+	if rep, err := conn.Request(config.cluster, []byte{0x00}, 0); err != ErrTimeout {
+		t.Fatalf("mismatching request result: have %v/%v, want %v/%v.", rep, err, nil, ErrTimeout)
+	}
+	// Verify that receives on an idle tunnel time out
+	serv, err := Register(config.relay, config.cluster, new(tunnelTestHandler), nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	tun, err := conn.Tunnel(config.cluster, time.Second)
+	if err != nil {
+		t.Fatalf("tunnel construction failed: %v.", err)
+	}
+	defer tun.Close()
+
+	if msg, err := tun.Recv(0); err != ErrTimeout {
+		t.Fatalf("mismatching receive result: have %v/%v, want %v/%v.", msg, err, nil, ErrTimeout)
+	}
+}
+
+// Service handler reporting the headers of the inbound tunnels.
+type tunnelHeaderTestHandler struct {
+	headers chan map[string]string
+}
+
+func (t *tunnelHeaderTestHandler) Init(conn *Connection) error              { return nil }
+func (t *tunnelHeaderTestHandler) HandleBroadcast(msg []byte)               { panic("not implemented") }
+func (t *tunnelHeaderTestHandler) HandleRequest(req []byte) ([]byte, error) { panic("not implemented") }
+func (t *tunnelHeaderTestHandler) HandleDrop(reason error)                  { panic("not implemented") }
+
+func (t *tunnelHeaderTestHandler) HandleTunnel(tun *Tunnel) {
+	t.headers <- tun.Header()
+	tun.Close()
+}
+
+// Tests that the header attached by a tunnel initiator is delivered to the
+// remote handler before any data.
+func TestTunnelHeader(t *testing.T) {
+	handler := &tunnelHeaderTestHandler{headers: make(chan map[string]string, 2)}
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Open a tunnel with and one without a header, checking the deliveries
+	header := map[string]string{"purpose": "backup", "content-type": "application/json"}
+	tests := []map[string]string{header, nil}
+	for i, want := range tests {
+		tun, err := conn.TunnelWithHeader(config.cluster, want, time.Second)
+		if err != nil {
+			t.Fatalf("test %d: tunnel construction failed: %v.", i, err)
+		}
+		select {
+		case have := <-handler.headers:
+			if len(have) != len(want) {
+				t.Fatalf("test %d: header mismatch: have %v, want %v.", i, have, want)
+			}
+			for key, value := range want {
+				if have[key] != value {
+					t.Fatalf("test %d: header mismatch: have %v, want %v.", i, have, want)
+				}
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("test %d: tunnel not delivered.", i)
+		}
+		tun.Close()
+	}
+}
+
+// V2 service handler aborting or awaiting the termination of inbound tunnels.
+type tunnelAbortTestHandler struct {
+	causes chan error
+}
+
+func (t *tunnelAbortTestHandler) Init(ctx context.Context, conn *Connection) error { return nil }
+func (t *tunnelAbortTestHandler) HandleBroadcast(ctx context.Context, message []byte, meta *Metadata) {
+	panic("not implemented")
+}
+func (t *tunnelAbortTestHandler) HandleRequest(ctx context.Context, request []byte, meta *Metadata) ([]byte, error) {
+	panic("not implemented")
+}
+func (t *tunnelAbortTestHandler) HandleDrop(ctx context.Context, reason error) {}
+
+func (t *tunnelAbortTestHandler) HandleTunnel(ctx context.Context, tun *Tunnel, meta *Metadata) {
+	if meta.Headers["mode"] == "abort" {
+		tun.Abort("rejected by service")
+		return
+	}
+	<-ctx.Done()
+	t.causes <- context.Cause(ctx)
+}
+
+// Tests that tunnel contexts are cancelled on termination, and that aborts carry
+// their reason to the remote end.
+func TestTunnelAbort(t *testing.T) {
+	handler := &tunnelAbortTestHandler{causes: make(chan error, 1)}
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Abort a tunnel locally and check the remote context's cause
+	tun, err := conn.Tunnel(config.cluster, time.Second)
+	if err != nil {
+		t.Fatalf("tunnel construction failed: %v.", err)
+	}
+	if err := tun.Abort("client gave up"); err != nil {
+		t.Fatalf("local abort failed: %v.", err)
+	}
+	if err := context.Cause(tun.Context()); err != ErrClosed {
+		t.Fatalf("local cause mismatch: have %v, want %v.", err, ErrClosed)
+	}
+	select {
+	case cause := <-handler.causes:
+		if cause == nil || !strings.Contains(cause.Error(), "client gave up") {
+			t.Fatalf("remote cause mismatch: have %v, want client gave up.", cause)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("remote context not cancelled.")
+	}
+	// Have the service abort a tunnel and check the local failure
+	tun, err = conn.TunnelWithHeader(config.cluster, map[string]string{"mode": "abort"}, time.Second)
+	if err != nil {
+		t.Fatalf("tunnel construction failed: %v.", err)
+	}
+	if _, err := tun.Recv(time.Second); err != ErrClosed {
+		t.Fatalf("receive mismatch: have %v, want %v.", err, ErrClosed)
+	}
+	if err := tun.Close(); err == nil || !strings.Contains(err.Error(), "rejected by service") {
+		t.Fatalf("close failure mismatch: have %v, want rejected by service.", err)
+	}
+	if cause := context.Cause(tun.Context()); cause == nil || !strings.Contains(cause.Error(), "rejected by service") {
+		t.Fatalf("local cause mismatch: have %v, want rejected by service.", cause)
+	}
+}
+
+// Tests that tunnels can be queued for explicit acceptance, rejecting any that
+// arrive while the backlog is full.
+func TestTunnelAccept(t *testing.T) {
+	serv, err := Register(config.relay, config.cluster, new(tunnelTestHandler), &ServiceLimits{TunnelBacklog: 1})
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Fill up the backlog and ensure any excess tunnels get rejected
+	queued, err := conn.Tunnel(config.cluster, time.Second)
+	if err != nil {
+		t.Fatalf("tunnel construction failed: %v.", err)
+	}
+	defer queued.Close()
+
+	excess, err := conn.Tunnel(config.cluster, time.Second)
+	if err != nil {
+		t.Fatalf("tunnel construction failed: %v.", err)
+	}
+	if _, err := excess.Recv(time.Second); err != ErrClosed {
+		t.Fatalf("receive mismatch: have %v, want %v.", err, ErrClosed)
+	}
+	if err := excess.Close(); err == nil || !strings.Contains(err.Error(), "tunnel backlog full") {
+		t.Fatalf("close failure mismatch: have %v, want tunnel backlog full.", err)
+	}
+	// Accept the queued tunnel and ensure it's usable
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	tun, err := serv.AcceptTunnel(ctx)
+	if err != nil {
+		t.Fatalf("tunnel acceptance failed: %v.", err)
+	}
+	if err := queued.Send([]byte("ping"), time.Second); err != nil {
+		t.Fatalf("tunnel send failed: %v.", err)
+	}
+	if msg, err := tun.Recv(time.Second); err != nil || string(msg) != "ping" {
+		t.Fatalf("tunnel receive mismatch: have %q/%v, want %q/nil.", msg, err, "ping")
+	}
+	tun.Close()
+
+	// Ensure acceptance obeys the context
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if _, err := serv.AcceptTunnel(ctx); err != context.Canceled {
+		t.Fatalf("cancelled acceptance mismatch: have %v, want %v.", err, context.Canceled)
+	}
+}
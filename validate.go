@@ -0,0 +1,55 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the payload validation hooks, checking outbound messages before they
+// are sent and inbound ones before they reach the handlers (e.g. against a JSON
+// schema or protobuf descriptor).
+
+package iris
+
+// Callback validating the (plain) payload of a message sent to or arrived
+// through a cluster or topic. Returning an error rejects the message.
+type Validator func(target string, payload []byte) error
+
+// Directions of the validated messages.
+const (
+	ValidateSend    = "send"
+	ValidateReceive = "receive"
+)
+
+// Returned if a payload was rejected by a validator.
+type ValidationError struct {
+	Target    string // Cluster or topic the message was sent to or arrived through
+	Direction string // Direction of the message (ValidateSend or ValidateReceive)
+	Err       error  // Failure reported by the validator
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid " + e.Direction + " payload for " + e.Target + ": " + e.Err.Error()
+}
+
+// Retrieves the failure reported by the validator.
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// Validates a payload with the validator configured for a target, falling back
+// to the wildcard. Replies (empty target) are never validated.
+func (c *Connection) validate(target string, payload []byte, direction string) error {
+	if target == "" || len(c.options.Validators) == 0 {
+		return nil
+	}
+	validator, ok := c.options.Validators[target]
+	if !ok {
+		if validator = c.options.Validators[""]; validator == nil {
+			return nil
+		}
+	}
+	if err := validator(target, payload); err != nil {
+		return &ValidationError{Target: target, Direction: direction, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Validator accepting only well formed JSON payloads.
+func validateJSON(target string, payload []byte) error {
+	if !json.Valid(payload) {
+		return errors.New("malformed JSON")
+	}
+	return nil
+}
+
+// Tests that malformed payloads are rejected both on send and on receive.
+func TestValidation(t *testing.T) {
+	// Register a service validating its inbound requests
+	serv, err := RegisterWithOptions(config.relay, config.cluster, new(requestTestHandler), nil, &Options{
+		Validators: map[string]Validator{config.cluster: validateJSON},
+	})
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	// Connect a client validating its outbound publishes only
+	conn, err := ConnectWithOptions(config.relay, &Options{
+		Validators: map[string]Validator{config.topic: validateJSON},
+	})
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Verify that send side validation fails locally with a structured error
+	err = conn.Publish(config.topic, []byte("garbage"))
+	if verr, ok := err.(*ValidationError); !ok || verr.Target != config.topic || verr.Direction != ValidateSend {
+		t.Fatalf("publish validation error mismatch: have %v.", err)
+	}
+	if err := conn.Publish(config.topic, []byte(`{"valid": true}`)); err != nil {
+		t.Fatalf("valid publish failed: %v.", err)
+	}
+	// Verify that receive side validation rejects the request remotely
+	_, err = conn.Request(config.cluster, []byte("garbage"), time.Second)
+	if _, ok := err.(*RemoteError); !ok || !strings.Contains(err.Error(), "invalid receive payload") {
+		t.Fatalf("request validation error mismatch: have %v.", err)
+	}
+	if reply, err := conn.Request(config.cluster, []byte(`"valid"`), time.Second); err != nil || string(reply) != `"valid"` {
+		t.Fatalf("valid request failed: have %q/%v.", reply, err)
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+//go:build js && wasm
+// +build js,wasm
+
+// Contains the browser WebSocket transport, the only link available to the
+// binding when compiled to WebAssembly. It is selected with a ws://host/path or
+// wss://host/path endpoint and carries the relay protocol in binary messages,
+// so a WebSocket to TCP bridge needs to be deployed in front of the relay.
+
+package iris
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"syscall/js"
+	"time"
+)
+
+func init() {
+	RegisterTransport("ws", webSocketTransport("ws"))
+	RegisterTransport("wss", webSocketTransport("wss"))
+}
+
+// Address of a WebSocket endpoint.
+type webSocketAddr string
+
+func (a webSocketAddr) Network() string { return "websocket" }
+func (a webSocketAddr) String() string  { return string(a) }
+
+// Browser WebSocket, wrapped into a network connection.
+type webSocketConn struct {
+	sock  js.Value           // Browser WebSocket object
+	addr  webSocketAddr      // Endpoint the socket is connected to
+	funcs map[string]js.Func // Event callbacks to release on close
+
+	buf  []byte     // Data received but not yet read
+	err  error      // Failure to report after the buffer is drained
+	lock sync.Mutex // Mutex protecting the receive state
+	cond *sync.Cond // Signaller of arriving data or failures
+}
+
+// Creates a transport dialing WebSocket endpoints of the given scheme.
+func webSocketTransport(scheme string) Transport {
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		return dialWebSocket(ctx, scheme+"://"+addr)
+	}
+}
+
+// Opens a browser WebSocket and waits until it's connected.
+func dialWebSocket(ctx context.Context, url string) (net.Conn, error) {
+	c := &webSocketConn{
+		sock:  js.Global().Get("WebSocket").New(url),
+		addr:  webSocketAddr(url),
+		funcs: make(map[string]js.Func),
+	}
+	c.cond = sync.NewCond(&c.lock)
+	c.sock.Set("binaryType", "arraybuffer")
+
+	opened := make(chan error, 1)
+	c.listen("open", func(event js.Value) {
+		select {
+		case opened <- nil:
+		default:
+		}
+	})
+	c.listen("message", func(event js.Value) {
+		data := js.Global().Get("Uint8Array").New(event.Get("data"))
+		chunk := make([]byte, data.Length())
+		js.CopyBytesToGo(chunk, data)
+
+		c.lock.Lock()
+		c.buf = append(c.buf, chunk...)
+		c.lock.Unlock()
+		c.cond.Broadcast()
+	})
+	c.listen("close", func(event js.Value) {
+		select {
+		case opened <- errors.New("websocket connection refused"):
+		default:
+		}
+		c.fail(io.EOF)
+	})
+	select {
+	case err := <-opened:
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		return c, nil
+	case <-ctx.Done():
+		c.Close()
+		return nil, ctx.Err()
+	}
+}
+
+// Subscribes a callback to a WebSocket event.
+func (c *webSocketConn) listen(event string, callback func(event js.Value)) {
+	fn := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		callback(args[0])
+		return nil
+	})
+	c.funcs[event] = fn
+	c.sock.Call("addEventListener", event, fn)
+}
+
+// Records a terminal failure, waking up any blocked readers.
+func (c *webSocketConn) fail(err error) {
+	c.lock.Lock()
+	if c.err == nil {
+		c.err = err
+	}
+	c.lock.Unlock()
+	c.cond.Broadcast()
+}
+
+// Reads data received from the WebSocket, blocking until some is available.
+func (c *webSocketConn) Read(b []byte) (int, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for len(c.buf) == 0 && c.err == nil {
+		c.cond.Wait()
+	}
+	if len(c.buf) == 0 {
+		return 0, c.err
+	}
+	n := copy(b, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+// Sends data as a single binary WebSocket message.
+func (c *webSocketConn) Write(b []byte) (int, error) {
+	c.lock.Lock()
+	err := c.err
+	c.lock.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	data := js.Global().Get("Uint8Array").New(len(b))
+	js.CopyBytesToJS(data, b)
+	c.sock.Call("send", data)
+	return len(b), nil
+}
+
+// Closes the WebSocket and releases the event callbacks.
+func (c *webSocketConn) Close() error {
+	for event, fn := range c.funcs {
+		c.sock.Call("removeEventListener", event, fn)
+		fn.Release()
+	}
+	c.funcs = nil
+	c.sock.Call("close")
+	c.fail(net.ErrClosed)
+	return nil
+}
+
+func (c *webSocketConn) LocalAddr() net.Addr  { return c.addr }
+func (c *webSocketConn) RemoteAddr() net.Addr { return c.addr }
+
+// Browser WebSockets don't support deadlines, which the binding doesn't rely on
+// either, so they are silently ignored.
+func (c *webSocketConn) SetDeadline(t time.Time) error      { return nil }
+func (c *webSocketConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *webSocketConn) SetWriteDeadline(t time.Time) error { return nil }
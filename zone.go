@@ -0,0 +1,123 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the zone (data center, region) aware routing of requests.
+//
+// The relay balances requests between all members of a cluster, oblivious of
+// where they run. Services tagged with a zone thus open an alias link into a
+// zone scoped cluster (the cluster name suffixed by the zone). Callers in the
+// same zone preferring local members address the zone scoped cluster instead,
+// as long as a probe found it to have members, falling back to the whole
+// cluster otherwise, or if the zone members fail the request.
+//
+// Only the first request to a cluster awaits the probe of its zone; later ones
+// go by the last outcome while a single background probe refreshes it.
+
+package iris
+
+import (
+	"context"
+	"time"
+)
+
+// Separator between a cluster name and a zone in the zone scoped clusters.
+const zoneSeparator = "@"
+
+// Context key marking requests that prefer the members of the caller's zone.
+type zoneKey struct{}
+
+// Time after which the presence of zone members is probed anew.
+var zoneCheckInterval = 10 * time.Second
+
+// Timeout of the probes checking the presence of zone members.
+var zoneProbeTimeout = 100 * time.Millisecond
+
+// Presence of members of a cluster in the caller's zone, as last probed.
+type zoneState struct {
+	local   bool          // Whether the zone scoped cluster had members
+	checked time.Time     // Time of the last probe (zero if none finished yet)
+	probing chan struct{} // Closed when the running probe finishes (nil if none)
+}
+
+// Creates a context marking the requests issued with it to prefer the members
+// of the target cluster in the connection's own zone, falling back to any
+// member if none are found there. Connections without a zone ignore it.
+func WithZonePreference(ctx context.Context) context.Context {
+	return context.WithValue(ctx, zoneKey{}, true)
+}
+
+// Assembles the name of the zone scoped variant of a cluster.
+func zoneCluster(cluster string, zone string) string {
+	return cluster + zoneSeparator + zone
+}
+
+// Resolves the cluster to address a request to, the zone scoped one if the
+// context prefers it and members were found there.
+func (c *Connection) zoneRoute(ctx context.Context, cluster string) string {
+	if c.options.Zone == "" {
+		return cluster
+	}
+	if prefer, _ := ctx.Value(zoneKey{}).(bool); !prefer {
+		return cluster
+	}
+	zoned := zoneCluster(cluster, c.options.Zone)
+
+	// Start a probe unless one is already running or the last is recent enough
+	c.zoneLock.Lock()
+	state, ok := c.zoneLive[cluster]
+	if !ok {
+		state = new(zoneState)
+		c.zoneLive[cluster] = state
+	}
+	if state.probing == nil && (state.checked.IsZero() || since(c.clock, state.checked) >= zoneCheckInterval) {
+		state.probing = make(chan struct{})
+		go c.probeZone(cluster, zoned, state, state.probing)
+	}
+	probing, checked := state.probing, !state.checked.IsZero()
+	c.zoneLock.Unlock()
+
+	// Await the outcome if this is the first probe of the cluster
+	if !checked {
+		select {
+		case <-probing:
+		case <-ctx.Done():
+			return cluster
+		}
+	}
+	c.zoneLock.Lock()
+	local := state.local
+	c.zoneLock.Unlock()
+
+	if local {
+		return zoned
+	}
+	return cluster
+}
+
+// Probes the zone scoped cluster for members, recording the outcome and waking
+// any requests waiting for it.
+func (c *Connection) probeZone(cluster string, zoned string, state *zoneState, done chan struct{}) {
+	local := false
+	if probe, err := c.envelope(zoned, []byte{0x00}, map[string]string{headerProbe: "1"}); err == nil {
+		_, err = c.request(context.Background(), c.Log, zoned, probe, zoneProbeTimeout, int(zoneProbeTimeout.Nanoseconds()/1000000))
+		local = err == nil
+	}
+	c.Log.Debug("probed zone members", "cluster", cluster, "zone", c.options.Zone, "local", local)
+
+	c.zoneLock.Lock()
+	state.local, state.checked, state.probing = local, c.clock.Now(), nil
+	c.zoneLock.Unlock()
+
+	close(done)
+}
+
+// Discards the outcome of the last zone probe of a cluster, e.g. after a local
+// member failed to answer.
+func (c *Connection) forgetZone(cluster string) {
+	c.zoneLock.Lock()
+	delete(c.zoneLive, cluster)
+	c.zoneLock.Unlock()
+}
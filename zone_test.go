@@ -0,0 +1,142 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Service handler replying with the zone it runs in, stalling the requests that
+// arrive before a deadline.
+type zoneTestHandler struct {
+	zone  string
+	stall int64 // Unix nanoseconds until which to stall requests (atomic)
+}
+
+func (h *zoneTestHandler) Init(conn *Connection) error { return nil }
+func (h *zoneTestHandler) HandleBroadcast(msg []byte)  { panic("not implemented") }
+func (h *zoneTestHandler) HandleTunnel(tun *Tunnel)    { panic("not implemented") }
+func (h *zoneTestHandler) HandleDrop(reason error)     { panic("not implemented") }
+
+func (h *zoneTestHandler) HandleRequest(req []byte) ([]byte, error) {
+	if time.Now().UnixNano() < atomic.LoadInt64(&h.stall) {
+		time.Sleep(time.Second)
+	}
+	return []byte(h.zone), nil
+}
+
+// Tests that zone preferring requests are served by members of the same zone if
+// there are any, and by any member otherwise.
+func TestZonePreference(t *testing.T) {
+	// Register a member of the cluster in two zones each
+	for _, zone := range []string{"eu", "us"} {
+		serv, err := RegisterWithOptions(config.relay, config.cluster, &zoneTestHandler{zone: zone}, nil, &Options{Zone: zone})
+		if err != nil {
+			t.Fatalf("registration failed: %v.", err)
+		}
+		defer serv.Unregister()
+	}
+	// Ensure requests preferring a populated zone stay within
+	local, err := ConnectWithOptions(config.relay, &Options{Zone: "eu"})
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer local.Close()
+
+	ctx := WithZonePreference(context.Background())
+	for i := 0; i < 10; i++ {
+		if reply, err := local.RequestContext(ctx, config.cluster, []byte{0x00}, time.Second); err != nil || string(reply) != "eu" {
+			t.Fatalf("request %d: reply mismatch: have %q/%v, want %q/nil.", i, reply, err, "eu")
+		}
+	}
+	// Ensure requests preferring an empty zone fall back to the whole cluster
+	remote, err := ConnectWithOptions(config.relay, &Options{Zone: "ap"})
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer remote.Close()
+
+	for i := 0; i < 10; i++ {
+		if reply, err := remote.RequestContext(ctx, config.cluster, []byte{0x00}, time.Second); err != nil || (string(reply) != "eu" && string(reply) != "us") {
+			t.Fatalf("request %d: reply mismatch: have %q/%v, want eu or us.", i, reply, err)
+		}
+	}
+}
+
+// Tests that concurrent requests share a single zone probe, and that requests
+// failed by the zone members fall back to the whole cluster, timed out ones only
+// if idempotent.
+func TestZoneFallback(t *testing.T) {
+	// Register a member in the caller's zone that can be stalled and one elsewhere
+	local := &zoneTestHandler{zone: "eu"}
+	for _, handler := range []*zoneTestHandler{local, {zone: "us"}} {
+		serv, err := RegisterWithOptions(config.relay, config.cluster, handler, &ServiceLimits{RequestThreads: 32}, &Options{Zone: handler.zone})
+		if err != nil {
+			t.Fatalf("registration failed: %v.", err)
+		}
+		defer serv.Unregister()
+	}
+	// Ensure plain requests timing out in the zone are not sent anew
+	plain, err := ConnectWithOptions(config.relay, &Options{Zone: "eu"})
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer plain.Close()
+
+	ctx := WithZonePreference(context.Background())
+	if _, err := plain.RequestContext(ctx, config.cluster, []byte{0x00}, time.Second); err != nil {
+		t.Fatalf("zone probing request failed: %v.", err)
+	}
+	atomic.StoreInt64(&local.stall, time.Now().Add(200*time.Millisecond).UnixNano())
+
+	start := time.Now()
+	if _, err := plain.RequestContext(ctx, config.cluster, []byte{0x00}, 250*time.Millisecond); err != ErrTimeout {
+		t.Fatalf("plain request error mismatch: have %v, want %v.", err, ErrTimeout)
+	}
+	if elapsed := time.Since(start); elapsed > 400*time.Millisecond {
+		t.Fatalf("plain request overran its timeout: %v.", elapsed)
+	}
+	if sent := plain.Stats().RequestsSent; sent != 3 {
+		t.Fatalf("plain sent requests mismatch: have %d, want %d.", sent, 3)
+	}
+	// Issue a batch of concurrent idempotent requests, each timing out locally first
+	conn, err := ConnectWithOptions(config.relay, &Options{Zone: "eu", Retry: &RetryPolicy{Attempts: 2, Backoff: 10 * time.Millisecond}})
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	atomic.StoreInt64(&local.stall, time.Now().Add(200*time.Millisecond).UnixNano())
+
+	var pend sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		pend.Add(1)
+		go func(i int) {
+			defer pend.Done()
+
+			ctx := WithIdempotencyKey(ctx, fmt.Sprintf("request #%d", i))
+			if _, err := conn.RequestContext(ctx, config.cluster, []byte{0x00}, 250*time.Millisecond); err != nil {
+				errs <- err
+			}
+		}(i)
+	}
+	pend.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("idempotent request failed: %v.", err)
+	}
+	// Ensure the zone was probed once: a probe, ten zone attempts and ten retries
+	if sent := conn.Stats().RequestsSent; sent != 21 {
+		t.Fatalf("sent requests mismatch: have %d, want %d.", sent, 21)
+	}
+}